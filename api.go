@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiRoute is an endpoint of the HTTP API under /api/v1. Besides the handler
+// it carries the documentation used to build the OpenAPI spec and the
+// generated Go client.
+type apiRoute struct {
+	Method string
+	// Path in gin syntax, e.g. /reminders/:id
+	Path string
+	// Scope the caller's token must carry
+	Scope string
+
+	// OperationID names the operation, and the generated client method
+	OperationID string
+	Summary     string
+	Query       []apiParam
+	// Request and Response are zero values of the JSON body types; nil
+	// means no body
+	Request  any
+	Response any
+
+	Handler gin.HandlerFunc
+}
+
+// apiParam documents a query string parameter
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// Every registered API endpoint, mounted at startup
+var apiRoutes []apiRoute
+
+// registerAPIRoute adds an endpoint to /api/v1
+func registerAPIRoute(route apiRoute) {
+	apiRoutes = append(apiRoutes, route)
+}
+
+// mountAPIRoutes attaches every registered endpoint to group
+func mountAPIRoutes(group *gin.RouterGroup) {
+	for _, route := range apiRoutes {
+		group.Handle(route.Method, route.Path, requireAPIScope(route.Scope), route.Handler)
+	}
+}
+
+// requireAPIScope authenticates the bearer token and checks it carries scope
+func requireAPIScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || secret == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			c.Abort()
+			return
+		}
+		token := authenticateAPIToken(secret)
+		if token == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+		if auditorReadable(scope, c.Request.Method) && !slices.Contains(token.Scopes, scope) && slices.Contains(token.Scopes, scopeAuditRead) {
+			if !isAuditor(token.UserID) && !isAdmin(token.UserID) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Token owner is no longer an auditor"})
+				c.Abort()
+				return
+			}
+			recordAdminAudit(token.UserID, roleAuditor, "api", c.Request.Method+" "+c.Request.URL.RequestURI())
+		} else if scope != "" && !slices.Contains(token.Scopes, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token lacks scope " + scope})
+			c.Abort()
+			return
+		}
+		c.Set("apiToken", token)
+		c.Next()
+	}
+}
+
+// auditorReadable reports whether auditors' audit:read tokens may call an
+// endpoint: every admin endpoint that only reads
+func auditorReadable(scope, method string) bool {
+	return scope == scopeAdmin && method == http.MethodGet
+}
+
+// apiCaller returns the token that authenticated the current request
+func apiCaller(c *gin.Context) *apiToken {
+	return c.MustGet("apiToken").(*apiToken)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for personal API tokens, keyed by the token's SHA-256 hash
+const apiTokensBucket = "api_tokens"
+
+// Prefix that makes tokens easy to recognise in logs and secret scanners
+const apiTokenPrefix = "sbt_"
+
+// API token scopes
+const (
+	scopeProfileRead   = "profile:read"
+	scopeRemindersRead = "reminders:read"
+	scopeTimeLogsRead  = "timelogs:read"
+	scopeExportsRead   = "exports:read"
+	// Lets CI pipelines announce deployments
+	scopeDeploymentsWrite = "deployments:write"
+	// Only bot admins can create tokens with the admin scope
+	scopeAdmin = "admin"
+	// Read-only access to admin GET endpoints, for auditors
+	scopeAuditRead = "audit:read"
+)
+
+// Scopes a user can request, in display order
+var apiScopes = []string{scopeProfileRead, scopeRemindersRead, scopeTimeLogsRead, scopeExportsRead, scopeDeploymentsWrite, scopeAdmin, scopeAuditRead}
+
+// apiToken is a personal token as stored; the secret itself is never kept
+type apiToken struct {
+	ID         string    `json:"id"`
+	Hash       string    `json:"hash"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool      `json:"revoked"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "token create",
+		Usage:       "token create <name> [scope,scope...]",
+		Description: "Create a personal API token. Scopes: " + strings.Join(apiScopes, ", "),
+		Handler:     handleTokenCreate,
+	})
+	registerBotCommand(&command{
+		Name:        "token list",
+		Usage:       "token list",
+		Description: "List your API tokens",
+		Handler:     handleTokenList,
+	})
+	registerBotCommand(&command{
+		Name:        "token revoke",
+		Usage:       "token revoke <id>",
+		Description: "Revoke one of your API tokens",
+		Handler:     handleTokenRevoke,
+	})
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/me",
+		Scope:       scopeProfileRead,
+		OperationID: "GetMe",
+		Summary:     "Describe the calling user and token",
+		Response:    apiMeResponse{},
+		Handler:     handleAPIMe,
+	})
+	registerUserDataset(userDataset{Name: "api_tokens", Title: "API tokens", Describe: describeUserAPITokens, Delete: deleteUserAPITokens})
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIToken returns the live token matching secret, or nil
+func authenticateAPIToken(secret string) *apiToken {
+	hash := hashAPIToken(secret)
+	var token apiToken
+	found, err := store.Get(apiTokensBucket, hash, &token)
+	if err != nil {
+		log.Printf("Error reading API token: %v", err)
+		return nil
+	}
+	if !found || token.Revoked {
+		return nil
+	}
+
+	// Recording every use would rewrite the store on each request
+	if time.Since(token.LastUsedAt) > time.Minute {
+		token.LastUsedAt = time.Now().UTC()
+		if err := store.Put(apiTokensBucket, hash, token); err != nil {
+			log.Printf("Error updating API token: %v", err)
+		}
+	}
+	return &token
+}
+
+// userAPITokens returns the tokens owned by userID
+func userAPITokens(userID string) ([]apiToken, error) {
+	tokens, err := storeList[apiToken](store, apiTokensBucket)
+	if err != nil {
+		return nil, err
+	}
+	var owned []apiToken
+	for _, token := range tokens {
+		if token.UserID == userID {
+			owned = append(owned, token)
+		}
+	}
+	slices.SortFunc(owned, func(a, b apiToken) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return owned, nil
+}
+
+func handleTokenCreate(req commandRequest) commandResponse {
+	if len(req.Args) == 0 {
+		return ephemeral("Usage: `%s token create <name> [scope,scope...]`", botCommand)
+	}
+	name := req.Args[0]
+	scopes := []string{scopeProfileRead}
+	if len(req.Args) > 1 {
+		scopes = strings.Split(strings.Join(req.Args[1:], ","), ",")
+		scopes = slices.DeleteFunc(scopes, func(s string) bool { return strings.TrimSpace(s) == "" })
+	}
+	for i, scope := range scopes {
+		scopes[i] = strings.TrimSpace(scope)
+		if !slices.Contains(apiScopes, scopes[i]) {
+			return ephemeral("Unknown scope `%s`. Available scopes: %s", scopes[i], strings.Join(apiScopes, ", "))
+		}
+		if scopes[i] == scopeAdmin && !isAdmin(req.UserID) {
+			return ephemeral("Only bot admins can create tokens with the `admin` scope.")
+		}
+		if scopes[i] == scopeAuditRead && !isAuditor(req.UserID) && !isAdmin(req.UserID) {
+			return ephemeral("Only auditors and bot admins can create tokens with the `audit:read` scope.")
+		}
+	}
+	scopes = slices.Compact(slices.Sorted(slices.Values(scopes)))
+
+	secret := apiTokenPrefix + newInteractionToken() + newInteractionToken()
+	token := apiToken{
+		ID:        newInteractionToken()[:8],
+		Hash:      hashAPIToken(secret),
+		UserID:    req.UserID,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := store.Put(apiTokensBucket, token.Hash, token); err != nil {
+		log.Printf("Error saving API token: %v", err)
+		return ephemeral("Sorry, something went wrong creating your token.")
+	}
+	return ephemeral("Created token *%s* (`%s`) with scopes %s.\nCopy it now, it won't be shown again:\n```%s```",
+		name, token.ID, strings.Join(scopes, ", "), secret)
+}
+
+func handleTokenList(req commandRequest) commandResponse {
+	tokens, err := userAPITokens(req.UserID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		return ephemeral("Sorry, something went wrong listing your tokens.")
+	}
+
+	type tokenRow struct {
+		ID       string    `table:"ID"`
+		Name     string    `table:"Name"`
+		Scopes   []string  `table:"Scopes"`
+		Created  time.Time `table:"Created"`
+		LastUsed time.Time `table:"Last used"`
+		Status   string    `table:"Status"`
+	}
+	var rows []tokenRow
+	for _, token := range tokens {
+		status := "active"
+		if token.Revoked {
+			status = "revoked"
+		}
+		rows = append(rows, tokenRow{token.ID, token.Name, token.Scopes, token.CreatedAt, token.LastUsedAt, status})
+	}
+	if len(rows) == 0 {
+		return ephemeral("You don't have any API tokens. Create one with `%s token create <name>`.", botCommand)
+	}
+	table, err := renderTable(rows, tableOptions{})
+	if err != nil {
+		log.Printf("Error rendering API tokens: %v", err)
+		return ephemeral("Sorry, something went wrong listing your tokens.")
+	}
+	text := "*Your API tokens*\n" + table
+	return commandResponse{
+		Text:   text,
+		Blocks: []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)},
+	}
+}
+
+func handleTokenRevoke(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s token revoke <id>`", botCommand)
+	}
+	tokens, err := userAPITokens(req.UserID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		return ephemeral("Sorry, something went wrong revoking your token.")
+	}
+	for _, token := range tokens {
+		if token.ID != req.Args[0] {
+			continue
+		}
+		token.Revoked = true
+		if err := store.Put(apiTokensBucket, token.Hash, token); err != nil {
+			log.Printf("Error revoking API token: %v", err)
+			return ephemeral("Sorry, something went wrong revoking your token.")
+		}
+		return ephemeral("Revoked token *%s* (`%s`).", token.Name, token.ID)
+	}
+	return ephemeral("You don't have a token with ID `%s`.", req.Args[0])
+}
+
+// apiMeResponse is returned by GET /me
+type apiMeResponse struct {
+	UserID  string   `json:"user_id"`
+	TokenID string   `json:"token_id"`
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+}
+
+// handleAPIMe describes the calling user and token
+func handleAPIMe(c *gin.Context) {
+	token := apiCaller(c)
+	c.JSON(http.StatusOK, apiMeResponse{
+		UserID:  token.UserID,
+		TokenID: token.ID,
+		Name:    token.Name,
+		Scopes:  token.Scopes,
+	})
+}
+
+func describeUserAPITokens(userID string) (string, error) {
+	tokens, err := userAPITokens(userID)
+	if err != nil {
+		return "", err
+	}
+	items := make([]string, len(tokens))
+	for i, token := range tokens {
+		items[i] = fmt.Sprintf("%s (`%s`)", token.Name, token.ID)
+		if token.Revoked {
+			items[i] += ", revoked"
+		}
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserAPITokens deletes userID's tokens, which also revokes them
+func deleteUserAPITokens(userID string) error {
+	for _, hash := range store.Keys(apiTokensBucket) {
+		var token apiToken
+		if _, err := store.Get(apiTokensBucket, hash, &token); err != nil {
+			return err
+		}
+		if token.UserID != userID {
+			continue
+		}
+		if err := store.Delete(apiTokensBucket, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Change actions reported by POST /apply
+const (
+	applyCreate = "create"
+	applyUpdate = "update"
+	applyDelete = "delete"
+)
+
+// applyKind is a section of the declarative config document, reconciled
+// against one store bucket
+type applyKind struct {
+	// Name is the document key, e.g. "triggers"
+	Name   string
+	Bucket string
+	// Decode validates one document item and returns its store key and value
+	Decode func(raw json.RawMessage) (key string, value any, err error)
+}
+
+// apiApplyRequest is a declarative config document. Every kind present is
+// made to match exactly, deleting stored items that aren't listed; kinds
+// left out are not touched.
+type apiApplyRequest struct {
+	Kinds map[string][]json.RawMessage `json:"kinds"`
+	// DryRun reports the changes without making them
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// apiApplyResponse lists the changes an apply made, or would make
+type apiApplyResponse struct {
+	DryRun    bool             `json:"dry_run"`
+	Changes   []apiApplyChange `json:"changes"`
+	Unchanged int              `json:"unchanged"`
+}
+
+// apiApplyChange is one created, updated or deleted item, with what it
+// holds before and after so a dry run reads as a plan
+type apiApplyChange struct {
+	Kind   string          `json:"kind"`
+	Name   string          `json:"name"`
+	Action string          `json:"action"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+var (
+	// Reconcilable document sections, keyed by name
+	applyKinds = map[string]applyKind{}
+	// Serializes applies so two pipelines can't interleave their writes
+	applyMu sync.Mutex
+)
+
+// registerApplyKind makes a store bucket manageable through POST /apply
+func registerApplyKind(kind applyKind) {
+	applyKinds[kind.Name] = kind
+}
+
+func init() {
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodPost,
+		Path:        "/apply",
+		Scope:       scopeAdmin,
+		OperationID: "Apply",
+		Summary:     "Reconcile bot configuration with a declarative document",
+		Request:     apiApplyRequest{},
+		Response:    apiApplyResponse{},
+		Handler:     handleAPIApply,
+	})
+}
+
+func handleAPIApply(c *gin.Context) {
+	var req apiApplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config document: " + err.Error()})
+		return
+	}
+
+	// Validate the whole document before changing anything
+	desired := map[string]map[string]json.RawMessage{}
+	for name, items := range req.Kinds {
+		kind, ok := applyKinds[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown kind %q", name)})
+			return
+		}
+		desired[name] = map[string]json.RawMessage{}
+		for i, item := range items {
+			key, value, err := kind.Decode(item)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s[%d]: %v", name, i, err)})
+				return
+			}
+			if _, dup := desired[name][key]; dup {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s[%d]: duplicate name %q", name, i, key)})
+				return
+			}
+			raw, err := json.Marshal(value)
+			if err != nil {
+				log.Printf("Error encoding %s %s: %v", name, key, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+			desired[name][key] = raw
+		}
+	}
+
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	resp := apiApplyResponse{DryRun: req.DryRun, Changes: []apiApplyChange{}}
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		changes, unchanged, err := reconcileApplyKind(applyKinds[name], desired[name], req.DryRun)
+		resp.Changes = append(resp.Changes, changes...)
+		resp.Unchanged += unchanged
+		if err != nil {
+			log.Printf("Error applying %s: %v", name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Applying " + name + " failed part way; re-run the apply"})
+			return
+		}
+	}
+	log.Printf("Config apply by %s: %d changes (dry run %t)", apiCaller(c).UserID, len(resp.Changes), req.DryRun)
+	if !req.DryRun && len(resp.Changes) > 0 {
+		recordAdminAudit(apiCaller(c).UserID, roleAdmin, "apply", fmt.Sprintf("%d changes", len(resp.Changes)))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// reconcileApplyKind makes kind's bucket hold exactly the desired items
+func reconcileApplyKind(kind applyKind, desired map[string]json.RawMessage, dryRun bool) ([]apiApplyChange, int, error) {
+	var changes []apiApplyChange
+	unchanged := 0
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var current json.RawMessage
+		found, err := store.Get(kind.Bucket, key, &current)
+		if err != nil {
+			return changes, unchanged, err
+		}
+		action := applyCreate
+		if found {
+			if bytes.Equal(current, desired[key]) {
+				unchanged++
+				continue
+			}
+			action = applyUpdate
+		}
+		if !dryRun {
+			if err := store.Put(kind.Bucket, key, desired[key]); err != nil {
+				return changes, unchanged, err
+			}
+		}
+		change := apiApplyChange{Kind: kind.Name, Name: key, Action: action, After: desired[key]}
+		if found {
+			change.Before = current
+		}
+		changes = append(changes, change)
+	}
+
+	for _, key := range store.Keys(kind.Bucket) {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		var current json.RawMessage
+		if _, err := store.Get(kind.Bucket, key, &current); err != nil {
+			return changes, unchanged, err
+		}
+		if !dryRun {
+			if err := store.Delete(kind.Bucket, key); err != nil {
+				return changes, unchanged, err
+			}
+		}
+		changes = append(changes, apiApplyChange{Kind: kind.Name, Name: key, Action: applyDelete, Before: current})
+	}
+	return changes, unchanged, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for approval requests, keyed by approval ID
+const approvalsBucket = "approvals"
+
+// Action IDs of the approval buttons
+const (
+	actionApprovalApprove = "approval_approve"
+	actionApprovalDeny    = "approval_deny"
+)
+
+// Matches a bare user ID, like U012AB3CD
+var userIDPattern = regexp.MustCompile(`^[UW][A-Z0-9]{6,}$`)
+
+// How long an approval waits for its decision when the request doesn't say
+const defaultApprovalTTL = 24 * time.Hour
+
+const (
+	approvalPending  = "pending"
+	approvalApproved = "approved"
+	approvalDenied   = "denied"
+	approvalExpired  = "expired"
+)
+
+// approvalHandler is called with an approval once it's approved, denied or
+// expired
+type approvalHandler func(a *approval)
+
+// Handlers for decided approvals, keyed by the kind the feature asking
+// registered. Approvals only store their kind, so a decision made after a
+// restart still reaches the feature.
+var approvalHandlers = map[string]approvalHandler{}
+
+// registerApprovalHandler routes decisions on approvals of kind to handler
+func registerApprovalHandler(kind string, handler approvalHandler) {
+	approvalHandlers[kind] = handler
+}
+
+// approvalContext says what an approval is for and where to ask for it
+type approvalContext struct {
+	// Kind picks the handler registered with registerApprovalHandler
+	Kind string
+	// Title is what's being asked, like "Deploy checkout 1.4 to production"
+	Title string
+	// Details are shown under the title, in mrkdwn
+	Details     string
+	RequestedBy string
+	// Channel gets the buttons; when empty each approver gets them in a DM
+	Channel string
+	// Required is how many approvals it needs; default 1. One denial
+	// decides it.
+	Required int
+	// TTL is how long it waits before expiring; default 24h
+	TTL time.Duration
+}
+
+// approvalResponse is one approver's click
+type approvalResponse struct {
+	User     string    `json:"user"`
+	Approved bool      `json:"approved"`
+	At       time.Time `json:"at"`
+}
+
+// approvalMessage is a message with an approval's buttons
+type approvalMessage struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// approval is a request for some people to approve something
+type approval struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Details     string `json:"details,omitempty"`
+	RequestedBy string `json:"requested_by"`
+	// Approvers are the user IDs allowed to click
+	Approvers []string `json:"approvers"`
+	Required  int      `json:"required"`
+	// Payload is whatever the feature asking needs to act on the decision
+	Payload   json.RawMessage    `json:"payload,omitempty"`
+	Status    string             `json:"status"`
+	Responses []approvalResponse `json:"responses,omitempty"`
+	Messages  []approvalMessage  `json:"messages,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at"`
+	DecidedAt time.Time          `json:"decided_at,omitempty"`
+}
+
+// Serializes responses, which read and rewrite the whole approval
+var approvalsMu sync.Mutex
+
+func init() {
+	registerBlockAction(actionApprovalApprove, handleApprovalClick)
+	registerBlockAction(actionApprovalDeny, handleApprovalClick)
+}
+
+// startApprovals expires approvals nobody decided in time
+func startApprovals() {
+	runEvery("approval expiry", time.Minute, expireApprovals)
+}
+
+// requestApproval asks approvers, user IDs or usergroup handles, to approve
+// what ctx describes. payload is stored with the approval and handed back
+// to the handler for ctx.Kind with the decision.
+func requestApproval(ctx approvalContext, approvers []string, payload any) (*approval, error) {
+	if _, ok := approvalHandlers[ctx.Kind]; !ok {
+		return nil, fmt.Errorf("no approval handler for %q", ctx.Kind)
+	}
+	users, err := resolveApprovers(approvers)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New("approval needs at least one approver")
+	}
+	a := &approval{
+		ID:          newInteractionToken(),
+		Kind:        ctx.Kind,
+		Title:       ctx.Title,
+		Details:     ctx.Details,
+		RequestedBy: ctx.RequestedBy,
+		Approvers:   users,
+		Required:    max(ctx.Required, 1),
+		Status:      approvalPending,
+		CreatedAt:   time.Now().UTC(),
+	}
+	// Requesters can't approve their own requests
+	if eligible := len(slices.DeleteFunc(slices.Clone(users), func(u string) bool { return u == ctx.RequestedBy })); a.Required > eligible {
+		return nil, fmt.Errorf("approval needs %d approvals but has only %d other approvers", a.Required, eligible)
+	}
+	ttl := ctx.TTL
+	if ttl <= 0 {
+		ttl = defaultApprovalTTL
+	}
+	a.ExpiresAt = a.CreatedAt.Add(ttl)
+	if payload != nil {
+		if a.Payload, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("encoding approval payload: %w", err)
+		}
+	}
+
+	// Saved before the buttons are posted, so an early click finds it
+	if err := store.Put(approvalsBucket, a.ID, a); err != nil {
+		return nil, fmt.Errorf("saving approval: %w", err)
+	}
+
+	channels := []string{ctx.Channel}
+	if ctx.Channel == "" {
+		channels = nil
+		for _, user := range users {
+			dm, err := openDM(user)
+			if err != nil {
+				log.Printf("Error asking %s for approval: %v", user, err)
+				continue
+			}
+			channels = append(channels, dm)
+		}
+	}
+	var messages []approvalMessage
+	for _, channel := range channels {
+		ts, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceNotice, NeedTS: true, Text: approvalText(a), Blocks: approvalBlocks(a)})
+		if err != nil {
+			log.Printf("Error posting approval request: %v", err)
+			continue
+		}
+		messages = append(messages, approvalMessage{Channel: channel, TS: ts})
+	}
+
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	if len(messages) == 0 {
+		if err := store.Delete(approvalsBucket, a.ID); err != nil {
+			log.Printf("Error removing approval: %v", err)
+		}
+		return nil, errors.New("couldn't post the approval request anywhere")
+	}
+	found, err := store.Get(approvalsBucket, a.ID, a)
+	if err == nil && found {
+		a.Messages = messages
+		err = store.Put(approvalsBucket, a.ID, a)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("saving approval: %w", err)
+	}
+	// Someone may have decided it before the last copy was posted
+	if a.Status != approvalPending {
+		updateApprovalMessages(a)
+	}
+	return a, nil
+}
+
+// decodePayload unmarshals the payload the approval was requested with
+func (a *approval) decodePayload(v any) error {
+	if len(a.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(a.Payload, v)
+}
+
+// resolveApprovers turns usergroup handles into their members
+func resolveApprovers(approvers []string) ([]string, error) {
+	var users []string
+	for _, approver := range approvers {
+		if m := userMentionPattern.FindStringSubmatch(approver); m != nil {
+			users = append(users, m[1])
+			continue
+		}
+		if userIDPattern.MatchString(approver) {
+			users = append(users, approver)
+			continue
+		}
+		group, err := findUsergroup(approver)
+		if err != nil {
+			return nil, fmt.Errorf("looking up approvers %s: %w", approver, err)
+		}
+		if group == nil {
+			return nil, fmt.Errorf("no user or usergroup %s", approver)
+		}
+		users = append(users, group.Users...)
+	}
+	return slices.Compact(slices.Sorted(slices.Values(users))), nil
+}
+
+// handleApprovalClick records an approver's decision and, once it's
+// decided, updates every copy of the request and tells the feature
+func handleApprovalClick(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	user := callback.User.ID
+	approve := action.ActionID == actionApprovalApprove
+
+	approvalsMu.Lock()
+	a := &approval{}
+	found, err := store.Get(approvalsBucket, action.Value, a)
+	var problem string
+	switch {
+	case err != nil:
+	case !found:
+		problem = "I couldn't find this request any more."
+	case a.Status != approvalPending:
+		problem = "This request has already been " + a.Status + "."
+	case !slices.Contains(a.Approvers, user):
+		problem = "You're not one of the approvers for this request."
+	case approve && user == a.RequestedBy:
+		problem = "You can't approve your own request."
+	case slices.ContainsFunc(a.Responses, func(r approvalResponse) bool { return r.User == user }):
+		problem = "You've already responded to this request."
+	default:
+		a.Responses = append(a.Responses, approvalResponse{User: user, Approved: approve, At: time.Now().UTC()})
+		a.Status = a.decision()
+		if a.Status != approvalPending {
+			a.DecidedAt = time.Now().UTC()
+		}
+		err = store.Put(approvalsBucket, a.ID, a)
+	}
+	approvalsMu.Unlock()
+	if err != nil {
+		log.Printf("Error recording approval response: %v", err)
+		pollReply(callback, "Sorry, something went wrong recording that.")
+		return
+	}
+	if problem != "" {
+		pollReply(callback, problem)
+		return
+	}
+
+	updateApprovalMessages(a)
+	if a.Status != approvalPending {
+		finishApproval(a)
+	}
+}
+
+// decision works out the status from the responses so far
+func (a *approval) decision() string {
+	approvals := 0
+	for _, r := range a.Responses {
+		if !r.Approved {
+			return approvalDenied
+		}
+		approvals++
+	}
+	if approvals >= a.Required {
+		return approvalApproved
+	}
+	return approvalPending
+}
+
+// expireApprovals gives up on approvals whose time ran out
+func expireApprovals() {
+	approvals, err := storeList[approval](store, approvalsBucket)
+	if err != nil {
+		log.Printf("Error listing approvals: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, listed := range approvals {
+		if listed.Status != approvalPending || now.Before(listed.ExpiresAt) {
+			continue
+		}
+		approvalsMu.Lock()
+		a := &approval{}
+		found, err := store.Get(approvalsBucket, listed.ID, a)
+		expired := err == nil && found && a.Status == approvalPending
+		if expired {
+			a.Status, a.DecidedAt = approvalExpired, now.UTC()
+			err = store.Put(approvalsBucket, a.ID, a)
+		}
+		approvalsMu.Unlock()
+		if err != nil {
+			log.Printf("Error expiring approval %s: %v", listed.ID, err)
+			continue
+		}
+		if expired {
+			updateApprovalMessages(a)
+			finishApproval(a)
+		}
+	}
+}
+
+// finishApproval hands the decided approval to its feature's handler
+func finishApproval(a *approval) {
+	handler, ok := approvalHandlers[a.Kind]
+	if !ok {
+		log.Printf("Error finishing approval %s: no handler for %q", a.ID, a.Kind)
+		return
+	}
+	go runJob("approval "+a.Kind, func() { handler(a) })
+}
+
+func updateApprovalMessages(a *approval) {
+	text := approvalText(a)
+	for _, m := range a.Messages {
+		_, _, _, err := slackClient.UpdateMessage(m.Channel, m.TS, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(approvalBlocks(a)...))
+		if err != nil {
+			log.Printf("Error updating approval request in %s: %v", m.Channel, err)
+		}
+	}
+}
+
+func approvalText(a *approval) string {
+	switch a.Status {
+	case approvalApproved:
+		return ":white_check_mark: Approved: " + a.Title
+	case approvalDenied:
+		return ":no_entry: Denied: " + a.Title
+	case approvalExpired:
+		return ":hourglass: Expired without a decision: " + a.Title
+	}
+	return ":raised_hand: Approval needed: " + a.Title
+}
+
+// approvalBlocks shows the request, the responses so far and, while it's
+// pending, the buttons
+func approvalBlocks(a *approval) []slack.Block {
+	header := fmt.Sprintf("*%s*\nRequested by <@%s>", approvalText(a), a.RequestedBy)
+	if a.Details != "" {
+		header += "\n" + a.Details
+	}
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, header, false, false), nil, nil)}
+
+	var lines []string
+	for _, r := range a.Responses {
+		verb := "approved"
+		if !r.Approved {
+			verb = "denied"
+		}
+		lines = append(lines, fmt.Sprintf("<@%s> %s %s", r.User, verb, slackDate(r.At)))
+	}
+	if a.Status == approvalPending {
+		approvers := make([]string, len(a.Approvers))
+		for i, user := range a.Approvers {
+			approvers[i] = "<@" + user + ">"
+		}
+		lines = append(lines, fmt.Sprintf("Needs %d of %s. Expires %s.", a.Required, strings.Join(approvers, ", "), slackDate(a.ExpiresAt)))
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, strings.Join(lines, "\n"), false, false)))
+
+	if a.Status == approvalPending {
+		blocks = append(blocks, slack.NewActionBlock("",
+			slack.NewButtonBlockElement(actionApprovalApprove, a.ID, slack.NewTextBlockObject(slack.PlainTextType, "Approve", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(actionApprovalDeny, a.ID, slack.NewTextBlockObject(slack.PlainTextType, "Deny", false, false)).WithStyle(slack.StyleDanger),
+		))
+	}
+	return blocks
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Posted in a protected channel after the bot undoes its archiving, when
+// archives.policy_message is unset
+const defaultArchivePolicyMessage = "This channel is protected and can't be archived. Ask in the governance channel if it should be retired."
+
+// handleChannelArchive reports an archived public channel
+func handleChannelArchive(ev *slackevents.ChannelArchiveEvent) {
+	channelArchived(ev.Channel, ev.User)
+}
+
+// handleChannelUnarchive reports an unarchived public channel
+func handleChannelUnarchive(ev *slackevents.ChannelUnarchiveEvent) {
+	channelUnarchived(ev.Channel, ev.User)
+}
+
+// handleGroupArchive reports an archived private channel. Slack doesn't say
+// who archived those.
+func handleGroupArchive(ev *slackevents.GroupArchiveEvent) {
+	channelArchived(ev.Channel, "")
+}
+
+// handleGroupUnarchive reports an unarchived private channel
+func handleGroupUnarchive(ev *slackevents.GroupUnarchiveEvent) {
+	channelUnarchived(ev.Channel, "")
+}
+
+// channelArchived tells the channel's owners and the governance channel
+// about an archived channel, and unarchives protected channels
+func channelArchived(channel, userID string) {
+	cfg := appConfig.Archives
+	if slices.Contains(cfg.Protected, channel) {
+		restoreProtectedChannel(channel, userID)
+		return
+	}
+	text := fmt.Sprintf(":file_cabinet: %s archived <#%s>", actorMention(userID), channel)
+	notifyChannelOwners(channel, userID, text)
+	postGovernanceNotice(text)
+}
+
+// channelUnarchived tells the owners and governance channel that a channel
+// is back
+func channelUnarchived(channel, userID string) {
+	// The bot's own unarchiving of protected channels is reported already
+	if userID == botUserID {
+		return
+	}
+	text := fmt.Sprintf(":open_file_folder: %s unarchived <#%s>", actorMention(userID), channel)
+	notifyChannelOwners(channel, userID, text)
+	postGovernanceNotice(text)
+}
+
+// actorMention mentions userID, or says "Someone" when Slack didn't say who
+func actorMention(userID string) string {
+	if userID == "" {
+		return "Someone"
+	}
+	return "<@" + userID + ">"
+}
+
+// restoreProtectedChannel unarchives a protected channel and explains why
+func restoreProtectedChannel(channel, userID string) {
+	actor := actorMention(userID)
+	if err := slackClient.UnArchiveConversation(channel); err != nil {
+		log.Printf("Error unarchiving protected channel %s: %v", channel, err)
+		postGovernanceNotice(fmt.Sprintf(":warning: %s archived the protected channel <#%s> and I couldn't unarchive it: %v", actor, channel, err))
+		return
+	}
+	policy := appConfig.Archives.PolicyMessage
+	if policy == "" {
+		policy = defaultArchivePolicyMessage
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceWarning, Text: fmt.Sprintf(":shield: %s archived this channel, so I've unarchived it. %s", actor, policy)}); err != nil {
+		log.Printf("Error posting archive policy notice: %v", err)
+	}
+	postGovernanceNotice(fmt.Sprintf(":shield: %s tried to archive the protected channel <#%s>; I've unarchived it", actor, channel))
+}
+
+// channelOwners returns the channel's configured owners, or its creator
+func channelOwners(channel string) ([]string, error) {
+	if owners := appConfig.Archives.Owners[channel]; len(owners) > 0 {
+		return owners, nil
+	}
+	info, err := slackClient.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel})
+	if err != nil {
+		return nil, fmt.Errorf("looking up channel %s: %w", channel, err)
+	}
+	if info.Creator == "" {
+		return nil, nil
+	}
+	return []string{info.Creator}, nil
+}
+
+// notifyChannelOwners DMs the channel's owners, skipping whoever made the
+// change
+func notifyChannelOwners(channel, actorID, text string) {
+	owners, err := channelOwners(channel)
+	if err != nil {
+		log.Printf("Error finding channel owners: %v", err)
+		return
+	}
+	for _, owner := range owners {
+		if owner == actorID || owner == botUserID {
+			continue
+		}
+		if _, err := sendDM("archives", owner, outboundMessage{Text: text + ", a channel you own"}); err != nil {
+			log.Printf("Error notifying channel owner %s: %v", owner, err)
+		}
+	}
+}
+
+func postGovernanceNotice(text string) {
+	channel := appConfig.Archives.GovernanceChannel
+	if channel == "" {
+		return
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceNotice, Text: text}); err != nil {
+		log.Printf("Error posting to governance channel: %v", err)
+	}
+}
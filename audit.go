@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for message edit/delete records
+const messageAuditBucket = "message_audit"
+
+// Message audit actions
+const (
+	auditEdited  = "edited"
+	auditDeleted = "deleted"
+)
+
+// Audit history is shown a few entries at a time, each text cut to
+// auditQuoteLimit characters so a page fits in one section block
+const (
+	auditPageSize   = 5
+	auditQuoteLimit = 250
+)
+
+// messageAuditEntry records one edit or deletion of a message
+type messageAuditEntry struct {
+	Channel      string    `json:"channel"`
+	TS           string    `json:"ts"`
+	User         string    `json:"user"`
+	Action       string    `json:"action"`
+	OriginalText string    `json:"original_text"`
+	NewText      string    `json:"new_text,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// Matches message permalinks like https://acme.slack.com/archives/C123/p1700000000000100
+var permalinkPattern = regexp.MustCompile(`/archives/([A-Z0-9]+)/p(\d{10})(\d{6})`)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin audit",
+		Usage:       "admin audit <message link>",
+		Description: "Show the edit and delete history of a message in an audited channel",
+		AdminOnly:   true,
+		Handler:     handleAuditCommand,
+	})
+	registerUserDataset(userDataset{Name: "message_audit", Title: "Audited edits and deletions", Describe: describeUserAuditEntries})
+}
+
+// isAuditedChannel reports whether edits and deletions in channel are recorded
+func isAuditedChannel(channel string) bool {
+	return appConfig.Audit.AllChannels || slices.Contains(appConfig.Audit.Channels, channel)
+}
+
+// handleMessageAudit records message_changed and message_deleted events
+func handleMessageAudit(ev *slackevents.MessageEvent) {
+	if !isAuditedChannel(ev.Channel) {
+		return
+	}
+
+	entry := messageAuditEntry{Channel: ev.Channel, At: time.Now().UTC()}
+	switch ev.SubType {
+	case "message_changed":
+		if ev.Message == nil || ev.PreviousMessage == nil || ev.Message.Text == ev.PreviousMessage.Text {
+			// Unfurls and other attachment changes also arrive as edits
+			return
+		}
+		entry.Action = auditEdited
+		entry.TS = ev.Message.Timestamp
+		entry.User = ev.Message.User
+		entry.OriginalText = ev.PreviousMessage.Text
+		entry.NewText = ev.Message.Text
+	case "message_deleted":
+		if ev.PreviousMessage == nil {
+			return
+		}
+		entry.Action = auditDeleted
+		entry.TS = ev.DeletedTimeStamp
+		entry.User = ev.PreviousMessage.User
+		entry.OriginalText = ev.PreviousMessage.Text
+	default:
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", entry.Channel, entry.TS, ev.EventTimeStamp)
+	if err := store.Put(messageAuditBucket, key, entry); err != nil {
+		log.Printf("Error recording message audit entry: %v", err)
+	}
+}
+
+// handleAuditCommand shows the recorded history of a message
+func handleAuditCommand(req commandRequest) commandResponse {
+	if len(req.Args) == 0 {
+		return ephemeral("Usage: `%s admin audit <message link>`", botCommand)
+	}
+	match := permalinkPattern.FindStringSubmatch(req.Args[0])
+	if match == nil {
+		return ephemeral("That doesn't look like a message link. Use *Copy link* on the message.")
+	}
+	channel, ts := match[1], match[2]+"."+match[3]
+	if !isAuditedChannel(channel) {
+		return ephemeral("<#%s> is not an audited channel.", channel)
+	}
+
+	prefix := channel + "/" + ts + "/"
+	var lines []string
+	for _, key := range store.Keys(messageAuditBucket) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var entry messageAuditEntry
+		if _, err := store.Get(messageAuditBucket, key, &entry); err != nil {
+			log.Printf("Error reading message audit entry: %v", err)
+			continue
+		}
+		line := fmt.Sprintf("• %s by <@%s> at %s\n>%s", entry.Action, entry.User,
+			entry.At.Format(time.RFC1123), quoteAuditText(entry.OriginalText))
+		if entry.Action == auditEdited {
+			line += "\nchanged to\n>" + quoteAuditText(entry.NewText)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ephemeral("No edits or deletions recorded for that message.")
+	}
+
+	return pagedResponse("Audit history", lines, auditPageSize)
+}
+
+// quoteAuditText quotes a shortened copy of a message's text, so a page of
+// history stays within one section block
+func quoteAuditText(text string) string {
+	text, _ = truncateText(text, auditQuoteLimit)
+	return quoteText(text)
+}
+
+// quoteText keeps multi-line text inside a mrkdwn block quote
+func quoteText(text string) string {
+	if text == "" {
+		return "_(empty)_"
+	}
+	return strings.ReplaceAll(text, "\n", "\n>")
+}
+
+func describeUserAuditEntries(userID string) (string, error) {
+	entries, err := storeList[messageAuditEntry](store, messageAuditBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, entry := range entries {
+		if entry.User == userID {
+			items = append(items, fmt.Sprintf("Message %s in <#%s> on %s", entry.Action, entry.Channel, entry.At.Format("2 Jan 2006")))
+		}
+	}
+	return describeItems(items), nil
+}
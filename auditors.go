@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Store bucket for the admin audit log: changes made by admins and
+	// everything auditors look at, keyed by time so keys sort by age
+	adminAuditBucket = "admin_audit"
+	// Store bucket holding the recent delivery history under one key
+	deliveriesBucket = "deliveries"
+	deliveriesKey    = "recent"
+)
+
+// How many bot messages the delivery history keeps
+const maxDeliveries = 500
+
+// Who made an admin audit log entry
+const (
+	roleAdmin   = "admin"
+	roleAuditor = "auditor"
+)
+
+// adminAuditEntry is one line of the admin audit log
+type adminAuditEntry struct {
+	At     time.Time `json:"at" table:"When"`
+	UserID string    `json:"user_id" table:"User"`
+	Role   string    `json:"role" table:"Role"`
+	// Action is where it happened: command, web, api or apply
+	Action string `json:"action" table:"Action"`
+	Detail string `json:"detail" table:"Detail"`
+}
+
+// deliveryRecord is what happened to one message the bot sent
+type deliveryRecord struct {
+	At         time.Time  `json:"at"`
+	Channel    string     `json:"channel"`
+	ThreadTS   string     `json:"thread_ts,omitempty"`
+	TS         string     `json:"ts,omitempty"`
+	Importance importance `json:"importance,omitempty"`
+	// Route is post, or the routing policy's route when it didn't post
+	Route string `json:"route"`
+	// Text is the start of the message
+	Text  string `json:"text"`
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	// Deliveries not yet written to the store, oldest first
+	pendingDeliveries   []deliveryRecord
+	pendingDeliveriesMu sync.Mutex
+	// Held while the stored history is read and rewritten
+	deliveriesStoreMu sync.Mutex
+)
+
+func init() {
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/admin/audit-log",
+		Scope:       scopeAdmin,
+		OperationID: "ListAdminAuditLog",
+		Summary:     "List admin changes and auditor access, newest first",
+		Query: []apiParam{
+			{Name: "user", Description: "Only entries by this user ID"},
+			{Name: "limit", Description: "How many entries to return (default 100)"},
+		},
+		Response: []adminAuditEntry{},
+		Handler:  handleAPIAdminAuditLog,
+	})
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/admin/deliveries",
+		Scope:       scopeAdmin,
+		OperationID: "ListDeliveries",
+		Summary:     "List the bot's recent messages and what routing did with them, newest first",
+		Query:       []apiParam{{Name: "channel", Description: "Only messages to this channel ID"}},
+		Response:    []deliveryRecord{},
+		Handler:     handleAPIDeliveries,
+	})
+	registerUserDataset(userDataset{Name: "admin_audit", Title: "Admin and auditor activity", Describe: describeUserAdminAudit})
+}
+
+// isAuditor reports whether userID may look at, but not change, the bot's
+// settings and logs
+func isAuditor(userID string) bool {
+	return slices.Contains(appConfig.Auditors, userID)
+}
+
+// startAuditLog writes the delivery history to the store every minute
+func startAuditLog() {
+	runEvery("deliveries", time.Minute, flushDeliveries)
+}
+
+// recordAdminAudit adds an entry to the admin audit log
+func recordAdminAudit(userID, role, action, detail string) {
+	entry := adminAuditEntry{At: time.Now().UTC(), UserID: userID, Role: role, Action: action, Detail: detail}
+	key := entry.At.Format("20060102T150405.000000000") + "-" + newInteractionToken()[:8]
+	if err := store.Put(adminAuditBucket, key, entry); err != nil {
+		log.Printf("Error recording admin audit entry: %v", err)
+	}
+}
+
+// adminAuditLog returns the audit log newest first, optionally only the
+// entries by userID
+func adminAuditLog(userID string, limit int) ([]adminAuditEntry, error) {
+	keys := store.Keys(adminAuditBucket)
+	slices.Sort(keys)
+	var entries []adminAuditEntry
+	for i := len(keys) - 1; i >= 0 && len(entries) < limit; i-- {
+		var entry adminAuditEntry
+		if _, err := store.Get(adminAuditBucket, keys[i], &entry); err != nil {
+			return nil, err
+		}
+		if userID == "" || entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// recordDelivery adds a bot message to the delivery history
+func recordDelivery(msg outboundMessage, route, ts string, err error) {
+	text, _ := truncateText(msg.Text, 200)
+	record := deliveryRecord{
+		At:         time.Now().UTC(),
+		Channel:    msg.Channel,
+		ThreadTS:   msg.ThreadTS,
+		TS:         ts,
+		Importance: msg.Importance,
+		Route:      route,
+		Text:       text,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	pendingDeliveriesMu.Lock()
+	pendingDeliveries = append(pendingDeliveries, record)
+	if len(pendingDeliveries) > maxDeliveries {
+		pendingDeliveries = pendingDeliveries[len(pendingDeliveries)-maxDeliveries:]
+	}
+	pendingDeliveriesMu.Unlock()
+}
+
+// flushDeliveries appends pending deliveries to the stored history
+func flushDeliveries() {
+	deliveriesStoreMu.Lock()
+	defer deliveriesStoreMu.Unlock()
+	pendingDeliveriesMu.Lock()
+	pending := pendingDeliveries
+	pendingDeliveries = nil
+	pendingDeliveriesMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	var history []deliveryRecord
+	if _, err := store.Get(deliveriesBucket, deliveriesKey, &history); err != nil {
+		log.Printf("Error loading delivery history: %v", err)
+		return
+	}
+	history = append(history, pending...)
+	if len(history) > maxDeliveries {
+		history = history[len(history)-maxDeliveries:]
+	}
+	if err := store.Put(deliveriesBucket, deliveriesKey, history); err != nil {
+		log.Printf("Error saving delivery history: %v", err)
+	}
+}
+
+// recentDeliveries returns the delivery history newest first, optionally
+// only the messages to channel
+func recentDeliveries(channel string) ([]deliveryRecord, error) {
+	deliveriesStoreMu.Lock()
+	var history []deliveryRecord
+	_, err := store.Get(deliveriesBucket, deliveriesKey, &history)
+	deliveriesStoreMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	pendingDeliveriesMu.Lock()
+	history = append(history, pendingDeliveries...)
+	pendingDeliveriesMu.Unlock()
+
+	var records []deliveryRecord
+	for i := len(history) - 1; i >= 0 && len(records) < maxDeliveries; i-- {
+		if channel == "" || history[i].Channel == channel {
+			records = append(records, history[i])
+		}
+	}
+	return records, nil
+}
+
+func handleAPIAdminAuditLog(c *gin.Context) {
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+	entries, err := adminAuditLog(c.Query("user"), limit)
+	if err != nil {
+		log.Printf("Error loading admin audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if entries == nil {
+		entries = []adminAuditEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func handleAPIDeliveries(c *gin.Context) {
+	records, err := recentDeliveries(c.Query("channel"))
+	if err != nil {
+		log.Printf("Error loading delivery history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if records == nil {
+		records = []deliveryRecord{}
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+func describeUserAdminAudit(userID string) (string, error) {
+	entries, err := adminAuditLog(userID, math.MaxInt)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, entry := range entries {
+		items = append(items, fmt.Sprintf("%s %s as %s on %s", strings.ToUpper(entry.Action[:1])+entry.Action[1:], entry.Detail, entry.Role, entry.At.Format("2 Jan 2006")))
+	}
+	return describeItems(items), nil
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Marks a backup document; bump when the layout changes
+const backupFormat = "slack-bot-backup-v1"
+
+// Used when backups.keep isn't set
+const defaultBackupKeep = 14
+
+// Backups are named by when they were taken, so names sort by age
+var backupNamePattern = regexp.MustCompile(`^slack-bot-\d{8}T\d{6}Z\.json\.gz$`)
+
+// backupFile is what a backup holds: the store as it was written to disk,
+// and a checksum to tell whether it survived the trip
+type backupFile struct {
+	Format  string          `json:"format"`
+	Created time.Time       `json:"created"`
+	SHA256  string          `json:"sha256"`
+	Store   json.RawMessage `json:"store"`
+}
+
+// backupCheck is what an integrity check found in a backup
+type backupCheck struct {
+	Buckets int
+	Keys    int
+	// Secrets counts encrypted values; they're only unwrapped when a
+	// master key is configured
+	Secrets int
+}
+
+// backupTarget is somewhere backups are kept
+type backupTarget interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	// List returns the names of every backup, oldest first
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// backupTargetFactories creates backup targets by the name used in logs
+var backupTargetFactories = map[string]func(BackupConfig) (backupTarget, error){
+	"dir": newDirBackupTarget,
+	"s3":  newS3BackupTarget,
+}
+
+// The configured backup target; set by setupBackups
+var backups backupTarget
+
+// Held while a backup is taken and old ones pruned
+var backupMu sync.Mutex
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin backup",
+		Usage:       "admin backup",
+		Description: "Back up the bot's data now",
+		AdminOnly:   true,
+		Handler:     handleBackupNow,
+	})
+	registerBotCommand(&command{
+		Name:        "admin backup list",
+		Usage:       "admin backup list",
+		Description: "List the backups of the bot's data, newest first",
+		AdminOnly:   true,
+		Handler:     handleBackupList,
+	})
+}
+
+// setupBackups builds the backup target: S3 when backups.s3.bucket is set,
+// otherwise a directory
+func setupBackups(cfg BackupConfig) error {
+	name := "dir"
+	if cfg.S3.Bucket != "" {
+		name = "s3"
+	}
+	target, err := backupTargetFactories[name](cfg)
+	if err != nil {
+		return fmt.Errorf("configuring backups: %w", err)
+	}
+	backups = target
+	return nil
+}
+
+// startBackups backs the store up on backups.schedule
+func startBackups(cfg BackupConfig) error {
+	if cfg.Schedule == "" {
+		return nil
+	}
+	schedule, err := parseCron(cfg.Schedule)
+	if err != nil {
+		return fmt.Errorf("backups.schedule: %w", err)
+	}
+	next := schedule.next(time.Now(), time.UTC)
+	runEvery("backups", time.Minute, func() {
+		if time.Now().Before(next) {
+			return
+		}
+		next = schedule.next(time.Now(), time.UTC)
+		name, err := takeBackup(store)
+		if err != nil {
+			log.Printf("Error backing up store: %v", err)
+			return
+		}
+		log.Printf("Backed up store to %s", name)
+	})
+	return nil
+}
+
+// takeBackup writes a backup of s and deletes the oldest ones beyond
+// backups.keep, returning the new backup's name
+func takeBackup(s *Store) (string, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	raw, err := s.Snapshot()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	doc := backupFile{Format: backupFormat, Created: time.Now().UTC().Truncate(time.Second), SHA256: hex.EncodeToString(sum[:]), Store: raw}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("encoding backup: %w", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(encoded); err != nil {
+		return "", fmt.Errorf("compressing backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("compressing backup: %w", err)
+	}
+
+	name := "slack-bot-" + doc.Created.Format("20060102T150405Z") + ".json.gz"
+	if err := backups.Put(name, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("writing backup %s: %w", name, err)
+	}
+	if err := pruneBackups(); err != nil {
+		return name, fmt.Errorf("deleting old backups: %w", err)
+	}
+	return name, nil
+}
+
+// pruneBackups deletes the oldest backups beyond backups.keep
+func pruneBackups() error {
+	keep := appConfig.Backups.Keep
+	if keep <= 0 {
+		keep = defaultBackupKeep
+	}
+	names, err := backups.List()
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		if err := backups.Delete(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// readBackup loads a backup by name from the backup target, or from a
+// local file when name is a path to one
+func readBackup(name string) (backupFile, error) {
+	var raw []byte
+	var err error
+	if _, statErr := os.Stat(name); statErr == nil {
+		raw, err = os.ReadFile(name)
+	} else {
+		raw, err = backups.Get(name)
+	}
+	if err != nil {
+		return backupFile{}, fmt.Errorf("reading backup %s: %w", name, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return backupFile{}, fmt.Errorf("backup %s isn't gzipped: %w", name, err)
+	}
+	defer zr.Close()
+	var doc backupFile
+	if err := json.NewDecoder(zr).Decode(&doc); err != nil {
+		return backupFile{}, fmt.Errorf("decoding backup %s: %w", name, err)
+	}
+	return doc, nil
+}
+
+// checkBackup verifies a backup's checksum and that every value in it can
+// be read, including, when a master key is configured, every secret's
+// data key
+func checkBackup(doc backupFile) (backupCheck, error) {
+	var check backupCheck
+	if doc.Format != backupFormat {
+		return check, fmt.Errorf("unknown backup format %q", doc.Format)
+	}
+	sum := sha256.Sum256(doc.Store)
+	if hex.EncodeToString(sum[:]) != doc.SHA256 {
+		return check, errors.New("checksum doesn't match; the backup is damaged")
+	}
+	var data map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(doc.Store, &data); err != nil {
+		return check, fmt.Errorf("decoding store: %w", err)
+	}
+	for bucket, values := range data {
+		check.Buckets++
+		for key, raw := range values {
+			check.Keys++
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return check, fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+			}
+			n, err := checkEnvelopes(value)
+			check.Secrets += n
+			if err != nil {
+				return check, fmt.Errorf("secret in %s/%s can't be decrypted: %w", bucket, key, err)
+			}
+		}
+	}
+	return check, nil
+}
+
+// checkEnvelopes counts the encrypted values anywhere in decoded JSON,
+// unwrapping their data keys when a master key is configured
+func checkEnvelopes(value any) (int, error) {
+	count := 0
+	switch v := value.(type) {
+	case map[string]any:
+		if v["enc"] == envelopeFormat {
+			if masterKeys == nil {
+				return 1, nil
+			}
+			keyID, _ := v["kid"].(string)
+			encoded, _ := v["dek"].(string)
+			wrapped, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return 1, err
+			}
+			if _, err := masterKeys.UnwrapKey(keyID, wrapped); err != nil {
+				return 1, err
+			}
+			return 1, nil
+		}
+		for _, child := range v {
+			n, err := checkEnvelopes(child)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	case []any:
+		for _, child := range v {
+			n, err := checkEnvelopes(child)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+	return count, nil
+}
+
+// restoreBackup replaces the store at path with the backup's, keeping the
+// current file as path.before-restore. The bot must not be running, or it
+// will write its own copy back over the restored one.
+func restoreBackup(doc backupFile, path string) error {
+	s := &Store{path: path}
+	if err := json.Unmarshal(doc.Store, &s.data); err != nil {
+		return fmt.Errorf("decoding store: %w", err)
+	}
+	current, err := os.ReadFile(path)
+	if err == nil {
+		if err := os.WriteFile(path+".before-restore", current, 0o600); err != nil {
+			return fmt.Errorf("keeping current store: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading current store: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func handleBackupNow(req commandRequest) commandResponse {
+	name, err := takeBackup(store)
+	if name == "" {
+		log.Printf("Error backing up store: %v", err)
+		return ephemeral("Sorry, something went wrong taking the backup.")
+	}
+	if err != nil {
+		log.Printf("Error backing up store: %v", err)
+		return ephemeral("Backed up to `%s`, but I couldn't delete the old backups.", name)
+	}
+	return ephemeral("Backed up to `%s`. Restore it with `slack-bot restore %s` while the bot is stopped.", name, name)
+}
+
+func handleBackupList(req commandRequest) commandResponse {
+	names, err := backups.List()
+	if err != nil {
+		log.Printf("Error listing backups: %v", err)
+		return ephemeral("Sorry, something went wrong listing the backups.")
+	}
+	if len(names) == 0 {
+		return ephemeral("There are no backups yet. Take one with `/bot admin backup`.")
+	}
+	slices.Reverse(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = "• `" + name + "`"
+	}
+	return ephemeral("Backups, newest first:\n%s", strings.Join(lines, "\n"))
+}
+
+// dirBackupTarget keeps backups in a local directory
+type dirBackupTarget struct {
+	dir string
+}
+
+func newDirBackupTarget(cfg BackupConfig) (backupTarget, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(storePath()), "backups")
+	}
+	return &dirBackupTarget{dir: dir}, nil
+}
+
+func (t *dirBackupTarget) Put(name string, data []byte) error {
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(t.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, name))
+}
+
+func (t *dirBackupTarget) Get(name string) ([]byte, error) {
+	if !backupNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%q isn't a backup name", name)
+	}
+	return os.ReadFile(filepath.Join(t.dir, name))
+}
+
+func (t *dirBackupTarget) List() ([]string, error) {
+	entries, err := os.ReadDir(t.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if backupNamePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *dirBackupTarget) Delete(name string) error {
+	return os.Remove(filepath.Join(t.dir, name))
+}
+
+// s3BackupTarget keeps backups in an S3 bucket, signing requests with AWS
+// Signature Version 4. Buckets are addressed by path, which S3-compatible
+// services support too.
+type s3BackupTarget struct {
+	endpoint, bucket, region, prefix string
+	accessKey, secretKey, token      string
+}
+
+func newS3BackupTarget(cfg BackupConfig) (backupTarget, error) {
+	s3 := cfg.S3
+	if s3.Region == "" {
+		return nil, fmt.Errorf("backups.s3.region is required")
+	}
+	t := &s3BackupTarget{
+		endpoint:  strings.TrimSuffix(s3.Endpoint, "/"),
+		bucket:    s3.Bucket,
+		region:    s3.Region,
+		prefix:    s3.Prefix,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if t.endpoint == "" {
+		t.endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("S3 backups need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return t, nil
+}
+
+func (t *s3BackupTarget) Put(name string, data []byte) error {
+	resp, err := t.do(http.MethodPut, t.prefix+name, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *s3BackupTarget) Get(name string) ([]byte, error) {
+	if !backupNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%q isn't a backup name", name)
+	}
+	resp, err := t.do(http.MethodGet, t.prefix+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (t *s3BackupTarget) List() ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {t.prefix}}
+	for {
+		resp, err := t.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var out struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding bucket listing: %w", err)
+		}
+		for _, object := range out.Contents {
+			name := strings.TrimPrefix(object.Key, t.prefix)
+			if backupNamePattern.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+		if !out.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", out.NextContinuationToken)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *s3BackupTarget) Delete(name string) error {
+	resp, err := t.do(http.MethodDelete, t.prefix+name, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for key in the bucket, or for the bucket
+// itself when key is empty
+func (t *s3BackupTarget) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + t.bucket + "/"
+	for i, segment := range strings.Split(key, "/") {
+		if i > 0 {
+			path += "/"
+		}
+		path += url.PathEscape(segment)
+	}
+	if key == "" {
+		path = "/" + t.bucket
+	}
+	target := t.endpoint + path
+	rawQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req, path, body, time.Now().UTC())
+	// Backups can be large, so they get longer than httpClient allows
+	resp, err := (&http.Client{Timeout: 5 * time.Minute}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling S3: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("calling S3: %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (t *s3BackupTarget) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+	if t.token != "" {
+		req.Header.Set("x-amz-security-token", t.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(values[0])
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + t.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + t.secretKey)
+	for _, part := range []string{date, t.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", t.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
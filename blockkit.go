@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+// Slack Block Kit limits, see https://api.slack.com/reference/block-kit/blocks
+const (
+	maxMessageBlocks   = 50
+	maxViewBlocks      = 100
+	maxMessageText     = 40000
+	maxAttachments     = 100
+	maxSectionText     = 3000
+	maxSectionFields   = 10
+	maxFieldText       = 2000
+	maxHeaderText      = 150
+	maxActionElements  = 25
+	maxContextElements = 10
+	maxOptionText      = 75
+	maxButtonText      = 75
+	maxSelectOptions   = 100
+)
+
+// Appended to any text cut short to fit a limit
+const truncationMarker = "…"
+
+// messageFit is the result of fitting a message into Slack's limits
+type messageFit struct {
+	// Parts to post in order; more than one when the blocks had to be split
+	Parts []outboundMessage
+	// Full text of every section that had to be truncated
+	Overflow []string
+}
+
+// fitMessage checks msg against Slack's limits, truncating oversized text
+// and splitting blocks across several messages so the post never fails
+// with invalid_blocks. It does not modify msg.
+func fitMessage(msg outboundMessage) messageFit {
+	var fit messageFit
+
+	text, cut := truncateText(msg.Text, maxMessageText)
+	if cut {
+		fit.Overflow = append(fit.Overflow, msg.Text)
+	}
+
+	var blocks []slack.Block
+	for _, block := range msg.Blocks {
+		fitted, overflow := fitBlock(block)
+		blocks = append(blocks, fitted...)
+		fit.Overflow = append(fit.Overflow, overflow...)
+	}
+
+	attachments := msg.Attachments
+	if len(attachments) > maxAttachments {
+		log.Printf("Dropping %d attachments over the limit of %d", len(attachments)-maxAttachments, maxAttachments)
+		attachments = attachments[:maxAttachments]
+	}
+
+	first := msg
+	first.Text = text
+	first.Attachments = attachments
+	first.Blocks = nil
+	if len(blocks) <= maxMessageBlocks {
+		first.Blocks = blocks
+		fit.Parts = []outboundMessage{first}
+		return fit
+	}
+
+	for start := 0; start < len(blocks); start += maxMessageBlocks {
+		end := min(start+maxMessageBlocks, len(blocks))
+		part := first
+		if start > 0 {
+			part = outboundMessage{Channel: msg.Channel, ThreadTS: msg.ThreadTS, Text: text}
+		}
+		part.Blocks = blocks[start:end]
+		fit.Parts = append(fit.Parts, part)
+	}
+	return fit
+}
+
+// fitViewBlocks applies the per-block limits to the blocks of a view and
+// drops any blocks past the view limit, since views cannot be split
+func fitViewBlocks(blocks []slack.Block) []slack.Block {
+	var fitted []slack.Block
+	for _, block := range blocks {
+		adjusted, _ := fitBlock(block)
+		fitted = append(fitted, adjusted...)
+	}
+	if len(fitted) > maxViewBlocks {
+		log.Printf("Dropping %d view blocks over the limit of %d", len(fitted)-maxViewBlocks, maxViewBlocks)
+		fitted = fitted[:maxViewBlocks]
+	}
+	return fitted
+}
+
+// fitResponseBlocks fits the blocks of a reply that cannot be split into
+// several messages, such as a slash command or response_url reply
+func fitResponseBlocks(blocks []slack.Block) []slack.Block {
+	var fitted []slack.Block
+	for _, block := range blocks {
+		adjusted, _ := fitBlock(block)
+		fitted = append(fitted, adjusted...)
+	}
+	if len(fitted) > maxMessageBlocks {
+		log.Printf("Dropping %d response blocks over the limit of %d", len(fitted)-maxMessageBlocks, maxMessageBlocks)
+		fitted = fitted[:maxMessageBlocks]
+	}
+	return fitted
+}
+
+// fitBlock returns block adjusted to Slack's per-block limits. Action blocks
+// with too many elements are split into several blocks; truncated section
+// text is returned as overflow.
+func fitBlock(block slack.Block) ([]slack.Block, []string) {
+	var overflow []string
+	switch b := block.(type) {
+	case *slack.SectionBlock:
+		s := *b
+		if s.Text != nil {
+			limited, full := fitTextObject(s.Text, maxSectionText)
+			s.Text = limited
+			overflow = append(overflow, full...)
+		}
+		if len(s.Fields) > maxSectionFields {
+			s.Fields = s.Fields[:maxSectionFields]
+		}
+		fields := make([]*slack.TextBlockObject, len(s.Fields))
+		for i, field := range s.Fields {
+			limited, full := fitTextObject(field, maxFieldText)
+			fields[i] = limited
+			overflow = append(overflow, full...)
+		}
+		if len(fields) > 0 {
+			s.Fields = fields
+		}
+		return []slack.Block{&s}, overflow
+	case *slack.HeaderBlock:
+		h := *b
+		if h.Text != nil {
+			h.Text, _ = fitTextObject(h.Text, maxHeaderText)
+		}
+		return []slack.Block{&h}, nil
+	case *slack.ActionBlock:
+		if b.Elements == nil || len(b.Elements.ElementSet) <= maxActionElements {
+			return []slack.Block{b}, nil
+		}
+		var split []slack.Block
+		elements := b.Elements.ElementSet
+		for start := 0; start < len(elements); start += maxActionElements {
+			end := min(start+maxActionElements, len(elements))
+			blockID := b.BlockID
+			if blockID != "" && start > 0 {
+				blockID = fmt.Sprintf("%s_%d", b.BlockID, start/maxActionElements+1)
+			}
+			split = append(split, slack.NewActionBlock(blockID, elements[start:end]...))
+		}
+		return split, nil
+	case *slack.ContextBlock:
+		if len(b.ContextElements.Elements) <= maxContextElements {
+			return []slack.Block{b}, nil
+		}
+		c := *b
+		c.ContextElements.Elements = c.ContextElements.Elements[:maxContextElements]
+		return []slack.Block{&c}, nil
+	}
+	return []slack.Block{block}, nil
+}
+
+// fitTextObject returns a copy of obj truncated to limit characters, along
+// with the original text when truncation happened
+func fitTextObject(obj *slack.TextBlockObject, limit int) (*slack.TextBlockObject, []string) {
+	text, cut := truncateText(obj.Text, limit)
+	if !cut {
+		return obj, nil
+	}
+	limited := *obj
+	limited.Text = text
+	return &limited, []string{obj.Text}
+}
+
+// truncateText shortens s to at most limit characters, reporting whether it
+// had to cut anything
+func truncateText(s string, limit int) (string, bool) {
+	if utf8.RuneCountInString(s) <= limit {
+		return s, false
+	}
+	runes := []rune(s)
+	keep := limit - utf8.RuneCountInString(truncationMarker)
+	return strings.TrimRightFunc(string(runes[:keep]), func(r rune) bool { return r == ' ' || r == '\n' }) + truncationMarker, true
+}
+
+// uploadOverflowSnippet shares the full text of truncated sections as a
+// snippet in channel and returns its permalink
+func uploadOverflowSnippet(channel string, overflow []string) (string, error) {
+	content := strings.Join(overflow, "\n\n---\n\n")
+	file, err := slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+		Channel:     channel,
+		Content:     content,
+		FileSize:    len(content),
+		Filename:    "message.txt",
+		Title:       "Full message",
+		SnippetType: "text",
+	})
+	if err != nil {
+		return "", fmt.Errorf("uploading overflow snippet: %w", err)
+	}
+	info, _, _, err := slackClient.GetFileInfo(file.ID, 0, 0)
+	if err != nil {
+		return "", fmt.Errorf("fetching overflow snippet info: %w", err)
+	}
+	return info.Permalink, nil
+}
+
+// showMoreBlock links to the snippet holding the untruncated message
+func showMoreBlock(permalink string) slack.Block {
+	btn := slack.NewButtonBlockElement("show_more", permalink,
+		slack.NewTextBlockObject(slack.PlainTextType, "Show more", false, false))
+	btn.URL = permalink
+	return slack.NewActionBlock("show_more", btn)
+}
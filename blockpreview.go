@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+var (
+	// Slack's <...> entities: links, mentions and special mentions
+	slackEntityPattern = regexp.MustCompile(`<([^<>]+)>`)
+	// mrkdwn emphasis, applied to already escaped text
+	mrkdwnStyles = []struct {
+		pattern *regexp.Regexp
+		tag     string
+	}{
+		{regexp.MustCompile("`([^`\n]+)`"), "code"},
+		{regexp.MustCompile(`(^|[\s(])\*([^*\n]+)\*`), "strong"},
+		{regexp.MustCompile(`(^|[\s(])_([^_\n]+)_`), "em"},
+		{regexp.MustCompile(`(^|[\s(])~([^~\n]+)~`), "del"},
+	}
+)
+
+// blockKitBuilderURL opens blocks in Slack's Block Kit Builder, which
+// shows exactly how Slack renders them
+func blockKitBuilderURL(blocks []slack.Block) string {
+	payload, err := json.Marshal(map[string]any{"blocks": blocks})
+	if err != nil {
+		return ""
+	}
+	return "https://app.slack.com/block-kit-builder#" + url.PathEscape(string(payload))
+}
+
+// previewMessageHTML approximates how Slack shows a message with text and
+// blocks, for previews in the admin web UI
+func previewMessageHTML(text string, blocks []slack.Block) template.HTML {
+	if len(blocks) == 0 {
+		return template.HTML(`<div class="message">` + mrkdwnHTML(text) + `</div>`)
+	}
+	var b strings.Builder
+	b.WriteString(`<div class="message">`)
+	for _, block := range blocks {
+		switch block := block.(type) {
+		case *slack.HeaderBlock:
+			fmt.Fprintf(&b, "<h3>%s</h3>", textObjectHTML(block.Text))
+		case *slack.SectionBlock:
+			b.WriteString(`<div class="section"><div>`)
+			b.WriteString(textObjectHTML(block.Text))
+			if len(block.Fields) > 0 {
+				b.WriteString(`<div class="fields">`)
+				for _, field := range block.Fields {
+					fmt.Fprintf(&b, "<div>%s</div>", textObjectHTML(field))
+				}
+				b.WriteString(`</div>`)
+			}
+			b.WriteString(`</div>`)
+			if block.Accessory != nil {
+				b.WriteString(accessoryHTML(block.Accessory))
+			}
+			b.WriteString(`</div>`)
+		case *slack.DividerBlock:
+			b.WriteString("<hr>")
+		case *slack.ContextBlock:
+			b.WriteString(`<div class="context">`)
+			for _, element := range block.ContextElements.Elements {
+				switch element := element.(type) {
+				case *slack.TextBlockObject:
+					fmt.Fprintf(&b, "<span>%s</span> ", textObjectHTML(element))
+				case *slack.ImageBlockElement:
+					fmt.Fprintf(&b, `<img class="icon" src="%s" alt="%s"> `, html.EscapeString(element.ImageURL), html.EscapeString(element.AltText))
+				}
+			}
+			b.WriteString(`</div>`)
+		case *slack.ImageBlock:
+			fmt.Fprintf(&b, `<img class="image" src="%s" alt="%s">`, html.EscapeString(block.ImageURL), html.EscapeString(block.AltText))
+		case *slack.ActionBlock:
+			b.WriteString(`<div class="actions">`)
+			for _, element := range block.Elements.ElementSet {
+				b.WriteString(elementHTML(element))
+			}
+			b.WriteString(`</div>`)
+		default:
+			fmt.Fprintf(&b, `<div class="context">[%s block]</div>`, html.EscapeString(string(block.BlockType())))
+		}
+	}
+	b.WriteString(`</div>`)
+	return template.HTML(b.String())
+}
+
+func textObjectHTML(text *slack.TextBlockObject) string {
+	if text == nil {
+		return ""
+	}
+	if text.Type == slack.PlainTextType {
+		return strings.ReplaceAll(html.EscapeString(text.Text), "\n", "<br>")
+	}
+	return mrkdwnHTML(text.Text)
+}
+
+func accessoryHTML(accessory *slack.Accessory) string {
+	switch {
+	case accessory.ButtonElement != nil:
+		return elementHTML(accessory.ButtonElement)
+	case accessory.ImageElement != nil:
+		return fmt.Sprintf(`<img class="thumb" src="%s" alt="%s">`, html.EscapeString(accessory.ImageElement.ImageURL), html.EscapeString(accessory.ImageElement.AltText))
+	case accessory.OverflowElement != nil:
+		return `<button disabled>⋯</button>`
+	}
+	return ""
+}
+
+func elementHTML(element slack.BlockElement) string {
+	switch element := element.(type) {
+	case *slack.ButtonBlockElement:
+		return fmt.Sprintf(`<button class="%s" disabled>%s</button>`, html.EscapeString(string(element.Style)), textObjectHTML(element.Text))
+	case *slack.SelectBlockElement:
+		return fmt.Sprintf(`<select disabled><option>%s</option></select>`, textObjectHTML(element.Placeholder))
+	case *slack.DatePickerBlockElement:
+		return `<input type="date" disabled>`
+	}
+	return fmt.Sprintf(`<span class="context">[%s]</span>`, html.EscapeString(string(element.ElementType())))
+}
+
+// mrkdwnHTML renders Slack mrkdwn as escaped HTML: links, mentions, code,
+// bold, italic, strikethrough, quotes and line breaks
+func mrkdwnHTML(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range slackEntityPattern.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString(slackEntityHTML(text[m[2]:m[3]]))
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+
+	out := b.String()
+	for _, style := range mrkdwnStyles {
+		if style.tag == "code" {
+			out = style.pattern.ReplaceAllString(out, "<code>$1</code>")
+		} else {
+			out = style.pattern.ReplaceAllString(out, "$1<"+style.tag+">$2</"+style.tag+">")
+		}
+	}
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if quoted, ok := strings.CutPrefix(line, "&gt;"); ok {
+			line = "<blockquote>" + strings.TrimPrefix(quoted, " ") + "</blockquote>"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "<br>")
+}
+
+// slackEntityHTML renders the inside of a <...> entity
+func slackEntityHTML(entity string) string {
+	target, label, hasLabel := strings.Cut(entity, "|")
+	switch {
+	case strings.HasPrefix(target, "@"):
+		if !hasLabel {
+			label = target[1:]
+		}
+		return `<span class="mention">@` + html.EscapeString(label) + `</span>`
+	case strings.HasPrefix(target, "#"):
+		if !hasLabel {
+			label = target[1:]
+		}
+		return `<span class="mention">#` + html.EscapeString(label) + `</span>`
+	case strings.HasPrefix(target, "!"):
+		if !hasLabel {
+			label = strings.TrimPrefix(strings.SplitN(target, "^", 2)[0], "!")
+		}
+		return `<span class="mention">@` + html.EscapeString(strings.TrimPrefix(label, "@")) + `</span>`
+	}
+	if !hasLabel {
+		label = target
+	}
+	return `<a href="` + html.EscapeString(target) + `" target="_blank" rel="noopener">` + html.EscapeString(label) + `</a>`
+}
@@ -0,0 +1,462 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: bot/v1/bot.proto
+
+package botpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SendMessageRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Channel ID, or a user ID to send a DM.
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	// Timestamp of the parent message to reply in its thread.
+	ThreadTs string `protobuf:"bytes,2,opt,name=thread_ts,json=threadTs,proto3" json:"thread_ts,omitempty"`
+	// mrkdwn text of the message.
+	Text          string `protobuf:"bytes,3,opt,name=text,proto3" json:"text,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMessageRequest) Reset() {
+	*x = SendMessageRequest{}
+	mi := &file_bot_v1_bot_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMessageRequest) ProtoMessage() {}
+
+func (x *SendMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bot_v1_bot_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMessageRequest.ProtoReflect.Descriptor instead.
+func (*SendMessageRequest) Descriptor() ([]byte, []int) {
+	return file_bot_v1_bot_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SendMessageRequest) GetChannel() string {
+	if x != nil {
+		return x.Channel
+	}
+	return ""
+}
+
+func (x *SendMessageRequest) GetThreadTs() string {
+	if x != nil {
+		return x.ThreadTs
+	}
+	return ""
+}
+
+func (x *SendMessageRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+type SendMessageResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Channel the message was posted to.
+	Channel string `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
+	// Timestamp of the new message.
+	Ts            string `protobuf:"bytes,2,opt,name=ts,proto3" json:"ts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendMessageResponse) Reset() {
+	*x = SendMessageResponse{}
+	mi := &file_bot_v1_bot_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendMessageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendMessageResponse) ProtoMessage() {}
+
+func (x *SendMessageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_bot_v1_bot_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendMessageResponse.ProtoReflect.Descriptor instead.
+func (*SendMessageResponse) Descriptor() ([]byte, []int) {
+	return file_bot_v1_bot_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SendMessageResponse) GetChannel() string {
+	if x != nil {
+		return x.Channel
+	}
+	return ""
+}
+
+func (x *SendMessageResponse) GetTs() string {
+	if x != nil {
+		return x.Ts
+	}
+	return ""
+}
+
+type CreateIncidentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Title string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	// Severity such as "sev1"; the bot's default when empty.
+	Severity    string `protobuf:"bytes,2,opt,name=severity,proto3" json:"severity,omitempty"`
+	Description string `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	// Slack user ID of the incident commander.
+	CommanderId   string `protobuf:"bytes,4,opt,name=commander_id,json=commanderId,proto3" json:"commander_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateIncidentRequest) Reset() {
+	*x = CreateIncidentRequest{}
+	mi := &file_bot_v1_bot_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateIncidentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateIncidentRequest) ProtoMessage() {}
+
+func (x *CreateIncidentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bot_v1_bot_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateIncidentRequest.ProtoReflect.Descriptor instead.
+func (*CreateIncidentRequest) Descriptor() ([]byte, []int) {
+	return file_bot_v1_bot_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CreateIncidentRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *CreateIncidentRequest) GetSeverity() string {
+	if x != nil {
+		return x.Severity
+	}
+	return ""
+}
+
+func (x *CreateIncidentRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *CreateIncidentRequest) GetCommanderId() string {
+	if x != nil {
+		return x.CommanderId
+	}
+	return ""
+}
+
+type CreateIncidentResponse struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	IncidentId string                 `protobuf:"bytes,1,opt,name=incident_id,json=incidentId,proto3" json:"incident_id,omitempty"`
+	// ID of the incident's Slack channel.
+	Channel       string `protobuf:"bytes,2,opt,name=channel,proto3" json:"channel,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateIncidentResponse) Reset() {
+	*x = CreateIncidentResponse{}
+	mi := &file_bot_v1_bot_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateIncidentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateIncidentResponse) ProtoMessage() {}
+
+func (x *CreateIncidentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_bot_v1_bot_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateIncidentResponse.ProtoReflect.Descriptor instead.
+func (*CreateIncidentResponse) Descriptor() ([]byte, []int) {
+	return file_bot_v1_bot_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateIncidentResponse) GetIncidentId() string {
+	if x != nil {
+		return x.IncidentId
+	}
+	return ""
+}
+
+func (x *CreateIncidentResponse) GetChannel() string {
+	if x != nil {
+		return x.Channel
+	}
+	return ""
+}
+
+type ScheduleReminderRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Slack user ID to remind.
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Text          string                 `protobuf:"bytes,2,opt,name=text,proto3" json:"text,omitempty"`
+	RemindAt      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=remind_at,json=remindAt,proto3" json:"remind_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleReminderRequest) Reset() {
+	*x = ScheduleReminderRequest{}
+	mi := &file_bot_v1_bot_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleReminderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleReminderRequest) ProtoMessage() {}
+
+func (x *ScheduleReminderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_bot_v1_bot_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleReminderRequest.ProtoReflect.Descriptor instead.
+func (*ScheduleReminderRequest) Descriptor() ([]byte, []int) {
+	return file_bot_v1_bot_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ScheduleReminderRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *ScheduleReminderRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *ScheduleReminderRequest) GetRemindAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RemindAt
+	}
+	return nil
+}
+
+type ScheduleReminderResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ReminderId    string                 `protobuf:"bytes,1,opt,name=reminder_id,json=reminderId,proto3" json:"reminder_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScheduleReminderResponse) Reset() {
+	*x = ScheduleReminderResponse{}
+	mi := &file_bot_v1_bot_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScheduleReminderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScheduleReminderResponse) ProtoMessage() {}
+
+func (x *ScheduleReminderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_bot_v1_bot_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScheduleReminderResponse.ProtoReflect.Descriptor instead.
+func (*ScheduleReminderResponse) Descriptor() ([]byte, []int) {
+	return file_bot_v1_bot_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ScheduleReminderResponse) GetReminderId() string {
+	if x != nil {
+		return x.ReminderId
+	}
+	return ""
+}
+
+var File_bot_v1_bot_proto protoreflect.FileDescriptor
+
+const file_bot_v1_bot_proto_rawDesc = "" +
+	"\n" +
+	"\x10bot/v1/bot.proto\x12\x06bot.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"_\n" +
+	"\x12SendMessageRequest\x12\x18\n" +
+	"\achannel\x18\x01 \x01(\tR\achannel\x12\x1b\n" +
+	"\tthread_ts\x18\x02 \x01(\tR\bthreadTs\x12\x12\n" +
+	"\x04text\x18\x03 \x01(\tR\x04text\"?\n" +
+	"\x13SendMessageResponse\x12\x18\n" +
+	"\achannel\x18\x01 \x01(\tR\achannel\x12\x0e\n" +
+	"\x02ts\x18\x02 \x01(\tR\x02ts\"\x8e\x01\n" +
+	"\x15CreateIncidentRequest\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x1a\n" +
+	"\bseverity\x18\x02 \x01(\tR\bseverity\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12!\n" +
+	"\fcommander_id\x18\x04 \x01(\tR\vcommanderId\"S\n" +
+	"\x16CreateIncidentResponse\x12\x1f\n" +
+	"\vincident_id\x18\x01 \x01(\tR\n" +
+	"incidentId\x12\x18\n" +
+	"\achannel\x18\x02 \x01(\tR\achannel\"\x7f\n" +
+	"\x17ScheduleReminderRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x12\n" +
+	"\x04text\x18\x02 \x01(\tR\x04text\x127\n" +
+	"\tremind_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\bremindAt\";\n" +
+	"\x18ScheduleReminderResponse\x12\x1f\n" +
+	"\vreminder_id\x18\x01 \x01(\tR\n" +
+	"reminderId2\xf5\x01\n" +
+	"\x03Bot\x12F\n" +
+	"\vSendMessage\x12\x1a.bot.v1.SendMessageRequest\x1a\x1b.bot.v1.SendMessageResponse\x12O\n" +
+	"\x0eCreateIncident\x12\x1d.bot.v1.CreateIncidentRequest\x1a\x1e.bot.v1.CreateIncidentResponse\x12U\n" +
+	"\x10ScheduleReminder\x12\x1f.bot.v1.ScheduleReminderRequest\x1a .bot.v1.ScheduleReminderResponseB\x11Z\x0fslack-bot/botpbb\x06proto3"
+
+var (
+	file_bot_v1_bot_proto_rawDescOnce sync.Once
+	file_bot_v1_bot_proto_rawDescData []byte
+)
+
+func file_bot_v1_bot_proto_rawDescGZIP() []byte {
+	file_bot_v1_bot_proto_rawDescOnce.Do(func() {
+		file_bot_v1_bot_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_bot_v1_bot_proto_rawDesc), len(file_bot_v1_bot_proto_rawDesc)))
+	})
+	return file_bot_v1_bot_proto_rawDescData
+}
+
+var file_bot_v1_bot_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_bot_v1_bot_proto_goTypes = []any{
+	(*SendMessageRequest)(nil),       // 0: bot.v1.SendMessageRequest
+	(*SendMessageResponse)(nil),      // 1: bot.v1.SendMessageResponse
+	(*CreateIncidentRequest)(nil),    // 2: bot.v1.CreateIncidentRequest
+	(*CreateIncidentResponse)(nil),   // 3: bot.v1.CreateIncidentResponse
+	(*ScheduleReminderRequest)(nil),  // 4: bot.v1.ScheduleReminderRequest
+	(*ScheduleReminderResponse)(nil), // 5: bot.v1.ScheduleReminderResponse
+	(*timestamppb.Timestamp)(nil),    // 6: google.protobuf.Timestamp
+}
+var file_bot_v1_bot_proto_depIdxs = []int32{
+	6, // 0: bot.v1.ScheduleReminderRequest.remind_at:type_name -> google.protobuf.Timestamp
+	0, // 1: bot.v1.Bot.SendMessage:input_type -> bot.v1.SendMessageRequest
+	2, // 2: bot.v1.Bot.CreateIncident:input_type -> bot.v1.CreateIncidentRequest
+	4, // 3: bot.v1.Bot.ScheduleReminder:input_type -> bot.v1.ScheduleReminderRequest
+	1, // 4: bot.v1.Bot.SendMessage:output_type -> bot.v1.SendMessageResponse
+	3, // 5: bot.v1.Bot.CreateIncident:output_type -> bot.v1.CreateIncidentResponse
+	5, // 6: bot.v1.Bot.ScheduleReminder:output_type -> bot.v1.ScheduleReminderResponse
+	4, // [4:7] is the sub-list for method output_type
+	1, // [1:4] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_bot_v1_bot_proto_init() }
+func file_bot_v1_bot_proto_init() {
+	if File_bot_v1_bot_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_bot_v1_bot_proto_rawDesc), len(file_bot_v1_bot_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_bot_v1_bot_proto_goTypes,
+		DependencyIndexes: file_bot_v1_bot_proto_depIdxs,
+		MessageInfos:      file_bot_v1_bot_proto_msgTypes,
+	}.Build()
+	File_bot_v1_bot_proto = out.File
+	file_bot_v1_bot_proto_goTypes = nil
+	file_bot_v1_bot_proto_depIdxs = nil
+}
@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: bot/v1/bot.proto
+
+package botpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Bot_SendMessage_FullMethodName      = "/bot.v1.Bot/SendMessage"
+	Bot_CreateIncident_FullMethodName   = "/bot.v1.Bot/CreateIncident"
+	Bot_ScheduleReminder_FullMethodName = "/bot.v1.Bot/ScheduleReminder"
+)
+
+// BotClient is the client API for Bot service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Bot lets internal services drive the Slack bot directly. Every call needs
+// a client certificate signed by the configured CA.
+type BotClient interface {
+	// SendMessage posts a message to a channel, DM or thread.
+	SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error)
+	// CreateIncident declares an incident and opens its channel.
+	CreateIncident(ctx context.Context, in *CreateIncidentRequest, opts ...grpc.CallOption) (*CreateIncidentResponse, error)
+	// ScheduleReminder schedules a reminder for a user.
+	ScheduleReminder(ctx context.Context, in *ScheduleReminderRequest, opts ...grpc.CallOption) (*ScheduleReminderResponse, error)
+}
+
+type botClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBotClient(cc grpc.ClientConnInterface) BotClient {
+	return &botClient{cc}
+}
+
+func (c *botClient) SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendMessageResponse)
+	err := c.cc.Invoke(ctx, Bot_SendMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *botClient) CreateIncident(ctx context.Context, in *CreateIncidentRequest, opts ...grpc.CallOption) (*CreateIncidentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateIncidentResponse)
+	err := c.cc.Invoke(ctx, Bot_CreateIncident_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *botClient) ScheduleReminder(ctx context.Context, in *ScheduleReminderRequest, opts ...grpc.CallOption) (*ScheduleReminderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScheduleReminderResponse)
+	err := c.cc.Invoke(ctx, Bot_ScheduleReminder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BotServer is the server API for Bot service.
+// All implementations must embed UnimplementedBotServer
+// for forward compatibility.
+//
+// Bot lets internal services drive the Slack bot directly. Every call needs
+// a client certificate signed by the configured CA.
+type BotServer interface {
+	// SendMessage posts a message to a channel, DM or thread.
+	SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error)
+	// CreateIncident declares an incident and opens its channel.
+	CreateIncident(context.Context, *CreateIncidentRequest) (*CreateIncidentResponse, error)
+	// ScheduleReminder schedules a reminder for a user.
+	ScheduleReminder(context.Context, *ScheduleReminderRequest) (*ScheduleReminderResponse, error)
+	mustEmbedUnimplementedBotServer()
+}
+
+// UnimplementedBotServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBotServer struct{}
+
+func (UnimplementedBotServer) SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMessage not implemented")
+}
+func (UnimplementedBotServer) CreateIncident(context.Context, *CreateIncidentRequest) (*CreateIncidentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateIncident not implemented")
+}
+func (UnimplementedBotServer) ScheduleReminder(context.Context, *ScheduleReminderRequest) (*ScheduleReminderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ScheduleReminder not implemented")
+}
+func (UnimplementedBotServer) mustEmbedUnimplementedBotServer() {}
+func (UnimplementedBotServer) testEmbeddedByValue()             {}
+
+// UnsafeBotServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BotServer will
+// result in compilation errors.
+type UnsafeBotServer interface {
+	mustEmbedUnimplementedBotServer()
+}
+
+func RegisterBotServer(s grpc.ServiceRegistrar, srv BotServer) {
+	// If the following call pancis, it indicates UnimplementedBotServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Bot_ServiceDesc, srv)
+}
+
+func _Bot_SendMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BotServer).SendMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bot_SendMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BotServer).SendMessage(ctx, req.(*SendMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bot_CreateIncident_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateIncidentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BotServer).CreateIncident(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bot_CreateIncident_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BotServer).CreateIncident(ctx, req.(*CreateIncidentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bot_ScheduleReminder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScheduleReminderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BotServer).ScheduleReminder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bot_ScheduleReminder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BotServer).ScheduleReminder(ctx, req.(*ScheduleReminderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Bot_ServiceDesc is the grpc.ServiceDesc for Bot service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Bot_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bot.v1.Bot",
+	HandlerType: (*BotServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendMessage",
+			Handler:    _Bot_SendMessage_Handler,
+		},
+		{
+			MethodName: "CreateIncident",
+			Handler:    _Bot_CreateIncident_Handler,
+		},
+		{
+			MethodName: "ScheduleReminder",
+			Handler:    _Bot_ScheduleReminder_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "bot/v1/bot.proto",
+}
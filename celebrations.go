@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store buckets for celebrations: people's dates keyed by user ID, and the
+// days already celebrated keyed by date
+const (
+	celebrationsBucket    = "celebrations"
+	celebrationRunsBucket = "celebration_runs"
+)
+
+// Callback, block and action IDs of the celebrations modal
+const (
+	celebrationsCallbackID = "celebration_dates"
+	celebrationBirthday    = "celebration_birthday"
+	celebrationStartDate   = "celebration_start_date"
+	celebrationOptOut      = "celebration_opt_out"
+)
+
+// Used when celebrations.time isn't set
+const defaultCelebrationTime = "09:00"
+
+// Layouts for birthdays, kept without the year, and start dates
+const (
+	birthdayLayout  = "01-02"
+	startDateLayout = "2006-01-02"
+)
+
+// celebrationDates are the days celebrated for someone
+type celebrationDates struct {
+	User string `json:"user"`
+	// Birthday is month and day, like 03-14
+	Birthday string `json:"birthday,omitempty"`
+	// StartDate is when they joined, like 2021-06-01
+	StartDate string `json:"start_date,omitempty"`
+	// OptOut keeps their days from being announced
+	OptOut    bool      `json:"opt_out,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "celebrations",
+		Usage:       "celebrations [optout|optin]",
+		Description: "Set your birthday and start date for announcements, or opt out of them",
+		Handler:     handleCelebrationsCommand,
+	})
+	registerBotCommand(&command{
+		Name:        "admin celebrations import",
+		Usage:       "admin celebrations import <csv: user,birthday,start_date>",
+		Description: "Import birthdays and start dates; users by ID, mention or email",
+		AdminOnly:   true,
+		Handler:     handleCelebrationsImport,
+	})
+	registerViewSubmission(celebrationsCallbackID, handleCelebrationsSubmission)
+	registerUserDataset(userDataset{Name: "celebrations", Title: "Birthday and start date", Describe: describeUserCelebrations, Delete: deleteUserCelebrations})
+}
+
+// startCelebrations announces the day's birthdays and work anniversaries
+// once celebrations.time comes
+func startCelebrations() {
+	if appConfig.Celebrations.Channel == "" {
+		return
+	}
+	runEvery("celebrations", time.Minute, runDueCelebrations)
+}
+
+// parseBirthday reads a birthday as MM-DD or YYYY-MM-DD, dropping the year
+func parseBirthday(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(startDateLayout, s); err == nil {
+		return t.Format(birthdayLayout), nil
+	}
+	// 2000 is a leap year, so 02-29 parses
+	t, err := time.Parse(startDateLayout, "2000-"+s)
+	if err != nil {
+		return "", fmt.Errorf("%q isn't a date like 03-14", s)
+	}
+	return t.Format(birthdayLayout), nil
+}
+
+func parseStartDate(s string) (string, error) {
+	t, err := time.Parse(startDateLayout, strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("%q isn't a date like 2021-06-01", s)
+	}
+	return t.Format(startDateLayout), nil
+}
+
+func loadCelebrationDates(userID string) (celebrationDates, error) {
+	dates := celebrationDates{User: userID}
+	_, err := store.Get(celebrationsBucket, userID, &dates)
+	return dates, err
+}
+
+func saveCelebrationDates(dates celebrationDates) error {
+	dates.UpdatedAt = time.Now().UTC()
+	return store.Put(celebrationsBucket, dates.User, dates)
+}
+
+func handleCelebrationsCommand(req commandRequest) commandResponse {
+	dates, err := loadCelebrationDates(req.UserID)
+	if err != nil {
+		log.Printf("Error loading celebration dates: %v", err)
+		return ephemeral("Sorry, something went wrong looking up your dates.")
+	}
+	switch strings.ToLower(strings.Join(req.Args, " ")) {
+	case "":
+	case "optout", "opt out":
+		dates.OptOut = true
+		if err := saveCelebrationDates(dates); err != nil {
+			log.Printf("Error saving celebration dates: %v", err)
+			return ephemeral("Sorry, something went wrong opting you out.")
+		}
+		return ephemeral("OK, I won't announce your birthday or work anniversary. `%s celebrations optin` changes that.", botCommand)
+	case "optin", "opt in":
+		dates.OptOut = false
+		if err := saveCelebrationDates(dates); err != nil {
+			log.Printf("Error saving celebration dates: %v", err)
+			return ephemeral("Sorry, something went wrong opting you in.")
+		}
+		return ephemeral(":tada: I'll announce your days again.")
+	default:
+		return ephemeral("Usage: `%s celebrations [optout|optin]`", botCommand)
+	}
+
+	if err := openCelebrationsModal(req.TriggerID, dates); err != nil {
+		log.Printf("Error opening celebrations modal: %v", err)
+		return ephemeral("Sorry, something went wrong opening the form.")
+	}
+	return ephemeral("Opening your dates…")
+}
+
+// openCelebrationsModal asks for someone's birthday and start date,
+// prefilled with what's stored
+func openCelebrationsModal(triggerID string, dates celebrationDates) error {
+	birthday := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject(slack.PlainTextType, "MM-DD, like 03-14", false, false), celebrationBirthday)
+	birthday.InitialValue = dates.Birthday
+	start := slack.NewDatePickerBlockElement(celebrationStartDate)
+	start.InitialDate = dates.StartDate
+	optOut := slack.NewCheckboxGroupsBlockElement(celebrationOptOut,
+		slack.NewOptionBlockObject("yes", slack.NewTextBlockObject(slack.PlainTextType, "Don't announce my days", false, false), nil))
+	if dates.OptOut {
+		optOut.InitialOptions = optOut.Options
+	}
+	view := slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: celebrationsCallbackID,
+		Title:      slack.NewTextBlockObject(slack.PlainTextType, "Your dates", false, false),
+		Submit:     slack.NewTextBlockObject(slack.PlainTextType, "Save", false, false),
+		Close:      slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(celebrationBirthday, slack.NewTextBlockObject(slack.PlainTextType, "Birthday", false, false),
+				slack.NewTextBlockObject(slack.PlainTextType, "Only the month and day are kept.", false, false), birthday).WithOptional(true),
+			slack.NewInputBlock(celebrationStartDate, slack.NewTextBlockObject(slack.PlainTextType, "Start date", false, false), nil, start).WithOptional(true),
+			slack.NewInputBlock(celebrationOptOut, slack.NewTextBlockObject(slack.PlainTextType, "Announcements", false, false), nil, optOut).WithOptional(true),
+		}},
+	}
+	_, err := slackClient.OpenView(triggerID, view)
+	return err
+}
+
+func handleCelebrationsSubmission(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	values := callback.View.State.Values
+	dates := celebrationDates{
+		User:      callback.User.ID,
+		StartDate: values[celebrationStartDate][celebrationStartDate].SelectedDate,
+		OptOut:    len(values[celebrationOptOut][celebrationOptOut].SelectedOptions) > 0,
+	}
+	if birthday := strings.TrimSpace(values[celebrationBirthday][celebrationBirthday].Value); birthday != "" {
+		var err error
+		if dates.Birthday, err = parseBirthday(birthday); err != nil {
+			return slack.NewErrorsViewSubmissionResponse(map[string]string{celebrationBirthday: "Use month and day, like 03-14."})
+		}
+	}
+	if err := saveCelebrationDates(dates); err != nil {
+		log.Printf("Error saving celebration dates: %v", err)
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{celebrationBirthday: "Sorry, your dates couldn't be saved. Please try again."})
+	}
+	return nil
+}
+
+// handleCelebrationsImport reads CSV rows of user, birthday and start date
+// pasted after the command. Empty cells leave what's stored alone.
+func handleCelebrationsImport(req commandRequest) commandResponse {
+	usage := fmt.Sprintf("Usage: `%s admin celebrations import` followed by CSV lines of `user,birthday,start_date`, like `jo@example.com,03-14,2021-06-01`", botCommand)
+	_, data, _ := strings.Cut(strings.TrimSpace(req.Text), "import")
+	reader := csv.NewReader(strings.NewReader(strings.TrimSpace(data)))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var imported int
+	var problems []string
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return ephemeral("I couldn't read that CSV: %v. %s", err, usage)
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "user") {
+			continue
+		}
+		if len(record) < 2 {
+			problems = append(problems, fmt.Sprintf("line %d: needs a user and at least a birthday", line))
+			continue
+		}
+		if err := importCelebrationRow(record); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		imported++
+	}
+	if imported == 0 && len(problems) == 0 {
+		return ephemeral("%s", usage)
+	}
+	recordAdminAudit(req.UserID, roleAdmin, "celebrations import", fmt.Sprintf("imported dates for %d people", imported))
+	text := fmt.Sprintf(":tada: Imported dates for %d people.", imported)
+	if len(problems) > 0 {
+		text += "\nSkipped:\n• " + strings.Join(problems, "\n• ")
+	}
+	return ephemeral("%s", text)
+}
+
+// importCelebrationRow saves one user,birthday[,start_date] row
+func importCelebrationRow(record []string) error {
+	who := strings.TrimSpace(record[0])
+	userID := who
+	if m := userMentionPattern.FindStringSubmatch(who); m != nil {
+		userID = m[1]
+	} else if strings.Contains(who, "@") {
+		// Slack formats pasted emails as <mailto:jo@example.com|jo@example.com>
+		email := who
+		if m := slackMarkupPattern.FindStringSubmatch(who); m != nil {
+			email = strings.TrimPrefix(m[2], "mailto:")
+		}
+		user, err := slackClient.GetUserByEmail(email)
+		if err != nil {
+			return fmt.Errorf("no Slack user with the email %s", email)
+		}
+		userID = user.ID
+	} else if !userIDPattern.MatchString(who) {
+		return fmt.Errorf("%q isn't a user ID, mention or email", who)
+	}
+
+	dates, err := loadCelebrationDates(userID)
+	if err != nil {
+		return err
+	}
+	if birthday := strings.TrimSpace(record[1]); birthday != "" {
+		if dates.Birthday, err = parseBirthday(birthday); err != nil {
+			return err
+		}
+	}
+	if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+		if dates.StartDate, err = parseStartDate(record[2]); err != nil {
+			return err
+		}
+	}
+	return saveCelebrationDates(dates)
+}
+
+// runDueCelebrations posts today's celebrations once celebrations.time has
+// passed, once a day
+func runDueCelebrations() {
+	cfg := appConfig.Celebrations
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			log.Printf("Error scheduling celebrations: timezone: %v", err)
+			return
+		}
+	}
+	clock := cfg.Time
+	if clock == "" {
+		clock = defaultCelebrationTime
+	}
+	now := time.Now().In(loc)
+	due, err := reached(now, clock)
+	if err != nil {
+		log.Printf("Error scheduling celebrations: time: %v", err)
+		return
+	}
+	today := now.Format(startDateLayout)
+	if !due {
+		return
+	}
+	if done, err := store.Get(celebrationRunsBucket, today, new(time.Time)); err != nil || done {
+		if err != nil {
+			log.Printf("Error loading celebrations run: %v", err)
+		}
+		return
+	}
+	if err := store.Put(celebrationRunsBucket, today, time.Now().UTC()); err != nil {
+		log.Printf("Error saving celebrations run: %v", err)
+		return
+	}
+	if err := postCelebrations(now); err != nil {
+		log.Printf("Error posting celebrations: %v", err)
+	}
+}
+
+// postCelebrations announces the birthdays and work anniversaries on
+// today's date
+func postCelebrations(today time.Time) error {
+	all, err := storeList[celebrationDates](store, celebrationsBucket)
+	if err != nil {
+		return err
+	}
+	birthdays, anniversaries := celebrationsOn(all, today)
+	var lines []string
+	if len(birthdays) > 0 {
+		lines = append(lines, fmt.Sprintf(":birthday: Happy birthday, %s!", mentionList(birthdays)))
+	}
+	for _, a := range anniversaries {
+		years := "1 year"
+		if a.years > 1 {
+			years = fmt.Sprintf("%d years", a.years)
+		}
+		lines = append(lines, fmt.Sprintf(":tada: Happy work anniversary, <@%s>! %s with us today.", a.user, years))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err = sendMessage(outboundMessage{Channel: appConfig.Celebrations.Channel, Importance: importanceInfo, Text: strings.Join(lines, "\n")})
+	return err
+}
+
+// workAnniversary is someone who joined this many years ago today
+type workAnniversary struct {
+	user  string
+	years int
+}
+
+// celebrationsOn picks who has a birthday or work anniversary on today's
+// date, leaving out whoever opted out. Those born or started on 29 February
+// are celebrated on the 28th in other years.
+func celebrationsOn(all []celebrationDates, today time.Time) ([]string, []workAnniversary) {
+	isLeap := func(year int) bool { return year%4 == 0 && (year%100 != 0 || year%400 == 0) }
+	matches := func(monthDay string) bool {
+		if monthDay == today.Format(birthdayLayout) {
+			return true
+		}
+		return monthDay == "02-29" && !isLeap(today.Year()) && today.Format(birthdayLayout) == "02-28"
+	}
+	var birthdays []string
+	var anniversaries []workAnniversary
+	for _, d := range all {
+		if d.OptOut {
+			continue
+		}
+		if d.Birthday != "" && matches(d.Birthday) {
+			birthdays = append(birthdays, d.User)
+		}
+		if start, err := time.Parse(startDateLayout, d.StartDate); err == nil && matches(start.Format(birthdayLayout)) {
+			if years := today.Year() - start.Year(); years > 0 {
+				anniversaries = append(anniversaries, workAnniversary{user: d.User, years: years})
+			}
+		}
+	}
+	sort.Strings(birthdays)
+	slices.SortFunc(anniversaries, func(a, b workAnniversary) int { return b.years - a.years })
+	return birthdays, anniversaries
+}
+
+func describeUserCelebrations(userID string) (string, error) {
+	dates := celebrationDates{}
+	found, err := store.Get(celebrationsBucket, userID, &dates)
+	if err != nil || !found {
+		return "", err
+	}
+	var items []string
+	if dates.Birthday != "" {
+		items = append(items, "Birthday "+dates.Birthday)
+	}
+	if dates.StartDate != "" {
+		items = append(items, "Start date "+dates.StartDate)
+	}
+	if dates.OptOut {
+		items = append(items, "Opted out of announcements")
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserCelebrations(userID string) error {
+	return store.Delete(celebrationsBucket, userID)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for channel announcements waiting for the next batch
+const channelFeedBucket = "channel_feed_pending"
+
+const defaultChannelFeedInterval = time.Hour
+
+// channelFeedItem is one created or renamed channel to announce
+type channelFeedItem struct {
+	ChannelID string    `json:"channel_id"`
+	Name      string    `json:"name"`
+	Creator   string    `json:"creator,omitempty"`
+	Renamed   bool      `json:"renamed"`
+	At        time.Time `json:"at"`
+}
+
+// startChannelFeed flushes batched announcements on the configured interval
+func startChannelFeed() {
+	cfg := appConfig.ChannelFeed
+	if cfg.Channel == "" || !cfg.Batch {
+		return
+	}
+	interval := cfg.BatchInterval
+	if interval <= 0 {
+		interval = defaultChannelFeedInterval
+	}
+	runEvery("channel feed", interval, flushChannelFeed)
+}
+
+// handleChannelCreated announces a new public channel in the feed
+func handleChannelCreated(ev *slackevents.ChannelCreatedEvent) {
+	queueChannelFeedItem(channelFeedItem{
+		ChannelID: ev.Channel.ID,
+		Name:      ev.Channel.Name,
+		Creator:   ev.Channel.Creator,
+		At:        time.Now().UTC(),
+	})
+}
+
+// handleChannelRename announces a renamed channel in the feed
+func handleChannelRename(ev *slackevents.ChannelRenameEvent) {
+	if !appConfig.ChannelFeed.AnnounceRenames {
+		return
+	}
+	queueChannelFeedItem(channelFeedItem{
+		ChannelID: ev.Channel.ID,
+		Name:      ev.Channel.Name,
+		Renamed:   true,
+		At:        time.Now().UTC(),
+	})
+}
+
+// queueChannelFeedItem posts item right away, or stores it for the next
+// batch when batching is on
+func queueChannelFeedItem(item channelFeedItem) {
+	cfg := appConfig.ChannelFeed
+	if cfg.Channel == "" {
+		return
+	}
+	if !cfg.Batch {
+		if err := postChannelFeed([]channelFeedItem{item}); err != nil {
+			log.Printf("Error announcing channel %s: %v", item.ChannelID, err)
+		}
+		return
+	}
+	key := fmt.Sprintf("%d-%s", item.At.UnixNano(), item.ChannelID)
+	if err := store.Put(channelFeedBucket, key, item); err != nil {
+		log.Printf("Error queueing channel announcement: %v", err)
+	}
+}
+
+// flushChannelFeed posts every queued announcement as one digest
+func flushChannelFeed() {
+	keys := store.Keys(channelFeedBucket)
+	if len(keys) == 0 {
+		return
+	}
+	var items []channelFeedItem
+	for _, key := range keys {
+		var item channelFeedItem
+		if _, err := store.Get(channelFeedBucket, key, &item); err != nil {
+			log.Printf("Error reading channel announcement: %v", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := postChannelFeed(items); err != nil {
+		log.Printf("Error posting channel digest: %v", err)
+		return
+	}
+	for _, key := range keys {
+		if err := store.Delete(channelFeedBucket, key); err != nil {
+			log.Printf("Error clearing channel announcement: %v", err)
+		}
+	}
+}
+
+// postChannelFeed posts items to the configured feed channel
+func postChannelFeed(items []channelFeedItem) error {
+	var lines []string
+	for _, item := range items {
+		if item.Renamed {
+			lines = append(lines, fmt.Sprintf("• <#%s> was renamed to #%s", item.ChannelID, item.Name))
+		} else if item.Creator != "" {
+			lines = append(lines, fmt.Sprintf("• <#%s> was created by <@%s>", item.ChannelID, item.Creator))
+		} else {
+			lines = append(lines, fmt.Sprintf("• <#%s> was created", item.ChannelID))
+		}
+	}
+
+	title := ":new: *New channel*"
+	if len(items) > 1 {
+		title = fmt.Sprintf(":new: *%d channel updates*", len(items))
+	}
+	text := title + "\n" + strings.Join(lines, "\n")
+	_, err := sendInWindow("channel_feed", outboundMessage{
+		Channel: appConfig.ChannelFeed.Channel,
+		Text:    text,
+		Blocks:  []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)},
+	})
+	return err
+}
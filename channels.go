@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Whether each channel is private, keyed by channel ID. Channels can be
+// converted to private but never back, so entries only go stale one way.
+var privateChannels sync.Map
+
+// isPrivateChannel reports whether channel is a private channel (a "group"
+// in Slack's older APIs). Lookups that fail count as private, so private
+// messages are never treated as public by mistake.
+func isPrivateChannel(channel string) bool {
+	if private, ok := privateChannels.Load(channel); ok {
+		return private.(bool)
+	}
+	info, err := slackClient.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel})
+	if err != nil {
+		log.Printf("Error looking up channel %s: %v", channel, err)
+		return true
+	}
+	if info.IsPrivate {
+		privateChannels.Store(channel, true)
+	}
+	return info.IsPrivate
+}
+
+// handleGroupLeft forgets per-channel state for a private channel the bot
+// was removed from, since it can't post there any more
+func handleGroupLeft(ev *slackevents.GroupLeftEvent) {
+	if err := store.Delete(pinThreadsBucket, ev.Channel); err != nil {
+		log.Printf("Error forgetting highlights thread for %s: %v", ev.Channel, err)
+	}
+	if err := store.Delete(noticeThreadsBucket, ev.Channel); err != nil {
+		log.Printf("Error forgetting notices thread for %s: %v", ev.Channel, err)
+	}
+	privateChannels.Delete(ev.Channel)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/wcharczuk/go-chart/v2"
+)
+
+// Supported chart kinds
+const (
+	chartLine = "line"
+	chartBar  = "bar"
+	chartPie  = "pie"
+)
+
+const (
+	defaultChartWidth  = 800
+	defaultChartHeight = 400
+)
+
+// chartSeries is one named set of values, aligned with chartSpec.Labels
+type chartSeries struct {
+	Name   string
+	Values []float64
+}
+
+// chartSpec describes a chart to render. Line charts take any number of
+// series; bar and pie charts take exactly one.
+type chartSpec struct {
+	Kind   string
+	Title  string
+	Labels []string
+	Series []chartSeries
+	Width  int
+	Height int
+}
+
+// renderChart renders spec as a PNG image
+func renderChart(spec chartSpec) ([]byte, error) {
+	if len(spec.Series) == 0 {
+		return nil, fmt.Errorf("chart %q has no data", spec.Title)
+	}
+	for _, series := range spec.Series {
+		if len(series.Values) != len(spec.Labels) {
+			return nil, fmt.Errorf("chart %q: series %q has %d values for %d labels",
+				spec.Title, series.Name, len(series.Values), len(spec.Labels))
+		}
+	}
+	width, height := spec.Width, spec.Height
+	if width <= 0 {
+		width = defaultChartWidth
+	}
+	if height <= 0 {
+		height = defaultChartHeight
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch spec.Kind {
+	case chartLine:
+		err = renderLineChart(spec, width, height, &buf)
+	case chartBar, chartPie:
+		if len(spec.Series) != 1 {
+			return nil, fmt.Errorf("%s chart %q needs exactly one series", spec.Kind, spec.Title)
+		}
+		values := make([]chart.Value, len(spec.Labels))
+		for i, label := range spec.Labels {
+			values[i] = chart.Value{Label: label, Value: spec.Series[0].Values[i]}
+		}
+		if spec.Kind == chartBar {
+			top := 0.0
+			for _, v := range values {
+				top = max(top, v.Value)
+			}
+			graph := chart.BarChart{
+				Title:      spec.Title,
+				Width:      width,
+				Height:     height,
+				BarWidth:   max(10, width/(2*len(values)+1)),
+				Background: chart.Style{Padding: chart.Box{Top: 40}},
+				// Bars must start at zero or their heights mislead
+				YAxis: chart.YAxis{Range: &chart.ContinuousRange{Min: 0, Max: max(top, 1)}},
+				Bars:  values,
+			}
+			err = graph.Render(chart.PNG, &buf)
+		} else {
+			graph := chart.PieChart{
+				Title:  spec.Title,
+				Width:  width,
+				Height: height,
+				Values: values,
+			}
+			err = graph.Render(chart.PNG, &buf)
+		}
+	default:
+		return nil, fmt.Errorf("unknown chart kind %q", spec.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rendering chart %q: %w", spec.Title, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func renderLineChart(spec chartSpec, width, height int, buf *bytes.Buffer) error {
+	xValues := make([]float64, len(spec.Labels))
+	ticks := make([]chart.Tick, len(spec.Labels))
+	for i, label := range spec.Labels {
+		xValues[i] = float64(i)
+		ticks[i] = chart.Tick{Value: float64(i), Label: label}
+	}
+
+	graph := chart.Chart{
+		Title:      spec.Title,
+		Width:      width,
+		Height:     height,
+		Background: chart.Style{Padding: chart.Box{Top: 40}},
+		XAxis:      chart.XAxis{Ticks: ticks},
+	}
+	for _, series := range spec.Series {
+		graph.Series = append(graph.Series, chart.ContinuousSeries{
+			Name:    series.Name,
+			XValues: xValues,
+			YValues: series.Values,
+		})
+	}
+	if len(spec.Series) > 1 {
+		graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+	}
+	return graph.Render(chart.PNG, buf)
+}
+
+// uploadChart renders spec and shares it in channel, in a thread when
+// threadTS is set
+func uploadChart(channel, threadTS string, spec chartSpec) error {
+	image, err := renderChart(spec)
+	if err != nil {
+		return err
+	}
+	_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+		Channel:         channel,
+		ThreadTimestamp: threadTS,
+		Reader:          bytes.NewReader(image),
+		FileSize:        len(image),
+		Filename:        chartFilename(spec.Title),
+		Title:           spec.Title,
+		AltTxt:          chartAltText(spec),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading chart %q: %w", spec.Title, err)
+	}
+	return nil
+}
+
+func chartFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(title))
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "chart"
+	}
+	return name + ".png"
+}
+
+// chartAltText describes the chart for screen readers
+func chartAltText(spec chartSpec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s chart: %s.", spec.Kind, spec.Title)
+	for _, series := range spec.Series {
+		b.WriteString(" ")
+		if series.Name != "" {
+			b.WriteString(series.Name + ": ")
+		}
+		parts := make([]string, len(spec.Labels))
+		for i, label := range spec.Labels {
+			parts[i] = fmt.Sprintf("%s %g", label, series.Values[i])
+		}
+		b.WriteString(strings.Join(parts, ", ") + ".")
+	}
+	text, _ := truncateText(b.String(), 1000)
+	return text
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+//go:generate sh -c "go run . openapi > openapi.json && go run ./tools/genclient openapi.json > client/client.go && rm openapi.json"
+//go:generate buf generate
+
+// runCLI runs an offline subcommand of the bot binary
+func runCLI(args []string) {
+	switch args[0] {
+	case "openapi":
+		// Print the OpenAPI document for the HTTP API
+		out, err := json.MarshalIndent(openAPISpec(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding OpenAPI spec: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	case "reencrypt":
+		// Rewrap every stored secret with the active master key, after
+		// adding a new key to the front of STORE_MASTER_KEYS
+		cfg := loadCLIConfig()
+		if err := setupEncryption(cfg.Encryption); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+		if masterKeys == nil {
+			fmt.Fprintln(os.Stderr, "No master key configured; set STORE_MASTER_KEYS or encryption.provider")
+			os.Exit(1)
+		}
+		s, err := openStore(storePath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening store: %v\n", err)
+			os.Exit(1)
+		}
+		n, err := reencryptStore(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error re-encrypting store (%d secrets done): %v\n", n, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Rewrapped %d secrets with key %s\n", n, masterKeys.ActiveKeyID())
+	case "import":
+		// Translate another bot's scripts into triggers and schedules
+		runImport(args[1:])
+	case "index":
+		// Add documents to the knowledge base /ask answers from
+		runIndex(args[1:])
+	case "backup":
+		runBackup(args[1:])
+	case "restore":
+		runRestore(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Run without arguments to start the bot.\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// loadCLIConfig loads .env and the config file for an offline subcommand,
+// exiting if the config is broken
+func loadCLIConfig() *Config {
+	godotenv.Load()
+	cfg, err := loadConfig(configPathFromEnv(), os.Getenv("BOT_PROFILE"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	appConfig = cfg
+	return cfg
+}
+
+// runImport runs `import --from hubot|errbot|workflow [--dry-run]
+// [--overwrite] <path>`
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	from := flags.String("from", "", "what to import: hubot (a scripts directory), errbot (a plugins directory) or workflow (Workflow Builder export files)")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without saving it")
+	overwrite := flags.Bool("overwrite", false, "replace triggers and schedules that already exist")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: slack-bot import --from hubot|errbot|workflow [--dry-run] [--overwrite] <path>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *from == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	loadCLIConfig()
+	result, err := importSource(*from, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing: %v\n", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		for _, trigger := range result.Triggers {
+			fmt.Printf("would import trigger %s: /%s/ => %q\n", trigger.Name, trigger.Regex, trigger.Response)
+		}
+		for _, post := range result.Schedules {
+			fmt.Printf("would import schedule %s: %q in %s => %q\n", post.Name, post.Cron, post.Channel, post.Text)
+		}
+	} else {
+		s, err := openStore(storePath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening store: %v\n", err)
+			os.Exit(1)
+		}
+		lines, err := saveImport(s, result, *overwrite)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving import: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("couldn't translate %s\n", skipped)
+	}
+	fmt.Printf("%d triggers, %d schedules, %d not translated\n", len(result.Triggers), len(result.Schedules), len(result.Skipped))
+}
+
+// runIndex runs `index [--base-url <url>] <path or URL>...`
+func runIndex(args []string) {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	baseURL := flags.String("base-url", "", "where the files are published, to link to them in answers; a file's link is this plus its path without the extension")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: slack-bot index [--base-url <url>] <file, directory or URL>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := loadCLIConfig()
+	if err := setupKnowledgeBase(cfg.Knowledge); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if knowledgeBase == nil {
+		fmt.Fprintln(os.Stderr, "No knowledge base configured; set knowledge.vector_store")
+		os.Exit(1)
+	}
+	s, err := openStore(storePath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening store: %v\n", err)
+		os.Exit(1)
+	}
+	store = s
+
+	failed, total := 0, 0
+	for _, location := range flags.Args() {
+		docs, err := loadKnowledgeDocuments(location, *baseURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", location, err)
+			failed++
+			continue
+		}
+		for _, doc := range docs {
+			n, err := indexDocument(doc)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error indexing %s: %v\n", doc.Source, err)
+				failed++
+				continue
+			}
+			fmt.Printf("indexed %s (%q): %d passages\n", doc.Source, doc.Title, n)
+			total++
+		}
+	}
+	fmt.Printf("%d documents indexed, %d failed\n", total, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadBackupCLIConfig loads the config for a backup subcommand and sets up
+// the backup target and, for checking secrets, the master key
+func loadBackupCLIConfig() {
+	cfg := loadCLIConfig()
+	if err := setupEncryption(cfg.Encryption); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupBackups(cfg.Backups); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runBackup runs `backup [list | check <backup>]`. Without arguments it
+// backs up the store now.
+func runBackup(args []string) {
+	valid := len(args) == 0 || len(args) == 1 && args[0] == "list" || len(args) == 2 && args[0] == "check"
+	if !valid {
+		fmt.Fprintln(os.Stderr, "Usage: slack-bot backup [list | check <backup>]")
+		os.Exit(2)
+	}
+	loadBackupCLIConfig()
+	switch {
+	case len(args) == 0:
+		s, err := openStore(storePath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening store: %v\n", err)
+			os.Exit(1)
+		}
+		name, err := takeBackup(s)
+		if name != "" {
+			fmt.Printf("Backed up %s to %s\n", storePath(), name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error backing up store: %v\n", err)
+			os.Exit(1)
+		}
+	case args[0] == "list":
+		names, err := backups.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing backups: %v\n", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	default:
+		doc, err := readBackup(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		check, err := checkBackup(doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Backup %s failed its integrity check: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		printBackupCheck(doc, check)
+	}
+}
+
+// runRestore runs `restore <backup>`, replacing the store with a backup
+// after checking it
+func runRestore(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: slack-bot restore <backup>")
+		fmt.Fprintln(os.Stderr, "Stop the bot first; a running bot writes its own data back over the restored store.")
+		os.Exit(2)
+	}
+	loadBackupCLIConfig()
+	doc, err := readBackup(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	check, err := checkBackup(doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Not restoring %s, it failed its integrity check: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	printBackupCheck(doc, check)
+	if err := restoreBackup(doc, storePath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error restoring backup: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Restored %s to %s; the previous store is in %s.before-restore\n", args[0], storePath(), storePath())
+}
+
+func printBackupCheck(doc backupFile, check backupCheck) {
+	secrets := fmt.Sprintf("%d secrets", check.Secrets)
+	if check.Secrets > 0 && masterKeys == nil {
+		secrets += " (not checked, no master key configured)"
+	}
+	fmt.Printf("Backup from %s: %d buckets, %d keys, %s\n", doc.Created.Format(time.RFC3339), check.Buckets, check.Keys, secrets)
+}
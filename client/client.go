@@ -0,0 +1,328 @@
+// Code generated by tools/genclient from the bot's OpenAPI spec (API version 1.0.0). DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// AdminAuditEntry is a schema of the bot API.
+type AdminAuditEntry struct {
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail"`
+	Role   string    `json:"role"`
+	UserID string    `json:"user_id"`
+}
+
+// ApplyChange is a schema of the bot API.
+type ApplyChange struct {
+	Action string `json:"action"`
+	After  any    `json:"after,omitempty"`
+	Before any    `json:"before,omitempty"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+}
+
+// ApplyRequest is a schema of the bot API.
+type ApplyRequest struct {
+	DryRun bool             `json:"dry_run,omitempty"`
+	Kinds  map[string][]any `json:"kinds"`
+}
+
+// ApplyResponse is a schema of the bot API.
+type ApplyResponse struct {
+	Changes   []ApplyChange `json:"changes"`
+	DryRun    bool          `json:"dry_run"`
+	Unchanged int64         `json:"unchanged"`
+}
+
+// ConversationExport is a schema of the bot API.
+type ConversationExport struct {
+	Channel     string            `json:"channel"`
+	ChannelName string            `json:"channel_name"`
+	ExportedAt  time.Time         `json:"exported_at"`
+	ExportedBy  string            `json:"exported_by"`
+	Messages    []ExportedMessage `json:"messages"`
+	Since       time.Time         `json:"since,omitempty"`
+	ThreadTS    string            `json:"thread_ts,omitempty"`
+	Truncated   bool              `json:"truncated,omitempty"`
+}
+
+// DeliveryRecord is a schema of the bot API.
+type DeliveryRecord struct {
+	At         time.Time `json:"at"`
+	Channel    string    `json:"channel"`
+	Error      string    `json:"error,omitempty"`
+	Importance string    `json:"importance,omitempty"`
+	Route      string    `json:"route"`
+	Text       string    `json:"text"`
+	ThreadTS   string    `json:"thread_ts,omitempty"`
+	TS         string    `json:"ts,omitempty"`
+}
+
+// DeploymentRequest is a schema of the bot API.
+type DeploymentRequest struct {
+	Description string `json:"description,omitempty"`
+	Env         string `json:"env"`
+	Service     string `json:"service"`
+	Status      string `json:"status,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Version     string `json:"version"`
+}
+
+// DeploymentResponse is a schema of the bot API.
+type DeploymentResponse struct {
+	Channel string `json:"channel"`
+	Status  string `json:"status"`
+	TS      string `json:"ts"`
+}
+
+// ErrorResponse is a schema of the bot API.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// ExportedMessage is a schema of the bot API.
+type ExportedMessage struct {
+	Files    []string          `json:"files,omitempty"`
+	Replies  []ExportedMessage `json:"replies,omitempty"`
+	Text     string            `json:"text"`
+	Time     time.Time         `json:"time"`
+	TS       string            `json:"ts"`
+	User     string            `json:"user,omitempty"`
+	UserName string            `json:"user_name"`
+}
+
+// MeResponse is a schema of the bot API.
+type MeResponse struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	TokenID string   `json:"token_id"`
+	UserID  string   `json:"user_id"`
+}
+
+// Reminder is a schema of the bot API.
+type Reminder struct {
+	Channel string    `json:"channel,omitempty"`
+	Every   string    `json:"every,omitempty"`
+	ID      string    `json:"id"`
+	Next    time.Time `json:"next"`
+	Text    string    `json:"text"`
+}
+
+// RemindersResponse is a schema of the bot API.
+type RemindersResponse struct {
+	Reminders []Reminder `json:"reminders"`
+}
+
+// SlackMethodStats is a schema of the bot API.
+type SlackMethodStats struct {
+	Calls       int64  `json:"calls"`
+	Method      string `json:"method"`
+	Queued      int64  `json:"queued"`
+	RateLimited int64  `json:"rate_limited"`
+	Shed        int64  `json:"shed"`
+	Tier        int64  `json:"tier"`
+	WaitMs      int64  `json:"wait_ms"`
+}
+
+// UsageCount is a schema of the bot API.
+type UsageCount struct {
+	Count    int64  `json:"count"`
+	Errors   int64  `json:"errors,omitempty"`
+	Name     string `json:"name"`
+	Previous int64  `json:"previous,omitempty"`
+}
+
+// UsageQuota is a schema of the bot API.
+type UsageQuota struct {
+	Budget int64  `json:"budget,omitempty"`
+	Name   string `json:"name"`
+	Used   int64  `json:"used"`
+}
+
+// UsageReport is a schema of the bot API.
+type UsageReport struct {
+	ActiveUsers         int64            `json:"active_users"`
+	CommandErrorPct     float64          `json:"command_error_pct"`
+	CommandErrors       int64            `json:"command_errors"`
+	Commands            []UsageCount     `json:"commands"`
+	Days                []UsageReportDay `json:"days"`
+	Features            []UsageCount     `json:"features"`
+	Month               string           `json:"month"`
+	PreviousActiveUsers int64            `json:"previous_active_users"`
+	Quotas              []UsageQuota     `json:"quotas"`
+	Requests            int64            `json:"requests"`
+	ServerErrorPct      float64          `json:"server_error_pct"`
+	ServerErrors        int64            `json:"server_errors"`
+}
+
+// UsageReportDay is a schema of the bot API.
+type UsageReportDay struct {
+	ActiveUsers   int64  `json:"active_users"`
+	CommandErrors int64  `json:"command_errors"`
+	Commands      int64  `json:"commands"`
+	Date          string `json:"date"`
+}
+
+// Apply: Reconcile bot configuration with a declarative document
+//
+// Requires a token with the `admin` scope.
+func (c *Client) Apply(ctx context.Context, body ApplyRequest) (*ApplyResponse, error) {
+	out := new(ApplyResponse)
+	if err := c.do(ctx, "POST", "/apply", nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExportConversationParams holds the query parameters of ExportConversation.
+type ExportConversationParams struct {
+	// Channel ID
+	Channel string
+	// Timestamp of a thread's first message; exports only that thread
+	Thread string
+	// Only messages after this, like 7d or 2024-01-31 (channel exports)
+	Since string
+	// json (default), markdown or html
+	Format string
+}
+
+// ExportConversation: Export a channel's history or one thread, with user names resolved
+//
+// Requires a token with the `admin` scope, or `audit:read` for auditors.
+func (c *Client) ExportConversation(ctx context.Context, params ExportConversationParams) (*ConversationExport, error) {
+	query := url.Values{}
+	if params.Channel != "" {
+		query.Set("channel", params.Channel)
+	}
+	if params.Thread != "" {
+		query.Set("thread", params.Thread)
+	}
+	if params.Since != "" {
+		query.Set("since", params.Since)
+	}
+	if params.Format != "" {
+		query.Set("format", params.Format)
+	}
+	out := new(ConversationExport)
+	if err := c.do(ctx, "GET", "/admin/exports", query, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetMe: Describe the calling user and token
+//
+// Requires a token with the `profile:read` scope.
+func (c *Client) GetMe(ctx context.Context) (*MeResponse, error) {
+	out := new(MeResponse)
+	if err := c.do(ctx, "GET", "/me", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetUsageReportParams holds the query parameters of GetUsageReport.
+type GetUsageReportParams struct {
+	// Month as YYYY-MM, in UTC; defaults to the current month
+	Month string
+}
+
+// GetUsageReport: Summarize bot usage for a month
+//
+// Requires a token with the `admin` scope, or `audit:read` for auditors.
+func (c *Client) GetUsageReport(ctx context.Context, params GetUsageReportParams) (*UsageReport, error) {
+	query := url.Values{}
+	if params.Month != "" {
+		query.Set("month", params.Month)
+	}
+	out := new(UsageReport)
+	if err := c.do(ctx, "GET", "/admin/usage", query, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListAdminAuditLogParams holds the query parameters of ListAdminAuditLog.
+type ListAdminAuditLogParams struct {
+	// Only entries by this user ID
+	User string
+	// How many entries to return (default 100)
+	Limit string
+}
+
+// ListAdminAuditLog: List admin changes and auditor access, newest first
+//
+// Requires a token with the `admin` scope, or `audit:read` for auditors.
+func (c *Client) ListAdminAuditLog(ctx context.Context, params ListAdminAuditLogParams) ([]AdminAuditEntry, error) {
+	query := url.Values{}
+	if params.User != "" {
+		query.Set("user", params.User)
+	}
+	if params.Limit != "" {
+		query.Set("limit", params.Limit)
+	}
+	var out []AdminAuditEntry
+	if err := c.do(ctx, "GET", "/admin/audit-log", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListDeliveriesParams holds the query parameters of ListDeliveries.
+type ListDeliveriesParams struct {
+	// Only messages to this channel ID
+	Channel string
+}
+
+// ListDeliveries: List the bot's recent messages and what routing did with them, newest first
+//
+// Requires a token with the `admin` scope, or `audit:read` for auditors.
+func (c *Client) ListDeliveries(ctx context.Context, params ListDeliveriesParams) ([]DeliveryRecord, error) {
+	query := url.Values{}
+	if params.Channel != "" {
+		query.Set("channel", params.Channel)
+	}
+	var out []DeliveryRecord
+	if err := c.do(ctx, "GET", "/admin/deliveries", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListReminders: List the calling user's reminders
+//
+// Requires a token with the `reminders:read` scope.
+func (c *Client) ListReminders(ctx context.Context) (*RemindersResponse, error) {
+	out := new(RemindersResponse)
+	if err := c.do(ctx, "GET", "/reminders", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListSlackRateStats: List Slack API calls by method since the bot started, with how many waited, were dropped or were rate limited
+//
+// Requires a token with the `admin` scope, or `audit:read` for auditors.
+func (c *Client) ListSlackRateStats(ctx context.Context) ([]SlackMethodStats, error) {
+	var out []SlackMethodStats
+	if err := c.do(ctx, "GET", "/admin/slack-rate", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReportDeployment: Announce a deployment in the releases channel, or update its status
+//
+// Requires a token with the `deployments:write` scope.
+func (c *Client) ReportDeployment(ctx context.Context, body DeploymentRequest) (*DeploymentResponse, error) {
+	out := new(DeploymentResponse)
+	if err := c.do(ctx, "POST", "/deployments", nil, body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
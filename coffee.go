@@ -0,0 +1,538 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store buckets for coffee pairing: each channel's rounds keyed by channel
+// ID, and people sitting rounds out keyed by user ID
+const (
+	coffeeBucket       = "coffee"
+	coffeePausedBucket = "coffee_paused"
+)
+
+// Action ID of the button a group clicks once they've met
+const actionCoffeeMet = "coffee_met"
+
+// Used when coffee.schedule and coffee.group_size aren't set
+const (
+	defaultCoffeeSchedule  = "0 10 * * mon"
+	defaultCoffeeGroupSize = 2
+)
+
+// How many shuffles a round tries, keeping the one with the fewest repeats
+const coffeeShuffles = 200
+
+// Pairs who met this many rounds ago or longer count as a repeat just once
+const coffeeRepeatWindow = 8
+
+// coffeeGroup is people matched for one round
+type coffeeGroup struct {
+	Members []string `json:"members"`
+	// Conversation is the group DM they were introduced in
+	Conversation string `json:"conversation,omitempty"`
+	Met          bool   `json:"met,omitempty"`
+}
+
+// coffeeChannel is a channel's pairing rounds
+type coffeeChannel struct {
+	Channel string `json:"channel"`
+	Round   int    `json:"round"`
+	// StartedAt is when the current round started, or when pairing was set
+	// up before the first
+	StartedAt time.Time     `json:"started_at"`
+	Nudged    bool          `json:"nudged,omitempty"`
+	Groups    []coffeeGroup `json:"groups,omitempty"`
+	// LastMet is the round each pair was last matched in, keyed by
+	// coffeePairKey
+	LastMet map[string]int `json:"last_met,omitempty"`
+}
+
+// coffeePause marks someone sitting pairing rounds out
+type coffeePause struct {
+	At time.Time `json:"at"`
+}
+
+// Serializes rounds and clicks, which read and rewrite a channel's rounds
+var coffeeMu sync.Mutex
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "coffee",
+		Usage:       "coffee [pause|resume]",
+		Description: "See who you're paired with for coffee, or sit the rounds out for a while",
+		Handler:     handleCoffeeCommand,
+	})
+	registerBotCommand(&command{
+		Name:        "admin coffee pair",
+		Usage:       "admin coffee pair [#channel]",
+		Description: "Start a new coffee pairing round now",
+		AdminOnly:   true,
+		Handler:     handleCoffeePairNow,
+	})
+	registerBlockAction(actionCoffeeMet, handleCoffeeMet)
+	registerUserDataset(userDataset{Name: "coffee", Title: "Coffee pairings", Describe: describeUserCoffee, Delete: deleteUserCoffee})
+}
+
+// startCoffee pairs the members of each coffee channel on coffee.schedule
+// and nudges them halfway through the round
+func startCoffee() {
+	if len(appConfig.Coffee.Channels) == 0 {
+		return
+	}
+	runEvery("coffee pairing", time.Minute, runDueCoffee)
+}
+
+// coffeeSchedule parses coffee.schedule and coffee.timezone
+func coffeeSchedule() (cronSchedule, *time.Location, error) {
+	cfg := appConfig.Coffee
+	expr := cfg.Schedule
+	if expr == "" {
+		expr = defaultCoffeeSchedule
+	}
+	cron, err := parseCron(expr)
+	if err != nil {
+		return cronSchedule{}, nil, fmt.Errorf("coffee.schedule: %w", err)
+	}
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return cronSchedule{}, nil, fmt.Errorf("coffee.timezone: %w", err)
+		}
+	}
+	return cron, loc, nil
+}
+
+// runDueCoffee starts rounds whose time has come and sends the nudges due
+func runDueCoffee() {
+	cron, loc, err := coffeeSchedule()
+	if err != nil {
+		log.Printf("Error scheduling coffee pairing: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, channel := range appConfig.Coffee.Channels {
+		state, err := loadCoffeeChannel(channel)
+		if err != nil {
+			log.Printf("Error loading coffee pairing for %s: %v", channel, err)
+			continue
+		}
+		if state.StartedAt.IsZero() {
+			// The first round waits for the schedule rather than starting
+			// the moment pairing is set up
+			state.StartedAt = now.UTC()
+			if err := saveCoffeeChannel(state); err != nil {
+				log.Printf("Error saving coffee pairing for %s: %v", channel, err)
+			}
+			continue
+		}
+		next := cron.next(state.StartedAt, loc)
+		if next.IsZero() {
+			continue
+		}
+		if !now.Before(next) {
+			if err := startCoffeeRound(channel); err != nil {
+				log.Printf("Error pairing %s for coffee: %v", channel, err)
+			}
+			continue
+		}
+		if state.Round > 0 && !state.Nudged && !now.Before(state.StartedAt.Add(next.Sub(state.StartedAt)/2)) {
+			nudgeCoffeeGroups(channel)
+		}
+	}
+}
+
+func loadCoffeeChannel(channel string) (*coffeeChannel, error) {
+	state := &coffeeChannel{Channel: channel}
+	if _, err := store.Get(coffeeBucket, channel, state); err != nil {
+		return nil, err
+	}
+	if state.LastMet == nil {
+		state.LastMet = map[string]int{}
+	}
+	return state, nil
+}
+
+func saveCoffeeChannel(state *coffeeChannel) error {
+	return store.Put(coffeeBucket, state.Channel, state)
+}
+
+// coffeePairKey identifies a pair the same way whichever way round
+func coffeePairKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "/" + b
+}
+
+// startCoffeeRound matches the channel's members into groups and
+// introduces each group in a group DM
+func startCoffeeRound(channel string) error {
+	members, err := coffeeMembers(channel)
+	if err != nil {
+		return err
+	}
+
+	coffeeMu.Lock()
+	state, err := loadCoffeeChannel(channel)
+	if err != nil {
+		coffeeMu.Unlock()
+		return err
+	}
+	state.Round++
+	state.StartedAt = time.Now().UTC()
+	state.Nudged = false
+	state.Groups = nil
+	for _, group := range matchCoffeeGroups(members, state.LastMet, state.Round) {
+		for i, a := range group {
+			for _, b := range group[i+1:] {
+				state.LastMet[coffeePairKey(a, b)] = state.Round
+			}
+		}
+		state.Groups = append(state.Groups, coffeeGroup{Members: group})
+	}
+	err = saveCoffeeChannel(state)
+	coffeeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(state.Groups) == 0 {
+		log.Printf("Coffee pairing: fewer than two people to pair in %s", channel)
+		return nil
+	}
+
+	for i, group := range state.Groups {
+		conversation, _, _, err := slackClient.OpenConversation(&slack.OpenConversationParameters{Users: group.Members})
+		if err != nil {
+			log.Printf("Error opening coffee group DM: %v", err)
+			continue
+		}
+		state.Groups[i].Conversation = conversation.ID
+		text := fmt.Sprintf(":coffee: Hi %s! You've been matched for a coffee chat from <#%s> this round. Find a time that works and say hello.", mentionList(group.Members), channel)
+		if _, err := sendMessage(outboundMessage{Channel: conversation.ID, Importance: importanceInfo, Text: text, Blocks: coffeeBlocks(text, channel)}); err != nil {
+			log.Printf("Error introducing coffee group: %v", err)
+		}
+	}
+
+	coffeeMu.Lock()
+	defer coffeeMu.Unlock()
+	current, err := loadCoffeeChannel(channel)
+	if err != nil || current.Round != state.Round {
+		return err
+	}
+	for i := range current.Groups {
+		current.Groups[i].Conversation = state.Groups[i].Conversation
+	}
+	log.Printf("Coffee pairing: round %d in %s matched %d groups", state.Round, channel, len(state.Groups))
+	return saveCoffeeChannel(current)
+}
+
+// coffeeMembers returns the people in channel who haven't paused pairing
+func coffeeMembers(channel string) ([]string, error) {
+	people, err := userDirectory.list()
+	if err != nil {
+		return nil, err
+	}
+	humans := map[string]bool{}
+	for _, entry := range people {
+		humans[entry.ID] = true
+	}
+	var members []string
+	params := &slack.GetUsersInConversationParameters{ChannelID: channel, Limit: 1000}
+	for {
+		page, cursor, err := slackClient.GetUsersInConversation(params)
+		if err != nil {
+			return nil, fmt.Errorf("listing members of %s: %w", channel, err)
+		}
+		for _, member := range page {
+			if !humans[member] {
+				continue
+			}
+			paused, err := store.Get(coffeePausedBucket, member, &coffeePause{})
+			if err != nil {
+				return nil, err
+			}
+			if !paused {
+				members = append(members, member)
+			}
+		}
+		if cursor == "" {
+			return members, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+// matchCoffeeGroups splits members into groups of coffee.group_size,
+// trying many shuffles and keeping the one that repeats the fewest recent
+// pairings. Leftovers join other groups, so pairs sometimes become triples.
+func matchCoffeeGroups(members []string, lastMet map[string]int, round int) [][]string {
+	size := appConfig.Coffee.GroupSize
+	if size < 2 {
+		size = defaultCoffeeGroupSize
+	}
+	if len(members) < 2 {
+		return nil
+	}
+	var best [][]string
+	bestScore := -1
+	shuffled := slices.Clone(members)
+	for range coffeeShuffles {
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		groups := splitCoffeeGroups(shuffled, size)
+		score := 0
+		for _, group := range groups {
+			for i, a := range group {
+				for _, b := range group[i+1:] {
+					if last, ok := lastMet[coffeePairKey(a, b)]; ok {
+						score += max(1, coffeeRepeatWindow-(round-last))
+					}
+				}
+			}
+		}
+		if bestScore < 0 || score < bestScore {
+			best, bestScore = groups, score
+		}
+		if score == 0 {
+			break
+		}
+	}
+	return best
+}
+
+// splitCoffeeGroups cuts members into groups of size, spreading any
+// leftovers over the groups
+func splitCoffeeGroups(members []string, size int) [][]string {
+	n := max(len(members)/size, 1)
+	groups := make([][]string, n)
+	for i, member := range members {
+		groups[i%n] = append(groups[i%n], member)
+	}
+	return groups
+}
+
+// nudgeCoffeeGroups reminds the groups that haven't met yet
+func nudgeCoffeeGroups(channel string) {
+	coffeeMu.Lock()
+	state, err := loadCoffeeChannel(channel)
+	if err == nil {
+		state.Nudged = true
+		err = saveCoffeeChannel(state)
+	}
+	coffeeMu.Unlock()
+	if err != nil {
+		log.Printf("Error saving coffee pairing for %s: %v", channel, err)
+		return
+	}
+	for _, group := range state.Groups {
+		if group.Met || group.Conversation == "" {
+			continue
+		}
+		text := ":wave: Halfway through this round! Have you found a time for your coffee chat yet?"
+		if _, err := sendMessage(outboundMessage{Channel: group.Conversation, Importance: importanceInfo, Text: text, Blocks: coffeeBlocks(text, channel)}); err != nil {
+			log.Printf("Error nudging coffee group: %v", err)
+		}
+	}
+}
+
+func coffeeBlocks(text, channel string) []slack.Block {
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(actionCoffeeMet, channel, slack.NewTextBlockObject(slack.PlainTextType, "We've met", false, false)).WithStyle(slack.StylePrimary),
+		),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("Matched because you're in <#%s>. `%s coffee pause` sits the next rounds out.", channel, botCommand), false, false)),
+	}
+}
+
+// handleCoffeeMet records that a group met, so they aren't nudged
+func handleCoffeeMet(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	coffeeMu.Lock()
+	state, err := loadCoffeeChannel(action.Value)
+	found := false
+	if err == nil {
+		for i, group := range state.Groups {
+			if group.Conversation == callback.Channel.ID && slices.Contains(group.Members, callback.User.ID) {
+				state.Groups[i].Met, found = true, true
+			}
+		}
+		if found {
+			err = saveCoffeeChannel(state)
+		}
+	}
+	coffeeMu.Unlock()
+	if err != nil {
+		log.Printf("Error recording coffee chat: %v", err)
+		return
+	}
+	if !found {
+		pollReply(callback, "This round is over. Look out for your next match!")
+		return
+	}
+	reply := &slack.WebhookMessage{ReplaceOriginal: true, Text: fmt.Sprintf(":coffee: <@%s> says you've met. Thanks for taking the time!", callback.User.ID)}
+	if err := respond(callback.ResponseURL, reply); err != nil {
+		log.Printf("Error updating coffee message: %v", err)
+	}
+}
+
+func handleCoffeeCommand(req commandRequest) commandResponse {
+	if len(appConfig.Coffee.Channels) == 0 {
+		return ephemeral("Coffee pairing isn't set up. See `coffee` in the config.")
+	}
+	switch strings.ToLower(strings.Join(req.Args, " ")) {
+	case "pause":
+		if err := store.Put(coffeePausedBucket, req.UserID, coffeePause{At: time.Now().UTC()}); err != nil {
+			log.Printf("Error pausing coffee pairing: %v", err)
+			return ephemeral("Sorry, something went wrong pausing your pairings.")
+		}
+		return ephemeral(":pause_button: You'll sit the coffee rounds out until you run `%s coffee resume`.", botCommand)
+	case "resume":
+		if err := store.Delete(coffeePausedBucket, req.UserID); err != nil {
+			log.Printf("Error resuming coffee pairing: %v", err)
+			return ephemeral("Sorry, something went wrong resuming your pairings.")
+		}
+		return ephemeral(":coffee: You're back in the next coffee round.")
+	case "":
+	default:
+		return ephemeral("Usage: `%s coffee [pause|resume]`", botCommand)
+	}
+
+	var lines []string
+	for _, channel := range appConfig.Coffee.Channels {
+		state, err := loadCoffeeChannel(channel)
+		if err != nil {
+			log.Printf("Error loading coffee pairing for %s: %v", channel, err)
+			return ephemeral("Sorry, something went wrong looking up your match.")
+		}
+		for _, group := range state.Groups {
+			if !slices.Contains(group.Members, req.UserID) {
+				continue
+			}
+			others := slices.DeleteFunc(slices.Clone(group.Members), func(id string) bool { return id == req.UserID })
+			line := fmt.Sprintf("• <#%s>: %s", channel, mentionList(others))
+			if group.Met {
+				line += " (met)"
+			}
+			lines = append(lines, line)
+		}
+	}
+	paused, err := store.Get(coffeePausedBucket, req.UserID, &coffeePause{})
+	if err != nil {
+		log.Printf("Error reading coffee pause: %v", err)
+	}
+	note := ""
+	if paused {
+		note = fmt.Sprintf("\nYou're sitting the next rounds out; `%s coffee resume` brings you back.", botCommand)
+	}
+	if len(lines) == 0 {
+		channels := make([]string, len(appConfig.Coffee.Channels))
+		for i, channel := range appConfig.Coffee.Channels {
+			channels[i] = "<#" + channel + ">"
+		}
+		return ephemeral("You haven't been matched this round. Join %s to be paired for coffee.%s", strings.Join(channels, " or "), note)
+	}
+	return ephemeral(":coffee: *Your coffee matches this round*\n%s%s", strings.Join(lines, "\n"), note)
+}
+
+func handleCoffeePairNow(req commandRequest) commandResponse {
+	channels := appConfig.Coffee.Channels
+	if len(req.Args) > 0 {
+		m := channelMentionPattern.FindStringSubmatch(req.Args[0])
+		if m == nil || !slices.Contains(channels, m[1]) {
+			return ephemeral("Say which coffee channel to pair, like #coffee. They're set by `coffee.channels` in the config.")
+		}
+		channels = []string{m[1]}
+	}
+	if len(channels) == 0 {
+		return ephemeral("Coffee pairing isn't set up. See `coffee` in the config.")
+	}
+	recordAdminAudit(req.UserID, roleAdmin, "coffee", "started a pairing round in "+strings.Join(channels, ", "))
+	go runJob("coffee pairing", func() {
+		for _, channel := range channels {
+			if err := startCoffeeRound(channel); err != nil {
+				log.Printf("Error pairing %s for coffee: %v", channel, err)
+			}
+		}
+	})
+	return ephemeral(":coffee: Starting a new pairing round. Everyone will get an intro in a group DM.")
+}
+
+// mentionList mentions users as "<@a>, <@b> and <@c>"
+func mentionList(users []string) string {
+	mentions := make([]string, len(users))
+	for i, user := range users {
+		mentions[i] = "<@" + user + ">"
+	}
+	if len(mentions) < 2 {
+		return strings.Join(mentions, "")
+	}
+	return strings.Join(mentions[:len(mentions)-1], ", ") + " and " + mentions[len(mentions)-1]
+}
+
+func describeUserCoffee(userID string) (string, error) {
+	var items []string
+	paused, err := store.Get(coffeePausedBucket, userID, &coffeePause{})
+	if err != nil {
+		return "", err
+	}
+	if paused {
+		items = append(items, "Sitting coffee rounds out")
+	}
+	for _, channel := range store.Keys(coffeeBucket) {
+		state, err := loadCoffeeChannel(channel)
+		if err != nil {
+			return "", err
+		}
+		pairs := 0
+		for key := range state.LastMet {
+			a, b, _ := strings.Cut(key, "/")
+			if a == userID || b == userID {
+				pairs++
+			}
+		}
+		if pairs > 0 {
+			items = append(items, fmt.Sprintf("Matches with %d people remembered from <#%s>", pairs, channel))
+		}
+	}
+	sort.Strings(items)
+	return describeItems(items), nil
+}
+
+// deleteUserCoffee forgets userID's pause and who they've been matched with,
+// and takes them out of the current rounds
+func deleteUserCoffee(userID string) error {
+	if err := store.Delete(coffeePausedBucket, userID); err != nil {
+		return err
+	}
+	coffeeMu.Lock()
+	defer coffeeMu.Unlock()
+	for _, channel := range store.Keys(coffeeBucket) {
+		state, err := loadCoffeeChannel(channel)
+		if err != nil {
+			return err
+		}
+		for key := range state.LastMet {
+			a, b, _ := strings.Cut(key, "/")
+			if a == userID || b == userID {
+				delete(state.LastMet, key)
+			}
+		}
+		for i := range state.Groups {
+			state.Groups[i].Members = slices.DeleteFunc(state.Groups[i].Members, func(id string) bool { return id == userID })
+		}
+		if err := saveCoffeeChannel(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
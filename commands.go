@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// The slash command that hosts the bot's subcommands, e.g. /bot admin audit
+const botCommand = "/bot"
+
+// Matches a user mention such as <@U123|ana>
+var userMentionPattern = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(?:\|[^>]*)?>$`)
+
+// commandRequest is a parsed slash command invocation
+type commandRequest struct {
+	slack.SlashCommand
+	// Args are the words after the command (and subcommand, for /bot)
+	Args []string
+	// ThreadTS is the thread a command typed in a mention or DM came
+	// from, for answers posted after the handler returns
+	ThreadTS string
+}
+
+// commandResponse is sent back to the user who ran the command
+type commandResponse struct {
+	Text   string
+	Blocks []slack.Block
+	// InChannel makes the response visible to the whole channel
+	InChannel bool
+	// Result and Fields are what a chained command can pass to the next
+	// one, as {result} and {result.field}
+	Result string
+	Fields map[string]string
+}
+
+// commandHandler runs a command and returns the reply for the user
+type commandHandler func(req commandRequest) commandResponse
+
+// command describes a slash command or a /bot subcommand
+type command struct {
+	// Name is the slash command ("/poll") or the /bot subcommand words
+	// ("admin audit")
+	Name        string
+	Usage       string
+	Description string
+	AdminOnly   bool
+	Handler     commandHandler
+}
+
+var (
+	// Slash commands other than /bot, keyed by command
+	slashCommands = map[string]*command{}
+	// /bot subcommands, keyed by their words
+	botCommands = map[string]*command{}
+)
+
+// registerSlashCommand handles a top-level slash command
+func registerSlashCommand(cmd *command) {
+	slashCommands[cmd.Name] = cmd
+}
+
+// registerBotCommand handles a /bot subcommand
+func registerBotCommand(cmd *command) {
+	botCommands[cmd.Name] = cmd
+}
+
+// ephemeral builds a private reply to the user who ran a command
+func ephemeral(format string, args ...any) commandResponse {
+	return commandResponse{Text: fmt.Sprintf(format, args...)}
+}
+
+// parseQuotedArgs splits text into words, keeping "quoted phrases"
+// together. Slack clients often turn straight quotes into curly ones, so
+// those count too.
+func parseQuotedArgs(text string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, started := false, false
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			inQuotes = !inQuotes
+			started = true
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// handleSlackCommands dispatches slash commands to their registered handler
+func handleSlackCommands(c *gin.Context) {
+	slash, err := slack.SlashCommandParse(c.Request)
+	if err != nil {
+		log.Printf("Error parsing slash command: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slash command"})
+		return
+	}
+
+	var resp commandResponse
+	words := strings.Fields(slash.Text)
+	if slash.Command == botCommand {
+		resp = dispatchBotCommand(slash, "", words)
+	} else if cmd, ok := slashCommands[slash.Command]; ok {
+		resp = runCommand(cmd, commandRequest{SlashCommand: slash, Args: words})
+	} else {
+		resp = ephemeral("Sorry, I don't know %s.", slash.Command)
+	}
+
+	text, err := withFallbackText(resp.Text, resp.Blocks)
+	if err != nil {
+		log.Printf("Error responding to %s: %v", slash.Command, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	text, blocks := withBanner(text, resp.Blocks)
+	msg := slack.Msg{Text: text, ResponseType: slack.ResponseTypeEphemeral}
+	if resp.InChannel {
+		msg.ResponseType = slack.ResponseTypeInChannel
+	}
+	if len(blocks) > 0 {
+		msg.Blocks = slack.Blocks{BlockSet: fitResponseBlocks(blocks)}
+	}
+	msg.Text, _ = truncateText(msg.Text, maxMessageText)
+	c.JSON(http.StatusOK, msg)
+}
+
+// dispatchBotCommand finds the /bot subcommand with the longest matching
+// name, running each one in turn when they're chained with |
+func dispatchBotCommand(slash slack.SlashCommand, threadTS string, words []string) commandResponse {
+	if stages := splitPipeline(words); len(stages) > 1 {
+		return runPipeline(stages, func(words []string) commandResponse {
+			return dispatchBotCommand(slash, threadTS, words)
+		})
+	}
+	if cmd, args := matchBotCommand(words); cmd != nil {
+		return runCommand(cmd, commandRequest{SlashCommand: slash, Args: args, ThreadTS: threadTS})
+	}
+
+	var names []string
+	for name, cmd := range botCommands {
+		if !cmd.AdminOnly || isAdmin(slash.UserID) {
+			names = append(names, "`"+botCommand+" "+name+"`")
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ephemeral("I don't have any commands for you yet.")
+	}
+	return ephemeral("Available commands: %s. Try `%s help` to see what they do.", strings.Join(names, ", "), botCommand)
+}
+
+// matchBotCommand finds the /bot subcommand whose name is the longest
+// prefix of words, returning it and the remaining words
+func matchBotCommand(words []string) (*command, []string) {
+	for n := len(words); n > 0; n-- {
+		name := strings.ToLower(strings.Join(words[:n], " "))
+		if cmd, ok := botCommands[name]; ok {
+			return cmd, words[n:]
+		}
+	}
+	return nil, nil
+}
+
+// runCommandLine runs a full command line such as "/bot kudos top" or
+// "/poll ..." as if slash.UserID had typed it in slash.ChannelID. It
+// reports false if the line isn't a known command.
+func runCommandLine(slash slack.SlashCommand, line string) (commandResponse, bool) {
+	name, text, _ := strings.Cut(strings.TrimSpace(line), " ")
+	slash.Command, slash.Text = name, text
+	words := strings.Fields(text)
+	if name == botCommand {
+		return dispatchBotCommand(slash, "", words), true
+	}
+	cmd, ok := slashCommands[name]
+	if !ok {
+		return commandResponse{}, false
+	}
+	return runCommand(cmd, commandRequest{SlashCommand: slash, Args: words}), true
+}
+
+// runCommand checks permissions and runs cmd
+func runCommand(cmd *command, req commandRequest) commandResponse {
+	if cmd.AdminOnly && !isAdmin(req.UserID) {
+		return ephemeral("Sorry, only bot admins can use that command.")
+	}
+	if cmd.AdminOnly {
+		recordAdminAudit(req.UserID, roleAdmin, "command", cmd.Name)
+	}
+	publishEvent(busEvent{
+		Type:    busCommandExecuted,
+		User:    req.UserID,
+		Channel: req.ChannelID,
+		Data:    busCommand{Command: cmd.Name, Args: req.Args},
+	})
+	start := time.Now()
+	resp := cmd.Handler(req)
+	recordLatency(metricCommand, time.Since(start))
+	recordCommandUsage(cmd.Name, req.UserID, strings.HasPrefix(resp.Text, commandErrorPrefix))
+	return resp
+}
+
+// isAdmin reports whether userID may use admin commands: configured bot
+// admins plus workspace admins and owners
+func isAdmin(userID string) bool {
+	for _, admin := range appConfig.Admins {
+		if admin == userID {
+			return true
+		}
+	}
+	user, err := slackClient.GetUserInfo(userID)
+	if err != nil {
+		log.Printf("Error looking up user %s: %v", userID, err)
+		return false
+	}
+	return user.IsAdmin || user.IsOwner
+}
@@ -0,0 +1,991 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Global bot configuration, loaded once at startup
+var appConfig = &Config{}
+
+// Config holds the behaviour that can be changed without touching code
+type Config struct {
+	// Admins are user IDs allowed to run admin commands, in addition to
+	// workspace admins and owners
+	Admins []string `yaml:"admins"`
+	// Auditors are user IDs who can look at settings, the admin audit log
+	// and delivery history in the web UI and API, but not change anything
+	Auditors []string `yaml:"auditors"`
+
+	// Profile selects one of Profiles; BOT_PROFILE overrides it
+	Profile string `yaml:"profile"`
+	// Profiles hold per-environment overrides of any of these settings
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+	// Banner labels every message, e.g. "STAGING"; defaults to the profile
+	// name outside production
+	Banner string `yaml:"banner"`
+	// Slack names the environment variables holding the Slack app's
+	// credentials, so each profile can use its own app
+	Slack SlackConfig `yaml:"slack"`
+	// StorePath is where the store is kept; STORE_PATH overrides it
+	StorePath string `yaml:"store_path"`
+
+	Onboarding  OnboardingConfig  `yaml:"onboarding"`
+	Home        HomeConfig        `yaml:"home"`
+	Unfurl      UnfurlConfig      `yaml:"unfurl"`
+	Greetings   GreetingsConfig   `yaml:"greetings"`
+	Audit       AuditConfig       `yaml:"audit"`
+	Files       FilesConfig       `yaml:"files"`
+	ChannelFeed ChannelFeedConfig `yaml:"channel_feed"`
+	EmojiFeed   EmojiFeedConfig   `yaml:"emoji_feed"`
+	API         APIConfig         `yaml:"api"`
+	Web         WebConfig         `yaml:"web"`
+	Pins        PinsConfig        `yaml:"pins"`
+	GRPC        GRPCConfig        `yaml:"grpc"`
+	Triggers    []Trigger         `yaml:"triggers"`
+	EventBus    EventBusConfig    `yaml:"event_bus"`
+	Replies     RepliesConfig     `yaml:"replies"`
+	Feedback    FeedbackConfig    `yaml:"feedback"`
+	Experiments []Experiment      `yaml:"experiments"`
+	SLOs        SLOConfig         `yaml:"slos"`
+	Encryption  EncryptionConfig  `yaml:"encryption"`
+	Usergroups  UsergroupsConfig  `yaml:"usergroups"`
+	Archives    ArchivesConfig    `yaml:"archives"`
+	Network     NetworkConfig     `yaml:"network"`
+	DND         DNDConfig         `yaml:"dnd"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Kudos       KudosConfig       `yaml:"kudos"`
+	Intents     IntentsConfig     `yaml:"intents"`
+	LLM         LLMConfig         `yaml:"llm"`
+	SendWindows SendWindowsConfig `yaml:"send_windows"`
+	Standups    []StandupConfig   `yaml:"standups"`
+	Routing     RoutingConfig     `yaml:"routing"`
+	Schedules   []ScheduledPost   `yaml:"schedules"`
+	UsageReport UsageReportConfig `yaml:"usage_report"`
+	FAQ         FAQConfig         `yaml:"faq"`
+	Translate   TranslateConfig   `yaml:"translate"`
+	Backups     BackupConfig      `yaml:"backups"`
+	Failover    FailoverConfig    `yaml:"failover"`
+	Knowledge   KnowledgeConfig   `yaml:"knowledge"`
+	SlackRate   SlackRateConfig   `yaml:"slack_rate"`
+	Incidents   IncidentsConfig   `yaml:"incidents"`
+	Exports     ExportsConfig     `yaml:"exports"`
+	Oncall      OncallConfig      `yaml:"oncall"`
+	Watchdog    WatchdogConfig    `yaml:"watchdog"`
+	Coffee      CoffeeConfig      `yaml:"coffee"`
+	Lunch       LunchConfig       `yaml:"lunch"`
+
+	Celebrations CelebrationsConfig `yaml:"celebrations"`
+	Trivia       TriviaConfig       `yaml:"trivia"`
+	Retro        RetroConfig        `yaml:"retro"`
+	RSVP         RSVPConfig         `yaml:"rsvp"`
+	WeeklyDigest WeeklyDigestConfig `yaml:"weekly_digest"`
+	OOO          OOOConfig          `yaml:"ooo"`
+	Expenses     ExpensesConfig     `yaml:"expenses"`
+	Jira         JiraConfig         `yaml:"jira"`
+	GitHub       GitHubConfig       `yaml:"github"`
+	PRReminders  PRRemindersConfig  `yaml:"pr_reminders"`
+	Reviewers    ReviewersConfig    `yaml:"reviewers"`
+	Deployments  DeploymentsConfig  `yaml:"deployments"`
+	Releases     ReleasesConfig     `yaml:"releases"`
+	Prices       PricesConfig       `yaml:"prices"`
+	Weather      WeatherConfig      `yaml:"weather"`
+	Convert      ConvertConfig      `yaml:"convert"`
+	Giphy        GiphyConfig        `yaml:"giphy"`
+}
+
+// SlackConfig selects the Slack app credentials to use
+type SlackConfig struct {
+	// BotTokenEnv defaults to SLACK_BOT_TOKEN
+	BotTokenEnv string `yaml:"bot_token_env"`
+	// SigningSecretEnv defaults to SLACK_SIGNING_SECRET
+	SigningSecretEnv string `yaml:"signing_secret_env"`
+}
+
+// OnboardingConfig controls the welcome DM sent on team_join
+type OnboardingConfig struct {
+	Enabled bool             `yaml:"enabled"`
+	Message string           `yaml:"message"`
+	Links   []OnboardingLink `yaml:"links"`
+	// Experiment names an experiment whose variant templates replace Message
+	Experiment string `yaml:"experiment"`
+	// Checklist is shown to new members in the Home tab until they've done
+	// every task
+	Checklist []OnboardingTask `yaml:"checklist"`
+	// NudgeAfter is when new members with tasks left get a reminder DM;
+	// default a week
+	NudgeAfter time.Duration `yaml:"nudge_after"`
+}
+
+// OnboardingLink is rendered as a button in the welcome DM
+type OnboardingLink struct {
+	Text string `yaml:"text"`
+	URL  string `yaml:"url"`
+}
+
+// OnboardingTask is one item of the new member checklist. Tasks with a
+// Channel or ProfilePhoto tick themselves off; the rest are ticked off by
+// hand.
+type OnboardingTask struct {
+	ID   string `yaml:"id"`
+	Text string `yaml:"text"`
+	// URL links the task, like a handbook page
+	URL string `yaml:"url"`
+	// Channel is done once they've joined it
+	Channel string `yaml:"channel"`
+	// ProfilePhoto is done once they've set a photo
+	ProfilePhoto bool `yaml:"profile_photo"`
+}
+
+// HomeConfig controls the App Home tab
+type HomeConfig struct {
+	Help string `yaml:"help"`
+}
+
+// UnfurlConfig maps internal domains to the provider that previews their links
+type UnfurlConfig struct {
+	Domains map[string]UnfurlDomainConfig `yaml:"domains"`
+}
+
+// UnfurlDomainConfig configures the unfurl provider for one domain
+type UnfurlDomainConfig struct {
+	// Provider is page_meta or json_api
+	Provider string `yaml:"provider"`
+
+	// json_api settings; all values are Go text/templates
+	APIURL  string            `yaml:"api_url"`
+	Headers map[string]string `yaml:"headers"`
+	Title   string            `yaml:"title"`
+	Text    string            `yaml:"text"`
+	Fields  map[string]string `yaml:"fields"`
+}
+
+// GreetingsConfig holds the welcome posted when someone joins a channel,
+// keyed by channel ID
+type GreetingsConfig struct {
+	Channels map[string]ChannelGreeting `yaml:"channels"`
+}
+
+// ChannelGreeting is the welcome for a single channel
+type ChannelGreeting struct {
+	// Mode is ephemeral (default), thread or channel
+	Mode string `yaml:"mode" json:"mode,omitempty"`
+	// ThreadTS is the message to reply under in thread mode, e.g. a pinned
+	// introductions post; without it the greeting goes to the channel
+	ThreadTS string `yaml:"thread_ts" json:"thread_ts,omitempty"`
+	Message  string `yaml:"message" json:"message"`
+}
+
+// AuditConfig selects the compliance-sensitive channels whose message edits
+// and deletions are recorded
+type AuditConfig struct {
+	Channels    []string `yaml:"channels"`
+	AllChannels bool     `yaml:"all_channels"`
+}
+
+// FilesConfig controls processing of files shared in channels
+type FilesConfig struct {
+	// Processors run in order: malware_scan, csv_summary, thumbnail
+	Processors []string `yaml:"processors"`
+	// Channels limits processing to these channel IDs; empty means all
+	Channels []string `yaml:"channels"`
+	// MaxSize is the largest file downloaded, in bytes
+	MaxSize        int    `yaml:"max_size"`
+	MalwareScanURL string `yaml:"malware_scan_url"`
+}
+
+// ChannelFeedConfig controls announcements of new and renamed channels
+type ChannelFeedConfig struct {
+	// Channel is where announcements go; empty disables the feed
+	Channel         string `yaml:"channel"`
+	AnnounceRenames bool   `yaml:"announce_renames"`
+	// Batch collects announcements into one digest every BatchInterval
+	Batch         bool          `yaml:"batch"`
+	BatchInterval time.Duration `yaml:"batch_interval"`
+}
+
+// ArchivesConfig controls notices about channels being archived and
+// unarchived
+type ArchivesConfig struct {
+	// GovernanceChannel hears about every archive and unarchive; empty
+	// disables those notices
+	GovernanceChannel string `yaml:"governance_channel"`
+	// Owners lists, by channel ID, who to DM about changes; channels not
+	// listed notify their creator
+	Owners map[string][]string `yaml:"owners"`
+	// Protected channels are unarchived again as soon as they're archived
+	Protected     []string `yaml:"protected"`
+	PolicyMessage string   `yaml:"policy_message"`
+}
+
+// EmojiFeedConfig controls announcements of custom emoji changes
+type EmojiFeedConfig struct {
+	// Channel is where announcements go; empty disables them
+	Channel          string `yaml:"channel"`
+	AnnounceAliases  bool   `yaml:"announce_aliases"`
+	AnnounceRemovals bool   `yaml:"announce_removals"`
+}
+
+// APIConfig controls the HTTP API under /api/v1
+type APIConfig struct {
+	// DevMode serves Swagger UI at /api/docs
+	DevMode bool `yaml:"dev_mode"`
+}
+
+// WebConfig sets up the admin web UI at /admin
+type WebConfig struct {
+	// BaseURL is where people reach the bot, like https://bot.example.com;
+	// sign-in links from /bot admin web point there
+	BaseURL string `yaml:"base_url"`
+}
+
+// PinsConfig controls mirroring of pinned messages
+type PinsConfig struct {
+	// Mode is thread (a pinned highlights thread per channel), digest (post
+	// to DigestChannel) or empty to disable
+	Mode          string `yaml:"mode"`
+	DigestChannel string `yaml:"digest_channel"`
+	// Channels limits mirroring to these channel IDs; empty means all
+	Channels         []string `yaml:"channels"`
+	AnnounceRemovals bool     `yaml:"announce_removals"`
+}
+
+// GRPCConfig configures the internal gRPC server, which always uses mutual TLS
+type GRPCConfig struct {
+	// Listen is the address to serve on, e.g. ":9090"; empty disables gRPC
+	Listen       string `yaml:"listen"`
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// AllowedClients limits callers to these certificate common names; empty
+	// allows any certificate signed by the client CA
+	AllowedClients []string `yaml:"allowed_clients"`
+}
+
+// NetworkConfig restricts who can reach the HTTP server's routes
+type NetworkConfig struct {
+	// TrustedProxies may set X-Forwarded-For; the client address is the
+	// connection's own when empty
+	TrustedProxies []string        `yaml:"trusted_proxies"`
+	TLS            TLSConfig       `yaml:"tls"`
+	Policies       []NetworkPolicy `yaml:"policies"`
+}
+
+// TLSConfig serves HTTPS instead of HTTP when CertFile is set
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile signs the client certificates policies can require
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// NetworkPolicy limits the requests allowed to paths under PathPrefix. The
+// policy with the longest matching prefix applies.
+type NetworkPolicy struct {
+	PathPrefix string `yaml:"path_prefix"`
+	// AllowCIDRs limits client addresses; empty allows any
+	AllowCIDRs []string `yaml:"allow_cidrs"`
+	// ClientCert requires a certificate signed by tls.client_ca_file, with
+	// a common name in AllowedClients when that is set
+	ClientCert     bool     `yaml:"client_cert"`
+	AllowedClients []string `yaml:"allowed_clients"`
+}
+
+// KudosConfig sets up /kudos shoutouts
+type KudosConfig struct {
+	// Channel is the recognition channel shoutouts are posted to
+	Channel string `yaml:"channel"`
+	// Leaderboards lists the periods (week, month) whose leaderboard is
+	// posted to Channel when they end
+	Leaderboards []string `yaml:"leaderboards"`
+}
+
+// IntentsConfig sets up working out which command a mention or DM meant
+// when it doesn't start with one
+type IntentsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Rules are tried in order before the language model
+	Rules []IntentRule `yaml:"rules"`
+	// LLM asks the language model when no rule matches, in channels with
+	// the ai_replies feature on
+	LLM bool `yaml:"llm"`
+	// ShadowLLM, with LLM off, asks the language model about mentions in
+	// shadow mode; admin shadow intent_llm shows what LLM would change
+	ShadowLLM bool `yaml:"shadow_llm"`
+}
+
+// IntentRule maps text matching Pattern to a command line. Command may use
+// the pattern's capture groups, like ${user} or $1.
+type IntentRule struct {
+	Pattern string `yaml:"pattern"`
+	Command string `yaml:"command"`
+}
+
+// LLMConfig points at an OpenAI-compatible chat completions API or
+// Anthropic's messages API
+type LLMConfig struct {
+	// Provider is openai (default, for any compatible API) or anthropic
+	Provider string `yaml:"provider"`
+	URL      string `yaml:"url"`
+	Model    string `yaml:"model"`
+	// APIKeyEnv names the environment variable holding the API key
+	// (default LLM_API_KEY)
+	APIKeyEnv string `yaml:"api_key_env"`
+	// MaxTokens caps the length of replies; anthropic defaults to 1024
+	MaxTokens int               `yaml:"max_tokens"`
+	Mentions  LLMMentionsConfig `yaml:"mentions"`
+}
+
+// LLMMentionsConfig has the model answer mentions that aren't commands
+type LLMMentionsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SystemPrompt tells the model who it is and how to answer
+	SystemPrompt string          `yaml:"system_prompt"`
+	Memory       LLMMemoryConfig `yaml:"memory"`
+	// Experiment names an experiment whose variant prompts replace
+	// SystemPrompt
+	Experiment string `yaml:"experiment"`
+}
+
+// LLMMemoryConfig controls how much of a thread the model is shown
+type LLMMemoryConfig struct {
+	// MaxTokens is roughly how much of the thread goes with each question
+	// (default 2000)
+	MaxTokens int `yaml:"max_tokens"`
+	// TTL is how long a quiet thread is remembered (default 720h)
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// TranslateConfig sets up translating messages people react to with a flag
+type TranslateConfig struct {
+	// Provider is deepl or google; empty turns translation off
+	Provider string `yaml:"provider"`
+	// APIKeyEnv names the environment variable holding the API key
+	// (default DEEPL_API_KEY or GOOGLE_TRANSLATE_API_KEY)
+	APIKeyEnv string `yaml:"api_key_env"`
+	// Flags maps more flag country codes to languages, like br: pt-BR
+	Flags map[string]string `yaml:"flags"`
+}
+
+// BackupConfig copies the store to local disk or S3 on a schedule
+type BackupConfig struct {
+	// Schedule is a cron expression in UTC, like "0 3 * * *"; empty turns
+	// scheduled backups off
+	Schedule string `yaml:"schedule"`
+	// Keep is how many backups to keep, deleting the oldest; default 14
+	Keep int `yaml:"keep"`
+	// Dir is where backups are written; defaults to backups/ next to the
+	// store. Ignored when S3 is set.
+	Dir string         `yaml:"dir"`
+	S3  BackupS3Config `yaml:"s3"`
+}
+
+// BackupS3Config sends backups to an S3 or S3-compatible bucket. The
+// credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+type BackupS3Config struct {
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
+	// Prefix goes in front of backup names, like "slack-bot/"
+	Prefix string `yaml:"prefix"`
+	// Endpoint is for S3-compatible services; defaults to AWS in Region
+	Endpoint string `yaml:"endpoint"`
+}
+
+// FailoverConfig pairs the bot with a second instance, usually in another
+// region, that follows its store on standby and takes over when it stops
+// answering
+type FailoverConfig struct {
+	// Role is primary or standby. The primary leads when both are up. A
+	// witness only holds the lease the other two lead by.
+	Role string `yaml:"role"`
+	// PeerURL is the other instance's base URL; empty turns failover off
+	PeerURL string `yaml:"peer_url"`
+	// SecretEnv names the environment variable holding the secret both
+	// instances share (default FAILOVER_SECRET)
+	SecretEnv string `yaml:"secret_env"`
+	// Heartbeat is how often the instances check on each other; default 5s
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// TakeoverAfter is how long the standby waits without hearing from
+	// the leader before taking over, and how long the witness's lease
+	// lasts; default 30s
+	TakeoverAfter time.Duration `yaml:"takeover_after"`
+	// WitnessURL is the base URL of a third instance run with role
+	// witness. An instance leads only while the witness gives it the
+	// lease, so two never lead at once.
+	WitnessURL string `yaml:"witness_url"`
+}
+
+// KnowledgeConfig sets up /ask, which answers questions from documents
+// indexed with `slack-bot index`
+type KnowledgeConfig struct {
+	// VectorStore is store (kept in the bot's own store) or qdrant; empty
+	// turns /ask off
+	VectorStore string `yaml:"vector_store"`
+	// QdrantURL and Collection locate the Qdrant collection
+	QdrantURL  string `yaml:"qdrant_url"`
+	Collection string `yaml:"collection"`
+	// EmbeddingURL is an OpenAI-compatible embeddings API; defaults to
+	// llm.url
+	EmbeddingURL   string `yaml:"embedding_url"`
+	EmbeddingModel string `yaml:"embedding_model"`
+	// EmbeddingAPIKeyEnv defaults to llm.api_key_env
+	EmbeddingAPIKeyEnv string `yaml:"embedding_api_key_env"`
+	// TopK is how many passages the answer is written from; default 5
+	TopK int `yaml:"top_k"`
+	// MinScore leaves out passages less alike the question than this,
+	// from 0 to 1; default 0.3
+	MinScore float64 `yaml:"min_score"`
+}
+
+// SlackRateConfig tunes how Slack API calls are budgeted by Slack's rate
+// tiers
+type SlackRateConfig struct {
+	// Reserve is the share of each method's budget only calls that can't
+	// be dropped may use; default 0.2
+	Reserve float64 `yaml:"reserve"`
+	// MaxWait is how long a call waits for budget before failing; default
+	// 30s
+	MaxWait time.Duration `yaml:"max_wait"`
+	// Tiers sets the rate tier (1-4) of methods, like
+	// conversations.history: 4 for internal apps
+	Tiers map[string]int `yaml:"tiers"`
+	// LowPriority lists methods whose calls are always dropped when their
+	// budget is down to the reserve, not only background ones
+	LowPriority []string `yaml:"low_priority"`
+}
+
+// IncidentsConfig sets up /incident
+type IncidentsConfig struct {
+	// ChannelPrefix starts incident channel names; default inc
+	ChannelPrefix string `yaml:"channel_prefix"`
+	// Private makes incident channels private
+	Private bool `yaml:"private"`
+	// OncallGroup is the handle of the usergroup invited to every incident
+	OncallGroup string `yaml:"oncall_group"`
+	// Channel is told when incidents open and resolve
+	Channel string `yaml:"channel"`
+	// Severities, most severe first; defaults to sev1 to sev4. Incidents
+	// started without one get the last.
+	Severities []string `yaml:"severities"`
+	// ArchiveAfter is how long after resolving the channel is archived;
+	// default 24h
+	ArchiveAfter time.Duration `yaml:"archive_after"`
+	// Postmortem is where drafts go when an incident resolves: file (a
+	// Markdown upload, the default) or canvas (the channel's canvas)
+	Postmortem string `yaml:"postmortem"`
+	// FlagReaction puts a message on the postmortem timeline, like pinning
+	// it does; default triangular_flag_on_post
+	FlagReaction string `yaml:"flag_reaction"`
+	// TimelineReaction records a message to an active incident's timeline;
+	// default stopwatch
+	TimelineReaction string `yaml:"timeline_reaction"`
+}
+
+// ExportsConfig limits /bot export
+type ExportsConfig struct {
+	// MaxMessages stops an export after this many messages; default 5000
+	MaxMessages int `yaml:"max_messages"`
+	// MaxBytes is the largest file an export may make; default 10 MB
+	MaxBytes int `yaml:"max_bytes"`
+}
+
+// OncallConfig sets up /oncall
+type OncallConfig struct {
+	// Provider is pagerduty or opsgenie
+	Provider string `yaml:"provider"`
+	// APIKeyEnv defaults to PAGERDUTY_API_KEY or OPSGENIE_API_KEY
+	APIKeyEnv string `yaml:"api_key_env"`
+	// APIURL overrides the provider's API, e.g. for Opsgenie's EU region
+	APIURL string `yaml:"api_url"`
+	// Services maps the names people use with /oncall to schedules:
+	// schedule IDs for PagerDuty, schedule names for Opsgenie
+	Services map[string]string `yaml:"services"`
+	// Default is the service /oncall shows without one; empty shows all
+	Default string `yaml:"default"`
+	// CacheTTL is how long lookups are reused; default 5m
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// WatchdogConfig sets up the watchdog that notices missed Slack events
+type WatchdogConfig struct {
+	// Channels are key channels whose history is compared with the
+	// messages received; missed ones are replayed
+	Channels []string `yaml:"channels"`
+	// AlertChannel is told about gaps
+	AlertChannel string `yaml:"alert_channel"`
+	// SampleInterval is how often the key channels are checked; default 5m
+	SampleInterval time.Duration `yaml:"sample_interval"`
+	// QuietAfter is how long without any events counts as a gap, when at
+	// least MinExpected events would normally have arrived by then;
+	// defaults 10m and 10
+	QuietAfter  time.Duration `yaml:"quiet_after"`
+	MinExpected float64       `yaml:"min_expected"`
+	// ReplayWindow is how old a missed message can be and still be
+	// replayed; older ones are only reported. Default 1h.
+	ReplayWindow time.Duration `yaml:"replay_window"`
+}
+
+// CoffeeConfig sets up random coffee pairing
+type CoffeeConfig struct {
+	// Channels whose members are paired; joining one opts in
+	Channels []string `yaml:"channels"`
+	// Schedule is a cron expression for new rounds, in Timezone (an IANA
+	// name, default UTC); default every Monday at 10:00. Groups that haven't
+	// met are nudged halfway to the next round.
+	Schedule string `yaml:"schedule"`
+	Timezone string `yaml:"timezone"`
+	// GroupSize is how many people each group has, with leftovers making
+	// some groups bigger; default 2
+	GroupSize int `yaml:"group_size"`
+}
+
+// LunchConfig sets up /lunch
+type LunchConfig struct {
+	// Spots groups are sent to, like restaurant names
+	Spots []string `yaml:"spots"`
+	// GroupSize is how many people each group has, with leftovers making
+	// some groups bigger; default 4
+	GroupSize int `yaml:"group_size"`
+	// Wait is how long /lunch collects people without a number of minutes;
+	// default 30m
+	Wait time.Duration `yaml:"wait"`
+	// Reaction people join with; default pizza
+	Reaction string `yaml:"reaction"`
+}
+
+// CelebrationsConfig announces birthdays and work anniversaries
+type CelebrationsConfig struct {
+	// Channel gets the announcements; empty turns them off
+	Channel string `yaml:"channel"`
+	// Time is when they're posted each day, like 09:00 (the default), in
+	// Timezone (an IANA name, default UTC)
+	Time     string `yaml:"time"`
+	Timezone string `yaml:"timezone"`
+}
+
+// TriviaConfig is the question pool for /trivia
+type TriviaConfig struct {
+	Questions []TriviaQuestion `yaml:"questions"`
+	// PerRound is how many questions a round asks; default 5
+	PerRound int `yaml:"per_round"`
+	// TimeLimit is how long each question is open; default 20s
+	TimeLimit time.Duration `yaml:"time_limit"`
+}
+
+// TriviaQuestion is a question with its right answer and up to four wrong
+// ones, shown in a random order
+type TriviaQuestion struct {
+	Question string   `yaml:"question"`
+	Answer   string   `yaml:"answer"`
+	Wrong    []string `yaml:"wrong"`
+}
+
+// RetroConfig controls /retro
+type RetroConfig struct {
+	// Period is how long a retro collects items unless /retro open says;
+	// default a day
+	Period time.Duration `yaml:"period"`
+}
+
+// RSVPConfig controls events posted with /event create
+type RSVPConfig struct {
+	// RemindBefore is how long before an event starts people who are going
+	// get a DM; default an hour
+	RemindBefore time.Duration `yaml:"remind_before"`
+}
+
+// WeeklyDigestConfig posts a summary of each channel's past week
+type WeeklyDigestConfig struct {
+	// Channels are the channel IDs that get a digest
+	Channels []string `yaml:"channels"`
+	// Schedule is a cron expression in Timezone; default Mondays at 09:00
+	Schedule string `yaml:"schedule"`
+	Timezone string `yaml:"timezone"`
+	// TopThreads is how many of the busiest threads are listed; default 5
+	TopThreads int `yaml:"top_threads"`
+}
+
+// OOOConfig controls /ooo time off
+type OOOConfig struct {
+	// StatusTokenEnv names the environment variable holding an admin's
+	// user token with users.profile:write, used to set the status of people
+	// who are out. Without it statuses are left alone.
+	StatusTokenEnv string `yaml:"status_token_env"`
+	// StatusText and StatusEmoji default to "Out of office" and :palm_tree:
+	StatusText  string `yaml:"status_text"`
+	StatusEmoji string `yaml:"status_emoji"`
+}
+
+// ExpensesConfig controls /expense claims
+type ExpensesConfig struct {
+	// Currency claims are made in; defaults to GBP
+	Currency string `yaml:"currency"`
+	// Categories offered in the form; defaults to Travel, Meals, Equipment,
+	// Software and Other
+	Categories []string `yaml:"categories"`
+	// Managers maps a user ID to the user ID approving their claims
+	Managers map[string]string `yaml:"managers"`
+	// Approvers decide claims of anyone not listed in Managers
+	Approvers []string `yaml:"approvers"`
+	// Finance are the user IDs, besides bot admins, who can export claims
+	Finance []string `yaml:"finance"`
+}
+
+// JiraConfig connects /jira and the "Create Jira ticket" shortcut to a
+// Jira Cloud site
+type JiraConfig struct {
+	// URL of the site, like https://example.atlassian.net
+	URL string `yaml:"url"`
+	// Email of the account tickets are filed as; its API token is read from
+	// TokenEnv, JIRA_API_TOKEN by default
+	Email    string `yaml:"email"`
+	TokenEnv string `yaml:"token_env"`
+	// Project and IssueType prefill the form; IssueType defaults to Task
+	Project   string `yaml:"project"`
+	IssueType string `yaml:"issue_type"`
+	// WebhookSecretEnv names the variable holding the secret of the Jira
+	// webhook pointed at /jira/webhook; JIRA_WEBHOOK_SECRET by default
+	WebhookSecretEnv string `yaml:"webhook_secret_env"`
+}
+
+// GitHubConfig connects the bot to GitHub's REST API
+type GitHubConfig struct {
+	// APIURL defaults to https://api.github.com; set it for GitHub
+	// Enterprise Server, like https://github.example.com/api/v3
+	APIURL string `yaml:"api_url"`
+	// TokenEnv names the variable holding the API token, GITHUB_TOKEN by
+	// default
+	TokenEnv string `yaml:"token_env"`
+	// Users maps GitHub logins to Slack user IDs, on top of the links people
+	// make with "/bot github"
+	Users map[string]string `yaml:"users"`
+}
+
+// PRRemindersConfig controls reminders of pull requests waiting on review
+type PRRemindersConfig struct {
+	// Repos are owner/name repositories to check
+	Repos []string `yaml:"repos"`
+	// Channel gets a summary of every stale pull request; reviewers are
+	// DMed either way
+	Channel string `yaml:"channel"`
+	// StaleAfter is how long a pull request goes untouched before reminding,
+	// 24h by default
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// Schedule is a cron expression, "0 10 * * mon-fri" by default, in
+	// Timezone or UTC
+	Schedule string `yaml:"schedule"`
+	Timezone string `yaml:"timezone"`
+}
+
+// ReviewersConfig controls /reviewer
+type ReviewersConfig struct {
+	Teams []ReviewTeam `yaml:"teams"`
+	// LoadWindow is how far back picks count against someone, 14 days by
+	// default
+	LoadWindow time.Duration `yaml:"load_window"`
+}
+
+// ReviewTeam is who reviews pull requests in some repositories
+type ReviewTeam struct {
+	Name string `yaml:"name"`
+	// Repos are owner/name repositories
+	Repos []string `yaml:"repos"`
+	// Members are GitHub logins
+	Members []string `yaml:"members"`
+}
+
+// DeploymentsConfig controls announcements made through POST
+// /api/v1/deployments
+type DeploymentsConfig struct {
+	// Channel is the releases channel
+	Channel string `yaml:"channel"`
+	// Channels overrides Channel per environment
+	Channels map[string]string `yaml:"channels"`
+}
+
+// ReleasesConfig controls release notes posted from GitHub releases
+type ReleasesConfig struct {
+	// Repos are owner/name repositories to watch
+	Repos   []string `yaml:"repos"`
+	Channel string   `yaml:"channel"`
+	// Prereleases are posted too when set
+	Prereleases bool `yaml:"prereleases"`
+	// Interval between checks, 10m by default
+	Interval time.Duration `yaml:"interval"`
+	// PreviewLength is how many characters of the notes show before "Show
+	// more", 800 by default
+	PreviewLength int `yaml:"preview_length"`
+}
+
+// PricesConfig controls /price
+type PricesConfig struct {
+	// Providers are tried in order until one knows the ticker: yahoo,
+	// coingecko
+	Providers []string `yaml:"providers"`
+	// APIKeyEnv names the variable holding a CoinGecko demo API key
+	APIKeyEnv string `yaml:"api_key_env"`
+	// CryptoCurrency is what CoinGecko prices are shown in, usd by default
+	CryptoCurrency string `yaml:"crypto_currency"`
+	// CacheFor is how long a quote is reused, 1m by default
+	CacheFor time.Duration `yaml:"cache_for"`
+}
+
+// WeatherConfig controls /weather
+type WeatherConfig struct {
+	// Provider defaults to openweather, the only one so far
+	Provider string `yaml:"provider"`
+	// APIKeyEnv names the variable holding the provider's API key,
+	// OPENWEATHER_API_KEY by default; without it /weather is off
+	APIKeyEnv string `yaml:"api_key_env"`
+	// Units are metric or imperial, metric by default
+	Units string `yaml:"units"`
+}
+
+// ConvertConfig controls /convert
+type ConvertConfig struct {
+	// Provider defaults to frankfurter, which serves the European Central
+	// Bank's daily rates without a key
+	Provider string `yaml:"provider"`
+	// CacheFor is how long today's rates are reused, 1h by default. Rates
+	// for past dates don't change and are kept for a day.
+	CacheFor time.Duration `yaml:"cache_for"`
+}
+
+// GiphyConfig controls /gif
+type GiphyConfig struct {
+	// APIKeyEnv names the variable holding the Giphy API key,
+	// GIPHY_API_KEY by default
+	APIKeyEnv string `yaml:"api_key_env"`
+	// Rating is the highest content rating shown: g, pg, pg-13 or r; g by
+	// default
+	Rating string `yaml:"rating"`
+}
+
+// RateLimitConfig throttles requests to the HTTP server
+type RateLimitConfig struct {
+	PerIP    RateLimit `yaml:"per_ip"`
+	PerToken RateLimit `yaml:"per_token"`
+	// Exempt path prefixes; /slack/ when unset
+	Exempt []string `yaml:"exempt"`
+	// BanAfter bans an address limited this many times within BanWindow
+	// (default 10m) for BanDuration (default 1h); 0 never bans
+	BanAfter    int           `yaml:"ban_after"`
+	BanWindow   time.Duration `yaml:"ban_window"`
+	BanDuration time.Duration `yaml:"ban_duration"`
+	// BanList is refused outright
+	BanList []string `yaml:"ban_list"`
+}
+
+// RateLimit allows Burst requests at once and Rate per second after that;
+// a zero Rate means no limit
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// DNDConfig controls direct messages to people in do-not-disturb
+type DNDConfig struct {
+	// Defer schedules DMs for when the recipient's DND ends
+	Defer bool `yaml:"defer"`
+	// Urgent features always DM right away
+	Urgent []string `yaml:"urgent"`
+}
+
+// RoutingConfig maps message importance (info, notice, warning, critical)
+// to what happens to bot messages: post, thread (into the channel's bot
+// notices thread), digest (batched into one post a day), suppress or
+// dm_owner (instead of posting)
+type RoutingConfig struct {
+	// Default applies to channels without their own policy and to levels
+	// their policy leaves out; anything unset is posted
+	Default map[string]string `yaml:"default"`
+	// Channels holds policies keyed by channel ID
+	Channels map[string]map[string]string `yaml:"channels"`
+	// Digests opts channels, by ID, into digest mode: info messages are
+	// batched and posted daily at the given time, like 17:00, in the IANA
+	// timezone after it or send_windows.timezone
+	Digests map[string]string `yaml:"digests"`
+}
+
+// UsageReportConfig sets up the monthly usage report for admins
+type UsageReportConfig struct {
+	// Enabled sends last month's report on the first of each month
+	Enabled bool `yaml:"enabled"`
+	// Channel gets the report; empty sends it to each of admins directly
+	Channel string `yaml:"channel"`
+	// Quotas are monthly budgets, keyed api_requests, api_requests_limited,
+	// llm_calls or llm_tokens, that the report measures consumption against
+	Quotas map[string]int `yaml:"quotas"`
+}
+
+// FAQConfig sets up answering common questions asked in mentions and DMs
+type FAQConfig struct {
+	// File is a YAML list of entries, reread when it changes
+	File    string     `yaml:"file"`
+	Entries []FAQEntry `yaml:"entries"`
+	// MinScore is how closely, from 0 to 1, a question must match an
+	// entry to be answered; default 0.6
+	MinScore float64 `yaml:"min_score"`
+	// Channel hears about answers people didn't find helpful
+	Channel string `yaml:"channel"`
+}
+
+// StandupConfig schedules one team's asynchronous standup
+type StandupConfig struct {
+	Name string `yaml:"name"`
+	// Channel gets the compiled summary
+	Channel string   `yaml:"channel"`
+	Members []string `yaml:"members"`
+	// Time members are asked and SummaryTime the summary is posted, like
+	// 09:30, in Timezone (an IANA name, default UTC) on Days (default
+	// Monday to Friday)
+	Time        string   `yaml:"time"`
+	SummaryTime string   `yaml:"summary_time"`
+	Timezone    string   `yaml:"timezone"`
+	Days        []string `yaml:"days"`
+	// PerUser asks each member at Time in their own Slack timezone
+	// instead, and posts the summary once SummaryTime has passed for all
+	// of them
+	PerUser bool `yaml:"per_user"`
+}
+
+// SendWindowsConfig holds digests, reports and other output that can wait
+// until business hours in the recipient's timezone
+type SendWindowsConfig struct {
+	// Start and End are local times like 09:00; an empty Start disables
+	// send windows
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// Days the window opens on, like mon; default Monday to Friday
+	Days []string `yaml:"days"`
+	// Timezone for channels; DMs use the recipient's. Default UTC.
+	Timezone string `yaml:"timezone"`
+	// Urgent features are never held
+	Urgent []string `yaml:"urgent"`
+}
+
+// EventBusConfig configures publishing of bot events to NATS or Kafka
+type EventBusConfig struct {
+	// Driver is nats, kafka or empty to disable publishing
+	Driver string `yaml:"driver"`
+	// URL of the NATS server
+	URL string `yaml:"url"`
+	// Brokers are the Kafka bootstrap brokers
+	Brokers []string `yaml:"brokers"`
+	// TopicPrefix is prepended to event types, e.g. "slackbot." publishes
+	// to slackbot.message.received
+	TopicPrefix string `yaml:"topic_prefix"`
+	// Events limits publishing to these event types; empty means all
+	Events []string `yaml:"events"`
+	// Consume turns messages on other topics into Slack notifications
+	Consume BusConsumeConfig `yaml:"consume"`
+}
+
+// BusConsumeConfig routes messages from bus topics to Slack
+type BusConsumeConfig struct {
+	// Group is the Kafka consumer group or NATS queue group
+	Group string    `yaml:"group"`
+	Rules []BusRule `yaml:"rules"`
+}
+
+// BusRule posts matching messages from a topic; the first matching rule wins
+type BusRule struct {
+	Topic string `yaml:"topic"`
+	// Match requires payload fields (dotted paths) to have these values
+	Match map[string]string `yaml:"match"`
+	// Channel is a template for the channel or user ID to post to
+	Channel string `yaml:"channel"`
+	// Template is rendered with a busNotification
+	Template string `yaml:"template"`
+}
+
+// RepliesConfig controls how the bot replies to messages in threads
+type RepliesConfig struct {
+	// Broadcast also sends thread replies to the channel
+	Broadcast bool `yaml:"broadcast"`
+}
+
+// FeedbackConfig controls the "Submit feedback" shortcut
+type FeedbackConfig struct {
+	// Channel receives submitted feedback; empty disables the shortcut
+	Channel string `yaml:"channel"`
+}
+
+// SLOConfig defines response time objectives for the bot itself
+type SLOConfig struct {
+	// OpsChannel receives burn rate alerts
+	OpsChannel string `yaml:"ops_channel"`
+	Objectives []SLO  `yaml:"objectives"`
+}
+
+// EncryptionConfig selects the master key that protects secrets in the store
+type EncryptionConfig struct {
+	// Provider is env (keys in STORE_MASTER_KEYS) or vault_transit; empty
+	// uses env when STORE_MASTER_KEYS is set
+	Provider  string `yaml:"provider"`
+	VaultAddr string `yaml:"vault_addr"`
+	VaultKey  string `yaml:"vault_key"`
+}
+
+// UsergroupsConfig sets up usergroup announcements, join requests and sync
+type UsergroupsConfig struct {
+	// Channel is told when usergroups are created or changed
+	Channel string `yaml:"channel"`
+	// RequestsChannel receives /bot groups join requests for admins to
+	// approve; empty disables join requests
+	RequestsChannel string              `yaml:"requests_channel"`
+	Sync            UsergroupSyncConfig `yaml:"sync"`
+}
+
+// UsergroupSyncConfig keeps usergroup membership matching an external
+// directory such as the HR system
+type UsergroupSyncConfig struct {
+	// URL is a Go template with .Handle and .Source; it must answer
+	// {"emails": [...]}
+	URL      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`
+	Interval time.Duration     `yaml:"interval"`
+	// Groups maps usergroup handles to group names in the directory
+	Groups map[string]string `yaml:"groups"`
+}
+
+// SLO is a latency objective: Target share of Metric events finish within
+// Threshold over a rolling Window
+type SLO struct {
+	Name string `yaml:"name"`
+	// Metric is slack_ack or command
+	Metric    string        `yaml:"metric"`
+	Threshold time.Duration `yaml:"threshold"`
+	Target    float64       `yaml:"target"`
+	Window    time.Duration `yaml:"window"`
+}
+
+// loadConfig reads the YAML config file at path and applies the named
+// profile, or the file's own profile setting when name is empty. A missing
+// file is not an error; the bot simply runs with every optional feature
+// disabled.
+func loadConfig(path, name string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && name == "" {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if name == "" {
+		name = cfg.Profile
+	}
+	if err := applyProfile(cfg, name); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Used when the convert settings aren't set
+const (
+	defaultConvertProvider = "frankfurter"
+	defaultConvertCacheFor = time.Hour
+)
+
+// Rates for past dates never change, so they're kept this long
+const historicalRatesCacheFor = 24 * time.Hour
+
+// Most currencies one /convert converts into
+const maxConvertTargets = 10
+
+// The first day the European Central Bank published euro rates
+var earliestRatesDate = time.Date(1999, time.January, 4, 0, 0, 0, 0, time.UTC)
+
+var (
+	// currencyCodePattern matches ISO 4217 codes like USD
+	currencyCodePattern = regexp.MustCompile(`^[A-Za-z]{3}$`)
+	// convertAmountPattern matches amounts like 100, 1,250.50 or 100usd
+	convertAmountPattern = regexp.MustCompile(`^([0-9][0-9,]*(?:\.[0-9]+)?|\.[0-9]+)([A-Za-z]{3})?$`)
+)
+
+const convertUsage = "`convert <amount> <FROM> to <TO> [on YYYY-MM-DD]`, e.g. `convert 100 USD to EUR` or `convert 50 GBP to USD,JPY on 2024-01-02`"
+
+// errUnknownCurrency is returned by providers that don't have rates for a
+// currency
+var errUnknownCurrency = errors.New("unknown currency")
+
+// exchangeRates are what one unit of Base bought on Date
+type exchangeRates struct {
+	Base string
+	// Date is the day the rates were published, which can be before the day
+	// asked for on weekends and holidays
+	Date   time.Time
+	Rates  map[string]float64
+	Source string
+}
+
+// exchangeRateProvider looks up currency exchange rates
+type exchangeRateProvider interface {
+	// Rates returns base's rates on day, or the latest when day is zero
+	Rates(base string, day time.Time) (*exchangeRates, error)
+}
+
+// exchangeRateProviderFactories creates rate providers by the name used in
+// config
+var exchangeRateProviderFactories = map[string]func(ConvertConfig) (exchangeRateProvider, error){
+	"frankfurter": newFrankfurterRateProvider,
+}
+
+// cachedRates are a base currency's rates as last looked up
+type cachedRates struct {
+	rates   *exchangeRates
+	expires time.Time
+}
+
+var (
+	// The provider for convert.provider
+	exchangeRateSource exchangeRateProvider
+
+	ratesCacheMu sync.Mutex
+	ratesCache   = map[string]cachedRates{}
+)
+
+// conversion is a parsed /convert request
+type conversion struct {
+	Amount float64
+	From   string
+	To     []string
+	// Date asks for historical rates; zero means the latest
+	Date time.Time
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/convert",
+		Usage:       "/convert <amount> <FROM> to <TO> [on YYYY-MM-DD]",
+		Description: "Convert between currencies at today's or a past day's rate, e.g. `/convert 100 USD to EUR`",
+		Handler:     handleConvert,
+	})
+	// Also lets people mention the bot mid-conversation: @bot convert 100 USD to EUR
+	registerBotCommand(&command{
+		Name:        "convert",
+		Usage:       "convert <amount> <FROM> to <TO> [on YYYY-MM-DD]",
+		Description: "Convert between currencies at today's or a past day's rate",
+		Handler:     handleConvert,
+	})
+}
+
+// setupConvert builds the provider for convert.provider
+func setupConvert(cfg ConvertConfig) error {
+	name := cfg.Provider
+	if name == "" {
+		name = defaultConvertProvider
+	}
+	factory, ok := exchangeRateProviderFactories[name]
+	if !ok {
+		return fmt.Errorf("unknown exchange rate provider %q", name)
+	}
+	provider, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring exchange rate provider %s: %w", name, err)
+	}
+	exchangeRateSource = provider
+	ratesCacheMu.Lock()
+	ratesCache = map[string]cachedRates{}
+	ratesCacheMu.Unlock()
+	return nil
+}
+
+func handleConvert(req commandRequest) commandResponse {
+	if exchangeRateSource == nil {
+		return ephemeral("Currency conversion isn't set up. See `convert` in the config.")
+	}
+	conv, err := parseConversion(req.Args, time.Now().UTC())
+	if err != nil {
+		return ephemeral("I couldn't read that: %s. Usage: %s", err, convertUsage)
+	}
+	// Looking up rates can take longer than Slack waits for an answer, so
+	// it's done after this returns. Mentions and DMs have no response URL;
+	// their answer goes to the thread they came from.
+	if req.ResponseURL == "" {
+		go runJob("convert", func() {
+			resp := answerConversion(req.UserID, conv)
+			msg := outboundMessage{Channel: req.ChannelID, ThreadTS: req.ThreadTS, Text: resp.Text}
+			if !resp.InChannel {
+				if err := sendEphemeral(req.UserID, msg); err != nil {
+					log.Printf("Error replying to convert: %v", err)
+				}
+				return
+			}
+			msg.Broadcast = appConfig.Replies.Broadcast && req.ThreadTS != ""
+			if _, err := sendMessage(msg); err != nil {
+				log.Printf("Error replying to convert: %v", err)
+			}
+		})
+		return ephemeral(":currency_exchange: Converting %s…", conv.From)
+	}
+	go runJob("convert", func() {
+		resp := answerConversion(req.UserID, conv)
+		msg := &slack.WebhookMessage{Text: resp.Text, ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true}
+		if resp.InChannel {
+			msg = &slack.WebhookMessage{Text: resp.Text, ResponseType: slack.ResponseTypeInChannel}
+		}
+		if err := respond(req.ResponseURL, msg); err != nil {
+			log.Printf("Error replying to /convert: %v", err)
+		}
+	})
+	return ephemeral(":currency_exchange: Converting %s…", conv.From)
+}
+
+// answerConversion converts at the rates asked for, sharing the answer
+// with the channel
+func answerConversion(userID string, conv conversion) commandResponse {
+	rates, err := lookupRates(conv.From, conv.Date)
+	if errors.Is(err, errUnknownCurrency) {
+		return ephemeral("I don't have exchange rates for %s.", conv.From)
+	}
+	if err != nil {
+		log.Printf("Error looking up %s exchange rates: %v", conv.From, err)
+		return ephemeral("Sorry, something went wrong looking up exchange rates.")
+	}
+	var results, unknown []string
+	for _, to := range conv.To {
+		rate := 1.0
+		if to != rates.Base {
+			var ok bool
+			if rate, ok = rates.Rates[to]; !ok {
+				unknown = append(unknown, to)
+				continue
+			}
+		}
+		results = append(results, "*"+formatQuotePrice(conv.Amount*rate)+" "+to+"*")
+	}
+	if len(results) == 0 {
+		return ephemeral("I don't have exchange rates from %s to %s.", conv.From, strings.Join(unknown, ", "))
+	}
+	when := "latest rates"
+	if !conv.Date.IsZero() {
+		when = "rates on " + conv.Date.Format("2 Jan 2006")
+	}
+	text := fmt.Sprintf(":currency_exchange: <@%s> asked for %s %s at the %s: %s",
+		userID, formatQuotePrice(conv.Amount), conv.From, when, strings.Join(results, " · "))
+	// A weekend or holiday gets the last rates published before it
+	text += fmt.Sprintf("\n_Rates published %s · %s_", rates.Date.Format("2 Jan 2006"), rates.Source)
+	if len(unknown) > 0 {
+		text += fmt.Sprintf("\n_No rates for %s_", strings.Join(unknown, ", "))
+	}
+	return commandResponse{Text: text, InChannel: true}
+}
+
+// parseConversion reads "100 USD to EUR", "100usd eur,gbp" or
+// "USD in JPY on 2024-01-02"; the amount is 1 when left out
+func parseConversion(args []string, now time.Time) (conversion, error) {
+	conv := conversion{Amount: 1}
+	if n := len(args); n >= 2 && strings.EqualFold(args[n-2], "on") {
+		day, err := parseRatesDate(args[n-1], now)
+		if err != nil {
+			return conv, err
+		}
+		conv.Date, args = day, args[:n-2]
+	}
+	if len(args) == 0 {
+		return conv, errors.New("nothing to convert")
+	}
+
+	if m := convertAmountPattern.FindStringSubmatch(args[0]); m != nil {
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		if err != nil {
+			return conv, fmt.Errorf("%q isn't an amount", args[0])
+		}
+		conv.Amount, args = amount, args[1:]
+		if m[2] != "" {
+			args = append([]string{m[2]}, args...)
+		}
+	}
+	if len(args) == 0 || !currencyCodePattern.MatchString(args[0]) {
+		return conv, errors.New("the currency to convert from should be a three-letter code like USD")
+	}
+	conv.From, args = strings.ToUpper(args[0]), args[1:]
+	if len(args) > 0 && slices.Contains([]string{"to", "in", "into"}, strings.ToLower(args[0])) {
+		args = args[1:]
+	}
+	for _, arg := range args {
+		for _, code := range strings.Split(arg, ",") {
+			if code == "" {
+				continue
+			}
+			if !currencyCodePattern.MatchString(code) {
+				return conv, fmt.Errorf("%q isn't a currency code", code)
+			}
+			if code = strings.ToUpper(code); !slices.Contains(conv.To, code) {
+				conv.To = append(conv.To, code)
+			}
+		}
+	}
+	if len(conv.To) == 0 {
+		return conv, errors.New("no currency to convert to")
+	}
+	if len(conv.To) > maxConvertTargets {
+		return conv, fmt.Errorf("at most %d currencies can be converted into at once", maxConvertTargets)
+	}
+	return conv, nil
+}
+
+// parseRatesDate reads a YYYY-MM-DD date, today or yesterday. Today means
+// the latest rates, so it reads as the zero time.
+func parseRatesDate(s string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var day time.Time
+	switch strings.ToLower(s) {
+	case "today":
+		return time.Time{}, nil
+	case "yesterday":
+		day = today.AddDate(0, 0, -1)
+	default:
+		var err error
+		if day, err = time.Parse(time.DateOnly, s); err != nil {
+			return time.Time{}, fmt.Errorf("%q isn't a date like 2024-01-02", s)
+		}
+	}
+	if day.After(today) {
+		return time.Time{}, errors.New("there are no rates for the future yet")
+	}
+	if day.Before(earliestRatesDate) {
+		return time.Time{}, fmt.Errorf("rates only go back to %s", earliestRatesDate.Format(time.DateOnly))
+	}
+	if day.Equal(today) {
+		return time.Time{}, nil
+	}
+	return day, nil
+}
+
+// lookupRates asks the provider for base's rates, caching today's for
+// convert.cache_for and past dates' for a day
+func lookupRates(base string, day time.Time) (*exchangeRates, error) {
+	key := base + "/latest"
+	if !day.IsZero() {
+		key = base + "/" + day.Format(time.DateOnly)
+	}
+	ratesCacheMu.Lock()
+	cached, ok := ratesCache[key]
+	ratesCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.rates, nil
+	}
+
+	rates, err := exchangeRateSource.Rates(base, day)
+	if err != nil {
+		return nil, err
+	}
+	ttl := appConfig.Convert.CacheFor
+	if ttl <= 0 {
+		ttl = defaultConvertCacheFor
+	}
+	if !day.IsZero() {
+		ttl = historicalRatesCacheFor
+	}
+	ratesCacheMu.Lock()
+	for k, c := range ratesCache {
+		if time.Now().After(c.expires) {
+			delete(ratesCache, k)
+		}
+	}
+	ratesCache[key] = cachedRates{rates: rates, expires: time.Now().Add(ttl)}
+	ratesCacheMu.Unlock()
+	return rates, nil
+}
+
+// frankfurterRateProvider reads the European Central Bank's reference
+// rates from the Frankfurter API, which needs no key
+type frankfurterRateProvider struct {
+	url string
+}
+
+func newFrankfurterRateProvider(cfg ConvertConfig) (exchangeRateProvider, error) {
+	return frankfurterRateProvider{url: "https://api.frankfurter.app"}, nil
+}
+
+func (p frankfurterRateProvider) Rates(base string, day time.Time) (*exchangeRates, error) {
+	path := "/latest"
+	if !day.IsZero() {
+		path = "/" + day.Format(time.DateOnly)
+	}
+	resp, err := httpClient.Get(p.url + path + "?from=" + url.QueryEscape(base))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Currencies it doesn't cover are a 404, or a 422 on older deployments
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity {
+		return nil, errUnknownCurrency
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var out struct {
+		Base  string             `json:"base"`
+		Date  string             `json:"date"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return nil, err
+	}
+	published, err := time.Parse(time.DateOnly, out.Date)
+	if err != nil {
+		return nil, fmt.Errorf("parsing rates date %q: %w", out.Date, err)
+	}
+	return &exchangeRates{Base: out.Base, Date: published, Rates: out.Rates, Source: "European Central Bank via Frankfurter"}, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Free Dictionary API, which has English definitions without a key
+const dictionaryAPIURL = "https://api.dictionaryapi.dev/api/v2/entries/en/"
+
+// Limits on how much of an entry /define shows
+const (
+	maxDefineWordChars   = 50
+	maxDefineMeanings    = 4
+	maxDefineDefinitions = 3
+)
+
+// errWordNotFound is returned when the dictionary has no entry for a word
+var errWordNotFound = errors.New("word not found")
+
+// dictionaryEntry is one sense group of a word as the dictionary API has it
+type dictionaryEntry struct {
+	Word      string `json:"word"`
+	Phonetic  string `json:"phonetic"`
+	Phonetics []struct {
+		Text  string `json:"text"`
+		Audio string `json:"audio"`
+	} `json:"phonetics"`
+	Meanings []struct {
+		PartOfSpeech string `json:"partOfSpeech"`
+		Definitions  []struct {
+			Definition string `json:"definition"`
+			Example    string `json:"example"`
+		} `json:"definitions"`
+	} `json:"meanings"`
+	SourceURLs []string `json:"sourceUrls"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/define",
+		Usage:       "/define <word>",
+		Description: "Look up a word's pronunciation, meanings and examples",
+		Handler:     handleDefine,
+	})
+}
+
+func handleDefine(req commandRequest) commandResponse {
+	word := strings.TrimSpace(req.Text)
+	if word == "" {
+		return ephemeral("Usage: `/define <word>`, e.g. `/define serendipity`")
+	}
+	if len(word) > maxDefineWordChars {
+		return ephemeral("That's a long word. Try just one, like `/define serendipity`.")
+	}
+	go runJob("define", func() {
+		reply := &slack.WebhookMessage{ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true}
+		entries, err := lookupWord(word)
+		switch {
+		case errors.Is(err, errWordNotFound):
+			reply.Text = fmt.Sprintf("I couldn't find a definition for %q. Check the spelling, or try the word's base form.", word)
+		case err != nil:
+			log.Printf("Error looking up %q: %v", word, err)
+			reply.Text = "Sorry, something went wrong looking up that word."
+		default:
+			// Only definitions go to the channel; misses stay with the asker
+			reply = &slack.WebhookMessage{
+				ResponseType: slack.ResponseTypeInChannel,
+				Text:         fmt.Sprintf("<@%s> looked up %s", req.UserID, entries[0].Word),
+				Blocks:       &slack.Blocks{BlockSet: fitResponseBlocks(definitionBlocks(req.UserID, entries))},
+			}
+		}
+		if err := respond(req.ResponseURL, reply); err != nil {
+			log.Printf("Error replying to /define: %v", err)
+		}
+	})
+	return ephemeral(":books: Looking up %s…", word)
+}
+
+// lookupWord fetches a word's entries from the dictionary API
+func lookupWord(word string) ([]dictionaryEntry, error) {
+	resp, err := httpClient.Get(dictionaryAPIURL + url.PathEscape(strings.ToLower(word)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errWordNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var entries []dictionaryEntry
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errWordNotFound
+	}
+	return entries, nil
+}
+
+// definitionBlocks shows the word with its pronunciation, then a section
+// per part of speech with numbered definitions and their examples
+func definitionBlocks(userID string, entries []dictionaryEntry) []slack.Block {
+	md := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.MarkdownType, s, false, false)
+	}
+	header, _ := truncateText(entries[0].Word, maxHeaderText)
+	blocks := []slack.Block{slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, header, false, false))}
+
+	phonetic, audio := entries[0].Phonetic, ""
+	for _, entry := range entries {
+		for _, p := range entry.Phonetics {
+			if phonetic == "" {
+				phonetic = p.Text
+			}
+			if audio == "" && p.Audio != "" {
+				audio = p.Audio
+			}
+		}
+	}
+	var pronunciation []string
+	if phonetic != "" {
+		pronunciation = append(pronunciation, escapeMrkdwn(phonetic))
+	}
+	if audio != "" {
+		pronunciation = append(pronunciation, "<"+audio+"|:sound: Listen>")
+	}
+	if len(pronunciation) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", md(strings.Join(pronunciation, " · "))))
+	}
+
+	// Entries for the same word repeat parts of speech, e.g. "set" as a noun
+	// twice; they're shown under one heading
+	var order []string
+	seen := map[string]bool{}
+	senses := map[string][]string{}
+	for _, entry := range entries {
+		for _, m := range entry.Meanings {
+			if !seen[m.PartOfSpeech] {
+				seen[m.PartOfSpeech] = true
+				order = append(order, m.PartOfSpeech)
+			}
+			for _, d := range m.Definitions {
+				if len(senses[m.PartOfSpeech]) == maxDefineDefinitions {
+					break
+				}
+				line := escapeMrkdwn(d.Definition)
+				if d.Example != "" {
+					line += "\n      _“" + escapeMrkdwn(d.Example) + "”_"
+				}
+				senses[m.PartOfSpeech] = append(senses[m.PartOfSpeech], line)
+			}
+		}
+	}
+	// A part of speech listed with no definitions has nothing to show
+	order = slices.DeleteFunc(order, func(pos string) bool { return len(senses[pos]) == 0 })
+	for _, pos := range order[:min(len(order), maxDefineMeanings)] {
+		text := "*" + pos + "*"
+		for i, line := range senses[pos] {
+			text += fmt.Sprintf("\n%d. %s", i+1, line)
+		}
+		text, _ = truncateText(text, maxSectionText)
+		blocks = append(blocks, slack.NewSectionBlock(md(text), nil, nil))
+	}
+
+	footer := fmt.Sprintf("Looked up by <@%s> · Free Dictionary API", userID)
+	if len(entries[0].SourceURLs) > 0 {
+		footer += " · <" + entries[0].SourceURLs[0] + "|Source>"
+	}
+	return append(blocks, slack.NewContextBlock("", md(footer)))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// Store bucket of announced deployments, keyed by service/env/version
+const deploymentsBucket = "deployments"
+
+// Deployment statuses CI reports
+const (
+	deploymentStarted   = "started"
+	deploymentSucceeded = "succeeded"
+	deploymentFailed    = "failed"
+)
+
+// Deployments are forgotten this long after they were announced
+const deploymentRetention = 30 * 24 * time.Hour
+
+// deployment is a release announced in the releases channel
+type deployment struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	Env     string `json:"env"`
+	Status  string `json:"status"`
+	// URL links to the pipeline run
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description,omitempty"`
+	// By is the owner of the API token that announced it
+	By         string    `json:"by"`
+	Channel    string    `json:"channel"`
+	TS         string    `json:"ts"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// apiDeploymentRequest reports a deployment starting or finishing. Calls
+// with the same service, env and version update one announcement.
+type apiDeploymentRequest struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	Env     string `json:"env"`
+	// Status is started, succeeded or failed; started by default
+	Status      string `json:"status,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// apiDeploymentResponse is the announcement a deployment report updated
+type apiDeploymentResponse struct {
+	Status  string `json:"status"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// Serializes reports, so a quick started/succeeded pair posts one message
+var deploymentsMu sync.Mutex
+
+func init() {
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodPost,
+		Path:        "/deployments",
+		Scope:       scopeDeploymentsWrite,
+		OperationID: "ReportDeployment",
+		Summary:     "Announce a deployment in the releases channel, or update its status",
+		Request:     apiDeploymentRequest{},
+		Response:    apiDeploymentResponse{},
+		Handler:     handleAPIDeployment,
+	})
+}
+
+// startDeploymentCleanup forgets old deployments once a day
+func startDeploymentCleanup() {
+	runEvery("deployment cleanup", 24*time.Hour, pruneDeployments)
+}
+
+func deploymentKey(service, env, version string) string {
+	return strings.ToLower(service + "/" + env + "/" + version)
+}
+
+func handleAPIDeployment(c *gin.Context) {
+	var req apiDeploymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deployment: " + err.Error()})
+		return
+	}
+	req.Service, req.Version, req.Env = strings.TrimSpace(req.Service), strings.TrimSpace(req.Version), strings.TrimSpace(req.Env)
+	if req.Service == "" || req.Version == "" || req.Env == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "service, version and env are required"})
+		return
+	}
+	if req.Status == "" {
+		req.Status = deploymentStarted
+	}
+	if !slices.Contains([]string{deploymentStarted, deploymentSucceeded, deploymentFailed}, req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be started, succeeded or failed"})
+		return
+	}
+	channel := appConfig.Deployments.Channel
+	if ch, ok := appConfig.Deployments.Channels[req.Env]; ok {
+		channel = ch
+	}
+	if channel == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No releases channel is set up; see deployments in the config"})
+		return
+	}
+
+	deploymentsMu.Lock()
+	defer deploymentsMu.Unlock()
+	key := deploymentKey(req.Service, req.Env, req.Version)
+	d := &deployment{}
+	found, err := store.Get(deploymentsBucket, key, d)
+	if err != nil {
+		log.Printf("Error loading deployment %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	now := time.Now().UTC()
+	if !found {
+		d = &deployment{Service: req.Service, Version: req.Version, Env: req.Env, By: apiCaller(c).UserID, Channel: channel, StartedAt: now}
+	}
+	previous := d.Status
+	d.Status = req.Status
+	if req.URL != "" {
+		d.URL = req.URL
+	}
+	if req.Description != "" {
+		d.Description = req.Description
+	}
+	if req.Status != deploymentStarted {
+		d.FinishedAt = now
+	}
+	text := deploymentText(d)
+
+	status := http.StatusOK
+	if !found {
+		ts, err := sendMessage(outboundMessage{Channel: d.Channel, Importance: importanceInfo, NeedTS: true, Text: text, Blocks: deploymentBlocks(d)})
+		if err != nil {
+			log.Printf("Error announcing deployment %s: %v", key, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Couldn't post to the releases channel"})
+			return
+		}
+		d.TS, status = ts, http.StatusCreated
+	} else {
+		_, _, _, err := slackClient.UpdateMessage(d.Channel, d.TS, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(deploymentBlocks(d)...))
+		if err != nil {
+			log.Printf("Error updating deployment %s: %v", key, err)
+		}
+	}
+	// Each change of status is also a thread reply, so the channel sees how
+	// it ended; failures show in the channel too
+	if found && req.Status != previous {
+		reply := outboundMessage{Channel: d.Channel, ThreadTS: d.TS, Importance: importanceInfo, Text: deploymentUpdateText(d)}
+		if req.Status == deploymentFailed {
+			reply.Broadcast, reply.Importance = true, importanceWarning
+		}
+		if _, err := sendMessage(reply); err != nil {
+			log.Printf("Error threading deployment update %s: %v", key, err)
+		}
+	}
+	if err := store.Put(deploymentsBucket, key, d); err != nil {
+		log.Printf("Error saving deployment %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(status, apiDeploymentResponse{Status: d.Status, Channel: d.Channel, TS: d.TS})
+}
+
+// deploymentEmoji marks a status in announcements
+func deploymentEmoji(status string) string {
+	switch status {
+	case deploymentSucceeded:
+		return ":white_check_mark:"
+	case deploymentFailed:
+		return ":x:"
+	default:
+		return ":rocket:"
+	}
+}
+
+func deploymentText(d *deployment) string {
+	return fmt.Sprintf("%s %s %s to %s: %s", deploymentEmoji(d.Status), d.Service, d.Version, d.Env, d.Status)
+}
+
+// deploymentUpdateText says how a deployment finished, and how long it took
+func deploymentUpdateText(d *deployment) string {
+	text := fmt.Sprintf("%s %s %s %s in %s", deploymentEmoji(d.Status), d.Service, d.Version, d.Status, d.Env)
+	if !d.FinishedAt.IsZero() {
+		text += " after " + d.FinishedAt.Sub(d.StartedAt).Round(time.Second).String()
+	}
+	if d.URL != "" {
+		text += " · <" + d.URL + "|pipeline>"
+	}
+	return text
+}
+
+// deploymentBlocks renders the announcement, laid out the same for every
+// service
+func deploymentBlocks(d *deployment) []slack.Block {
+	field := func(label, value string) *slack.TextBlockObject {
+		value, _ = truncateText(value, maxFieldText-len(label)-4)
+		return slack.NewTextBlockObject(slack.MarkdownType, "*"+label+"*\n"+value, false, false)
+	}
+	header, _ := truncateText(fmt.Sprintf("%s %s", d.Service, d.Version), maxHeaderText)
+	fields := []*slack.TextBlockObject{
+		field("Environment", d.Env),
+		field("Status", deploymentEmoji(d.Status)+" "+d.Status),
+		field("Started", slackDate(d.StartedAt)),
+	}
+	if d.By != "" {
+		fields = append(fields, field("Announced by", "<@"+d.By+">"))
+	}
+	if !d.FinishedAt.IsZero() {
+		fields = append(fields, field("Took", d.FinishedAt.Sub(d.StartedAt).Round(time.Second).String()))
+	}
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, header, false, false)),
+		slack.NewSectionBlock(nil, fields, nil),
+	}
+	if d.Description != "" {
+		description, _ := truncateText(d.Description, maxSectionText)
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, description, false, false), nil, nil))
+	}
+	if d.URL != "" {
+		btn := slack.NewButtonBlockElement("deployment_pipeline", "", slack.NewTextBlockObject(slack.PlainTextType, "View pipeline", false, false))
+		btn.URL = d.URL
+		blocks = append(blocks, slack.NewActionBlock("", btn))
+	}
+	return blocks
+}
+
+// pruneDeployments forgets deployments past deploymentRetention
+func pruneDeployments() {
+	cutoff := time.Now().Add(-deploymentRetention)
+	for _, key := range store.Keys(deploymentsBucket) {
+		var d deployment
+		if _, err := store.Get(deploymentsBucket, key, &d); err != nil {
+			log.Printf("Error loading deployment %s: %v", key, err)
+			continue
+		}
+		if d.StartedAt.Before(cutoff) {
+			if err := store.Delete(deploymentsBucket, key); err != nil {
+				log.Printf("Error forgetting deployment %s: %v", key, err)
+			}
+		}
+	}
+}
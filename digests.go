@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for messages waiting for their channel's digest, keyed by
+// channel and the time they were queued
+const digestQueueBucket = "digest_queue"
+
+// Time digests go out when a channel uses the digest route without
+// listing a time under routing.digests
+const defaultDigestTime = "17:00"
+
+// digestItem is one message waiting for a digest
+type digestItem struct {
+	Channel   string    `json:"channel"`
+	Text      string    `json:"text"`
+	ReleaseAt time.Time `json:"release_at"`
+}
+
+// startDigests posts each channel's digest once its time comes
+func startDigests() {
+	runEvery("digests", time.Minute, postDueDigests)
+}
+
+// queueForDigest holds msg for the channel's next digest
+func queueForDigest(msg outboundMessage) error {
+	text, err := withFallbackText(msg.Text, msg.Blocks)
+	if err != nil {
+		return fmt.Errorf("queueing message for %s digest: %w", msg.Channel, err)
+	}
+	clock, loc, err := digestTime(msg.Channel)
+	if err != nil {
+		return err
+	}
+	item := digestItem{
+		Channel:   msg.Channel,
+		Text:      text,
+		ReleaseAt: nextReminderOccurrence("day", clock, loc, time.Now()).UTC(),
+	}
+	key := fmt.Sprintf("%s/%020d", msg.Channel, time.Now().UnixNano())
+	return store.Put(digestQueueBucket, key, item)
+}
+
+// digestTime returns when channel's digest goes out: a time like 17:00,
+// optionally followed by an IANA timezone, from routing.digests
+func digestTime(channel string) (string, *time.Location, error) {
+	words := strings.Fields(appConfig.Routing.Digests[channel])
+	words, loc, ok := parseTimezoneSuffix(words)
+	if !ok {
+		loc = recipientLocation(channel)
+	}
+	if len(words) == 0 {
+		return defaultDigestTime, loc, nil
+	}
+	clock, err := parseClock(words[0])
+	if err != nil || len(words) > 1 {
+		return "", nil, fmt.Errorf("routing.digests for %s should be like \"17:00 Europe/London\"", channel)
+	}
+	return clock, loc, nil
+}
+
+// postDueDigests posts one message per channel gathering every item whose
+// digest time has come
+func postDueDigests() {
+	now := time.Now()
+	due := map[string][]string{}
+	var order []string
+	for _, key := range store.Keys(digestQueueBucket) {
+		var item digestItem
+		found, err := store.Get(digestQueueBucket, key, &item)
+		if err != nil {
+			log.Printf("Error loading digest item: %v", err)
+			continue
+		}
+		if !found || item.ReleaseAt.After(now) {
+			continue
+		}
+		if _, ok := due[item.Channel]; !ok {
+			order = append(order, item.Channel)
+		}
+		due[item.Channel] = append(due[item.Channel], key)
+	}
+
+	for _, channel := range order {
+		keys := due[channel]
+		blocks := []slack.Block{
+			slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, fmt.Sprintf("Bot digest (%d)", len(keys)), false, false)),
+		}
+		var texts []string
+		for _, key := range keys {
+			var item digestItem
+			if _, err := store.Get(digestQueueBucket, key, &item); err != nil {
+				log.Printf("Error loading digest item: %v", err)
+				continue
+			}
+			texts = append(texts, item.Text)
+			section, _ := truncateText(item.Text, maxSectionText)
+			blocks = append(blocks, slack.NewDividerBlock(), slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, section, false, false), nil, nil))
+		}
+		text := fmt.Sprintf(":newspaper: Bot digest (%d)\n%s", len(keys), strings.Join(texts, "\n"))
+		if _, err := sendMessage(outboundMessage{Channel: channel, Text: text, Blocks: blocks, routed: true}); err != nil {
+			log.Printf("Error posting digest to %s: %v", channel, err)
+			continue
+		}
+		for _, key := range keys {
+			if err := store.Delete(digestQueueBucket, key); err != nil {
+				log.Printf("Error clearing digest item: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// dndEnd returns when userID's do-not-disturb ends, or the zero time if
+// they aren't in DND now
+func dndEnd(userID string) (time.Time, error) {
+	status, err := slackClient.GetDNDInfo(&userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking DND for %s: %w", userID, err)
+	}
+	now := time.Now().Unix()
+	var end int64
+	if status.SnoozeEnabled && int64(status.SnoozeEndTime) > now {
+		end = int64(status.SnoozeEndTime)
+	}
+	if status.Enabled && int64(status.NextStartTimestamp) <= now && int64(status.NextEndTimestamp) > now {
+		end = max(end, int64(status.NextEndTimestamp))
+	}
+	if end == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(end, 0), nil
+}
+
+// sendDM messages userID directly on behalf of feature. When dnd.defer is
+// set and the user is in do-not-disturb, the message is scheduled for when
+// DND ends instead, unless feature is listed in dnd.urgent. The timestamp of
+// the message is returned, or "" if it was scheduled.
+func sendDM(feature, userID string, msg outboundMessage) (string, error) {
+	channel, err := openDM(userID)
+	if err != nil {
+		return "", err
+	}
+	msg.Channel = channel
+
+	cfg := appConfig.DND
+	if !cfg.Defer || slices.Contains(cfg.Urgent, feature) {
+		return sendMessage(msg)
+	}
+	end, err := dndEnd(userID)
+	if err != nil {
+		// Delivering now beats not delivering at all
+		log.Printf("Error checking DND, sending now: %v", err)
+		return sendMessage(msg)
+	}
+	if end.IsZero() {
+		return sendMessage(msg)
+	}
+	if err := scheduleMessage(msg, end); err != nil {
+		return "", err
+	}
+	log.Printf("Deferred %s DM to %s until their DND ends at %s", feature, userID, end.Format(time.RFC3339))
+	return "", nil
+}
+
+// scheduleMessage has Slack post msg at the given time. Scheduled messages
+// can't get a "Show more" snippet, so oversized text is only truncated.
+func scheduleMessage(msg outboundMessage, at time.Time) error {
+	var err error
+	if msg.Text, err = withFallbackText(msg.Text, msg.Blocks); err != nil {
+		return fmt.Errorf("scheduling message to %s: %w", msg.Channel, err)
+	}
+	msg.Text, msg.Blocks = withBanner(msg.Text, msg.Blocks)
+
+	for i, part := range fitMessage(msg).Parts {
+		opts := []slack.MsgOption{slack.MsgOptionText(part.Text, false)}
+		if len(part.Blocks) > 0 {
+			opts = append(opts, slack.MsgOptionBlocks(part.Blocks...))
+		}
+		if len(part.Attachments) > 0 {
+			opts = append(opts, slack.MsgOptionAttachments(part.Attachments...))
+		}
+		if part.ThreadTS != "" {
+			opts = append(opts, slack.MsgOptionTS(part.ThreadTS))
+		}
+		// A second apart, so the parts arrive in order
+		postAt := strconv.FormatInt(at.Unix()+int64(i), 10)
+		if _, _, err := slackClient.ScheduleMessage(part.Channel, postAt, opts...); err != nil {
+			return fmt.Errorf("scheduling message to %s: %w", part.Channel, err)
+		}
+	}
+	return nil
+}
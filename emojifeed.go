@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// handleEmojiChanged announces added, removed and renamed custom emoji
+func handleEmojiChanged(ev *slackevents.EmojiChangedEvent) {
+	channel := appConfig.EmojiFeed.Channel
+	if channel == "" {
+		return
+	}
+
+	var text string
+	var accessory *slack.Accessory
+	switch ev.Subtype {
+	case "add":
+		if target, ok := strings.CutPrefix(ev.Value, "alias:"); ok {
+			if !appConfig.EmojiFeed.AnnounceAliases {
+				return
+			}
+			text = fmt.Sprintf(":%s: `:%s:` was added as an alias for `:%s:`", ev.Name, ev.Name, target)
+		} else {
+			text = fmt.Sprintf(":tada: New emoji :%s: `:%s:`", ev.Name, ev.Name)
+			if ev.Value != "" {
+				accessory = slack.NewAccessory(slack.NewImageBlockElement(ev.Value, ev.Name))
+			}
+		}
+	case "remove":
+		if !appConfig.EmojiFeed.AnnounceRemovals || len(ev.Names) == 0 {
+			return
+		}
+		names := make([]string, len(ev.Names))
+		for i, name := range ev.Names {
+			names[i] = "`:" + name + ":`"
+		}
+		text = fmt.Sprintf(":wave: Removed emoji %s", strings.Join(names, ", "))
+	case "rename":
+		text = fmt.Sprintf(":%s: `:%s:` is now `:%s:`", ev.NewName, ev.OldName, ev.NewName)
+	default:
+		return
+	}
+
+	_, err := sendInWindow("emoji_feed", outboundMessage{
+		Channel: channel,
+		Text:    text,
+		Blocks:  []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, accessory)},
+	})
+	if err != nil {
+		log.Printf("Error announcing emoji change: %v", err)
+	}
+}
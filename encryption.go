@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Marks an encrypted value in the store
+const envelopeFormat = "aes-gcm-v1"
+
+// keyProvider wraps and unwraps the per-value data keys with a master key
+type keyProvider interface {
+	// WrapKey encrypts dek with the active master key
+	WrapKey(dek []byte) (keyID string, wrapped []byte, err error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+	// ActiveKeyID names the key new values are wrapped with
+	ActiveKeyID() string
+}
+
+// keyProviderFactories creates key providers by the name used in config
+var keyProviderFactories = map[string]func(EncryptionConfig) (keyProvider, error){
+	"env":           newEnvKeyProvider,
+	"vault_transit": newVaultTransitKeyProvider,
+}
+
+// The configured master key provider; nil until setupEncryption runs
+var masterKeys keyProvider
+
+var errNoMasterKey = errors.New("storing secrets needs encryption configured (STORE_MASTER_KEYS or encryption.provider)")
+
+// envelope is how a secretString is stored: the value sealed with a random
+// data key, and the data key sealed with a master key
+type envelope struct {
+	Format string `json:"enc"`
+	KeyID  string `json:"kid"`
+	DEK    []byte `json:"dek"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+// secretString is a string that is encrypted whenever it is stored. Use it
+// for tokens, credentials and other secrets kept in store values.
+type secretString string
+
+func (s secretString) MarshalJSON() ([]byte, error) {
+	if masterKeys == nil {
+		return nil, errNoMasterKey
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	nonce, data, err := sealAESGCM(dek, []byte(s))
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := masterKeys.WrapKey(dek)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+	return json.Marshal(envelope{Format: envelopeFormat, KeyID: keyID, DEK: wrapped, Nonce: nonce, Data: data})
+}
+
+func (s *secretString) UnmarshalJSON(raw []byte) error {
+	// Plain strings are values stored before they were encrypted
+	if len(raw) > 0 && raw[0] == '"' {
+		var plain string
+		if err := json.Unmarshal(raw, &plain); err != nil {
+			return err
+		}
+		*s = secretString(plain)
+		return nil
+	}
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return err
+	}
+	if env.Format != envelopeFormat {
+		return fmt.Errorf("unknown secret format %q", env.Format)
+	}
+	if masterKeys == nil {
+		return errNoMasterKey
+	}
+	dek, err := masterKeys.UnwrapKey(env.KeyID, env.DEK)
+	if err != nil {
+		return fmt.Errorf("unwrapping data key: %w", err)
+	}
+	plain, err := openAESGCM(dek, env.Nonce, env.Data)
+	if err != nil {
+		return err
+	}
+	*s = secretString(plain)
+	return nil
+}
+
+// setupEncryption configures the master key provider. Without a provider
+// or STORE_MASTER_KEYS, secrets can't be stored, but everything else works.
+func setupEncryption(cfg EncryptionConfig) error {
+	if cfg.Provider == "" {
+		if os.Getenv("STORE_MASTER_KEYS") == "" {
+			return nil
+		}
+		cfg.Provider = "env"
+	}
+	factory, ok := keyProviderFactories[cfg.Provider]
+	if !ok {
+		return fmt.Errorf("unknown encryption provider %q", cfg.Provider)
+	}
+	provider, err := factory(cfg)
+	if err != nil {
+		return err
+	}
+	masterKeys = provider
+	return nil
+}
+
+func sealAESGCM(key, plain []byte) (nonce, data []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, nil), nil
+}
+
+func openAESGCM(key, nonce, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting secret: %w", err)
+	}
+	return plain, nil
+}
+
+// envKeyProvider uses master keys from STORE_MASTER_KEYS, a comma-separated
+// list of id:base64-key pairs. The first key wraps new values; the others
+// are kept to read values wrapped before a rotation.
+type envKeyProvider struct {
+	active string
+	keys   map[string][]byte
+}
+
+func newEnvKeyProvider(EncryptionConfig) (keyProvider, error) {
+	spec := os.Getenv("STORE_MASTER_KEYS")
+	if spec == "" {
+		return nil, fmt.Errorf("STORE_MASTER_KEYS is not set")
+	}
+	p := &envKeyProvider{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("STORE_MASTER_KEYS entries must look like id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("STORE_MASTER_KEYS key %s must be 32 bytes of base64", id)
+		}
+		if p.active == "" {
+			p.active = id
+		}
+		p.keys[id] = key
+	}
+	return p, nil
+}
+
+func (p *envKeyProvider) ActiveKeyID() string { return p.active }
+
+func (p *envKeyProvider) WrapKey(dek []byte) (string, []byte, error) {
+	nonce, data, err := sealAESGCM(p.keys[p.active], dek)
+	if err != nil {
+		return "", nil, err
+	}
+	return p.active, append(nonce, data...), nil
+}
+
+func (p *envKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not in STORE_MASTER_KEYS", keyID)
+	}
+	if len(wrapped) < 12 {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	return openAESGCM(key, wrapped[:12], wrapped[12:])
+}
+
+// vaultTransitKeyProvider wraps data keys with a HashiCorp Vault transit key,
+// so the master key never leaves Vault. Vault versions the key itself.
+type vaultTransitKeyProvider struct {
+	addr, key, token string
+}
+
+func newVaultTransitKeyProvider(cfg EncryptionConfig) (keyProvider, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if cfg.VaultAddr == "" || cfg.VaultKey == "" || token == "" {
+		return nil, fmt.Errorf("vault_transit needs encryption.vault_addr, encryption.vault_key and VAULT_TOKEN")
+	}
+	return &vaultTransitKeyProvider{addr: strings.TrimSuffix(cfg.VaultAddr, "/"), key: cfg.VaultKey, token: token}, nil
+}
+
+func (p *vaultTransitKeyProvider) ActiveKeyID() string { return "vault:" + p.key }
+
+func (p *vaultTransitKeyProvider) WrapKey(dek []byte) (string, []byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dek)}
+	if err := p.call("encrypt", body, &resp); err != nil {
+		return "", nil, err
+	}
+	return p.ActiveKeyID(), []byte(resp.Data.Ciphertext), nil
+}
+
+func (p *vaultTransitKeyProvider) UnwrapKey(_ string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := p.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+func (p *vaultTransitKeyProvider) call(op string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.addr+"/v1/transit/"+op+"/"+p.key, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("calling vault: %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// reencryptStore rewraps the data key of every encrypted value in the store
+// with the active master key, so retired keys can be removed. Values stay
+// sealed with their data keys, so secrets are never written decrypted.
+func reencryptStore(s *Store) (rewrapped int, err error) {
+	for _, bucket := range s.Buckets() {
+		for _, key := range s.Keys(bucket) {
+			var raw json.RawMessage
+			if _, err := s.Get(bucket, key, &raw); err != nil {
+				return rewrapped, err
+			}
+			var value any
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return rewrapped, fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+			}
+			n, err := rewrapEnvelopes(value)
+			if err != nil {
+				return rewrapped, fmt.Errorf("rewrapping %s/%s: %w", bucket, key, err)
+			}
+			if n == 0 {
+				continue
+			}
+			if err := s.Put(bucket, key, value); err != nil {
+				return rewrapped, err
+			}
+			rewrapped += n
+		}
+	}
+	return rewrapped, nil
+}
+
+// rewrapEnvelopes finds envelopes anywhere in decoded JSON and rewraps the
+// ones not already using the active key, in place
+func rewrapEnvelopes(value any) (int, error) {
+	count := 0
+	switch v := value.(type) {
+	case map[string]any:
+		if v["enc"] == envelopeFormat {
+			keyID, _ := v["kid"].(string)
+			// Vault tracks key versions inside the ciphertext, so its keys
+			// always get rewrapped to pick up the latest version
+			if keyID == masterKeys.ActiveKeyID() && !strings.HasPrefix(keyID, "vault:") {
+				return 0, nil
+			}
+			encoded, _ := v["dek"].(string)
+			wrapped, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return 0, err
+			}
+			dek, err := masterKeys.UnwrapKey(keyID, wrapped)
+			if err != nil {
+				return 0, err
+			}
+			newID, rewrapped, err := masterKeys.WrapKey(dek)
+			if err != nil {
+				return 0, err
+			}
+			v["kid"], v["dek"] = newID, base64.StdEncoding.EncodeToString(rewrapped)
+			return 1, nil
+		}
+		for _, child := range v {
+			n, err := rewrapEnvelopes(child)
+			if err != nil {
+				return count, err
+			}
+			count += n
+		}
+	case []any:
+		for _, child := range v {
+			n, err := rewrapEnvelopes(child)
+			if err != nil {
+				return count, err
+			}
+			count += n
+		}
+	}
+	return count, nil
+}
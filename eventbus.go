@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Normalized event types published to the event bus
+const (
+	busMessageReceived  = "message.received"
+	busCommandExecuted  = "command.executed"
+	busIncidentDeclared = "incident.declared"
+)
+
+// Events waiting to be published; when the bus falls this far behind, new
+// events are dropped rather than slowing down Slack handlers
+const eventBusQueueSize = 1000
+
+// busEvent is the JSON envelope of every published event
+type busEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+	Channel string    `json:"channel,omitempty"`
+	// Data holds the type-specific fields
+	Data any `json:"data,omitempty"`
+}
+
+// busMessage is the data of a message.received event
+type busMessage struct {
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+	Text     string `json:"text"`
+}
+
+// busCommand is the data of a command.executed event
+type busCommand struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// busIncident is the data of an incident.declared event
+type busIncident struct {
+	Number   int    `json:"number"`
+	Title    string `json:"title"`
+	Severity string `json:"severity"`
+}
+
+// eventPublisher sends payloads to a message bus topic. Drivers that
+// partition topics use key to keep related events in order.
+type eventPublisher interface {
+	Publish(topic, key string, payload []byte) error
+}
+
+// eventPublisherFactories creates publishers by the driver name used in config
+var eventPublisherFactories = map[string]func(EventBusConfig) (eventPublisher, error){
+	"nats":  newNATSPublisher,
+	"kafka": newKafkaPublisher,
+}
+
+var eventBusQueue chan busEvent
+
+// setupEventBus connects to the configured bus and starts publishing
+func setupEventBus(cfg EventBusConfig) error {
+	if cfg.Driver == "" {
+		return nil
+	}
+	factory, ok := eventPublisherFactories[cfg.Driver]
+	if !ok {
+		return fmt.Errorf("unknown event bus driver %q", cfg.Driver)
+	}
+	publisher, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("connecting to event bus: %w", err)
+	}
+
+	eventBusQueue = make(chan busEvent, eventBusQueueSize)
+	go func() {
+		for ev := range eventBusQueue {
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("Error encoding %s event: %v", ev.Type, err)
+				continue
+			}
+			if err := publisher.Publish(cfg.TopicPrefix+ev.Type, ev.Channel, payload); err != nil {
+				log.Printf("Error publishing %s event: %v", ev.Type, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// publishEvent queues ev for the event bus, if one is configured and
+// publishes that type
+func publishEvent(ev busEvent) {
+	if eventBusQueue == nil {
+		return
+	}
+	if events := appConfig.EventBus.Events; len(events) > 0 && !slices.Contains(events, ev.Type) {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	select {
+	case eventBusQueue <- ev:
+	default:
+		log.Printf("Event bus queue is full, dropping %s event", ev.Type)
+	}
+}
+
+// natsPublisher publishes to NATS subjects
+type natsPublisher struct {
+	conn *nats.Conn
+}
+
+func newNATSPublisher(cfg EventBusConfig) (eventPublisher, error) {
+	url := cfg.URL
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	conn, err := nats.Connect(url, nats.Name("slack-bot"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn}, nil
+}
+
+func (p *natsPublisher) Publish(topic, _ string, payload []byte) error {
+	return p.conn.Publish(topic, payload)
+}
+
+// kafkaPublisher publishes to Kafka topics
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(cfg EventBusConfig) (eventPublisher, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka driver needs brokers")
+	}
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.Brokers...),
+		Balancer:               &kafka.Hash{},
+		AllowAutoTopicCreation: true,
+	}}, nil
+}
+
+func (p *kafkaPublisher) Publish(topic, key string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, kafka.Message{Topic: topic, Key: []byte(key), Value: payload})
+}
+
+// publishMessageReceived publishes a message posted by a user
+func publishMessageReceived(ev *slackevents.MessageEvent) {
+	if ev.User == "" || ev.User == botUserID || ev.BotID != "" {
+		return
+	}
+	publishEvent(busEvent{
+		Type:    busMessageReceived,
+		User:    ev.User,
+		Channel: ev.Channel,
+		Data:    busMessage{TS: ev.TimeStamp, ThreadTS: ev.ThreadTimeStamp, Text: ev.Text},
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// Consumer group (Kafka) or queue group (NATS) used when none is configured,
+// so replicas of the bot share the work instead of each posting every message
+const defaultBusConsumerGroup = "slack-bot"
+
+// busNotification is the data available to notification rule templates
+type busNotification struct {
+	Topic string
+	// Payload is the decoded JSON message; nil when it isn't a JSON object
+	Payload map[string]any
+	// Raw is the message exactly as received
+	Raw string
+}
+
+// eventSubscriber delivers messages from bus topics to handle
+type eventSubscriber interface {
+	Subscribe(topics []string, group string, handle func(topic string, payload []byte)) error
+}
+
+// eventSubscriberFactories creates subscribers by the driver name used in config
+var eventSubscriberFactories = map[string]func(EventBusConfig) (eventSubscriber, error){
+	"nats":  newNATSSubscriber,
+	"kafka": newKafkaSubscriber,
+}
+
+// startEventConsumer subscribes to the topics of the configured notification
+// rules
+func startEventConsumer(cfg EventBusConfig) error {
+	var topics []string
+	for _, rule := range cfg.Consume.Rules {
+		if rule.Topic == "" || rule.Channel == "" || rule.Template == "" {
+			return fmt.Errorf("event bus rules need topic, channel and template")
+		}
+		if !slices.Contains(topics, rule.Topic) {
+			topics = append(topics, rule.Topic)
+		}
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+	factory, ok := eventSubscriberFactories[cfg.Driver]
+	if !ok {
+		return fmt.Errorf("unknown event bus driver %q", cfg.Driver)
+	}
+	subscriber, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("connecting to event bus: %w", err)
+	}
+	group := cfg.Consume.Group
+	if group == "" {
+		group = defaultBusConsumerGroup
+	}
+	return subscriber.Subscribe(topics, group, handleBusNotification)
+}
+
+// handleBusNotification posts a bus message using the first rule that
+// matches it
+func handleBusNotification(topic string, payload []byte) {
+	if !isLeader() {
+		log.Printf("Dropping %s message while on standby", topic)
+		return
+	}
+	job := busNotification{Topic: topic, Raw: string(payload)}
+	if err := json.Unmarshal(payload, &job.Payload); err != nil {
+		job.Payload = nil
+	}
+	for i, rule := range appConfig.EventBus.Consume.Rules {
+		if rule.Topic != topic || !rule.matches(job.Payload) {
+			continue
+		}
+		name := fmt.Sprintf("event bus rule %d", i+1)
+		channel, err := renderTemplate(name+" channel", rule.Channel, job)
+		if err != nil {
+			log.Printf("Error routing %s message: %v", topic, err)
+			return
+		}
+		text, err := renderTemplate(name, rule.Template, job)
+		if err != nil {
+			log.Printf("Error rendering %s message: %v", topic, err)
+			return
+		}
+		channel = strings.TrimSpace(channel)
+		if !featureEnabled(featureEventBus, channel) {
+			log.Printf("Dropping %s message for %s: event_bus is switched off", topic, channel)
+			return
+		}
+		if strings.HasPrefix(channel, "U") {
+			_, err = sendDM("event_bus", channel, outboundMessage{Text: text})
+		} else {
+			_, err = sendMessage(outboundMessage{Channel: channel, Text: text})
+		}
+		if err != nil {
+			log.Printf("Error posting %s message: %v", topic, err)
+		}
+		return
+	}
+	log.Printf("No event bus rule matched a %s message", topic)
+}
+
+// matches reports whether every Match field of the rule equals the payload
+// field at that dotted path
+func (r BusRule) matches(payload map[string]any) bool {
+	for path, want := range r.Match {
+		value, ok := lookupPath(payload, path)
+		if !ok || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupPath finds a dotted path such as "deploy.env" in decoded JSON
+func lookupPath(payload map[string]any, path string) (any, bool) {
+	var value any = payload
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// natsSubscriber consumes NATS subjects through a queue group
+type natsSubscriber struct {
+	conn *nats.Conn
+}
+
+func newNATSSubscriber(cfg EventBusConfig) (eventSubscriber, error) {
+	url := cfg.URL
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	conn, err := nats.Connect(url, nats.Name("slack-bot"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSubscriber{conn: conn}, nil
+}
+
+func (s *natsSubscriber) Subscribe(topics []string, group string, handle func(string, []byte)) error {
+	for _, topic := range topics {
+		_, err := s.conn.QueueSubscribe(topic, group, func(msg *nats.Msg) {
+			runJob("event bus "+msg.Subject, func() { handle(msg.Subject, msg.Data) })
+		})
+		if err != nil {
+			return fmt.Errorf("subscribing to %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// kafkaSubscriber consumes Kafka topics as a consumer group
+type kafkaSubscriber struct {
+	brokers []string
+}
+
+func newKafkaSubscriber(cfg EventBusConfig) (eventSubscriber, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka driver needs brokers")
+	}
+	return &kafkaSubscriber{brokers: cfg.Brokers}, nil
+}
+
+func (s *kafkaSubscriber) Subscribe(topics []string, group string, handle func(string, []byte)) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     s.brokers,
+		GroupID:     group,
+		GroupTopics: topics,
+	})
+	go func() {
+		for {
+			msg, err := reader.ReadMessage(context.Background())
+			if err != nil {
+				log.Printf("Error reading from Kafka: %v", err)
+				return
+			}
+			runJob("event bus "+msg.Topic, func() { handle(msg.Topic, msg.Value) })
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for expense claims, keyed by claim ID
+const expensesBucket = "expenses"
+
+// Approval kind used for expense claims
+const expenseApprovalKind = "expense"
+
+// Flow and block IDs of the /expense modal
+const (
+	expenseFlowName         = "expense"
+	expenseAmountBlock      = "expense_amount"
+	expenseCategoryBlock    = "expense_category"
+	expenseDescriptionBlock = "expense_description"
+	expenseReceiptBlock     = "expense_receipt"
+)
+
+// Used when the expenses settings aren't set
+const defaultExpenseCurrency = "GBP"
+
+var defaultExpenseCategories = []string{"Travel", "Meals", "Equipment", "Software", "Other"}
+
+// Largest receipt copied to the approver
+const maxExpenseReceiptSize = 20 << 20
+
+// expense is a claim waiting for, or decided by, an approver
+type expense struct {
+	ID          string `json:"id"`
+	User        string `json:"user"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency"`
+	Category    string `json:"category"`
+	Description string `json:"description,omitempty"`
+	// ReceiptID is the Slack file uploaded with the claim
+	ReceiptID   string    `json:"receipt_id,omitempty"`
+	ReceiptName string    `json:"receipt_name,omitempty"`
+	ApprovalID  string    `json:"approval_id,omitempty"`
+	Status      string    `json:"status"`
+	DecidedBy   string    `json:"decided_by,omitempty"`
+	DecidedAt   time.Time `json:"decided_at,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/expense",
+		Usage:       "/expense",
+		Description: "Submit an expense claim with its receipt for approval",
+		Handler:     handleExpenseCommand,
+	})
+	registerBotCommand(&command{
+		Name:        "expenses export",
+		Usage:       "expenses export [YYYY-MM]",
+		Description: "Get a CSV of a month's expense claims; for finance and bot admins",
+		Handler:     handleExpensesExport,
+	})
+	registerModalFlow(expenseModalFlow())
+	registerApprovalHandler(expenseApprovalKind, handleExpenseDecision)
+	registerUserDataset(userDataset{Name: "expenses", Title: "Expense claims", Describe: describeUserExpenses, Delete: deleteUserExpenses})
+}
+
+func expenseCurrency() string {
+	if c := appConfig.Expenses.Currency; c != "" {
+		return strings.ToUpper(c)
+	}
+	return defaultExpenseCurrency
+}
+
+func expenseCategories() []string {
+	if len(appConfig.Expenses.Categories) > 0 {
+		return appConfig.Expenses.Categories
+	}
+	return defaultExpenseCategories
+}
+
+// expenseApprovers returns who approves userID's claims: their manager from
+// expenses.managers, or else expenses.approvers
+func expenseApprovers(userID string) []string {
+	if manager, ok := appConfig.Expenses.Managers[userID]; ok {
+		return []string{manager}
+	}
+	return appConfig.Expenses.Approvers
+}
+
+// formatExpenseAmount shows cents as an amount, like GBP 12.50
+func formatExpenseAmount(cents int64, currency string) string {
+	return fmt.Sprintf("%s %d.%02d", currency, cents/100, cents%100)
+}
+
+func handleExpenseCommand(req commandRequest) commandResponse {
+	if len(expenseApprovers(req.UserID)) == 0 {
+		return ephemeral("Expense claims aren't set up for you yet. Ask a bot admin to add your manager under `expenses.managers`.")
+	}
+	if err := openModalFlow(req.TriggerID, expenseFlowName, nil); err != nil {
+		log.Printf("Error opening expense modal: %v", err)
+		return ephemeral("Sorry, something went wrong opening the form.")
+	}
+	return ephemeral("Opening the expense form…")
+}
+
+// expenseModalFlow asks for the claim details, then for the receipt
+func expenseModalFlow() *modalFlow {
+	label := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, s, false, false)
+	}
+	return &modalFlow{
+		Name:  expenseFlowName,
+		Title: "Expense claim",
+		First: "details",
+		Steps: map[string]*modalStep{
+			"details": {
+				Blocks: func(state *modalFlowState) []slack.Block {
+					amount := slack.NewNumberInputBlockElement(nil, expenseAmountBlock, true).WithMinValue("0.01")
+					var options []*slack.OptionBlockObject
+					for _, category := range expenseCategories() {
+						options = append(options, slack.NewOptionBlockObject(category, label(category), nil))
+					}
+					category := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, label("Pick one"), expenseCategoryBlock, options...)
+					description := slack.NewPlainTextInputBlockElement(nil, expenseDescriptionBlock)
+					description.Multiline = true
+					return []slack.Block{
+						slack.NewInputBlock(expenseAmountBlock, label("Amount ("+expenseCurrency()+")"), nil, amount),
+						slack.NewInputBlock(expenseCategoryBlock, label("Category"), nil, category),
+						slack.NewInputBlock(expenseDescriptionBlock, label("What was it for?"), nil, description).WithOptional(true),
+					}
+				},
+				Validate: func(values map[string]string, state *modalFlowState) map[string]string {
+					if _, err := parseExpenseAmount(values[expenseAmountBlock]); err != nil {
+						return map[string]string{expenseAmountBlock: "Enter an amount, like 12.50."}
+					}
+					return nil
+				},
+				Next: func(state *modalFlowState) string { return "receipt" },
+			},
+			"receipt": {
+				Blocks: func(state *modalFlowState) []slack.Block {
+					receipt := slack.NewFileInputBlockElement(expenseReceiptBlock).WithMaxFiles(1).WithFileTypes("pdf", "png", "jpg", "jpeg", "heic")
+					return []slack.Block{slack.NewInputBlock(expenseReceiptBlock, label("Receipt"), nil, receipt)}
+				},
+				Validate: func(values map[string]string, state *modalFlowState) map[string]string {
+					if values[expenseReceiptBlock] == "" {
+						return map[string]string{expenseReceiptBlock: "Attach a photo or PDF of the receipt."}
+					}
+					return nil
+				},
+			},
+		},
+		Complete: completeExpenseClaim,
+	}
+}
+
+// parseExpenseAmount reads a positive amount, like 12.50, as cents
+func parseExpenseAmount(s string) (int64, error) {
+	amount, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount %v isn't positive", amount)
+	}
+	return int64(math.Round(amount * 100)), nil
+}
+
+// completeExpenseClaim saves the claim and sends it for approval
+func completeExpenseClaim(callback *slack.InteractionCallback, state *modalFlowState) map[string]string {
+	cents, err := parseExpenseAmount(state.Values[expenseAmountBlock])
+	if err != nil {
+		// Validated on the first step, so only a tampered session gets here
+		log.Printf("Error reading expense amount: %v", err)
+		return map[string]string{expenseReceiptBlock: "Sorry, your claim couldn't be read. Please start again."}
+	}
+	e := &expense{
+		ID:          newInteractionToken(),
+		User:        callback.User.ID,
+		AmountCents: cents,
+		Currency:    expenseCurrency(),
+		Category:    state.Values[expenseCategoryBlock],
+		Description: strings.TrimSpace(state.Values[expenseDescriptionBlock]),
+		ReceiptID:   state.Values[expenseReceiptBlock],
+		Status:      approvalPending,
+		SubmittedAt: time.Now().UTC(),
+	}
+	if err := store.Put(expensesBucket, e.ID, e); err != nil {
+		log.Printf("Error saving expense: %v", err)
+		return map[string]string{expenseReceiptBlock: "Sorry, your claim couldn't be saved. Please try again."}
+	}
+	// Asking for approval posts messages and copies the receipt, which can
+	// take longer than Slack waits for the modal
+	go runJob("expense approval", func() { requestExpenseApproval(e) })
+	return nil
+}
+
+// requestExpenseApproval asks the claimant's approver to decide the claim
+// and copies the receipt under the request
+func requestExpenseApproval(e *expense) {
+	details := "*Category:* " + e.Category
+	if e.Description != "" {
+		details += "\n*For:* " + e.Description
+	}
+	a, err := requestApproval(approvalContext{
+		Kind:        expenseApprovalKind,
+		Title:       fmt.Sprintf("%s expense claim", formatExpenseAmount(e.AmountCents, e.Currency)),
+		Details:     details,
+		RequestedBy: e.User,
+		TTL:         7 * 24 * time.Hour,
+	}, expenseApprovers(e.User), e.ID)
+	if err != nil {
+		log.Printf("Error requesting expense approval: %v", err)
+		if err := store.Delete(expensesBucket, e.ID); err != nil {
+			log.Printf("Error removing expense: %v", err)
+		}
+		if _, err := sendDM("expenses", e.User, outboundMessage{Text: "Sorry, I couldn't send your expense claim for approval. Please try again, or ask a bot admin to check `expenses` in the config."}); err != nil {
+			log.Printf("Error replying to expense claim: %v", err)
+		}
+		return
+	}
+	e.ApprovalID = a.ID
+	if err := store.Put(expensesBucket, e.ID, e); err != nil {
+		log.Printf("Error saving expense: %v", err)
+	}
+	if _, err := sendDM("expenses", e.User, outboundMessage{Text: fmt.Sprintf(":receipt: Your %s claim is with %s for approval.",
+		formatExpenseAmount(e.AmountCents, e.Currency), mentionList(a.Approvers))}); err != nil {
+		log.Printf("Error confirming expense claim: %v", err)
+	}
+
+	// The receipt was uploaded privately, so approvers get their own copy
+	file, _, _, err := slackClient.GetFileInfo(e.ReceiptID, 0, 0)
+	if err != nil {
+		log.Printf("Error fetching receipt %s: %v", e.ReceiptID, err)
+		return
+	}
+	// The modal only hands over the file ID, so the name is filled in here
+	e.ReceiptName = file.Name
+	if err := store.Put(expensesBucket, e.ID, e); err != nil {
+		log.Printf("Error saving expense: %v", err)
+	}
+	if file.Size > maxExpenseReceiptSize {
+		log.Printf("Not copying receipt %s: %d bytes is too large", file.ID, file.Size)
+		return
+	}
+	var content bytes.Buffer
+	if err := slackClient.GetFile(file.URLPrivateDownload, &content); err != nil {
+		log.Printf("Error downloading receipt %s: %v", file.ID, err)
+		return
+	}
+	for _, m := range a.Messages {
+		_, err := slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+			Channel:         m.Channel,
+			ThreadTimestamp: m.TS,
+			Reader:          bytes.NewReader(content.Bytes()),
+			FileSize:        content.Len(),
+			Filename:        file.Name,
+			Title:           "Receipt",
+		})
+		if err != nil {
+			log.Printf("Error copying receipt to %s: %v", m.Channel, err)
+		}
+	}
+}
+
+// handleExpenseDecision records the decision and tells the claimant
+func handleExpenseDecision(a *approval) {
+	var id string
+	if err := a.decodePayload(&id); err != nil {
+		log.Printf("Error reading expense approval %s: %v", a.ID, err)
+		return
+	}
+	e := &expense{}
+	found, err := store.Get(expensesBucket, id, e)
+	if err != nil || !found {
+		if err != nil {
+			log.Printf("Error loading expense: %v", err)
+		}
+		return
+	}
+	e.Status, e.DecidedAt = a.Status, a.DecidedAt
+	if n := len(a.Responses); n > 0 {
+		e.DecidedBy = a.Responses[n-1].User
+	}
+	if err := store.Put(expensesBucket, e.ID, e); err != nil {
+		log.Printf("Error saving expense decision: %v", err)
+	}
+
+	amount := formatExpenseAmount(e.AmountCents, e.Currency)
+	var text string
+	switch e.Status {
+	case approvalApproved:
+		text = fmt.Sprintf(":white_check_mark: <@%s> approved your %s %s claim.", e.DecidedBy, amount, e.Category)
+	case approvalDenied:
+		text = fmt.Sprintf(":no_entry: <@%s> turned down your %s %s claim. Have a word with them if you're not sure why.", e.DecidedBy, amount, e.Category)
+	default:
+		text = fmt.Sprintf(":hourglass: Your %s %s claim expired before anyone decided it. Submit it again with `/expense`.", amount, e.Category)
+	}
+	if _, err := sendDM("expenses", e.User, outboundMessage{Text: text}); err != nil {
+		log.Printf("Error telling claimant about expense decision: %v", err)
+	}
+}
+
+// handleExpensesExport sends a CSV of the month's claims, this month by
+// default. Looking up every claimant's name takes a while, so the export
+// is sent once it's ready.
+func handleExpensesExport(req commandRequest) commandResponse {
+	if !isAdmin(req.UserID) && !slices.Contains(appConfig.Expenses.Finance, req.UserID) {
+		return ephemeral("Sorry, only finance and bot admins can export expense claims.")
+	}
+	month := time.Now().UTC().Format("2006-01")
+	if len(req.Args) > 0 {
+		if _, err := time.Parse("2006-01", req.Args[0]); err != nil {
+			return ephemeral("Usage: `%s expenses export [YYYY-MM]`", botCommand)
+		}
+		month = req.Args[0]
+	}
+	go runJob("expenses export", func() {
+		text := deliverExpensesExport(req.UserID, month)
+		if err := respond(req.ResponseURL, &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeEphemeral}); err != nil {
+			log.Printf("Error replying to expenses export: %v", err)
+		}
+	})
+	return ephemeral(":package: Preparing the claims from %s… I'll send you the file in a DM.", month)
+}
+
+// deliverExpensesExport uploads the month's claims to the user's DM,
+// returning what to tell them
+func deliverExpensesExport(userID, month string) string {
+	claims, err := storeList[expense](store, expensesBucket)
+	if err != nil {
+		log.Printf("Error loading expenses: %v", err)
+		return "Sorry, something went wrong exporting the claims."
+	}
+	claims = slices.DeleteFunc(claims, func(e expense) bool { return e.SubmittedAt.Format("2006-01") != month })
+	if len(claims) == 0 {
+		return fmt.Sprintf("There are no expense claims from %s.", month)
+	}
+	slices.SortFunc(claims, func(a, b expense) int { return a.SubmittedAt.Compare(b.SubmittedAt) })
+
+	names := map[string]string{}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"id", "submitted_at", "claimant", "amount", "currency", "category", "description", "status", "decided_by", "decided_at", "receipt"})
+	for _, e := range claims {
+		decidedBy, decidedAt := "", ""
+		if e.DecidedBy != "" {
+			decidedBy = exportUserName(e.DecidedBy, names)
+		}
+		if !e.DecidedAt.IsZero() {
+			decidedAt = e.DecidedAt.Format(time.RFC3339)
+		}
+		w.Write([]string{
+			e.ID, e.SubmittedAt.Format(time.RFC3339), csvText(exportUserName(e.User, names)),
+			fmt.Sprintf("%d.%02d", e.AmountCents/100, e.AmountCents%100), e.Currency, csvText(e.Category), csvText(e.Description),
+			e.Status, csvText(decidedBy), decidedAt, csvText(e.ReceiptName),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Error rendering expenses CSV: %v", err)
+		return "Sorry, something went wrong exporting the claims."
+	}
+	dm, err := openDM(userID)
+	if err == nil {
+		_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+			Channel:  dm,
+			Content:  buf.String(),
+			FileSize: buf.Len(),
+			Filename: "expenses-" + month + ".csv",
+			Title:    "Expense claims for " + month,
+		})
+	}
+	if err != nil {
+		log.Printf("Error uploading expenses export: %v", err)
+		return "Sorry, something went wrong sending you the export."
+	}
+	return fmt.Sprintf(":white_check_mark: I've sent you %s from %s.", plural(len(claims), "claim"), month)
+}
+
+// csvText keeps text someone typed from being run as a formula when the
+// CSV is opened in a spreadsheet
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func describeUserExpenses(userID string) (string, error) {
+	claims, err := storeList[expense](store, expensesBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, e := range claims {
+		if e.User == userID {
+			items = append(items, fmt.Sprintf("%s %s on %s: %s", formatExpenseAmount(e.AmountCents, e.Currency), e.Category, e.SubmittedAt.Format("2 Jan 2006"), e.Status))
+		}
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserExpenses keeps claims, which finance needs for its records,
+// but drops the description
+func deleteUserExpenses(userID string) error {
+	claims, err := storeList[expense](store, expensesBucket)
+	if err != nil {
+		return err
+	}
+	for _, e := range claims {
+		if e.User != userID || e.Description == "" {
+			continue
+		}
+		e.Description = ""
+		if err := store.Put(expensesBucket, e.ID, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
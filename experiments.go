@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store buckets for experiments: messages that showed a variant, keyed by
+// channel/ts, and per-variant counters, keyed by experiment/variant
+const (
+	experimentMessagesBucket = "experiment_messages"
+	experimentStatsBucket    = "experiment_stats"
+)
+
+// Experiment bucketing units
+const (
+	experimentUnitUser    = "user"
+	experimentUnitChannel = "channel"
+)
+
+// Experiment serves different variants of a template or prompt to
+// deterministic buckets of users or channels
+type Experiment struct {
+	Name string `yaml:"name"`
+	// Unit is user (the default) or channel
+	Unit     string              `yaml:"unit"`
+	Variants []ExperimentVariant `yaml:"variants"`
+}
+
+// ExperimentVariant is one arm of an experiment
+type ExperimentVariant struct {
+	Name string `yaml:"name"`
+	// Weight is the variant's relative share of units; defaults to 1
+	Weight int `yaml:"weight"`
+	// Template replaces a message template, like onboarding's welcome;
+	// Prompt replaces a language model system prompt, like the one for
+	// mentions
+	Template string `yaml:"template"`
+	Prompt   string `yaml:"prompt"`
+}
+
+// experimentMessage links a posted message to the variant it showed
+type experimentMessage struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+	Engaged    bool   `json:"engaged"`
+}
+
+// experimentStats counts how a variant's messages were received
+type experimentStats struct {
+	Experiment string `json:"experiment" table:"Experiment"`
+	Variant    string `json:"variant" table:"Variant"`
+	Exposures  int    `json:"exposures" table:"Shown"`
+	Clicks     int    `json:"clicks" table:"Clicks"`
+	Reactions  int    `json:"reactions" table:"Reactions"`
+	Replies    int    `json:"replies" table:"Replies"`
+	// Engaged counts messages with at least one click, reaction or reply
+	Engaged int    `json:"engaged" table:"Engaged"`
+	Rate    string `json:"-" table:"Engagement"`
+}
+
+// Engagement kinds
+const (
+	engagementClick    = "click"
+	engagementReaction = "reaction"
+	engagementReply    = "reply"
+)
+
+// Serializes counter updates
+var experimentsMu sync.Mutex
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin experiments",
+		Usage:       "admin experiments",
+		Description: "Show how each experiment variant is performing",
+		AdminOnly:   true,
+		Handler:     handleExperimentsReport,
+	})
+}
+
+// experimentVariant picks the variant of the named experiment for a user or
+// channel. The same unit always gets the same variant. ok is false when the
+// experiment isn't configured.
+func experimentVariant(name, userID, channelID string) (variant ExperimentVariant, ok bool) {
+	for _, exp := range appConfig.Experiments {
+		if exp.Name != name || len(exp.Variants) == 0 {
+			continue
+		}
+		unit := userID
+		if exp.Unit == experimentUnitChannel {
+			unit = channelID
+		}
+		total := 0
+		for _, v := range exp.Variants {
+			total += max(v.Weight, 1)
+		}
+		h := fnv.New32a()
+		h.Write([]byte(name + "/" + unit))
+		point := int(h.Sum32() % uint32(total))
+		for _, v := range exp.Variants {
+			point -= max(v.Weight, 1)
+			if point < 0 {
+				return v, true
+			}
+		}
+	}
+	return ExperimentVariant{}, false
+}
+
+// recordExposure remembers that the message at channel/ts showed variant, so
+// engagement with it can be attributed
+func recordExposure(experiment, variant, channel, ts string) {
+	if ts == "" {
+		return
+	}
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	msg := experimentMessage{Experiment: experiment, Variant: variant}
+	if err := store.Put(experimentMessagesBucket, channel+"/"+ts, msg); err != nil {
+		log.Printf("Error recording experiment exposure: %v", err)
+		return
+	}
+	updateExperimentStats(experiment, variant, func(stats *experimentStats) { stats.Exposures++ })
+}
+
+// recordEngagement counts a click, reaction or reply on a message that
+// showed an experiment variant
+func recordEngagement(channel, ts, kind string) {
+	if channel == "" || ts == "" {
+		return
+	}
+	experimentsMu.Lock()
+	defer experimentsMu.Unlock()
+	var msg experimentMessage
+	key := channel + "/" + ts
+	found, err := store.Get(experimentMessagesBucket, key, &msg)
+	if err != nil {
+		log.Printf("Error loading experiment message: %v", err)
+		return
+	}
+	if !found {
+		return
+	}
+	firstEngagement := !msg.Engaged
+	if firstEngagement {
+		msg.Engaged = true
+		if err := store.Put(experimentMessagesBucket, key, msg); err != nil {
+			log.Printf("Error recording experiment engagement: %v", err)
+			return
+		}
+	}
+	updateExperimentStats(msg.Experiment, msg.Variant, func(stats *experimentStats) {
+		switch kind {
+		case engagementClick:
+			stats.Clicks++
+		case engagementReaction:
+			stats.Reactions++
+		case engagementReply:
+			stats.Replies++
+		}
+		if firstEngagement {
+			stats.Engaged++
+		}
+	})
+}
+
+// updateExperimentStats applies update to a variant's counters. Callers must
+// hold experimentsMu.
+func updateExperimentStats(experiment, variant string, update func(*experimentStats)) {
+	key := experiment + "/" + variant
+	stats := experimentStats{Experiment: experiment, Variant: variant}
+	if _, err := store.Get(experimentStatsBucket, key, &stats); err != nil {
+		log.Printf("Error loading experiment stats: %v", err)
+		return
+	}
+	update(&stats)
+	if err := store.Put(experimentStatsBucket, key, stats); err != nil {
+		log.Printf("Error saving experiment stats: %v", err)
+	}
+}
+
+// handleExperimentReaction counts reactions to experiment messages
+func handleExperimentReaction(ev *slackevents.ReactionAddedEvent) {
+	if ev.User != botUserID {
+		recordEngagement(ev.Item.Channel, ev.Item.Timestamp, engagementReaction)
+	}
+}
+
+// handleExperimentReply counts thread replies to experiment messages
+func handleExperimentReply(ev *slackevents.MessageEvent) {
+	if ev.ThreadTimeStamp != "" && ev.ThreadTimeStamp != ev.TimeStamp && ev.User != botUserID {
+		recordEngagement(ev.Channel, ev.ThreadTimeStamp, engagementReply)
+	}
+}
+
+// handleExperimentClick counts button clicks on experiment messages
+func handleExperimentClick(callback *slack.InteractionCallback) {
+	recordEngagement(callback.Container.ChannelID, callback.Container.MessageTs, engagementClick)
+}
+
+func handleExperimentsReport(req commandRequest) commandResponse {
+	stats, err := storeList[experimentStats](store, experimentStatsBucket)
+	if err != nil {
+		log.Printf("Error loading experiment stats: %v", err)
+		return ephemeral("Sorry, something went wrong loading the experiments.")
+	}
+	if len(stats) == 0 {
+		return ephemeral("No experiment variants have been shown yet.")
+	}
+	for i := range stats {
+		if stats[i].Exposures > 0 {
+			stats[i].Rate = fmt.Sprintf("%.1f%%", 100*float64(stats[i].Engaged)/float64(stats[i].Exposures))
+		}
+	}
+	table, err := renderTable(stats, tableOptions{})
+	if err != nil {
+		log.Printf("Error rendering experiment report: %v", err)
+		return ephemeral("Sorry, something went wrong building the report.")
+	}
+	return ephemeral("%s", table)
+}
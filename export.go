@@ -0,0 +1,614 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// Used when exports.max_messages and exports.max_bytes aren't set
+const (
+	defaultExportMaxMessages = 5000
+	defaultExportMaxBytes    = 10 << 20
+)
+
+// Formats an export can be rendered in, by the name used with --format
+var exportFormats = map[string]exportFormat{
+	"markdown": {Ext: "md", MIME: "text/markdown; charset=utf-8", Render: renderExportMarkdown},
+	"html":     {Ext: "html", MIME: "text/html; charset=utf-8", Render: renderExportHTML},
+	"json":     {Ext: "json", MIME: "application/json", Render: renderExportJSON},
+}
+
+// Matches user, channel and link markup in message text, like <@U123>,
+// <#C123|general> and <https://example.com|a link>
+var slackMarkupPattern = regexp.MustCompile(`<([@#!]?)([^>|]+)(?:\|([^>]*))?>`)
+
+var errExportTooLarge = errors.New("export is larger than exports.max_bytes")
+
+// exportFormat renders an export as a file
+type exportFormat struct {
+	Ext    string
+	MIME   string
+	Render func(conversationExport) ([]byte, error)
+}
+
+// conversationExport is a thread or a stretch of a channel's history
+type conversationExport struct {
+	Channel     string    `json:"channel"`
+	ChannelName string    `json:"channel_name"`
+	ThreadTS    string    `json:"thread_ts,omitempty"`
+	Since       time.Time `json:"since,omitempty"`
+	ExportedAt  time.Time `json:"exported_at"`
+	ExportedBy  string    `json:"exported_by"`
+	// Truncated is set when exports.max_messages cut the export short
+	Truncated bool              `json:"truncated,omitempty"`
+	Messages  []exportedMessage `json:"messages"`
+}
+
+// exportedMessage is one message of an export, with mentions resolved to
+// names
+type exportedMessage struct {
+	TS       string            `json:"ts"`
+	Time     time.Time         `json:"time"`
+	User     string            `json:"user,omitempty"`
+	UserName string            `json:"user_name"`
+	Text     string            `json:"text"`
+	Files    []string          `json:"files,omitempty"`
+	Replies  []exportedMessage `json:"replies,omitempty"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "export thread",
+		Usage:       "export thread <message link> [--format markdown|html|json]",
+		Description: "Get a thread you can see as a file",
+		Handler:     handleExportThreadCommand,
+	})
+	registerBotCommand(&command{
+		Name:        "export channel",
+		Usage:       "export channel [#channel] [--since 7d|2024-01-31] [--format markdown|html|json]",
+		Description: "Get a channel's history as a file; bot admins and auditors only",
+		Handler:     handleExportChannelCommand,
+	})
+	registerMessageShortcut("export_thread", handleExportThreadShortcut)
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/admin/exports",
+		Scope:       scopeAdmin,
+		OperationID: "ExportConversation",
+		Summary:     "Export a channel's history or one thread, with user names resolved",
+		Query: []apiParam{
+			{Name: "channel", Description: "Channel ID", Required: true},
+			{Name: "thread", Description: "Timestamp of a thread's first message; exports only that thread"},
+			{Name: "since", Description: "Only messages after this, like 7d or 2024-01-31 (channel exports)"},
+			{Name: "format", Description: "json (default), markdown or html"},
+		},
+		Response: conversationExport{},
+		Handler:  handleAPIExport,
+	})
+}
+
+func exportMaxMessages() int {
+	if n := appConfig.Exports.MaxMessages; n > 0 {
+		return n
+	}
+	return defaultExportMaxMessages
+}
+
+func exportMaxBytes() int {
+	if n := appConfig.Exports.MaxBytes; n > 0 {
+		return n
+	}
+	return defaultExportMaxBytes
+}
+
+// exportOptions are the flags shared by the export commands, with the
+// words left over
+type exportOptions struct {
+	Format string
+	Since  time.Time
+	Args   []string
+}
+
+func parseExportOptions(args []string) (exportOptions, error) {
+	opts := exportOptions{Format: "markdown"}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format", "--since":
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("%s needs a value", args[i])
+			}
+			if args[i] == "--format" {
+				opts.Format = strings.ToLower(args[i+1])
+				if _, ok := exportFormats[opts.Format]; !ok {
+					return opts, fmt.Errorf("I can export as markdown, html or json, not %q", args[i+1])
+				}
+			} else {
+				since, err := parseExportSince(args[i+1], time.Now())
+				if err != nil {
+					return opts, err
+				}
+				opts.Since = since
+			}
+			i++
+		default:
+			opts.Args = append(opts.Args, args[i])
+		}
+	}
+	return opts, nil
+}
+
+// parseExportSince understands an age like 7d, 12h or 90m, or a date like
+// 2024-01-31 in UTC
+func parseExportSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("I don't understand --since %q; use an age like 7d or a date like 2024-01-31", s)
+}
+
+func handleExportThreadCommand(req commandRequest) commandResponse {
+	usage := "Usage: `" + botCommand + " export thread <message link> [--format markdown|html|json]`"
+	opts, err := parseExportOptions(req.Args)
+	if err != nil {
+		return ephemeral("%v. %s", err, usage)
+	}
+	if len(opts.Args) != 1 {
+		return ephemeral("%s", usage)
+	}
+	match := permalinkPattern.FindStringSubmatch(opts.Args[0])
+	if match == nil {
+		return ephemeral("That doesn't look like a message link. Use *Copy link* on the message.")
+	}
+	channel, ts := match[1], match[2]+"."+match[3]
+	if m := permalinkThreadPattern.FindStringSubmatch(opts.Args[0]); m != nil {
+		ts = m[1]
+	}
+	return startExport(req, channel, ts, opts)
+}
+
+func handleExportChannelCommand(req commandRequest) commandResponse {
+	usage := "Usage: `" + botCommand + " export channel [#channel] [--since 7d|2024-01-31] [--format markdown|html|json]`"
+	role := roleAdmin
+	if !isAdmin(req.UserID) {
+		if !isAuditor(req.UserID) {
+			return ephemeral("Sorry, only bot admins and auditors can export whole channels. You can export a thread with `%s export thread`.", botCommand)
+		}
+		role = roleAuditor
+	}
+	opts, err := parseExportOptions(req.Args)
+	if err != nil {
+		return ephemeral("%v. %s", err, usage)
+	}
+	arg := ""
+	switch len(opts.Args) {
+	case 0:
+	case 1:
+		if !channelMentionPattern.MatchString(opts.Args[0]) {
+			return ephemeral("%s", usage)
+		}
+		arg = opts.Args[0]
+	default:
+		return ephemeral("%s", usage)
+	}
+	// Run from a DM, this is the default channel or the one last exported
+	target, err := inferChannelTarget(req, "export channel", arg)
+	if err != nil {
+		log.Printf("Error picking channel: %v", err)
+		return ephemeral("Sorry, something went wrong exporting the channel.")
+	}
+	channel := target.ChannelID
+	if isDMChannel(channel) {
+		return ephemeral("Say which channel to export, or set a default with `%s channel default #channel`. %s", botCommand, usage)
+	}
+	recordAdminAudit(req.UserID, role, "command", fmt.Sprintf("export channel <#%s>", channel))
+	return startExport(req, channel, "", opts)
+}
+
+// startExport checks the user can see channel and sends them the export in
+// a DM once it's ready, which can take a while for long histories
+func startExport(req commandRequest, channel, threadTS string, opts exportOptions) commandResponse {
+	member, err := isChannelMember(channel, req.UserID)
+	if err != nil {
+		log.Printf("Error checking channel membership: %v", err)
+		return ephemeral("Sorry, I couldn't look at <#%s>. Is the bot in the channel?", channel)
+	}
+	if !member {
+		return ephemeral("You can only export conversations in channels you're in.")
+	}
+	go runJob("export", func() {
+		text := deliverExport(req.UserID, channel, threadTS, opts)
+		if err := respond(req.ResponseURL, &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeEphemeral}); err != nil {
+			log.Printf("Error replying to export: %v", err)
+		}
+	})
+	return ephemeral(":package: Exporting… I'll send you the file in a DM.")
+}
+
+// handleExportThreadShortcut sends the user the thread as Markdown
+func handleExportThreadShortcut(callback *slack.InteractionCallback) {
+	threadTS := callback.Message.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = callback.Message.Timestamp
+	}
+	go runJob("export", func() {
+		member, err := isChannelMember(callback.Channel.ID, callback.User.ID)
+		if err != nil || !member {
+			if err != nil {
+				log.Printf("Error checking channel membership: %v", err)
+			}
+			shortcutReply(callback, "You can only export conversations in channels you're in.")
+			return
+		}
+		shortcutReply(callback, deliverExport(callback.User.ID, callback.Channel.ID, threadTS, exportOptions{Format: "markdown"}))
+	})
+}
+
+// deliverExport uploads the export to the user's DM, returning what to
+// tell them
+func deliverExport(userID, channel, threadTS string, opts exportOptions) string {
+	export, err := exportConversation(channel, threadTS, opts.Since, userID)
+	if err != nil {
+		log.Printf("Error exporting %s: %v", channel, err)
+		return "Sorry, something went wrong exporting that conversation."
+	}
+	format := exportFormats[opts.Format]
+	data, err := renderExport(export, format)
+	if errors.Is(err, errExportTooLarge) {
+		return fmt.Sprintf("That export is over %d MB. Try a shorter `--since`.", exportMaxBytes()>>20)
+	}
+	if err != nil {
+		log.Printf("Error rendering export: %v", err)
+		return "Sorry, something went wrong exporting that conversation."
+	}
+	dm, err := openDM(userID)
+	if err == nil {
+		_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+			Channel:  dm,
+			Content:  string(data),
+			FileSize: len(data),
+			Filename: exportFilename(export, format),
+			Title:    "Export of #" + export.ChannelName,
+		})
+	}
+	if err != nil {
+		log.Printf("Error uploading export: %v", err)
+		return "Sorry, something went wrong sending you the export."
+	}
+	note := ""
+	if export.Truncated {
+		note = fmt.Sprintf(" It stops at the limit of %d messages.", exportMaxMessages())
+	}
+	return fmt.Sprintf(":white_check_mark: I've sent you %d messages from <#%s>.%s", countExportedMessages(export.Messages), channel, note)
+}
+
+// exportConversation fetches a thread, or the channel's history since
+// since, with replies, and resolves user names
+func exportConversation(channel, threadTS string, since time.Time, exportedBy string) (conversationExport, error) {
+	info, err := slackClient.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel})
+	if err != nil {
+		return conversationExport{}, fmt.Errorf("looking up channel %s: %w", channel, err)
+	}
+	export := conversationExport{
+		Channel:     channel,
+		ChannelName: info.Name,
+		ThreadTS:    threadTS,
+		Since:       since,
+		ExportedAt:  time.Now().UTC(),
+		ExportedBy:  exportedBy,
+	}
+	limit := exportMaxMessages()
+	names := map[string]string{}
+
+	if threadTS != "" {
+		thread, err := fetchThread(channel, threadTS)
+		if err != nil {
+			return export, err
+		}
+		if len(thread) > limit {
+			thread, export.Truncated = thread[:limit], true
+		}
+		export.Messages = exportMessages(thread, names)
+		return export, nil
+	}
+
+	var history []slack.Message
+	params := &slack.GetConversationHistoryParameters{ChannelID: channel, Limit: 200}
+	if !since.IsZero() {
+		params.Oldest = fmt.Sprintf("%d.000000", since.Unix())
+	}
+	count := 0
+	for {
+		page, err := slackClient.GetConversationHistory(params)
+		if err != nil {
+			return export, fmt.Errorf("fetching history of %s: %w", channel, err)
+		}
+		for _, msg := range page.Messages {
+			if count >= limit {
+				export.Truncated = true
+				break
+			}
+			history = append(history, msg)
+			count++
+		}
+		if export.Truncated || !page.HasMore || page.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.ResponseMetaData.NextCursor
+	}
+	// History comes newest first
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	export.Messages = exportMessages(history, names)
+	for i, msg := range history {
+		if msg.ReplyCount == 0 || count >= limit {
+			continue
+		}
+		thread, err := fetchThread(channel, msg.Timestamp)
+		if err != nil {
+			return export, err
+		}
+		// The first message of a thread is already in the history
+		replies := thread[min(1, len(thread)):]
+		if count+len(replies) > limit {
+			replies, export.Truncated = replies[:limit-count], true
+		}
+		count += len(replies)
+		export.Messages[i].Replies = exportMessages(replies, names)
+	}
+	return export, nil
+}
+
+// exportMessages converts messages, looking up names into names as it goes
+func exportMessages(messages []slack.Message, names map[string]string) []exportedMessage {
+	out := make([]exportedMessage, 0, len(messages))
+	for _, msg := range messages {
+		m := exportedMessage{
+			TS:       msg.Timestamp,
+			Time:     slackTSTime(msg.Timestamp),
+			User:     msg.User,
+			UserName: msg.Username,
+			Text:     resolveMarkup(msg.Text, names),
+		}
+		if msg.User != "" {
+			m.UserName = exportUserName(msg.User, names)
+		}
+		if m.UserName == "" {
+			m.UserName = "A bot"
+		}
+		for _, file := range msg.Files {
+			m.Files = append(m.Files, file.Name)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// exportUserName returns a user's display name, remembering it in names
+func exportUserName(userID string, names map[string]string) string {
+	if name, ok := names[userID]; ok {
+		return name
+	}
+	name := userID
+	if user, err := slackClient.GetUserInfo(userID); err != nil {
+		log.Printf("Error looking up user %s: %v", userID, err)
+	} else if user.Profile.DisplayName != "" {
+		name = user.Profile.DisplayName
+	} else if user.RealName != "" {
+		name = user.RealName
+	}
+	names[userID] = name
+	return name
+}
+
+// resolveMarkup turns Slack's markup into plain text: <@U123> into @name,
+// <#C123|general> into #general and links into their labels and URLs
+func resolveMarkup(text string, names map[string]string) string {
+	text = slackMarkupPattern.ReplaceAllStringFunc(text, func(s string) string {
+		m := slackMarkupPattern.FindStringSubmatch(s)
+		kind, target, label := m[1], m[2], m[3]
+		switch {
+		case kind == "@":
+			return "@" + exportUserName(target, names)
+		case kind == "#" && label != "":
+			return "#" + label
+		case kind == "#":
+			return "#" + target
+		case kind == "!":
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimPrefix(target, "subteam^")
+		case label != "" && label != target:
+			return label + " (" + target + ")"
+		}
+		return target
+	})
+	return html.UnescapeString(text)
+}
+
+func countExportedMessages(messages []exportedMessage) int {
+	n := len(messages)
+	for _, m := range messages {
+		n += len(m.Replies)
+	}
+	return n
+}
+
+// renderExport renders export, refusing ones over exports.max_bytes
+func renderExport(export conversationExport, format exportFormat) ([]byte, error) {
+	data, err := format.Render(export)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > exportMaxBytes() {
+		return nil, errExportTooLarge
+	}
+	return data, nil
+}
+
+func exportFilename(export conversationExport, format exportFormat) string {
+	name := export.ChannelName + "-" + export.ExportedAt.Format("20060102")
+	if export.ThreadTS != "" {
+		name = export.ChannelName + "-thread-" + strings.ReplaceAll(export.ThreadTS, ".", "")
+	}
+	return name + "." + format.Ext
+}
+
+// exportHeading describes what an export covers
+func exportHeading(export conversationExport) string {
+	what := "History of #" + export.ChannelName
+	if export.ThreadTS != "" {
+		what = "Thread in #" + export.ChannelName
+	} else if !export.Since.IsZero() {
+		what += " since " + export.Since.Format(time.DateOnly)
+	}
+	return what
+}
+
+func renderExportJSON(export conversationExport) ([]byte, error) {
+	return json.MarshalIndent(export, "", "  ")
+}
+
+func renderExportMarkdown(export conversationExport) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\nExported %s", exportHeading(export), export.ExportedAt.Format(time.RFC1123))
+	if export.Truncated {
+		b.WriteString(", cut short at the message limit")
+	}
+	b.WriteString(".\n")
+	var write func(m exportedMessage, quote string)
+	write = func(m exportedMessage, quote string) {
+		fmt.Fprintf(&b, "\n%s**%s** · %s\n%s\n", quote, m.UserName, m.Time.Format("2006-01-02 15:04 MST"), quote)
+		for _, line := range strings.Split(m.Text, "\n") {
+			b.WriteString(quote + line + "\n")
+		}
+		for _, file := range m.Files {
+			fmt.Fprintf(&b, "%s_Attached: %s_\n", quote, file)
+		}
+		for _, reply := range m.Replies {
+			write(reply, quote+"> ")
+		}
+	}
+	for _, m := range export.Messages {
+		write(m, "")
+	}
+	return []byte(b.String()), nil
+}
+
+func renderExportHTML(export conversationExport) ([]byte, error) {
+	var b strings.Builder
+	heading := html.EscapeString(exportHeading(export))
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title>\n", heading)
+	b.WriteString("<style>body{font-family:sans-serif;max-width:50em;margin:auto}.msg{margin:1em 0}.replies{margin-left:2em;border-left:3px solid #ddd;padding-left:1em}time{color:#666;font-size:small}p{white-space:pre-wrap;margin:.2em 0}</style>\n")
+	fmt.Fprintf(&b, "</head><body>\n<h1>%s</h1>\n<p>Exported %s", heading, export.ExportedAt.Format(time.RFC1123))
+	if export.Truncated {
+		b.WriteString(", cut short at the message limit")
+	}
+	b.WriteString(".</p>\n")
+	var write func(m exportedMessage)
+	write = func(m exportedMessage) {
+		fmt.Fprintf(&b, "<div class=\"msg\"><strong>%s</strong> <time datetime=\"%s\">%s</time>\n<p>%s</p>\n",
+			html.EscapeString(m.UserName), m.Time.Format(time.RFC3339), m.Time.Format("2006-01-02 15:04 MST"), html.EscapeString(m.Text))
+		for _, file := range m.Files {
+			fmt.Fprintf(&b, "<p><em>Attached: %s</em></p>\n", html.EscapeString(file))
+		}
+		if len(m.Replies) > 0 {
+			b.WriteString("<div class=\"replies\">\n")
+			for _, reply := range m.Replies {
+				write(reply)
+			}
+			b.WriteString("</div>\n")
+		}
+		b.WriteString("</div>\n")
+	}
+	for _, m := range export.Messages {
+		write(m)
+	}
+	b.WriteString("</body></html>\n")
+	return []byte(b.String()), nil
+}
+
+// isChannelMember reports whether userID is in channel
+func isChannelMember(channel, userID string) (bool, error) {
+	params := &slack.GetUsersInConversationParameters{ChannelID: channel, Limit: 1000}
+	for {
+		members, cursor, err := slackClient.GetUsersInConversation(params)
+		if err != nil {
+			return false, fmt.Errorf("listing members of %s: %w", channel, err)
+		}
+		for _, member := range members {
+			if member == userID {
+				return true, nil
+			}
+		}
+		if cursor == "" {
+			return false, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+func handleAPIExport(c *gin.Context) {
+	channel := c.Query("channel")
+	if channel == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel is required"})
+		return
+	}
+	formatName := c.DefaultQuery("format", "json")
+	format, ok := exportFormats[formatName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, markdown or html"})
+		return
+	}
+	var since time.Time
+	if s := c.Query("since"); s != "" {
+		var err error
+		if since, err = parseExportSince(s, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	caller := apiCaller(c)
+	export, err := exportConversation(channel, c.Query("thread"), since, caller.UserID)
+	if err != nil {
+		log.Printf("Error exporting %s: %v", channel, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	data, err := renderExport(export, format)
+	if errors.Is(err, errExportTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Export is larger than exports.max_bytes; narrow it with since"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error rendering export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if slices.Contains(caller.Scopes, scopeAdmin) {
+		recordAdminAudit(caller.UserID, roleAdmin, "api", fmt.Sprintf("export <#%s> %s", channel, c.Query("thread")))
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(export, format)))
+	c.Data(http.StatusOK, format.MIME, data)
+}
@@ -0,0 +1,881 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Used when failover.heartbeat and failover.takeover_after aren't set
+const (
+	defaultFailoverHeartbeat = 5 * time.Second
+	defaultFailoverTakeover  = 30 * time.Second
+)
+
+// The witness holds the lease for the instance that leads; it's a third
+// instance run with failover.role: witness
+const failoverWitnessRole = "witness"
+
+// failoverBaseSuffix is added to the store path for the snapshot this
+// instance last copied from its peer
+const failoverBaseSuffix = ".failover-base"
+
+// errStoreDiverged is returned when the store has changes the leader
+// doesn't, and no snapshot both started from to merge them with
+var errStoreDiverged = errors.New("store has diverged from the leader's with nothing in common to merge from")
+
+// failoverHeartbeat is what an instance tells its peer about itself
+type failoverHeartbeat struct {
+	Role   string `json:"role"`
+	Leader bool   `json:"leader"`
+	// HandingOff is set while a leader waits for its peer to take over
+	HandingOff bool `json:"handing_off,omitempty"`
+	// Epoch goes up each time leadership changes hands, so the newer
+	// leader wins if both ever think they lead
+	Epoch        uint64 `json:"epoch"`
+	StoreVersion uint64 `json:"store_version"`
+	// Following is the peer's store version this instance last copied
+	Following uint64 `json:"following,omitempty"`
+	// ScheduleCheck is when the leader last looked for due scheduled
+	// posts, so a new leader picks up from there
+	ScheduleCheck time.Time `json:"schedule_check"`
+	// Diverged is set while a follower holds changes it couldn't merge
+	// into the leader's store
+	Diverged bool `json:"diverged,omitempty"`
+	// ResyncAt is when an admin last told the follower to take the
+	// leader's store as is
+	ResyncAt time.Time `json:"resync_at,omitempty"`
+}
+
+// failoverLeaseRequest asks the witness for the lease, renews it, or
+// gives it back
+type failoverLeaseRequest struct {
+	Holder  string        `json:"holder"`
+	TTL     time.Duration `json:"ttl"`
+	Release bool          `json:"release,omitempty"`
+}
+
+// failoverLease is the witness's answer
+type failoverLease struct {
+	Granted bool `json:"granted"`
+	// Holder is who has the lease when it wasn't granted
+	Holder string `json:"holder,omitempty"`
+}
+
+// failoverBase is a snapshot of the peer's store and the epoch it was
+// copied in. The newer of the two instances' bases is what they last had
+// in common.
+type failoverBase struct {
+	Epoch    uint64          `json:"epoch"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// failoverMergeResponse lists the follower's changes the leader kept its
+// own values for
+type failoverMergeResponse struct {
+	Conflicts []storeChange `json:"conflicts"`
+}
+
+var (
+	// Whether this instance runs jobs and handles requests. Without
+	// failover configured it always does.
+	leading atomic.Bool
+	// With failover, leading also needs the witness's lease, which runs
+	// out at this UnixNano time
+	leaseUntil atomic.Int64
+	// Held for reading by each job run; held for writing to stop leading
+	// once the running jobs finish, so nothing posts twice on handoff
+	leaderMu sync.RWMutex
+
+	// What this instance knows about the pair
+	failover struct {
+		sync.Mutex
+		epoch      uint64
+		handingOff time.Time
+		// When the peer last answered as leader
+		peerSeen    time.Time
+		peerVersion uint64
+		// The leader's last schedule check, from its heartbeat
+		scheduleCheck time.Time
+		// Run the first time this instance leads
+		leaderHooks []func()
+		hooksRun    bool
+		// The local store version just after the last copy of the
+		// leader's, so a follower knows if anything else wrote to it
+		syncedVersion uint64
+		diverged      bool
+		// The leader's last resync request, and the last one carried out
+		resyncAt, resyncedAt time.Time
+	}
+
+	// The lease, as the witness keeps it
+	witness struct {
+		sync.Mutex
+		holder  string
+		expires time.Time
+		started time.Time
+	}
+
+	failoverHTTPClient = &http.Client{Timeout: 3 * time.Second}
+	// Snapshots can be large
+	replicationHTTPClient = &http.Client{Timeout: time.Minute}
+)
+
+func init() {
+	leading.Store(true)
+	registerBotCommand(&command{
+		Name:        "admin failover",
+		Usage:       "admin failover",
+		Description: "Show which instance is leading and whether its standby is keeping up",
+		AdminOnly:   true,
+		Handler:     handleFailoverStatus,
+	})
+	registerBotCommand(&command{
+		Name:        "admin failover resync",
+		Usage:       "admin failover resync",
+		Description: "Make the standby take this instance's store as is, when its own has diverged",
+		AdminOnly:   true,
+		Handler:     handleFailoverResync,
+	})
+	registerBotCommand(&command{
+		Name:        "admin failover handoff",
+		Usage:       "admin failover handoff",
+		Description: "Hand leadership to the standby instance, e.g. before maintenance",
+		AdminOnly:   true,
+		Handler:     handleFailoverHandoff,
+	})
+}
+
+// isLeader reports whether this instance should do any work: it leads,
+// and with failover, still holds the lease
+func isLeader() bool {
+	until := leaseUntil.Load()
+	return leading.Load() && (until == 0 || time.Now().UnixNano() < until)
+}
+
+// whenLeading runs fn the first time this instance leads, or now if it
+// already does
+func whenLeading(fn func()) {
+	failover.Lock()
+	if !failover.hooksRun {
+		failover.leaderHooks = append(failover.leaderHooks, fn)
+		failover.Unlock()
+		return
+	}
+	failover.Unlock()
+	fn()
+}
+
+// setupFailover decides whether this instance starts out leading. The
+// standby always starts by following; the primary follows only when its
+// peer already leads, as it does after a failover.
+//
+// Either instance leads only while it holds the witness's lease, so a
+// partition between the two can't leave both leading. A witness instance
+// never leads; it only holds the lease.
+func setupFailover(cfg FailoverConfig) error {
+	if cfg.Role == failoverWitnessRole {
+		if failoverSecret(cfg) == "" {
+			return fmt.Errorf("failover needs a shared secret in %s", failoverSecretEnv(cfg))
+		}
+		leading.Store(false)
+		witness.Lock()
+		witness.started = time.Now()
+		witness.Unlock()
+		log.Printf("Running as the failover witness")
+		return nil
+	}
+	if cfg.PeerURL == "" {
+		runLeaderHooks()
+		return nil
+	}
+	if cfg.Role != "primary" && cfg.Role != "standby" {
+		return fmt.Errorf("failover.role must be primary, standby or witness, not %q", cfg.Role)
+	}
+	if failoverSecret(cfg) == "" {
+		return fmt.Errorf("failover needs a shared secret in %s", failoverSecretEnv(cfg))
+	}
+	if cfg.WitnessURL == "" {
+		return errors.New("failover needs a witness_url, so only one instance leads at a time")
+	}
+	if failoverTakeover(cfg) < 3*failoverInterval(cfg) {
+		return errors.New("failover.takeover_after must be at least three heartbeats")
+	}
+	leading.Store(false)
+	// Nothing leads without the lease
+	leaseUntil.Store(1)
+	failover.peerSeen = time.Now()
+	if cfg.Role == "primary" {
+		peer, err := fetchPeerHeartbeat(cfg)
+		if (err != nil || !peer.Leader) && tryLeadership(cfg, 0) {
+			return nil
+		}
+		if err == nil {
+			log.Printf("Peer is leading (epoch %d); starting on standby", peer.Epoch)
+			followPeer(cfg, peer)
+		}
+	}
+	return nil
+}
+
+// startFailover checks on the peer every failover.heartbeat, following its
+// store while it leads and taking over when it stops answering
+func startFailover(cfg FailoverConfig) {
+	if cfg.PeerURL == "" || cfg.Role == failoverWitnessRole {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(failoverInterval(cfg))
+		defer ticker.Stop()
+		for range ticker.C {
+			runJob("failover", func() { checkPeer(cfg) })
+		}
+	}()
+}
+
+// checkPeer is one heartbeat
+func checkPeer(cfg FailoverConfig) {
+	takeover := failoverTakeover(cfg)
+	peer, err := fetchPeerHeartbeat(cfg)
+
+	if leading.Load() {
+		granted, leaseErr := acquireLease(cfg)
+		// Without a renewal, step down while the lease still runs: the
+		// witness gives it to the peer only once it has run out
+		if !granted && (leaseErr == nil || time.Now().Add(2*failoverInterval(cfg)).UnixNano() >= leaseUntil.Load()) {
+			log.Printf("Lost the failover lease (%v); stepping down", leaseErr)
+			stopLeading(false)
+			return
+		}
+		failover.Lock()
+		epoch := failover.epoch
+		failover.Unlock()
+		if err == nil && peer.Diverged {
+			log.Printf("The %s's store has diverged from ours and can't be merged; see admin failover", peer.Role)
+		}
+		// Two leaders after the network between them comes back: the newer
+		// one keeps leading, or the primary if neither is newer
+		if err == nil && peer.Leader && (peer.Epoch > epoch || peer.Epoch == epoch && cfg.Role == "standby") {
+			log.Printf("Peer is also leading (epoch %d, ours %d); stepping down", peer.Epoch, epoch)
+			stopLeading(false)
+			followPeer(cfg, peer)
+		}
+		return
+	}
+
+	failover.Lock()
+	handingOff, peerSeen := failover.handingOff, failover.peerSeen
+	failover.Unlock()
+	switch {
+	case err == nil && peer.Leader:
+		followPeer(cfg, peer)
+	case err == nil && peer.HandingOff:
+		log.Printf("Peer is handing off leadership")
+		followPeer(cfg, peer)
+		tryLeadership(cfg, peer.Epoch)
+	case !handingOff.IsZero():
+		// Nobody took over; carry on leading rather than leave no leader
+		if time.Since(handingOff) > takeover && tryLeadership(cfg, 0) {
+			log.Printf("Peer didn't take over within %s; leading again", takeover)
+		}
+	case err == nil && cfg.Role == "primary":
+		tryLeadership(cfg, peer.Epoch)
+	case time.Since(peerSeen) > takeover:
+		// Silence alone isn't enough: the peer may only be cut off from
+		// this instance. The witness gives out the lease only once the
+		// peer's has run out, which it doesn't renew without the witness.
+		if tryLeadership(cfg, peer.Epoch) && err != nil {
+			log.Printf("Peer hasn't answered for %s (%v); took over", takeover, err)
+		}
+	}
+}
+
+// tryLeadership takes over if the witness gives this instance the lease
+func tryLeadership(cfg FailoverConfig, peerEpoch uint64) bool {
+	granted, err := acquireLease(cfg)
+	if err != nil {
+		log.Printf("Error asking the failover witness for the lease: %v", err)
+	}
+	if !granted {
+		return false
+	}
+	takeLeadership(cfg, peerEpoch)
+	return true
+}
+
+// acquireLease asks the witness for the lease, or renews it, for
+// failover.takeover_after. It reports false with no error when the peer
+// holds it.
+func acquireLease(cfg FailoverConfig) (bool, error) {
+	ttl := failoverTakeover(cfg)
+	// Counted from before asking, so it runs out here no later than at
+	// the witness
+	asked := time.Now()
+	var lease failoverLease
+	if err := witnessRequest(cfg, failoverLeaseRequest{Holder: cfg.Role, TTL: ttl}, &lease); err != nil {
+		return false, err
+	}
+	if !lease.Granted {
+		return false, nil
+	}
+	leaseUntil.Store(asked.Add(ttl).UnixNano())
+	return true, nil
+}
+
+// releaseLease gives the lease back, so the peer can take over straight
+// away
+func releaseLease(cfg FailoverConfig) {
+	leaseUntil.Store(1)
+	if err := witnessRequest(cfg, failoverLeaseRequest{Holder: cfg.Role, TTL: failoverTakeover(cfg), Release: true}, &failoverLease{}); err != nil {
+		log.Printf("Error releasing the failover lease: %v", err)
+	}
+}
+
+func witnessRequest(cfg FailoverConfig, lease failoverLeaseRequest, out *failoverLease) error {
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(cfg.WitnessURL, "/")+"/failover/lease", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+failoverSecret(cfg))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := failoverHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling witness: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("calling witness: %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(out)
+}
+
+// followPeer records the leader's heartbeat and copies its store if it has
+// changed since the last copy
+func followPeer(cfg FailoverConfig, peer failoverHeartbeat) {
+	failover.Lock()
+	failover.peerSeen = time.Now()
+	failover.handingOff = time.Time{}
+	failover.epoch = max(failover.epoch, peer.Epoch)
+	failover.scheduleCheck = peer.ScheduleCheck
+	failover.resyncAt = peer.ResyncAt
+	current := failover.peerVersion == peer.StoreVersion && !failover.diverged
+	resync := peer.ResyncAt.After(failover.resyncedAt)
+	failover.Unlock()
+	if current && !resync {
+		return
+	}
+	version, err := replicateStore(cfg, resync)
+	failover.Lock()
+	failover.diverged = errors.Is(err, errStoreDiverged)
+	failover.Unlock()
+	if err != nil {
+		log.Printf("Error copying the leader's store: %v", err)
+		return
+	}
+	failover.Lock()
+	failover.peerVersion = version
+	failover.Unlock()
+}
+
+// takeLeadership makes this instance the leader. Scheduled posts carry on
+// from the old leader's last check, so ones it already sent aren't sent
+// again.
+func takeLeadership(cfg FailoverConfig, peerEpoch uint64) {
+	failover.Lock()
+	failover.epoch = max(failover.epoch, peerEpoch) + 1
+	failover.handingOff = time.Time{}
+	epoch, check := failover.epoch, failover.scheduleCheck
+	failover.Unlock()
+	if !check.IsZero() {
+		scheduleLastCheckMu.Lock()
+		scheduleLastCheck = check
+		scheduleLastCheckMu.Unlock()
+	}
+	leading.Store(true)
+	log.Printf("Leading as %s (epoch %d)", cfg.Role, epoch)
+	runLeaderHooks()
+}
+
+// stopLeading stops this instance doing work, waiting for running jobs,
+// and gives back the lease
+func stopLeading(handoff bool) {
+	leaderMu.Lock()
+	leading.Store(false)
+	leaderMu.Unlock()
+	// Deliveries are kept in memory between flushes
+	flushDeliveries()
+	releaseLease(appConfig.Failover)
+	failover.Lock()
+	if handoff {
+		failover.handingOff = time.Now()
+	}
+	failover.peerSeen = time.Now()
+	failover.Unlock()
+}
+
+func runLeaderHooks() {
+	failover.Lock()
+	if failover.hooksRun {
+		failover.Unlock()
+		return
+	}
+	hooks := failover.leaderHooks
+	failover.leaderHooks, failover.hooksRun = nil, true
+	failover.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+func failoverSecretEnv(cfg FailoverConfig) string {
+	if cfg.SecretEnv != "" {
+		return cfg.SecretEnv
+	}
+	return "FAILOVER_SECRET"
+}
+
+func failoverSecret(cfg FailoverConfig) string {
+	return os.Getenv(failoverSecretEnv(cfg))
+}
+
+// peerRequest calls one of the peer's /failover endpoints
+func peerRequest(cfg FailoverConfig, client *http.Client, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(cfg.PeerURL, "/")+"/failover"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+failoverSecret(cfg))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling peer: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("calling peer: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func fetchPeerHeartbeat(cfg FailoverConfig) (failoverHeartbeat, error) {
+	resp, err := peerRequest(cfg, failoverHTTPClient, "/heartbeat")
+	if err != nil {
+		return failoverHeartbeat{}, err
+	}
+	defer resp.Body.Close()
+	var peer failoverHeartbeat
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&peer); err != nil {
+		return failoverHeartbeat{}, fmt.Errorf("decoding peer heartbeat: %w", err)
+	}
+	return peer, nil
+}
+
+// replicateStore brings the store up to date with the leader's, returning
+// the leader's store version.
+//
+// Writes made here since the last copy, while this instance led, aren't
+// overwritten: they're sent to the leader to merge first, against the
+// last snapshot the two had in common. Where the leader has changed a key
+// too, its value wins and this instance's is kept in a file next to the
+// store. With nothing in common to merge from, the store is left alone
+// until an admin runs admin failover resync on the leader.
+func replicateStore(cfg FailoverConfig, resync bool) (uint64, error) {
+	failover.Lock()
+	synced, resyncAt := failover.syncedVersion, failover.resyncAt
+	failover.Unlock()
+	if !resync && (synced == 0 || store.Version() != synced) && len(store.Buckets()) > 0 {
+		if err := mergeIntoLeader(cfg); err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := peerRequest(cfg, replicationHTTPClient, "/snapshot")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	version, err := strconv.ParseUint(resp.Header.Get("X-Store-Version"), 10, 64)
+	if err != nil {
+		return 0, errors.New("peer sent no store version")
+	}
+	snapshot, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("reading snapshot: %w", err)
+	}
+	if resync {
+		if err := keepDivergedStore("resync"); err != nil {
+			return 0, err
+		}
+	}
+	if err := store.Replace(snapshot); err != nil {
+		return 0, err
+	}
+	failover.Lock()
+	failover.syncedVersion = store.Version()
+	if resync {
+		failover.resyncedAt = resyncAt
+	}
+	epoch := failover.epoch
+	failover.Unlock()
+	if err := saveFailoverBase(failoverBase{Epoch: epoch, Snapshot: snapshot}); err != nil {
+		log.Printf("Error saving failover base: %v", err)
+	}
+	return version, nil
+}
+
+// mergeIntoLeader sends the leader what changed here since the last
+// snapshot both instances had
+func mergeIntoLeader(cfg FailoverConfig) error {
+	base, found, err := loadFailoverBase()
+	if err != nil {
+		return err
+	}
+	if peerBase, ok, err := fetchPeerBase(cfg); err != nil {
+		return err
+	} else if ok && (!found || peerBase.Epoch > base.Epoch) {
+		base, found = peerBase, true
+	}
+	if !found {
+		return errStoreDiverged
+	}
+	local, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	changes, err := diffSnapshots(base.Snapshot, local)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	log.Printf("Merging %d changes made while this instance led into the leader's store", len(changes))
+	body, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(cfg.PeerURL, "/")+"/failover/merge", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+failoverSecret(cfg))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := replicationHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling peer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("merging into peer: %s", resp.Status)
+	}
+	var merged failoverMergeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&merged); err != nil {
+		return fmt.Errorf("decoding merge result: %w", err)
+	}
+	if len(merged.Conflicts) > 0 {
+		log.Printf("The leader kept its own values for %d keys this instance changed too", len(merged.Conflicts))
+		return keepConflicts(merged.Conflicts)
+	}
+	return nil
+}
+
+func fetchPeerBase(cfg FailoverConfig) (failoverBase, bool, error) {
+	resp, err := peerRequest(cfg, replicationHTTPClient, "/base")
+	if err != nil {
+		return failoverBase{}, false, err
+	}
+	defer resp.Body.Close()
+	var base failoverBase
+	if err := json.NewDecoder(resp.Body).Decode(&base); err != nil {
+		return failoverBase{}, false, fmt.Errorf("decoding peer base: %w", err)
+	}
+	return base, base.Snapshot != nil, nil
+}
+
+func loadFailoverBase() (failoverBase, bool, error) {
+	raw, err := os.ReadFile(storePath() + failoverBaseSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return failoverBase{}, false, nil
+	}
+	if err != nil {
+		return failoverBase{}, false, fmt.Errorf("reading failover base: %w", err)
+	}
+	var base failoverBase
+	if err := json.Unmarshal(raw, &base); err != nil {
+		return failoverBase{}, false, fmt.Errorf("parsing failover base: %w", err)
+	}
+	return base, true, nil
+}
+
+func saveFailoverBase(base failoverBase) error {
+	raw, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+	path := storePath() + failoverBaseSuffix
+	if err := os.WriteFile(path+".tmp", raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// keepConflicts writes this instance's side of keys the leader also
+// changed next to the store, for an admin to go through
+func keepConflicts(conflicts []storeChange) error {
+	raw, err := json.MarshalIndent(conflicts, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s.conflicts-%d.json", storePath(), time.Now().Unix())
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		return fmt.Errorf("saving merge conflicts: %w", err)
+	}
+	log.Printf("Saved this instance's side of the merge conflicts to %s", path)
+	return nil
+}
+
+// keepDivergedStore copies the whole store next to itself before it's
+// replaced
+func keepDivergedStore(reason string) error {
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s.%s-%d.json", storePath(), reason, time.Now().Unix())
+	if err := os.WriteFile(path, snapshot, 0o600); err != nil {
+		return fmt.Errorf("saving diverged store: %w", err)
+	}
+	log.Printf("Saved the diverged store to %s", path)
+	return nil
+}
+
+// currentHeartbeat describes this instance for its peer
+func currentHeartbeat() failoverHeartbeat {
+	failover.Lock()
+	epoch, handingOff, following := failover.epoch, !failover.handingOff.IsZero(), failover.peerVersion
+	diverged, resyncAt := failover.diverged, failover.resyncAt
+	failover.Unlock()
+	scheduleLastCheckMu.Lock()
+	check := scheduleLastCheck
+	scheduleLastCheckMu.Unlock()
+	return failoverHeartbeat{
+		Role:          appConfig.Failover.Role,
+		Leader:        isLeader(),
+		HandingOff:    handingOff,
+		Epoch:         epoch,
+		StoreVersion:  store.Version(),
+		Following:     following,
+		ScheduleCheck: check,
+		Diverged:      diverged,
+		ResyncAt:      resyncAt,
+	}
+}
+
+// mountFailoverRoutes adds the endpoints the instances use to watch each
+// other, and a health check load balancers can use to find the leader
+func mountFailoverRoutes(group *gin.RouterGroup) {
+	group.GET("/leader", handleFailoverLeader)
+	peer := group.Group("", requireFailoverSecret)
+	peer.GET("/heartbeat", handleFailoverHeartbeat)
+	peer.GET("/snapshot", handleFailoverSnapshot)
+	peer.GET("/base", handleFailoverBase)
+	peer.POST("/merge", handleFailoverMerge)
+	peer.POST("/lease", handleFailoverLease)
+}
+
+// requireFailoverSecret lets only the peer call the failover endpoints
+func requireFailoverSecret(c *gin.Context) {
+	cfg := appConfig.Failover
+	secret := failoverSecret(cfg)
+	got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if cfg.PeerURL == "" && cfg.Role != failoverWitnessRole || secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid failover secret"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// requireLeader turns away requests while this instance is on standby, so
+// the load balancer and Slack's retries send them to the leader
+func requireLeader(c *gin.Context) {
+	if !isLeader() && !strings.HasPrefix(c.Request.URL.Path, "/failover/") {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "This instance is on standby"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func handleFailoverLeader(c *gin.Context) {
+	if !isLeader() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"leader": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"leader": true})
+}
+
+func handleFailoverHeartbeat(c *gin.Context) {
+	c.JSON(http.StatusOK, currentHeartbeat())
+}
+
+func handleFailoverSnapshot(c *gin.Context) {
+	// Read the version first; the snapshot is at least that new
+	version := store.Version()
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		log.Printf("Error taking store snapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.Header("X-Store-Version", strconv.FormatUint(version, 10))
+	c.Data(http.StatusOK, "application/json", snapshot)
+}
+
+func handleFailoverBase(c *gin.Context) {
+	base, _, err := loadFailoverBase()
+	if err != nil {
+		log.Printf("Error loading failover base: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, base)
+}
+
+// handleFailoverMerge applies a former leader's changes, except to keys
+// this instance has changed since too
+func handleFailoverMerge(c *gin.Context) {
+	if !isLeader() {
+		c.JSON(http.StatusConflict, gin.H{"error": "This instance isn't leading"})
+		return
+	}
+	var changes []storeChange
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid changes: " + err.Error()})
+		return
+	}
+	conflicts, err := store.Apply(changes)
+	if err != nil {
+		log.Printf("Error merging the peer's changes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	log.Printf("Merged %d of the peer's changes into the store", len(changes)-len(conflicts))
+	if len(conflicts) > 0 {
+		alertOps(fmt.Sprintf(":warning: The failover standby changed %s while it led that this instance changed too. This instance's values were kept; the standby saved its own next to its store.", plural(len(conflicts), "key")))
+	}
+	c.JSON(http.StatusOK, failoverMergeResponse{Conflicts: conflicts})
+}
+
+// handleFailoverLease is the witness's side of the lease: one holder at a
+// time, until its lease runs out or it gives it back
+func handleFailoverLease(c *gin.Context) {
+	if appConfig.Failover.Role != failoverWitnessRole {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This instance isn't the failover witness"})
+		return
+	}
+	var req failoverLeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Holder == "" || req.TTL <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "holder and ttl are required"})
+		return
+	}
+	witness.Lock()
+	defer witness.Unlock()
+	now := time.Now()
+	held := witness.holder != "" && now.Before(witness.expires)
+	switch {
+	case req.Release:
+		if witness.holder == req.Holder {
+			witness.holder, witness.expires = "", time.Time{}
+		}
+		c.JSON(http.StatusOK, failoverLease{})
+		return
+	case held && witness.holder != req.Holder:
+		c.JSON(http.StatusOK, failoverLease{Holder: witness.holder})
+		return
+	case !held && now.Sub(witness.started) < req.TTL:
+		// A lease given out before a restart could still be running
+		c.JSON(http.StatusOK, failoverLease{})
+		return
+	}
+	if witness.holder != req.Holder {
+		log.Printf("Failover lease goes to the %s", req.Holder)
+	}
+	witness.holder, witness.expires = req.Holder, now.Add(req.TTL)
+	c.JSON(http.StatusOK, failoverLease{Granted: true})
+}
+
+func handleFailoverStatus(req commandRequest) commandResponse {
+	cfg := appConfig.Failover
+	if cfg.PeerURL == "" {
+		return ephemeral("Failover isn't set up; this is the only instance. See `failover` in the config.")
+	}
+	self := currentHeartbeat()
+	lines := []string{fmt.Sprintf("This instance is the *%s* and is leading (epoch %d).", self.Role, self.Epoch)}
+	peer, err := fetchPeerHeartbeat(cfg)
+	switch {
+	case err != nil:
+		log.Printf("Error checking failover peer: %v", err)
+		lines = append(lines, fmt.Sprintf(":warning: The peer at %s isn't answering, so nothing will take over if this instance fails.", cfg.PeerURL))
+	case peer.Diverged:
+		lines = append(lines, fmt.Sprintf(":warning: The %s at %s has changes in its store that can't be merged into this one. `%s admin failover resync` makes it take this store, keeping its own in a file.", peer.Role, cfg.PeerURL, botCommand))
+	case peer.Following != self.StoreVersion:
+		lines = append(lines, fmt.Sprintf("The %s at %s is on standby and catching up on the store.", peer.Role, cfg.PeerURL))
+	default:
+		lines = append(lines, fmt.Sprintf("The %s at %s is on standby and up to date.", peer.Role, cfg.PeerURL))
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
+
+func handleFailoverHandoff(req commandRequest) commandResponse {
+	cfg := appConfig.Failover
+	if cfg.PeerURL == "" {
+		return ephemeral("Failover isn't set up, so there's no other instance to hand off to.")
+	}
+	if _, err := fetchPeerHeartbeat(cfg); err != nil {
+		log.Printf("Error checking failover peer: %v", err)
+		return ephemeral("The standby at %s isn't answering, so I'm staying in charge.", cfg.PeerURL)
+	}
+	log.Printf("Handing off leadership at the request of %s", req.UserID)
+	// Answer first; once leadership goes, this instance turns requests away
+	go runJob("failover handoff", func() { stopLeading(true) })
+	return ephemeral("Handing off to the standby at %s. It takes over within %s; if it doesn't, this instance carries on.", cfg.PeerURL, failoverInterval(cfg))
+}
+
+func handleFailoverResync(req commandRequest) commandResponse {
+	if appConfig.Failover.PeerURL == "" {
+		return ephemeral("Failover isn't set up, so there's no standby to resync.")
+	}
+	failover.Lock()
+	failover.resyncAt = time.Now().UTC()
+	failover.Unlock()
+	recordAdminAudit(req.UserID, roleAdmin, "failover resync", "")
+	return ephemeral("The standby takes this instance's store on its next heartbeat, saving its own next to it first.")
+}
+
+func failoverTakeover(cfg FailoverConfig) time.Duration {
+	if cfg.TakeoverAfter > 0 {
+		return cfg.TakeoverAfter
+	}
+	return defaultFailoverTakeover
+}
+
+func failoverInterval(cfg FailoverConfig) time.Duration {
+	if cfg.Heartbeat > 0 {
+		return cfg.Heartbeat
+	}
+	return defaultFailoverHeartbeat
+}
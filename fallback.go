@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Returned when a block message has no text a notification could show
+var errNoFallbackText = errors.New("message has blocks but no fallback text")
+
+var (
+	// <https://example.com|label> and <#C123|name> become their label
+	labelledLinkPattern = regexp.MustCompile(`<[^<>|]+\|([^<>]+)>`)
+	// <https://example.com> becomes the bare URL
+	bareLinkPattern = regexp.MustCompile(`<((?:https?|mailto):[^<>]+)>`)
+	// *bold*, _italic_ and ~strike~ markers around words
+	emphasisPattern = regexp.MustCompile(`(^|[\s(])[*_~]+([^*_~\n]+?)[*_~]+`)
+)
+
+// withFallbackText returns the notification text for a message: text itself
+// when set, otherwise plain text generated from blocks. Push notifications
+// and screen readers only see this text, so a block message without it is
+// rejected rather than sent.
+func withFallbackText(text string, blocks []slack.Block) (string, error) {
+	if strings.TrimSpace(text) != "" || len(blocks) == 0 {
+		return text, nil
+	}
+	fallback := blocksFallbackText(blocks)
+	if fallback == "" {
+		return "", errNoFallbackText
+	}
+	text, _ = truncateText(fallback, maxMessageText)
+	return text, nil
+}
+
+// blocksFallbackText extracts readable plain text from blocks
+func blocksFallbackText(blocks []slack.Block) string {
+	var parts []string
+	add := func(obj *slack.TextBlockObject) {
+		if obj != nil {
+			if text := plainText(obj.Text); text != "" {
+				parts = append(parts, text)
+			}
+		}
+	}
+
+	for _, block := range blocks {
+		switch b := block.(type) {
+		case *slack.HeaderBlock:
+			add(b.Text)
+		case *slack.SectionBlock:
+			add(b.Text)
+			for _, field := range b.Fields {
+				add(field)
+			}
+		case *slack.ContextBlock:
+			for _, element := range b.ContextElements.Elements {
+				switch e := element.(type) {
+				case *slack.TextBlockObject:
+					add(e)
+				case *slack.ImageBlockElement:
+					if e.AltText != "" {
+						parts = append(parts, e.AltText)
+					}
+				}
+			}
+		case *slack.ImageBlock:
+			if b.Title != nil {
+				add(b.Title)
+			} else if b.AltText != "" {
+				parts = append(parts, b.AltText)
+			}
+		case *slack.RichTextBlock:
+			if text := richTextPlain(b); text != "" {
+				parts = append(parts, text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// plainText strips mrkdwn markup that reads badly in a notification
+func plainText(text string) string {
+	text = labelledLinkPattern.ReplaceAllString(text, "$1")
+	text = bareLinkPattern.ReplaceAllString(text, "$1")
+	text = emphasisPattern.ReplaceAllString(text, "$1$2")
+	text = strings.ReplaceAll(text, "```", "")
+	return strings.TrimSpace(text)
+}
+
+// richTextPlain returns the text content of a rich text block
+func richTextPlain(block *slack.RichTextBlock) string {
+	var b strings.Builder
+	for _, element := range block.Elements {
+		section, ok := element.(*slack.RichTextSection)
+		if !ok {
+			continue
+		}
+		for _, inner := range section.Elements {
+			if text, ok := inner.(*slack.RichTextSectionTextElement); ok {
+				b.WriteString(text.Text)
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+func TestWithFallbackText(t *testing.T) {
+	md := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.MarkdownType, s, false, false)
+	}
+	plain := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, s, false, false)
+	}
+	tests := []struct {
+		name   string
+		text   string
+		blocks []slack.Block
+		want   string
+	}{
+		{
+			name: "text without blocks",
+			text: "Hello",
+			want: "Hello",
+		},
+		{
+			name:   "text is kept over blocks",
+			text:   "Deploy finished",
+			blocks: []slack.Block{slack.NewSectionBlock(md("*Deploy* finished in 3m"), nil, nil)},
+			want:   "Deploy finished",
+		},
+		{
+			name: "header, section and fields",
+			blocks: []slack.Block{
+				slack.NewHeaderBlock(plain("Weekly digest")),
+				slack.NewSectionBlock(md("Top posts this week"), []*slack.TextBlockObject{md("*Posts*\n12"), md("*Reactions*\n40")}, nil),
+			},
+			want: "Weekly digest\nTop posts this week\nPosts\n12\nReactions\n40",
+		},
+		{
+			name: "links and emphasis are stripped",
+			blocks: []slack.Block{
+				slack.NewSectionBlock(md("*Incident 4* in <#C123|incidents>, see <https://status.example.com>"), nil, nil),
+			},
+			want: "Incident 4 in incidents, see https://status.example.com",
+		},
+		{
+			name: "context and image alt text",
+			blocks: []slack.Block{
+				slack.NewImageBlock("https://example.com/a.gif", "A high five", "", nil),
+				slack.NewContextBlock("", slack.NewImageBlockElement("https://example.com/i.png", "warning"), md("_Sent by the bot_")),
+			},
+			want: "A high five\nwarning\nSent by the bot",
+		},
+		{
+			name: "blank text falls back to blocks",
+			text: "  ",
+			blocks: []slack.Block{
+				slack.NewSectionBlock(md("Standup reminder"), nil, nil),
+			},
+			want: "Standup reminder",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withFallbackText(tt.text, tt.blocks)
+			if err != nil {
+				t.Fatalf("withFallbackText: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("withFallbackText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithFallbackTextEmpty(t *testing.T) {
+	blocks := []slack.Block{
+		slack.NewDividerBlock(),
+		slack.NewActionBlock("", slack.NewButtonBlockElement("ok", "ok", slack.NewTextBlockObject(slack.PlainTextType, "OK", false, false))),
+	}
+	if got, err := withFallbackText("", blocks); !errors.Is(err, errNoFallbackText) {
+		t.Errorf("withFallbackText = %q, %v; want errNoFallbackText", got, err)
+	}
+}
+
+func TestWithFallbackTextTruncates(t *testing.T) {
+	long := strings.Repeat("word ", maxMessageText)
+	got, err := withFallbackText("", []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, long, false, false), nil, nil),
+	})
+	if err != nil {
+		t.Fatalf("withFallbackText: %v", err)
+	}
+	if n := utf8.RuneCountInString(got); n > maxMessageText {
+		t.Errorf("fallback is %d characters, over the %d limit", n, maxMessageText)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/slack-go/slack"
+	"gopkg.in/yaml.v3"
+)
+
+// Store buckets for FAQ entries added at runtime, keyed by name, and for
+// "was this helpful?" answers, keyed by entry name. Stored entries override
+// ones from the config or FAQ file with the same name.
+const (
+	faqBucket         = "faq"
+	faqFeedbackBucket = "faq_feedback"
+)
+
+// Action IDs of the "was this helpful?" buttons under FAQ answers
+const (
+	actionFAQHelpful    = "faq_helpful"
+	actionFAQNotHelpful = "faq_not_helpful"
+)
+
+// How closely a question must match an entry when faq.min_score is unset
+const defaultFAQMinScore = 0.6
+
+// FAQEntry is one answer and the questions it answers
+type FAQEntry struct {
+	Name string `yaml:"name" json:"name"`
+	// Questions are example phrasings, matched fuzzily word by word
+	Questions []string `yaml:"questions" json:"questions,omitempty"`
+	// Keywords match when every one of them appears in the question
+	Keywords []string `yaml:"keywords" json:"keywords,omitempty"`
+	Answer   string   `yaml:"answer" json:"answer"`
+}
+
+// faqFeedback counts the answers to "was this helpful?" for one entry
+type faqFeedback struct {
+	Helpful    int `json:"helpful"`
+	NotHelpful int `json:"not_helpful"`
+}
+
+// Words too common to say anything about what a question is about
+var faqStopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "i": true, "me": true, "my": true, "we": true, "our": true,
+	"you": true, "your": true, "it": true, "is": true, "are": true, "am": true, "be": true, "do": true,
+	"does": true, "can": true, "could": true, "should": true, "would": true, "how": true, "what": true,
+	"where": true, "when": true, "who": true, "why": true, "which": true, "to": true, "of": true,
+	"in": true, "on": true, "for": true, "with": true, "and": true, "or": true, "at": true, "by": true,
+	"there": true, "this": true, "that": true, "get": true, "please": true, "hi": true, "hey": true,
+}
+
+var (
+	// The FAQ file, reloaded when it changes on disk
+	faqFileMu      sync.Mutex
+	faqFileEntries []FAQEntry
+	faqFileModTime time.Time
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin faq add",
+		Usage:       `admin faq add <name> "<question>" <answer>`,
+		Description: "Answer a question automatically when people ask me it",
+		AdminOnly:   true,
+		Handler:     handleFAQAdd,
+	})
+	registerBotCommand(&command{
+		Name:        "admin faq remove",
+		Usage:       "admin faq remove <name>",
+		Description: "Remove an FAQ entry added with faq add",
+		AdminOnly:   true,
+		Handler:     handleFAQRemove,
+	})
+	registerBotCommand(&command{
+		Name:        "admin faq list",
+		Usage:       "admin faq list",
+		Description: "List FAQ entries and how helpful people found them",
+		AdminOnly:   true,
+		Handler:     handleFAQList,
+	})
+	registerBlockAction(actionFAQHelpful, handleFAQFeedback)
+	registerBlockAction(actionFAQNotHelpful, handleFAQFeedback)
+	registerApplyKind(applyKind{Name: "faq", Bucket: faqBucket, Decode: decodeFAQSpec})
+}
+
+// loadFAQ returns the entries from the config, the FAQ file and the store,
+// later sources overriding earlier ones by name
+func loadFAQ() ([]FAQEntry, error) {
+	fileEntries, err := loadFAQFile()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := storeList[FAQEntry](store, faqBucket)
+	if err != nil {
+		return nil, err
+	}
+	entries := slices.Clone(appConfig.FAQ.Entries)
+	for _, entry := range slices.Concat(fileEntries, stored) {
+		if i := slices.IndexFunc(entries, func(e FAQEntry) bool { return e.Name == entry.Name }); i >= 0 {
+			entries[i] = entry
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// loadFAQFile reads faq.file, a YAML list of entries, when it has changed
+// since it was last read
+func loadFAQFile() ([]FAQEntry, error) {
+	path := appConfig.FAQ.File
+	if path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading FAQ file: %w", err)
+	}
+	faqFileMu.Lock()
+	defer faqFileMu.Unlock()
+	if info.ModTime().Equal(faqFileModTime) {
+		return faqFileEntries, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading FAQ file: %w", err)
+	}
+	var entries []FAQEntry
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing FAQ file %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("FAQ file %s: %w", path, err)
+		}
+	}
+	faqFileEntries, faqFileModTime = entries, info.ModTime()
+	return entries, nil
+}
+
+func (e FAQEntry) validate() error {
+	if e.Name == "" || e.Answer == "" {
+		return fmt.Errorf("FAQ entry %q needs a name and an answer", e.Name)
+	}
+	if len(e.Questions) == 0 && len(e.Keywords) == 0 {
+		return fmt.Errorf("FAQ entry %s needs questions or keywords", e.Name)
+	}
+	return nil
+}
+
+// decodeFAQSpec decodes an FAQ entry from a POST /apply document
+func decodeFAQSpec(raw json.RawMessage) (string, any, error) {
+	var entry FAQEntry
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&entry); err != nil {
+		return "", nil, err
+	}
+	if err := entry.validate(); err != nil {
+		return "", nil, err
+	}
+	return entry.Name, entry, nil
+}
+
+// faqAnswer answers text from the FAQ when an entry matches it well enough
+func faqAnswer(text string) (commandResponse, bool) {
+	entries, err := loadFAQ()
+	if err != nil {
+		log.Printf("Error loading FAQ: %v", err)
+		return commandResponse{}, false
+	}
+	minScore := appConfig.FAQ.MinScore
+	if minScore <= 0 {
+		minScore = defaultFAQMinScore
+	}
+	var best *FAQEntry
+	bestScore := 0.0
+	for i, entry := range entries {
+		if score := entry.score(text); score >= minScore && score > bestScore {
+			best, bestScore = &entries[i], score
+		}
+	}
+	if best == nil {
+		return commandResponse{}, false
+	}
+	return commandResponse{
+		Text:      best.Answer,
+		Blocks:    faqAnswerBlocks(*best, true),
+		InChannel: true,
+	}, true
+}
+
+// faqAnswerBlocks shows entry's answer, asking whether it helped
+func faqAnswerBlocks(entry FAQEntry, ask bool) []slack.Block {
+	answer, _ := truncateText(entry.Answer, maxSectionText)
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, answer, false, false), nil, nil)}
+	if ask {
+		blocks = append(blocks,
+			slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "From the FAQ. Was this helpful?", false, false)),
+			slack.NewActionBlock("",
+				slack.NewButtonBlockElement(actionFAQHelpful, entry.Name, slack.NewTextBlockObject(slack.PlainTextType, "Yes", false, false)),
+				slack.NewButtonBlockElement(actionFAQNotHelpful, entry.Name, slack.NewTextBlockObject(slack.PlainTextType, "No", false, false)),
+			))
+	}
+	return blocks
+}
+
+// score rates how well text matches the entry from 0 to 1: 1 when every
+// keyword appears, otherwise the best of its questions. A question scores
+// by the share of its words found in text, with a smaller weight on the
+// share of text's words it explains, so a long message that contains the
+// question still matches.
+func (e FAQEntry) score(text string) float64 {
+	normalized := " " + strings.Join(strings.FieldsFunc(strings.ToLower(text), isFAQSeparator), " ") + " "
+	if len(e.Keywords) > 0 && !slices.ContainsFunc(e.Keywords, func(keyword string) bool {
+		return !strings.Contains(normalized, " "+strings.Join(strings.FieldsFunc(strings.ToLower(keyword), isFAQSeparator), " ")+" ")
+	}) {
+		return 1
+	}
+
+	words := faqWords(text)
+	if len(words) == 0 {
+		return 0
+	}
+	best := 0.0
+	for _, question := range e.Questions {
+		questionWords := faqWords(question)
+		if len(questionWords) == 0 {
+			continue
+		}
+		matched := 0
+		for _, q := range questionWords {
+			if slices.ContainsFunc(words, func(w string) bool { return faqWordsMatch(q, w) }) {
+				matched++
+			}
+		}
+		recall := float64(matched) / float64(len(questionWords))
+		precision := float64(matched) / float64(len(words))
+		best = max(best, 0.7*recall+0.3*min(precision, 1))
+	}
+	return best
+}
+
+func isFAQSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// faqWords splits text into distinct stemmed words, leaving out stopwords
+func faqWords(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isFAQSeparator) {
+		if faqStopwords[word] {
+			continue
+		}
+		if word = faqStem(word); !slices.Contains(words, word) {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// faqStem strips common English endings, so "resetting" matches "reset"
+func faqStem(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if stem, ok := strings.CutSuffix(word, suffix); ok && len(stem) >= 3 {
+			// resetting -> resett -> reset
+			if n := len(stem); n >= 2 && stem[n-1] == stem[n-2] && suffix != "s" {
+				stem = stem[:n-1]
+			}
+			return stem
+		}
+	}
+	return word
+}
+
+// faqWordsMatch reports whether two stemmed words are the same, allowing
+// one typo in longer words
+func faqWordsMatch(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) < 5 || len(b) < 5 {
+		return false
+	}
+	return editDistanceAtMostOne(a, b)
+}
+
+func editDistanceAtMostOne(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	if len(a) == len(b) {
+		// One substitution, or two swapped letters
+		return a[i+1:] == b[i+1:] || (i+1 < len(a) && a[i] == b[i+1] && a[i+1] == b[i] && a[i+2:] == b[i+2:])
+	}
+	return a[i:] == b[i+1:]
+}
+
+// handleFAQFeedback records whether an answer helped and replaces the
+// buttons with a thank you
+func handleFAQFeedback(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	helpful := action.ActionID == actionFAQHelpful
+	var feedback faqFeedback
+	if _, err := store.Get(faqFeedbackBucket, action.Value, &feedback); err != nil {
+		log.Printf("Error loading FAQ feedback: %v", err)
+		return
+	}
+	if helpful {
+		feedback.Helpful++
+	} else {
+		feedback.NotHelpful++
+	}
+	if err := store.Put(faqFeedbackBucket, action.Value, feedback); err != nil {
+		log.Printf("Error saving FAQ feedback: %v", err)
+	}
+
+	text := "Thanks for letting me know it helped."
+	if !helpful {
+		text = "Thanks for letting me know."
+		if channel := appConfig.FAQ.Channel; channel != "" {
+			msg := fmt.Sprintf("<@%s> didn't find the FAQ answer *%s* helpful.", callback.User.ID, action.Value)
+			if _, err := sendMessage(outboundMessage{Channel: channel, Text: msg}); err != nil {
+				log.Printf("Error reporting unhelpful FAQ answer: %v", err)
+			}
+			text += " I've told the people who look after the FAQ."
+		}
+	}
+	answer := faqOriginalAnswer(callback)
+	blocks := append(faqAnswerBlocks(FAQEntry{Name: action.Value, Answer: answer}, false),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, text, false, false)))
+	msg := &slack.WebhookMessage{ReplaceOriginal: true, Text: answer, Blocks: &slack.Blocks{BlockSet: blocks}}
+	if err := respond(callback.ResponseURL, msg); err != nil {
+		log.Printf("Error updating FAQ answer: %v", err)
+	}
+}
+
+// faqOriginalAnswer returns the answer text of the message the buttons
+// were under
+func faqOriginalAnswer(callback *slack.InteractionCallback) string {
+	for _, block := range callback.Message.Blocks.BlockSet {
+		if section, ok := block.(*slack.SectionBlock); ok && section.Text != nil {
+			return section.Text.Text
+		}
+	}
+	return callback.Message.Text
+}
+
+func handleFAQAdd(req commandRequest) commandResponse {
+	args := parseQuotedArgs(strings.Join(req.Args, " "))
+	if len(args) < 3 {
+		return ephemeral("Usage: `%s admin faq add <name> \"<question>\" <answer>`", botCommand)
+	}
+	entry := FAQEntry{Name: args[0], Questions: []string{args[1]}, Answer: strings.Join(args[2:], " ")}
+	var existing FAQEntry
+	found, err := store.Get(faqBucket, entry.Name, &existing)
+	if err != nil {
+		log.Printf("Error loading FAQ entry: %v", err)
+		return ephemeral("Sorry, something went wrong saving the FAQ entry.")
+	}
+	if found && existing.Answer == entry.Answer {
+		// Adding the same answer again adds another way of asking
+		entry.Questions = append(existing.Questions, entry.Questions...)
+	}
+	if err := store.Put(faqBucket, entry.Name, entry); err != nil {
+		log.Printf("Error saving FAQ entry: %v", err)
+		return ephemeral("Sorry, something went wrong saving the FAQ entry.")
+	}
+	return ephemeral("Saved FAQ entry *%s*; I'll answer questions like \"%s\" with it.", entry.Name, args[1])
+}
+
+func handleFAQRemove(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s admin faq remove <name>`", botCommand)
+	}
+	name := req.Args[0]
+	found, err := store.Get(faqBucket, name, &FAQEntry{})
+	if err != nil {
+		log.Printf("Error loading FAQ entry: %v", err)
+		return ephemeral("Sorry, something went wrong loading the FAQ entry.")
+	}
+	if !found {
+		return ephemeral("There is no FAQ entry called *%s* added with `faq add`; entries from the config or FAQ file are removed there.", name)
+	}
+	if err := store.Delete(faqBucket, name); err != nil {
+		log.Printf("Error deleting FAQ entry: %v", err)
+		return ephemeral("Sorry, something went wrong removing the FAQ entry.")
+	}
+	return ephemeral("Removed FAQ entry *%s*.", name)
+}
+
+func handleFAQList(req commandRequest) commandResponse {
+	entries, err := loadFAQ()
+	if err != nil {
+		log.Printf("Error loading FAQ: %v", err)
+		return ephemeral("Sorry, something went wrong loading the FAQ.")
+	}
+	if len(entries) == 0 {
+		return ephemeral("The FAQ is empty.")
+	}
+	type row struct {
+		Name       string `table:"Name"`
+		Questions  int    `table:"Questions"`
+		Helpful    int    `table:"Helpful"`
+		NotHelpful int    `table:"Not helpful"`
+	}
+	rows := make([]row, len(entries))
+	for i, entry := range entries {
+		var feedback faqFeedback
+		if _, err := store.Get(faqFeedbackBucket, entry.Name, &feedback); err != nil {
+			log.Printf("Error loading FAQ feedback: %v", err)
+		}
+		rows[i] = row{Name: entry.Name, Questions: len(entry.Questions), Helpful: feedback.Helpful, NotHelpful: feedback.NotHelpful}
+	}
+	table, err := renderTable(rows, tableOptions{SortBy: "Name"})
+	if err != nil {
+		log.Printf("Error rendering FAQ: %v", err)
+		return ephemeral("Sorry, something went wrong listing the FAQ.")
+	}
+	return ephemeral("%s", table)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Callback and block IDs of the feedback modal
+const (
+	feedbackCallbackID = "submit_feedback"
+	feedbackTextBlock  = "feedback_text"
+	feedbackTextAction = "text"
+	feedbackAnonBlock  = "feedback_anonymous"
+	feedbackAnonAction = "anonymous"
+)
+
+func init() {
+	registerGlobalShortcut(feedbackCallbackID, handleFeedbackShortcut)
+	registerViewSubmission(feedbackCallbackID, handleFeedbackSubmission)
+}
+
+// handleFeedbackShortcut opens the feedback modal
+func handleFeedbackShortcut(callback *slack.InteractionCallback) {
+	if appConfig.Feedback.Channel == "" {
+		// Global shortcuts have no channel to answer in, so reply by DM
+		channel, err := openDM(callback.User.ID)
+		if err == nil {
+			_, err = sendMessage(outboundMessage{Channel: channel, Text: "Feedback isn't set up yet."})
+		}
+		if err != nil {
+			log.Printf("Error replying to feedback shortcut: %v", err)
+		}
+		return
+	}
+	input := slack.NewPlainTextInputBlockElement(
+		slack.NewTextBlockObject(slack.PlainTextType, "What's on your mind?", false, false), feedbackTextAction)
+	input.Multiline = true
+	anonymous := slack.NewCheckboxGroupsBlockElement(feedbackAnonAction,
+		slack.NewOptionBlockObject("yes", slack.NewTextBlockObject(slack.PlainTextType, "Send anonymously", false, false), nil))
+	view := slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: feedbackCallbackID,
+		Title:      slack.NewTextBlockObject(slack.PlainTextType, "Submit feedback", false, false),
+		Submit:     slack.NewTextBlockObject(slack.PlainTextType, "Send", false, false),
+		Close:      slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(feedbackTextBlock, slack.NewTextBlockObject(slack.PlainTextType, "Feedback", false, false), nil, input),
+			slack.NewInputBlock(feedbackAnonBlock, slack.NewTextBlockObject(slack.PlainTextType, "Anonymity", false, false), nil, anonymous).WithOptional(true),
+		}},
+	}
+	if _, err := slackClient.OpenView(callback.TriggerID, view); err != nil {
+		log.Printf("Error opening feedback modal: %v", err)
+	}
+}
+
+// handleFeedbackSubmission posts submitted feedback to the feedback channel
+func handleFeedbackSubmission(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	values := callback.View.State.Values
+	text := strings.TrimSpace(values[feedbackTextBlock][feedbackTextAction].Value)
+	if text == "" {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{feedbackTextBlock: "Please write some feedback."})
+	}
+	from := fmt.Sprintf("<@%s>", callback.User.ID)
+	if len(values[feedbackAnonBlock][feedbackAnonAction].SelectedOptions) > 0 {
+		from = "someone who asked to stay anonymous"
+	}
+
+	summary := ":speech_balloon: New feedback from " + from
+	_, err := sendMessage(outboundMessage{
+		Channel: appConfig.Feedback.Channel,
+		Text:    summary,
+		Blocks: []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, summary, false, false), nil, nil),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, ">"+quoteText(text), false, false), nil, nil),
+		},
+	})
+	if err != nil {
+		log.Printf("Error posting feedback: %v", err)
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{feedbackTextBlock: "Sorry, your feedback couldn't be sent. Please try again."})
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// Files larger than this are not downloaded for processing
+	defaultMaxFileSize = 20 << 20
+	// Longest side of generated thumbnails, in pixels
+	thumbnailSize = 320
+)
+
+// fileResult is the outcome of running one processor on a file
+type fileResult struct {
+	// Text is added to the threaded reply, if set
+	Text string
+	// Upload is shared in the thread, if set
+	Upload *slack.UploadFileV2Parameters
+	// Stop skips the remaining processors, e.g. when a file is malicious
+	Stop bool
+}
+
+// fileProcessor is one step of the file_shared processing chain
+type fileProcessor interface {
+	// Accepts reports whether the processor wants to see file
+	Accepts(file *slack.File) bool
+	Process(file *slack.File, content []byte) (*fileResult, error)
+}
+
+// fileProcessorFactories creates processors by the name used in config
+var fileProcessorFactories = map[string]func(FilesConfig) (fileProcessor, error){
+	"malware_scan": newMalwareScanProcessor,
+	"csv_summary":  func(FilesConfig) (fileProcessor, error) { return csvSummaryProcessor{}, nil },
+	"thumbnail":    func(FilesConfig) (fileProcessor, error) { return thumbnailProcessor{}, nil },
+}
+
+// The configured processor chain, in order
+var fileProcessors []fileProcessor
+
+// setupFileProcessors builds the processor chain from config
+func setupFileProcessors(cfg FilesConfig) error {
+	for _, name := range cfg.Processors {
+		factory, ok := fileProcessorFactories[name]
+		if !ok {
+			return fmt.Errorf("unknown file processor %q", name)
+		}
+		processor, err := factory(cfg)
+		if err != nil {
+			return fmt.Errorf("configuring file processor %s: %w", name, err)
+		}
+		fileProcessors = append(fileProcessors, processor)
+	}
+	return nil
+}
+
+// handleFileShared processes a newly shared file in the background, since
+// downloading and scanning it can take longer than Slack waits for an ack
+func handleFileShared(ev *slackevents.FileSharedEvent) {
+	cfg := appConfig.Files
+	if len(fileProcessors) == 0 || ev.UserID == botUserID {
+		return
+	}
+	if len(cfg.Channels) > 0 && !slices.Contains(cfg.Channels, ev.ChannelID) {
+		return
+	}
+	go runJob("file processing", func() { processSharedFile(cfg, ev) })
+}
+
+// processSharedFile downloads a shared file, runs it through the processor
+// chain and replies in the file's thread with the results
+func processSharedFile(cfg FilesConfig, ev *slackevents.FileSharedEvent) {
+	file, _, _, err := slackClient.GetFileInfo(ev.FileID, 0, 0)
+	if err != nil {
+		log.Printf("Error fetching file %s: %v", ev.FileID, err)
+		return
+	}
+	var chain []fileProcessor
+	for _, processor := range fileProcessors {
+		if processor.Accepts(file) {
+			chain = append(chain, processor)
+		}
+	}
+	if len(chain) == 0 {
+		return
+	}
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFileSize
+	}
+	if file.Size > maxSize {
+		log.Printf("Skipping file %s: %d bytes is over the %d byte limit", file.ID, file.Size, maxSize)
+		return
+	}
+
+	var content bytes.Buffer
+	if err := slackClient.GetFile(file.URLPrivateDownload, &content); err != nil {
+		log.Printf("Error downloading file %s: %v", file.ID, err)
+		return
+	}
+
+	threadTS := fileShareTS(file, ev.ChannelID)
+	var lines []string
+	for _, processor := range chain {
+		result, err := processor.Process(file, content.Bytes())
+		if err != nil {
+			log.Printf("Error processing file %s: %v", file.ID, err)
+			continue
+		}
+		if result == nil {
+			continue
+		}
+		if result.Text != "" {
+			lines = append(lines, result.Text)
+		}
+		if result.Upload != nil {
+			result.Upload.Channel = ev.ChannelID
+			result.Upload.ThreadTimestamp = threadTS
+			if _, err := slackClient.UploadFileV2(*result.Upload); err != nil {
+				log.Printf("Error uploading result for file %s: %v", file.ID, err)
+			}
+		}
+		if result.Stop {
+			break
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	text := strings.Join(lines, "\n\n")
+	_, err = sendMessage(outboundMessage{
+		Channel:  ev.ChannelID,
+		ThreadTS: threadTS,
+		Text:     text,
+		Blocks:   []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)},
+	})
+	if err != nil {
+		log.Printf("Error replying to file %s: %v", file.ID, err)
+	}
+}
+
+// fileShareTS returns the timestamp of the message that shared file in channel
+func fileShareTS(file *slack.File, channel string) string {
+	for _, shares := range []map[string][]slack.ShareFileInfo{file.Shares.Public, file.Shares.Private} {
+		if infos := shares[channel]; len(infos) > 0 {
+			return infos[0].Ts
+		}
+	}
+	return ""
+}
+
+// malwareScanProcessor posts every file to an external scanner webhook and
+// stops the chain when the scanner flags it
+type malwareScanProcessor struct {
+	url string
+}
+
+// malwareScanVerdict is the response expected from the scanner webhook
+type malwareScanVerdict struct {
+	Clean  bool   `json:"clean"`
+	Threat string `json:"threat"`
+}
+
+func newMalwareScanProcessor(cfg FilesConfig) (fileProcessor, error) {
+	if cfg.MalwareScanURL == "" {
+		return nil, fmt.Errorf("malware_scan processor needs malware_scan_url")
+	}
+	return &malwareScanProcessor{url: cfg.MalwareScanURL}, nil
+}
+
+func (p *malwareScanProcessor) Accepts(*slack.File) bool { return true }
+
+func (p *malwareScanProcessor) Process(file *slack.File, content []byte) (*fileResult, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", file.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(content); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Post(p.url, form.FormDataContentType(), &body)
+	if err != nil {
+		return nil, fmt.Errorf("calling malware scanner: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calling malware scanner: %s", resp.Status)
+	}
+	var verdict malwareScanVerdict
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&verdict); err != nil {
+		return nil, fmt.Errorf("decoding malware scanner response: %w", err)
+	}
+	if verdict.Clean {
+		return nil, nil
+	}
+
+	threat := verdict.Threat
+	if threat == "" {
+		threat = "unknown threat"
+	}
+	return &fileResult{
+		Text: fmt.Sprintf(":warning: *%s* was flagged by the malware scanner (%s). Please don't open it.", file.Name, threat),
+		Stop: true,
+	}, nil
+}
+
+// csvSummaryProcessor replies with the shape of a CSV file and simple
+// statistics for its numeric columns
+type csvSummaryProcessor struct{}
+
+// csvColumnSummary is one row of the CSV summary table
+type csvColumnSummary struct {
+	Column string  `table:"Column"`
+	Type   string  `table:"Type"`
+	Empty  int     `table:"Empty"`
+	Min    float64 `table:"Min"`
+	Max    float64 `table:"Max"`
+	Mean   float64 `table:"Mean"`
+}
+
+func (csvSummaryProcessor) Accepts(file *slack.File) bool {
+	return file.Filetype == "csv" || file.Mimetype == "text/csv"
+}
+
+func (csvSummaryProcessor) Process(file *slack.File, content []byte) (*fileResult, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return &fileResult{Text: fmt.Sprintf("I couldn't read *%s* as CSV: %v", file.Name, err)}, nil
+	}
+	if len(records) == 0 {
+		return &fileResult{Text: fmt.Sprintf("*%s* is empty.", file.Name)}, nil
+	}
+
+	header, rows := records[0], records[1:]
+	summaries := make([]csvColumnSummary, len(header))
+	for i, name := range header {
+		summary := csvColumnSummary{Column: name, Type: "number", Min: math.Inf(1), Max: math.Inf(-1)}
+		sum, count := 0.0, 0
+		for _, row := range rows {
+			if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+				summary.Empty++
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				summary.Type = "text"
+				continue
+			}
+			summary.Min = math.Min(summary.Min, value)
+			summary.Max = math.Max(summary.Max, value)
+			sum += value
+			count++
+		}
+		if summary.Type == "text" || count == 0 {
+			summary.Type = "text"
+			summary.Min, summary.Max = 0, 0
+		} else {
+			summary.Mean = sum / float64(count)
+		}
+		summaries[i] = summary
+	}
+
+	table, err := renderTable(summaries, tableOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &fileResult{
+		Text: fmt.Sprintf("*%s*: %d rows, %d columns\n%s", file.Name, len(rows), len(header), table),
+	}, nil
+}
+
+// thumbnailProcessor shares a small preview of large images
+type thumbnailProcessor struct{}
+
+func (thumbnailProcessor) Accepts(file *slack.File) bool {
+	switch file.Mimetype {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+func (thumbnailProcessor) Process(file *slack.File, content []byte) (*fileResult, error) {
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	bounds := src.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+	if longest <= thumbnailSize {
+		return nil, nil
+	}
+
+	scale := float64(thumbnailSize) / float64(longest)
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	var out bytes.Buffer
+	if err := png.Encode(&out, dst); err != nil {
+		return nil, fmt.Errorf("encoding thumbnail: %w", err)
+	}
+
+	name := strings.TrimSuffix(file.Name, "."+file.Filetype)
+	return &fileResult{Upload: &slack.UploadFileV2Parameters{
+		Reader:   bytes.NewReader(out.Bytes()),
+		FileSize: out.Len(),
+		Filename: name + "-thumbnail.png",
+		Title:    "Thumbnail of " + file.Name,
+		AltTxt:   "Thumbnail of " + file.Name,
+	}}, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Action IDs of the buttons on a GIF preview
+const (
+	actionGIFSend    = "gif_send"
+	actionGIFShuffle = "gif_shuffle"
+	actionGIFCancel  = "gif_cancel"
+)
+
+// Used when the giphy settings aren't set
+const (
+	defaultGiphyKeyEnv = "GIPHY_API_KEY"
+	defaultGiphyRating = "g"
+)
+
+const (
+	// How many search results a preview can shuffle through
+	giphySearchLimit = 25
+	// How long a preview's buttons keep working
+	gifPreviewTTL = 15 * time.Minute
+	// Longest /gif search
+	maxGIFQueryChars = 100
+)
+
+// Content ratings Giphy filters by, mildest first
+var giphyRatings = []string{"g", "pg", "pg-13", "r"}
+
+var giphyAPIURL = "https://api.giphy.com/v1/gifs/search"
+
+// errNoGIFs is returned when a search finds nothing
+var errNoGIFs = errors.New("no GIFs found")
+
+// giphyResult is a GIF a search found
+type giphyResult struct {
+	Title    string
+	ImageURL string
+	PageURL  string
+}
+
+// gifPreview is a search result shown to its user until they send it
+type gifPreview struct {
+	UserID  string
+	Query   string
+	Results []giphyResult
+	Index   int
+	expires time.Time
+}
+
+var (
+	gifPreviewsMu sync.Mutex
+	gifPreviews   = map[string]*gifPreview{}
+)
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/gif",
+		Usage:       "/gif <search>",
+		Description: "Find a GIF and preview it before posting it to the channel",
+		Handler:     handleGIF,
+	})
+	registerBlockAction(actionGIFSend, handleGIFAction)
+	registerBlockAction(actionGIFShuffle, handleGIFAction)
+	registerBlockAction(actionGIFCancel, handleGIFAction)
+}
+
+func handleGIF(req commandRequest) commandResponse {
+	query := strings.TrimSpace(req.Text)
+	if query == "" {
+		return ephemeral("Usage: `/gif <search>`, e.g. `/gif high five`")
+	}
+	if len(query) > maxGIFQueryChars {
+		return ephemeral("That's a long search. Try a few words, like `/gif high five`.")
+	}
+	go runJob("gif", func() {
+		reply := &slack.WebhookMessage{ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true}
+		results, err := searchGiphy(query)
+		switch {
+		case errors.Is(err, errNoGIFs):
+			reply.Text = fmt.Sprintf("I couldn't find a GIF for %q.", query)
+		case err != nil:
+			log.Printf("Error searching Giphy for %q: %v", query, err)
+			reply.Text = "Sorry, something went wrong finding a GIF."
+		default:
+			p := &gifPreview{UserID: req.UserID, Query: query, Results: results, Index: rand.IntN(len(results)), expires: time.Now().Add(gifPreviewTTL)}
+			token := newInteractionToken()
+			gifPreviewsMu.Lock()
+			for key, old := range gifPreviews {
+				if time.Now().After(old.expires) {
+					delete(gifPreviews, key)
+				}
+			}
+			gifPreviews[token] = p
+			gifPreviewsMu.Unlock()
+			reply.Text, reply.Blocks = "Preview of a GIF for "+query, &slack.Blocks{BlockSet: gifPreviewBlocks(p, token)}
+		}
+		if err := respond(req.ResponseURL, reply); err != nil {
+			log.Printf("Error replying to /gif: %v", err)
+		}
+	})
+	return ephemeral(":mag: Looking for a GIF…")
+}
+
+// handleGIFAction posts, swaps or discards the GIF in a preview. Only the
+// user who searched ever sees the preview, since it's ephemeral.
+func handleGIFAction(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	gifPreviewsMu.Lock()
+	p, ok := gifPreviews[action.Value]
+	if ok && action.ActionID != actionGIFShuffle {
+		delete(gifPreviews, action.Value)
+	}
+	var gif giphyResult
+	if ok {
+		if action.ActionID == actionGIFShuffle && len(p.Results) > 1 {
+			// Any other result, so a shuffle always shows something new
+			p.Index = (p.Index + 1 + rand.IntN(len(p.Results)-1)) % len(p.Results)
+		}
+		gif = p.Results[p.Index]
+	}
+	gifPreviewsMu.Unlock()
+
+	if !ok || time.Now().After(p.expires) {
+		if err := respond(callback.ResponseURL, &slack.WebhookMessage{ReplaceOriginal: true, Text: "This preview has expired. Try `/gif` again."}); err != nil {
+			log.Printf("Error updating GIF preview: %v", err)
+		}
+		return
+	}
+	switch action.ActionID {
+	case actionGIFShuffle:
+		err := respond(callback.ResponseURL, &slack.WebhookMessage{ReplaceOriginal: true, Text: "Preview of a GIF for " + p.Query, Blocks: &slack.Blocks{BlockSet: gifPreviewBlocks(p, action.Value)}})
+		if err != nil {
+			log.Printf("Error updating GIF preview: %v", err)
+		}
+	case actionGIFCancel:
+		if err := respond(callback.ResponseURL, &slack.WebhookMessage{DeleteOriginal: true}); err != nil {
+			log.Printf("Error discarding GIF preview: %v", err)
+		}
+	default:
+		if err := respond(callback.ResponseURL, &slack.WebhookMessage{DeleteOriginal: true}); err != nil {
+			log.Printf("Error discarding GIF preview: %v", err)
+		}
+		msg := &slack.WebhookMessage{
+			ResponseType: slack.ResponseTypeInChannel,
+			Text:         fmt.Sprintf("<@%s> sent a GIF: %s", p.UserID, gif.PageURL),
+			Blocks:       &slack.Blocks{BlockSet: gifBlocks(p, gif)},
+		}
+		if err := respond(callback.ResponseURL, msg); err != nil {
+			log.Printf("Error posting GIF: %v", err)
+		}
+	}
+}
+
+// gifBlocks shows a GIF with who sent it and what they searched for
+func gifBlocks(p *gifPreview, gif giphyResult) []slack.Block {
+	title, _ := truncateText(gif.Title, maxHeaderText)
+	if title == "" {
+		title = p.Query
+	}
+	caption := fmt.Sprintf("Sent by <@%s> · `/gif %s` · <%s|Powered by GIPHY>", p.UserID, escapeMrkdwn(p.Query), gif.PageURL)
+	return []slack.Block{
+		slack.NewImageBlock(gif.ImageURL, title, "", slack.NewTextBlockObject(slack.PlainTextType, title, false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, caption, false, false)),
+	}
+}
+
+// gifPreviewBlocks is the GIF with buttons to send it, try another or
+// give up
+func gifPreviewBlocks(p *gifPreview, token string) []slack.Block {
+	gif := p.Results[p.Index]
+	blocks := gifBlocks(p, gif)
+	blocks[1] = slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("Only you can see this. %d of %d for `%s`.", p.Index+1, len(p.Results), escapeMrkdwn(p.Query)), false, false))
+	buttons := []slack.BlockElement{
+		slack.NewButtonBlockElement(actionGIFSend, token, slack.NewTextBlockObject(slack.PlainTextType, "Send", false, false)).WithStyle(slack.StylePrimary),
+	}
+	if len(p.Results) > 1 {
+		buttons = append(buttons, slack.NewButtonBlockElement(actionGIFShuffle, token, slack.NewTextBlockObject(slack.PlainTextType, "Shuffle", false, false)))
+	}
+	buttons = append(buttons, slack.NewButtonBlockElement(actionGIFCancel, token, slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false)))
+	return append(blocks, slack.NewActionBlock("", buttons...))
+}
+
+// giphyRating returns giphy.rating, or g when it's unset or unknown
+func giphyRating() string {
+	rating := strings.ToLower(appConfig.Giphy.Rating)
+	if !slices.Contains(giphyRatings, rating) {
+		return defaultGiphyRating
+	}
+	return rating
+}
+
+// searchGiphy finds GIFs for query no racier than giphy.rating
+func searchGiphy(query string) ([]giphyResult, error) {
+	env := appConfig.Giphy.APIKeyEnv
+	if env == "" {
+		env = defaultGiphyKeyEnv
+	}
+	key := os.Getenv(env)
+	if key == "" {
+		return nil, fmt.Errorf("%s is not set", env)
+	}
+	params := url.Values{
+		"api_key": {key},
+		"q":       {query},
+		"limit":   {fmt.Sprint(giphySearchLimit)},
+		"rating":  {giphyRating()},
+	}
+	resp, err := httpClient.Get(giphyAPIURL + "?" + params.Encode())
+	if err != nil {
+		// The URL carries the API key, which url.Error would log
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var out struct {
+		Data []struct {
+			Title  string `json:"title"`
+			URL    string `json:"url"`
+			Images struct {
+				// Downsized stays under Slack's image size limit
+				Downsized struct {
+					URL string `json:"url"`
+				} `json:"downsized"`
+			} `json:"images"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&out); err != nil {
+		return nil, err
+	}
+	var results []giphyResult
+	for _, d := range out.Data {
+		if d.Images.Downsized.URL != "" {
+			results = append(results, giphyResult{Title: d.Title, ImageURL: d.Images.Downsized.URL, PageURL: d.URL})
+		}
+	}
+	if len(results) == 0 {
+		return nil, errNoGIFs
+	}
+	return results, nil
+}
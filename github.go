@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Store bucket of GitHub logins people linked themselves, keyed by
+// lowercased login; the value is the Slack user ID
+const githubUsersBucket = "github_users"
+
+// Used when the github settings aren't set
+const (
+	defaultGitHubAPIURL   = "https://api.github.com"
+	defaultGitHubTokenEnv = "GITHUB_TOKEN"
+)
+
+// githubLoginPattern matches a GitHub username, with or without the @
+var githubLoginPattern = regexp.MustCompile(`^@?([A-Za-z0-9](?:[A-Za-z0-9-]{0,38}))$`)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "github",
+		Usage:       "github [username | unlink]",
+		Description: "Link your GitHub username so review reminders and mentions reach you",
+		Handler:     handleGitHubLink,
+	})
+	registerUserDataset(userDataset{Name: "github", Title: "Linked GitHub username", Describe: describeUserGitHub, Delete: deleteUserGitHub})
+}
+
+// githubRequest calls GitHub's REST API with the github.token_env token and
+// decodes the JSON answer into out, if given
+func githubRequest(method, path string, body, out any) error {
+	cfg := appConfig.GitHub
+	env := cfg.TokenEnv
+	if env == "" {
+		env = defaultGitHubTokenEnv
+	}
+	token := os.Getenv(env)
+	if token == "" {
+		return fmt.Errorf("%s is not set", env)
+	}
+	base := cfg.APIURL
+	if base == "" {
+		base = defaultGitHubAPIURL
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(out)
+}
+
+// slackUserForGitHub returns the Slack user ID of a GitHub login, from
+// github.users or else the links people made themselves, or "" if unknown
+func slackUserForGitHub(login string) string {
+	for name, user := range appConfig.GitHub.Users {
+		if strings.EqualFold(name, login) {
+			return user
+		}
+	}
+	var user string
+	if _, err := store.Get(githubUsersBucket, strings.ToLower(login), &user); err != nil {
+		log.Printf("Error loading GitHub link for %s: %v", login, err)
+	}
+	return user
+}
+
+// githubMention names a GitHub user the way Slack shows them best: as a
+// mention when they're linked, or by login
+func githubMention(login string) string {
+	if user := slackUserForGitHub(login); user != "" {
+		return "<@" + user + ">"
+	}
+	return "`@" + login + "`"
+}
+
+// githubLoginsOf returns the logins userID has linked
+func githubLoginsOf(userID string) ([]string, error) {
+	var logins []string
+	for name, user := range appConfig.GitHub.Users {
+		if user == userID {
+			logins = append(logins, name)
+		}
+	}
+	for _, login := range store.Keys(githubUsersBucket) {
+		var user string
+		if _, err := store.Get(githubUsersBucket, login, &user); err != nil {
+			return nil, err
+		}
+		if user == userID {
+			logins = append(logins, login)
+		}
+	}
+	return logins, nil
+}
+
+func handleGitHubLink(req commandRequest) commandResponse {
+	if len(req.Args) == 0 {
+		logins, err := githubLoginsOf(req.UserID)
+		if err != nil {
+			log.Printf("Error loading GitHub links: %v", err)
+			return ephemeral("Sorry, something went wrong looking that up.")
+		}
+		if len(logins) == 0 {
+			return ephemeral("You haven't linked a GitHub username. Use `%s github <username>`.", botCommand)
+		}
+		return ephemeral("You're linked to GitHub as `%s`.", strings.Join(logins, "`, `"))
+	}
+	if strings.EqualFold(req.Args[0], "unlink") {
+		if err := deleteUserGitHub(req.UserID); err != nil {
+			log.Printf("Error unlinking GitHub username: %v", err)
+			return ephemeral("Sorry, something went wrong unlinking your username.")
+		}
+		return ephemeral("Your GitHub username is unlinked.")
+	}
+	m := githubLoginPattern.FindStringSubmatch(req.Args[0])
+	if m == nil {
+		return ephemeral("Usage: `%s github [username | unlink]`", botCommand)
+	}
+	login := strings.ToLower(m[1])
+	if owner := slackUserForGitHub(login); owner != "" && owner != req.UserID {
+		return ephemeral("`%s` is already linked to <@%s>. Ask a bot admin if that's wrong.", login, owner)
+	}
+	if err := store.Put(githubUsersBucket, login, req.UserID); err != nil {
+		log.Printf("Error linking GitHub username: %v", err)
+		return ephemeral("Sorry, something went wrong linking your username.")
+	}
+	return ephemeral(":white_check_mark: Linked you to GitHub as `%s`.", login)
+}
+
+func describeUserGitHub(userID string) (string, error) {
+	logins, err := githubLoginsOf(userID)
+	if err != nil {
+		return "", err
+	}
+	return describeItems(logins), nil
+}
+
+// deleteUserGitHub removes the links the user made; github.users is left to
+// the config
+func deleteUserGitHub(userID string) error {
+	for _, login := range store.Keys(githubUsersBucket) {
+		var user string
+		if _, err := store.Get(githubUsersBucket, login, &user); err != nil {
+			return err
+		}
+		if user == userID {
+			if err := store.Delete(githubUsersBucket, login); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
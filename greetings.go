@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Greeting delivery modes
+const (
+	greetingEphemeral = "ephemeral"
+	greetingThread    = "thread"
+	greetingChannel   = "channel"
+)
+
+// greetingData is the data available to channel greeting templates
+type greetingData struct {
+	UserID    string
+	ChannelID string
+	InviterID string
+}
+
+// Store bucket for greetings edited in the admin web UI, keyed by channel
+// ID. They replace the channel's greeting from the config.
+const greetingsBucket = "greetings"
+
+// channelGreeting returns the greeting for channel, if it has one
+func channelGreeting(channel string) (ChannelGreeting, bool) {
+	var greeting ChannelGreeting
+	found, err := store.Get(greetingsBucket, channel, &greeting)
+	if err != nil {
+		log.Printf("Error loading greeting for %s: %v", channel, err)
+	}
+	if found {
+		return greeting, true
+	}
+	greeting, ok := appConfig.Greetings.Channels[channel]
+	return greeting, ok
+}
+
+// handleMemberJoinedChannel welcomes people joining a channel with a greeting
+func handleMemberJoinedChannel(ev *slackevents.MemberJoinedChannelEvent) {
+	markOnboardingChannelJoined(ev.User, ev.Channel)
+	greeting, ok := channelGreeting(ev.Channel)
+	if !ok || ev.User == botUserID || !featureEnabled(featureGreetings, ev.Channel) {
+		return
+	}
+
+	text, err := renderTemplate("greeting", greeting.Message, greetingData{
+		UserID:    ev.User,
+		ChannelID: ev.Channel,
+		InviterID: ev.Inviter,
+	})
+	if err != nil {
+		log.Printf("Error rendering greeting for %s: %v", ev.Channel, err)
+		return
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+	}
+
+	switch greeting.Mode {
+	case greetingThread, greetingChannel:
+		msg := outboundMessage{Channel: ev.Channel, Text: text, Blocks: blocks}
+		if greeting.Mode == greetingThread {
+			msg.ThreadTS = greeting.ThreadTS
+		}
+		_, err = sendMessage(msg)
+	default:
+		err = sendEphemeral(ev.User, outboundMessage{Channel: ev.Channel, Text: text, Blocks: blocks})
+	}
+	if err != nil {
+		log.Printf("Error posting greeting in %s: %v", ev.Channel, err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"slack-bot/botpb"
+)
+
+// grpcServer implements the Bot service for internal callers
+type grpcServer struct {
+	botpb.UnimplementedBotServer
+}
+
+// startGRPCServer serves the Bot gRPC service with mutual TLS when
+// grpc.listen is configured
+func startGRPCServer(cfg GRPCConfig) error {
+	if cfg.Listen == "" {
+		return nil
+	}
+	creds, err := grpcServerCredentials(cfg)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", cfg.Listen, err)
+	}
+
+	server := grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(authorizeGRPCClient))
+	botpb.RegisterBotServer(server, &grpcServer{})
+	go func() {
+		log.Printf("gRPC server starting on %s", cfg.Listen)
+		if err := server.Serve(listener); err != nil {
+			log.Printf("Error serving gRPC: %v", err)
+		}
+	}()
+	return nil
+}
+
+// grpcServerCredentials requires clients to present a certificate signed by
+// the configured CA
+func grpcServerCredentials(cfg GRPCConfig) (credentials.TransportCredentials, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" || cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("grpc needs cert_file, key_file and client_ca_file")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading gRPC server certificate: %w", err)
+	}
+	pool, err := loadCertPool(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading gRPC client CA: %w", err)
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}), nil
+}
+
+// authorizeGRPCClient limits calls to the configured client certificate
+// names, when any are set
+func authorizeGRPCClient(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	name := grpcClientName(ctx)
+	allowed := appConfig.GRPC.AllowedClients
+	if len(allowed) > 0 && !slices.Contains(allowed, name) {
+		log.Printf("Rejected gRPC call %s from %q", info.FullMethod, name)
+		return nil, status.Error(codes.PermissionDenied, "client certificate is not allowed")
+	}
+	if !isLeader() {
+		return nil, status.Error(codes.Unavailable, "this instance is on standby")
+	}
+	log.Printf("gRPC call %s from %q", info.FullMethod, name)
+	return handler(ctx, req)
+}
+
+// grpcClientName returns the common name of the caller's verified
+// certificate
+func grpcClientName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return ""
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+}
+
+func (s *grpcServer) SendMessage(ctx context.Context, req *botpb.SendMessageRequest) (*botpb.SendMessageResponse, error) {
+	if req.Channel == "" || req.Text == "" {
+		return nil, status.Error(codes.InvalidArgument, "channel and text are required")
+	}
+	ts, err := sendMessage(outboundMessage{Channel: req.Channel, ThreadTS: req.ThreadTs, Text: req.Text, NeedTS: true})
+	if err != nil {
+		log.Printf("Error sending message over gRPC: %v", err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &botpb.SendMessageResponse{Channel: req.Channel, Ts: ts}, nil
+}
+
+func (s *grpcServer) CreateIncident(ctx context.Context, req *botpb.CreateIncidentRequest) (*botpb.CreateIncidentResponse, error) {
+	if req.Title == "" || req.CommanderId == "" {
+		return nil, status.Error(codes.InvalidArgument, "title and commander_id are required")
+	}
+	severities := incidentSeverities()
+	severity := severities[len(severities)-1]
+	if req.Severity != "" {
+		i := slices.IndexFunc(severities, func(s string) bool { return strings.EqualFold(s, req.Severity) })
+		if i < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "severity must be one of %s", strings.Join(severities, ", "))
+		}
+		severity = severities[i]
+	}
+	inc, err := startIncident(req.Title, severity, req.Description, req.CommanderId)
+	if err != nil {
+		log.Printf("Error starting incident over gRPC: %v", err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &botpb.CreateIncidentResponse{IncidentId: strconv.Itoa(inc.Number), Channel: inc.Channel}, nil
+}
+
+func (s *grpcServer) ScheduleReminder(ctx context.Context, req *botpb.ScheduleReminderRequest) (*botpb.ScheduleReminderResponse, error) {
+	if req.UserId == "" || req.Text == "" || req.RemindAt == nil {
+		return nil, status.Error(codes.InvalidArgument, "user_id, text and remind_at are required")
+	}
+	if err := req.RemindAt.CheckValid(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r, err := createReminder(req.UserId, "", req.Text, reminderSchedule{Next: req.RemindAt.AsTime()}, time.UTC)
+	if err != nil {
+		log.Printf("Error scheduling reminder over gRPC: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &botpb.ScheduleReminderResponse{ReminderId: r.ID}, nil
+}
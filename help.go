@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/help",
+		Usage:       "/help [command]",
+		Description: "List what I can do, or explain one command",
+		Handler:     handleHelp,
+	})
+	registerBotCommand(&command{
+		Name:        "help",
+		Usage:       "help [command]",
+		Description: "List what I can do, or explain one command",
+		Handler:     handleHelp,
+	})
+}
+
+// usageLine returns how to type cmd, with /bot in front of subcommands
+func (cmd *command) usageLine() string {
+	usage := cmd.Usage
+	if usage == "" {
+		usage = cmd.Name
+	}
+	if !strings.HasPrefix(usage, "/") {
+		usage = botCommand + " " + usage
+	}
+	return usage
+}
+
+// helpCommands returns the commands userID may run, sorted by how they're
+// typed, leaving out admin commands for everyone else
+func helpCommands(userID string) []*command {
+	admin := isAdmin(userID)
+	var commands []*command
+	for _, registry := range []map[string]*command{slashCommands, botCommands} {
+		for _, cmd := range registry {
+			if !cmd.AdminOnly || admin {
+				commands = append(commands, cmd)
+			}
+		}
+	}
+	slices.SortFunc(commands, func(a, b *command) int { return strings.Compare(a.usageLine(), b.usageLine()) })
+	return commands
+}
+
+// handleHelp lists the commands the user can run, grouped by feature, or
+// the ones matching the words given
+func handleHelp(req commandRequest) commandResponse {
+	commands := helpCommands(req.UserID)
+	if len(req.Args) > 0 {
+		query := strings.ToLower(strings.TrimPrefix(strings.Join(req.Args, " "), botCommand+" "))
+		commands = slices.DeleteFunc(commands, func(cmd *command) bool {
+			name := strings.TrimPrefix(cmd.Name, "/")
+			return name != strings.TrimPrefix(query, "/") && !strings.HasPrefix(name, strings.TrimPrefix(query, "/")+" ")
+		})
+		if len(commands) == 0 {
+			return ephemeral("I don't have a command called `%s`. Try `%s help` to see them all.", query, botCommand)
+		}
+	}
+
+	// Group by feature, with admin commands last
+	var groups []string
+	byGroup := map[string][]*command{}
+	for _, cmd := range commands {
+		group := commandFeature(cmd.Name)
+		if cmd.AdminOnly {
+			group = "admin"
+		}
+		if _, ok := byGroup[group]; !ok {
+			groups = append(groups, group)
+		}
+		byGroup[group] = append(byGroup[group], cmd)
+	}
+	slices.SortFunc(groups, func(a, b string) int {
+		if (a == "admin") != (b == "admin") {
+			if a == "admin" {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a, b)
+	})
+
+	blocks := []slack.Block{slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "What I can do", false, false))}
+	var lines []string
+	for _, group := range groups {
+		title := group
+		if group == "admin" {
+			title = "admin (only bot admins see these)"
+		}
+		// Big groups like admin are split over several sections
+		text := "*" + title + "*"
+		var usages []string
+		for _, cmd := range byGroup[group] {
+			entry := fmt.Sprintf("`%s`\n%s", cmd.usageLine(), cmd.Description)
+			if len(text)+1+len(entry) > maxSectionText {
+				blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+				text = ""
+			}
+			text = strings.TrimPrefix(text+"\n"+entry, "\n")
+			usages = append(usages, "`"+cmd.usageLine()+"`")
+		}
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+		lines = append(lines, "*"+title+"*: "+strings.Join(usages, ", "))
+	}
+	if len(req.Args) == 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("Mention me or DM me with any of these too, leaving out `%s`. `%s help <command>` shows just one.", botCommand, botCommand), false, false)))
+	}
+	return commandResponse{Text: strings.Join(lines, "\n"), Blocks: blocks}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Shown in the Home tab when no help text is configured
+const defaultHomeHelp = "Mention me in any channel and I'll reply. More features will show up here as they are enabled."
+
+// homeSection contributes blocks for userID to the Home tab. Returning no
+// blocks hides the section.
+type homeSection func(userID string) []slack.Block
+
+var (
+	homeSections []homeSection
+
+	// Users who have opened the Home tab at least once since startup
+	homeUsersMu sync.Mutex
+	homeUsers   = map[string]bool{}
+)
+
+// registerHomeSection adds a section to every user's Home tab. Sections are
+// rendered in registration order, above the help section.
+func registerHomeSection(section homeSection) {
+	homeSections = append(homeSections, section)
+}
+
+// handleAppHomeOpened publishes a fresh Home view when the Home tab is opened
+func handleAppHomeOpened(ev *slackevents.AppHomeOpenedEvent) {
+	if ev.Tab != "home" {
+		return
+	}
+	homeUsersMu.Lock()
+	homeUsers[ev.User] = true
+	homeUsersMu.Unlock()
+
+	if err := publishHome(ev.User); err != nil {
+		log.Printf("Error publishing home view: %v", err)
+	}
+}
+
+// refreshHome republishes the Home view for userID after data shown there has
+// changed. Users who have never opened the Home tab are skipped.
+func refreshHome(userID string) {
+	homeUsersMu.Lock()
+	seen := homeUsers[userID]
+	homeUsersMu.Unlock()
+	if !seen {
+		return
+	}
+	if err := publishHome(userID); err != nil {
+		log.Printf("Error refreshing home view: %v", err)
+	}
+}
+
+// publishHome builds and publishes the Home tab for userID
+func publishHome(userID string) error {
+	view := slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: fitViewBlocks(buildHomeBlocks(userID))},
+	}
+	_, err := slackClient.PublishViewContext(context.Background(), slack.PublishViewContextRequest{
+		UserID: userID,
+		View:   view,
+	})
+	return err
+}
+
+// buildHomeBlocks renders every registered section followed by the help section
+func buildHomeBlocks(userID string) []slack.Block {
+	var blocks []slack.Block
+	for _, section := range homeSections {
+		sectionBlocks := section(userID)
+		if len(sectionBlocks) == 0 {
+			continue
+		}
+		blocks = append(blocks, sectionBlocks...)
+		blocks = append(blocks, slack.NewDividerBlock())
+	}
+
+	help := appConfig.Home.Help
+	if help == "" {
+		help = defaultHomeHelp
+	}
+	blocks = append(blocks,
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Help", false, false)),
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, help, false, false), nil, nil),
+	)
+	return blocks
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/slack-go/slack"
+)
+
+// importance says how much a bot message matters, so channels can decide
+// what to do with it. The zero value is info.
+type importance string
+
+const (
+	importanceInfo     importance = "info"
+	importanceNotice   importance = "notice"
+	importanceWarning  importance = "warning"
+	importanceCritical importance = "critical"
+)
+
+// What a channel's routing policy does with a message
+const (
+	routePost     = "post"
+	routeThread   = "thread"
+	routeSuppress = "suppress"
+	routeDMOwner  = "dm_owner"
+	routeDigest   = "digest"
+)
+
+// Store bucket for the per-channel thread that collects thread-routed
+// messages, keyed by channel ID with the thread's timestamp as value
+const noticeThreadsBucket = "notice_threads"
+
+// Store bucket for routing policies set in the admin web UI, keyed by
+// channel ID. They replace the channel's policy from the config.
+const routingPoliciesBucket = "routing_policies"
+
+func init() {
+	registerApplyKind(applyKind{Name: "routing_policies", Bucket: routingPoliciesBucket, Decode: decodeRoutingPolicySpec})
+}
+
+// decodeRoutingPolicySpec decodes a channel's routing policy from a POST
+// /apply document, like {"channel": "C123", "policy": {"info": "digest"}}
+func decodeRoutingPolicySpec(raw json.RawMessage) (string, any, error) {
+	var spec struct {
+		Channel string            `json:"channel"`
+		Policy  map[string]string `json:"policy"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return "", nil, err
+	}
+	if !channelIDPattern.MatchString(spec.Channel) {
+		return "", nil, fmt.Errorf("routing policy needs a channel ID, not %q", spec.Channel)
+	}
+	for level, route := range spec.Policy {
+		if !slices.Contains(routeLevels, level) {
+			return "", nil, fmt.Errorf("routing policy for %s: %q isn't an importance level", spec.Channel, level)
+		}
+		if !slices.Contains(routeNames, route) {
+			return "", nil, fmt.Errorf("routing policy for %s: %q isn't a route", spec.Channel, route)
+		}
+	}
+	if spec.Policy == nil {
+		spec.Policy = map[string]string{}
+	}
+	return spec.Channel, spec.Policy, nil
+}
+
+// routeFor returns what the routing policy does with a message of level in
+// channel: the channel's own policy (from the store, then the config), then
+// digest for info messages in channels listed under routing.digests, then
+// routing.default, then post
+func routeFor(channel string, level importance) string {
+	level = importanceOrInfo(level)
+	cfg := appConfig.Routing
+	policy := cfg.Channels[channel]
+	var stored map[string]string
+	if found, err := store.Get(routingPoliciesBucket, channel, &stored); err != nil {
+		log.Printf("Error loading routing policy for %s: %v", channel, err)
+	} else if found {
+		policy = stored
+	}
+	if route, ok := policy[string(level)]; ok {
+		return route
+	}
+	if _, ok := cfg.Digests[channel]; ok && level == importanceInfo {
+		return routeDigest
+	}
+	if route, ok := cfg.Default[string(level)]; ok {
+		return route
+	}
+	return routePost
+}
+
+// routeMessage applies the channel's routing policy to msg. It reports
+// false when msg should simply be posted.
+func routeMessage(msg outboundMessage) (string, bool, error) {
+	route := routeFor(msg.Channel, msg.Importance)
+	msg.routed = true
+	// Buttons and later updates need the message itself in the channel
+	if route != routePost && (msg.NeedTS || hasInteractiveBlocks(msg.Blocks)) {
+		return "", false, nil
+	}
+	switch route {
+	case routePost:
+		return "", false, nil
+	case routeSuppress:
+		log.Printf("Suppressed %s message to %s by routing policy", importanceOrInfo(msg.Importance), msg.Channel)
+		recordDelivery(msg, routeSuppress, "", nil)
+		return "", true, nil
+	case routeThread:
+		if msg.ThreadTS != "" {
+			return "", false, nil
+		}
+		threadTS, err := noticeThread(msg.Channel)
+		if err != nil {
+			return "", true, err
+		}
+		msg.ThreadTS, msg.Broadcast = threadTS, false
+		ts, err := sendMessage(msg)
+		return ts, true, err
+	case routeDigest:
+		// Replies belong with what they reply to
+		if msg.ThreadTS != "" {
+			return "", false, nil
+		}
+		if err := queueForDigest(msg); err != nil {
+			log.Printf("Error queueing message for %s digest, posting instead: %v", msg.Channel, err)
+			return "", false, nil
+		}
+		recordDelivery(msg, routeDigest, "", nil)
+		return "", true, nil
+	case routeDMOwner:
+		owners, err := channelOwners(msg.Channel)
+		if err != nil || len(owners) == 0 {
+			if err != nil {
+				log.Printf("Error finding owners of %s, posting instead: %v", msg.Channel, err)
+			}
+			return "", false, nil
+		}
+		msg.Text = fmt.Sprintf("Sent to you as an owner of <#%s>: %s", msg.Channel, msg.Text)
+		for _, owner := range owners {
+			if _, err := sendDM("routing", owner, msg); err != nil {
+				log.Printf("Error sending routed message to %s: %v", owner, err)
+			}
+		}
+		return "", true, nil
+	default:
+		log.Printf("Unknown route %q for %s in %s, posting instead", route, importanceOrInfo(msg.Importance), msg.Channel)
+		return "", false, nil
+	}
+}
+
+// hasInteractiveBlocks reports whether blocks have buttons, menus or inputs
+// for people to use
+func hasInteractiveBlocks(blocks []slack.Block) bool {
+	for _, block := range blocks {
+		switch b := block.(type) {
+		case *slack.ActionBlock, *slack.InputBlock:
+			return true
+		case *slack.SectionBlock:
+			if b.Accessory != nil && b.Accessory.ImageElement == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func importanceOrInfo(level importance) importance {
+	if level == "" {
+		return importanceInfo
+	}
+	return level
+}
+
+// noticeThread returns the channel's bot notices thread, starting it on
+// first use
+func noticeThread(channel string) (string, error) {
+	var threadTS string
+	if _, err := store.Get(noticeThreadsBucket, channel, &threadTS); err != nil {
+		return "", err
+	}
+	if threadTS != "" {
+		return threadTS, nil
+	}
+	threadTS, err := sendMessage(outboundMessage{
+		Channel: channel,
+		Text:    ":robot_face: *Bot notices* — lower-priority updates for this channel are collected in this thread.",
+		routed:  true,
+	})
+	if err != nil {
+		return "", err
+	}
+	return threadTS, store.Put(noticeThreadsBucket, channel, threadTS)
+}
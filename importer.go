@@ -0,0 +1,517 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bots and tools `slack-bot import --from` understands
+const (
+	importHubot    = "hubot"
+	importErrbot   = "errbot"
+	importWorkflow = "workflow"
+)
+
+// importResult is what an import found: the triggers and schedules it
+// could translate, and a note for everything it couldn't
+type importResult struct {
+	Triggers  []Trigger
+	Schedules []ScheduledPost
+	Skipped   []string
+}
+
+func (r *importResult) skip(where, format string, args ...any) {
+	r.Skipped = append(r.Skipped, where+": "+fmt.Sprintf(format, args...))
+}
+
+// importSource translates the scripts or exports at path
+func importSource(from, path string) (importResult, error) {
+	switch from {
+	case importHubot:
+		return importFiles(path, []string{".coffee", ".js"}, importHubotScript)
+	case importErrbot:
+		return importFiles(path, []string{".py"}, importErrbotPlugin)
+	case importWorkflow:
+		return importFiles(path, []string{".json"}, importWorkflowExport)
+	}
+	return importResult{}, fmt.Errorf("unknown source %q; use %s, %s or %s", from, importHubot, importErrbot, importWorkflow)
+}
+
+// importFiles runs translate on path, or on every file under it with one of
+// the extensions
+func importFiles(path string, extensions []string, translate func(name, source string, result *importResult)) (importResult, error) {
+	var result importResult
+	err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || (file != path && !slices.Contains(extensions, filepath.Ext(file))) {
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		translate(file, string(data), &result)
+		return nil
+	})
+	if err != nil {
+		return importResult{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return result, nil
+}
+
+// saveImport writes the translated triggers and schedules to the store,
+// leaving existing ones with the same name alone unless overwrite is set.
+// It returns a line for each item saved or kept.
+func saveImport(s *Store, result importResult, overwrite bool) ([]string, error) {
+	var lines []string
+	save := func(bucket, kind, name string, value any) error {
+		found, err := s.Get(bucket, name, new(json.RawMessage))
+		if err != nil {
+			return err
+		}
+		if found && !overwrite {
+			lines = append(lines, fmt.Sprintf("kept existing %s %s (use --overwrite to replace it)", kind, name))
+			return nil
+		}
+		if err := s.Put(bucket, name, value); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("imported %s %s", kind, name))
+		return nil
+	}
+	for _, trigger := range result.Triggers {
+		if err := save(triggersBucket, "trigger", trigger.Name, trigger); err != nil {
+			return lines, err
+		}
+	}
+	for _, post := range result.Schedules {
+		if err := save(schedulesBucket, "schedule", post.Name, post); err != nil {
+			return lines, err
+		}
+	}
+	return lines, nil
+}
+
+// importName names the nth item imported from file, like "greetings-2"
+func importName(file string, n int) string {
+	return importSlug(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), n)
+}
+
+// importSlug turns a name into a lowercase store key, numbered n
+func importSlug(name string, n int) string {
+	slug := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name)), "-")
+	return fmt.Sprintf("%s-%d", slug, n)
+}
+
+// escapeTemplate makes literal text safe to use as a text/template
+func escapeTemplate(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{"{{"}}`)
+}
+
+// Hubot listeners, e.g. robot.hear /hello/i, (res) ->
+var (
+	hubotListenerPattern = regexp.MustCompile(`robot\.(hear|respond)\s*\(?\s*/((?:\\/|[^/\n])+)/([gimsuy]*)\s*,\s*(?:\(?\s*\w+\s*\)?\s*(?:->|=>)|function\s*\(\s*\w*\s*\)\s*\{)`)
+	hubotCronPattern     = regexp.MustCompile(`new\s+CronJob\s*\(?\s*['"]([^'"]+)['"]\s*,\s*(?:\(\s*\)\s*)?(?:->|=>|function\s*\(\s*\)\s*\{)`)
+	hubotSendPattern     = regexp.MustCompile(`^\w+\.(send|reply)\s*\(?\s*("(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|` + "`(?:\\\\.|[^`\\\\])*`" + `)\s*\)?;?$`)
+	hubotRoomPattern     = regexp.MustCompile(`^robot\.messageRoom\s*\(?\s*['"]([^'"]+)['"]\s*,\s*("(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|` + "`(?:\\\\.|[^`\\\\])*`" + `)\s*\)?;?$`)
+	hubotMatchPattern    = regexp.MustCompile(`[#$]\{\s*\w+\.match\[(\d+)\]\s*\}`)
+	hubotUserPattern     = regexp.MustCompile(`[#$]\{\s*\w+\.message\.user\.(?:name|id)\s*\}`)
+	quotedZonePattern    = regexp.MustCompile(`['"]([A-Za-z_]+/[A-Za-z_]+(?:/[A-Za-z_]+)?)['"]`)
+)
+
+// importHubotScript translates a Hubot script's hear and respond listeners
+// that only send or reply with a string, and hubot-cron jobs that only post
+// a string to a room
+func importHubotScript(file, source string, result *importResult) {
+	lines := strings.Split(source, "\n")
+	n := 0
+	for i, line := range lines {
+		where := fmt.Sprintf("%s:%d", file, i+1)
+		if m := hubotListenerPattern.FindStringSubmatchIndex(line); m != nil {
+			kind, pattern, flags := line[m[2]:m[3]], line[m[4]:m[5]], line[m[6]:m[7]]
+			statement, ok := singleStatement(lines, i, line[m[1]:])
+			if !ok {
+				result.skip(where, "the %s listener does more than send one reply", kind)
+				continue
+			}
+			send := hubotSendPattern.FindStringSubmatch(statement)
+			if send == nil {
+				result.skip(where, "the %s listener's reply isn't a plain string: %s", kind, statement)
+				continue
+			}
+			response, err := hubotTemplate(send[2])
+			if err != nil {
+				result.skip(where, "%v", err)
+				continue
+			}
+			if send[1] == "reply" {
+				response = "<@{{.User}}> " + response
+			}
+			regex := strings.ReplaceAll(pattern, `\/`, "/")
+			if kind == "respond" {
+				// respond listeners only answer messages addressed to the bot
+				regex = leadingMentionPattern.String() + strings.TrimPrefix(regex, "^")
+			}
+			if strings.Contains(flags, "i") {
+				regex = "(?i)" + regex
+			}
+			n++
+			addImportedTrigger(result, where, Trigger{Name: importName(file, n), Regex: regex, Response: response})
+			continue
+		}
+		if m := hubotCronPattern.FindStringSubmatchIndex(line); m != nil {
+			statement, ok := singleStatement(lines, i, line[m[1]:])
+			room := hubotRoomPattern.FindStringSubmatch(statement)
+			if !ok || room == nil {
+				result.skip(where, "the cron job does more than post one message with robot.messageRoom")
+				continue
+			}
+			cron, err := importCron(line[m[2]:m[3]])
+			if err != nil {
+				result.skip(where, "%v", err)
+				continue
+			}
+			text, err := hubotTemplate(room[2])
+			if err != nil {
+				result.skip(where, "the cron job's message isn't a plain string")
+				continue
+			}
+			post := ScheduledPost{Cron: cron, Channel: importChannel(room[1]), Text: text}
+			// hubot-cron takes the timezone as a later argument
+			if zone := quotedZonePattern.FindStringSubmatch(strings.Join(lines[i:min(i+statementLines(lines, i)+2, len(lines))], "\n")); zone != nil {
+				post.Timezone = zone[1]
+			}
+			n++
+			post.Name = importName(file, n)
+			addImportedSchedule(result, where, post)
+		}
+	}
+}
+
+// singleStatement returns the only statement in the body of the function
+// starting on line i, whose header ends with rest. CoffeeScript bodies are
+// the more deeply indented lines that follow; JavaScript ones end at the
+// closing brace.
+func singleStatement(lines []string, i int, rest string) (string, bool) {
+	var statements []string
+	rest = strings.TrimPrefix(strings.TrimSpace(rest), "{")
+	if rest = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "});")); rest != "" {
+		statements = append(statements, rest)
+	}
+	for _, line := range lines[i+1 : i+1+statementLines(lines, i)] {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") || isDocstring(line) {
+			continue
+		}
+		if line = strings.TrimSpace(strings.TrimRight(line, "});")); line != "" && !strings.HasPrefix(line, ",") {
+			statements = append(statements, line)
+		}
+	}
+	if len(statements) != 1 {
+		return "", false
+	}
+	return statements[0], true
+}
+
+// isDocstring reports whether line is a one-line Python docstring
+func isDocstring(line string) bool {
+	for _, quote := range []string{`"""`, "'''"} {
+		if len(line) >= 6 && strings.HasPrefix(line, quote) && strings.HasSuffix(line, quote) {
+			return true
+		}
+	}
+	return false
+}
+
+// statementLines counts the lines after line i indented more deeply than it
+func statementLines(lines []string, i int) int {
+	indent := len(lines[i]) - len(strings.TrimLeft(lines[i], " \t"))
+	n := 0
+	for _, line := range lines[i+1:] {
+		if strings.TrimSpace(line) != "" && len(line)-len(strings.TrimLeft(line, " \t")) <= indent {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// hubotTemplate turns a CoffeeScript or JavaScript string literal into a
+// response template, translating match groups and the user's name
+func hubotTemplate(literal string) (string, error) {
+	quote := literal[0]
+	text := literal[1 : len(literal)-1]
+	text = strings.NewReplacer(`\`+string(quote), string(quote), `\n`, "\n", `\\`, `\`).Replace(text)
+	text = escapeTemplate(text)
+	if quote == '\'' {
+		return text, nil
+	}
+	text = hubotMatchPattern.ReplaceAllString(text, "{{index .Groups $1}}")
+	text = hubotUserPattern.ReplaceAllString(text, "<@{{.User}}>")
+	if strings.Contains(text, "#{") || strings.Contains(text, "${") {
+		return "", fmt.Errorf("the reply %s uses expressions other than match groups and the user's name", literal)
+	}
+	return text, nil
+}
+
+// Errbot commands: the decorator, then the method it decorates
+var (
+	errbotDecoratorPattern = regexp.MustCompile(`^(\s*)@(botcmd|re_botcmd|arg_botcmd)\b(.*)$`)
+	errbotDefPattern       = regexp.MustCompile(`^\s*def\s+(\w+)\s*\(`)
+	errbotRegexArgPattern  = regexp.MustCompile(`pattern\s*=\s*r?("(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*')`)
+	errbotReturnPattern    = regexp.MustCompile(`^(?:return|yield)\s+(f?)r?("(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*')$`)
+	errbotGroupPattern     = regexp.MustCompile(`\{\s*match\.group\(\s*(\d+)\s*\)\s*\}`)
+	errbotArgsPattern      = regexp.MustCompile(`\{\s*args\s*\}`)
+	errbotSenderPattern    = regexp.MustCompile(`\{\s*msg\.frm(?:\.person)?\s*\}`)
+)
+
+// importErrbotPlugin translates Errbot commands whose method only returns
+// a string. Prefixed commands answer messages starting with !, Errbot's
+// default prefix.
+func importErrbotPlugin(file, source string, result *importResult) {
+	lines := strings.Split(source, "\n")
+	n := 0
+	for i, line := range lines {
+		decorator := errbotDecoratorPattern.FindStringSubmatch(line)
+		if decorator == nil {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", file, i+1)
+		if decorator[2] == "arg_botcmd" {
+			result.skip(where, "arg_botcmd commands parse arguments, which triggers can't")
+			continue
+		}
+		def := -1
+		for j := i + 1; j < len(lines) && j < i+10; j++ {
+			if errbotDefPattern.MatchString(lines[j]) {
+				def = j
+				break
+			}
+		}
+		if def < 0 {
+			result.skip(where, "couldn't find the method this decorates")
+			continue
+		}
+		name := errbotDefPattern.FindStringSubmatch(lines[def])[1]
+		statement, ok := singleStatement(lines, def, "")
+		ret := errbotReturnPattern.FindStringSubmatch(statement)
+		if !ok || ret == nil {
+			result.skip(where, "%s does more than return a string", name)
+			continue
+		}
+
+		args := decorator[3]
+		var regex string
+		if decorator[2] == "re_botcmd" {
+			m := errbotRegexArgPattern.FindStringSubmatch(args)
+			if m == nil {
+				result.skip(where, "%s has no pattern= argument", name)
+				continue
+			}
+			regex = m[1][1 : len(m[1])-1]
+			if !strings.Contains(args, "prefixed=False") {
+				regex = `^!` + strings.TrimPrefix(regex, "^")
+			}
+			if strings.Contains(args, "IGNORECASE") || strings.Contains(args, "re.I)") {
+				regex = "(?i)" + regex
+			}
+		} else {
+			// Errbot turns underscores in method names into spaces
+			regex = `(?i)^!` + regexp.QuoteMeta(strings.ReplaceAll(name, "_", " ")) + `(?:\s+(.*))?$`
+		}
+
+		text := escapeTemplate(ret[2][1 : len(ret[2])-1])
+		if ret[1] == "f" {
+			text = errbotGroupPattern.ReplaceAllString(text, "{{index .Groups $1}}")
+			if decorator[2] == "botcmd" {
+				text = errbotArgsPattern.ReplaceAllString(text, "{{index .Groups 1}}")
+			}
+			text = errbotSenderPattern.ReplaceAllString(text, "<@{{.User}}>")
+			if strings.ContainsAny(strings.ReplaceAll(strings.ReplaceAll(text, "{{", ""), "}}", ""), "{}") {
+				result.skip(where, "%s's reply uses expressions other than match groups, args and the sender", name)
+				continue
+			}
+		}
+		n++
+		addImportedTrigger(result, where, Trigger{Name: importName(file, n), Regex: regex, Response: text})
+	}
+}
+
+// workflowExport is the part of a Workflow Builder export file the importer
+// reads
+type workflowExport struct {
+	Workflow struct {
+		Name      string `json:"name"`
+		Blueprint struct {
+			Trigger struct {
+				Type   string          `json:"type"`
+				Config json.RawMessage `json:"config"`
+			} `json:"trigger"`
+			Steps []struct {
+				Type   string `json:"type"`
+				Config struct {
+					Channel struct {
+						Value string `json:"value"`
+					} `json:"channel"`
+					MessageText string `json:"message_text"`
+				} `json:"config"`
+			} `json:"steps"`
+		} `json:"blueprint"`
+	} `json:"workflow"`
+}
+
+// workflowSchedule is a scheduled trigger's config
+type workflowSchedule struct {
+	// Start is RFC 3339 or Unix seconds
+	Start     json.RawMessage `json:"start"`
+	StartTime json.RawMessage `json:"start_time"`
+	Frequency string          `json:"frequency"`
+	Timezone  string          `json:"timezone"`
+}
+
+// importWorkflowExport translates a scheduled workflow whose steps all send
+// a message to a channel into one scheduled post per step
+func importWorkflowExport(file, source string, result *importResult) {
+	var export workflowExport
+	if err := json.Unmarshal([]byte(source), &export); err != nil {
+		result.skip(file, "not a Workflow Builder export: %v", err)
+		return
+	}
+	blueprint := export.Workflow.Blueprint
+	if !strings.HasPrefix(blueprint.Trigger.Type, "schedule") {
+		result.skip(file, "workflow %q starts on a %q trigger; only scheduled workflows can be imported", export.Workflow.Name, blueprint.Trigger.Type)
+		return
+	}
+	var schedule workflowSchedule
+	if err := json.Unmarshal(blueprint.Trigger.Config, &schedule); err != nil {
+		result.skip(file, "workflow %q: can't read its schedule: %v", export.Workflow.Name, err)
+		return
+	}
+	cron, err := schedule.cron()
+	if err != nil {
+		result.skip(file, "workflow %q: %v", export.Workflow.Name, err)
+		return
+	}
+
+	for i, step := range blueprint.Steps {
+		where := fmt.Sprintf("%s step %d", file, i+1)
+		channel, text := step.Config.Channel.Value, step.Config.MessageText
+		if step.Type != "message" || channel == "" || text == "" {
+			result.skip(where, "only steps that send a message to a channel can be imported, not %q", step.Type)
+			continue
+		}
+		if strings.Contains(text, "{{") {
+			result.skip(where, "the message uses workflow variables")
+			continue
+		}
+		addImportedSchedule(result, where, ScheduledPost{
+			Name:     importSlug(export.Workflow.Name, i+1),
+			Cron:     cron,
+			Channel:  channel,
+			Timezone: schedule.Timezone,
+			Text:     text,
+		})
+	}
+}
+
+// cron translates the schedule into a cron expression
+func (s workflowSchedule) cron() (string, error) {
+	raw := s.Start
+	if len(raw) == 0 {
+		raw = s.StartTime
+	}
+	var start time.Time
+	var text string
+	var seconds int64
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			start = time.Unix(n, 0)
+		} else if start, err = time.Parse(time.RFC3339, text); err != nil {
+			return "", fmt.Errorf("unknown start time %s", raw)
+		}
+	} else if err := json.Unmarshal(raw, &seconds); err == nil {
+		start = time.Unix(seconds, 0)
+	} else {
+		return "", fmt.Errorf("the schedule has no start time")
+	}
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("timezone: %w", err)
+		}
+		start = start.In(loc)
+	}
+	at := fmt.Sprintf("%d %d", start.Minute(), start.Hour())
+	switch strings.ToLower(s.Frequency) {
+	case "daily":
+		return at + " * * *", nil
+	case "every_weekday", "weekdays":
+		return at + " * * 1-5", nil
+	case "weekly":
+		return fmt.Sprintf("%s * * %d", at, start.Weekday()), nil
+	case "monthly":
+		return fmt.Sprintf("%s %d * *", at, start.Day()), nil
+	case "yearly", "annually":
+		return fmt.Sprintf("%s %d %d *", at, start.Day(), start.Month()), nil
+	}
+	return "", fmt.Errorf("unknown frequency %q", s.Frequency)
+}
+
+// importCron accepts five-field cron expressions, and six-field ones with
+// seconds, as used by hubot-cron, when they run on the minute
+func importCron(expr string) (string, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 6 {
+		if fields[0] != "0" && fields[0] != "00" {
+			return "", fmt.Errorf("cron expression %q runs at seconds past the minute", expr)
+		}
+		fields = fields[1:]
+	}
+	cron := strings.Join(fields, " ")
+	if _, err := parseCron(cron); err != nil {
+		return "", err
+	}
+	return cron, nil
+}
+
+// importChannel keeps channel IDs and turns room names into #names, which
+// Slack accepts when posting
+func importChannel(room string) string {
+	if channelIDPattern.MatchString(room) {
+		return room
+	}
+	return "#" + strings.TrimPrefix(room, "#")
+}
+
+var channelIDPattern = regexp.MustCompile(`^[CGD][A-Z0-9]{8,}$`)
+
+func addImportedTrigger(result *importResult, where string, trigger Trigger) {
+	if err := trigger.validate(); err != nil {
+		result.skip(where, "%v", err)
+		return
+	}
+	result.Triggers = append(result.Triggers, trigger)
+}
+
+func addImportedSchedule(result *importResult, where string, post ScheduledPost) {
+	if err := post.validate(); err != nil {
+		result.skip(where, "%v", err)
+		return
+	}
+	result.Schedules = append(result.Schedules, post)
+}
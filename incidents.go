@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for incidents, keyed by the incident channel's ID
+const incidentsBucket = "incidents"
+
+// Used when incidents.channel_prefix and incidents.archive_after aren't set
+const (
+	defaultIncidentChannelPrefix = "inc"
+	defaultIncidentArchiveAfter  = 24 * time.Hour
+)
+
+// Slack channel names and topics can't be longer than these
+const (
+	maxChannelNameLength = 80
+	maxTopicLength       = 250
+)
+
+// Severities used when incidents.severities is unset, most severe first
+var defaultIncidentSeverities = []string{"sev1", "sev2", "sev3", "sev4"}
+
+// Runs of characters Slack doesn't allow in channel names
+var channelNameInvalidPattern = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+const (
+	incidentActive   = "active"
+	incidentResolved = "resolved"
+)
+
+// incident is an incident with its own channel
+type incident struct {
+	// Number counts incidents, starting at 1
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	Severity  string    `json:"severity"`
+	Channel   string    `json:"channel"`
+	Commander string    `json:"commander"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+
+	ResolvedAt time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy string    `json:"resolved_by,omitempty"`
+	Summary    string    `json:"summary,omitempty"`
+	// ArchiveAt is when the channel of a resolved incident is archived
+	ArchiveAt time.Time `json:"archive_at,omitempty"`
+	Archived  bool      `json:"archived,omitempty"`
+	// PostmortemCanvas is the channel canvas holding the postmortem draft
+	PostmortemCanvas string `json:"postmortem_canvas,omitempty"`
+	// Timeline is the messages people recorded with
+	// incidents.timeline_reaction, oldest first
+	Timeline []timelineEntry `json:"timeline,omitempty"`
+}
+
+// Serializes numbering and changes to incidents
+var incidentsMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/incident",
+		Usage:       "/incident start <title> [severity] | resolve [summary] | timeline | postmortem | list",
+		Description: "Open an incident with its own channel, resolve it, see its timeline, draft its postmortem, or list the open ones",
+		Handler:     handleIncidentCommand,
+	})
+}
+
+// startIncidents archives the channels of resolved incidents once their
+// delay is up
+func startIncidents() {
+	runEvery("incident archiving", time.Minute, archiveResolvedIncidents)
+}
+
+func incidentSeverities() []string {
+	if len(appConfig.Incidents.Severities) > 0 {
+		return appConfig.Incidents.Severities
+	}
+	return defaultIncidentSeverities
+}
+
+func handleIncidentCommand(req commandRequest) commandResponse {
+	usage := "Usage: `/incident start <title> [severity]`, `/incident resolve [summary]`, `/incident timeline`, `/incident postmortem` or `/incident list`"
+	if len(req.Args) == 0 {
+		return ephemeral("%s", usage)
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(req.Text), req.Args[0]))
+	switch strings.ToLower(req.Args[0]) {
+	case "start":
+		return handleIncidentStart(req, rest)
+	case "resolve":
+		return handleIncidentResolve(req, rest)
+	case "timeline":
+		return handleIncidentTimeline(req)
+	case "postmortem":
+		return handleIncidentPostmortem(req)
+	case "list":
+		return handleIncidentList()
+	}
+	return ephemeral("%s", usage)
+}
+
+// handleIncidentStart opens an incident: "/incident start Checkout is down sev1"
+func handleIncidentStart(req commandRequest, text string) commandResponse {
+	severities := incidentSeverities()
+	words := parseQuotedArgs(text)
+	severity := severities[len(severities)-1]
+	if n := len(words); n > 1 {
+		if i := slices.IndexFunc(severities, func(s string) bool { return strings.EqualFold(s, words[n-1]) }); i >= 0 {
+			severity = severities[i]
+			words = words[:n-1]
+		}
+	}
+	title := strings.TrimSpace(strings.Join(words, " "))
+	if title == "" {
+		return ephemeral("Usage: `/incident start <title> [severity]`, with severity one of %s", strings.Join(severities, ", "))
+	}
+
+	// Opening the channel takes several Slack calls, longer than Slack waits
+	// before retrying the command and opening a second incident
+	go runJob("incident start", func() {
+		text := ""
+		inc, err := startIncident(title, severity, "", req.UserID)
+		if err != nil {
+			log.Printf("Error starting incident: %v", err)
+			text = fmt.Sprintf("Sorry, something went wrong opening the incident: %v", err)
+		} else {
+			text = fmt.Sprintf(":rotating_light: Incident #%d is open in <#%s>.", inc.Number, inc.Channel)
+		}
+		if err := respond(req.ResponseURL, &slack.WebhookMessage{ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true, Text: text}); err != nil {
+			log.Printf("Error replying to /incident start: %v", err)
+		}
+	})
+	return ephemeral(":rotating_light: Opening the incident…")
+}
+
+// startIncident creates the incident's channel, brings in the commander and
+// the on-call group, and posts the kickoff message with the description,
+// if any
+func startIncident(title, severity, description, commander string) (*incident, error) {
+	cfg := appConfig.Incidents
+	incidentsMu.Lock()
+	inc := &incident{
+		Number:    len(store.Keys(incidentsBucket)) + 1,
+		Title:     title,
+		Severity:  strings.ToUpper(severity),
+		Commander: commander,
+		Status:    incidentActive,
+		StartedAt: time.Now().UTC(),
+	}
+	channel, err := createIncidentChannel(inc)
+	if err == nil {
+		inc.Channel = channel.ID
+		err = store.Put(incidentsBucket, inc.Channel, inc)
+	}
+	incidentsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	publishEvent(busEvent{
+		Type:    busIncidentDeclared,
+		User:    inc.Commander,
+		Channel: inc.Channel,
+		Data:    busIncident{Number: inc.Number, Title: inc.Title, Severity: inc.Severity},
+	})
+
+	topic, _ := truncateText(fmt.Sprintf("%s #%d: %s | Commander: <@%s>", inc.Severity, inc.Number, inc.Title, inc.Commander), maxTopicLength)
+	if _, err := slackClient.SetTopicOfConversation(inc.Channel, topic); err != nil {
+		log.Printf("Error setting incident topic: %v", err)
+	}
+	members := []string{commander}
+	if cfg.OncallGroup != "" {
+		if group, err := findUsergroup(cfg.OncallGroup); err != nil {
+			log.Printf("Error looking up on-call group: %v", err)
+		} else if group == nil {
+			log.Printf("Error inviting on-call group: no usergroup %s", cfg.OncallGroup)
+		} else {
+			members = append(members, group.Users...)
+		}
+	}
+	members = slices.DeleteFunc(slices.Compact(slices.Sorted(slices.Values(members))), func(id string) bool { return id == botUserID })
+	if _, err := slackClient.InviteUsersToConversation(inc.Channel, members...); err != nil {
+		log.Printf("Error inviting people to incident channel: %v", err)
+	}
+
+	text := fmt.Sprintf(":rotating_light: Incident #%d: %s", inc.Number, inc.Title)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, text, true, false)),
+		slack.NewSectionBlock(nil, []*slack.TextBlockObject{
+			slack.NewTextBlockObject(slack.MarkdownType, "*Severity*\n"+inc.Severity, false, false),
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*Commander*\n<@%s>", inc.Commander), false, false),
+			slack.NewTextBlockObject(slack.MarkdownType, "*Started*\n"+slackDate(inc.StartedAt), false, false),
+		}, nil),
+	}
+	if description != "" {
+		description, _ = truncateText(description, maxSectionText)
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, description, false, false), nil, nil))
+	}
+	blocks = append(blocks,
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("Keep updates and decisions in this channel, and react with :%s: to put a message on the timeline. When it's over, run `/incident resolve <summary>` here.", incidentTimelineReaction()), false, false), nil, nil),
+	)
+	if _, err := sendMessage(outboundMessage{Channel: inc.Channel, Importance: importanceCritical, Text: text, Blocks: blocks}); err != nil {
+		log.Printf("Error posting incident kickoff: %v", err)
+	}
+	if cfg.Channel != "" {
+		notice := fmt.Sprintf(":rotating_light: <@%s> opened %s incident #%d: %s. Follow along in <#%s>.", inc.Commander, inc.Severity, inc.Number, inc.Title, inc.Channel)
+		if _, err := sendMessage(outboundMessage{Channel: cfg.Channel, Importance: importanceWarning, Text: notice}); err != nil {
+			log.Printf("Error announcing incident: %v", err)
+		}
+	}
+	return inc, nil
+}
+
+// createIncidentChannel creates a channel like inc-12-checkout-is-down
+func createIncidentChannel(inc *incident) (*slack.Channel, error) {
+	prefix := appConfig.Incidents.ChannelPrefix
+	if prefix == "" {
+		prefix = defaultIncidentChannelPrefix
+	}
+	slug := strings.Trim(channelNameInvalidPattern.ReplaceAllString(strings.ToLower(inc.Title), "-"), "-")
+	name := strings.TrimSuffix(fmt.Sprintf("%s-%d-%s", prefix, inc.Number, slug), "-")
+	if len(name) > maxChannelNameLength {
+		name = strings.TrimRight(name[:maxChannelNameLength], "-")
+	}
+	channel, err := slackClient.CreateConversation(slack.CreateConversationParams{ChannelName: name, IsPrivate: appConfig.Incidents.Private})
+	if err != nil {
+		return nil, fmt.Errorf("creating channel #%s: %w", name, err)
+	}
+	return channel, nil
+}
+
+// handleIncidentResolve resolves the incident whose channel the command
+// was run in and drafts its postmortem
+func handleIncidentResolve(req commandRequest, summary string) commandResponse {
+	inc, err := resolveIncident(req.ChannelID, req.UserID, summary)
+	if errors.Is(err, errNotIncidentChannel) {
+		return ephemeral("Run `/incident resolve` in the channel of an open incident.")
+	}
+	if err != nil {
+		log.Printf("Error resolving incident: %v", err)
+		return ephemeral("Sorry, something went wrong resolving the incident.")
+	}
+	go runJob("postmortem", func() {
+		if err := postPostmortem(inc.Channel); err != nil {
+			log.Printf("Error writing postmortem for incident #%d: %v", inc.Number, err)
+		}
+	})
+	return ephemeral(":white_check_mark: Incident #%d is resolved. I'm drafting its postmortem in this channel.", inc.Number)
+}
+
+var errNotIncidentChannel = errors.New("not the channel of an open incident")
+
+// resolveIncident marks the incident in channel resolved, posts its
+// summary and schedules the channel to be archived
+func resolveIncident(channel, userID, summary string) (*incident, error) {
+	cfg := appConfig.Incidents
+	archiveAfter := cfg.ArchiveAfter
+	if archiveAfter <= 0 {
+		archiveAfter = defaultIncidentArchiveAfter
+	}
+
+	incidentsMu.Lock()
+	var inc incident
+	found, err := store.Get(incidentsBucket, channel, &inc)
+	if err == nil && (!found || inc.Status != incidentActive) {
+		err = errNotIncidentChannel
+	}
+	if err == nil {
+		inc.Status = incidentResolved
+		inc.ResolvedAt = time.Now().UTC()
+		inc.ResolvedBy = userID
+		inc.Summary = summary
+		inc.ArchiveAt = inc.ResolvedAt.Add(archiveAfter)
+		err = store.Put(incidentsBucket, channel, inc)
+	}
+	incidentsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	duration := inc.ResolvedAt.Sub(inc.StartedAt).Round(time.Minute)
+	text := fmt.Sprintf(":white_check_mark: Incident #%d resolved: %s", inc.Number, inc.Title)
+	if summary == "" {
+		summary = "_No summary given._"
+	}
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, text, true, false)),
+		slack.NewSectionBlock(nil, []*slack.TextBlockObject{
+			slack.NewTextBlockObject(slack.MarkdownType, "*Severity*\n"+inc.Severity, false, false),
+			slack.NewTextBlockObject(slack.MarkdownType, "*Duration*\n"+duration.String(), false, false),
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*Commander*\n<@%s>", inc.Commander), false, false),
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*Resolved by*\n<@%s>", inc.ResolvedBy), false, false),
+		}, nil),
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "*Summary*\n"+summary, false, false), nil, nil),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "This channel will be archived "+slackDate(inc.ArchiveAt)+".", false, false)),
+	}
+	if _, err := sendMessage(outboundMessage{Channel: inc.Channel, Importance: importanceNotice, Text: text, Blocks: blocks}); err != nil {
+		log.Printf("Error posting incident summary: %v", err)
+	}
+	if cfg.Channel != "" {
+		notice := fmt.Sprintf(":white_check_mark: %s incident #%d (%s) was resolved after %s. Summary in <#%s>.", inc.Severity, inc.Number, inc.Title, duration, inc.Channel)
+		if _, err := sendMessage(outboundMessage{Channel: cfg.Channel, Importance: importanceNotice, Text: notice}); err != nil {
+			log.Printf("Error announcing resolved incident: %v", err)
+		}
+	}
+	return &inc, nil
+}
+
+func handleIncidentList() commandResponse {
+	incidents, err := storeList[incident](store, incidentsBucket)
+	if err != nil {
+		log.Printf("Error listing incidents: %v", err)
+		return ephemeral("Sorry, something went wrong listing incidents.")
+	}
+	var open []incident
+	for _, inc := range incidents {
+		if inc.Status == incidentActive {
+			open = append(open, inc)
+		}
+	}
+	if len(open) == 0 {
+		return ephemeral("There are no open incidents.")
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].Number < open[j].Number })
+	lines := []string{"*Open incidents*"}
+	for _, inc := range open {
+		lines = append(lines, fmt.Sprintf("• #%d %s %s in <#%s>, started %s by <@%s>", inc.Number, inc.Severity, inc.Title, inc.Channel, slackDate(inc.StartedAt), inc.Commander))
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
+
+// archiveResolvedIncidents archives the channels of incidents resolved
+// long enough ago
+func archiveResolvedIncidents() {
+	incidents, err := storeList[incident](store, incidentsBucket)
+	if err != nil {
+		log.Printf("Error listing incidents: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, inc := range incidents {
+		if inc.Status != incidentResolved || inc.Archived || now.Before(inc.ArchiveAt) {
+			continue
+		}
+		if err := slackClient.ArchiveConversation(inc.Channel); err != nil && err.Error() != "already_archived" {
+			log.Printf("Error archiving incident channel %s: %v", inc.Channel, err)
+			continue
+		}
+		incidentsMu.Lock()
+		var current incident
+		if found, err := store.Get(incidentsBucket, inc.Channel, &current); err == nil && found {
+			current.Archived = true
+			err = store.Put(incidentsBucket, inc.Channel, current)
+		}
+		if err != nil {
+			log.Printf("Error saving incident: %v", err)
+		}
+		incidentsMu.Unlock()
+	}
+}
+
+// slackDate formats t for Slack to show in each reader's own timezone
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s>", t.Unix(), t.UTC().Format(time.RFC1123))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Used when incidents.timeline_reaction isn't set
+const defaultIncidentTimelineReaction = "stopwatch"
+
+// How much of a message /incident timeline shows
+const maxTimelineTextLength = 200
+
+// timelineEntry is a message recorded to an incident's timeline
+type timelineEntry struct {
+	TS string `json:"ts"`
+	// At is when the message was posted
+	At      time.Time `json:"at"`
+	Author  string    `json:"author,omitempty"`
+	Text    string    `json:"text"`
+	AddedBy string    `json:"added_by"`
+}
+
+func incidentTimelineReaction() string {
+	if r := strings.Trim(appConfig.Incidents.TimelineReaction, ":"); r != "" {
+		return r
+	}
+	return defaultIncidentTimelineReaction
+}
+
+// handleTimelineReaction records a message to the timeline of the active
+// incident in its channel when someone reacts with
+// incidents.timeline_reaction
+func handleTimelineReaction(ev *slackevents.ReactionAddedEvent) {
+	if ev.Item.Type != "message" || ev.User == botUserID || ev.Reaction != incidentTimelineReaction() {
+		return
+	}
+	var inc incident
+	found, err := store.Get(incidentsBucket, ev.Item.Channel, &inc)
+	if err != nil {
+		log.Printf("Error reading incident: %v", err)
+		return
+	}
+	if !found || inc.Status != incidentActive {
+		return
+	}
+	msg, err := fetchMessage(ev.Item.Channel, ev.Item.Timestamp)
+	if err != nil {
+		log.Printf("Error recording to incident timeline: %v", err)
+		return
+	}
+	entry := timelineEntry{
+		TS:      msg.Timestamp,
+		At:      slackTSTime(msg.Timestamp),
+		Author:  msg.User,
+		Text:    msg.Text,
+		AddedBy: ev.User,
+	}
+	added, err := addTimelineEntry(ev.Item.Channel, entry)
+	if err != nil {
+		log.Printf("Error recording to incident timeline: %v", err)
+		return
+	}
+	if !added {
+		return
+	}
+	text := fmt.Sprintf(":%s: Added to the timeline of incident #%d. See it with `/incident timeline`.", ev.Reaction, inc.Number)
+	if err := sendEphemeral(ev.User, outboundMessage{Channel: ev.Item.Channel, Text: text}); err != nil {
+		log.Printf("Error confirming timeline entry: %v", err)
+	}
+}
+
+// addTimelineEntry adds entry to the timeline of the active incident in
+// channel, reporting false when the message is already on it
+func addTimelineEntry(channel string, entry timelineEntry) (bool, error) {
+	incidentsMu.Lock()
+	defer incidentsMu.Unlock()
+	var inc incident
+	found, err := store.Get(incidentsBucket, channel, &inc)
+	if err != nil {
+		return false, err
+	}
+	if !found || inc.Status != incidentActive {
+		return false, errNotIncidentChannel
+	}
+	for _, e := range inc.Timeline {
+		if e.TS == entry.TS {
+			return false, nil
+		}
+	}
+	inc.Timeline = append(inc.Timeline, entry)
+	sort.SliceStable(inc.Timeline, func(i, j int) bool { return inc.Timeline[i].At.Before(inc.Timeline[j].At) })
+	return true, store.Put(incidentsBucket, channel, inc)
+}
+
+// handleIncidentTimeline lists the timeline of the incident whose channel
+// the command was run in
+func handleIncidentTimeline(req commandRequest) commandResponse {
+	var inc incident
+	found, err := store.Get(incidentsBucket, req.ChannelID, &inc)
+	if err != nil {
+		log.Printf("Error reading incident: %v", err)
+		return ephemeral("Sorry, something went wrong reading the timeline.")
+	}
+	if !found {
+		return ephemeral("Run `/incident timeline` in an incident's channel.")
+	}
+	lines := []string{
+		fmt.Sprintf("*Timeline of incident #%d: %s*", inc.Number, inc.Title),
+		fmt.Sprintf("• %s: opened by <@%s>", slackDate(inc.StartedAt), inc.Commander),
+	}
+	for _, e := range inc.Timeline {
+		author := "a bot"
+		if e.Author != "" {
+			author = "<@" + e.Author + ">"
+		}
+		text, _ := truncateText(strings.Join(strings.Fields(e.Text), " "), maxTimelineTextLength)
+		lines = append(lines, fmt.Sprintf("• %s: %s: %s", slackDate(e.At), author, text))
+	}
+	if !inc.ResolvedAt.IsZero() {
+		lines = append(lines, fmt.Sprintf("• %s: resolved by <@%s>", slackDate(inc.ResolvedAt), inc.ResolvedBy))
+	}
+	if len(inc.Timeline) == 0 {
+		lines = append(lines, fmt.Sprintf("_Nothing recorded yet. React to a message with :%s: to add it._", incidentTimelineReaction()))
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
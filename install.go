@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Set once the app is uninstalled or its bot token revoked; the token is
+// never valid again, so every later Slack call would only fail
+var slackRevoked atomic.Bool
+
+// errSlackRevoked is returned for Slack API calls made after revocation
+var errSlackRevoked = errors.New("slack token has been revoked; reinstall the app and update SLACK_BOT_TOKEN")
+
+// Store buckets that only make sense while the app is installed, such as
+// pointers to messages the bot posted. They are cleared on uninstall.
+var installationBuckets = []string{channelFeedBucket, pinThreadsBucket, noticeThreadsBucket}
+
+// revocableTransport fails Slack API requests without sending them once the
+// token has been revoked
+type revocableTransport struct {
+	next http.RoundTripper
+}
+
+func (t revocableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if slackRevoked.Load() {
+		return nil, errSlackRevoked
+	}
+	return t.next.RoundTrip(req)
+}
+
+// handleAppUninstalled stops all Slack activity and deletes installation data
+func handleAppUninstalled(*slackevents.AppUninstalledEvent) {
+	revokeSlackAccess("app was uninstalled")
+	for _, bucket := range installationBuckets {
+		if err := store.DeleteBucket(bucket); err != nil {
+			log.Printf("Error deleting %s after uninstall: %v", bucket, err)
+		}
+	}
+}
+
+// handleTokensRevoked stops all Slack activity when the bot token is revoked.
+// Revoked user tokens are ignored; the bot doesn't use any.
+func handleTokensRevoked(ev *slackevents.TokensRevokedEvent) {
+	if slices.Contains(ev.Tokens.Bot, botUserID) {
+		revokeSlackAccess("bot token was revoked")
+	}
+}
+
+func revokeSlackAccess(reason string) {
+	if slackRevoked.Swap(true) {
+		return
+	}
+	log.Printf("Slack access stopped: %s. Background jobs are paused until the app is reinstalled and the bot restarted with a new token.", reason)
+}
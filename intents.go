@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Action IDs of the buttons on an interpreted command
+const (
+	actionIntentRun    = "intent_run"
+	actionIntentCancel = "intent_cancel"
+)
+
+// How long an interpreted command waits for confirmation
+const intentTTL = 15 * time.Minute
+
+// Matches a leading mention of the bot
+var leadingMentionPattern = regexp.MustCompile(`^\s*<@[UW][A-Z0-9]+(?:\|[^>]*)?>[\s:,]*`)
+
+// pendingIntent is an interpreted command waiting for its user to confirm it
+type pendingIntent struct {
+	UserID  string
+	Channel string
+	Line    string
+	expires time.Time
+}
+
+var (
+	pendingIntentsMu sync.Mutex
+	pendingIntents   = map[string]*pendingIntent{}
+	// Compiled intent rule patterns, keyed by pattern
+	intentPatterns sync.Map
+)
+
+func init() {
+	registerBlockAction(actionIntentRun, handleIntentDecision)
+	registerBlockAction(actionIntentCancel, handleIntentDecision)
+}
+
+// handleDirectMessage treats a DM to the bot as a command
+func handleDirectMessage(ev *slackevents.MessageEvent) {
+	if ev.User == "" || ev.User == botUserID || ev.BotID != "" {
+		return
+	}
+	reply := func(resp commandResponse, ok bool) {
+		if !ok {
+			resp = commandResponse{Text: fmt.Sprintf("Sorry, I didn't catch that. Try `%s` to see what I can do.", botCommand)}
+		}
+		if _, err := sendMessage(outboundMessage{Channel: ev.Channel, ThreadTS: ev.ThreadTimeStamp, Text: resp.Text, Blocks: resp.Blocks}); err != nil {
+			log.Printf("Error replying to DM: %v", err)
+		}
+	}
+	resp, ok := commandFromText(ev.User, ev.Channel, ev.ThreadTimeStamp, ev.Text)
+	if !ok && wantsIntentLLM(ev.Channel, ev.Text) {
+		// The language model is slow, and the event has to be acked first
+		go runJob("intent", func() { reply(intentFromLLM(ev.User, ev.Channel, ev.Text)) })
+		return
+	}
+	reply(resp, ok)
+}
+
+// commandFromText runs text, sent in threadTS, as a /bot command when it
+// starts with one, or
+// answers it from the FAQ. Otherwise, with intents enabled, it works out
+// from the intent rules which command was meant and returns a message
+// offering to run it. It reports false if none of them worked; the
+// language model is left to intentFromLLM, since it's too slow to wait
+// for before acking an event.
+func commandFromText(userID, channel, threadTS, text string) (commandResponse, bool) {
+	text = intentText(text)
+	slash := slack.SlashCommand{Command: botCommand, Text: text, UserID: userID, ChannelID: channel}
+	if cmd, _ := matchBotCommand(strings.Fields(text)); cmd != nil {
+		return dispatchBotCommand(slash, threadTS, strings.Fields(text)), true
+	}
+	if answer, ok := faqAnswer(text); ok {
+		return answer, true
+	}
+	if !appConfig.Intents.Enabled || text == "" {
+		return commandResponse{}, false
+	}
+	line, source := interpretIntent(text)
+	if line == "" {
+		return commandResponse{}, false
+	}
+	return offerIntent(userID, channel, line, source), true
+}
+
+// wantsIntentLLM reports whether text in channel that commandFromText had
+// no answer for should go to intentFromLLM. AI replies being off in the
+// channel rules the language model out here too.
+func wantsIntentLLM(channel, text string) bool {
+	return appConfig.Intents.Enabled && appConfig.Intents.LLM && intentText(text) != "" && featureEnabled(featureAIReplies, channel)
+}
+
+// intentFromLLM asks the language model which command text meant, and
+// returns a message offering to run it. It reports false if none fits.
+func intentFromLLM(userID, channel, text string) (commandResponse, bool) {
+	line, err := classifyIntentWithLLM(intentText(text))
+	if err != nil {
+		log.Printf("Error classifying intent: %v", err)
+		return commandResponse{}, false
+	}
+	if line == "" || !knownCommandLine(line) {
+		return commandResponse{}, false
+	}
+	return offerIntent(userID, channel, line, "the language model"), true
+}
+
+// setupIntents registers the intent_llm shadow handler when
+// intents.shadow_llm asks for it
+func setupIntents(cfg IntentsConfig) {
+	if cfg.Enabled && !cfg.LLM && cfg.ShadowLLM {
+		registerShadowResponder("app_mention", "intent_llm", shadowIntentLLM)
+	}
+}
+
+// shadowIntentLLM answers mentions as mentionResponder would with
+// intents.llm on, so admin shadow shows what turning it on would change.
+// Mentions the language model wouldn't have been asked about, or found no
+// command for, are answered as live without working them out again, since
+// that would run their commands twice.
+func shadowIntentLLM(event any, out *eventReplies) {
+	ev := event.(*slackevents.AppMentionEvent)
+	text := intentText(ev.Text)
+	if !featureEnabled(featureAIReplies, ev.Channel) || text == "" || isSummaryRequest(ev.Text) {
+		out.answerAsLive()
+		return
+	}
+	if cmd, _ := matchBotCommand(strings.Fields(text)); cmd != nil || matchesTrigger(ev.Channel, ev.Text) {
+		out.answerAsLive()
+		return
+	}
+	if _, ok := faqAnswer(text); ok {
+		out.answerAsLive()
+		return
+	}
+	if line, _ := interpretIntent(text); line != "" {
+		out.answerAsLive()
+		return
+	}
+	line, err := classifyIntentWithLLM(text)
+	if err != nil {
+		log.Printf("Error classifying intent in shadow mode: %v", err)
+	}
+	if err != nil || line == "" || !knownCommandLine(line) {
+		out.answerAsLive()
+		return
+	}
+	resp := intentOffer(line, "the language model", "shadow")
+	reply := replyTo(ev.Channel, ev.TimeStamp, ev.ThreadTimeStamp)
+	reply.Text, reply.Blocks = resp.Text, resp.Blocks
+	if err := out.postEphemeral(ev.User, reply); err != nil {
+		log.Printf("Error recording shadow intent: %v", err)
+	}
+	out.done()
+}
+
+// intentText strips a leading mention and /bot from text
+func intentText(text string) string {
+	return strings.TrimSpace(strings.TrimPrefix(leadingMentionPattern.ReplaceAllString(text, ""), botCommand+" "))
+}
+
+// offerIntent returns a message offering userID to run line
+func offerIntent(userID, channel, line, source string) commandResponse {
+	token := newInteractionToken()
+	pendingIntentsMu.Lock()
+	now := time.Now()
+	for key, p := range pendingIntents {
+		if now.After(p.expires) {
+			delete(pendingIntents, key)
+		}
+	}
+	pendingIntents[token] = &pendingIntent{UserID: userID, Channel: channel, Line: line, expires: now.Add(intentTTL)}
+	pendingIntentsMu.Unlock()
+	return intentOffer(line, source, token)
+}
+
+// intentOffer is the message offering to run line, its buttons carrying
+// token
+func intentOffer(line, source, token string) commandResponse {
+	prompt := fmt.Sprintf(":thinking_face: Did you mean `%s`?", line)
+	return commandResponse{
+		Text: prompt,
+		Blocks: []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, prompt, false, false), nil, nil),
+			slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "Worked out by "+source+". Nothing runs until you say so.", false, false)),
+			slack.NewActionBlock("",
+				slack.NewButtonBlockElement(actionIntentRun, token, slack.NewTextBlockObject(slack.PlainTextType, "Run it", false, false)).WithStyle(slack.StylePrimary),
+				slack.NewButtonBlockElement(actionIntentCancel, token, slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false)),
+			),
+		},
+	}
+}
+
+// interpretIntent maps text to a command line with the configured rules.
+// source says which one answered.
+func interpretIntent(text string) (line, source string) {
+	for _, rule := range appConfig.Intents.Rules {
+		re, err := rule.pattern()
+		if err != nil {
+			log.Printf("Error matching intent rule: %v", err)
+			continue
+		}
+		m := re.FindStringSubmatchIndex(text)
+		if m == nil {
+			continue
+		}
+		line := string(re.ExpandString(nil, rule.Command, text, m))
+		if knownCommandLine(line) {
+			return line, "a rule"
+		}
+		log.Printf("Intent rule %q produced an unknown command %q", rule.Pattern, line)
+	}
+	return "", ""
+}
+
+func (r IntentRule) pattern() (*regexp.Regexp, error) {
+	if re, ok := intentPatterns.Load(r.Pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("intent rule %q: %w", r.Pattern, err)
+	}
+	intentPatterns.Store(r.Pattern, re)
+	return re, nil
+}
+
+// knownCommandLine reports whether line starts with a registered command
+func knownCommandLine(line string) bool {
+	name, text, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if name == botCommand {
+		cmd, _ := matchBotCommand(strings.Fields(text))
+		return cmd != nil
+	}
+	_, ok := slashCommands[name]
+	return ok
+}
+
+// classifyIntentWithLLM asks the language model which command text means,
+// returning "" when none fits
+func classifyIntentWithLLM(text string) (string, error) {
+	var commands []string
+	for _, cmd := range botCommands {
+		if !cmd.AdminOnly {
+			commands = append(commands, fmt.Sprintf("%s %s: %s", botCommand, cmd.Usage, cmd.Description))
+		}
+	}
+	for _, cmd := range slashCommands {
+		commands = append(commands, fmt.Sprintf("%s: %s", cmd.Usage, cmd.Description))
+	}
+	sort.Strings(commands)
+
+	system := "You turn requests to a Slack bot into one of its commands. The commands are:\n" +
+		strings.Join(commands, "\n") +
+		"\n\nKeep Slack mentions such as <@U123> and <#C123> exactly as written. " +
+		`Answer with JSON only: {"command": "<the full command line>"}, or {"command": ""} if no command fits.`
+	reply, err := llmChat([]llmMessage{{Role: "system", Content: system}, {Role: "user", Content: text}})
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(reply, "```json"), "```"), "```")
+	var out struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(reply)), &out); err != nil {
+		return "", fmt.Errorf("language model answered %q: %w", reply, err)
+	}
+	return strings.TrimSpace(out.Command), nil
+}
+
+// handleIntentDecision runs or drops an interpreted command once the person
+// it was offered to decides
+func handleIntentDecision(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	pendingIntentsMu.Lock()
+	p, ok := pendingIntents[action.Value]
+	if ok && p.UserID == callback.User.ID {
+		delete(pendingIntents, action.Value)
+	}
+	pendingIntentsMu.Unlock()
+
+	var text string
+	switch {
+	case !ok || time.Now().After(p.expires):
+		text = "This suggestion has expired. Ask me again."
+	case p.UserID != callback.User.ID:
+		pollReply(callback, "Only the person who asked can run this.")
+		return
+	case action.ActionID == actionIntentCancel:
+		text = fmt.Sprintf("OK, I won't run `%s`.", p.Line)
+	default:
+		resp, _ := runCommandLine(slack.SlashCommand{UserID: p.UserID, ChannelID: p.Channel}, p.Line)
+		text = fmt.Sprintf("Ran `%s`", p.Line)
+		if resp.Text != "" {
+			text += "\n" + resp.Text
+		}
+	}
+	if err := respond(callback.ResponseURL, &slack.WebhookMessage{ReplaceOriginal: true, Text: text}); err != nil {
+		log.Printf("Error updating interpreted command: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// blockActionHandler handles a single interactive element being used
+type blockActionHandler func(callback *slack.InteractionCallback, action *slack.BlockAction)
+
+// Handlers for block_actions payloads, keyed by action_id
+var blockActionHandlers = map[string]blockActionHandler{}
+
+// registerBlockAction routes block actions with actionID to handler
+func registerBlockAction(actionID string, handler blockActionHandler) {
+	blockActionHandlers[actionID] = handler
+}
+
+// viewSubmissionHandler handles a modal being submitted. A non-nil response,
+// e.g. field errors, is sent back to Slack; nil closes the modal.
+type viewSubmissionHandler func(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse
+
+// Handlers for view_submission payloads, keyed by the view's callback_id
+var viewSubmissionHandlers = map[string]viewSubmissionHandler{}
+
+// registerViewSubmission routes submissions of modals with callbackID to handler
+func registerViewSubmission(callbackID string, handler viewSubmissionHandler) {
+	viewSubmissionHandlers[callbackID] = handler
+}
+
+// viewClosedHandler handles a modal being cancelled
+type viewClosedHandler func(callback *slack.InteractionCallback)
+
+// Handlers for view_closed payloads, keyed by the view's callback_id. Slack
+// only sends them for views opened with notify_on_close.
+var viewClosedHandlers = map[string]viewClosedHandler{}
+
+// registerViewClosed routes cancellations of modals with callbackID to handler
+func registerViewClosed(callbackID string, handler viewClosedHandler) {
+	viewClosedHandlers[callbackID] = handler
+}
+
+// handleSlackInteractions receives button clicks and other interactive payloads
+func handleSlackInteractions(c *gin.Context) {
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(c.PostForm("payload")), &callback); err != nil {
+		log.Printf("Error parsing Slack interaction: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction payload"})
+		return
+	}
+
+	switch callback.Type {
+	case slack.InteractionTypeBlockActions:
+		handleExperimentClick(&callback)
+		for _, action := range callback.ActionCallback.BlockActions {
+			handler, ok := blockActionHandlers[action.ActionID]
+			if !ok {
+				// Link buttons also send block_actions; there is nothing to do for them
+				continue
+			}
+			recordActionUsage(action.ActionID, callback.User.ID)
+			handler(&callback, action)
+		}
+	case slack.InteractionTypeMessageAction:
+		handler, ok := messageShortcutHandlers[callback.CallbackID]
+		if !ok {
+			log.Printf("Unknown message shortcut: %s", callback.CallbackID)
+			break
+		}
+		handler(&callback)
+	case slack.InteractionTypeShortcut:
+		handler, ok := globalShortcutHandlers[callback.CallbackID]
+		if !ok {
+			log.Printf("Unknown global shortcut: %s", callback.CallbackID)
+			break
+		}
+		handler(&callback)
+	case slack.InteractionTypeViewSubmission:
+		handler, ok := viewSubmissionHandlers[callback.View.CallbackID]
+		if !ok {
+			log.Printf("Unknown view submission: %s", callback.View.CallbackID)
+			break
+		}
+		if resp := handler(&callback); resp != nil {
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+	case slack.InteractionTypeViewClosed:
+		if handler, ok := viewClosedHandlers[callback.View.CallbackID]; ok {
+			handler(&callback)
+		}
+	default:
+		log.Printf("Unsupported interaction type: %s", callback.Type)
+	}
+
+	// Acknowledge receipt of the interaction
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// Store bucket mapping Jira issues filed from Slack back to their thread,
+// keyed by issue key
+const jiraIssuesBucket = "jira_issues"
+
+// Callback and block IDs of the Jira ticket modal
+const (
+	jiraCallbackID       = "jira_create"
+	jiraProjectBlock     = "jira_project"
+	jiraIssueTypeBlock   = "jira_issue_type"
+	jiraSummaryBlock     = "jira_summary"
+	jiraDescriptionBlock = "jira_description"
+)
+
+// Used when the jira settings aren't set
+const (
+	defaultJiraIssueType = "Task"
+	defaultJiraTokenEnv  = "JIRA_API_TOKEN"
+	defaultJiraSecretEnv = "JIRA_WEBHOOK_SECRET"
+)
+
+// Longest summary Jira accepts
+const maxJiraSummary = 255
+
+// jiraIssue is a ticket filed from Slack and the thread its updates go to
+type jiraIssue struct {
+	Key       string    `json:"key"`
+	Summary   string    `json:"summary"`
+	Status    string    `json:"status,omitempty"`
+	Channel   string    `json:"channel"`
+	ThreadTS  string    `json:"thread_ts"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/jira",
+		Usage:       "/jira create [summary]",
+		Description: "File a Jira ticket; status changes are posted back in its thread",
+		Handler:     handleJiraCommand,
+	})
+	registerMessageShortcut("create_jira_ticket", handleJiraShortcut)
+	registerViewSubmission(jiraCallbackID, handleJiraSubmission)
+	registerWorkflowStep("create_ticket", runCreateTicketStep)
+	registerUserDataset(userDataset{Name: "jira", Title: "Jira tickets filed", Describe: describeUserJiraIssues, Delete: deleteUserJiraIssues})
+}
+
+func jiraConfigured() bool {
+	cfg := appConfig.Jira
+	return cfg.URL != "" && cfg.Email != "" && cfg.Project != ""
+}
+
+// jiraIssueURL links to an issue in the Jira web UI
+func jiraIssueURL(key string) string {
+	return strings.TrimSuffix(appConfig.Jira.URL, "/") + "/browse/" + key
+}
+
+func handleJiraCommand(req commandRequest) commandResponse {
+	if len(req.Args) == 0 || strings.ToLower(req.Args[0]) != "create" {
+		return ephemeral("Usage: `%s create [summary]`", req.Command)
+	}
+	if !jiraConfigured() {
+		return ephemeral("Jira isn't set up. See `jira` in the config.")
+	}
+	summary := strings.Join(req.Args[1:], " ")
+	if err := openJiraModal(req.TriggerID, req.ChannelID, "", summary, ""); err != nil {
+		log.Printf("Error opening Jira modal: %v", err)
+		return ephemeral("Sorry, something went wrong opening the form.")
+	}
+	return ephemeral("Opening the ticket form…")
+}
+
+// handleJiraShortcut opens the ticket form filled in from a message, and
+// files the ticket into the message's thread
+func handleJiraShortcut(callback *slack.InteractionCallback) {
+	if !jiraConfigured() {
+		shortcutReply(callback, "Jira isn't set up. See `jira` in the config.")
+		return
+	}
+	msg := callback.Message
+	threadTS := msg.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = msg.Timestamp
+	}
+	text := resolveMarkup(msg.Text, map[string]string{})
+	summary, _ := truncateText(strings.Join(strings.Fields(text), " "), 80)
+	description := text
+	if link, err := slackClient.GetPermalink(&slack.PermalinkParameters{Channel: callback.Channel.ID, Ts: msg.Timestamp}); err != nil {
+		log.Printf("Error linking message for Jira: %v", err)
+	} else {
+		description += "\n\nFrom Slack: " + link
+	}
+	if err := openJiraModal(callback.TriggerID, callback.Channel.ID, threadTS, summary, description); err != nil {
+		log.Printf("Error opening Jira modal: %v", err)
+		shortcutReply(callback, "Sorry, something went wrong opening the form.")
+	}
+}
+
+// openJiraModal asks for the ticket's details. The ticket is announced in
+// threadTS, or in a new message in channel when it's empty.
+func openJiraModal(triggerID, channel, threadTS, summary, description string) error {
+	input := func(blockID, label, value string, multiline bool) slack.Block {
+		element := slack.NewPlainTextInputBlockElement(nil, blockID)
+		element.Multiline = multiline
+		element.InitialValue = value
+		if !multiline {
+			element.MaxLength = maxJiraSummary
+		}
+		return slack.NewInputBlock(blockID, slack.NewTextBlockObject(slack.PlainTextType, label, false, false), nil, element).WithOptional(multiline)
+	}
+	issueType := appConfig.Jira.IssueType
+	if issueType == "" {
+		issueType = defaultJiraIssueType
+	}
+	view := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      jiraCallbackID,
+		PrivateMetadata: channel + "|" + threadTS,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "New Jira ticket", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Create", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			input(jiraProjectBlock, "Project key", appConfig.Jira.Project, false),
+			input(jiraIssueTypeBlock, "Issue type", issueType, false),
+			input(jiraSummaryBlock, "Summary", summary, false),
+			input(jiraDescriptionBlock, "Description", description, true),
+		}},
+	}
+	_, err := slackClient.OpenView(triggerID, view)
+	return err
+}
+
+// handleJiraSubmission checks the form and files the ticket in the
+// background, since Jira can take longer than Slack waits for the modal
+func handleJiraSubmission(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	values := callback.View.State.Values
+	field := func(blockID string) string { return strings.TrimSpace(values[blockID][blockID].Value) }
+	project, issueType, summary := strings.ToUpper(field(jiraProjectBlock)), field(jiraIssueTypeBlock), field(jiraSummaryBlock)
+	errs := map[string]string{}
+	if project == "" {
+		errs[jiraProjectBlock] = "Enter a project key, like OPS."
+	}
+	if issueType == "" {
+		errs[jiraIssueTypeBlock] = "Enter an issue type, like Task."
+	}
+	if summary == "" {
+		errs[jiraSummaryBlock] = "Give the ticket a summary."
+	}
+	if len(errs) > 0 {
+		return slack.NewErrorsViewSubmissionResponse(errs)
+	}
+	channel, threadTS, _ := strings.Cut(callback.View.PrivateMetadata, "|")
+	issue := &jiraIssue{Summary: summary, Channel: channel, ThreadTS: threadTS, CreatedBy: callback.User.ID, CreatedAt: time.Now().UTC()}
+	description := field(jiraDescriptionBlock)
+	go runJob("jira", func() { fileJiraIssue(issue, project, issueType, description) })
+	return nil
+}
+
+// fileJiraIssue creates the ticket and announces it in Slack. The filer
+// hears by DM when Jira turns it down.
+func fileJiraIssue(issue *jiraIssue, project, issueType, description string) {
+	key, err := createJiraIssue(project, issueType, issue.Summary, description)
+	if err != nil {
+		log.Printf("Error creating Jira issue: %v", err)
+		text := fmt.Sprintf("Sorry, Jira didn't accept your ticket *%s*. Check the project key (%s) and issue type (%s), then try again.", issue.Summary, project, issueType)
+		if _, err := sendDM("jira", issue.CreatedBy, outboundMessage{Text: text}); err != nil {
+			log.Printf("Error reporting Jira failure: %v", err)
+		}
+		return
+	}
+	issue.Key = key
+	text := fmt.Sprintf(":ticket: <@%s> filed <%s|%s>: %s", issue.CreatedBy, jiraIssueURL(key), key, issue.Summary)
+	ts, err := sendMessage(outboundMessage{Channel: issue.Channel, ThreadTS: issue.ThreadTS, Importance: importanceInfo, NeedTS: true, Text: text})
+	if err != nil {
+		// The bot isn't in every channel; the filer still needs the link
+		log.Printf("Error announcing Jira issue %s: %v", key, err)
+		if _, err := sendDM("jira", issue.CreatedBy, outboundMessage{Text: text}); err != nil {
+			log.Printf("Error sending Jira issue link: %v", err)
+		}
+		return
+	}
+	if issue.ThreadTS == "" {
+		issue.ThreadTS = ts
+	}
+	if err := store.Put(jiraIssuesBucket, key, issue); err != nil {
+		log.Printf("Error saving Jira issue %s: %v", key, err)
+	}
+}
+
+// jiraRequest calls Jira's REST API as jira.email and decodes the JSON
+// answer into out, if given
+func jiraRequest(method, path string, body, out any) error {
+	cfg := appConfig.Jira
+	env := cfg.TokenEnv
+	if env == "" {
+		env = defaultJiraTokenEnv
+	}
+	token := os.Getenv(env)
+	if token == "" {
+		return fmt.Errorf("%s is not set", env)
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(cfg.URL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(cfg.Email, token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// createJiraIssue files an issue and returns its key. Version 2 of the API
+// is used since it takes the description as plain text.
+func createJiraIssue(project, issueType, summary, description string) (string, error) {
+	fields := map[string]any{
+		"project":   map[string]string{"key": project},
+		"issuetype": map[string]string{"name": issueType},
+		"summary":   summary,
+	}
+	if description != "" {
+		fields["description"] = description
+	}
+	var out struct {
+		Key string `json:"key"`
+	}
+	if err := jiraRequest(http.MethodPost, "/rest/api/2/issue", map[string]any{"fields": fields}, &out); err != nil {
+		return "", err
+	}
+	return out.Key, nil
+}
+
+// runCreateTicketStep files a Jira issue from a workflow. Inputs: summary,
+// optional description, optional project and issue_type (defaulting to
+// jira.project and jira.issue_type) and optional channel_id to announce
+// the ticket in. Outputs: issue_key and issue_url.
+func runCreateTicketStep(inputs map[string]any) (map[string]string, error) {
+	if !jiraConfigured() {
+		return nil, fmt.Errorf("jira isn't set up")
+	}
+	input := func(name string) string {
+		value, _ := inputs[name].(string)
+		return strings.TrimSpace(value)
+	}
+	summary := input("summary")
+	if summary == "" {
+		return nil, fmt.Errorf("summary is required")
+	}
+	project, issueType := strings.ToUpper(input("project")), input("issue_type")
+	if project == "" {
+		project = appConfig.Jira.Project
+	}
+	if issueType == "" {
+		issueType = appConfig.Jira.IssueType
+	}
+	if issueType == "" {
+		issueType = defaultJiraIssueType
+	}
+	key, err := createJiraIssue(project, issueType, summary, input("description"))
+	if err != nil {
+		return nil, fmt.Errorf("creating jira issue: %w", err)
+	}
+	if channel := input("channel_id"); channel != "" {
+		text := fmt.Sprintf(":ticket: A workflow filed <%s|%s>: %s", jiraIssueURL(key), key, summary)
+		if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceInfo, Text: text}); err != nil {
+			log.Printf("Error announcing Jira issue %s: %v", key, err)
+		}
+	}
+	return map[string]string{"issue_key": key, "issue_url": jiraIssueURL(key)}, nil
+}
+
+// jiraWebhookEvent is the part of a Jira issue webhook the bot reads
+type jiraWebhookEvent struct {
+	WebhookEvent string `json:"webhookEvent"`
+	User         struct {
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Issue struct {
+		Key string `json:"key"`
+	} `json:"issue"`
+	Changelog struct {
+		Items []struct {
+			Field      string `json:"field"`
+			FromString string `json:"fromString"`
+			ToString   string `json:"toString"`
+		} `json:"items"`
+	} `json:"changelog"`
+}
+
+// handleJiraWebhook posts status changes of tickets filed from Slack into
+// their thread. Jira signs the body with the webhook's secret.
+func handleJiraWebhook(c *gin.Context) {
+	env := appConfig.Jira.WebhookSecretEnv
+	if env == "" {
+		env = defaultJiraSecretEnv
+	}
+	secret := os.Getenv(env)
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unreadable body"})
+		return
+	}
+	signature, _ := strings.CutPrefix(c.GetHeader("X-Hub-Signature"), "sha256=")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	got, err := hex.DecodeString(signature)
+	if secret == "" || err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	var ev jiraWebhookEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	issue := &jiraIssue{}
+	found, err := store.Get(jiraIssuesBucket, ev.Issue.Key, issue)
+	if err != nil {
+		log.Printf("Error loading Jira issue %s: %v", ev.Issue.Key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Store unavailable"})
+		return
+	}
+	if !found {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	switch ev.WebhookEvent {
+	case "jira:issue_deleted":
+		if err := store.Delete(jiraIssuesBucket, issue.Key); err != nil {
+			log.Printf("Error removing Jira issue %s: %v", issue.Key, err)
+		}
+		postJiraUpdate(issue, fmt.Sprintf(":wastebasket: %s was deleted in Jira.", issue.Key))
+	case "jira:issue_updated":
+		for _, item := range ev.Changelog.Items {
+			if item.Field != "status" {
+				continue
+			}
+			by := ""
+			if ev.User.DisplayName != "" {
+				by = " by " + ev.User.DisplayName
+			}
+			issue.Status = item.ToString
+			postJiraUpdate(issue, fmt.Sprintf(":arrows_counterclockwise: <%s|%s> moved from *%s* to *%s*%s.", jiraIssueURL(issue.Key), issue.Key, item.FromString, item.ToString, by))
+			if err := store.Put(jiraIssuesBucket, issue.Key, issue); err != nil {
+				log.Printf("Error saving Jira issue %s: %v", issue.Key, err)
+			}
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func postJiraUpdate(issue *jiraIssue, text string) {
+	if _, err := sendMessage(outboundMessage{Channel: issue.Channel, ThreadTS: issue.ThreadTS, Importance: importanceInfo, Text: text}); err != nil {
+		log.Printf("Error posting Jira update for %s: %v", issue.Key, err)
+	}
+}
+
+func describeUserJiraIssues(userID string) (string, error) {
+	issues, err := storeList[jiraIssue](store, jiraIssuesBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, issue := range issues {
+		if issue.CreatedBy == userID {
+			items = append(items, fmt.Sprintf("%s: %s (%s)", issue.Key, issue.Summary, issue.CreatedAt.Format("2 Jan 2006")))
+		}
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserJiraIssues stops threading updates back for the user's
+// tickets; the tickets themselves live in Jira
+func deleteUserJiraIssues(userID string) error {
+	issues, err := storeList[jiraIssue](store, jiraIssuesBucket)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		if issue.CreatedBy == userID {
+			if err := store.Delete(jiraIssuesBucket, issue.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// runEvery calls fn on its own goroutine every interval until the process
+// exits. A panic in fn is logged and does not stop later runs. Runs are
+// skipped once the Slack token has been revoked, and while this instance
+// is on standby.
+func runEvery(name string, interval time.Duration, fn func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if slackRevoked.Load() {
+				continue
+			}
+			leaderMu.RLock()
+			if isLeader() {
+				runJob(name, fn)
+			}
+			leaderMu.RUnlock()
+		}
+	}()
+}
+
+func runJob(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", name, r)
+		}
+	}()
+	fn()
+}
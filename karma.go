@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store buckets for karma scores, keyed by user ID, and for channels that
+// opted out of karma, keyed by channel ID
+const (
+	karmaBucket       = "karma"
+	karmaOptOutBucket = "karma_optout"
+)
+
+// Most karma changes counted from one message
+const maxKarmaPerMessage = 5
+
+// How many people the leaderboard shows
+const karmaLeaderboardSize = 10
+
+// Matches <@U123>++ or <@U123> -- in message text
+var karmaPattern = regexp.MustCompile(`<@([UW][A-Z0-9]+)(?:\|[^>]*)?>\s?(\+\+|--)`)
+
+// karmaScore is a user's karma, as listed on the leaderboard
+type karmaScore struct {
+	User  string
+	Karma int
+}
+
+// Serializes score updates
+var karmaMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/karma",
+		Usage:       "/karma [@user|top|off|on]",
+		Description: "Show karma, the leaderboard, or turn karma off or on in this channel",
+		Handler:     handleKarmaCommand,
+	})
+	registerUserDataset(userDataset{Name: "karma", Title: "Karma", Describe: describeUserKarma, Delete: deleteUserKarma})
+}
+
+// handleKarmaMessage applies the @user++ and @user-- in a message
+func handleKarmaMessage(ev *slackevents.MessageEvent) {
+	if ev.User == "" || ev.BotID != "" || !strings.Contains(ev.Text, "<@") {
+		return
+	}
+	matches := karmaPattern.FindAllStringSubmatch(ev.Text, -1)
+	if len(matches) == 0 || karmaOptedOut(ev.Channel) || !featureEnabled(featureKarma, ev.Channel) {
+		return
+	}
+
+	var lines []string
+	seen := map[string]bool{}
+	for _, m := range matches {
+		userID, op := m[1], m[2]
+		if seen[userID] || len(seen) == maxKarmaPerMessage {
+			continue
+		}
+		seen[userID] = true
+		if userID == ev.User {
+			if op == "++" {
+				lines = append(lines, fmt.Sprintf("Nice try, <@%s>. You can't give yourself karma.", userID))
+			}
+			continue
+		}
+		delta := 1
+		if op == "--" {
+			delta = -1
+		}
+		score, err := addKarma(userID, delta)
+		if err != nil {
+			log.Printf("Error updating karma: %v", err)
+			return
+		}
+		lines = append(lines, fmt.Sprintf("<@%s> now has %d karma", userID, score))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	reply := replyTo(ev.Channel, ev.TimeStamp, ev.ThreadTimeStamp)
+	reply.Text = strings.Join(lines, "\n")
+	if _, err := sendMessage(reply); err != nil {
+		log.Printf("Error replying with karma: %v", err)
+	}
+}
+
+func addKarma(userID string, delta int) (int, error) {
+	karmaMu.Lock()
+	defer karmaMu.Unlock()
+	var score int
+	if _, err := store.Get(karmaBucket, userID, &score); err != nil {
+		return 0, err
+	}
+	score += delta
+	return score, store.Put(karmaBucket, userID, score)
+}
+
+func karmaOptedOut(channel string) bool {
+	var off bool
+	if _, err := store.Get(karmaOptOutBucket, channel, &off); err != nil {
+		log.Printf("Error checking karma opt-out: %v", err)
+		return true
+	}
+	return off
+}
+
+func handleKarmaCommand(req commandRequest) commandResponse {
+	arg := ""
+	if len(req.Args) > 0 {
+		arg = strings.ToLower(req.Args[0])
+	}
+	switch arg {
+	case "top":
+		return karmaLeaderboard()
+	case "off", "on":
+		return setKarmaOptOut(req, arg == "off")
+	}
+
+	userID := req.UserID
+	if arg != "" {
+		m := userMentionPattern.FindStringSubmatch(req.Args[0])
+		if m == nil {
+			return ephemeral("Usage: `%s [@user|top|off|on]`", req.Command)
+		}
+		userID = m[1]
+	}
+	var score int
+	if _, err := store.Get(karmaBucket, userID, &score); err != nil {
+		log.Printf("Error loading karma: %v", err)
+		return ephemeral("Sorry, something went wrong loading the karma.")
+	}
+	if userID == req.UserID {
+		return ephemeral("You have %d karma.", score)
+	}
+	return ephemeral("<@%s> has %d karma.", userID, score)
+}
+
+func karmaLeaderboard() commandResponse {
+	var scores []karmaScore
+	for _, userID := range store.Keys(karmaBucket) {
+		var score int
+		if _, err := store.Get(karmaBucket, userID, &score); err != nil {
+			log.Printf("Error loading karma: %v", err)
+			return ephemeral("Sorry, something went wrong loading the leaderboard.")
+		}
+		scores = append(scores, karmaScore{User: userID, Karma: score})
+	}
+	if len(scores) == 0 {
+		return ephemeral("Nobody has any karma yet. Give some with @someone++")
+	}
+	slices.SortStableFunc(scores, func(a, b karmaScore) int { return b.Karma - a.Karma })
+	var lines []string
+	for i, s := range scores[:min(len(scores), karmaLeaderboardSize)] {
+		lines = append(lines, fmt.Sprintf("%d. <@%s> %d", i+1, s.User, s.Karma))
+	}
+	return commandResponse{Text: ":trophy: *Karma leaderboard*\n" + strings.Join(lines, "\n"), InChannel: true}
+}
+
+// setKarmaOptOut turns karma off or on in the channel; an admin setting
+func setKarmaOptOut(req commandRequest, off bool) commandResponse {
+	if !isAdmin(req.UserID) {
+		return ephemeral("Sorry, only bot admins can turn karma off or on.")
+	}
+	var err error
+	if off {
+		err = store.Put(karmaOptOutBucket, req.ChannelID, true)
+	} else {
+		err = store.Delete(karmaOptOutBucket, req.ChannelID)
+	}
+	if err != nil {
+		log.Printf("Error saving karma opt-out: %v", err)
+		return ephemeral("Sorry, something went wrong changing the karma setting.")
+	}
+	if off {
+		return ephemeral("Karma is off in this channel; ++ and -- are ignored here.")
+	}
+	return ephemeral("Karma is on in this channel.")
+}
+
+func describeUserKarma(userID string) (string, error) {
+	var score int
+	found, err := store.Get(karmaBucket, userID, &score)
+	if err != nil || !found {
+		return "", err
+	}
+	return fmt.Sprintf("Your score is %d.", score), nil
+}
+
+func deleteUserKarma(userID string) error {
+	karmaMu.Lock()
+	defer karmaMu.Unlock()
+	return store.Delete(karmaBucket, userID)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store bucket for kill switches, keyed by feature for the whole workspace
+// or feature/channel for one channel
+const killSwitchesBucket = "kill_switches"
+
+// Features admins can switch off
+const (
+	featureAIReplies = "ai_replies"
+	featureTriggers  = "triggers"
+	featureKarma     = "karma"
+	featureTranslate = "translate"
+	featureUnfurl    = "unfurl"
+	featureGreetings = "greetings"
+	featureEventBus  = "event_bus"
+)
+
+// What each feature that can be switched off does
+var killSwitchFeatures = map[string]string{
+	featureAIReplies: "Language model answers to mentions and tl;dr, thread summaries and /ask",
+	featureTriggers:  "Keyword and regex trigger responses",
+	featureKarma:     "@user++ and @user--",
+	featureTranslate: "Translations on flag reactions",
+	featureUnfurl:    "Link previews",
+	featureGreetings: "Greetings for people joining channels",
+	featureEventBus:  "Notifications relayed from the event bus",
+}
+
+// killSwitch turns a feature off until an admin turns it back on or Until
+// passes
+type killSwitch struct {
+	Feature string `json:"feature"`
+	// Channel is empty when the feature is off in the whole workspace
+	Channel string    `json:"channel,omitempty"`
+	By      string    `json:"by"`
+	At      time.Time `json:"at"`
+	// Until is zero when the switch stays off until turned back on
+	Until time.Time `json:"until,omitempty"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin disable",
+		Usage:       "admin disable <feature> [#channel|workspace] [duration]",
+		Description: "Switch a feature off now, everywhere or in one channel, for a while or until it's enabled again",
+		AdminOnly:   true,
+		Handler:     handleDisableFeature,
+	})
+	registerBotCommand(&command{
+		Name:        "admin enable",
+		Usage:       "admin enable <feature> [#channel|workspace]",
+		Description: "Switch a feature back on",
+		AdminOnly:   true,
+		Handler:     handleEnableFeature,
+	})
+	registerBotCommand(&command{
+		Name:        "admin disabled",
+		Usage:       "admin disabled",
+		Description: "List the features that are switched off",
+		AdminOnly:   true,
+		Handler:     handleListDisabledFeatures,
+	})
+}
+
+// startKillSwitches re-enables features whose switch has run out
+func startKillSwitches() {
+	runEvery("kill switches", time.Minute, expireKillSwitches)
+}
+
+func killSwitchKey(feature, channel string) string {
+	if channel == "" {
+		return feature
+	}
+	return feature + "/" + channel
+}
+
+// featureEnabled reports whether feature may run in channel: it isn't
+// switched off there or in the whole workspace
+func featureEnabled(feature, channel string) bool {
+	keys := []string{killSwitchKey(feature, "")}
+	if channel != "" {
+		keys = append(keys, killSwitchKey(feature, channel))
+	}
+	for _, key := range keys {
+		var ks killSwitch
+		found, err := store.Get(killSwitchesBucket, key, &ks)
+		if err != nil {
+			log.Printf("Error reading kill switch %s: %v", key, err)
+			continue
+		}
+		if found && (ks.Until.IsZero() || time.Now().Before(ks.Until)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseKillSwitchArgs reads "<feature> [#channel|workspace]" and returns
+// the words after them
+func parseKillSwitchArgs(args []string) (feature, channel string, rest []string, err error) {
+	if len(args) == 0 {
+		return "", "", nil, fmt.Errorf("I need to know which feature")
+	}
+	feature = strings.ToLower(args[0])
+	if _, ok := killSwitchFeatures[feature]; !ok {
+		return "", "", nil, fmt.Errorf("I don't know the feature %q; it's one of %s", args[0], strings.Join(killSwitchFeatureNames(), ", "))
+	}
+	rest = args[1:]
+	if len(rest) > 0 {
+		if m := channelMentionPattern.FindStringSubmatch(rest[0]); m != nil {
+			channel, rest = m[1], rest[1:]
+		} else if strings.EqualFold(rest[0], "workspace") {
+			rest = rest[1:]
+		}
+	}
+	return feature, channel, rest, nil
+}
+
+func killSwitchFeatureNames() []string {
+	names := make([]string, 0, len(killSwitchFeatures))
+	for name := range killSwitchFeatures {
+		names = append(names, "`"+name+"`")
+	}
+	sort.Strings(names)
+	return names
+}
+
+// killSwitchScope describes where a switch applies
+func killSwitchScope(channel string) string {
+	if channel == "" {
+		return "the whole workspace"
+	}
+	return "<#" + channel + ">"
+}
+
+func handleDisableFeature(req commandRequest) commandResponse {
+	usage := "Usage: `" + botCommand + " admin disable <feature> [#channel|workspace] [duration]`, e.g. `" + botCommand + " admin disable ai_replies #general 2h`"
+	feature, channel, rest, err := parseKillSwitchArgs(req.Args)
+	if err != nil {
+		return ephemeral("%v. %s", err, usage)
+	}
+	ks := killSwitch{Feature: feature, Channel: channel, By: req.UserID, At: time.Now().UTC()}
+	if len(rest) > 0 {
+		d, err := parseSpokenDuration(rest)
+		if err != nil {
+			return ephemeral("I couldn't work out how long: %v. %s", err, usage)
+		}
+		ks.Until = ks.At.Add(d)
+	}
+	if err := store.Put(killSwitchesBucket, killSwitchKey(feature, channel), ks); err != nil {
+		log.Printf("Error saving kill switch: %v", err)
+		return ephemeral("Sorry, something went wrong switching %s off.", feature)
+	}
+	detail := fmt.Sprintf("disabled %s in %s", feature, killSwitchScope(channel))
+	if !ks.Until.IsZero() {
+		detail += " until " + ks.Until.Format(time.RFC3339)
+	}
+	recordAdminAudit(req.UserID, roleAdmin, "kill switch", detail)
+	log.Printf("Kill switch: %s %s", req.UserID, detail)
+
+	until := "until someone runs `" + botCommand + " admin enable " + feature + "`"
+	if !ks.Until.IsZero() {
+		until = "until " + slackDate(ks.Until)
+	}
+	return ephemeral(":octagonal_sign: `%s` is off in %s %s.", feature, killSwitchScope(channel), until)
+}
+
+func handleEnableFeature(req commandRequest) commandResponse {
+	feature, channel, rest, err := parseKillSwitchArgs(req.Args)
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("I didn't expect %q", strings.Join(rest, " "))
+	}
+	if err != nil {
+		return ephemeral("%v. Usage: `%s admin enable <feature> [#channel|workspace]`", err, botCommand)
+	}
+	key := killSwitchKey(feature, channel)
+	var ks killSwitch
+	found, err := store.Get(killSwitchesBucket, key, &ks)
+	if err == nil && found {
+		err = store.Delete(killSwitchesBucket, key)
+	}
+	if err != nil {
+		log.Printf("Error removing kill switch: %v", err)
+		return ephemeral("Sorry, something went wrong switching %s back on.", feature)
+	}
+	if !found {
+		return ephemeral("`%s` isn't switched off in %s.", feature, killSwitchScope(channel))
+	}
+	recordAdminAudit(req.UserID, roleAdmin, "kill switch", fmt.Sprintf("enabled %s in %s", feature, killSwitchScope(channel)))
+	return ephemeral(":white_check_mark: `%s` is back on in %s.", feature, killSwitchScope(channel))
+}
+
+func handleListDisabledFeatures(req commandRequest) commandResponse {
+	switches, err := storeList[killSwitch](store, killSwitchesBucket)
+	if err != nil {
+		log.Printf("Error listing kill switches: %v", err)
+		return ephemeral("Sorry, something went wrong listing kill switches.")
+	}
+	var lines []string
+	for _, ks := range switches {
+		if !ks.Until.IsZero() && time.Now().After(ks.Until) {
+			continue
+		}
+		line := fmt.Sprintf("• `%s` in %s, by <@%s> %s", ks.Feature, killSwitchScope(ks.Channel), ks.By, slackDate(ks.At))
+		if !ks.Until.IsZero() {
+			line += ", until " + slackDate(ks.Until)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		for name, description := range killSwitchFeatures {
+			lines = append(lines, fmt.Sprintf("• `%s`: %s", name, description))
+		}
+		sort.Strings(lines)
+		return ephemeral("Every feature is on. These can be switched off:\n%s", strings.Join(lines, "\n"))
+	}
+	sort.Strings(lines)
+	return ephemeral("*Switched off*\n%s", strings.Join(lines, "\n"))
+}
+
+// expireKillSwitches removes switches whose time is up
+func expireKillSwitches() {
+	switches, err := storeList[killSwitch](store, killSwitchesBucket)
+	if err != nil {
+		log.Printf("Error listing kill switches: %v", err)
+		return
+	}
+	for _, ks := range switches {
+		if ks.Until.IsZero() || time.Now().Before(ks.Until) {
+			continue
+		}
+		if err := store.Delete(killSwitchesBucket, killSwitchKey(ks.Feature, ks.Channel)); err != nil {
+			log.Printf("Error removing kill switch: %v", err)
+			continue
+		}
+		detail := fmt.Sprintf("%s re-enabled in %s after its switch ran out", ks.Feature, killSwitchScope(ks.Channel))
+		recordAdminAudit(ks.By, roleAdmin, "kill switch", detail)
+		log.Printf("Kill switch: %s", detail)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Used when knowledge.top_k and knowledge.min_score aren't set
+const (
+	defaultKnowledgeTopK     = 5
+	defaultKnowledgeMinScore = 0.3
+)
+
+// Passages are cut at paragraph breaks once they reach about this many
+// characters
+const knowledgeChunkChars = 1500
+
+// How many passages are embedded in one request
+const embeddingBatchSize = 64
+
+const knowledgePrompt = "You answer questions about our internal documentation. Use only the numbered passages below. " +
+	"Cite the passages you use like [1] or [2][3]. If the passages don't answer the question, say you don't know " +
+	"rather than guessing. Answer briefly and format with Slack mrkdwn, not Markdown."
+
+// Files the indexer reads; everything else in a directory is skipped
+var knowledgeExtensions = []string{".md", ".markdown", ".txt", ".html", ".htm"}
+
+var (
+	htmlTitlePattern    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlSkippedPattern  = regexp.MustCompile(`(?is)<(script|style|nav|header|footer)[^>]*>.*?</(script|style|nav|header|footer)>`)
+	htmlBlockTagPattern = regexp.MustCompile(`(?i)</?(p|div|br|li|ul|ol|h[1-6]|tr|table|section|article|pre)[^>]*>`)
+	htmlTagPattern      = regexp.MustCompile(`<[^>]*>`)
+	blankLinesPattern   = regexp.MustCompile(`\n\s*\n+`)
+	citationPattern     = regexp.MustCompile(`\[(\d+)\]`)
+)
+
+// kbDocument is a document to index
+type kbDocument struct {
+	// Source identifies the document; indexing it again replaces it
+	Source string
+	Title  string
+	URL    string
+	Text   string
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/ask",
+		Usage:       "/ask <question>",
+		Description: "Ask a question and get an answer from our docs, with links to where it came from",
+		Handler:     handleAsk,
+	})
+}
+
+func knowledgeTopK() int {
+	if n := appConfig.Knowledge.TopK; n > 0 {
+		return n
+	}
+	return defaultKnowledgeTopK
+}
+
+func knowledgeMinScore() float64 {
+	if score := appConfig.Knowledge.MinScore; score > 0 {
+		return score
+	}
+	return defaultKnowledgeMinScore
+}
+
+func handleAsk(req commandRequest) commandResponse {
+	if knowledgeBase == nil {
+		return ephemeral("The knowledge base isn't set up. See `knowledge` in the config.")
+	}
+	if !featureEnabled(featureAIReplies, req.ChannelID) {
+		return ephemeral("Answers from the docs are switched off right now.")
+	}
+	question := strings.TrimSpace(req.Text)
+	if question == "" {
+		return ephemeral("Usage: `/ask <question>`, e.g. `/ask how do I get VPN access?`")
+	}
+	// Answers take longer than Slack waits for a slash command
+	go runJob("ask", func() {
+		msg := askKnowledgeBase(question)
+		if err := respond(req.ResponseURL, msg); err != nil {
+			log.Printf("Error answering /ask: %v", err)
+		}
+	})
+	return ephemeral(":mag: Looking that up…")
+}
+
+// askKnowledgeBase answers question from the passages most like it
+func askKnowledgeBase(question string) *slack.WebhookMessage {
+	reply := func(text string) *slack.WebhookMessage {
+		return &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true}
+	}
+	vectors, err := embedTexts([]string{question})
+	if err != nil {
+		log.Printf("Error embedding question: %v", err)
+		return reply("Sorry, something went wrong looking that up.")
+	}
+	matches, err := knowledgeBase.Search(vectors[0], knowledgeTopK())
+	if err != nil {
+		log.Printf("Error searching knowledge base: %v", err)
+		return reply("Sorry, something went wrong looking that up.")
+	}
+	matches = slices.DeleteFunc(matches, func(m kbMatch) bool { return m.Score < knowledgeMinScore() })
+	if len(matches) == 0 {
+		return reply("I couldn't find anything about that in the docs.")
+	}
+
+	var passages strings.Builder
+	for i, match := range matches {
+		fmt.Fprintf(&passages, "[%d] %s\n%s\n\n", i+1, match.Title, match.Text)
+	}
+	answer, err := llmChat([]llmMessage{
+		{Role: "system", Content: knowledgePrompt + "\n\n" + passages.String()},
+		{Role: "user", Content: question},
+	})
+	if err != nil {
+		log.Printf("Error answering from knowledge base: %v", err)
+		return reply("Sorry, something went wrong putting an answer together.")
+	}
+	answer = strings.TrimSpace(answer)
+
+	// Only the passages the answer cites are listed, each document once
+	var sources []string
+	var seen []string
+	for _, m := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		n, _ := strconv.Atoi(m[1])
+		if n < 1 || n > len(matches) || slices.Contains(seen, matches[n-1].Source) {
+			continue
+		}
+		seen = append(seen, matches[n-1].Source)
+		sources = append(sources, fmt.Sprintf("[%d] %s", n, kbLink(matches[n-1].kbChunk)))
+	}
+	section, _ := truncateText(fmt.Sprintf("*%s*\n%s", question, answer), maxSectionText)
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, section, false, false), nil, nil)}
+	if len(sources) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "Sources: "+strings.Join(sources, "  "), false, false)))
+	}
+	msg := reply(answer)
+	msg.Blocks = &slack.Blocks{BlockSet: blocks}
+	return msg
+}
+
+// kbLink links to a passage's document, or names it when it has no URL
+func kbLink(chunk kbChunk) string {
+	if chunk.URL == "" {
+		return chunk.Title
+	}
+	return fmt.Sprintf("<%s|%s>", chunk.URL, chunk.Title)
+}
+
+// indexDocument splits doc into passages, embeds them and replaces the
+// document's passages in the knowledge base, returning how many it stored
+func indexDocument(doc kbDocument) (int, error) {
+	passages := chunkText(doc.Text, knowledgeChunkChars)
+	chunks := make([]kbChunk, 0, len(passages))
+	for start := 0; start < len(passages); start += embeddingBatchSize {
+		batch := passages[start:min(start+embeddingBatchSize, len(passages))]
+		vectors, err := embedTexts(batch)
+		if err != nil {
+			return 0, err
+		}
+		for i, text := range batch {
+			chunks = append(chunks, kbChunk{
+				ID:     fmt.Sprintf("%s#%d", doc.Source, start+i),
+				Source: doc.Source,
+				Title:  doc.Title,
+				URL:    doc.URL,
+				Text:   text,
+				Vector: vectors[i],
+			})
+		}
+	}
+	if err := knowledgeBase.ReplaceSource(doc.Source, chunks); err != nil {
+		return 0, fmt.Errorf("storing %s: %w", doc.Source, err)
+	}
+	return len(chunks), nil
+}
+
+// chunkText splits text at blank lines into passages of about size
+// characters, starting a new passage at each Markdown heading
+func chunkText(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(current.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		current.Reset()
+	}
+	for _, para := range blankLinesPattern.Split(text, -1) {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if current.Len() > 0 && (current.Len()+len(para) > size || strings.HasPrefix(para, "#")) {
+			flush()
+		}
+		// A paragraph longer than a passage is cut at line breaks, or hard
+		for len(para) > size {
+			cut := strings.LastIndex(para[:size], "\n")
+			if cut <= 0 {
+				cut = strings.LastIndex(para[:size], " ")
+			}
+			if cut <= 0 {
+				cut = size
+			}
+			current.WriteString(para[:cut])
+			flush()
+			para = strings.TrimSpace(para[cut:])
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(para)
+	}
+	flush()
+	return chunks
+}
+
+// loadKnowledgeDocuments reads the documents at a file, a directory (every
+// Markdown, text and HTML file in it) or an http(s) URL. Files are linked
+// as baseURL plus their path under root, without the extension.
+func loadKnowledgeDocuments(location, baseURL string) ([]kbDocument, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		doc, err := fetchKnowledgeURL(location)
+		if err != nil {
+			return nil, err
+		}
+		return []kbDocument{doc}, nil
+	}
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	root := location
+	if !info.IsDir() {
+		root = filepath.Dir(location)
+	}
+	var docs []kbDocument
+	err = filepath.WalkDir(location, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !slices.Contains(knowledgeExtensions, strings.ToLower(filepath.Ext(path))) {
+			return err
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		doc := documentFromText(filepath.ToSlash(rel), string(raw), filepath.Ext(path))
+		if baseURL != "" {
+			doc.URL = strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimSuffix(doc.Source, filepath.Ext(path))
+		}
+		docs = append(docs, doc)
+		return nil
+	})
+	return docs, err
+}
+
+// fetchKnowledgeURL downloads a page to index
+func fetchKnowledgeURL(pageURL string) (kbDocument, error) {
+	resp, err := httpClient.Get(pageURL)
+	if err != nil {
+		return kbDocument{}, fmt.Errorf("fetching %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return kbDocument{}, fmt.Errorf("fetching %s: %s", pageURL, resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return kbDocument{}, fmt.Errorf("fetching %s: %w", pageURL, err)
+	}
+	ext := ".txt"
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		ext = ".html"
+	}
+	doc := documentFromText(pageURL, string(raw), ext)
+	doc.URL = pageURL
+	return doc, nil
+}
+
+// documentFromText titles a document by its first heading, or its HTML
+// title, falling back to its source
+func documentFromText(source, text, ext string) kbDocument {
+	doc := kbDocument{Source: source, Title: source, Text: text}
+	switch strings.ToLower(ext) {
+	case ".html", ".htm":
+		if m := htmlTitlePattern.FindStringSubmatch(text); m != nil {
+			doc.Title = strings.TrimSpace(html.UnescapeString(m[1]))
+		}
+		text = htmlSkippedPattern.ReplaceAllString(text, "")
+		text = htmlBlockTagPattern.ReplaceAllString(text, "\n\n")
+		doc.Text = html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+	case ".md", ".markdown":
+		for _, line := range strings.Split(text, "\n") {
+			if title, ok := strings.CutPrefix(line, "# "); ok {
+				doc.Title = strings.TrimSpace(title)
+				break
+			}
+		}
+	}
+	return doc
+}
+
+// cosineSimilarity is how alike two embeddings are, from -1 to 1
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Store buckets for kudos, keyed by ID, and for the leaderboards already
+// posted, keyed by period (e.g. week:2024-03-04)
+const (
+	kudosBucket             = "kudos"
+	kudosLeaderboardsBucket = "kudos_leaderboards"
+)
+
+// Leaderboard periods
+const (
+	kudosWeek  = "week"
+	kudosMonth = "month"
+)
+
+// How many people a leaderboard shows
+const kudosLeaderboardSize = 10
+
+// kudo is one shoutout
+type kudo struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Reason  string    `json:"reason"`
+	Channel string    `json:"channel"`
+	At      time.Time `json:"at"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/kudos",
+		Usage:       "/kudos @user <reason> | top [week|month] | history [@user]",
+		Description: "Give someone a shoutout, or see the kudos leaderboard and history",
+		Handler:     handleKudosCommand,
+	})
+	registerUserDataset(userDataset{Name: "kudos", Title: "Kudos given and received", Describe: describeUserKudos, Delete: deleteUserKudos})
+}
+
+// startKudosLeaderboards posts last week's and last month's leaderboards to
+// the recognition channel once each period is over
+func startKudosLeaderboards() {
+	cfg := appConfig.Kudos
+	if cfg.Channel == "" || len(cfg.Leaderboards) == 0 {
+		return
+	}
+	runEvery("kudos leaderboards", time.Hour, postDueKudosLeaderboards)
+}
+
+func handleKudosCommand(req commandRequest) commandResponse {
+	if len(req.Args) == 0 {
+		return ephemeral("Usage: `%s @user <reason>`, `%s top [week|month]` or `%s history [@user]`", req.Command, req.Command, req.Command)
+	}
+	switch strings.ToLower(req.Args[0]) {
+	case "top":
+		period := kudosWeek
+		if len(req.Args) > 1 {
+			period = strings.ToLower(req.Args[1])
+		}
+		if period != kudosWeek && period != kudosMonth {
+			return ephemeral("Usage: `%s top [week|month]`", req.Command)
+		}
+		start, end := kudosPeriod(period, time.Now())
+		return kudosLeaderboard(period, start, end, true)
+	case "history":
+		return kudosHistory(req)
+	}
+	return giveKudos(req)
+}
+
+func giveKudos(req commandRequest) commandResponse {
+	m := userMentionPattern.FindStringSubmatch(req.Args[0])
+	reason := strings.TrimSpace(strings.Join(req.Args[1:], " "))
+	if m == nil || reason == "" {
+		return ephemeral("Usage: `%s @user <reason>`", req.Command)
+	}
+	to := m[1]
+	if to == req.UserID {
+		return ephemeral("Kudos are for other people. Maybe someone helped you with it?")
+	}
+	channel := appConfig.Kudos.Channel
+	if channel == "" {
+		return ephemeral("Kudos aren't set up yet; a bot admin needs to set kudos.channel.")
+	}
+
+	k := kudo{ID: newInteractionToken(), From: req.UserID, To: to, Reason: reason, Channel: req.ChannelID, At: time.Now().UTC()}
+	if err := store.Put(kudosBucket, k.ID, k); err != nil {
+		log.Printf("Error saving kudos: %v", err)
+		return ephemeral("Sorry, something went wrong giving kudos.")
+	}
+	text := fmt.Sprintf(":tada: *Kudos to <@%s>!*\n>%s\n— from <@%s>", to, quoteText(reason), req.UserID)
+	if _, err := sendMessage(outboundMessage{Channel: channel, Text: text}); err != nil {
+		log.Printf("Error posting kudos: %v", err)
+		return ephemeral("I saved your kudos but couldn't post it in <#%s>.", channel)
+	}
+	if channel == req.ChannelID {
+		return ephemeral("Sent!")
+	}
+	return ephemeral("Sent! Your kudos for <@%s> is in <#%s>.", to, channel)
+}
+
+// loadKudos returns the kudos given in [start, end)
+func loadKudos(start, end time.Time) ([]kudo, error) {
+	all, err := storeList[kudo](store, kudosBucket)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(all, func(k kudo) bool { return k.At.Before(start) || !k.At.Before(end) }), nil
+}
+
+// kudosPeriod returns the UTC week (from Monday) or month containing t
+func kudosPeriod(period string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == kudosMonth {
+		start := day.AddDate(0, 0, 1-day.Day())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	return start, start.AddDate(0, 0, 7)
+}
+
+func kudosLeaderboard(period string, start, end time.Time, inChannel bool) commandResponse {
+	kudos, err := loadKudos(start, end)
+	if err != nil {
+		log.Printf("Error loading kudos: %v", err)
+		return ephemeral("Sorry, something went wrong loading the kudos.")
+	}
+	title := fmt.Sprintf(":trophy: *Kudos leaderboard for the %s of %s*", period, start.Format("Jan 2, 2006"))
+	if period == kudosMonth {
+		title = fmt.Sprintf(":trophy: *Kudos leaderboard for %s*", start.Format("January 2006"))
+	}
+	if len(kudos) == 0 {
+		return commandResponse{Text: title + "\nNo kudos yet. Be the first with `/kudos @someone <reason>`.", InChannel: inChannel}
+	}
+
+	counts := map[string]int{}
+	for _, k := range kudos {
+		counts[k.To]++
+	}
+	people := make([]string, 0, len(counts))
+	for userID := range counts {
+		people = append(people, userID)
+	}
+	slices.SortFunc(people, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	lines := []string{title}
+	for i, userID := range people[:min(len(people), kudosLeaderboardSize)] {
+		lines = append(lines, fmt.Sprintf("%d. <@%s> %d", i+1, userID, counts[userID]))
+	}
+	lines = append(lines, fmt.Sprintf("_%d kudos in total_", len(kudos)))
+	return commandResponse{Text: strings.Join(lines, "\n"), InChannel: inChannel}
+}
+
+func kudosHistory(req commandRequest) commandResponse {
+	userID := req.UserID
+	if len(req.Args) > 1 {
+		m := userMentionPattern.FindStringSubmatch(req.Args[1])
+		if m == nil {
+			return ephemeral("Usage: `%s history [@user]`", req.Command)
+		}
+		userID = m[1]
+	}
+	all, err := storeList[kudo](store, kudosBucket)
+	if err != nil {
+		log.Printf("Error loading kudos: %v", err)
+		return ephemeral("Sorry, something went wrong loading the kudos.")
+	}
+	received := slices.DeleteFunc(all, func(k kudo) bool { return k.To != userID })
+	if len(received) == 0 {
+		return ephemeral("<@%s> hasn't received any kudos yet.", userID)
+	}
+	slices.SortFunc(received, func(a, b kudo) int { return b.At.Compare(a.At) })
+	items := make([]string, 0, len(received))
+	for _, k := range received {
+		items = append(items, fmt.Sprintf("• %s from <@%s>: %s", k.At.Format("Jan 2, 2006"), k.From, k.Reason))
+	}
+	title := fmt.Sprintf("Kudos for <@%s> (%d)", userID, len(received))
+	return pagedResponse(title, items, 0)
+}
+
+// postDueKudosLeaderboards posts the leaderboard of each configured period
+// that has just ended, once
+func postDueKudosLeaderboards() {
+	cfg := appConfig.Kudos
+	now := time.Now()
+	for _, period := range cfg.Leaderboards {
+		if period != kudosWeek && period != kudosMonth {
+			log.Printf("Unknown kudos leaderboard period %q", period)
+			continue
+		}
+		current, _ := kudosPeriod(period, now)
+		start, end := kudosPeriod(period, current.Add(-time.Hour))
+		key := period + ":" + start.Format("2006-01-02")
+		var posted bool
+		if _, err := store.Get(kudosLeaderboardsBucket, key, &posted); err != nil || posted {
+			continue
+		}
+		resp := kudosLeaderboard(period, start, end, true)
+		if _, err := sendInWindow("kudos_leaderboard", outboundMessage{Channel: cfg.Channel, Text: resp.Text}); err != nil {
+			log.Printf("Error posting kudos leaderboard: %v", err)
+			continue
+		}
+		if err := store.Put(kudosLeaderboardsBucket, key, true); err != nil {
+			log.Printf("Error recording kudos leaderboard: %v", err)
+		}
+	}
+}
+
+// userKudos returns the kudos userID gave or received
+func userKudos(userID string) ([]kudo, error) {
+	all, err := storeList[kudo](store, kudosBucket)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(all, func(k kudo) bool { return k.From != userID && k.To != userID }), nil
+}
+
+func describeUserKudos(userID string) (string, error) {
+	kudos, err := userKudos(userID)
+	if err != nil {
+		return "", err
+	}
+	items := make([]string, len(kudos))
+	for i, k := range kudos {
+		if k.From == userID {
+			items[i] = fmt.Sprintf("To <@%s>: %s", k.To, k.Reason)
+		} else {
+			items[i] = fmt.Sprintf("From <@%s>: %s", k.From, k.Reason)
+		}
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserKudos deletes the kudos userID gave and received; the
+// shoutouts already posted in Slack stay
+func deleteUserKudos(userID string) error {
+	kudos, err := userKudos(userID)
+	if err != nil {
+		return err
+	}
+	for _, k := range kudos {
+		if err := store.Delete(kudosBucket, k.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
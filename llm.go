@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Language models answer slower than the services httpClient is tuned for
+var llmHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+var errLLMNotConfigured = errors.New("no language model is configured (llm.url and llm.model)")
+
+// Anthropic's API needs a reply length; used when llm.max_tokens isn't set
+const defaultLLMMaxTokens = 1024
+
+// llmMessage is one turn of a chat with the language model
+type llmMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// llmReply is the model's answer and what it cost
+type llmReply struct {
+	Text         string
+	InputTokens  int
+	OutputTokens int
+}
+
+// llmChat sends messages to the configured chat API and returns the reply
+func llmChat(messages []llmMessage) (string, error) {
+	reply, err := llmStream(messages, nil)
+	return reply.Text, err
+}
+
+// llmStream sends messages to the configured chat API. With onText set,
+// the reply is streamed and onText gets the text so far as it grows.
+func llmStream(messages []llmMessage, onText func(string)) (llmReply, error) {
+	cfg := appConfig.LLM
+	if cfg.URL == "" || cfg.Model == "" {
+		return llmReply{}, errLLMNotConfigured
+	}
+	var reply llmReply
+	var err error
+	switch cfg.Provider {
+	case "", "openai":
+		reply, err = openAIChat(cfg, messages, onText)
+	case "anthropic":
+		reply, err = anthropicChat(cfg, messages, onText)
+	default:
+		return llmReply{}, fmt.Errorf("unknown llm.provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return llmReply{}, err
+	}
+	recordQuotaUsage(quotaLLMCalls, 1)
+	recordQuotaUsage(quotaLLMTokens, reply.InputTokens+reply.OutputTokens)
+	return reply, nil
+}
+
+// llmRequest posts a JSON request to the model's API
+func llmRequest(url string, body any, headers map[string]string) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := llmHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling language model: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("calling language model: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// llmAPIKey reads the API key from llm.api_key_env
+func llmAPIKey(cfg LLMConfig) string {
+	keyEnv := cfg.APIKeyEnv
+	if keyEnv == "" {
+		keyEnv = "LLM_API_KEY"
+	}
+	return os.Getenv(keyEnv)
+}
+
+// readServerSentEvents calls fn with the data of each event in a
+// text/event-stream body, stopping at [DONE]
+func readServerSentEvents(body io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		if err := fn([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading language model stream: %w", err)
+	}
+	return nil
+}
+
+// openAIChat calls an OpenAI-compatible chat completions API
+func openAIChat(cfg LLMConfig, messages []llmMessage, onText func(string)) (llmReply, error) {
+	body := map[string]any{"model": cfg.Model, "messages": messages, "temperature": 0}
+	if cfg.MaxTokens > 0 {
+		body["max_tokens"] = cfg.MaxTokens
+	}
+	if onText != nil {
+		body["stream"] = true
+		body["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	headers := map[string]string{}
+	if key := llmAPIKey(cfg); key != "" {
+		headers["Authorization"] = "Bearer " + key
+	}
+	resp, err := llmRequest(strings.TrimSuffix(cfg.URL, "/")+"/chat/completions", body, headers)
+	if err != nil {
+		return llmReply{}, err
+	}
+	defer resp.Body.Close()
+
+	type usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	}
+	if onText == nil {
+		var out struct {
+			Choices []struct {
+				Message llmMessage `json:"message"`
+			} `json:"choices"`
+			Usage usage `json:"usage"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+			return llmReply{}, fmt.Errorf("decoding language model reply: %w", err)
+		}
+		if len(out.Choices) == 0 {
+			return llmReply{}, fmt.Errorf("language model returned no reply")
+		}
+		return llmReply{Text: out.Choices[0].Message.Content, InputTokens: out.Usage.PromptTokens, OutputTokens: out.Usage.CompletionTokens}, nil
+	}
+
+	var reply llmReply
+	var text strings.Builder
+	err = readServerSentEvents(resp.Body, func(data []byte) error {
+		var chunk struct {
+			Choices []struct {
+				Delta llmMessage `json:"delta"`
+			} `json:"choices"`
+			Usage *usage `json:"usage"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decoding language model stream: %w", err)
+		}
+		if chunk.Usage != nil {
+			reply.InputTokens, reply.OutputTokens = chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			text.WriteString(chunk.Choices[0].Delta.Content)
+			onText(text.String())
+		}
+		return nil
+	})
+	reply.Text = text.String()
+	return reply, err
+}
+
+// anthropicChat calls Anthropic's messages API, which takes the system
+// prompt apart from the conversation. It always streams.
+func anthropicChat(cfg LLMConfig, messages []llmMessage, onText func(string)) (llmReply, error) {
+	var system []string
+	var turns []llmMessage
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+		} else {
+			turns = append(turns, msg)
+		}
+	}
+	maxTokens := cfg.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultLLMMaxTokens
+	}
+	body := map[string]any{"model": cfg.Model, "messages": turns, "max_tokens": maxTokens, "temperature": 0, "stream": true}
+	if len(system) > 0 {
+		body["system"] = strings.Join(system, "\n\n")
+	}
+	headers := map[string]string{"anthropic-version": "2023-06-01", "x-api-key": llmAPIKey(cfg)}
+	resp, err := llmRequest(strings.TrimSuffix(cfg.URL, "/")+"/messages", body, headers)
+	if err != nil {
+		return llmReply{}, err
+	}
+	defer resp.Body.Close()
+
+	var reply llmReply
+	var text strings.Builder
+	err = readServerSentEvents(resp.Body, func(data []byte) error {
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				Usage struct {
+					InputTokens int `json:"input_tokens"`
+				} `json:"usage"`
+			} `json:"message"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("decoding language model stream: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			reply.InputTokens = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				text.WriteString(event.Delta.Text)
+				if onText != nil {
+					onText(text.String())
+				}
+			}
+		case "message_delta":
+			reply.OutputTokens = event.Usage.OutputTokens
+		case "error":
+			return fmt.Errorf("language model stream failed: %s", event.Error.Message)
+		}
+		return nil
+	})
+	reply.Text = text.String()
+	if err == nil && reply.Text == "" {
+		err = fmt.Errorf("language model returned no reply")
+	}
+	return reply, err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// How often a streaming reply is edited; Slack allows about one update a
+// second per message
+const llmUpdateInterval = time.Second
+
+const defaultMentionPrompt = "You are a helpful assistant in a Slack workspace. Answer briefly. " +
+	"Format with Slack mrkdwn: *bold*, _italic_, `code` and <https://example.com|links>, not Markdown. " +
+	"Keep mentions such as <@U123> and <#C123> exactly as written."
+
+// answerMentionWithLLM answers a mention with the language model in the
+// mention's thread, with what was said in the thread so far. Where the
+// reply would be posted as is, a placeholder goes up first and is edited
+// as the answer streams in. Everything is posted through out.
+func answerMentionWithLLM(ev *slackevents.AppMentionEvent, reply outboundMessage, out *eventReplies) {
+	cfg := appConfig.LLM.Mentions
+	system := cfg.SystemPrompt
+	variant, inExperiment := experimentVariant(cfg.Experiment, ev.User, ev.Channel)
+	if inExperiment && variant.Prompt != "" {
+		system = variant.Prompt
+	}
+	if system == "" {
+		system = defaultMentionPrompt
+	}
+	question := threadMessage{Role: "user", User: ev.User, Text: strings.TrimSpace(leadingMentionPattern.ReplaceAllString(ev.Text, "")), At: time.Now().UTC()}
+	if err := rememberInThread(reply.Channel, reply.ThreadTS, question); err != nil {
+		log.Printf("Error saving thread memory: %v", err)
+	}
+	history, err := threadContext(reply.Channel, reply.ThreadTS)
+	if err != nil || len(history) == 0 {
+		if err != nil {
+			log.Printf("Error loading thread memory: %v", err)
+		}
+		history = []llmMessage{{Role: "user", Content: question.Text}}
+	}
+	messages := append([]llmMessage{{Role: "system", Content: system}}, history...)
+
+	// Routed replies (threaded, digested or suppressed) are sent once, when
+	// the answer is complete
+	var ts string
+	var onText func(string)
+	if routeFor(reply.Channel, reply.Importance) == routePost {
+		placeholder := reply
+		placeholder.Text = ":thinking_face: Thinking…"
+		if ts, err = out.post(placeholder); err != nil {
+			log.Printf("Error replying to mention: %v", err)
+			return
+		}
+		var lastUpdate time.Time
+		onText = func(text string) {
+			if time.Since(lastUpdate) < llmUpdateInterval {
+				return
+			}
+			lastUpdate = time.Now()
+			if err := out.update(reply.Channel, ts, text+" …"); err != nil {
+				log.Printf("Error streaming mention reply: %v", err)
+			}
+		}
+	}
+
+	started := time.Now()
+	answer, err := llmStream(messages, onText)
+	if err != nil {
+		log.Printf("Error answering mention with language model: %v", err)
+		answer.Text = "Sorry, something went wrong thinking about that. Try again in a bit."
+	} else {
+		log.Printf("Answered mention from %s in %s with %s: %d input + %d output tokens in %s",
+			ev.User, ev.Channel, appConfig.LLM.Model, answer.InputTokens, answer.OutputTokens, time.Since(started).Round(time.Millisecond))
+		if err := rememberInThread(reply.Channel, reply.ThreadTS, threadMessage{Role: "assistant", Text: answer.Text, At: time.Now().UTC()}); err != nil {
+			log.Printf("Error saving thread memory: %v", err)
+		}
+	}
+
+	if ts == "" {
+		reply.Text = answer.Text
+		if ts, err = out.post(reply); err != nil {
+			log.Printf("Error replying to mention: %v", err)
+		}
+	} else if err := out.update(reply.Channel, ts, answer.Text); err != nil {
+		log.Printf("Error replying to mention: %v", err)
+	}
+	if inExperiment {
+		recordExposure(cfg.Experiment, variant.Name, reply.Channel, ts)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for lunch roulettes still collecting people, keyed by
+// channel/ts of the announcement
+const lunchBucket = "lunch"
+
+// Used when the lunch settings aren't set
+const (
+	defaultLunchWait      = 30 * time.Minute
+	defaultLunchGroupSize = 4
+	defaultLunchReaction  = "pizza"
+)
+
+// Longest a lunch roulette can collect people for
+const maxLunchWait = 4 * time.Hour
+
+// lunchRoulette is a /lunch announcement collecting reactions
+type lunchRoulette struct {
+	Channel   string    `json:"channel"`
+	TS        string    `json:"ts"`
+	StartedBy string    `json:"started_by"`
+	CloseAt   time.Time `json:"close_at"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/lunch",
+		Usage:       "/lunch [minutes]",
+		Description: "Collect people for lunch by reaction, then split them into groups with somewhere to go",
+		Handler:     handleLunch,
+	})
+}
+
+// startLunch closes lunch roulettes once their time is up
+func startLunch() {
+	runEvery("lunch roulette", time.Minute, closeDueLunches)
+}
+
+func lunchReaction() string {
+	if r := strings.Trim(appConfig.Lunch.Reaction, ":"); r != "" {
+		return r
+	}
+	return defaultLunchReaction
+}
+
+func handleLunch(req commandRequest) commandResponse {
+	wait := appConfig.Lunch.Wait
+	if wait <= 0 {
+		wait = defaultLunchWait
+	}
+	if arg := strings.TrimSpace(req.Text); arg != "" {
+		minutes, err := strconv.Atoi(strings.TrimSuffix(arg, "m"))
+		if err != nil || minutes <= 0 || time.Duration(minutes)*time.Minute > maxLunchWait {
+			return ephemeral("Usage: `/lunch [minutes]`, with up to %d minutes to collect people.", int(maxLunchWait.Minutes()))
+		}
+		wait = time.Duration(minutes) * time.Minute
+	}
+
+	reaction := lunchReaction()
+	closeAt := time.Now().Add(wait).UTC()
+	text := fmt.Sprintf(":%s: *Lunch roulette!* <@%s> is getting people together for lunch. React with :%s: by %s and I'll put you in a group with somewhere to go.",
+		reaction, req.UserID, reaction, slackDate(closeAt))
+	ts, err := sendMessage(outboundMessage{Channel: req.ChannelID, Importance: importanceInfo, NeedTS: true, Text: text})
+	if err != nil {
+		log.Printf("Error announcing lunch roulette: %v", err)
+		return ephemeral("Sorry, I couldn't post here. Is the bot in this channel?")
+	}
+	if err := slackClient.AddReaction(reaction, slack.NewRefToMessage(req.ChannelID, ts)); err != nil {
+		log.Printf("Error adding lunch reaction: %v", err)
+	}
+	lunch := lunchRoulette{Channel: req.ChannelID, TS: ts, StartedBy: req.UserID, CloseAt: closeAt}
+	if err := store.Put(lunchBucket, lunch.Channel+"/"+lunch.TS, lunch); err != nil {
+		log.Printf("Error saving lunch roulette: %v", err)
+		return ephemeral("Sorry, something went wrong starting lunch roulette.")
+	}
+	return ephemeral("Lunch roulette is on. I'll post the groups in %d minutes.", int(wait.Minutes()))
+}
+
+// closeDueLunches groups the people who reacted to each lunch roulette
+// whose time is up
+func closeDueLunches() {
+	lunches, err := storeList[lunchRoulette](store, lunchBucket)
+	if err != nil {
+		log.Printf("Error listing lunch roulettes: %v", err)
+		return
+	}
+	for _, lunch := range lunches {
+		if time.Now().Before(lunch.CloseAt) {
+			continue
+		}
+		if err := store.Delete(lunchBucket, lunch.Channel+"/"+lunch.TS); err != nil {
+			log.Printf("Error removing lunch roulette: %v", err)
+			continue
+		}
+		if err := closeLunch(lunch); err != nil {
+			log.Printf("Error closing lunch roulette in %s: %v", lunch.Channel, err)
+		}
+	}
+}
+
+// closeLunch splits the people who reacted into groups, each with a spot
+// from lunch.spots, and posts them in the announcement's thread
+func closeLunch(lunch lunchRoulette) error {
+	reactions, err := slackClient.GetReactions(slack.NewRefToMessage(lunch.Channel, lunch.TS), slack.GetReactionsParameters{Full: true})
+	if err != nil {
+		return fmt.Errorf("reading reactions: %w", err)
+	}
+	var people []string
+	for _, r := range reactions {
+		if r.Name != lunchReaction() {
+			continue
+		}
+		for _, user := range r.Users {
+			if user != botUserID {
+				people = append(people, user)
+			}
+		}
+	}
+
+	var text string
+	if len(people) < 2 {
+		text = "Not enough people joined lunch roulette this time. Maybe tomorrow!"
+	} else {
+		size := appConfig.Lunch.GroupSize
+		if size < 2 {
+			size = defaultLunchGroupSize
+		}
+		rand.Shuffle(len(people), func(i, j int) { people[i], people[j] = people[j], people[i] })
+		spots := rand.Perm(len(appConfig.Lunch.Spots))
+		lines := []string{fmt.Sprintf(":%s: *Lunch groups*", lunchReaction())}
+		for i, group := range splitCoffeeGroups(people, size) {
+			line := fmt.Sprintf("%d. %s", i+1, mentionList(group))
+			if len(spots) > 0 {
+				// Groups only share a spot once every spot is taken
+				line += " at *" + appConfig.Lunch.Spots[spots[i%len(spots)]] + "*"
+			}
+			lines = append(lines, line)
+		}
+		lines = append(lines, "Enjoy your lunch!")
+		text = strings.Join(lines, "\n")
+	}
+	_, err = sendMessage(outboundMessage{Channel: lunch.Channel, ThreadTS: lunch.TS, Broadcast: true, Importance: importanceInfo, Text: text})
+	return err
+}
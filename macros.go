@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Store buckets for macros: personal ones keyed by user ID, and each
+// channel's shared library keyed by channel ID
+const (
+	macrosBucket        = "macros"
+	channelMacrosBucket = "channel_macros"
+)
+
+// Matches a macro name
+var macroNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Matches a macro parameter: {1} to {9}, or {args} for all of them
+var macroParamPattern = regexp.MustCompile(`\{([1-9]|args)\}`)
+
+// macro is a saved command line
+type macro struct {
+	// Command is a /bot or other slash command line with parameters
+	Command string `json:"command"`
+	Author  string `json:"author"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "macro save",
+		Usage:       `macro save <name> "<command>"`,
+		Description: "Save a command to replay later; {1}, {2}… and {args} are filled in from `macro run`",
+		Handler:     handleMacroSave,
+	})
+	registerBotCommand(&command{
+		Name:        "macro run",
+		Usage:       "macro run <name> [args...]",
+		Description: "Run one of your macros, or one shared in this channel",
+		Handler:     handleMacroRun,
+	})
+	registerBotCommand(&command{
+		Name:        "macro list",
+		Usage:       "macro list",
+		Description: "List your macros and the ones shared in this channel",
+		Handler:     handleMacroList,
+	})
+	registerBotCommand(&command{
+		Name:        "macro delete",
+		Usage:       "macro delete <name>",
+		Description: "Delete one of your macros",
+		Handler:     handleMacroDelete,
+	})
+	registerBotCommand(&command{
+		Name:        "macro share",
+		Usage:       "macro share <name> [#channel]",
+		Description: "Add one of your macros to a channel's library",
+		Handler:     handleMacroShare,
+	})
+	registerBotCommand(&command{
+		Name:        "macro unshare",
+		Usage:       "macro unshare <name> [#channel]",
+		Description: "Remove a macro from a channel's library",
+		Handler:     handleMacroUnshare,
+	})
+	registerUserDataset(userDataset{Name: "macros", Title: "Macros", Describe: describeUserMacros, Delete: deleteUserMacros})
+}
+
+// loadMacros returns the macros stored under key in bucket
+func loadMacros(bucket, key string) (map[string]macro, error) {
+	macros := map[string]macro{}
+	if _, err := store.Get(bucket, key, &macros); err != nil {
+		return nil, err
+	}
+	return macros, nil
+}
+
+func handleMacroSave(req commandRequest) commandResponse {
+	args := parseQuotedArgs(strings.Join(req.Args, " "))
+	if len(args) != 2 {
+		return ephemeral(`Usage: %s macro save <name> "<command>"`, botCommand)
+	}
+	name, line := strings.ToLower(args[0]), strings.TrimSpace(args[1])
+	if !macroNamePattern.MatchString(name) {
+		return ephemeral("Macro names are up to 32 lower case letters, digits, - and _.")
+	}
+	if err := validateMacroCommand(line); err != nil {
+		return ephemeral("That macro doesn't work: %v", err)
+	}
+
+	macros, err := loadMacros(macrosBucket, req.UserID)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong saving the macro.")
+	}
+	macros[name] = macro{Command: line, Author: req.UserID}
+	if err := store.Put(macrosBucket, req.UserID, macros); err != nil {
+		log.Printf("Error saving macro: %v", err)
+		return ephemeral("Sorry, something went wrong saving the macro.")
+	}
+	return ephemeral("Saved. Run it with `%s macro run %s`.", botCommand, name)
+}
+
+// validateMacroCommand checks that line is a command the bot can replay
+func validateMacroCommand(line string) error {
+	command, rest, _ := strings.Cut(line, " ")
+	if command != botCommand && slashCommands[command] == nil {
+		return fmt.Errorf("macros can run %s commands and %s", botCommand, strings.Join(slashCommandNames(), ", "))
+	}
+	// Macros can't run macros, so a macro can never run forever
+	if command == botCommand && strings.Contains(strings.ToLower(rest), "macro ") {
+		return fmt.Errorf("macros can't run other macro commands")
+	}
+	return nil
+}
+
+func slashCommandNames() []string {
+	names := make([]string, 0, len(slashCommands))
+	for name := range slashCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func handleMacroRun(req commandRequest) commandResponse {
+	if len(req.Args) == 0 {
+		return ephemeral("Usage: `%s macro run <name> [args...]`", botCommand)
+	}
+	name := strings.ToLower(req.Args[0])
+	m, ok, err := findMacro(req.UserID, req.ChannelID, name)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong loading the macro.")
+	}
+	if !ok {
+		return ephemeral("There's no macro called %s. See `%s macro list`.", name, botCommand)
+	}
+	line, err := expandMacro(m.Command, req.Args[1:])
+	if err != nil {
+		return ephemeral("Can't run %s: %v", name, err)
+	}
+	if err := validateMacroCommand(line); err != nil {
+		return ephemeral("Can't run %s: %v", name, err)
+	}
+
+	resp, _ := runCommandLine(req.SlashCommand, line)
+	return resp
+}
+
+// findMacro looks for name among the user's macros, then in the channel's
+// library
+func findMacro(userID, channelID, name string) (macro, bool, error) {
+	for _, source := range [][2]string{{macrosBucket, userID}, {channelMacrosBucket, channelID}} {
+		macros, err := loadMacros(source[0], source[1])
+		if err != nil {
+			return macro{}, false, err
+		}
+		if m, ok := macros[name]; ok {
+			return m, true, nil
+		}
+	}
+	return macro{}, false, nil
+}
+
+// expandMacro fills in a macro's parameters from args
+func expandMacro(line string, args []string) (string, error) {
+	var missing []string
+	expanded := macroParamPattern.ReplaceAllStringFunc(line, func(param string) string {
+		key := strings.Trim(param, "{}")
+		if key == "args" {
+			return strings.Join(args, " ")
+		}
+		n, _ := strconv.Atoi(key)
+		if n > len(args) {
+			missing = append(missing, param)
+			return ""
+		}
+		return args[n-1]
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("it needs a value for %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+func handleMacroList(req commandRequest) commandResponse {
+	personal, err := loadMacros(macrosBucket, req.UserID)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong loading your macros.")
+	}
+	shared, err := loadMacros(channelMacrosBucket, req.ChannelID)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong loading the channel's macros.")
+	}
+	if len(personal) == 0 && len(shared) == 0 {
+		return ephemeral("There are no macros yet. Save one with `%s macro save <name> \"<command>\"`.", botCommand)
+	}
+
+	var lines []string
+	if len(personal) > 0 {
+		lines = append(lines, "*Your macros*")
+		lines = append(lines, macroLines(personal, false)...)
+	}
+	if len(shared) > 0 {
+		lines = append(lines, "*Shared in this channel*")
+		lines = append(lines, macroLines(shared, true)...)
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
+
+func macroLines(macros map[string]macro, withAuthor bool) []string {
+	names := make([]string, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		line := fmt.Sprintf("• `%s`: `%s`", name, macros[name].Command)
+		if withAuthor {
+			line += fmt.Sprintf(" (from <@%s>)", macros[name].Author)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func handleMacroDelete(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s macro delete <name>`", botCommand)
+	}
+	name := strings.ToLower(req.Args[0])
+	macros, err := loadMacros(macrosBucket, req.UserID)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong deleting the macro.")
+	}
+	if _, ok := macros[name]; !ok {
+		return ephemeral("You don't have a macro called %s.", name)
+	}
+	delete(macros, name)
+	if err := store.Put(macrosBucket, req.UserID, macros); err != nil {
+		log.Printf("Error deleting macro: %v", err)
+		return ephemeral("Sorry, something went wrong deleting the macro.")
+	}
+	return ephemeral("Deleted %s. Copies shared in channels stay until they're unshared.", name)
+}
+
+// macroTargetChannel infers the channel whose library a macro command
+// changes from its optional second argument
+func macroTargetChannel(req commandRequest, commandName string) (string, error) {
+	arg := ""
+	if len(req.Args) > 1 {
+		arg = req.Args[1]
+	}
+	target, err := inferChannelTarget(req, commandName, arg)
+	return target.ChannelID, err
+}
+
+func handleMacroShare(req commandRequest) commandResponse {
+	if len(req.Args) < 1 || len(req.Args) > 2 {
+		return ephemeral("Usage: `%s macro share <name> [#channel]`", botCommand)
+	}
+	name := strings.ToLower(req.Args[0])
+	channel, err := macroTargetChannel(req, "macro share")
+	if err != nil {
+		log.Printf("Error picking channel: %v", err)
+		return ephemeral("Sorry, something went wrong sharing the macro.")
+	}
+	personal, err := loadMacros(macrosBucket, req.UserID)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong sharing the macro.")
+	}
+	m, ok := personal[name]
+	if !ok {
+		return ephemeral("You don't have a macro called %s.", name)
+	}
+	shared, err := loadMacros(channelMacrosBucket, channel)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong sharing the macro.")
+	}
+	if existing, ok := shared[name]; ok && existing.Author != req.UserID {
+		return ephemeral("<@%s> already shared a macro called %s here.", existing.Author, name)
+	}
+	shared[name] = m
+	if err := store.Put(channelMacrosBucket, channel, shared); err != nil {
+		log.Printf("Error sharing macro: %v", err)
+		return ephemeral("Sorry, something went wrong sharing the macro.")
+	}
+	text := fmt.Sprintf(":card_index: <@%s> shared the macro `%s` in this channel: run it with `%s macro run %s`", req.UserID, name, botCommand, name)
+	if channel == req.ChannelID {
+		return commandResponse{Text: text, InChannel: true}
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Text: text}); err != nil {
+		log.Printf("Error announcing shared macro: %v", err)
+	}
+	return ephemeral("Shared %s in <#%s>.", name, channel)
+}
+
+func handleMacroUnshare(req commandRequest) commandResponse {
+	if len(req.Args) < 1 || len(req.Args) > 2 {
+		return ephemeral("Usage: `%s macro unshare <name> [#channel]`", botCommand)
+	}
+	name := strings.ToLower(req.Args[0])
+	channel, err := macroTargetChannel(req, "macro unshare")
+	if err != nil {
+		log.Printf("Error picking channel: %v", err)
+		return ephemeral("Sorry, something went wrong removing the macro.")
+	}
+	shared, err := loadMacros(channelMacrosBucket, channel)
+	if err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return ephemeral("Sorry, something went wrong removing the macro.")
+	}
+	m, ok := shared[name]
+	if !ok {
+		return ephemeral("There's no macro called %s shared in <#%s>.", name, channel)
+	}
+	if m.Author != req.UserID && !isAdmin(req.UserID) {
+		return ephemeral("Only <@%s>, who shared %s, or a bot admin can remove it.", m.Author, name)
+	}
+	delete(shared, name)
+	if err := store.Put(channelMacrosBucket, channel, shared); err != nil {
+		log.Printf("Error removing macro: %v", err)
+		return ephemeral("Sorry, something went wrong removing the macro.")
+	}
+	return ephemeral("Removed %s from the macros in <#%s>.", name, channel)
+}
+
+func describeUserMacros(userID string) (string, error) {
+	macros, err := loadMacros(macrosBucket, userID)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for name, m := range macros {
+		items = append(items, fmt.Sprintf("%s: `%s`", name, m.Command))
+	}
+	sort.Strings(items)
+	return describeItems(items), nil
+}
+
+// deleteUserMacros deletes userID's own macros; copies shared in channels
+// belong to the channel
+func deleteUserMacros(userID string) error {
+	return store.Delete(macrosBucket, userID)
+}
@@ -21,28 +21,216 @@ import (
 var slackClient *slack.Client
 var slackSigningSecret string
 
+// User ID of the bot itself, used to ignore events the bot caused
+var botUserID string
+
+// Shared client for outbound HTTP calls to services other than Slack
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// configPathFromEnv is CONFIG_PATH, defaulting to config.yaml
+func configPathFromEnv() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "config.yaml"
+}
+
+// storePath is where the store is kept: STORE_PATH, then the config, then
+// data/bot.json
+func storePath() string {
+	if path := os.Getenv("STORE_PATH"); path != "" {
+		return path
+	}
+	if appConfig.StorePath != "" {
+		return appConfig.StorePath
+	}
+	return "data/bot.json"
+}
+
 func main() {
+	// Offline subcommands that don't need Slack credentials
+	if len(os.Args) > 1 {
+		runCLI(os.Args[1:])
+		return
+	}
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	slackBotToken := os.Getenv("SLACK_BOT_TOKEN")
-	slackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
+	// Load optional feature configuration
+	appConfig, err = loadConfig(configPathFromEnv(), os.Getenv("BOT_PROFILE"))
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if appConfig.Profile != "" {
+		log.Printf("Using config profile %s", appConfig.Profile)
+	}
+
+	// The profile can point at a different Slack app's credentials
+	tokenEnv, secretEnv := appConfig.Slack.BotTokenEnv, appConfig.Slack.SigningSecretEnv
+	if tokenEnv == "" {
+		tokenEnv = "SLACK_BOT_TOKEN"
+	}
+	if secretEnv == "" {
+		secretEnv = "SLACK_SIGNING_SECRET"
+	}
+	slackBotToken := os.Getenv(tokenEnv)
+	slackSigningSecret = os.Getenv(secretEnv)
 	if slackBotToken == "" || slackSigningSecret == "" {
-		log.Fatal("SLACK_BOT_TOKEN and SLACK_SIGNING_SECRET must be set in .env")
+		log.Fatalf("%s and %s must be set in .env", tokenEnv, secretEnv)
+	}
+
+	setupIntents(appConfig.Intents)
+	if err := setupUnfurlProviders(appConfig.Unfurl); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupTranslation(appConfig.Translate); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupFileProcessors(appConfig.Files); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupEventBus(appConfig.EventBus); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupEncryption(appConfig.Encryption); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupBackups(appConfig.Backups); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupKnowledgeBase(appConfig.Knowledge); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupOncall(appConfig.Oncall); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupOOO(appConfig.OOO); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupPrices(appConfig.Prices); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupWeather(appConfig.Weather); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := setupConvert(appConfig.Convert); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	// Open the persistent store
+	store, err = openStore(storePath())
+	if err != nil {
+		log.Fatalf("Error opening store: %v", err)
 	}
 	// Initialize Slack client
-	slackClient = slack.New(slackBotToken)
+	slackClient = slack.New(slackBotToken,
+		slack.OptionHTTPClient(&http.Client{Transport: revocableTransport{slackBudgetTransport{http.DefaultTransport}}}))
 	fmt.Println(slackClient)
+	auth, err := slackClient.AuthTest()
+	if err != nil {
+		log.Fatalf("Error authenticating with Slack: %v", err)
+	}
+	botUserID = auth.UserID
+
+	// A standby instance follows the primary's store until it takes over
+	if err := setupFailover(appConfig.Failover); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	startFailover(appConfig.Failover)
+
+	// Start background jobs
+	startChannelFeed()
+	startViewSessionCleanup()
+	startUsergroupSync()
+	startRateLimitCleanup()
+	startKudosLeaderboards()
+	startOutbox()
+	startStandups()
+	startReminders()
+	startIncidents()
+	startEventWatchdog()
+	startKillSwitches()
+	startApprovals()
+	startCoffee()
+	startLunch()
+	startCelebrations()
+	startOnboardingChecklists()
+	startRetros()
+	startRSVPReminders()
+	startWeeklyDigests()
+	startOOO()
+	startPRReminders()
+	startDeploymentCleanup()
+	startReleaseNotes()
+	startDigests()
+	startSchedules()
+	startUsageTracking()
+	startAuditLog()
+	if appConfig.LLM.Mentions.Enabled {
+		startThreadMemory()
+	}
+	if err := startSLOTracking(appConfig.SLOs); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := startBackups(appConfig.Backups); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
 
+	// Notifications from other services on the event bus, consumed by
+	// whichever instance leads
+	whenLeading(func() {
+		if err := startEventConsumer(appConfig.EventBus); err != nil {
+			log.Fatalf("Error starting event bus consumer: %v", err)
+		}
+	})
+
+	// Internal gRPC interface for other backend services
+	if err := startGRPCServer(appConfig.GRPC); err != nil {
+		log.Fatalf("Error starting gRPC server: %v", err)
+	}
+
+	if err := setupNetworkPolicies(appConfig.Network); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
 	router := gin.Default()
+	if err := router.SetTrustedProxies(appConfig.Network.TrustedProxies); err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	router.Use(countRequests, enforceNetworkPolicy, rateLimit, requireLeader)
+
+	// Heartbeats and store replication between failover instances
+	mountFailoverRoutes(router.Group("/failover"))
 
 	// Use a custom middleware for Slack request verification
-	router.Use(verifySlackRequestMiddleware)
+	slackRoutes := router.Group("/slack", measureSlackAck, verifySlackRequestMiddleware)
 
 	// Slack Events API endpoint
-	router.POST("/slack/events", handleSlackEvents)
+	slackRoutes.POST("/events", handleSlackEvents)
+
+	// Slack interactivity endpoint (buttons, menus, modals)
+	slackRoutes.POST("/interactions", handleSlackInteractions)
+
+	// Options for external_select menus, loaded as the user types
+	slackRoutes.POST("/options", handleSlackOptions)
+
+	// Slash commands endpoint
+	slackRoutes.POST("/commands", handleSlackCommands)
+
+	// Jira issue webhooks, signed with jira.webhook_secret_env
+	router.POST("/jira/webhook", handleJiraWebhook)
+
+	// HTTP API, authenticated with personal API tokens
+	mountAPIRoutes(router.Group("/api/v1"))
+	router.GET("/api/openapi.json", handleOpenAPISpec)
+	if appConfig.API.DevMode {
+		router.GET("/api/docs", handleSwaggerUI)
+	}
+
+	// Admin web UI, signed into with a link from /bot admin web
+	mountWebUI(router.Group("/admin"))
 
 	// Start the Gin server
 	port := os.Getenv("PORT")
@@ -50,11 +238,88 @@ func main() {
 		port = "8080"
 	}
 	log.Printf("Server starting on port :%s", port)
-	if err := router.Run(":" + port); err != nil {
+	if err := serveHTTP(":"+port, router, appConfig.Network.TLS); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
 
+// mentionResponder answers a mention of the bot in its thread, running the
+// command it names if there is one. Replies that aren't meant for the
+// channel only go to the person who mentioned the bot.
+func mentionResponder(event any, out *eventReplies) {
+	ev := event.(*slackevents.AppMentionEvent)
+	reply := replyTo(ev.Channel, ev.TimeStamp, ev.ThreadTimeStamp)
+	if isSummaryRequest(ev.Text) && featureEnabled(featureAIReplies, ev.Channel) {
+		go runJob("thread summary", func() {
+			defer out.done()
+			answerSummaryRequest(ev, reply, out)
+		})
+		return
+	}
+	if resp, ok := commandFromText(ev.User, ev.Channel, reply.ThreadTS, ev.Text); ok {
+		mentionReply(ev, reply, resp, out)
+		out.done()
+		return
+	}
+	if matchesTrigger(ev.Channel, ev.Text) {
+		// The message event for the mention gets the trigger's answer
+		out.done()
+		return
+	}
+	intentLLM := wantsIntentLLM(ev.Channel, ev.Text)
+	if !intentLLM && !wantsMentionLLM(ev.Channel) {
+		mentionFallback(ev, reply, out)
+		out.done()
+		return
+	}
+	// The language model is slow, and the event has to be acked first
+	go runJob("mention", func() {
+		defer out.done()
+		if intentLLM {
+			if resp, ok := intentFromLLM(ev.User, ev.Channel, ev.Text); ok {
+				mentionReply(ev, reply, resp, out)
+				return
+			}
+		}
+		mentionFallback(ev, reply, out)
+	})
+}
+
+// mentionReply answers a mention with resp, in the thread if resp is for
+// the channel and to the person who mentioned the bot if not
+func mentionReply(ev *slackevents.AppMentionEvent, reply outboundMessage, resp commandResponse, out *eventReplies) {
+	reply.Text, reply.Blocks = resp.Text, resp.Blocks
+	var err error
+	if resp.InChannel {
+		_, err = out.post(reply)
+	} else {
+		err = out.postEphemeral(ev.User, reply)
+	}
+	if err != nil {
+		log.Printf("Error replying to mention: %v", err)
+	}
+}
+
+// wantsMentionLLM reports whether mentions in channel that aren't commands
+// are answered by the language model
+func wantsMentionLLM(channel string) bool {
+	return appConfig.LLM.Mentions.Enabled && featureEnabled(featureAIReplies, channel)
+}
+
+// mentionFallback answers a mention that isn't a command, with the
+// language model if it's set up. That's slow, so callers run it in a job
+// when wantsMentionLLM.
+func mentionFallback(ev *slackevents.AppMentionEvent, reply outboundMessage, out *eventReplies) {
+	if wantsMentionLLM(ev.Channel) {
+		answerMentionWithLLM(ev, reply, out)
+		return
+	}
+	reply.Text = fmt.Sprintf("Hello <@%s>! You mentioned me: %s", ev.User, ev.Text)
+	if _, err := out.post(reply); err != nil {
+		log.Printf("Error replying to mention: %v", err)
+	}
+}
+
 // verifySlackRequestMiddleware verifies incoming requests from Slack
 func verifySlackRequestMiddleware(c *gin.Context) {
 	// Read the raw request body
@@ -140,20 +405,65 @@ func handleSlackEvents(c *gin.Context) {
 		return
 	}
 
-	// Handle event callbacks
-	if eventsAPIEvent.Type == slackevents.CallbackEvent {
+	// Handle event callbacks; once the token is revoked they are only acknowledged
+	if eventsAPIEvent.Type == slackevents.CallbackEvent && !slackRevoked.Load() {
 		innerEvent := eventsAPIEvent.InnerEvent
+		countEventReceived()
 		switch ev := innerEvent.Data.(type) {
 		case *slackevents.AppMentionEvent:
 			log.Printf("Received app_mention event: %+v", ev)
-			// Respond to the mention
-			_, _, err := slackClient.PostMessage(
-				ev.Channel,
-				slack.MsgOptionText(fmt.Sprintf("Hello <@%s>! You mentioned me: %s", ev.User, ev.Text), false),
-				slack.MsgOptionAsUser(true), // Post as the bot user
-			)
-			if err != nil {
-				log.Printf("Error posting message to Slack: %v", err)
+			respondToEvent(innerEvent.Type, ev, mentionResponder)
+		case *slackevents.TeamJoinEvent:
+			handleTeamJoin(ev)
+		case *slackevents.AppHomeOpenedEvent:
+			handleAppHomeOpened(ev)
+		case *slackevents.LinkSharedEvent:
+			handleLinkShared(ev)
+		case *slackevents.MemberJoinedChannelEvent:
+			handleMemberJoinedChannel(ev)
+		case *slackevents.ReactionAddedEvent:
+			handleQuickActionReaction(ev)
+			handleExperimentReaction(ev)
+			handleTranslateReaction(ev)
+			handleTimelineReaction(ev)
+		case *slackevents.ChannelCreatedEvent:
+			handleChannelCreated(ev)
+		case *slackevents.ChannelRenameEvent:
+			handleChannelRename(ev)
+		case *slackevents.ChannelArchiveEvent:
+			handleChannelArchive(ev)
+		case *slackevents.ChannelUnarchiveEvent:
+			handleChannelUnarchive(ev)
+		case *slackevents.GroupArchiveEvent:
+			handleGroupArchive(ev)
+		case *slackevents.GroupUnarchiveEvent:
+			handleGroupUnarchive(ev)
+		case *slackevents.GroupLeftEvent:
+			handleGroupLeft(ev)
+		case *slackevents.EmojiChangedEvent:
+			handleEmojiChanged(ev)
+		case *slackevents.SubteamCreatedEvent:
+			handleSubteamCreated(ev)
+		case *slackevents.SubteamUpdatedEvent:
+			handleSubteamUpdated(ev)
+		case *slackevents.PinAddedEvent:
+			handlePinAdded(ev)
+		case *slackevents.PinRemovedEvent:
+			handlePinRemoved(ev)
+		case *slackevents.AppUninstalledEvent:
+			handleAppUninstalled(ev)
+		case *slackevents.TokensRevokedEvent:
+			handleTokensRevoked(ev)
+		case *slackevents.FunctionExecutedEvent:
+			handleFunctionExecuted(ev)
+		case *slackevents.FileSharedEvent:
+			handleFileShared(ev)
+		case *slackevents.MessageEvent:
+			switch ev.SubType {
+			case "", "thread_broadcast":
+				handleNewMessage(ev)
+			case "message_changed", "message_deleted":
+				handleMessageAudit(ev)
 			}
 		default:
 			log.Printf("Unsupported event type: %s", innerEvent.Type)
@@ -163,3 +473,19 @@ func handleSlackEvents(c *gin.Context) {
 	// Acknowledge receipt of the event
 	c.Status(http.StatusOK)
 }
+
+// handleNewMessage runs everything that reacts to a new message, whether it
+// arrived as an event or was replayed by the event watchdog
+func handleNewMessage(ev *slackevents.MessageEvent) {
+	markMessageReceived(ev.Channel, ev.TimeStamp)
+	publishMessageReceived(ev)
+	handleTriggerMessage(ev)
+	handleKarmaMessage(ev)
+	handleExperimentReply(ev)
+	handleThreadMemoryMessage(ev)
+	handleOOOMentions(ev)
+	retroItem := handleRetroMessage(ev)
+	if ev.ChannelType == "im" && !retroItem {
+		handleDirectMessage(ev)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/slack-go/slack"
+)
+
+// outboundMessage describes a message the bot wants to post
+type outboundMessage struct {
+	Channel  string
+	ThreadTS string
+	// Broadcast also shows a thread reply in the channel
+	Broadcast   bool
+	Text        string
+	Blocks      []slack.Block
+	Attachments []slack.Attachment
+	// Importance selects what the channel's routing policy does with the
+	// message
+	Importance importance
+	// NeedTS is set by callers that store the message's timestamp to update
+	// or thread under it later. Routing never suppresses, digests or
+	// redirects such messages, which would leave them without one.
+	NeedTS bool
+	// routed is set once the routing policy has been applied
+	routed bool
+}
+
+// sendMessage posts msg to Slack and returns the timestamp of the new message.
+// Messages over Slack's limits are truncated or split first; when text had to
+// be cut, the full version is shared as a snippet behind a "Show more" button.
+// The channel's routing policy may thread, suppress or redirect it instead.
+func sendMessage(msg outboundMessage) (string, error) {
+	if !msg.routed {
+		if ts, handled, err := routeMessage(msg); handled {
+			return ts, err
+		}
+	}
+	var err error
+	if msg.Text, err = withFallbackText(msg.Text, msg.Blocks); err != nil {
+		return "", fmt.Errorf("posting message to %s: %w", msg.Channel, err)
+	}
+	msg.Text, msg.Blocks = withBanner(msg.Text, msg.Blocks)
+
+	fit := fitMessage(msg)
+	if len(fit.Overflow) > 0 {
+		permalink, err := uploadOverflowSnippet(msg.Channel, fit.Overflow)
+		if err != nil {
+			log.Printf("Error sharing full message, sending truncated version only: %v", err)
+		} else {
+			last := &fit.Parts[len(fit.Parts)-1]
+			if len(last.Blocks) < maxMessageBlocks {
+				last.Blocks = append(last.Blocks, showMoreBlock(permalink))
+			} else {
+				fit.Parts = append(fit.Parts, outboundMessage{
+					Channel:  msg.Channel,
+					ThreadTS: msg.ThreadTS,
+					Text:     last.Text,
+					Blocks:   []slack.Block{showMoreBlock(permalink)},
+				})
+			}
+		}
+	}
+
+	var firstTS string
+	for i, part := range fit.Parts {
+		ts, err := postMessage(part)
+		if err != nil {
+			return firstTS, err
+		}
+		if i == 0 {
+			firstTS = ts
+		}
+	}
+	return firstTS, nil
+}
+
+// postMessage posts a single message that is already within Slack's limits
+func postMessage(msg outboundMessage) (string, error) {
+	opts := []slack.MsgOption{
+		slack.MsgOptionText(msg.Text, false),
+		slack.MsgOptionAsUser(true), // Post as the bot user
+	}
+	if len(msg.Blocks) > 0 {
+		opts = append(opts, slack.MsgOptionBlocks(msg.Blocks...))
+	}
+	if len(msg.Attachments) > 0 {
+		opts = append(opts, slack.MsgOptionAttachments(msg.Attachments...))
+	}
+	if msg.ThreadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(msg.ThreadTS))
+		if msg.Broadcast {
+			opts = append(opts, slack.MsgOptionBroadcast())
+		}
+	}
+	_, ts, err := slackClient.PostMessage(msg.Channel, opts...)
+	if err != nil {
+		err = fmt.Errorf("posting message to %s: %w", msg.Channel, err)
+	}
+	recordDelivery(msg, routePost, ts, err)
+	return ts, err
+}
+
+// updateMessage replaces the text of a message the bot posted earlier
+func updateMessage(channel, ts, text string) error {
+	text, _ = withBanner(text, nil)
+	text, _ = truncateText(text, maxMessageText)
+	if _, _, _, err := slackClient.UpdateMessage(channel, ts, slack.MsgOptionText(text, false)); err != nil {
+		return fmt.Errorf("updating message %s in %s: %w", ts, channel, err)
+	}
+	return nil
+}
+
+// sendEphemeral shows msg only to userID, in msg.Channel (and msg.ThreadTS if
+// set). Use it for help, errors and permission denials that nobody else in
+// the channel needs to see. Ephemeral messages can't be split, so blocks
+// over Slack's limits are dropped and long text is truncated.
+func sendEphemeral(userID string, msg outboundMessage) error {
+	var err error
+	if msg.Text, err = withFallbackText(msg.Text, msg.Blocks); err != nil {
+		return fmt.Errorf("posting ephemeral message to %s: %w", msg.Channel, err)
+	}
+	msg.Text, msg.Blocks = withBanner(msg.Text, msg.Blocks)
+	msg.Text, _ = truncateText(msg.Text, maxMessageText)
+	opts := []slack.MsgOption{slack.MsgOptionText(msg.Text, false)}
+	if len(msg.Blocks) > 0 {
+		opts = append(opts, slack.MsgOptionBlocks(fitResponseBlocks(msg.Blocks)...))
+	}
+	if msg.ThreadTS != "" {
+		opts = append(opts, slack.MsgOptionTS(msg.ThreadTS))
+	}
+	if _, err := slackClient.PostEphemeral(msg.Channel, userID, opts...); err != nil {
+		return fmt.Errorf("posting ephemeral message to %s: %w", msg.Channel, err)
+	}
+	return nil
+}
+
+// replyTo starts a reply to the message at ts in channel, threaded under the
+// message's thread or the message itself. Whether the reply is also
+// broadcast to the channel follows replies.broadcast in config.
+func replyTo(channel, ts, threadTS string) outboundMessage {
+	if threadTS == "" {
+		threadTS = ts
+	}
+	return outboundMessage{Channel: channel, ThreadTS: threadTS, Broadcast: appConfig.Replies.Broadcast}
+}
+
+// respond replies through an interaction's response_url, which works for
+// both regular and ephemeral messages. Blocks are fitted to Slack's limits;
+// there is no second message to split into, so extra blocks are dropped.
+func respond(responseURL string, msg *slack.WebhookMessage) error {
+	if msg.Blocks != nil {
+		var err error
+		if msg.Text, err = withFallbackText(msg.Text, msg.Blocks.BlockSet); err != nil {
+			return fmt.Errorf("responding to interaction: %w", err)
+		}
+	}
+	if msg.Blocks != nil {
+		var blocks []slack.Block
+		msg.Text, blocks = withBanner(msg.Text, msg.Blocks.BlockSet)
+		msg.Blocks = &slack.Blocks{BlockSet: fitResponseBlocks(blocks)}
+	} else {
+		msg.Text, _ = withBanner(msg.Text, nil)
+	}
+	msg.Text, _ = truncateText(msg.Text, maxMessageText)
+	if err := slack.PostWebhookCustomHTTP(responseURL, httpClient, msg); err != nil {
+		return fmt.Errorf("responding to interaction: %w", err)
+	}
+	return nil
+}
+
+// openDM returns the ID of the direct message channel with userID
+func openDM(userID string) (string, error) {
+	channel, _, _, err := slackClient.OpenConversation(&slack.OpenConversationParameters{
+		Users: []string{userID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("opening DM with %s: %w", userID, err)
+	}
+	return channel.ID, nil
+}
+
+// fetchMessage returns the message at ts in channel, including thread replies
+func fetchMessage(channel, ts string) (*slack.Message, error) {
+	history, err := slackClient.GetConversationHistory(&slack.GetConversationHistoryParameters{
+		ChannelID: channel,
+		Latest:    ts,
+		Oldest:    ts,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching message %s in %s: %w", ts, channel, err)
+	}
+	if len(history.Messages) > 0 && history.Messages[0].Timestamp == ts {
+		return &history.Messages[0], nil
+	}
+
+	// Thread replies don't show up in the channel history
+	replies, _, _, err := slackClient.GetConversationReplies(&slack.GetConversationRepliesParameters{
+		ChannelID: channel,
+		Timestamp: ts,
+		Latest:    ts,
+		Oldest:    ts,
+		Inclusive: true,
+		Limit:     1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching message %s in %s: %w", ts, channel, err)
+	}
+	for i := range replies {
+		if replies[i].Timestamp == ts {
+			return &replies[i], nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found in %s", ts, channel)
+}
+
+// renderTemplate executes a text/template taken from config against data
+func renderTemplate(name, text string, data any) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// plural formats n with noun, adding an s unless n is 1. Irregular nouns
+// pass their plural too, like plural(n, "person", "people").
+func plural(n int, noun string, nouns ...string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	if len(nouns) > 0 {
+		return fmt.Sprintf("%d %s", n, nouns[0])
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// Slack reads &, < and > as markup in mrkdwn and plain text alike
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeMrkdwn keeps text from outside Slack, like a search or an API's
+// answer, from being read as links or mentions
+func escapeMrkdwn(s string) string {
+	return mrkdwnEscaper.Replace(s)
+}
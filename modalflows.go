@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Callback ID shared by every view of a modal flow
+const modalFlowCallbackID = "modal_flow"
+
+// Store bucket for in-progress flows, keyed by the root view ID that every
+// view of the flow's modal stack shares
+const viewSessionsBucket = "view_sessions"
+
+// How long an untouched flow is kept before it is cleaned up
+const viewSessionTTL = 24 * time.Hour
+
+// Slack keeps at most this many modals stacked; later steps replace the top one
+const maxModalStack = 3
+
+// modalFlow is a chain of modal steps that collects a form one page at a time
+type modalFlow struct {
+	Name  string
+	Title string
+	// First is the name of the opening step
+	First string
+	Steps map[string]*modalStep
+	// Complete runs when the last step is submitted; field errors it returns
+	// are shown on the last step
+	Complete func(callback *slack.InteractionCallback, state *modalFlowState) map[string]string
+}
+
+// modalStep is one view of a flow. Input block IDs double as field names.
+type modalStep struct {
+	Submit string
+	Blocks func(state *modalFlowState) []slack.Block
+	// Validate returns error messages keyed by block ID; empty means valid
+	Validate func(values map[string]string, state *modalFlowState) map[string]string
+	// Next picks the following step from the values so far; "" (or a nil
+	// Next) makes this the last step
+	Next func(state *modalFlowState) string
+}
+
+// modalFlowState is where a flow is and the values collected so far. Flow,
+// Step and Depth travel in each view's private_metadata, so going back to an
+// earlier modal resumes at its step; Values are kept server-side.
+type modalFlowState struct {
+	Flow   string            `json:"flow"`
+	Step   string            `json:"step"`
+	Depth  int               `json:"depth"`
+	Values map[string]string `json:"-"`
+}
+
+// viewSession is the stored part of an in-progress flow, so flows survive
+// restarts and work whichever instance Slack's request reaches
+type viewSession struct {
+	Flow    string            `json:"flow"`
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// Registered flows, keyed by name
+var modalFlows = map[string]*modalFlow{}
+
+// registerModalFlow makes flow available to openModalFlow
+func registerModalFlow(flow *modalFlow) {
+	modalFlows[flow.Name] = flow
+}
+
+func init() {
+	registerViewSubmission(modalFlowCallbackID, handleModalFlowSubmission)
+	registerViewClosed(modalFlowCallbackID, handleModalFlowClosed)
+}
+
+// startViewSessionCleanup periodically deletes flows that were abandoned
+// without Slack telling us
+func startViewSessionCleanup() {
+	runEvery("view session cleanup", 10*time.Minute, cleanupViewSessions)
+}
+
+func cleanupViewSessions() {
+	for _, key := range store.Keys(viewSessionsBucket) {
+		var session viewSession
+		if _, err := store.Get(viewSessionsBucket, key, &session); err != nil {
+			log.Printf("Error loading view session: %v", err)
+			continue
+		}
+		if time.Now().After(session.Expires) {
+			if err := store.Delete(viewSessionsBucket, key); err != nil {
+				log.Printf("Error deleting view session: %v", err)
+			}
+		}
+	}
+}
+
+func saveViewSession(rootViewID string, state *modalFlowState) error {
+	return store.Put(viewSessionsBucket, rootViewID, viewSession{
+		Flow:    state.Flow,
+		Values:  state.Values,
+		Expires: time.Now().Add(viewSessionTTL),
+	})
+}
+
+// openModalFlow opens the first step of the named flow
+func openModalFlow(triggerID, name string, initial map[string]string) error {
+	flow, ok := modalFlows[name]
+	if !ok {
+		return fmt.Errorf("unknown modal flow %q", name)
+	}
+	if initial == nil {
+		initial = map[string]string{}
+	}
+	state := &modalFlowState{Flow: name, Step: flow.First, Depth: 1, Values: initial}
+	view, err := modalFlowView(flow, state)
+	if err != nil {
+		return err
+	}
+	resp, err := slackClient.OpenView(triggerID, view)
+	if err != nil {
+		return fmt.Errorf("opening %s modal: %w", name, err)
+	}
+	return saveViewSession(resp.ID, state)
+}
+
+// modalFlowView renders the current step of state
+func modalFlowView(flow *modalFlow, state *modalFlowState) (slack.ModalViewRequest, error) {
+	step, ok := flow.Steps[state.Step]
+	if !ok {
+		return slack.ModalViewRequest{}, fmt.Errorf("modal flow %s has no step %q", flow.Name, state.Step)
+	}
+	metadata, err := json.Marshal(state)
+	if err != nil {
+		return slack.ModalViewRequest{}, fmt.Errorf("encoding modal flow state: %w", err)
+	}
+	submit := step.Submit
+	if submit == "" {
+		submit = "Next"
+		if step.Next == nil {
+			submit = "Submit"
+		}
+	}
+	title, _ := truncateText(flow.Title, 24)
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      modalFlowCallbackID,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, title, false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, submit, false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		PrivateMetadata: string(metadata),
+		NotifyOnClose:   true,
+		Blocks:          slack.Blocks{BlockSet: fitViewBlocks(step.Blocks(state))},
+	}, nil
+}
+
+// handleModalFlowSubmission validates a step and moves the flow on: to the
+// next step, or through Complete when it was the last one
+func handleModalFlowSubmission(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	var state modalFlowState
+	if err := json.Unmarshal([]byte(callback.View.PrivateMetadata), &state); err != nil {
+		log.Printf("Error decoding modal flow state: %v", err)
+		return nil
+	}
+	flow, ok := modalFlows[state.Flow]
+	if !ok {
+		log.Printf("Unknown modal flow: %s", state.Flow)
+		return nil
+	}
+	step, ok := flow.Steps[state.Step]
+	if !ok {
+		log.Printf("Modal flow %s has no step %q", flow.Name, state.Step)
+		return nil
+	}
+	var session viewSession
+	found, err := store.Get(viewSessionsBucket, callback.View.RootViewID, &session)
+	if err != nil {
+		log.Printf("Error loading view session: %v", err)
+		return nil
+	}
+	if !found || session.Flow != state.Flow {
+		// Expired, or opened before a restart wiped it; nothing to resume
+		log.Printf("No session for %s modal %s", state.Flow, callback.View.RootViewID)
+		return slack.NewClearViewSubmissionResponse()
+	}
+	state.Values = session.Values
+	if state.Values == nil {
+		state.Values = map[string]string{}
+	}
+
+	values := viewValues(callback.View.State)
+	if step.Validate != nil {
+		if errs := step.Validate(values, &state); len(errs) > 0 {
+			return slack.NewErrorsViewSubmissionResponse(errs)
+		}
+	}
+	for field, value := range values {
+		state.Values[field] = value
+	}
+
+	next := ""
+	if step.Next != nil {
+		next = step.Next(&state)
+	}
+	if next == "" {
+		if flow.Complete != nil {
+			if errs := flow.Complete(callback, &state); len(errs) > 0 {
+				return slack.NewErrorsViewSubmissionResponse(errs)
+			}
+		}
+		if err := store.Delete(viewSessionsBucket, callback.View.RootViewID); err != nil {
+			log.Printf("Error deleting view session: %v", err)
+		}
+		return slack.NewClearViewSubmissionResponse()
+	}
+	if err := saveViewSession(callback.View.RootViewID, &state); err != nil {
+		log.Printf("Error saving view session: %v", err)
+		return nil
+	}
+
+	state.Step = next
+	push := state.Depth < maxModalStack
+	if push {
+		state.Depth++
+	}
+	view, err := modalFlowView(flow, &state)
+	if err != nil {
+		log.Printf("Error building modal flow step: %v", err)
+		return nil
+	}
+	if push {
+		return slack.NewPushViewSubmissionResponse(&view)
+	}
+	return slack.NewUpdateViewSubmissionResponse(&view)
+}
+
+// handleModalFlowClosed forgets a flow once its whole modal stack is closed
+func handleModalFlowClosed(callback *slack.InteractionCallback) {
+	if !callback.IsCleared && callback.View.ID != callback.View.RootViewID {
+		// Only a pushed view was closed; the flow goes on underneath
+		return
+	}
+	if err := store.Delete(viewSessionsBucket, callback.View.RootViewID); err != nil {
+		log.Printf("Error deleting view session: %v", err)
+	}
+}
+
+// viewValues flattens a submitted view's inputs into block ID → value. Lists
+// of selected items, and the IDs of uploaded files, are joined with commas.
+func viewValues(state *slack.ViewState) map[string]string {
+	values := map[string]string{}
+	if state == nil {
+		return values
+	}
+	for blockID, actions := range state.Values {
+		for _, action := range actions {
+			values[blockID] = blockActionValue(action)
+		}
+	}
+	return values
+}
+
+func blockActionValue(action slack.BlockAction) string {
+	switch {
+	case action.Value != "":
+		return action.Value
+	case action.SelectedOption.Value != "":
+		return action.SelectedOption.Value
+	case len(action.SelectedOptions) > 0:
+		var selected []string
+		for _, option := range action.SelectedOptions {
+			selected = append(selected, option.Value)
+		}
+		return strings.Join(selected, ",")
+	case action.SelectedUser != "":
+		return action.SelectedUser
+	case len(action.SelectedUsers) > 0:
+		return strings.Join(action.SelectedUsers, ",")
+	case action.SelectedChannel != "":
+		return action.SelectedChannel
+	case len(action.SelectedChannels) > 0:
+		return strings.Join(action.SelectedChannels, ",")
+	case action.SelectedConversation != "":
+		return action.SelectedConversation
+	case len(action.SelectedConversations) > 0:
+		return strings.Join(action.SelectedConversations, ",")
+	case action.SelectedDate != "":
+		return action.SelectedDate
+	case action.SelectedTime != "":
+		return action.SelectedTime
+	case action.SelectedDateTime != 0:
+		return strconv.FormatInt(action.SelectedDateTime, 10)
+	case len(action.Files) > 0:
+		var ids []string
+		for _, file := range action.Files {
+			ids = append(ids, file.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/slack-go/slack"
+)
+
+// Action IDs of the buttons in the Home tab's "Your data" section
+const (
+	actionMyDataDelete    = "mydata_delete"
+	actionMyDataDeleteAll = "mydata_delete_all"
+	actionMyDataHide      = "mydata_hide"
+)
+
+// userDataset is one kind of data the bot keeps about people, shown and
+// deleted through /bot mydata
+type userDataset struct {
+	Name  string
+	Title string
+	// Describe summarizes what is stored about userID; "" means nothing
+	Describe func(userID string) (string, error)
+	// Delete removes it all. Nil means the data can't be deleted by the
+	// user, like audit records kept for admins.
+	Delete func(userID string) error
+}
+
+var (
+	userDatasets []userDataset
+
+	// Users who asked to see their data in the Home tab
+	myDataShownMu sync.Mutex
+	myDataShown   = map[string]bool{}
+)
+
+// registerUserDataset adds a dataset to /bot mydata
+func registerUserDataset(ds userDataset) {
+	userDatasets = append(userDatasets, ds)
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "mydata",
+		Usage:       "mydata",
+		Description: "See everything I store about you, and delete it",
+		Handler:     handleMyData,
+	})
+	registerHomeSection(myDataHomeSection)
+	registerBlockAction(actionMyDataDelete, handleMyDataDelete)
+	registerBlockAction(actionMyDataDeleteAll, handleMyDataDelete)
+	registerBlockAction(actionMyDataHide, handleMyDataHide)
+}
+
+func handleMyData(req commandRequest) commandResponse {
+	myDataShownMu.Lock()
+	myDataShown[req.UserID] = true
+	myDataShownMu.Unlock()
+	if err := publishHome(req.UserID); err != nil {
+		log.Printf("Error publishing home view: %v", err)
+		return ephemeral("Sorry, something went wrong showing your data.")
+	}
+	return ephemeral("Everything I store about you is now in <slack://app?team=%s&id=%s&tab=home|my Home tab>, where you can delete it.", req.TeamID, req.APIAppID)
+}
+
+// myDataHomeSection lists the user's data with delete buttons, once they've
+// asked for it with /bot mydata
+func myDataHomeSection(userID string) []slack.Block {
+	myDataShownMu.Lock()
+	shown := myDataShown[userID]
+	myDataShownMu.Unlock()
+	if !shown {
+		return nil
+	}
+
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Your data", false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "Everything I store about you. Deleting can't be undone.", false, false)),
+	}
+	stored := 0
+	for _, ds := range userDatasets {
+		summary, err := ds.Describe(userID)
+		if err != nil {
+			log.Printf("Error describing %s for %s: %v", ds.Name, userID, err)
+			summary = "_Couldn't be loaded right now._"
+		}
+		if summary == "" {
+			continue
+		}
+		stored++
+		var accessory *slack.Accessory
+		if ds.Delete != nil {
+			button := slack.NewButtonBlockElement(actionMyDataDelete, ds.Name, slack.NewTextBlockObject(slack.PlainTextType, "Delete", false, false)).
+				WithStyle(slack.StyleDanger).
+				WithConfirm(myDataConfirm("Delete your "+strings.ToLower(ds.Title)+"?", "This can't be undone."))
+			accessory = slack.NewAccessory(button)
+		} else {
+			summary += "\n_Kept for the workspace admins; ask them if this should be removed._"
+		}
+		text, _ := truncateText("*"+ds.Title+"*\n"+summary, maxSectionText)
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, accessory))
+	}
+	if stored == 0 {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "I don't store anything about you.", false, false), nil, nil))
+	}
+
+	elements := []slack.BlockElement{
+		slack.NewButtonBlockElement(actionMyDataHide, "hide", slack.NewTextBlockObject(slack.PlainTextType, "Hide", false, false)),
+	}
+	if stored > 0 {
+		elements = append([]slack.BlockElement{
+			slack.NewButtonBlockElement(actionMyDataDeleteAll, "all", slack.NewTextBlockObject(slack.PlainTextType, "Delete everything about me", false, false)).
+				WithStyle(slack.StyleDanger).
+				WithConfirm(myDataConfirm("Delete everything about you?", "All of the data above that you can delete goes, for good.")),
+		}, elements...)
+	}
+	return append(blocks, slack.NewActionBlock("", elements...))
+}
+
+func myDataConfirm(title, text string) *slack.ConfirmationBlockObject {
+	return slack.NewConfirmationBlockObject(
+		slack.NewTextBlockObject(slack.PlainTextType, title, false, false),
+		slack.NewTextBlockObject(slack.PlainTextType, text, false, false),
+		slack.NewTextBlockObject(slack.PlainTextType, "Delete", false, false),
+		slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+	).WithStyle(slack.StyleDanger)
+}
+
+// handleMyDataDelete deletes one dataset, or every deletable one, once the
+// user has confirmed in Slack's dialog
+func handleMyDataDelete(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	userID := callback.User.ID
+	var failed []string
+	for _, ds := range userDatasets {
+		if ds.Delete == nil || (action.ActionID == actionMyDataDelete && ds.Name != action.Value) {
+			continue
+		}
+		if err := ds.Delete(userID); err != nil {
+			log.Printf("Error deleting %s for %s: %v", ds.Name, userID, err)
+			failed = append(failed, strings.ToLower(ds.Title))
+			continue
+		}
+		log.Printf("Deleted %s for %s at their request", ds.Name, userID)
+	}
+	if len(failed) > 0 {
+		channel, err := openDM(userID)
+		if err == nil {
+			_, err = sendMessage(outboundMessage{Channel: channel, Text: fmt.Sprintf("Sorry, I couldn't delete your %s. Please try again.", strings.Join(failed, ", "))})
+		}
+		if err != nil {
+			log.Printf("Error reporting failed data deletion: %v", err)
+		}
+	}
+	if err := publishHome(userID); err != nil {
+		log.Printf("Error publishing home view: %v", err)
+	}
+}
+
+func handleMyDataHide(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	myDataShownMu.Lock()
+	delete(myDataShown, callback.User.ID)
+	myDataShownMu.Unlock()
+	if err := publishHome(callback.User.ID); err != nil {
+		log.Printf("Error publishing home view: %v", err)
+	}
+}
+
+// describeItems summarizes a list as a count and the first few entries
+func describeItems(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	const shown = 3
+	text := fmt.Sprintf("%d in total", len(items))
+	for i, item := range items {
+		if i == shown {
+			text += fmt.Sprintf("\n…and %d more", len(items)-shown)
+			break
+		}
+		short, _ := truncateText(item, 100)
+		text += "\n• " + short
+	}
+	return text
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// networkPolicy is a NetworkPolicy with its CIDRs parsed
+type networkPolicy struct {
+	NetworkPolicy
+	nets []*net.IPNet
+}
+
+// Configured policies, longest path prefix first
+var networkPolicies []networkPolicy
+
+// setupNetworkPolicies parses the configured per-route network policies
+func setupNetworkPolicies(cfg NetworkConfig) error {
+	networkPolicies = nil
+	for _, p := range cfg.Policies {
+		if p.PathPrefix == "" {
+			return fmt.Errorf("network policies need a path_prefix")
+		}
+		if p.ClientCert && cfg.TLS.ClientCAFile == "" {
+			return fmt.Errorf("network policy for %s needs network.tls.client_ca_file to check client certificates", p.PathPrefix)
+		}
+		policy := networkPolicy{NetworkPolicy: p}
+		for _, cidr := range p.AllowCIDRs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("network policy for %s: %w", p.PathPrefix, err)
+			}
+			policy.nets = append(policy.nets, ipNet)
+		}
+		networkPolicies = append(networkPolicies, policy)
+	}
+	slices.SortStableFunc(networkPolicies, func(a, b networkPolicy) int {
+		return len(b.PathPrefix) - len(a.PathPrefix)
+	})
+	return nil
+}
+
+// enforceNetworkPolicy rejects requests that the policy for their path
+// doesn't allow, before any other handler runs
+func enforceNetworkPolicy(c *gin.Context) {
+	path := c.Request.URL.Path
+	idx := slices.IndexFunc(networkPolicies, func(p networkPolicy) bool { return strings.HasPrefix(path, p.PathPrefix) })
+	if idx < 0 {
+		c.Next()
+		return
+	}
+	policy := networkPolicies[idx]
+
+	if len(policy.nets) > 0 {
+		ip := net.ParseIP(c.ClientIP())
+		if ip == nil || !slices.ContainsFunc(policy.nets, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+			log.Printf("Rejected %s %s from %s: address not allowed", c.Request.Method, path, c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Address not allowed"})
+			return
+		}
+	}
+	if policy.ClientCert {
+		state := c.Request.TLS
+		if state == nil || len(state.VerifiedChains) == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Client certificate required"})
+			return
+		}
+		name := state.VerifiedChains[0][0].Subject.CommonName
+		if len(policy.AllowedClients) > 0 && !slices.Contains(policy.AllowedClients, name) {
+			log.Printf("Rejected %s %s from client certificate %q", c.Request.Method, path, name)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Client certificate not allowed"})
+			return
+		}
+	}
+	c.Next()
+}
+
+// serveHTTP serves handler on addr, over TLS when network.tls is configured.
+// Client certificates are requested but optional, since Slack doesn't send
+// one; policies with client_cert require them.
+func serveHTTP(addr string, handler http.Handler, cfg TLSConfig) error {
+	if cfg.CertFile == "" {
+		return http.ListenAndServe(addr, handler)
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	return server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
+
+// loadCertPool reads the PEM certificates in path
+func loadCertPool(path string) (*x509.CertPool, error) {
+	caPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
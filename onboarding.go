@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Used when onboarding is enabled but no message template is configured
+const defaultOnboardingMessage = "Welcome aboard, <@{{.UserID}}>! Here are a few places to get started."
+
+// onboardingData is the data available to the onboarding message template
+type onboardingData struct {
+	UserID   string
+	Name     string
+	RealName string
+}
+
+// handleTeamJoin sends the configured welcome DM to a new workspace member
+func handleTeamJoin(ev *slackevents.TeamJoinEvent) {
+	cfg := appConfig.Onboarding
+	if !cfg.Enabled || ev.User == nil || ev.User.IsBot {
+		return
+	}
+	startOnboardingChecklist(ev.User.ID)
+
+	msg, err := buildOnboardingMessage(cfg, ev.User)
+	if err != nil {
+		log.Printf("Error building onboarding message: %v", err)
+		return
+	}
+	msg.Channel, err = openDM(ev.User.ID)
+	if err != nil {
+		log.Printf("Error sending onboarding message: %v", err)
+		return
+	}
+	msg.NeedTS = true
+	ts, err := sendMessage(msg)
+	if err != nil {
+		log.Printf("Error sending onboarding message: %v", err)
+		return
+	}
+	if variant, ok := experimentVariant(cfg.Experiment, ev.User.ID, ""); ok {
+		recordExposure(cfg.Experiment, variant.Name, msg.Channel, ts)
+	}
+}
+
+// buildOnboardingMessage renders the welcome text and link buttons for user
+func buildOnboardingMessage(cfg OnboardingConfig, user *slack.User) (outboundMessage, error) {
+	text := cfg.Message
+	if variant, ok := experimentVariant(cfg.Experiment, user.ID, ""); ok && variant.Template != "" {
+		text = variant.Template
+	}
+	if text == "" {
+		text = defaultOnboardingMessage
+	}
+	text, err := renderTemplate("onboarding", text, onboardingData{
+		UserID:   user.ID,
+		Name:     user.Name,
+		RealName: user.RealName,
+	})
+	if err != nil {
+		return outboundMessage{}, err
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+	}
+	if len(cfg.Links) > 0 {
+		var buttons []slack.BlockElement
+		for i, link := range cfg.Links {
+			btn := slack.NewButtonBlockElement(
+				fmt.Sprintf("onboarding_link_%d", i),
+				link.URL,
+				slack.NewTextBlockObject(slack.PlainTextType, link.Text, false, false),
+			)
+			btn.URL = link.URL
+			buttons = append(buttons, btn)
+		}
+		blocks = append(blocks, slack.NewActionBlock("onboarding_links", buttons...))
+	}
+
+	return outboundMessage{Text: text, Blocks: blocks}, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for new members' checklists, keyed by user ID
+const onboardingChecklistsBucket = "onboarding_checklists"
+
+// Action IDs of the checklist buttons in the Home tab
+const (
+	actionOnboardingTaskDone = "onboarding_task_done"
+	actionOnboardingTaskUndo = "onboarding_task_undo"
+)
+
+// Used when onboarding.nudge_after isn't set
+const defaultOnboardingNudgeAfter = 7 * 24 * time.Hour
+
+// onboardingChecklist is a new member's progress through
+// onboarding.checklist
+type onboardingChecklist struct {
+	User      string    `json:"user"`
+	StartedAt time.Time `json:"started_at"`
+	// Done is when each task was done, keyed by task ID
+	Done   map[string]time.Time `json:"done"`
+	Nudged bool                 `json:"nudged,omitempty"`
+}
+
+func init() {
+	registerHomeSection(onboardingChecklistHomeSection)
+	registerBlockAction(actionOnboardingTaskDone, handleOnboardingTaskClick)
+	registerBlockAction(actionOnboardingTaskUndo, handleOnboardingTaskClick)
+	registerUserDataset(userDataset{Name: "onboarding_checklist", Title: "Onboarding checklist", Describe: describeUserOnboardingChecklist, Delete: deleteUserOnboardingChecklist})
+}
+
+// startOnboardingChecklists nudges new members who haven't finished their
+// checklist after onboarding.nudge_after
+func startOnboardingChecklists() {
+	if len(appConfig.Onboarding.Checklist) == 0 {
+		return
+	}
+	runEvery("onboarding checklist nudges", time.Hour, nudgeOnboardingChecklists)
+}
+
+// startOnboardingChecklist gives a new member the checklist
+func startOnboardingChecklist(userID string) {
+	if len(appConfig.Onboarding.Checklist) == 0 {
+		return
+	}
+	checklist := onboardingChecklist{User: userID, StartedAt: time.Now().UTC(), Done: map[string]time.Time{}}
+	if err := store.Put(onboardingChecklistsBucket, userID, checklist); err != nil {
+		log.Printf("Error saving onboarding checklist: %v", err)
+	}
+}
+
+// loadOnboardingChecklist returns userID's checklist, or nil if they
+// don't have one
+func loadOnboardingChecklist(userID string) (*onboardingChecklist, error) {
+	checklist := &onboardingChecklist{}
+	found, err := store.Get(onboardingChecklistsBucket, userID, checklist)
+	if err != nil || !found {
+		return nil, err
+	}
+	if checklist.Done == nil {
+		checklist.Done = map[string]time.Time{}
+	}
+	return checklist, nil
+}
+
+func onboardingTask(id string) (OnboardingTask, bool) {
+	i := slices.IndexFunc(appConfig.Onboarding.Checklist, func(t OnboardingTask) bool { return t.ID == id })
+	if i < 0 {
+		return OnboardingTask{}, false
+	}
+	return appConfig.Onboarding.Checklist[i], true
+}
+
+// remaining returns the tasks not done yet
+func (c *onboardingChecklist) remaining() []OnboardingTask {
+	var tasks []OnboardingTask
+	for _, task := range appConfig.Onboarding.Checklist {
+		if _, done := c.Done[task.ID]; !done {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// checkOnboardingTasks ticks off the tasks the bot can see are done:
+// channels joined and a profile photo set. It reports whether any changed.
+func (c *onboardingChecklist) checkOnboardingTasks() bool {
+	changed := false
+	var user *slack.User
+	for _, task := range c.remaining() {
+		done := false
+		switch {
+		case task.Channel != "":
+			member, err := isChannelMember(task.Channel, c.User)
+			if err != nil {
+				log.Printf("Error checking onboarding task %s: %v", task.ID, err)
+			}
+			done = member
+		case task.ProfilePhoto:
+			if user == nil {
+				var err error
+				if user, err = slackClient.GetUserInfo(c.User); err != nil {
+					log.Printf("Error checking onboarding task %s: %v", task.ID, err)
+					continue
+				}
+			}
+			// Slack's generated avatars have no original image
+			done = user.Profile.ImageOriginal != ""
+		}
+		if done {
+			c.Done[task.ID], changed = time.Now().UTC(), true
+		}
+	}
+	return changed
+}
+
+// markOnboardingChannelJoined ticks off tasks to join channel
+func markOnboardingChannelJoined(userID, channel string) {
+	if !slices.ContainsFunc(appConfig.Onboarding.Checklist, func(t OnboardingTask) bool { return t.Channel == channel }) {
+		return
+	}
+	checklist, err := loadOnboardingChecklist(userID)
+	if err != nil {
+		log.Printf("Error loading onboarding checklist: %v", err)
+		return
+	}
+	if checklist == nil {
+		return
+	}
+	changed := false
+	for _, task := range checklist.remaining() {
+		if task.Channel == channel {
+			checklist.Done[task.ID], changed = time.Now().UTC(), true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := store.Put(onboardingChecklistsBucket, userID, checklist); err != nil {
+		log.Printf("Error saving onboarding checklist: %v", err)
+		return
+	}
+	refreshHome(userID)
+}
+
+// onboardingChecklistHomeSection shows a new member's checklist until
+// every task is done
+func onboardingChecklistHomeSection(userID string) []slack.Block {
+	checklist, err := loadOnboardingChecklist(userID)
+	if err != nil {
+		log.Printf("Error loading onboarding checklist: %v", err)
+		return nil
+	}
+	if checklist == nil || len(appConfig.Onboarding.Checklist) == 0 {
+		return nil
+	}
+	if checklist.checkOnboardingTasks() {
+		if err := store.Put(onboardingChecklistsBucket, userID, checklist); err != nil {
+			log.Printf("Error saving onboarding checklist: %v", err)
+		}
+	}
+	remaining := len(checklist.remaining())
+	if remaining == 0 {
+		return nil
+	}
+
+	total := len(appConfig.Onboarding.Checklist)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Getting started", false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("%d of %d done", total-remaining, total), false, false)),
+	}
+	for _, task := range appConfig.Onboarding.Checklist {
+		text := ":white_large_square: " + onboardingTaskText(task)
+		button := slack.NewButtonBlockElement(actionOnboardingTaskDone, task.ID, slack.NewTextBlockObject(slack.PlainTextType, "Done", false, false))
+		if _, done := checklist.Done[task.ID]; done {
+			text = ":white_check_mark: ~" + onboardingTaskText(task) + "~"
+			button = slack.NewButtonBlockElement(actionOnboardingTaskUndo, task.ID, slack.NewTextBlockObject(slack.PlainTextType, "Undo", false, false))
+		}
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, slack.NewAccessory(button)))
+	}
+	return blocks
+}
+
+// onboardingTaskText is a task's text, with its channel or link
+func onboardingTaskText(task OnboardingTask) string {
+	text := task.Text
+	if task.Channel != "" && !strings.Contains(text, task.Channel) {
+		text += " <#" + task.Channel + ">"
+	}
+	if task.URL != "" {
+		text = "<" + task.URL + "|" + text + ">"
+	}
+	return text
+}
+
+// handleOnboardingTaskClick ticks a task off, or back on, from the Home tab
+func handleOnboardingTaskClick(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	userID := callback.User.ID
+	if _, ok := onboardingTask(action.Value); !ok {
+		return
+	}
+	checklist, err := loadOnboardingChecklist(userID)
+	if err != nil || checklist == nil {
+		if err != nil {
+			log.Printf("Error loading onboarding checklist: %v", err)
+		}
+		return
+	}
+	if action.ActionID == actionOnboardingTaskDone {
+		checklist.Done[action.Value] = time.Now().UTC()
+	} else {
+		delete(checklist.Done, action.Value)
+	}
+	if err := store.Put(onboardingChecklistsBucket, userID, checklist); err != nil {
+		log.Printf("Error saving onboarding checklist: %v", err)
+		return
+	}
+	if err := publishHome(userID); err != nil {
+		log.Printf("Error refreshing home view: %v", err)
+	}
+}
+
+// nudgeOnboardingChecklists DMs new members whose checklist is still
+// unfinished after onboarding.nudge_after, once
+func nudgeOnboardingChecklists() {
+	after := appConfig.Onboarding.NudgeAfter
+	if after <= 0 {
+		after = defaultOnboardingNudgeAfter
+	}
+	checklists, err := storeList[onboardingChecklist](store, onboardingChecklistsBucket)
+	if err != nil {
+		log.Printf("Error listing onboarding checklists: %v", err)
+		return
+	}
+	for _, checklist := range checklists {
+		if checklist.Nudged || time.Since(checklist.StartedAt) < after {
+			continue
+		}
+		if checklist.Done == nil {
+			checklist.Done = map[string]time.Time{}
+		}
+		checklist.checkOnboardingTasks()
+		checklist.Nudged = true
+		if err := store.Put(onboardingChecklistsBucket, checklist.User, checklist); err != nil {
+			log.Printf("Error saving onboarding checklist: %v", err)
+			continue
+		}
+		remaining := checklist.remaining()
+		if len(remaining) == 0 {
+			continue
+		}
+		lines := []string{fmt.Sprintf(":wave: How's your first week going, <@%s>? A few things on your getting started list are still open:", checklist.User)}
+		for _, task := range remaining {
+			lines = append(lines, "• "+onboardingTaskText(task))
+		}
+		lines = append(lines, "Tick them off in my Home tab as you go.")
+		if _, err := sendDM("onboarding", checklist.User, outboundMessage{Importance: importanceInfo, Text: strings.Join(lines, "\n")}); err != nil {
+			log.Printf("Error nudging onboarding checklist: %v", err)
+		}
+	}
+}
+
+func describeUserOnboardingChecklist(userID string) (string, error) {
+	checklist, err := loadOnboardingChecklist(userID)
+	if err != nil || checklist == nil {
+		return "", err
+	}
+	var items []string
+	for _, task := range appConfig.Onboarding.Checklist {
+		if at, done := checklist.Done[task.ID]; done {
+			items = append(items, fmt.Sprintf("%s: done %s", task.Text, at.Format("2 Jan 2006")))
+		}
+	}
+	if len(items) == 0 {
+		items = append(items, fmt.Sprintf("Started %s, nothing done yet", checklist.StartedAt.Format("2 Jan 2006")))
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserOnboardingChecklist(userID string) error {
+	return store.Delete(onboardingChecklistsBucket, userID)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long on-call lookups are cached when oncall.cache_ttl is unset
+const defaultOncallCacheTTL = 5 * time.Minute
+
+// oncallShift is someone on call for a schedule right now
+type oncallShift struct {
+	Name  string
+	Email string
+	// Until is when the shift ends; zero when the provider doesn't say
+	Until time.Time
+}
+
+// oncallProvider looks up who is on call in a paging service
+type oncallProvider interface {
+	OnCall(schedule string) ([]oncallShift, error)
+}
+
+// oncallProviderFactories creates on-call providers by the name used in
+// config
+var oncallProviderFactories = map[string]func(OncallConfig) (oncallProvider, error){
+	"pagerduty": newPagerDutyProvider,
+	"opsgenie":  newOpsgenieProvider,
+}
+
+// cachedOncall is a schedule's shifts as last looked up
+type cachedOncall struct {
+	shifts  []oncallShift
+	expires time.Time
+}
+
+var (
+	// The configured provider; nil when oncall.provider isn't set
+	oncallSource oncallProvider
+
+	oncallCacheMu sync.Mutex
+	oncallCache   = map[string]cachedOncall{}
+)
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/oncall",
+		Usage:       "/oncall [service]",
+		Description: "See who's on call now, for one service or all of them",
+		Handler:     handleOncall,
+	})
+}
+
+// setupOncall builds the provider for oncall.provider
+func setupOncall(cfg OncallConfig) error {
+	if cfg.Provider == "" {
+		return nil
+	}
+	factory, ok := oncallProviderFactories[cfg.Provider]
+	if !ok {
+		return fmt.Errorf("unknown on-call provider %q", cfg.Provider)
+	}
+	if len(cfg.Services) == 0 {
+		return errors.New("oncall.services must map at least one service to a schedule")
+	}
+	provider, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring on-call provider: %w", err)
+	}
+	oncallSource = provider
+	return nil
+}
+
+func handleOncall(req commandRequest) commandResponse {
+	cfg := appConfig.Oncall
+	if oncallSource == nil {
+		return ephemeral("On-call lookups aren't set up. See `oncall` in the config.")
+	}
+	service := strings.ToLower(strings.TrimSpace(req.Text))
+	if service == "" {
+		service = cfg.Default
+	}
+	services := make([]string, 0, len(cfg.Services))
+	for name := range cfg.Services {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	if service != "" {
+		if _, ok := cfg.Services[service]; !ok {
+			return ephemeral("I don't know the service %q. Try one of: %s", service, strings.Join(services, ", "))
+		}
+		services = []string{service}
+	}
+
+	lines := make([]string, 0, len(services))
+	for _, name := range services {
+		shifts, err := lookupOncall(cfg.Services[name])
+		if err != nil {
+			log.Printf("Error looking up on-call for %s: %v", name, err)
+			lines = append(lines, fmt.Sprintf("*%s*: sorry, I couldn't look that up", name))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("*%s*: %s", name, describeShifts(shifts)))
+	}
+	return ephemeral(":pager: On call now\n%s", strings.Join(lines, "\n"))
+}
+
+// lookupOncall returns who is on call for schedule, from the cache when
+// it's fresh
+func lookupOncall(schedule string) ([]oncallShift, error) {
+	ttl := appConfig.Oncall.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultOncallCacheTTL
+	}
+	oncallCacheMu.Lock()
+	cached, ok := oncallCache[schedule]
+	oncallCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.shifts, nil
+	}
+
+	shifts, err := oncallSource.OnCall(schedule)
+	if err != nil {
+		return nil, err
+	}
+	// A shift ending sooner than the TTL shouldn't stay cached past its end
+	expires := time.Now().Add(ttl)
+	for _, shift := range shifts {
+		if !shift.Until.IsZero() && shift.Until.Before(expires) {
+			expires = shift.Until
+		}
+	}
+	oncallCacheMu.Lock()
+	oncallCache[schedule] = cachedOncall{shifts: shifts, expires: expires}
+	oncallCacheMu.Unlock()
+	return shifts, nil
+}
+
+// describeShifts mentions the people on call, by their Slack account when
+// their email matches one
+func describeShifts(shifts []oncallShift) string {
+	if len(shifts) == 0 {
+		return "nobody"
+	}
+	people := make([]string, 0, len(shifts))
+	for _, shift := range shifts {
+		who := shift.Name
+		if shift.Email != "" {
+			if user, err := slackClient.GetUserByEmail(shift.Email); err == nil {
+				who = "<@" + user.ID + ">"
+			} else if who == "" {
+				who = shift.Email
+			}
+		}
+		if !shift.Until.IsZero() {
+			who += " until " + slackDate(shift.Until)
+		}
+		people = append(people, who)
+	}
+	return strings.Join(people, ", ")
+}
+
+// oncallAPIKey reads the provider's key from oncall.api_key_env, or the
+// provider's usual variable
+func oncallAPIKey(cfg OncallConfig, fallback string) (string, error) {
+	env := cfg.APIKeyEnv
+	if env == "" {
+		env = fallback
+	}
+	key := os.Getenv(env)
+	if key == "" {
+		return "", fmt.Errorf("%s is not set", env)
+	}
+	return key, nil
+}
+
+// oncallGet calls a provider's API and decodes the JSON answer into out
+func oncallGet(endpoint string, headers map[string]string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(out)
+}
+
+// pagerDutyProvider reads schedules from PagerDuty's REST API, by schedule
+// ID. The key comes from PAGERDUTY_API_KEY unless oncall.api_key_env says
+// otherwise.
+type pagerDutyProvider struct {
+	url, key string
+}
+
+func newPagerDutyProvider(cfg OncallConfig) (oncallProvider, error) {
+	key, err := oncallAPIKey(cfg, "PAGERDUTY_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	base := cfg.APIURL
+	if base == "" {
+		base = "https://api.pagerduty.com"
+	}
+	return pagerDutyProvider{url: strings.TrimSuffix(base, "/"), key: key}, nil
+}
+
+func (p pagerDutyProvider) OnCall(schedule string) ([]oncallShift, error) {
+	query := url.Values{"schedule_ids[]": {schedule}, "include[]": {"users"}, "earliest": {"true"}}
+	var out struct {
+		Oncalls []struct {
+			User struct {
+				Name  string `json:"name"`
+				Email string `json:"email"`
+			} `json:"user"`
+			End time.Time `json:"end"`
+		} `json:"oncalls"`
+	}
+	headers := map[string]string{
+		"Authorization": "Token token=" + p.key,
+		"Accept":        "application/vnd.pagerduty+json;version=2",
+	}
+	if err := oncallGet(p.url+"/oncalls?"+query.Encode(), headers, &out); err != nil {
+		return nil, fmt.Errorf("asking PagerDuty about schedule %s: %w", schedule, err)
+	}
+	shifts := make([]oncallShift, 0, len(out.Oncalls))
+	for _, o := range out.Oncalls {
+		shifts = append(shifts, oncallShift{Name: o.User.Name, Email: o.User.Email, Until: o.End})
+	}
+	return shifts, nil
+}
+
+// opsgenieProvider reads schedules from Opsgenie, by schedule name. The key
+// comes from OPSGENIE_API_KEY unless oncall.api_key_env says otherwise; EU
+// accounts set oncall.api_url to https://api.eu.opsgenie.com.
+type opsgenieProvider struct {
+	url, key string
+}
+
+func newOpsgenieProvider(cfg OncallConfig) (oncallProvider, error) {
+	key, err := oncallAPIKey(cfg, "OPSGENIE_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	base := cfg.APIURL
+	if base == "" {
+		base = "https://api.opsgenie.com"
+	}
+	return opsgenieProvider{url: strings.TrimSuffix(base, "/"), key: key}, nil
+}
+
+func (p opsgenieProvider) OnCall(schedule string) ([]oncallShift, error) {
+	endpoint := fmt.Sprintf("%s/v2/schedules/%s/on-calls?scheduleIdentifierType=name&flat=true", p.url, url.PathEscape(schedule))
+	var out struct {
+		Data struct {
+			OnCallRecipients []string `json:"onCallRecipients"`
+		} `json:"data"`
+	}
+	if err := oncallGet(endpoint, map[string]string{"Authorization": "GenieKey " + p.key}, &out); err != nil {
+		return nil, fmt.Errorf("asking Opsgenie about schedule %s: %w", schedule, err)
+	}
+	shifts := make([]oncallShift, 0, len(out.Data.OnCallRecipients))
+	for _, email := range out.Data.OnCallRecipients {
+		shifts = append(shifts, oncallShift{Email: email})
+	}
+	return shifts, nil
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for time off, keyed by user/first day
+const oooBucket = "ooo"
+
+// Status shown while someone is out, unless ooo.status_text and
+// ooo.status_emoji say otherwise
+const (
+	defaultOOOStatusText  = "Out of office"
+	defaultOOOStatusEmoji = ":palm_tree:"
+)
+
+// Longest stretch of time off one /ooo can register
+const maxOOODays = 90
+
+// How far ahead /whosout can look
+const maxWhosOutWeeks = 4
+
+// Each sender is told someone's out at most this often
+const oooWarningInterval = 12 * time.Hour
+
+// oooPeriod is a stretch of time off, From and Until inclusive, as dates
+// in the user's timezone
+type oooPeriod struct {
+	User     string `json:"user"`
+	From     string `json:"from"`
+	Until    string `json:"until"`
+	Timezone string `json:"timezone"`
+	Note     string `json:"note,omitempty"`
+	// StatusSet is whether the bot has set their Slack status for it
+	StatusSet bool      `json:"status_set,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var (
+	// Sets other people's status; nil unless ooo.status_token_env is set
+	oooStatusClient *slack.Client
+
+	// When each sender was last told each person is out, keyed by
+	// sender/person
+	oooWarnings   = map[string]time.Time{}
+	oooWarningsMu sync.Mutex
+)
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/ooo",
+		Usage:       "/ooo <date> [to <date>] [note] | list | cancel <date>",
+		Description: "Register time off; your status is set on those days",
+		Handler:     handleOOO,
+	})
+	registerSlashCommand(&command{
+		Name:        "/whosout",
+		Usage:       "/whosout [weeks]",
+		Description: "Show who's out over the next couple of weeks",
+		Handler:     handleWhosOut,
+	})
+	registerUserDataset(userDataset{Name: "ooo", Title: "Time off", Describe: describeUserOOO, Delete: deleteUserOOO})
+}
+
+// setupOOO creates the client that sets statuses. Setting someone else's
+// status needs an admin's user token with users.profile:write.
+func setupOOO(cfg OOOConfig) error {
+	if cfg.StatusTokenEnv == "" {
+		return nil
+	}
+	token := os.Getenv(cfg.StatusTokenEnv)
+	if token == "" {
+		return fmt.Errorf("ooo.status_token_env: %s is not set", cfg.StatusTokenEnv)
+	}
+	oooStatusClient = slack.New(token)
+	return nil
+}
+
+// startOOO sets statuses as time off begins and forgets time off that's over
+func startOOO() {
+	runEvery("out of office", 15*time.Minute, updateOOOStatuses)
+}
+
+func (p oooPeriod) location() *time.Location {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return workspaceLocation()
+	}
+	return loc
+}
+
+// covers reports whether p includes date, as YYYY-MM-DD
+func (p oooPeriod) covers(date string) bool {
+	return p.From <= date && date <= p.Until
+}
+
+// untilLabel is the last day off, like Fri 24 Oct
+func (p oooPeriod) untilLabel() string {
+	until, _ := time.Parse(time.DateOnly, p.Until)
+	return until.Format("Mon 2 Jan")
+}
+
+// parseOOODate understands today, tomorrow, day names for the next such
+// day, and dates like 2024-01-31
+func parseOOODate(s string, now time.Time) (string, bool) {
+	date, ok := parseAvailabilityDate(s, now)
+	if !ok {
+		return "", false
+	}
+	return date.Format(time.DateOnly), true
+}
+
+func handleOOO(req commandRequest) commandResponse {
+	usage := fmt.Sprintf("Usage: `%s <date> [to <date>] [note]`, like `%s 2024-08-05 to 2024-08-09 Camping`, or `%s list` or `%s cancel <date>`",
+		req.Command, req.Command, req.Command, req.Command)
+	if len(req.Args) == 0 {
+		return ephemeral("%s", usage)
+	}
+	loc := userLocationOr(req.UserID, workspaceLocation())
+	now := time.Now().In(loc)
+	switch strings.ToLower(req.Args[0]) {
+	case "list":
+		return listOOO(req.UserID)
+	case "cancel":
+		if len(req.Args) != 2 {
+			return ephemeral("%s", usage)
+		}
+		date, ok := parseOOODate(req.Args[1], now)
+		if !ok {
+			return ephemeral("%s", usage)
+		}
+		return cancelOOO(req.UserID, date)
+	}
+
+	p := oooPeriod{User: req.UserID, Timezone: loc.String(), CreatedAt: time.Now().UTC()}
+	var ok bool
+	if p.From, ok = parseOOODate(req.Args[0], now); !ok {
+		return ephemeral("%s", usage)
+	}
+	p.Until = p.From
+	rest := req.Args[1:]
+	if len(rest) >= 2 && strings.EqualFold(rest[0], "to") {
+		if p.Until, ok = parseOOODate(rest[1], now); !ok {
+			return ephemeral("%s", usage)
+		}
+		rest = rest[2:]
+	}
+	p.Note = strings.Join(rest, " ")
+	from, _ := time.Parse(time.DateOnly, p.From)
+	until, _ := time.Parse(time.DateOnly, p.Until)
+	switch {
+	case until.Before(from):
+		return ephemeral("Your time off has to end after it starts.")
+	case until.Sub(from) >= maxOOODays*24*time.Hour:
+		return ephemeral("That's more than %d days. Register it in a few goes.", maxOOODays)
+	case p.Until < now.Format(time.DateOnly):
+		return ephemeral("That's already over.")
+	}
+
+	periods, err := userOOO(req.UserID)
+	if err != nil {
+		log.Printf("Error loading time off: %v", err)
+		return ephemeral("Sorry, something went wrong saving your time off.")
+	}
+	for _, other := range periods {
+		if other.From <= p.Until && p.From <= other.Until {
+			return ephemeral("That overlaps your time off from %s to %s. `%s cancel %s` removes it.", other.From, other.Until, req.Command, other.From)
+		}
+	}
+	if err := store.Put(oooBucket, p.User+"/"+p.From, p); err != nil {
+		log.Printf("Error saving time off: %v", err)
+		return ephemeral("Sorry, something went wrong saving your time off.")
+	}
+	go runJob("out of office", updateOOOStatuses)
+
+	reply := fmt.Sprintf(":palm_tree: You're out from %s to %s.", from.Format("Mon 2 Jan"), until.Format("Mon 2 Jan"))
+	if p.From == p.Until {
+		reply = fmt.Sprintf(":palm_tree: You're out on %s.", from.Format("Mon 2 Jan"))
+	}
+	if oooStatusClient != nil {
+		reply += " I'll set your status on those days."
+	} else {
+		reply += " Don't forget to set your status."
+	}
+	return ephemeral("%s", reply)
+}
+
+// userOOO lists userID's time off, soonest first
+func userOOO(userID string) ([]oooPeriod, error) {
+	periods, err := storeList[oooPeriod](store, oooBucket)
+	if err != nil {
+		return nil, err
+	}
+	periods = slices.DeleteFunc(periods, func(p oooPeriod) bool { return p.User != userID })
+	slices.SortFunc(periods, func(a, b oooPeriod) int { return strings.Compare(a.From, b.From) })
+	return periods, nil
+}
+
+func listOOO(userID string) commandResponse {
+	periods, err := userOOO(userID)
+	if err != nil {
+		log.Printf("Error loading time off: %v", err)
+		return ephemeral("Sorry, something went wrong loading your time off.")
+	}
+	if len(periods) == 0 {
+		return ephemeral("You have no time off coming up.")
+	}
+	lines := []string{"*Your time off*"}
+	for _, p := range periods {
+		line := fmt.Sprintf("• %s to %s", p.From, p.Until)
+		if p.Note != "" {
+			line += ": " + p.Note
+		}
+		lines = append(lines, line)
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
+
+// cancelOOO removes the time off that includes date, clearing the status
+// if it was set
+func cancelOOO(userID, date string) commandResponse {
+	periods, err := userOOO(userID)
+	if err != nil {
+		log.Printf("Error loading time off: %v", err)
+		return ephemeral("Sorry, something went wrong cancelling your time off.")
+	}
+	i := slices.IndexFunc(periods, func(p oooPeriod) bool { return p.covers(date) })
+	if i < 0 {
+		return ephemeral("You have no time off on %s.", date)
+	}
+	p := periods[i]
+	if err := store.Delete(oooBucket, p.User+"/"+p.From); err != nil {
+		log.Printf("Error removing time off: %v", err)
+		return ephemeral("Sorry, something went wrong cancelling your time off.")
+	}
+	if p.StatusSet && oooStatusClient != nil {
+		if err := oooStatusClient.SetUserCustomStatusWithUser(p.User, "", "", 0); err != nil {
+			log.Printf("Error clearing out of office status: %v", err)
+		}
+	}
+	return ephemeral("Cancelled your time off from %s to %s.", p.From, p.Until)
+}
+
+// updateOOOStatuses sets the status of everyone whose time off has begun
+// and forgets time off that's over. Statuses expire by themselves.
+func updateOOOStatuses() {
+	periods, err := storeList[oooPeriod](store, oooBucket)
+	if err != nil {
+		log.Printf("Error listing time off: %v", err)
+		return
+	}
+	cfg := appConfig.OOO
+	text, emoji := cfg.StatusText, cfg.StatusEmoji
+	if text == "" {
+		text = defaultOOOStatusText
+	}
+	if emoji == "" {
+		emoji = defaultOOOStatusEmoji
+	}
+	for _, p := range periods {
+		loc := p.location()
+		today := time.Now().In(loc).Format(time.DateOnly)
+		key := p.User + "/" + p.From
+		if p.Until < today {
+			if err := store.Delete(oooBucket, key); err != nil {
+				log.Printf("Error removing time off: %v", err)
+			}
+			continue
+		}
+		if p.StatusSet || oooStatusClient == nil || !p.covers(today) {
+			continue
+		}
+		until, _ := time.ParseInLocation(time.DateOnly, p.Until, loc)
+		status := text
+		if p.Note != "" {
+			status += ": " + p.Note
+		}
+		status, _ = truncateText(status, 100)
+		if err := oooStatusClient.SetUserCustomStatusWithUser(p.User, status, emoji, until.AddDate(0, 0, 1).Unix()); err != nil {
+			log.Printf("Error setting out of office status for %s: %v", p.User, err)
+			continue
+		}
+		p.StatusSet = true
+		if err := store.Put(oooBucket, key, p); err != nil {
+			log.Printf("Error saving time off: %v", err)
+		}
+	}
+}
+
+// outToday returns userID's time off covering today, if any
+func outToday(userID string, periods []oooPeriod) (oooPeriod, bool) {
+	for _, p := range periods {
+		if p.User == userID && p.covers(time.Now().In(p.location()).Format(time.DateOnly)) {
+			return p, true
+		}
+	}
+	return oooPeriod{}, false
+}
+
+// handleOOOMentions tells whoever mentions someone who's out that they're
+// away. Slack doesn't show bots DMs between people, so only mentions in
+// the bot's channels are seen.
+func handleOOOMentions(ev *slackevents.MessageEvent) {
+	if ev.User == "" || ev.User == botUserID || ev.BotID != "" || ev.ChannelType == "im" {
+		return
+	}
+	matches := userMentionPattern.FindAllStringSubmatch(ev.Text, -1)
+	if len(matches) == 0 || len(store.Keys(oooBucket)) == 0 {
+		return
+	}
+	periods, err := storeList[oooPeriod](store, oooBucket)
+	if err != nil {
+		log.Printf("Error listing time off: %v", err)
+		return
+	}
+	var lines []string
+	seen := map[string]bool{}
+	for _, m := range matches {
+		userID := m[1]
+		if userID == ev.User || seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		p, out := outToday(userID, periods)
+		if !out || !oooWarnDue(ev.User, userID) {
+			continue
+		}
+		line := fmt.Sprintf(":palm_tree: <@%s> is out of office until %s.", userID, p.untilLabel())
+		if p.Note != "" {
+			line += " " + p.Note
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return
+	}
+	err = sendEphemeral(ev.User, outboundMessage{Channel: ev.Channel, ThreadTS: ev.ThreadTimeStamp, Text: strings.Join(lines, "\n")})
+	if err != nil {
+		log.Printf("Error warning about time off: %v", err)
+	}
+}
+
+// oooWarnDue reports whether sender should be told about person, noting
+// that they have been
+func oooWarnDue(sender, person string) bool {
+	oooWarningsMu.Lock()
+	defer oooWarningsMu.Unlock()
+	now := time.Now()
+	for key, at := range oooWarnings {
+		if now.Sub(at) > oooWarningInterval {
+			delete(oooWarnings, key)
+		}
+	}
+	key := sender + "/" + person
+	if _, warned := oooWarnings[key]; warned {
+		return false
+	}
+	oooWarnings[key] = now
+	return true
+}
+
+// handleWhosOut draws a calendar of who's out, a row per person and a
+// column per day
+func handleWhosOut(req commandRequest) commandResponse {
+	weeks := 2
+	if len(req.Args) > 0 {
+		n, err := strconv.Atoi(req.Args[0])
+		if err != nil || n < 1 || n > maxWhosOutWeeks {
+			return ephemeral("Usage: `%s [weeks]`, up to %d weeks.", req.Command, maxWhosOutWeeks)
+		}
+		weeks = n
+	}
+	periods, err := storeList[oooPeriod](store, oooBucket)
+	if err != nil {
+		log.Printf("Error listing time off: %v", err)
+		return ephemeral("Sorry, something went wrong loading who's out.")
+	}
+
+	loc := userLocationOr(req.UserID, workspaceLocation())
+	now := time.Now().In(loc)
+	// Weeks start on Monday
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	var days []string
+	for i := range weeks * 7 {
+		days = append(days, start.AddDate(0, 0, i).Format(time.DateOnly))
+	}
+
+	out := map[string][]bool{}
+	var users []string
+	for _, p := range periods {
+		if p.Until < days[0] || p.From > days[len(days)-1] {
+			continue
+		}
+		if out[p.User] == nil {
+			out[p.User] = make([]bool, len(days))
+			users = append(users, p.User)
+		}
+		for i, day := range days {
+			out[p.User][i] = out[p.User][i] || p.covers(day)
+		}
+	}
+	if len(users) == 0 {
+		return ephemeral(":sunny: Nobody's out in the next %s.", plural(weeks, "week"))
+	}
+
+	names := map[string]string{}
+	width := 0
+	for _, user := range users {
+		width = max(width, len([]rune(exportUserName(user, names))))
+	}
+	slices.SortFunc(users, func(a, b string) int { return strings.Compare(strings.ToLower(names[a]), strings.ToLower(names[b])) })
+	width = min(width, 20)
+
+	today := now.Format(time.DateOnly)
+	var lines []string
+	for w := range weeks {
+		header := strings.Repeat(" ", width)
+		for i := w * 7; i < w*7+7; i++ {
+			day, _ := time.Parse(time.DateOnly, days[i])
+			header += fmt.Sprintf(" %2d", day.Day())
+		}
+		monday, _ := time.Parse(time.DateOnly, days[w*7])
+		lines = append(lines, "Week of "+monday.Format("2 Jan"), strings.Repeat(" ", width)+" Mo Tu We Th Fr Sa Su", header)
+		for _, user := range users {
+			row := []rune(names[user])
+			if len(row) > width {
+				row = append(row[:width-1], '…')
+			}
+			line := string(row) + strings.Repeat(" ", width-len(row))
+			for i := w * 7; i < w*7+7; i++ {
+				mark := "·"
+				if out[user][i] {
+					mark = "■"
+				}
+				if days[i] == today {
+					mark = "[" + mark + "]"
+				} else {
+					mark = " " + mark + " "
+				}
+				line += mark
+			}
+			lines = append(lines, strings.TrimRight(line, " "))
+		}
+		lines = append(lines, "")
+	}
+	text, _ := truncateText("*Who's out*\n```\n"+strings.TrimSpace(strings.Join(lines, "\n"))+"\n```\n■ out, [ ] today", maxMessageText)
+	return ephemeral("%s", text)
+}
+
+func describeUserOOO(userID string) (string, error) {
+	periods, err := userOOO(userID)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, p := range periods {
+		item := fmt.Sprintf("%s to %s", p.From, p.Until)
+		if p.Note != "" {
+			item += ": " + p.Note
+		}
+		items = append(items, item)
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserOOO(userID string) error {
+	periods, err := userOOO(userID)
+	if err != nil {
+		return err
+	}
+	for _, p := range periods {
+		if err := store.Delete(oooBucket, p.User+"/"+p.From); err != nil {
+			return err
+		}
+	}
+	return nil
+}
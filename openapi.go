@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version of the HTTP API described by the spec
+const apiVersion = "1.0.0"
+
+// Matches gin path parameters such as :id
+var ginPathParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// apiErrorResponse is the body of every non-2xx API response
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// openAPISpec builds the OpenAPI 3 document for every registered route
+func openAPISpec() map[string]any {
+	schemas := map[string]any{}
+	errorRef := schemaFor(reflect.TypeOf(apiErrorResponse{}), schemas)
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+		}
+	}
+
+	paths := map[string]map[string]any{}
+	for _, route := range apiRoutes {
+		path := ginPathParam.ReplaceAllString(route.Path, "{$1}")
+		var params []map[string]any
+		for _, match := range ginPathParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]any{
+				"name": match[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, param := range route.Query {
+			params = append(params, map[string]any{
+				"name": param.Name, "in": "query", "required": param.Required,
+				"description": param.Description, "schema": map[string]any{"type": "string"},
+			})
+		}
+
+		description := "Requires a token with no particular scope."
+		if route.Scope != "" {
+			description = "Requires a token with the `" + route.Scope + "` scope."
+		}
+		if auditorReadable(route.Scope, route.Method) {
+			description = "Requires a token with the `" + route.Scope + "` scope, or `" + scopeAuditRead + "` for auditors."
+		}
+		op := map[string]any{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"description": description,
+			"security":    []map[string]any{{"bearerAuth": []string{}}},
+			"x-scope":     route.Scope,
+			"responses": map[string]any{
+				"401": errorResponse("Missing or invalid token"),
+				"403": errorResponse("Token lacks the required scope"),
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{"application/json": map[string]any{
+					"schema": schemaFor(reflect.TypeOf(route.Request), schemas),
+				}},
+			}
+		}
+		success := map[string]any{"description": "OK"}
+		if route.Response != nil {
+			success["content"] = map[string]any{"application/json": map[string]any{
+				"schema": schemaFor(reflect.TypeOf(route.Response), schemas),
+			}}
+		}
+		op["responses"].(map[string]any)["200"] = success
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Slack bot API",
+			"version": apiVersion,
+		},
+		"servers": []map[string]any{{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// schemaFor returns the JSON schema for t, adding named structs to schemas
+// and referring to them by $ref
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := schemaName(t)
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; !ok {
+			// Placeholder first, so self-referencing types terminate
+			schemas[name] = map[string]any{}
+			schemas[name] = structSchema(t, schemas)
+		}
+		return ref
+	}
+	return map[string]any{}
+}
+
+// structSchema describes the JSON encoding of struct type t
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaFor(field.Type, schemas)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// schemaName turns an unexported Go type name such as apiMeResponse into
+// the exported component name MeResponse
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// handleOpenAPISpec serves the OpenAPI document
+func handleOpenAPISpec(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPISpec())
+}
+
+// Swagger UI page loading the spec; only served in dev mode
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Slack bot API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>`
+
+// handleSwaggerUI serves an interactive explorer for the API
+func handleSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
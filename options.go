@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// How long the user and channel lists used for suggestions are cached
+const directoryTTL = 10 * time.Minute
+
+// optionsProvider returns the options matching what the user typed
+type optionsProvider func(callback *slack.InteractionCallback, query string) ([]*slack.OptionBlockObject, error)
+
+// Providers for external_select menus, keyed by the element's action_id
+var optionsProviders = map[string]optionsProvider{}
+
+// registerOptionsProvider loads the options of external_select elements with
+// actionID from provider
+func registerOptionsProvider(actionID string, provider optionsProvider) {
+	optionsProviders[actionID] = provider
+}
+
+// directoryEntry is a user or channel offered as a suggestion
+type directoryEntry struct {
+	ID    string
+	Label string
+	// Search is the lower-cased text queries are matched against
+	Search string
+}
+
+// directoryCache keeps a recently fetched list of users or channels
+type directoryCache struct {
+	mu      sync.Mutex
+	fetched time.Time
+	entries []directoryEntry
+	fetch   func() ([]directoryEntry, error)
+}
+
+var (
+	userDirectory    = &directoryCache{fetch: fetchUserDirectory}
+	channelDirectory = &directoryCache{fetch: fetchChannelDirectory}
+)
+
+func init() {
+	registerOptionsProvider("select_user", directoryOptions(userDirectory))
+	registerOptionsProvider("select_channel", directoryOptions(channelDirectory))
+}
+
+// handleSlackOptions answers block_suggestion requests for external_select
+// menus
+func handleSlackOptions(c *gin.Context) {
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(c.PostForm("payload")), &callback); err != nil {
+		log.Printf("Error parsing Slack options request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid options payload"})
+		return
+	}
+	provider, ok := optionsProviders[callback.ActionID]
+	if !ok {
+		log.Printf("Unknown options provider: %s", callback.ActionID)
+		c.JSON(http.StatusOK, slack.OptionsResponse{Options: []*slack.OptionBlockObject{}})
+		return
+	}
+	options, err := provider(&callback, strings.TrimSpace(callback.Value))
+	if err != nil {
+		log.Printf("Error loading options for %s: %v", callback.ActionID, err)
+		options = nil
+	}
+	if len(options) > maxSelectOptions {
+		options = options[:maxSelectOptions]
+	}
+	if options == nil {
+		options = []*slack.OptionBlockObject{}
+	}
+	c.JSON(http.StatusOK, slack.OptionsResponse{Options: options})
+}
+
+// directoryOptions suggests the entries of cache that contain the query
+func directoryOptions(cache *directoryCache) optionsProvider {
+	return func(_ *slack.InteractionCallback, query string) ([]*slack.OptionBlockObject, error) {
+		entries, err := cache.list()
+		if err != nil {
+			return nil, err
+		}
+		query = strings.ToLower(query)
+		var options []*slack.OptionBlockObject
+		for _, entry := range entries {
+			if !strings.Contains(entry.Search, query) {
+				continue
+			}
+			label, _ := truncateText(entry.Label, maxOptionText)
+			options = append(options, slack.NewOptionBlockObject(entry.ID,
+				slack.NewTextBlockObject(slack.PlainTextType, label, false, false), nil))
+			if len(options) == maxSelectOptions {
+				break
+			}
+		}
+		return options, nil
+	}
+}
+
+// list returns the cached entries, refreshing them when stale
+func (d *directoryCache) list() ([]directoryEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries != nil && time.Since(d.fetched) < directoryTTL {
+		return d.entries, nil
+	}
+	entries, err := d.fetch()
+	if err != nil {
+		if d.entries != nil {
+			// Stale suggestions beat none
+			log.Printf("Error refreshing directory, using cached copy: %v", err)
+			return d.entries, nil
+		}
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Label < entries[j].Label })
+	d.entries, d.fetched = entries, time.Now()
+	return entries, nil
+}
+
+func fetchUserDirectory() ([]directoryEntry, error) {
+	users, err := slackClient.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	var entries []directoryEntry
+	for _, user := range users {
+		if user.Deleted || user.IsBot || user.ID == "USLACKBOT" {
+			continue
+		}
+		label := user.RealName
+		if label == "" {
+			label = user.Name
+		}
+		entries = append(entries, directoryEntry{
+			ID:     user.ID,
+			Label:  label,
+			Search: strings.ToLower(label + " " + user.Name + " " + user.Profile.DisplayName),
+		})
+	}
+	return entries, nil
+}
+
+// fetchChannelDirectory lists public channels. Private channels are left
+// out, since suggestions would show their names to everyone.
+func fetchChannelDirectory() ([]directoryEntry, error) {
+	var entries []directoryEntry
+	params := &slack.GetConversationsParameters{ExcludeArchived: true, Limit: 1000, Types: []string{"public_channel"}}
+	for {
+		channels, cursor, err := slackClient.GetConversations(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			entries = append(entries, directoryEntry{
+				ID:     channel.ID,
+				Label:  "#" + channel.Name,
+				Search: strings.ToLower(channel.Name),
+			})
+		}
+		// Pages can be short or even empty before the last one, so only the
+		// cursor says whether there's more
+		if cursor == "" {
+			return entries, nil
+		}
+		params.Cursor = cursor
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	defaultPageSize = 10
+	// How long a paginated result stays browsable after it was posted
+	pageCacheTTL = 30 * time.Minute
+
+	actionPagePrev = "page_prev"
+	actionPageNext = "page_next"
+)
+
+// pagedResult is a cached list that is rendered one page at a time
+type pagedResult struct {
+	Title    string
+	Items    []string
+	PageSize int
+	expires  time.Time
+}
+
+var (
+	pageCacheMu sync.Mutex
+	pageCache   = map[string]*pagedResult{}
+)
+
+func init() {
+	registerBlockAction(actionPagePrev, handlePageAction)
+	registerBlockAction(actionPageNext, handlePageAction)
+}
+
+// newPagedResult caches items (one mrkdwn line each) and returns the token
+// the next/prev buttons use to find them again
+func newPagedResult(title string, items []string, pageSize int) string {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	token := newInteractionToken()
+
+	pageCacheMu.Lock()
+	defer pageCacheMu.Unlock()
+	now := time.Now()
+	for key, result := range pageCache {
+		if now.After(result.expires) {
+			delete(pageCache, key)
+		}
+	}
+	pageCache[token] = &pagedResult{
+		Title:    title,
+		Items:    items,
+		PageSize: pageSize,
+		expires:  now.Add(pageCacheTTL),
+	}
+	return token
+}
+
+// lookupPagedResult returns the cached result for token if it has not expired
+func lookupPagedResult(token string) (*pagedResult, bool) {
+	pageCacheMu.Lock()
+	defer pageCacheMu.Unlock()
+	result, ok := pageCache[token]
+	if !ok || time.Now().After(result.expires) {
+		return nil, false
+	}
+	return result, true
+}
+
+// pageCount returns the number of pages, which is at least one
+func (r *pagedResult) pageCount() int {
+	return max(1, (len(r.Items)+r.PageSize-1)/r.PageSize)
+}
+
+// pageBlocks renders page (zero-based) of the result cached under token
+func pageBlocks(token string, result *pagedResult, page int) []slack.Block {
+	pages := result.pageCount()
+	page = max(0, min(page, pages-1))
+	start := page * result.PageSize
+	end := min(start+result.PageSize, len(result.Items))
+
+	body := "_Nothing to show._"
+	if start < end {
+		body = strings.Join(result.Items[start:end], "\n")
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "*"+result.Title+"*", false, false), nil, nil),
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, body, false, false), nil, nil),
+	}
+	if pages == 1 {
+		return blocks
+	}
+
+	blocks = append(blocks, slack.NewContextBlock("",
+		slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("Page %d of %d", page+1, pages), false, false)))
+	var buttons []slack.BlockElement
+	if page > 0 {
+		buttons = append(buttons, slack.NewButtonBlockElement(actionPagePrev, pageActionValue(token, page-1),
+			slack.NewTextBlockObject(slack.PlainTextType, "◀ Prev", false, false)))
+	}
+	if page < pages-1 {
+		buttons = append(buttons, slack.NewButtonBlockElement(actionPageNext, pageActionValue(token, page+1),
+			slack.NewTextBlockObject(slack.PlainTextType, "Next ▶", false, false)))
+	}
+	return append(blocks, slack.NewActionBlock("pagination", buttons...))
+}
+
+// pagedResponse replies with the first page of items and next/prev buttons
+func pagedResponse(title string, items []string, pageSize int) commandResponse {
+	token := newPagedResult(title, items, pageSize)
+	result, _ := lookupPagedResult(token)
+	return commandResponse{Text: title, Blocks: pageBlocks(token, result, 0)}
+}
+
+// handlePageAction swaps the message for the requested page
+func handlePageAction(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	token, pageStr, _ := strings.Cut(action.Value, ":")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil {
+		log.Printf("Invalid page action value %q", action.Value)
+		return
+	}
+
+	result, ok := lookupPagedResult(token)
+	if !ok {
+		// Only tell the person who clicked; the list stays as it is for everyone else
+		err := sendEphemeral(callback.User.ID, outboundMessage{
+			Channel:  callback.Channel.ID,
+			ThreadTS: callback.Container.ThreadTs,
+			Text:     "This list has expired. Run the command again to see it.",
+		})
+		if err != nil {
+			log.Printf("Error reporting expired paged message: %v", err)
+		}
+		return
+	}
+	reply := &slack.WebhookMessage{
+		ReplaceOriginal: true,
+		Text:            result.Title,
+		Blocks:          &slack.Blocks{BlockSet: pageBlocks(token, result, page)},
+	}
+	if err := respond(callback.ResponseURL, reply); err != nil {
+		log.Printf("Error updating paged message: %v", err)
+	}
+}
+
+func pageActionValue(token string, page int) string {
+	return fmt.Sprintf("%s:%d", token, page)
+}
+
+// newInteractionToken returns a random token for keying server-side state
+// referenced from interactive elements
+func newInteractionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
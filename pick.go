@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strings"
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "pick",
+		Usage:       "pick <choice> <choice>...",
+		Description: "Pick one of the choices at random, e.g. `pick @ana @ben | remind {result} to run standup`",
+		Handler:     handlePick,
+	})
+}
+
+func handlePick(req commandRequest) commandResponse {
+	if len(req.Args) < 2 {
+		return ephemeral("Usage: `%s pick <choice> <choice>...`", botCommand)
+	}
+	choice := req.Args[rand.IntN(len(req.Args))]
+	return commandResponse{
+		Text:      ":game_die: I picked " + choice + " from " + strings.Join(req.Args, ", "),
+		InChannel: true,
+		Result:    choice,
+	}
+}
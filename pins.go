@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for the per-channel pinned highlights thread, keyed by
+// channel ID with the thread's timestamp as value
+const pinThreadsBucket = "pin_threads"
+
+// Pin mirroring modes
+const (
+	pinModeThread = "thread"
+	pinModeDigest = "digest"
+)
+
+// handlePinAdded mirrors a newly pinned message
+func handlePinAdded(ev *slackevents.PinAddedEvent) {
+	if ev.Item.Type != "message" || ev.Item.Message == nil || !isPinMirroredChannel(ev.Channel) {
+		return
+	}
+	msg := ev.Item.Message
+	if err := mirrorHighlight(ev.Channel, "pinned", ev.User, msg.User, msg.Text, msg.Timestamp); err != nil {
+		log.Printf("Error mirroring pinned message: %v", err)
+	}
+}
+
+// mirrorHighlight posts a quote of the message at ts, with its permalink, to
+// the channel's highlights thread or the digest channel
+func mirrorHighlight(channel, verb, actorID, authorID, messageText, ts string) error {
+	permalink, err := slackClient.GetPermalink(&slack.PermalinkParameters{Channel: channel, Ts: ts})
+	if err != nil {
+		return fmt.Errorf("fetching permalink: %w", err)
+	}
+
+	text := fmt.Sprintf(":pushpin: <@%s> %s a message from <@%s> in <#%s>", actorID, verb, authorID, channel)
+	quoted, _ := truncateText(messageText, maxSectionText-200)
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, ">"+quoteText(quoted), false, false), nil, nil),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("<%s|View message>", permalink), false, false)),
+	}
+	return postPinNotice(channel, text, blocks)
+}
+
+// handlePinRemoved notes that a message was unpinned
+func handlePinRemoved(ev *slackevents.PinRemovedEvent) {
+	if !appConfig.Pins.AnnounceRemovals || ev.Item.Type != "message" || ev.Item.Message == nil || !isPinMirroredChannel(ev.Channel) {
+		return
+	}
+	permalink, err := slackClient.GetPermalink(&slack.PermalinkParameters{Channel: ev.Channel, Ts: ev.Item.Message.Timestamp})
+	if err != nil {
+		log.Printf("Error fetching permalink for unpinned message: %v", err)
+		return
+	}
+	text := fmt.Sprintf(":wastebasket: <@%s> unpinned <%s|a message> in <#%s>", ev.User, permalink, ev.Channel)
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)}
+	if err := postPinNotice(ev.Channel, text, blocks); err != nil {
+		log.Printf("Error mirroring unpinned message: %v", err)
+	}
+}
+
+// isPinMirroredChannel reports whether pins in channel are mirrored. The
+// digest channel only gets private channels' pins when they're listed
+// explicitly, so their messages aren't shown to people outside them.
+func isPinMirroredChannel(channel string) bool {
+	cfg := appConfig.Pins
+	if cfg.Mode == "" {
+		return false
+	}
+	if slices.Contains(cfg.Channels, channel) {
+		return true
+	}
+	if len(cfg.Channels) > 0 {
+		return false
+	}
+	return cfg.Mode != pinModeDigest || !isPrivateChannel(channel)
+}
+
+// postPinNotice posts to the digest channel, or to the channel's pinned
+// highlights thread, starting that thread on first use
+func postPinNotice(channel, text string, blocks []slack.Block) error {
+	cfg := appConfig.Pins
+	if cfg.Mode == pinModeDigest {
+		if cfg.DigestChannel == "" {
+			return fmt.Errorf("pins.digest_channel is not set")
+		}
+		_, err := sendMessage(outboundMessage{Channel: cfg.DigestChannel, Text: text, Blocks: blocks})
+		return err
+	}
+
+	var threadTS string
+	if _, err := store.Get(pinThreadsBucket, channel, &threadTS); err != nil {
+		return err
+	}
+	if threadTS == "" {
+		ts, err := sendMessage(outboundMessage{
+			Channel: channel,
+			NeedTS:  true,
+			Text:    ":pushpin: *Pinned highlights* — every pinned message in this channel gets mirrored in this thread.",
+		})
+		if err != nil {
+			return err
+		}
+		threadTS = ts
+		if err := store.Put(pinThreadsBucket, channel, threadTS); err != nil {
+			return err
+		}
+	}
+	_, err := sendMessage(outboundMessage{Channel: channel, ThreadTS: threadTS, Text: text, Blocks: blocks})
+	return err
+}
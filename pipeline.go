@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits on chained commands
+const (
+	maxPipelineStages = 5
+	// Longest result value passed between commands
+	maxPipelineResult = 500
+)
+
+// Separates chained commands, as a word of its own
+const pipeSeparator = "|"
+
+// Matches a placeholder in a chained command's arguments: {result},
+// {result.field}, {step1} or {step1.field}
+var pipelinePlaceholder = regexp.MustCompile(`\{(result|step([1-9]))(?:\.([a-z_]+))?\}`)
+
+// runPipeline runs /bot commands separated by | in order. Each command's
+// arguments can use the result of the command before it ({result} or
+// {result.field}) or of any earlier one ({step1}, {step2.field}).
+// Placeholders are filled in after the arguments are split into words, so a
+// result never adds arguments or commands of its own.
+func runPipeline(stages [][]string, run func(words []string) commandResponse) commandResponse {
+	if len(stages) > maxPipelineStages {
+		return ephemeral("You can chain at most %d commands.", maxPipelineStages)
+	}
+	var results []commandResponse
+	var texts []string
+	for i, words := range stages {
+		if len(words) == 0 {
+			return ephemeral("Command %d in the chain is empty.", i+1)
+		}
+		args := make([]string, len(words))
+		for j, word := range words {
+			expanded, err := expandPipelineArg(word, results)
+			if err != nil {
+				texts = append(texts, fmt.Sprintf("Stopped before `%s`: %v", strings.Join(words, " "), err))
+				return commandResponse{Text: strings.Join(texts, "\n")}
+			}
+			args[j] = expanded
+		}
+		resp := run(args)
+		results = append(results, resp)
+		if resp.Text != "" {
+			texts = append(texts, resp.Text)
+		}
+	}
+	last := results[len(results)-1]
+	last.Text = strings.Join(texts, "\n")
+	return last
+}
+
+// expandPipelineArg fills in the placeholders in one argument
+func expandPipelineArg(word string, results []commandResponse) (string, error) {
+	var err error
+	expanded := pipelinePlaceholder.ReplaceAllStringFunc(word, func(placeholder string) string {
+		m := pipelinePlaceholder.FindStringSubmatch(placeholder)
+		step := len(results)
+		if m[2] != "" {
+			step, _ = strconv.Atoi(m[2])
+		}
+		if step == 0 || step > len(results) {
+			err = fmt.Errorf("%s refers to a command that hasn't run yet", placeholder)
+			return ""
+		}
+		resp := results[step-1]
+		value, ok := resp.Result, resp.Result != ""
+		if m[3] != "" {
+			value, ok = resp.Fields[m[3]]
+		}
+		if !ok {
+			err = fmt.Errorf("command %d didn't give a result for %s", step, placeholder)
+			return ""
+		}
+		if len(value) > maxPipelineResult {
+			err = fmt.Errorf("the result for %s is too long to pass on", placeholder)
+			return ""
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// splitPipeline splits words at each | word
+func splitPipeline(words []string) [][]string {
+	var stages [][]string
+	start := 0
+	for i, word := range words {
+		if word == pipeSeparator {
+			stages = append(stages, words[start:i])
+			start = i + 1
+		}
+	}
+	return append(stages, words[start:])
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for polls, keyed by poll ID
+const pollsBucket = "polls"
+
+// Action IDs of the poll buttons
+const (
+	actionPollVote  = "poll_vote"
+	actionPollClose = "poll_close"
+)
+
+// Slack can't show more buttons than this comfortably in one message
+const maxPollOptions = 10
+
+// Width of the result bars on a closed poll
+const pollBarWidth = 12
+
+// poll is a question with vote buttons
+type poll struct {
+	ID        string   `json:"id"`
+	Question  string   `json:"question"`
+	Options   []string `json:"options"`
+	Creator   string   `json:"creator"`
+	Channel   string   `json:"channel"`
+	Anonymous bool     `json:"anonymous,omitempty"`
+	// Multi lets people vote for several options
+	Multi bool `json:"multi,omitempty"`
+	// Votes holds the chosen option indexes, keyed by user ID
+	Votes     map[string][]int `json:"votes"`
+	Closed    bool             `json:"closed,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// Serializes vote updates, which read and rewrite the whole poll
+var pollsMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/poll",
+		Usage:       `/poll "Question" "Option A" "Option B" [--anonymous] [--multi]`,
+		Description: "Ask the channel a question with vote buttons",
+		Handler:     handlePollCommand,
+	})
+	registerBlockAction(actionPollVote, handlePollVote)
+	registerBlockAction(actionPollClose, handlePollClose)
+	registerUserDataset(userDataset{Name: "poll_votes", Title: "Poll votes", Describe: describeUserPollVotes, Delete: deleteUserPollVotes})
+}
+
+func handlePollCommand(req commandRequest) commandResponse {
+	p := &poll{Creator: req.UserID, Channel: req.ChannelID, Votes: map[string][]int{}, CreatedAt: time.Now().UTC()}
+	for _, arg := range parseQuotedArgs(req.Text) {
+		switch arg = strings.TrimSpace(arg); arg {
+		case "":
+		case "--anonymous":
+			p.Anonymous = true
+		case "--multi":
+			p.Multi = true
+		default:
+			if p.Question == "" {
+				p.Question = arg
+			} else {
+				p.Options = append(p.Options, arg)
+			}
+		}
+	}
+	if p.Question == "" || len(p.Options) < 2 {
+		return ephemeral(`Usage: %s "Question" "Option A" "Option B" [--anonymous] [--multi]`, req.Command)
+	}
+	if len(p.Options) > maxPollOptions {
+		return ephemeral("Polls can have at most %d options.", maxPollOptions)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Error creating poll ID: %v", err)
+		return ephemeral("Sorry, something went wrong creating the poll.")
+	}
+	p.ID = hex.EncodeToString(id)
+	if err := store.Put(pollsBucket, p.ID, p); err != nil {
+		log.Printf("Error saving poll: %v", err)
+		return ephemeral("Sorry, something went wrong creating the poll.")
+	}
+	return commandResponse{Text: "Poll: " + p.Question, Blocks: pollBlocks(p), InChannel: true, Result: p.ID}
+}
+
+// handlePollVote records or withdraws a vote and updates the tally
+func handlePollVote(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	id, indexStr, _ := strings.Cut(action.Value, ":")
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		log.Printf("Invalid poll vote value %q", action.Value)
+		return
+	}
+
+	pollsMu.Lock()
+	p, err := loadPoll(id)
+	if err == nil && p != nil && !p.Closed && index >= 0 && index < len(p.Options) {
+		p.vote(callback.User.ID, index)
+		err = store.Put(pollsBucket, p.ID, p)
+	}
+	pollsMu.Unlock()
+	if err != nil {
+		log.Printf("Error recording poll vote: %v", err)
+		return
+	}
+	if p == nil || p.Closed {
+		pollReply(callback, "This poll is closed.")
+		return
+	}
+	updatePollMessage(callback, p)
+}
+
+// vote toggles userID's vote for option index. Single choice polls move the
+// vote instead of adding another.
+func (p *poll) vote(userID string, index int) {
+	votes := p.Votes[userID]
+	switch {
+	case slices.Contains(votes, index):
+		votes = slices.DeleteFunc(votes, func(i int) bool { return i == index })
+	case p.Multi:
+		votes = append(votes, index)
+	default:
+		votes = []int{index}
+	}
+	if len(votes) == 0 {
+		delete(p.Votes, userID)
+		return
+	}
+	p.Votes[userID] = votes
+}
+
+// handlePollClose closes the poll and shows the results, if the clicker
+// created it or is an admin
+func handlePollClose(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	pollsMu.Lock()
+	p, err := loadPoll(action.Value)
+	allowed := p != nil && (callback.User.ID == p.Creator || isAdmin(callback.User.ID))
+	if err == nil && allowed && !p.Closed {
+		p.Closed = true
+		err = store.Put(pollsBucket, p.ID, p)
+	}
+	pollsMu.Unlock()
+	if err != nil {
+		log.Printf("Error closing poll: %v", err)
+		return
+	}
+	if p == nil {
+		pollReply(callback, "I couldn't find this poll any more.")
+		return
+	}
+	if !allowed {
+		pollReply(callback, "Only the person who started the poll or a bot admin can close it.")
+		return
+	}
+	updatePollMessage(callback, p)
+}
+
+func loadPoll(id string) (*poll, error) {
+	p := &poll{}
+	found, err := store.Get(pollsBucket, id, p)
+	if err != nil || !found {
+		return nil, err
+	}
+	if p.Votes == nil {
+		p.Votes = map[string][]int{}
+	}
+	return p, nil
+}
+
+func updatePollMessage(callback *slack.InteractionCallback, p *poll) {
+	reply := &slack.WebhookMessage{
+		ReplaceOriginal: true,
+		Text:            "Poll: " + p.Question,
+		Blocks:          &slack.Blocks{BlockSet: pollBlocks(p)},
+	}
+	if err := respond(callback.ResponseURL, reply); err != nil {
+		log.Printf("Error updating poll: %v", err)
+	}
+}
+
+// pollReply tells only the clicker about a problem with their click
+func pollReply(callback *slack.InteractionCallback, text string) {
+	err := sendEphemeral(callback.User.ID, outboundMessage{Channel: callback.Channel.ID, ThreadTS: callback.Container.ThreadTs, Text: text})
+	if err != nil {
+		log.Printf("Error replying to poll click: %v", err)
+	}
+}
+
+// pollBlocks shows the question, the tally for each option and, while the
+// poll is open, the vote and close buttons
+func pollBlocks(p *poll) []slack.Block {
+	title := ":bar_chart: *" + p.Question + "*"
+	if p.Closed {
+		title = ":checkered_flag: *" + p.Question + "* (closed)"
+	}
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, title, false, false), nil, nil)}
+
+	counts := make([]int, len(p.Options))
+	voters := make([][]string, len(p.Options))
+	for userID, votes := range p.Votes {
+		for _, i := range votes {
+			counts[i]++
+			voters[i] = append(voters[i], "<@"+userID+">")
+		}
+	}
+	top := slices.Max(counts)
+	for i, option := range p.Options {
+		text := fmt.Sprintf("*%s*  `%d`", option, counts[i])
+		if p.Closed {
+			text = fmt.Sprintf("%s\n`%s`", text, pollBar(counts[i], len(p.Votes)))
+			if counts[i] == top && top > 0 {
+				text = ":trophy: " + text
+			}
+		}
+		if !p.Anonymous && len(voters[i]) > 0 {
+			slices.Sort(voters[i])
+			text += "\n" + strings.Join(voters[i], " ")
+		}
+		text, _ = truncateText(text, maxSectionText)
+		var button *slack.Accessory
+		if !p.Closed {
+			button = slack.NewAccessory(slack.NewButtonBlockElement(actionPollVote, fmt.Sprintf("%s:%d", p.ID, i),
+				slack.NewTextBlockObject(slack.PlainTextType, "Vote", false, false)))
+		}
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, button))
+	}
+
+	voted := fmt.Sprintf("%d people voted", len(p.Votes))
+	if len(p.Votes) == 1 {
+		voted = "1 person voted"
+	}
+	details := []string{fmt.Sprintf("Asked by <@%s>", p.Creator), voted}
+	if p.Anonymous {
+		details = append(details, "anonymous")
+	}
+	if p.Multi {
+		details = append(details, "vote for as many as you like")
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, strings.Join(details, " · "), false, false)))
+	if !p.Closed {
+		blocks = append(blocks, slack.NewActionBlock("",
+			slack.NewButtonBlockElement(actionPollClose, p.ID, slack.NewTextBlockObject(slack.PlainTextType, "Close poll", false, false)),
+		))
+	}
+	return blocks
+}
+
+// pollBar draws count out of total as a bar
+func pollBar(count, total int) string {
+	filled := 0
+	if total > 0 {
+		filled = count * pollBarWidth / total
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", pollBarWidth-filled)
+}
+
+func describeUserPollVotes(userID string) (string, error) {
+	polls, err := storeList[poll](store, pollsBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, p := range polls {
+		if _, voted := p.Votes[userID]; voted {
+			items = append(items, p.Question)
+		}
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserPollVotes takes userID's votes out of every poll. Results
+// already shown in Slack update the next time someone votes.
+func deleteUserPollVotes(userID string) error {
+	pollsMu.Lock()
+	defer pollsMu.Unlock()
+	for _, id := range store.Keys(pollsBucket) {
+		var p poll
+		if _, err := store.Get(pollsBucket, id, &p); err != nil {
+			return err
+		}
+		if _, voted := p.Votes[userID]; !voted {
+			continue
+		}
+		delete(p.Votes, userID)
+		if err := store.Put(pollsBucket, id, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
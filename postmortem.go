@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Used when incidents.flag_reaction isn't set
+const defaultIncidentFlagReaction = "triangular_flag_on_post"
+
+// incidents.postmortem puts postmortem drafts in the channel's canvas
+// instead of a Markdown file
+const postmortemCanvas = "canvas"
+
+// How timeline times are written in postmortems
+const postmortemTimeLayout = "2006-01-02 15:04 UTC"
+
+// postmortemEvent is one line of a postmortem's timeline
+type postmortemEvent struct {
+	At   time.Time
+	Text string
+}
+
+// postmortemParticipant is someone who posted in the incident channel
+type postmortemParticipant struct {
+	Name     string
+	Messages int
+}
+
+// postmortemDraft is what a postmortem skeleton is written from
+type postmortemDraft struct {
+	Incident     incident
+	Timeline     []postmortemEvent
+	Participants []postmortemParticipant
+	// Truncated is set when the channel had more messages than were read
+	Truncated bool
+}
+
+func incidentFlagReaction() string {
+	if r := strings.Trim(appConfig.Incidents.FlagReaction, ":"); r != "" {
+		return r
+	}
+	return defaultIncidentFlagReaction
+}
+
+// handleIncidentPostmortem writes the postmortem of the incident whose
+// channel the command was run in again, from the channel as it is now
+func handleIncidentPostmortem(req commandRequest) commandResponse {
+	var inc incident
+	found, err := store.Get(incidentsBucket, req.ChannelID, &inc)
+	if err != nil {
+		log.Printf("Error reading incident: %v", err)
+		return ephemeral("Sorry, something went wrong finding the incident.")
+	}
+	if !found {
+		return ephemeral("Run `/incident postmortem` in an incident's channel.")
+	}
+	go runJob("postmortem", func() {
+		if err := postPostmortem(inc.Channel); err != nil {
+			log.Printf("Error writing postmortem for incident #%d: %v", inc.Number, err)
+			reply := &slack.WebhookMessage{Text: "Sorry, something went wrong writing the postmortem.", ResponseType: slack.ResponseTypeEphemeral}
+			if err := respond(req.ResponseURL, reply); err != nil {
+				log.Printf("Error replying to postmortem: %v", err)
+			}
+		}
+	})
+	return ephemeral(":memo: Writing the postmortem for incident #%d from this channel…", inc.Number)
+}
+
+// postPostmortem drafts the postmortem of the incident in channel and posts
+// it there, as a canvas or a Markdown file. A canvas already made for the
+// incident is rewritten instead.
+func postPostmortem(channel string) error {
+	var inc incident
+	found, err := store.Get(incidentsBucket, channel, &inc)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errNotIncidentChannel
+	}
+	draft, err := draftPostmortem(inc)
+	if err != nil {
+		return err
+	}
+	markdown := renderPostmortem(draft)
+	title := fmt.Sprintf("Postmortem: incident #%d, %s", inc.Number, inc.Title)
+
+	if appConfig.Incidents.Postmortem == postmortemCanvas {
+		err := writePostmortemCanvas(&inc, markdown)
+		if err == nil {
+			return nil
+		}
+		// Canvases need a paid plan, so fall back to a file
+		log.Printf("Error writing postmortem canvas, uploading a file instead: %v", err)
+	}
+	_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+		Channel:        inc.Channel,
+		Content:        markdown,
+		FileSize:       len(markdown),
+		Filename:       fmt.Sprintf("incident-%d-postmortem.md", inc.Number),
+		Title:          title,
+		InitialComment: fmt.Sprintf(":memo: Here's a postmortem draft for incident #%d to fill in. Run `/incident postmortem` here to write it again.", inc.Number),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading postmortem: %w", err)
+	}
+	return nil
+}
+
+// writePostmortemCanvas puts the postmortem in the incident channel's canvas,
+// creating it the first time
+func writePostmortemCanvas(inc *incident, markdown string) error {
+	content := slack.DocumentContent{Type: "markdown", Markdown: markdown}
+	if inc.PostmortemCanvas != "" {
+		err := slackClient.EditCanvas(slack.EditCanvasParams{
+			CanvasID: inc.PostmortemCanvas,
+			Changes:  []slack.CanvasChange{{Operation: "replace", DocumentContent: content}},
+		})
+		if err != nil {
+			return fmt.Errorf("rewriting canvas %s: %w", inc.PostmortemCanvas, err)
+		}
+		text := fmt.Sprintf(":memo: I've written the postmortem draft for incident #%d again, in this channel's canvas.", inc.Number)
+		_, err = sendMessage(outboundMessage{Channel: inc.Channel, Importance: importanceInfo, Text: text})
+		return err
+	}
+
+	canvas, err := slackClient.CreateChannelCanvas(inc.Channel, content)
+	if err != nil {
+		return fmt.Errorf("creating canvas: %w", err)
+	}
+	incidentsMu.Lock()
+	var current incident
+	found, err := store.Get(incidentsBucket, inc.Channel, &current)
+	if err == nil && found {
+		current.PostmortemCanvas = canvas
+		err = store.Put(incidentsBucket, inc.Channel, current)
+	}
+	incidentsMu.Unlock()
+	if err != nil {
+		log.Printf("Error saving incident: %v", err)
+	}
+	text := fmt.Sprintf(":memo: There's a postmortem draft for incident #%d in this channel's canvas to fill in. Run `/incident postmortem` here to write it again.", inc.Number)
+	_, err = sendMessage(outboundMessage{Channel: inc.Channel, Importance: importanceInfo, Text: text})
+	return err
+}
+
+// draftPostmortem reads the incident channel for the timeline, from the
+// messages recorded to it, pinned ones and ones flagged with
+// incidents.flag_reaction, and the people who took part
+func draftPostmortem(inc incident) (postmortemDraft, error) {
+	draft := postmortemDraft{Incident: inc}
+	end := inc.ResolvedAt
+	if end.IsZero() {
+		end = time.Now().UTC()
+	}
+	messages, truncated, err := incidentChannelMessages(inc.Channel, inc.StartedAt, end)
+	if err != nil {
+		return draft, err
+	}
+	draft.Truncated = truncated
+
+	pinned := map[string]bool{}
+	items, _, err := slackClient.ListPins(inc.Channel)
+	if err != nil {
+		return draft, fmt.Errorf("listing pins in %s: %w", inc.Channel, err)
+	}
+	for _, item := range items {
+		if item.Message != nil {
+			pinned[item.Message.Timestamp] = true
+		}
+	}
+
+	names := map[string]string{}
+	draft.Timeline = append(draft.Timeline, postmortemEvent{
+		At:   inc.StartedAt,
+		Text: fmt.Sprintf("%s incident opened by %s", inc.Severity, exportUserName(inc.Commander, names)),
+	})
+	flag := incidentFlagReaction()
+	onTimeline := map[string]bool{}
+	for _, e := range inc.Timeline {
+		onTimeline[e.TS] = true
+	}
+	recorded := map[string]bool{}
+	counts := map[string]int{}
+	for _, msg := range messages {
+		if msg.User != "" && msg.BotID == "" && msg.User != botUserID {
+			counts[msg.User]++
+		}
+		flagged := false
+		for _, r := range msg.Reactions {
+			flagged = flagged || r.Name == flag
+		}
+		if !pinned[msg.Timestamp] && !flagged && !onTimeline[msg.Timestamp] {
+			continue
+		}
+		recorded[msg.Timestamp] = true
+		author := msg.Username
+		if msg.User != "" {
+			author = exportUserName(msg.User, names)
+		}
+		if author == "" {
+			author = "A bot"
+		}
+		text := strings.Join(strings.Fields(resolveMarkup(msg.Text, names)), " ")
+		draft.Timeline = append(draft.Timeline, postmortemEvent{At: slackTSTime(msg.Timestamp), Text: author + ": " + text})
+	}
+	// Messages recorded to the timeline may be gone from the channel by now
+	for _, e := range inc.Timeline {
+		if recorded[e.TS] {
+			continue
+		}
+		author := "A bot"
+		if e.Author != "" {
+			author = exportUserName(e.Author, names)
+		}
+		text := strings.Join(strings.Fields(resolveMarkup(e.Text, names)), " ")
+		draft.Timeline = append(draft.Timeline, postmortemEvent{At: e.At, Text: author + ": " + text})
+	}
+	if !inc.ResolvedAt.IsZero() {
+		draft.Timeline = append(draft.Timeline, postmortemEvent{
+			At:   inc.ResolvedAt,
+			Text: "Resolved by " + exportUserName(inc.ResolvedBy, names),
+		})
+	}
+	sort.SliceStable(draft.Timeline, func(i, j int) bool { return draft.Timeline[i].At.Before(draft.Timeline[j].At) })
+
+	if _, ok := counts[inc.Commander]; !ok {
+		counts[inc.Commander] = 0
+	}
+	for userID, n := range counts {
+		draft.Participants = append(draft.Participants, postmortemParticipant{Name: exportUserName(userID, names), Messages: n})
+	}
+	sort.Slice(draft.Participants, func(i, j int) bool {
+		a, b := draft.Participants[i], draft.Participants[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Name < b.Name
+	})
+	return draft, nil
+}
+
+// incidentChannelMessages returns the messages posted in channel between
+// from and until, with thread replies, up to exports.max_messages
+func incidentChannelMessages(channel string, from, until time.Time) ([]slack.Message, bool, error) {
+	limit := exportMaxMessages()
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channel,
+		Oldest:    fmt.Sprintf("%d.000000", from.Unix()),
+		Latest:    fmt.Sprintf("%d.000000", until.Unix()+1),
+		Limit:     200,
+	}
+	var messages []slack.Message
+	for {
+		page, err := slackClient.GetConversationHistory(params)
+		if err != nil {
+			return nil, false, fmt.Errorf("fetching history of %s: %w", channel, err)
+		}
+		messages = append(messages, page.Messages...)
+		if len(messages) >= limit {
+			return messages[:limit], true, nil
+		}
+		if !page.HasMore || page.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.ResponseMetaData.NextCursor
+	}
+	for _, msg := range messages {
+		if msg.ReplyCount == 0 {
+			continue
+		}
+		thread, err := fetchThread(channel, msg.Timestamp)
+		if err != nil {
+			return nil, false, err
+		}
+		// The first message of a thread is already in the history
+		messages = append(messages, thread[min(1, len(thread)):]...)
+		if len(messages) >= limit {
+			return messages[:limit], true, nil
+		}
+	}
+	return messages, false, nil
+}
+
+// renderPostmortem writes the postmortem skeleton as Markdown, with the
+// sections people fill in left as prompts
+func renderPostmortem(draft postmortemDraft) string {
+	inc := draft.Incident
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Postmortem: incident #%d, %s\n\n", inc.Number, inc.Title)
+	fmt.Fprintf(&b, "- **Severity:** %s\n", inc.Severity)
+	fmt.Fprintf(&b, "- **Started:** %s\n", inc.StartedAt.UTC().Format(postmortemTimeLayout))
+	if inc.ResolvedAt.IsZero() {
+		fmt.Fprintf(&b, "- **Resolved:** not yet\n")
+		fmt.Fprintf(&b, "- **Duration so far:** %s\n", time.Since(inc.StartedAt).Round(time.Minute))
+	} else {
+		fmt.Fprintf(&b, "- **Resolved:** %s\n", inc.ResolvedAt.UTC().Format(postmortemTimeLayout))
+		fmt.Fprintf(&b, "- **Duration:** %s\n", inc.ResolvedAt.Sub(inc.StartedAt).Round(time.Minute))
+	}
+	b.WriteString("\n## Summary\n\n")
+	if inc.Summary != "" {
+		b.WriteString(inc.Summary + "\n")
+	} else {
+		b.WriteString("_What happened, in a few sentences._\n")
+	}
+
+	b.WriteString("\n## Impact\n\n_Who and what was affected, and for how long._\n")
+
+	b.WriteString("\n## Timeline\n\n")
+	for _, event := range draft.Timeline {
+		fmt.Fprintf(&b, "- **%s** %s\n", event.At.UTC().Format(postmortemTimeLayout), event.Text)
+	}
+	fmt.Fprintf(&b, "\n_From messages recorded with :%s:, pinned, or flagged with :%s: in the incident channel._\n", incidentTimelineReaction(), incidentFlagReaction())
+	if draft.Truncated {
+		fmt.Fprintf(&b, "_The channel had more than %d messages, so later ones aren't here._\n", exportMaxMessages())
+	}
+
+	b.WriteString("\n## Participants\n\n")
+	for _, p := range draft.Participants {
+		switch p.Messages {
+		case 0:
+			fmt.Fprintf(&b, "- %s\n", p.Name)
+		case 1:
+			fmt.Fprintf(&b, "- %s (1 message)\n", p.Name)
+		default:
+			fmt.Fprintf(&b, "- %s (%d messages)\n", p.Name, p.Messages)
+		}
+	}
+
+	b.WriteString("\n## Root cause\n\n_Why it happened._\n")
+	b.WriteString("\n## What went well\n\n- \n")
+	b.WriteString("\n## What went wrong\n\n- \n")
+	b.WriteString("\n## Action items\n\n- [ ] \n")
+	return b.String()
+}
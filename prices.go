@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+// Used when the prices settings aren't set
+const (
+	defaultPriceCacheFor       = time.Minute
+	defaultPriceCryptoCurrency = "usd"
+)
+
+// Sparkline image size, in pixels
+const (
+	sparklineWidth  = 300
+	sparklineHeight = 80
+)
+
+// tickerPattern matches stock and crypto tickers like AAPL, BRK.B or BTC-USD
+var tickerPattern = regexp.MustCompile(`^[A-Za-z0-9^][A-Za-z0-9.\-=^]{0,14}$`)
+
+// errQuoteNotFound is returned by providers that don't know a ticker, so the
+// next provider gets a try
+var errQuoteNotFound = errors.New("ticker not found")
+
+// priceQuote is a ticker's current price and how it moved today
+type priceQuote struct {
+	Symbol   string
+	Name     string
+	Currency string
+	Price    float64
+	// Open is the price the change is measured from: the previous close, or
+	// the price 24 hours ago for crypto
+	Open float64
+	// Points are today's prices, oldest first, for the sparkline
+	Points []float64
+	Source string
+}
+
+// quoteProvider looks up prices in a market data service
+type quoteProvider interface {
+	Quote(ticker string) (*priceQuote, error)
+}
+
+// quoteProviderFactories creates quote providers by the name used in config
+var quoteProviderFactories = map[string]func(PricesConfig) (quoteProvider, error){
+	"yahoo":     newYahooQuoteProvider,
+	"coingecko": newCoinGeckoQuoteProvider,
+}
+
+// cachedQuote is a ticker's quote as last looked up
+type cachedQuote struct {
+	quote   *priceQuote
+	expires time.Time
+}
+
+var (
+	// The configured providers, tried in order; empty when prices.providers
+	// isn't set
+	quoteSources []quoteProvider
+
+	quoteCacheMu sync.Mutex
+	quoteCache   = map[string]cachedQuote{}
+)
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/price",
+		Usage:       "/price <ticker>",
+		Description: "Get a stock or crypto price with today's change, e.g. `/price AAPL` or `/price BTC`",
+		Handler:     handlePrice,
+	})
+}
+
+// setupPrices builds the providers listed in prices.providers
+func setupPrices(cfg PricesConfig) error {
+	quoteSources = nil
+	for _, name := range cfg.Providers {
+		factory, ok := quoteProviderFactories[name]
+		if !ok {
+			return fmt.Errorf("unknown quote provider %q", name)
+		}
+		provider, err := factory(cfg)
+		if err != nil {
+			return fmt.Errorf("configuring quote provider %s: %w", name, err)
+		}
+		quoteSources = append(quoteSources, provider)
+	}
+	return nil
+}
+
+func handlePrice(req commandRequest) commandResponse {
+	if len(quoteSources) == 0 {
+		return ephemeral("Price lookups aren't set up. See `prices` in the config.")
+	}
+	ticker := strings.ToUpper(strings.TrimSpace(req.Text))
+	if !tickerPattern.MatchString(ticker) {
+		return ephemeral("Usage: `/price <ticker>`, e.g. `/price AAPL` or `/price BTC`")
+	}
+	// Providers can take longer than Slack waits for a slash command
+	go runJob("price", func() { answerPrice(req, ticker) })
+	return ephemeral(":chart_with_upwards_trend: Looking up %s…", ticker)
+}
+
+// answerPrice shares the quote with its sparkline in the channel, or as
+// text alone where the bot can't upload
+func answerPrice(req commandRequest, ticker string) {
+	q, err := lookupQuote(ticker)
+	if err != nil {
+		text := "Sorry, something went wrong looking up " + ticker + "."
+		if errors.Is(err, errQuoteNotFound) {
+			text = "I couldn't find a price for " + ticker + "."
+		} else {
+			log.Printf("Error looking up %s: %v", ticker, err)
+		}
+		if err := respond(req.ResponseURL, &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true}); err != nil {
+			log.Printf("Error replying to /price: %v", err)
+		}
+		return
+	}
+	summary := fmt.Sprintf("<@%s> asked for the price of %s", req.UserID, priceQuoteText(q))
+
+	if len(q.Points) >= 2 {
+		image, err := renderSparkline(q.Points, q.Price >= q.Open)
+		if err == nil {
+			_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+				Channel:        req.ChannelID,
+				Reader:         bytes.NewReader(image),
+				FileSize:       len(image),
+				Filename:       strings.ToLower(q.Symbol) + ".png",
+				Title:          q.Symbol + " today",
+				InitialComment: summary,
+				AltTxt:         fmt.Sprintf("Sparkline of %s today, from %s to %s", q.Symbol, formatQuotePrice(q.Points[0]), formatQuotePrice(q.Price)),
+			})
+		}
+		if err == nil {
+			if err := respond(req.ResponseURL, &slack.WebhookMessage{DeleteOriginal: true}); err != nil {
+				log.Printf("Error clearing /price reply: %v", err)
+			}
+			return
+		}
+		// The bot isn't in every channel, and can't upload to ones it isn't in
+		log.Printf("Error sharing %s sparkline: %v", q.Symbol, err)
+	}
+	if err := respond(req.ResponseURL, &slack.WebhookMessage{Text: summary, ResponseType: slack.ResponseTypeInChannel}); err != nil {
+		log.Printf("Error replying to /price: %v", err)
+	}
+}
+
+// lookupQuote asks each provider in turn, caching the answer for
+// prices.cache_for
+func lookupQuote(ticker string) (*priceQuote, error) {
+	quoteCacheMu.Lock()
+	cached, ok := quoteCache[ticker]
+	quoteCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.quote, nil
+	}
+
+	var q *priceQuote
+	err := errQuoteNotFound
+	for _, provider := range quoteSources {
+		if q, err = provider.Quote(ticker); !errors.Is(err, errQuoteNotFound) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl := appConfig.Prices.CacheFor
+	if ttl <= 0 {
+		ttl = defaultPriceCacheFor
+	}
+	quoteCacheMu.Lock()
+	for key, c := range quoteCache {
+		if time.Now().After(c.expires) {
+			delete(quoteCache, key)
+		}
+	}
+	quoteCache[ticker] = cachedQuote{quote: q, expires: time.Now().Add(ttl)}
+	quoteCacheMu.Unlock()
+	return q, nil
+}
+
+// priceQuoteText is a one-line summary like "*AAPL* (Apple Inc.): 189.20 USD,
+// :chart_with_upwards_trend: +1.25% (+2.34) today"
+func priceQuoteText(q *priceQuote) string {
+	name := "*" + q.Symbol + "*"
+	if q.Name != "" && !strings.EqualFold(q.Name, q.Symbol) {
+		name += " (" + escapeMrkdwn(q.Name) + ")"
+	}
+	text := fmt.Sprintf("%s: %s %s", name, formatQuotePrice(q.Price), strings.ToUpper(q.Currency))
+	if q.Open > 0 {
+		change := q.Price - q.Open
+		trend := ":chart_with_upwards_trend:"
+		if change < 0 {
+			trend = ":chart_with_downwards_trend:"
+		}
+		text += fmt.Sprintf(", %s %+.2f%% (%+.*f) today", trend, change/q.Open*100, quotePrecision(change), change)
+	}
+	return text + " · " + q.Source
+}
+
+// quotePrecision keeps a few significant digits of prices under a dollar
+func quotePrecision(v float64) int {
+	v = math.Abs(v)
+	if v == 0 || v >= 1 {
+		return 2
+	}
+	return min(8, 2-int(math.Floor(math.Log10(v))))
+}
+
+func formatQuotePrice(v float64) string {
+	return fmt.Sprintf("%.*f", quotePrecision(v), v)
+}
+
+// renderSparkline draws the prices as a bare line, green when up
+func renderSparkline(points []float64, up bool) ([]byte, error) {
+	xValues := make([]float64, len(points))
+	for i := range points {
+		xValues[i] = float64(i)
+	}
+	color := drawing.ColorFromHex("d93f0b")
+	if up {
+		color = drawing.ColorFromHex("1a7f37")
+	}
+	graph := chart.Chart{
+		Width:      sparklineWidth,
+		Height:     sparklineHeight,
+		Background: chart.Style{Padding: chart.Box{Top: 5, Left: 5, Right: 5, Bottom: 5}},
+		XAxis:      chart.XAxis{Style: chart.Hidden()},
+		YAxis:      chart.YAxis{Style: chart.Hidden()},
+		// go-chart draws the secondary axis line unless it's hidden too
+		YAxisSecondary: chart.YAxis{Style: chart.Hidden()},
+		Series: []chart.Series{chart.ContinuousSeries{
+			Style:   chart.Style{StrokeColor: color, StrokeWidth: 2},
+			XValues: xValues,
+			YValues: points,
+		}},
+	}
+	var buf bytes.Buffer
+	if err := graph.Render(chart.PNG, &buf); err != nil {
+		return nil, fmt.Errorf("rendering sparkline: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// priceGet calls a provider's API and decodes the JSON answer into out. A
+// 404 means the provider doesn't know the ticker.
+func priceGet(endpoint string, headers map[string]string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	// Yahoo turns away requests without a user agent
+	req.Header.Set("User-Agent", "slack-bot")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errQuoteNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(out)
+}
+
+// yahooQuoteProvider reads Yahoo Finance's chart API, which covers stocks,
+// funds, indices and crypto pairs like BTC-USD, without a key
+type yahooQuoteProvider struct {
+	url string
+}
+
+func newYahooQuoteProvider(cfg PricesConfig) (quoteProvider, error) {
+	return yahooQuoteProvider{url: "https://query1.finance.yahoo.com"}, nil
+}
+
+func (p yahooQuoteProvider) Quote(ticker string) (*priceQuote, error) {
+	var out struct {
+		Chart struct {
+			Result []struct {
+				Meta struct {
+					Symbol             string  `json:"symbol"`
+					Currency           string  `json:"currency"`
+					LongName           string  `json:"longName"`
+					ShortName          string  `json:"shortName"`
+					RegularMarketPrice float64 `json:"regularMarketPrice"`
+					ChartPreviousClose float64 `json:"chartPreviousClose"`
+					PreviousClose      float64 `json:"previousClose"`
+				} `json:"meta"`
+				Indicators struct {
+					Quote []struct {
+						Close []*float64 `json:"close"`
+					} `json:"quote"`
+				} `json:"indicators"`
+			} `json:"result"`
+		} `json:"chart"`
+	}
+	endpoint := p.url + "/v8/finance/chart/" + url.PathEscape(ticker) + "?range=1d&interval=5m"
+	if err := priceGet(endpoint, nil, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Chart.Result) == 0 || out.Chart.Result[0].Meta.RegularMarketPrice == 0 {
+		return nil, errQuoteNotFound
+	}
+	r := out.Chart.Result[0]
+	q := &priceQuote{Symbol: r.Meta.Symbol, Name: r.Meta.LongName, Currency: r.Meta.Currency, Price: r.Meta.RegularMarketPrice, Open: r.Meta.PreviousClose, Source: "Yahoo Finance"}
+	if q.Name == "" {
+		q.Name = r.Meta.ShortName
+	}
+	if q.Open == 0 {
+		q.Open = r.Meta.ChartPreviousClose
+	}
+	if len(r.Indicators.Quote) > 0 {
+		for _, c := range r.Indicators.Quote[0].Close {
+			// Minutes without trades are null
+			if c != nil {
+				q.Points = append(q.Points, *c)
+			}
+		}
+	}
+	return q, nil
+}
+
+// coinGeckoQuoteProvider reads crypto prices from CoinGecko by coin
+// symbol. A demo API key is read from prices.api_key_env when set.
+type coinGeckoQuoteProvider struct {
+	url, key, currency string
+}
+
+func newCoinGeckoQuoteProvider(cfg PricesConfig) (quoteProvider, error) {
+	p := coinGeckoQuoteProvider{url: "https://api.coingecko.com/api/v3", currency: strings.ToLower(cfg.CryptoCurrency)}
+	if p.currency == "" {
+		p.currency = defaultPriceCryptoCurrency
+	}
+	if cfg.APIKeyEnv != "" {
+		if p.key = os.Getenv(cfg.APIKeyEnv); p.key == "" {
+			return nil, fmt.Errorf("%s is not set", cfg.APIKeyEnv)
+		}
+	}
+	return p, nil
+}
+
+func (p coinGeckoQuoteProvider) Quote(ticker string) (*priceQuote, error) {
+	headers := map[string]string{}
+	if p.key != "" {
+		headers["x-cg-demo-api-key"] = p.key
+	}
+	// Symbols aren't unique; search ranks the biggest coin first
+	var search struct {
+		Coins []struct {
+			ID     string `json:"id"`
+			Symbol string `json:"symbol"`
+			Name   string `json:"name"`
+		} `json:"coins"`
+	}
+	if err := priceGet(p.url+"/search?query="+url.QueryEscape(ticker), headers, &search); err != nil {
+		return nil, err
+	}
+	var id, name string
+	for _, coin := range search.Coins {
+		if strings.EqualFold(coin.Symbol, ticker) {
+			id, name = coin.ID, coin.Name
+			break
+		}
+	}
+	if id == "" {
+		return nil, errQuoteNotFound
+	}
+
+	var market struct {
+		Prices [][2]float64 `json:"prices"`
+	}
+	endpoint := fmt.Sprintf("%s/coins/%s/market_chart?vs_currency=%s&days=1", p.url, url.PathEscape(id), url.QueryEscape(p.currency))
+	if err := priceGet(endpoint, headers, &market); err != nil {
+		return nil, err
+	}
+	if len(market.Prices) == 0 {
+		return nil, errQuoteNotFound
+	}
+	q := &priceQuote{Symbol: strings.ToUpper(ticker), Name: name, Currency: p.currency, Source: "CoinGecko"}
+	for _, point := range market.Prices {
+		q.Points = append(q.Points, point[1])
+	}
+	q.Open, q.Price = q.Points[0], q.Points[len(q.Points)-1]
+	return q, nil
+}
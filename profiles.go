@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Profiles treated as production, which get no banner
+var productionProfiles = []string{"prod", "production"}
+
+// applyProfile overlays the named profile's settings onto cfg. Fields the
+// profile sets replace the base value; lists are replaced, not appended.
+func applyProfile(cfg *Config, name string) error {
+	if name == "" {
+		return nil
+	}
+	node, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown config profile %q", name)
+	}
+	if err := node.Decode(cfg); err != nil {
+		return fmt.Errorf("applying config profile %s: %w", name, err)
+	}
+	cfg.Profile = name
+	return nil
+}
+
+// profileBanner returns the label for messages sent from a non-production
+// profile, or "" in production
+func profileBanner() string {
+	if appConfig.Banner != "" {
+		return appConfig.Banner
+	}
+	profile := strings.ToLower(appConfig.Profile)
+	for _, prod := range productionProfiles {
+		if profile == prod {
+			return ""
+		}
+	}
+	if profile == "" {
+		return ""
+	}
+	return strings.ToUpper(profile)
+}
+
+// withBanner marks a message as coming from a non-production bot, so people
+// don't act on test messages
+func withBanner(text string, blocks []slack.Block) (string, []slack.Block) {
+	banner := profileBanner()
+	if banner == "" {
+		return text, blocks
+	}
+	text = "[" + banner + "] " + text
+	if len(blocks) > 0 {
+		label := slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf(":construction: *%s* — sent by a test instance of the bot", banner), false, false))
+		blocks = append([]slack.Block{label}, blocks...)
+	}
+	return text, blocks
+}
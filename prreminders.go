@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for when review reminders last went out, under the key
+// "state"
+const prRemindersBucket = "pr_reminders"
+
+// Used when the pr_reminders settings aren't set
+const (
+	defaultPRReminderSchedule = "0 10 * * mon-fri"
+	defaultPRStaleAfter       = 24 * time.Hour
+)
+
+// Most pull requests read per repository, in one page
+const prRemindersPerRepo = 100
+
+// prRemindersState is when the reminders last went out
+type prRemindersState struct {
+	LastRun time.Time `json:"last_run"`
+}
+
+// githubPullRequest is the part of a GitHub pull request the reminders read
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+	Draft   bool   `json:"draft"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	RequestedReviewers []struct {
+		Login string `json:"login"`
+	} `json:"requested_reviewers"`
+	RequestedTeams []struct {
+		Slug string `json:"slug"`
+	} `json:"requested_teams"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// stalePR is a pull request that has waited too long for review
+type stalePR struct {
+	Repo string
+	PR   githubPullRequest
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin pr-reminders",
+		Usage:       "admin pr-reminders",
+		Description: "Send the pull request review reminders now",
+		AdminOnly:   true,
+		Handler:     handlePRRemindersNow,
+	})
+}
+
+// startPRReminders reminds reviewers of stale pull requests on
+// pr_reminders.schedule
+func startPRReminders() {
+	if len(appConfig.PRReminders.Repos) == 0 {
+		return
+	}
+	runEvery("review reminders", time.Minute, runDuePRReminders)
+}
+
+// prReminderSchedule parses pr_reminders.schedule and pr_reminders.timezone
+func prReminderSchedule() (cronSchedule, *time.Location, error) {
+	cfg := appConfig.PRReminders
+	expr := cfg.Schedule
+	if expr == "" {
+		expr = defaultPRReminderSchedule
+	}
+	cron, err := parseCron(expr)
+	if err != nil {
+		return cronSchedule{}, nil, fmt.Errorf("pr_reminders.schedule: %w", err)
+	}
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return cronSchedule{}, nil, fmt.Errorf("pr_reminders.timezone: %w", err)
+		}
+	}
+	return cron, loc, nil
+}
+
+func runDuePRReminders() {
+	cron, loc, err := prReminderSchedule()
+	if err != nil {
+		log.Printf("Error scheduling review reminders: %v", err)
+		return
+	}
+	var state prRemindersState
+	if _, err := store.Get(prRemindersBucket, "state", &state); err != nil {
+		log.Printf("Error loading review reminders: %v", err)
+		return
+	}
+	now := time.Now()
+	if state.LastRun.IsZero() {
+		// The first reminders wait for the schedule rather than going out
+		// the moment they're set up
+		state.LastRun = now.UTC()
+		if err := store.Put(prRemindersBucket, "state", state); err != nil {
+			log.Printf("Error saving review reminders: %v", err)
+		}
+		return
+	}
+	if next := cron.next(state.LastRun, loc); next.IsZero() || now.Before(next) {
+		return
+	}
+	sendPRReminders()
+}
+
+func handlePRRemindersNow(req commandRequest) commandResponse {
+	if len(appConfig.PRReminders.Repos) == 0 {
+		return ephemeral("Review reminders aren't set up. See `pr_reminders` in the config.")
+	}
+	recordAdminAudit(req.UserID, roleAdmin, "pr reminders", "sent the review reminders")
+	go runJob("review reminders", sendPRReminders)
+	return ephemeral(":eyes: Checking for pull requests waiting on review.")
+}
+
+// sendPRReminders DMs each linked reviewer their stale pull requests and
+// posts the whole list to pr_reminders.channel
+func sendPRReminders() {
+	// Recorded first so a failing repository doesn't retry every minute
+	if err := store.Put(prRemindersBucket, "state", prRemindersState{LastRun: time.Now().UTC()}); err != nil {
+		log.Printf("Error saving review reminders: %v", err)
+		return
+	}
+	stale := findStalePRs()
+
+	byReviewer := map[string][]stalePR{}
+	for _, s := range stale {
+		for _, r := range s.PR.RequestedReviewers {
+			if user := slackUserForGitHub(r.Login); user != "" {
+				byReviewer[user] = append(byReviewer[user], s)
+			}
+		}
+	}
+	for user, prs := range byReviewer {
+		lines := []string{fmt.Sprintf(":eyes: %s waiting on your review:", plural(len(prs), "pull request is", "pull requests are"))}
+		for _, s := range prs {
+			lines = append(lines, "• "+prReminderLine(s, false))
+		}
+		if _, err := sendDM("pr_reminders", user, outboundMessage{Text: strings.Join(lines, "\n")}); err != nil {
+			log.Printf("Error sending review reminder to %s: %v", user, err)
+		}
+	}
+
+	channel := appConfig.PRReminders.Channel
+	if channel == "" || len(stale) == 0 {
+		return
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceInfo, Text: "Pull requests waiting on review", Blocks: prReminderBlocks(stale)}); err != nil {
+		log.Printf("Error posting review summary: %v", err)
+	}
+}
+
+// findStalePRs lists open, non-draft pull requests with reviews still
+// requested that nobody has touched for pr_reminders.stale_after, oldest
+// first
+func findStalePRs() []stalePR {
+	staleAfter := appConfig.PRReminders.StaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultPRStaleAfter
+	}
+	cutoff := time.Now().Add(-staleAfter)
+	var stale []stalePR
+	for _, repo := range appConfig.PRReminders.Repos {
+		var prs []githubPullRequest
+		path := fmt.Sprintf("/repos/%s/pulls?state=open&sort=updated&direction=asc&per_page=%d", repo, prRemindersPerRepo)
+		if err := githubRequest(http.MethodGet, path, nil, &prs); err != nil {
+			log.Printf("Error listing pull requests of %s: %v", repo, err)
+			continue
+		}
+		for _, pr := range prs {
+			if pr.Draft || len(pr.RequestedReviewers)+len(pr.RequestedTeams) == 0 || pr.UpdatedAt.After(cutoff) {
+				continue
+			}
+			stale = append(stale, stalePR{Repo: repo, PR: pr})
+		}
+	}
+	slices.SortStableFunc(stale, func(a, b stalePR) int { return a.PR.UpdatedAt.Compare(b.PR.UpdatedAt) })
+	return stale
+}
+
+// prReminderLine describes a pull request, naming its reviewers if asked
+func prReminderLine(s stalePR, reviewers bool) string {
+	title := strings.NewReplacer("<", "", ">", "", "|", "").Replace(s.PR.Title)
+	line := fmt.Sprintf("<%s|%s#%d> %s by %s, quiet since %s", s.PR.HTMLURL, s.Repo, s.PR.Number, title, githubMention(s.PR.User.Login), slackDate(s.PR.UpdatedAt))
+	if !reviewers {
+		return line
+	}
+	var waiting []string
+	for _, r := range s.PR.RequestedReviewers {
+		waiting = append(waiting, githubMention(r.Login))
+	}
+	for _, t := range s.PR.RequestedTeams {
+		waiting = append(waiting, "`@"+t.Slug+"`")
+	}
+	return line + " · waiting on " + strings.Join(waiting, ", ")
+}
+
+// prReminderBlocks renders the team summary of stale pull requests
+func prReminderBlocks(stale []stalePR) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Waiting on review", false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, plural(len(stale), "pull request")+" with no activity for a while", false, false)),
+	}
+	var lines []string
+	flush := func() {
+		if len(lines) > 0 {
+			blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, strings.Join(lines, "\n"), false, false), nil, nil))
+			lines = nil
+		}
+	}
+	size := 0
+	for _, s := range stale {
+		line := "• " + prReminderLine(s, true)
+		if size+len(line)+1 > maxSectionText {
+			// Leaves room for the section holding the rest
+			if len(blocks) >= maxMessageBlocks-1 {
+				break
+			}
+			flush()
+			size = 0
+		}
+		lines = append(lines, line)
+		size += len(line) + 1
+	}
+	flush()
+	return blocks
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for per-user quick action settings, keyed by user ID
+const quickActionsBucket = "quick_actions"
+
+// Quick action modes
+const (
+	// Only reactions on the user's quick actions message count
+	quickModeHome = "home"
+	// Reactions on any message count
+	quickModeAny = "any"
+	quickModeOff = "off"
+)
+
+// quickActions is one user's emoji → command mapping
+type quickActions struct {
+	Mode string `json:"mode"`
+	// Commands maps emoji names (without colons) to /bot command lines.
+	// {text} and {link} are replaced with the reacted message's text and
+	// permalink.
+	Commands map[string]string `json:"commands"`
+	// Channel and TS locate the quick actions message in the user's DM
+	Channel string `json:"channel,omitempty"`
+	TS      string `json:"ts,omitempty"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "quick add",
+		Usage:       "quick add :emoji: <command>",
+		Description: "Run a /bot command when you react with an emoji, e.g. `quick add :clipboard: remind me {text}`",
+		Handler:     handleQuickAdd,
+	})
+	registerBotCommand(&command{
+		Name:        "quick remove",
+		Usage:       "quick remove :emoji:",
+		Description: "Remove an emoji quick action",
+		Handler:     handleQuickRemove,
+	})
+	registerBotCommand(&command{
+		Name:        "quick mode",
+		Usage:       "quick mode home|any|off",
+		Description: "Choose whether quick actions work only on your quick actions message or on any message",
+		Handler:     handleQuickMode,
+	})
+	registerBotCommand(&command{
+		Name:        "quick post",
+		Usage:       "quick post",
+		Description: "Post your quick actions message to our DM",
+		Handler:     handleQuickPost,
+	})
+	registerHomeSection(quickActionsHomeSection)
+	registerUserDataset(userDataset{Name: "quick_actions", Title: "Quick actions", Describe: describeUserQuickActions, Delete: deleteUserQuickActions})
+}
+
+// loadQuickActions returns userID's quick actions, which may be empty
+func loadQuickActions(userID string) (*quickActions, error) {
+	actions := &quickActions{Mode: quickModeHome, Commands: map[string]string{}}
+	if _, err := store.Get(quickActionsBucket, userID, actions); err != nil {
+		return nil, err
+	}
+	if actions.Commands == nil {
+		actions.Commands = map[string]string{}
+	}
+	return actions, nil
+}
+
+func saveQuickActions(userID string, actions *quickActions) error {
+	if err := store.Put(quickActionsBucket, userID, actions); err != nil {
+		return err
+	}
+	refreshHome(userID)
+	return nil
+}
+
+// emojiName turns ":clock1:" into "clock1"
+func emojiName(s string) string {
+	return strings.Trim(strings.TrimSpace(s), ":")
+}
+
+func handleQuickAdd(req commandRequest) commandResponse {
+	if len(req.Args) < 2 {
+		return ephemeral("Usage: `%s quick add :emoji: <command>`", botCommand)
+	}
+	actions, err := loadQuickActions(req.UserID)
+	if err != nil {
+		log.Printf("Error loading quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong loading your quick actions.")
+	}
+	emoji := emojiName(req.Args[0])
+	line := strings.TrimPrefix(strings.Join(req.Args[1:], " "), botCommand+" ")
+	actions.Commands[emoji] = line
+	if err := saveQuickActions(req.UserID, actions); err != nil {
+		log.Printf("Error saving quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong saving your quick actions.")
+	}
+	return ephemeral("Reacting with :%s: will now run `%s %s`.", emoji, botCommand, line)
+}
+
+func handleQuickRemove(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s quick remove :emoji:`", botCommand)
+	}
+	actions, err := loadQuickActions(req.UserID)
+	if err != nil {
+		log.Printf("Error loading quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong loading your quick actions.")
+	}
+	emoji := emojiName(req.Args[0])
+	if _, ok := actions.Commands[emoji]; !ok {
+		return ephemeral("You don't have a quick action for :%s:.", emoji)
+	}
+	delete(actions.Commands, emoji)
+	if err := saveQuickActions(req.UserID, actions); err != nil {
+		log.Printf("Error saving quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong saving your quick actions.")
+	}
+	return ephemeral("Removed the quick action for :%s:.", emoji)
+}
+
+func handleQuickMode(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s quick mode home|any|off`", botCommand)
+	}
+	mode := strings.ToLower(req.Args[0])
+	switch mode {
+	case quickModeHome, quickModeAny, quickModeOff:
+	default:
+		return ephemeral("Mode must be `home`, `any` or `off`.")
+	}
+	actions, err := loadQuickActions(req.UserID)
+	if err != nil {
+		log.Printf("Error loading quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong loading your quick actions.")
+	}
+	actions.Mode = mode
+	if err := saveQuickActions(req.UserID, actions); err != nil {
+		log.Printf("Error saving quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong saving your quick actions.")
+	}
+	return ephemeral("Quick actions mode set to `%s`.", mode)
+}
+
+func handleQuickPost(req commandRequest) commandResponse {
+	actions, err := loadQuickActions(req.UserID)
+	if err != nil {
+		log.Printf("Error loading quick actions: %v", err)
+		return ephemeral("Sorry, something went wrong loading your quick actions.")
+	}
+	if len(actions.Commands) == 0 {
+		return ephemeral("Add a quick action first with `%s quick add :emoji: <command>`.", botCommand)
+	}
+	channel, err := openDM(req.UserID)
+	if err != nil {
+		log.Printf("Error posting quick actions: %v", err)
+		return ephemeral("Sorry, I couldn't open a DM with you.")
+	}
+	text := "*Your quick actions*\nReact to this message to run a command:\n" + quickActionsList(actions)
+	ts, err := sendMessage(outboundMessage{
+		Channel: channel,
+		Text:    text,
+		Blocks:  []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)},
+		NeedTS:  true,
+	})
+	if err != nil {
+		log.Printf("Error posting quick actions: %v", err)
+		return ephemeral("Sorry, I couldn't post your quick actions.")
+	}
+	actions.Channel, actions.TS = channel, ts
+	if err := saveQuickActions(req.UserID, actions); err != nil {
+		log.Printf("Error saving quick actions: %v", err)
+	}
+	return ephemeral("Posted your quick actions to our DM.")
+}
+
+// quickActionsList renders one line per mapping, sorted by emoji
+func quickActionsList(actions *quickActions) string {
+	emojis := make([]string, 0, len(actions.Commands))
+	for emoji := range actions.Commands {
+		emojis = append(emojis, emoji)
+	}
+	sort.Strings(emojis)
+	lines := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		lines[i] = fmt.Sprintf(":%s: `%s %s`", emoji, botCommand, actions.Commands[emoji])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quickActionsHomeSection lists the user's quick actions in the Home tab
+func quickActionsHomeSection(userID string) []slack.Block {
+	actions, err := loadQuickActions(userID)
+	if err != nil || len(actions.Commands) == 0 {
+		return nil
+	}
+	text := fmt.Sprintf("*Quick actions* (mode: `%s`)\n%s", actions.Mode, quickActionsList(actions))
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+	}
+}
+
+// handleQuickActionReaction runs the command mapped to a reaction, if any
+func handleQuickActionReaction(ev *slackevents.ReactionAddedEvent) {
+	if ev.Item.Type != "message" || ev.User == botUserID {
+		return
+	}
+	actions, err := loadQuickActions(ev.User)
+	if err != nil {
+		log.Printf("Error loading quick actions: %v", err)
+		return
+	}
+	line, ok := actions.Commands[ev.Reaction]
+	if !ok || actions.Mode == quickModeOff {
+		return
+	}
+	if actions.Mode != quickModeAny && (ev.Item.Channel != actions.Channel || ev.Item.Timestamp != actions.TS) {
+		return
+	}
+
+	if strings.Contains(line, "{text}") {
+		msg, err := fetchMessage(ev.Item.Channel, ev.Item.Timestamp)
+		if err != nil {
+			log.Printf("Error running quick action: %v", err)
+			return
+		}
+		line = strings.ReplaceAll(line, "{text}", msg.Text)
+	}
+	if strings.Contains(line, "{link}") {
+		link, err := slackClient.GetPermalink(&slack.PermalinkParameters{Channel: ev.Item.Channel, Ts: ev.Item.Timestamp})
+		if err != nil {
+			log.Printf("Error running quick action: %v", err)
+			return
+		}
+		line = strings.ReplaceAll(line, "{link}", link)
+	}
+
+	resp := dispatchBotCommand(slack.SlashCommand{
+		Command:   botCommand,
+		Text:      line,
+		UserID:    ev.User,
+		ChannelID: ev.Item.Channel,
+	}, "", strings.Fields(line))
+
+	channel, err := openDM(ev.User)
+	if err != nil {
+		log.Printf("Error replying to quick action: %v", err)
+		return
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Text: resp.Text, Blocks: resp.Blocks}); err != nil {
+		log.Printf("Error replying to quick action: %v", err)
+	}
+}
+
+func describeUserQuickActions(userID string) (string, error) {
+	actions, err := loadQuickActions(userID)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for emoji, line := range actions.Commands {
+		items = append(items, fmt.Sprintf(":%s: `%s`", emoji, line))
+	}
+	sort.Strings(items)
+	return describeItems(items), nil
+}
+
+func deleteUserQuickActions(userID string) error {
+	return store.Delete(quickActionsBucket, userID)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Store bucket for addresses banned after repeated abuse, keyed by IP
+const ipBansBucket = "ip_bans"
+
+// Paths that aren't rate limited unless rate_limit.exempt says otherwise.
+// Slack sends everything under /slack from its own addresses, and the
+// requests are signed.
+var defaultRateLimitExempt = []string{"/slack/"}
+
+// tokenBucket allows Burst requests at once, refilled at Rate per second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ipBan is an address refused until Until
+type ipBan struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// rateLimitCounts are the rate limiter's metrics for one path prefix
+type rateLimitCounts struct {
+	Allowed, Limited, Banned int64
+}
+
+var (
+	rateLimitMu sync.Mutex
+	// Buckets keyed by "ip:<address>" or "token:<token hash>"
+	rateBuckets = map[string]*tokenBucket{}
+	// Times each address was limited within the ban window
+	rateViolations = map[string][]time.Time{}
+	// Metrics keyed by the first path segment, e.g. /api
+	rateLimitStats = map[string]*rateLimitCounts{}
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin ratelimit",
+		Usage:       "admin ratelimit",
+		Description: "Show rate limiting metrics and banned addresses",
+		AdminOnly:   true,
+		Handler:     handleRateLimitReport,
+	})
+	registerBotCommand(&command{
+		Name:        "admin unban",
+		Usage:       "admin unban <ip>",
+		Description: "Lift a rate limiting ban",
+		AdminOnly:   true,
+		Handler:     handleUnban,
+	})
+}
+
+// startRateLimitCleanup forgets idle buckets so the maps don't grow forever
+func startRateLimitCleanup() {
+	if !appConfig.RateLimit.enabled() {
+		return
+	}
+	runEvery("rate limit cleanup", 10*time.Minute, func() {
+		rateLimitMu.Lock()
+		defer rateLimitMu.Unlock()
+		cutoff := time.Now().Add(-10 * time.Minute)
+		for key, b := range rateBuckets {
+			if b.last.Before(cutoff) {
+				delete(rateBuckets, key)
+			}
+		}
+		for ip, times := range rateViolations {
+			if len(times) == 0 || times[len(times)-1].Before(cutoff) {
+				delete(rateViolations, ip)
+			}
+		}
+	})
+}
+
+func (cfg RateLimitConfig) enabled() bool {
+	return cfg.PerIP.Rate > 0 || cfg.PerToken.Rate > 0
+}
+
+// rateLimit answers 429 to clients over their per-address or per-token
+// limit, and 403 to banned addresses
+func rateLimit(c *gin.Context) {
+	cfg := appConfig.RateLimit
+	path := c.Request.URL.Path
+	if !cfg.enabled() || isRateLimitExempt(path) {
+		c.Next()
+		return
+	}
+	ip := c.ClientIP()
+	prefix := rateLimitPrefix(path)
+
+	if until, banned := ipBanned(ip); banned {
+		countRateLimit(prefix, func(s *rateLimitCounts) { s.Banned++ })
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Too many requests; this address is temporarily banned"})
+		c.Abort()
+		return
+	}
+
+	wait := takeToken("ip:"+ip, cfg.PerIP)
+	if secret, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && secret != "" {
+		wait = max(wait, takeToken("token:"+hashAPIToken(secret), cfg.PerToken))
+	}
+	if wait > 0 {
+		countRateLimit(prefix, func(s *rateLimitCounts) { s.Limited++ })
+		recordRateViolation(ip, cfg)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		c.Abort()
+		return
+	}
+	countRateLimit(prefix, func(s *rateLimitCounts) { s.Allowed++ })
+	c.Next()
+}
+
+func isRateLimitExempt(path string) bool {
+	exempt := appConfig.RateLimit.Exempt
+	if exempt == nil {
+		exempt = defaultRateLimitExempt
+	}
+	return slices.ContainsFunc(exempt, func(prefix string) bool { return strings.HasPrefix(path, prefix) })
+}
+
+// takeToken spends a token from key's bucket, returning how long to wait
+// when there is none. A zero rate means no limit.
+func takeToken(key string, limit RateLimit) time.Duration {
+	if limit.Rate <= 0 {
+		return 0
+	}
+	burst := float64(max(limit.Burst, 1))
+	now := time.Now()
+
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	b, ok := rateBuckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		rateBuckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// recordRateViolation bans ip once it has been limited ban_after times
+// within ban_window
+func recordRateViolation(ip string, cfg RateLimitConfig) {
+	if cfg.BanAfter <= 0 {
+		return
+	}
+	window, duration := cfg.BanWindow, cfg.BanDuration
+	if window <= 0 {
+		window = 10 * time.Minute
+	}
+	if duration <= 0 {
+		duration = time.Hour
+	}
+	now := time.Now()
+
+	rateLimitMu.Lock()
+	times := slices.DeleteFunc(rateViolations[ip], func(t time.Time) bool { return now.Sub(t) > window })
+	times = append(times, now)
+	rateViolations[ip] = times
+	ban := len(times) >= cfg.BanAfter
+	if ban {
+		delete(rateViolations, ip)
+	}
+	rateLimitMu.Unlock()
+
+	if !ban {
+		return
+	}
+	reason := fmt.Sprintf("rate limited %d times in %s", len(times), window)
+	if err := store.Put(ipBansBucket, ip, ipBan{Until: now.Add(duration), Reason: reason}); err != nil {
+		log.Printf("Error banning %s: %v", ip, err)
+		return
+	}
+	log.Printf("Banned %s for %s: %s", ip, duration, reason)
+}
+
+// ipBanned reports whether ip is on the config ban list or was banned for
+// abuse, and until when
+func ipBanned(ip string) (time.Time, bool) {
+	if slices.Contains(appConfig.RateLimit.BanList, ip) {
+		return time.Now().Add(24 * time.Hour), true
+	}
+	var ban ipBan
+	found, err := store.Get(ipBansBucket, ip, &ban)
+	if err != nil {
+		log.Printf("Error loading ban for %s: %v", ip, err)
+		return time.Time{}, false
+	}
+	if !found {
+		return time.Time{}, false
+	}
+	if time.Now().After(ban.Until) {
+		if err := store.Delete(ipBansBucket, ip); err != nil {
+			log.Printf("Error lifting expired ban for %s: %v", ip, err)
+		}
+		return time.Time{}, false
+	}
+	return ban.Until, true
+}
+
+// rateLimitPrefix returns the metrics key for path: its first segment
+func rateLimitPrefix(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return "/" + segment
+}
+
+func countRateLimit(prefix string, update func(*rateLimitCounts)) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	counts, ok := rateLimitStats[prefix]
+	if !ok {
+		counts = &rateLimitCounts{}
+		rateLimitStats[prefix] = counts
+	}
+	update(counts)
+}
+
+func handleRateLimitReport(req commandRequest) commandResponse {
+	if !appConfig.RateLimit.enabled() {
+		return ephemeral("Rate limiting is off; see rate_limit in the config.")
+	}
+	rateLimitMu.Lock()
+	prefixes := make([]string, 0, len(rateLimitStats))
+	for prefix := range rateLimitStats {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	lines := []string{"*Requests since the bot started*"}
+	for _, prefix := range prefixes {
+		s := rateLimitStats[prefix]
+		lines = append(lines, fmt.Sprintf("• `%s`: %d allowed, %d limited, %d refused while banned", prefix, s.Allowed, s.Limited, s.Banned))
+	}
+	rateLimitMu.Unlock()
+	if len(prefixes) == 0 {
+		lines = append(lines, "No requests yet.")
+	}
+
+	lines = append(lines, "", "*Banned addresses*")
+	bans := 0
+	for _, ip := range store.Keys(ipBansBucket) {
+		var ban ipBan
+		if _, err := store.Get(ipBansBucket, ip, &ban); err != nil || time.Now().After(ban.Until) {
+			continue
+		}
+		bans++
+		lines = append(lines, fmt.Sprintf("• `%s` until <!date^%d^{date_short_pretty} {time}|%s>: %s", ip, ban.Until.Unix(), ban.Until.Format(time.RFC3339), ban.Reason))
+	}
+	for _, ip := range appConfig.RateLimit.BanList {
+		bans++
+		lines = append(lines, fmt.Sprintf("• `%s` (rate_limit.ban_list)", ip))
+	}
+	if bans == 0 {
+		lines = append(lines, "None.")
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
+
+func handleUnban(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s admin unban <ip>`", botCommand)
+	}
+	ip := req.Args[0]
+	if slices.Contains(appConfig.RateLimit.BanList, ip) {
+		return ephemeral("`%s` is on rate_limit.ban_list in the config; remove it there.", ip)
+	}
+	if err := store.Delete(ipBansBucket, ip); err != nil {
+		log.Printf("Error lifting ban for %s: %v", ip, err)
+		return ephemeral("Sorry, something went wrong lifting the ban.")
+	}
+	return ephemeral("`%s` is no longer banned.", ip)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store buckets for release notes: what was last seen per repository, keyed
+// by owner/name, and each posted release, keyed by owner/name@tag
+const (
+	releaseWatchBucket = "release_watch"
+	releasesBucket     = "releases"
+)
+
+// Action IDs of the buttons folding release notes
+const (
+	actionReleaseShowMore = "release_show_more"
+	actionReleaseShowLess = "release_show_less"
+)
+
+// Used when the releases settings aren't set
+const (
+	defaultReleasePollInterval = 10 * time.Minute
+	defaultReleasePreview      = 800
+)
+
+// Posted releases are forgotten, and no longer unfold, after this long
+const releaseRetention = 90 * 24 * time.Hour
+
+// Releases read per repository on each poll
+const releasesPerPoll = 10
+
+// releaseWatch is the newest release seen in a repository
+type releaseWatch struct {
+	Repo   string `json:"repo"`
+	LastID int64  `json:"last_id"`
+	// Seeded is set once the first poll has noted the existing releases
+	Seeded bool `json:"seeded"`
+}
+
+// postedRelease is release notes posted to a channel
+type postedRelease struct {
+	Repo string `json:"repo"`
+	Tag  string `json:"tag"`
+	Name string `json:"name"`
+	// Notes are the release body converted to mrkdwn
+	Notes      string `json:"notes"`
+	URL        string `json:"url"`
+	Author     string `json:"author"`
+	Prerelease bool   `json:"prerelease,omitempty"`
+	Channel    string `json:"channel"`
+	// TS is empty when the post was held for the send window
+	TS          string    `json:"ts"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// githubRelease is the part of a GitHub release the poster reads
+type githubRelease struct {
+	ID         int64  `json:"id"`
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	HTMLURL    string `json:"html_url"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Author     struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+func init() {
+	registerBlockAction(actionReleaseShowMore, handleReleaseFold)
+	registerBlockAction(actionReleaseShowLess, handleReleaseFold)
+}
+
+// startReleaseNotes posts new releases of releases.repos as they're
+// published
+func startReleaseNotes() {
+	if len(appConfig.Releases.Repos) == 0 || appConfig.Releases.Channel == "" {
+		return
+	}
+	interval := appConfig.Releases.Interval
+	if interval <= 0 {
+		interval = defaultReleasePollInterval
+	}
+	runEvery("release notes", interval, pollReleases)
+}
+
+func pollReleases() {
+	for _, repo := range appConfig.Releases.Repos {
+		if err := pollRepoReleases(repo); err != nil {
+			log.Printf("Error checking releases of %s: %v", repo, err)
+		}
+	}
+	pruneReleases()
+}
+
+// pollRepoReleases posts the releases published in repo since the last
+// poll, oldest first. The first poll of a repository only notes where it is.
+func pollRepoReleases(repo string) error {
+	watch := releaseWatch{Repo: repo}
+	if _, err := store.Get(releaseWatchBucket, repo, &watch); err != nil {
+		return err
+	}
+	var releases []githubRelease
+	if err := githubRequest(http.MethodGet, fmt.Sprintf("/repos/%s/releases?per_page=%d", repo, releasesPerPoll), nil, &releases); err != nil {
+		return err
+	}
+	first := !watch.Seeded
+	newest := watch.LastID
+	// GitHub lists the newest release first
+	for i := len(releases) - 1; i >= 0; i-- {
+		r := releases[i]
+		if r.Draft || r.ID <= watch.LastID {
+			continue
+		}
+		newest = max(newest, r.ID)
+		if first || (r.Prerelease && !appConfig.Releases.Prereleases) {
+			continue
+		}
+		if err := postRelease(repo, r); err != nil {
+			return fmt.Errorf("posting %s: %w", r.TagName, err)
+		}
+		// Saved per release so a failure part way doesn't repost the rest
+		watch.LastID = r.ID
+		if err := store.Put(releaseWatchBucket, repo, watch); err != nil {
+			return err
+		}
+	}
+	if newest == watch.LastID && !first {
+		return nil
+	}
+	watch.LastID, watch.Seeded = newest, true
+	return store.Put(releaseWatchBucket, repo, watch)
+}
+
+func postRelease(repo string, r githubRelease) error {
+	name := r.Name
+	if name == "" {
+		name = r.TagName
+	}
+	rel := &postedRelease{
+		Repo:        repo,
+		Tag:         r.TagName,
+		Name:        name,
+		Notes:       markdownToMrkdwn(r.Body),
+		URL:         r.HTMLURL,
+		Author:      r.Author.Login,
+		Prerelease:  r.Prerelease,
+		Channel:     appConfig.Releases.Channel,
+		PublishedAt: r.PublishedAt,
+	}
+	ts, err := sendInWindow("release_notes", outboundMessage{Channel: rel.Channel, Importance: importanceInfo, NeedTS: true, Text: fmt.Sprintf("%s %s released", repo, name), Blocks: releaseBlocks(rel, false)})
+	if err != nil {
+		return err
+	}
+	rel.TS = ts
+	return store.Put(releasesBucket, repo+"@"+r.TagName, rel)
+}
+
+// releaseBlocks renders release notes, folded to a preview unless expanded
+func releaseBlocks(rel *postedRelease, expanded bool) []slack.Block {
+	key := rel.Repo + "@" + rel.Tag
+	header, _ := truncateText(fmt.Sprintf("%s %s", rel.Repo, rel.Name), maxHeaderText)
+	context := fmt.Sprintf("<%s|%s> released by %s", rel.URL, rel.Tag, githubMention(rel.Author))
+	if !rel.PublishedAt.IsZero() {
+		context += " " + slackDate(rel.PublishedAt)
+	}
+	if rel.Prerelease {
+		context += " · pre-release"
+	}
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, header, false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, context, false, false)),
+	}
+	notes := rel.Notes
+	if notes == "" {
+		notes = "_No release notes._"
+	}
+
+	preview := appConfig.Releases.PreviewLength
+	if preview <= 0 {
+		preview = defaultReleasePreview
+	}
+	folded := len(notes) > preview
+	if folded && !expanded {
+		notes = previewMrkdwn(notes, preview)
+	}
+	// Room is left for the header, context and button
+	sections := splitMrkdwn(notes, maxSectionText)
+	if room := maxMessageBlocks - len(blocks) - 1; len(sections) > room {
+		sections = append(sections[:room-1], fmt.Sprintf("_The notes go on; <%s|read the rest on GitHub>._", rel.URL))
+	}
+	for _, s := range sections {
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, s, false, false), nil, nil))
+	}
+	if folded {
+		action, label := actionReleaseShowMore, "Show more"
+		if expanded {
+			action, label = actionReleaseShowLess, "Show less"
+		}
+		blocks = append(blocks, slack.NewActionBlock("", slack.NewButtonBlockElement(action, key, slack.NewTextBlockObject(slack.PlainTextType, label, false, false))))
+	}
+	return blocks
+}
+
+// handleReleaseFold unfolds or folds the release notes clicked on, for
+// everyone in the channel
+func handleReleaseFold(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	rel := &postedRelease{}
+	found, err := store.Get(releasesBucket, action.Value, rel)
+	if err != nil {
+		log.Printf("Error loading release %s: %v", action.Value, err)
+		return
+	}
+	if !found {
+		pollReply(callback, "Those release notes are too old to unfold here. Use the link to read them on GitHub.")
+		return
+	}
+	expanded := action.ActionID == actionReleaseShowMore
+	// A post held for the send window went out without its timestamp
+	// being saved, but it's the message clicked on
+	channel, ts := rel.Channel, rel.TS
+	if ts == "" {
+		channel, ts = callback.Container.ChannelID, callback.Container.MessageTs
+	}
+	_, _, _, err = slackClient.UpdateMessage(channel, ts,
+		slack.MsgOptionText(fmt.Sprintf("%s %s released", rel.Repo, rel.Name), false),
+		slack.MsgOptionBlocks(releaseBlocks(rel, expanded)...))
+	if err != nil {
+		log.Printf("Error folding release notes %s: %v", action.Value, err)
+	}
+}
+
+// pruneReleases forgets releases posted more than releaseRetention ago
+func pruneReleases() {
+	cutoff := time.Now().Add(-releaseRetention)
+	for _, key := range store.Keys(releasesBucket) {
+		var rel postedRelease
+		if _, err := store.Get(releasesBucket, key, &rel); err != nil {
+			log.Printf("Error loading release %s: %v", key, err)
+			continue
+		}
+		if rel.PublishedAt.Before(cutoff) {
+			if err := store.Delete(releasesBucket, key); err != nil {
+				log.Printf("Error forgetting release %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// previewMrkdwn returns the whole lines of text that fit in limit, closing
+// a code block left open
+func previewMrkdwn(text string, limit int) string {
+	var kept []string
+	size := 0
+	for _, line := range strings.Split(text, "\n") {
+		if size+len(line) > limit && len(kept) > 0 {
+			break
+		}
+		kept = append(kept, line)
+		size += len(line) + 1
+	}
+	preview, _ := truncateText(strings.TrimRight(strings.Join(kept, "\n"), "\n"), limit)
+	if strings.Count(preview, "```")%2 == 1 {
+		preview += "\n```"
+	}
+	return preview + "\n…"
+}
+
+// splitMrkdwn cuts text into pieces of at most limit characters, at line
+// breaks where it can
+func splitMrkdwn(text string, limit int) []string {
+	var parts []string
+	var current strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		// A line too long for one piece is cut wherever the limit falls
+		for runes := []rune(line); len(runes) > limit; runes = []rune(line) {
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+			parts = append(parts, string(runes[:limit]))
+			line = string(runes[limit:])
+		}
+		if current.Len() > 0 && current.Len()+1+len(line) > limit {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteByte('\n')
+		}
+		current.WriteString(line)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+var (
+	markdownComment   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	markdownHeading   = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	markdownBullet    = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+	markdownImage     = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	markdownLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	markdownBold      = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	markdownItalic    = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*?)\*`)
+	markdownStrike    = regexp.MustCompile(`~~(.+?)~~`)
+	markdownAutolink  = regexp.MustCompile(`&lt;(https?://[^\s&]+)&gt;`)
+	markdownRule      = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	markdownCheckbox  = regexp.MustCompile(`^• \[([ xX])\] `)
+	slackBoldSentinel = "\x00"
+)
+
+// markdownToMrkdwn converts GitHub-flavoured Markdown, as in release notes,
+// to Slack's mrkdwn. Code is left as it is; tables and HTML are not
+// converted.
+func markdownToMrkdwn(md string) string {
+	md = markdownComment.ReplaceAllString(strings.ReplaceAll(md, "\r\n", "\n"), "")
+	var out []string
+	inFence := false
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			out = append(out, "```")
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+		out = append(out, markdownLineToMrkdwn(line))
+	}
+	if inFence {
+		out = append(out, "```")
+	}
+	text := strings.Join(out, "\n")
+	// Runs of blank lines left by removed comments read as gaps in Slack
+	for strings.Contains(text, "\n\n\n") {
+		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+	}
+	return strings.TrimSpace(text)
+}
+
+// markdownLineToMrkdwn converts one line outside a code block
+func markdownLineToMrkdwn(line string) string {
+	if markdownRule.MatchString(line) {
+		return "───"
+	}
+	heading := false
+	if m := markdownHeading.FindStringSubmatch(line); m != nil {
+		line, heading = m[1], true
+	}
+	if m := markdownBullet.FindStringSubmatch(line); m != nil {
+		indent := strings.Repeat("    ", len(strings.ReplaceAll(m[1], "\t", "  "))/2)
+		line = indent + "• " + line[len(m[0]):]
+		line = indent + markdownCheckbox.ReplaceAllStringFunc(line[len(indent):], func(s string) string {
+			if strings.Contains(strings.ToLower(s), "x") {
+				return "☑ "
+			}
+			return "☐ "
+		})
+	}
+	if rest, ok := strings.CutPrefix(line, ">"); ok {
+		return "> " + convertInlineMarkdown(strings.TrimSpace(rest))
+	}
+
+	line = convertInlineMarkdown(line)
+	if heading {
+		line = "*" + strings.ReplaceAll(line, "*", "") + "*"
+	}
+	return line
+}
+
+// convertInlineMarkdown converts emphasis and links, leaving `code` alone
+func convertInlineMarkdown(line string) string {
+	parts := strings.Split(line, "`")
+	for i := range parts {
+		// Odd parts are inside backticks, unless the last backtick is
+		// unmatched
+		if i%2 == 1 && i < len(parts)-1 {
+			continue
+		}
+		s := escapeMrkdwn(parts[i])
+		s = markdownAutolink.ReplaceAllString(s, "<$1>")
+		s = markdownImage.ReplaceAllString(s, "<$2|$1>")
+		s = markdownLink.ReplaceAllStringFunc(s, func(m string) string {
+			sub := markdownLink.FindStringSubmatch(m)
+			return "<" + sub[2] + "|" + strings.ReplaceAll(sub[1], "|", "¦") + ">"
+		})
+		s = markdownBold.ReplaceAllStringFunc(s, func(m string) string {
+			sub := markdownBold.FindStringSubmatch(m)
+			return slackBoldSentinel + sub[1] + sub[2] + slackBoldSentinel
+		})
+		s = markdownItalic.ReplaceAllString(s, "${1}_${2}_")
+		s = markdownStrike.ReplaceAllString(s, "~$1~")
+		parts[i] = strings.ReplaceAll(s, slackBoldSentinel, "*")
+	}
+	return strings.Join(parts, "`")
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Store bucket for reminders, keyed by ID
+const remindersBucket = "reminders"
+
+// Reminders per user, so a loop of commands can't flood the scheduler
+const maxRemindersPerUser = 50
+
+// Clock time used when a reminder names a day but no time
+const defaultReminderClock = "09:00"
+
+// How long an undeliverable reminder is retried, e.g. in a channel the bot
+// has since left
+const reminderRetryLimit = 24 * time.Hour
+
+// reminder is a message delivered at a set time, once or on a schedule
+type reminder struct {
+	ID string `json:"id"`
+	// UserID created the reminder
+	UserID string `json:"user_id"`
+	// Channel gets the reminder; empty means a DM to UserID
+	Channel string    `json:"channel,omitempty"`
+	Text    string    `json:"text"`
+	Next    time.Time `json:"next"`
+	// Every is day, weekday or a day name like mon; empty means once
+	Every string `json:"every,omitempty"`
+	// Clock and Timezone place each repeat, like 09:00 in Europe/London
+	Clock    string `json:"clock,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// reminderSchedule is when a parsed reminder goes off
+type reminderSchedule struct {
+	Next  time.Time
+	Every string
+	Clock string
+}
+
+// apiReminder is a reminder as the HTTP API shows it
+type apiReminder struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel,omitempty"`
+	Text    string    `json:"text"`
+	Next    time.Time `json:"next"`
+	Every   string    `json:"every,omitempty"`
+}
+
+type apiRemindersResponse struct {
+	Reminders []apiReminder `json:"reminders"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/remindme",
+		Usage:       "/remindme <when> [timezone] to <what>",
+		Description: "Remind yourself, e.g. `/remindme in 2h to stretch` or `/remindme every weekday at 9:30 Europe/Paris to check the board`",
+		Handler:     handleRemindMe,
+	})
+	registerBotCommand(&command{
+		Name:        "remind",
+		Usage:       "remind me|#channel <when> [timezone] to <what>",
+		Description: "Set a reminder for yourself or a channel, e.g. `remind #team every Monday at 10am to update the roadmap`",
+		Handler:     handleRemindCommand,
+	})
+	registerBotCommand(&command{
+		Name:        "remind list",
+		Usage:       "remind list",
+		Description: "List your reminders",
+		Handler:     handleRemindList,
+	})
+	registerBotCommand(&command{
+		Name:        "remind delete",
+		Usage:       "remind delete <id>",
+		Description: "Delete one of your reminders",
+		Handler:     handleRemindDelete,
+	})
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/reminders",
+		Scope:       scopeRemindersRead,
+		OperationID: "ListReminders",
+		Summary:     "List the calling user's reminders",
+		Response:    apiRemindersResponse{},
+		Handler:     handleAPIReminders,
+	})
+	registerUserDataset(userDataset{Name: "reminders", Title: "Reminders", Describe: describeUserReminders, Delete: deleteUserReminders})
+}
+
+// startReminders delivers reminders as they fall due. They live in the
+// store, so ones that fell due while the bot was down go out on startup.
+func startReminders() {
+	go runJob("reminders", deliverDueReminders)
+	runEvery("reminders", 30*time.Second, deliverDueReminders)
+}
+
+func handleRemindMe(req commandRequest) commandResponse {
+	return createReminderFromArgs(req, "", req.Args, "`/remindme <when> [timezone] to <what>`")
+}
+
+func handleRemindCommand(req commandRequest) commandResponse {
+	usage := "`" + botCommand + " remind me|#channel <when> [timezone] to <what>`"
+	if len(req.Args) == 0 {
+		return ephemeral("Usage: %s", usage)
+	}
+	channel := ""
+	if !strings.EqualFold(req.Args[0], "me") {
+		match := channelMentionPattern.FindStringSubmatch(req.Args[0])
+		if match == nil {
+			return ephemeral("Say who to remind: `me` or a channel like #general. Usage: %s", usage)
+		}
+		channel = match[1]
+		// Otherwise anyone could have the bot post into channels they
+		// can't see, private ones included
+		member, err := isChannelMember(channel, req.UserID)
+		if err != nil {
+			log.Printf("Error checking channel membership: %v", err)
+			return ephemeral("Sorry, I couldn't look at <#%s>. Is the bot in the channel?", channel)
+		}
+		if !member {
+			return ephemeral("You can only set reminders for channels you're in.")
+		}
+	}
+	return createReminderFromArgs(req, channel, req.Args[1:], usage)
+}
+
+// createReminderFromArgs parses "<when> to <what>" and saves the reminder
+func createReminderFromArgs(req commandRequest, channel string, args []string, usage string) commandResponse {
+	i := slices.IndexFunc(args, func(w string) bool { return strings.EqualFold(w, "to") })
+	if i <= 0 || i == len(args)-1 {
+		return ephemeral("Usage: %s", usage)
+	}
+	when, loc, ok := parseTimezoneSuffix(args[:i])
+	if !ok {
+		loc = userLocationOr(req.UserID, workspaceLocation())
+	}
+	schedule, err := parseReminderWhen(when, loc, time.Now())
+	if err != nil {
+		return ephemeral("I couldn't work out when: %v", err)
+	}
+	r, err := createReminder(req.UserID, channel, strings.Join(args[i+1:], " "), schedule, loc)
+	if err != nil {
+		log.Printf("Error saving reminder: %v", err)
+		return ephemeral("Sorry, something went wrong saving the reminder.")
+	}
+	return ephemeral(":alarm_clock: OK, I'll remind %s %s. (ID `%s`)", reminderTarget(r), describeReminderSchedule(r, loc), r.ID)
+}
+
+// createReminder saves a new reminder for userID
+func createReminder(userID, channel, text string, schedule reminderSchedule, loc *time.Location) (reminder, error) {
+	owned, err := userReminders(userID)
+	if err != nil {
+		return reminder{}, err
+	}
+	if len(owned) >= maxRemindersPerUser {
+		return reminder{}, fmt.Errorf("%s already has %d reminders", userID, maxRemindersPerUser)
+	}
+	r := reminder{
+		ID:       newInteractionToken()[:8],
+		UserID:   userID,
+		Channel:  channel,
+		Text:     text,
+		Next:     schedule.Next.UTC(),
+		Every:    schedule.Every,
+		Clock:    schedule.Clock,
+		Timezone: loc.String(),
+	}
+	return r, store.Put(remindersBucket, r.ID, r)
+}
+
+// userReminders returns the reminders userID created, soonest first
+func userReminders(userID string) ([]reminder, error) {
+	all, err := storeList[reminder](store, remindersBucket)
+	if err != nil {
+		return nil, err
+	}
+	owned := slices.DeleteFunc(all, func(r reminder) bool { return r.UserID != userID })
+	slices.SortFunc(owned, func(a, b reminder) int { return a.Next.Compare(b.Next) })
+	return owned, nil
+}
+
+func reminderTarget(r reminder) string {
+	if r.Channel == "" {
+		return "you"
+	}
+	return "<#" + r.Channel + ">"
+}
+
+func describeReminderSchedule(r reminder, loc *time.Location) string {
+	next := r.Next.In(loc).Format("Mon 2 Jan at 15:04 MST")
+	switch r.Every {
+	case "":
+		return "on " + next
+	case "day", "weekday":
+		return fmt.Sprintf("every %s at %s, starting %s", r.Every, r.Clock, next)
+	default:
+		return fmt.Sprintf("every %s at %s, starting %s", r.Next.In(loc).Weekday(), r.Clock, next)
+	}
+}
+
+// parseReminderWhen understands "in 2h", "in 3 days", "at 5pm",
+// "tomorrow [at 9:30]", "[on] friday [at 10]" and
+// "every day|weekday|week|monday [at 9am]", in loc. Repeats keep to the
+// clock time in loc across daylight saving changes.
+func parseReminderWhen(words []string, loc *time.Location, now time.Time) (reminderSchedule, error) {
+	if len(words) == 0 {
+		return reminderSchedule{}, fmt.Errorf("say when, like `in 2h` or `every monday at 9am`")
+	}
+	first := strings.ToLower(words[0])
+	rest := words[1:]
+
+	// Everything but "in" may end with "at <time>"
+	clock := defaultReminderClock
+	if j := slices.IndexFunc(rest, func(w string) bool { return strings.EqualFold(w, "at") }); first != "in" && j >= 0 {
+		if j != len(rest)-2 {
+			return reminderSchedule{}, fmt.Errorf("put the time last, like `at 9:30`")
+		}
+		parsed, err := parseClock(rest[j+1])
+		if err != nil {
+			return reminderSchedule{}, err
+		}
+		clock, rest = parsed, rest[:j]
+	}
+
+	switch first {
+	case "in":
+		d, err := parseSpokenDuration(rest)
+		if err != nil {
+			return reminderSchedule{}, err
+		}
+		return reminderSchedule{Next: now.Add(d)}, nil
+	case "at":
+		if len(rest) != 1 {
+			return reminderSchedule{}, fmt.Errorf("say a time like `at 5pm`")
+		}
+		parsed, err := parseClock(rest[0])
+		if err != nil {
+			return reminderSchedule{}, err
+		}
+		return reminderSchedule{Next: nextReminderOccurrence("day", parsed, loc, now)}, nil
+	case "tomorrow":
+		if len(rest) > 0 {
+			return reminderSchedule{}, fmt.Errorf("I don't understand %q", strings.Join(rest, " "))
+		}
+		local := now.In(loc).AddDate(0, 0, 1)
+		return reminderSchedule{Next: atClock(local, clock)}, nil
+	case "every":
+		if len(rest) != 1 {
+			return reminderSchedule{}, fmt.Errorf("say how often, like `every weekday` or `every monday`")
+		}
+		every := strings.ToLower(rest[0])
+		switch every {
+		case "day", "weekday":
+		case "week":
+			every = strings.ToLower(now.In(loc).Weekday().String()[:3])
+		default:
+			day, ok := parseWeekday(every)
+			if !ok {
+				return reminderSchedule{}, fmt.Errorf("I don't know how often %q is", rest[0])
+			}
+			every = day
+		}
+		return reminderSchedule{Next: nextReminderOccurrence(every, clock, loc, now), Every: every, Clock: clock}, nil
+	default:
+		if first == "on" && len(rest) == 1 {
+			first, rest = strings.ToLower(rest[0]), nil
+		}
+		day, ok := parseWeekday(first)
+		if !ok || len(rest) > 0 {
+			return reminderSchedule{}, fmt.Errorf("start with `in`, `at`, `tomorrow`, `every` or a day of the week")
+		}
+		return reminderSchedule{Next: nextReminderOccurrence(day, clock, loc, now)}, nil
+	}
+}
+
+// parseWeekday turns "monday" or "mon" into "mon"
+func parseWeekday(word string) (string, bool) {
+	word = strings.TrimSuffix(strings.ToLower(word), "s")
+	if len(word) < 3 {
+		return "", false
+	}
+	short := word[:3]
+	day, ok := weekdayNames[short]
+	if !ok || !strings.HasPrefix(strings.ToLower(day.String()), word) {
+		return "", false
+	}
+	return short, true
+}
+
+// parseSpokenDuration understands "2h30m", "90 minutes", "a day" and "2 weeks"
+func parseSpokenDuration(words []string) (time.Duration, error) {
+	if len(words) == 1 {
+		if d, err := time.ParseDuration(words[0]); err == nil && d > 0 {
+			return d, nil
+		}
+	}
+	if len(words) != 2 {
+		return 0, fmt.Errorf("say how long, like `in 2h` or `in 3 days`")
+	}
+	n, err := strconv.Atoi(words[0])
+	if strings.EqualFold(words[0], "a") || strings.EqualFold(words[0], "an") {
+		n, err = 1, nil
+	}
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q isn't a number I understand", words[0])
+	}
+	units := map[string]time.Duration{
+		"minute": time.Minute, "min": time.Minute,
+		"hour": time.Hour, "hr": time.Hour,
+		"day":  24 * time.Hour,
+		"week": 7 * 24 * time.Hour,
+	}
+	unit, ok := units[strings.TrimSuffix(strings.ToLower(words[1]), "s")]
+	if !ok {
+		return 0, fmt.Errorf("I don't know the unit %q", words[1])
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// parseClock turns "9", "9am", "9:30", "5:15pm" or "17:00" into "15:04" form
+func parseClock(s string) (string, error) {
+	lower := strings.ToLower(s)
+	if lower == "noon" {
+		return "12:00", nil
+	}
+	for _, layout := range []string{"15:04", "15", "3pm", "3:04pm"} {
+		if t, err := time.Parse(layout, lower); err == nil {
+			return t.Format("15:04"), nil
+		}
+	}
+	return "", fmt.Errorf("%q isn't a time I understand; try `9:30` or `5pm`", s)
+}
+
+// atClock returns clock (15:04) on local's day, in local's timezone
+func atClock(local time.Time, clock string) time.Time {
+	t, _ := time.Parse("15:04", clock)
+	return time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, local.Location())
+}
+
+// nextReminderOccurrence returns the first time after after that clock
+// falls on a day matching every in loc
+func nextReminderOccurrence(every, clock string, loc *time.Location, after time.Time) time.Time {
+	local := after.In(loc)
+	for i := 0; i <= 7; i++ {
+		candidate := atClock(local.AddDate(0, 0, i), clock)
+		if !candidate.After(after) {
+			continue
+		}
+		switch day := candidate.Weekday(); every {
+		case "day":
+			return candidate
+		case "weekday":
+			if day != time.Saturday && day != time.Sunday {
+				return candidate
+			}
+		default:
+			if weekdayNames[every] == day {
+				return candidate
+			}
+		}
+	}
+	return atClock(local.AddDate(0, 0, 7), clock)
+}
+
+// deliverDueReminders sends every reminder that has fallen due, then
+// schedules repeats and deletes one-off reminders
+func deliverDueReminders() {
+	now := time.Now()
+	reminders, err := storeList[reminder](store, remindersBucket)
+	if err != nil {
+		log.Printf("Error loading reminders: %v", err)
+		return
+	}
+	for _, r := range reminders {
+		if r.Next.After(now) {
+			continue
+		}
+		var err error
+		if r.Channel == "" {
+			_, err = sendDM("reminders", r.UserID, outboundMessage{Text: ":alarm_clock: Reminder: " + r.Text})
+		} else {
+			_, err = sendMessage(outboundMessage{Channel: r.Channel, Importance: importanceNotice, Text: fmt.Sprintf(":alarm_clock: Reminder from <@%s>: %s", r.UserID, r.Text)})
+		}
+		if err != nil {
+			log.Printf("Error delivering reminder %s: %v", r.ID, err)
+			if now.Sub(r.Next) < reminderRetryLimit {
+				continue
+			}
+			log.Printf("Giving up on reminder %s after %s", r.ID, reminderRetryLimit)
+		}
+
+		if r.Every == "" {
+			if err := store.Delete(remindersBucket, r.ID); err != nil {
+				log.Printf("Error deleting delivered reminder: %v", err)
+			}
+			continue
+		}
+		loc, err := time.LoadLocation(r.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		// Repeats missed while the bot was down are skipped, not sent in a burst
+		r.Next = nextReminderOccurrence(r.Every, r.Clock, loc, now).UTC()
+		if err := store.Put(remindersBucket, r.ID, r); err != nil {
+			log.Printf("Error rescheduling reminder: %v", err)
+		}
+	}
+}
+
+func handleRemindList(req commandRequest) commandResponse {
+	owned, err := userReminders(req.UserID)
+	if err != nil {
+		log.Printf("Error loading reminders: %v", err)
+		return ephemeral("Sorry, something went wrong loading your reminders.")
+	}
+	if len(owned) == 0 {
+		return ephemeral("You have no reminders. Set one with `/remindme in 2h to ...`.")
+	}
+	lines := make([]string, len(owned))
+	for i, r := range owned {
+		loc, err := time.LoadLocation(r.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		lines[i] = fmt.Sprintf("• `%s` %s for %s %s", r.ID, quoteText(r.Text), reminderTarget(r), describeReminderSchedule(r, loc))
+	}
+	return ephemeral("Your reminders:\n%s", strings.Join(lines, "\n"))
+}
+
+func handleRemindDelete(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s remind delete <id>`", botCommand)
+	}
+	var r reminder
+	found, err := store.Get(remindersBucket, req.Args[0], &r)
+	if err != nil {
+		log.Printf("Error loading reminder: %v", err)
+		return ephemeral("Sorry, something went wrong loading the reminder.")
+	}
+	if !found || (r.UserID != req.UserID && !isAdmin(req.UserID)) {
+		return ephemeral("You don't have a reminder with ID `%s`.", req.Args[0])
+	}
+	if err := store.Delete(remindersBucket, r.ID); err != nil {
+		log.Printf("Error deleting reminder: %v", err)
+		return ephemeral("Sorry, something went wrong deleting the reminder.")
+	}
+	return ephemeral("Deleted reminder `%s`.", r.ID)
+}
+
+// handleAPIReminders lists the calling user's reminders
+func handleAPIReminders(c *gin.Context) {
+	owned, err := userReminders(apiCaller(c).UserID)
+	if err != nil {
+		log.Printf("Error loading reminders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	resp := apiRemindersResponse{Reminders: []apiReminder{}}
+	for _, r := range owned {
+		resp.Reminders = append(resp.Reminders, apiReminder{ID: r.ID, Channel: r.Channel, Text: r.Text, Next: r.Next, Every: r.Every})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func describeUserReminders(userID string) (string, error) {
+	owned, err := userReminders(userID)
+	if err != nil {
+		return "", err
+	}
+	items := make([]string, len(owned))
+	for i, r := range owned {
+		items[i] = r.Text
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserReminders(userID string) error {
+	owned, err := userReminders(userID)
+	if err != nil {
+		return err
+	}
+	for _, r := range owned {
+		if err := store.Delete(remindersBucket, r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
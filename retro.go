@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for retros, keyed by channel/ts of the announcement
+const retrosBucket = "retros"
+
+// Used when retro.period isn't set
+const defaultRetroPeriod = 24 * time.Hour
+
+// Closed retros are kept this long for exports
+const retroRetention = 90 * 24 * time.Hour
+
+// Kinds of retro item, in board order
+const (
+	retroWell  = "well"
+	retroWrong = "wrong"
+	retroIdea  = "idea"
+)
+
+// retroKinds is each kind's board heading, and the tags that file an item
+// under it. Slack sends emoji as shortcodes, but pasted ones arrive as is.
+var retroKinds = []struct {
+	Kind    string
+	Heading string
+	Tags    []string
+}{
+	{retroWell, ":+1: What went well", []string{":+1:", ":thumbsup:", "👍"}},
+	{retroWrong, ":-1: What didn't go well", []string{":-1:", ":thumbsdown:", "👎"}},
+	{retroIdea, ":bulb: Ideas", []string{":bulb:", "💡"}},
+}
+
+// retro collects items in a channel until CloseAt
+type retro struct {
+	Channel  string      `json:"channel"`
+	TS       string      `json:"ts"`
+	OpenedBy string      `json:"opened_by"`
+	OpenedAt time.Time   `json:"opened_at"`
+	CloseAt  time.Time   `json:"close_at"`
+	Closed   bool        `json:"closed,omitempty"`
+	Items    []retroItem `json:"items"`
+}
+
+// retroItem is one thing someone said. Items sent by DM are anonymous.
+type retroItem struct {
+	Kind    string    `json:"kind"`
+	Text    string    `json:"text"`
+	Author  string    `json:"author,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Serializes item updates, which read and rewrite the whole retro
+var retrosMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/retro",
+		Usage:       "/retro open [duration] | close | export",
+		Description: "Collect 👍/👎/💡 items for a retro, then post them as a board",
+		Handler:     handleRetro,
+	})
+}
+
+// startRetros posts the board for each retro whose time is up
+func startRetros() {
+	runEvery("retros", time.Minute, closeDueRetros)
+}
+
+func handleRetro(req commandRequest) commandResponse {
+	sub := ""
+	if len(req.Args) > 0 {
+		sub = strings.ToLower(req.Args[0])
+	}
+	switch sub {
+	case "open":
+		return handleRetroOpen(req)
+	case "close":
+		return handleRetroClose(req)
+	case "export":
+		return handleRetroExport(req)
+	}
+	return ephemeral("Usage: `%s open [duration] | close | export`", req.Command)
+}
+
+// parseRetroPeriod understands a period like 3d, 12h or 90m
+func parseRetroPeriod(s string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, true
+		}
+	}
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d > 0
+}
+
+func handleRetroOpen(req commandRequest) commandResponse {
+	period := appConfig.Retro.Period
+	if period <= 0 {
+		period = defaultRetroPeriod
+	}
+	if len(req.Args) > 1 {
+		d, ok := parseRetroPeriod(req.Args[1])
+		if !ok {
+			return ephemeral("Usage: `%s open [duration]`, with a duration like 3d or 2h.", req.Command)
+		}
+		period = d
+	}
+	open, err := openRetro(req.ChannelID)
+	if err != nil {
+		log.Printf("Error loading retros: %v", err)
+		return ephemeral("Sorry, something went wrong opening the retro.")
+	}
+	if open != nil {
+		return ephemeral("There's already a retro open here until %s. `%s close` posts its board now.", slackDate(open.CloseAt), req.Command)
+	}
+
+	closeAt := time.Now().Add(period).UTC()
+	text := fmt.Sprintf(":memo: *Retro time!* <@%s> opened a retro until %s. Reply in this thread with items starting with :+1: for what went well, :-1: for what didn't or :bulb: for ideas. You can also DM them to me to add them anonymously.",
+		req.UserID, slackDate(closeAt))
+	ts, err := sendMessage(outboundMessage{Channel: req.ChannelID, Importance: importanceInfo, NeedTS: true, Text: text})
+	if err != nil {
+		log.Printf("Error announcing retro: %v", err)
+		return ephemeral("Sorry, I couldn't post here. Is the bot in this channel?")
+	}
+	r := retro{Channel: req.ChannelID, TS: ts, OpenedBy: req.UserID, OpenedAt: time.Now().UTC(), CloseAt: closeAt}
+	if err := store.Put(retrosBucket, r.Channel+"/"+r.TS, r); err != nil {
+		log.Printf("Error saving retro: %v", err)
+		return ephemeral("Sorry, something went wrong opening the retro.")
+	}
+	return ephemeral("The retro is open. I'll post the board %s.", slackDate(closeAt))
+}
+
+// handleRetroClose posts the board now, if the caller opened the retro or
+// is an admin
+func handleRetroClose(req commandRequest) commandResponse {
+	r, err := openRetro(req.ChannelID)
+	if err != nil {
+		log.Printf("Error loading retros: %v", err)
+		return ephemeral("Sorry, something went wrong closing the retro.")
+	}
+	if r == nil {
+		return ephemeral("There's no retro open here. Start one with `%s open`.", req.Command)
+	}
+	if req.UserID != r.OpenedBy && !isAdmin(req.UserID) {
+		return ephemeral("Only <@%s>, who opened this retro, or a bot admin can close it.", r.OpenedBy)
+	}
+	if err := closeRetro(r.Channel + "/" + r.TS); err != nil {
+		log.Printf("Error closing retro in %s: %v", r.Channel, err)
+		return ephemeral("Sorry, something went wrong posting the retro board.")
+	}
+	return ephemeral("The retro is closed and its board is posted.")
+}
+
+// handleRetroExport sends the caller a CSV of the channel's latest retro
+func handleRetroExport(req commandRequest) commandResponse {
+	retros, err := channelRetros(req.ChannelID)
+	if err != nil {
+		log.Printf("Error loading retros: %v", err)
+		return ephemeral("Sorry, something went wrong exporting the retro.")
+	}
+	if len(retros) == 0 {
+		return ephemeral("This channel hasn't had a retro lately.")
+	}
+	r := retros[0]
+	data, err := retroCSV(r)
+	if err != nil {
+		log.Printf("Error rendering retro CSV: %v", err)
+		return ephemeral("Sorry, something went wrong exporting the retro.")
+	}
+	dm, err := openDM(req.UserID)
+	if err == nil {
+		_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+			Channel:  dm,
+			Content:  string(data),
+			FileSize: len(data),
+			Filename: "retro-" + r.OpenedAt.Format(time.DateOnly) + ".csv",
+			Title:    "Retro items from " + r.OpenedAt.Format("2 Jan 2006"),
+		})
+	}
+	if err != nil {
+		log.Printf("Error uploading retro export: %v", err)
+		return ephemeral("Sorry, something went wrong sending you the export.")
+	}
+	return ephemeral(":white_check_mark: I've sent you the %s from the retro opened %s.", plural(len(r.Items), "item"), slackDate(r.OpenedAt))
+}
+
+// channelRetros lists the channel's retros, newest first
+func channelRetros(channel string) ([]retro, error) {
+	retros, err := storeList[retro](store, retrosBucket)
+	if err != nil {
+		return nil, err
+	}
+	retros = slices.DeleteFunc(retros, func(r retro) bool { return r.Channel != channel })
+	slices.SortFunc(retros, func(a, b retro) int { return b.OpenedAt.Compare(a.OpenedAt) })
+	return retros, nil
+}
+
+// openRetro returns the channel's open retro, or nil if there isn't one
+func openRetro(channel string) (*retro, error) {
+	retros, err := channelRetros(channel)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range retros {
+		if !r.Closed {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// retroItemKind returns the kind of item text is tagged as and the text
+// without its tag
+func retroItemKind(text string) (string, string, bool) {
+	text = strings.TrimSpace(text)
+	for _, k := range retroKinds {
+		for _, tag := range k.Tags {
+			if rest, ok := strings.CutPrefix(text, tag); ok && strings.TrimSpace(rest) != "" {
+				return k.Kind, strings.TrimSpace(rest), true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// handleRetroMessage adds tagged replies in an open retro's thread, and
+// tagged DMs, to the retro. DMs go to the newest open retro in a channel
+// the sender is in. It reports whether the message was a retro item.
+func handleRetroMessage(ev *slackevents.MessageEvent) bool {
+	if ev.User == "" || ev.User == botUserID || ev.BotID != "" {
+		return false
+	}
+	kind, text, ok := retroItemKind(ev.Text)
+	if !ok {
+		return false
+	}
+	dm := ev.ChannelType == "im"
+	if !dm && (ev.ThreadTimeStamp == "" || ev.ThreadTimeStamp == ev.TimeStamp) {
+		return false
+	}
+
+	key := ""
+	if dm {
+		retros, err := storeList[retro](store, retrosBucket)
+		if err != nil {
+			log.Printf("Error loading retros: %v", err)
+			return false
+		}
+		slices.SortFunc(retros, func(a, b retro) int { return b.OpenedAt.Compare(a.OpenedAt) })
+		for _, r := range retros {
+			if r.Closed {
+				continue
+			}
+			if member, err := isChannelMember(r.Channel, ev.User); err != nil {
+				log.Printf("Error checking retro channel membership: %v", err)
+			} else if member {
+				key = r.Channel + "/" + r.TS
+				break
+			}
+		}
+		if key == "" {
+			return false
+		}
+	} else {
+		key = ev.Channel + "/" + ev.ThreadTimeStamp
+	}
+
+	item := retroItem{Kind: kind, Text: text, AddedAt: time.Now().UTC()}
+	if !dm {
+		item.Author = ev.User
+	}
+	retrosMu.Lock()
+	var r retro
+	found, err := store.Get(retrosBucket, key, &r)
+	added := err == nil && found && !r.Closed
+	if added {
+		r.Items = append(r.Items, item)
+		err = store.Put(retrosBucket, key, r)
+	}
+	retrosMu.Unlock()
+	if err != nil {
+		log.Printf("Error saving retro item: %v", err)
+		return false
+	}
+	if !added {
+		return false
+	}
+
+	if dm {
+		reply := fmt.Sprintf("Added anonymously to the retro in <#%s>.", r.Channel)
+		if _, err := sendMessage(outboundMessage{Channel: ev.Channel, Text: reply}); err != nil {
+			log.Printf("Error confirming retro item: %v", err)
+		}
+	} else if err := slackClient.AddReaction("white_check_mark", slack.NewRefToMessage(ev.Channel, ev.TimeStamp)); err != nil {
+		log.Printf("Error acknowledging retro item: %v", err)
+	}
+	return true
+}
+
+// closeDueRetros posts the board of each retro whose time is up and forgets
+// old ones
+func closeDueRetros() {
+	retros, err := storeList[retro](store, retrosBucket)
+	if err != nil {
+		log.Printf("Error listing retros: %v", err)
+		return
+	}
+	for _, r := range retros {
+		key := r.Channel + "/" + r.TS
+		switch {
+		case r.Closed && time.Since(r.CloseAt) > retroRetention:
+			if err := store.Delete(retrosBucket, key); err != nil {
+				log.Printf("Error removing retro: %v", err)
+			}
+		case !r.Closed && !time.Now().Before(r.CloseAt):
+			if err := closeRetro(key); err != nil {
+				log.Printf("Error closing retro in %s: %v", r.Channel, err)
+			}
+		}
+	}
+}
+
+// closeRetro stops the retro collecting items and posts its board
+func closeRetro(key string) error {
+	retrosMu.Lock()
+	var r retro
+	found, err := store.Get(retrosBucket, key, &r)
+	open := err == nil && found && !r.Closed
+	if open {
+		r.Closed = true
+		r.CloseAt = time.Now().UTC()
+		err = store.Put(retrosBucket, key, r)
+	}
+	retrosMu.Unlock()
+	if err != nil || !open {
+		return err
+	}
+	_, err = sendMessage(outboundMessage{Channel: r.Channel, Importance: importanceInfo, Text: "Retro board", Blocks: retroBoardBlocks(r)})
+	return err
+}
+
+// retroBoardBlocks groups the retro's items by kind
+func retroBoardBlocks(r retro) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Retro board", false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("Opened by <@%s> %s · %s · `/retro export` for a CSV", r.OpenedBy, slackDate(r.OpenedAt), plural(len(r.Items), "item")), false, false)),
+	}
+	if len(r.Items) == 0 {
+		return append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, "Nobody added anything this time.", false, false), nil, nil))
+	}
+	for _, k := range retroKinds {
+		var lines []string
+		for _, item := range r.Items {
+			if item.Kind != k.Kind {
+				continue
+			}
+			line := "• " + item.Text
+			if item.Author != "" {
+				line += " — <@" + item.Author + ">"
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		text, _ := truncateText("*"+k.Heading+"*\n"+strings.Join(lines, "\n"), maxSectionText)
+		blocks = append(blocks, slack.NewDividerBlock(), slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+	}
+	return blocks
+}
+
+// retroCSV renders the retro's items, with names in place of markup
+func retroCSV(r retro) ([]byte, error) {
+	names := map[string]string{}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"kind", "text", "author", "added_at"})
+	for _, item := range r.Items {
+		author := ""
+		if item.Author != "" {
+			author = exportUserName(item.Author, names)
+		}
+		w.Write([]string{item.Kind, resolveMarkup(item.Text, names), author, item.AddedAt.Format(time.RFC3339)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
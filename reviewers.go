@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket of reviewers picked by /reviewer, keyed by
+// repo#number/reviewer
+const reviewAssignmentsBucket = "review_assignments"
+
+// Used when reviewers.load_window isn't set
+const defaultReviewLoadWindow = 14 * 24 * time.Hour
+
+// reviewAssignment is a reviewer /reviewer picked for a pull request
+type reviewAssignment struct {
+	Repo       string    `json:"repo"`
+	Number     int       `json:"number"`
+	Reviewer   string    `json:"reviewer"`
+	AssignedBy string    `json:"assigned_by"`
+	At         time.Time `json:"at"`
+}
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/reviewer",
+		Usage:       "/reviewer <repo> <pr#>",
+		Description: "Pick a reviewer for a pull request from the repo's team, favouring whoever has reviewed least lately",
+		Handler:     handleReviewerCommand,
+	})
+}
+
+// reviewTeamFor returns the reviewers.teams roster covering repo, and the
+// repo's full owner/name. A bare name matches a listed repo of that name.
+func reviewTeamFor(repo string) (ReviewTeam, string, bool) {
+	for _, team := range appConfig.Reviewers.Teams {
+		for _, r := range team.Repos {
+			if strings.EqualFold(r, repo) || strings.EqualFold(r[strings.LastIndex(r, "/")+1:], repo) {
+				return team, r, true
+			}
+		}
+	}
+	return ReviewTeam{}, "", false
+}
+
+func handleReviewerCommand(req commandRequest) commandResponse {
+	if len(req.Args) != 2 {
+		return ephemeral("Usage: `%s <repo> <pr#>`, e.g. `%s api 123`", req.Command, req.Command)
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(req.Args[1], "#"))
+	if err != nil || number <= 0 {
+		return ephemeral("Usage: `%s <repo> <pr#>`, e.g. `%s api 123`", req.Command, req.Command)
+	}
+	team, repo, ok := reviewTeamFor(req.Args[0])
+	if !ok {
+		return ephemeral("No review team covers `%s`. See `reviewers.teams` in the config.", req.Args[0])
+	}
+	// GitHub can take longer than Slack waits for a slash command
+	go runJob("reviewer roulette", func() {
+		reply := &slack.WebhookMessage{ResponseType: slack.ResponseTypeInChannel}
+		text, err := assignReviewer(team, repo, number, req.UserID)
+		if err != nil {
+			log.Printf("Error assigning reviewer to %s#%d: %v", repo, number, err)
+			reply.ResponseType, text = slack.ResponseTypeEphemeral, strings.TrimSpace("Sorry, something went wrong assigning a reviewer. "+text)
+		}
+		reply.Text = text
+		if err := respond(req.ResponseURL, reply); err != nil {
+			log.Printf("Error replying to /reviewer: %v", err)
+		}
+	})
+	return ephemeral(":game_die: Spinning the wheel for %s#%d…", repo, number)
+}
+
+// assignReviewer picks someone from team for the pull request, requests
+// their review on GitHub and records the pick. It returns the announcement,
+// or on failure what the caller should know.
+func assignReviewer(team ReviewTeam, repo string, number int, assignedBy string) (string, error) {
+	var pr githubPullRequest
+	if err := githubRequest(http.MethodGet, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), nil, &pr); err != nil {
+		return "Does the pull request exist?", err
+	}
+	// The author and anyone already asked aren't picked
+	taken := []string{strings.ToLower(pr.User.Login)}
+	for _, r := range pr.RequestedReviewers {
+		taken = append(taken, strings.ToLower(r.Login))
+	}
+	var candidates []string
+	for _, login := range team.Members {
+		if !slices.Contains(taken, strings.ToLower(login)) {
+			candidates = append(candidates, login)
+		}
+	}
+	if len(candidates) == 0 {
+		return "Everyone on the team is already the author or a reviewer.", fmt.Errorf("no candidates left")
+	}
+	load, err := reviewLoad()
+	if err != nil {
+		return "", err
+	}
+	reviewer := pickReviewer(candidates, load)
+
+	body := map[string][]string{"reviewers": {reviewer}}
+	if err := githubRequest(http.MethodPost, fmt.Sprintf("/repos/%s/pulls/%d/requested_reviewers", repo, number), body, nil); err != nil {
+		return "Can the bot's GitHub token request reviews there?", err
+	}
+	assignment := reviewAssignment{Repo: repo, Number: number, Reviewer: reviewer, AssignedBy: assignedBy, At: time.Now().UTC()}
+	if err := store.Put(reviewAssignmentsBucket, fmt.Sprintf("%s#%d/%s", strings.ToLower(repo), number, strings.ToLower(reviewer)), assignment); err != nil {
+		log.Printf("Error saving review assignment: %v", err)
+	}
+
+	title := strings.NewReplacer("<", "", ">", "", "|", "").Replace(pr.Title)
+	link := fmt.Sprintf("<%s|%s#%d> %s", pr.HTMLURL, repo, number, title)
+	if user := slackUserForGitHub(reviewer); user != "" {
+		dm := fmt.Sprintf(":eyes: <@%s> picked you to review %s.", assignedBy, link)
+		if _, err := sendDM("reviewer", user, outboundMessage{Text: dm}); err != nil {
+			log.Printf("Error telling %s about their review: %v", user, err)
+		}
+	}
+	return fmt.Sprintf(":game_die: %s will review %s (%s lately).", githubMention(reviewer), link, plural(load[strings.ToLower(reviewer)], "review")), nil
+}
+
+// reviewLoad counts each login's picks within reviewers.load_window, keyed
+// by lowercased login, and forgets older ones
+func reviewLoad() (map[string]int, error) {
+	window := appConfig.Reviewers.LoadWindow
+	if window <= 0 {
+		window = defaultReviewLoadWindow
+	}
+	cutoff := time.Now().Add(-window)
+	load := map[string]int{}
+	for _, key := range store.Keys(reviewAssignmentsBucket) {
+		var a reviewAssignment
+		if _, err := store.Get(reviewAssignmentsBucket, key, &a); err != nil {
+			return nil, err
+		}
+		if a.At.Before(cutoff) {
+			if err := store.Delete(reviewAssignmentsBucket, key); err != nil {
+				log.Printf("Error forgetting review assignment %s: %v", key, err)
+			}
+			continue
+		}
+		load[strings.ToLower(a.Reviewer)]++
+	}
+	return load, nil
+}
+
+// pickReviewer draws a candidate at random, each weighted by 1/(1+load) so
+// the busiest are least likely to come up
+func pickReviewer(candidates []string, load map[string]int) string {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, login := range candidates {
+		weights[i] = 1 / float64(1+load[strings.ToLower(login)])
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
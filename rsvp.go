@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for events people can RSVP to, keyed by event ID
+const rsvpEventsBucket = "rsvp_events"
+
+// Callback and block IDs of the /event create modal
+const (
+	rsvpCallbackID       = "rsvp_event_create"
+	rsvpTitleBlock       = "rsvp_title"
+	rsvpStartBlock       = "rsvp_start"
+	rsvpLocationBlock    = "rsvp_location"
+	rsvpDescriptionBlock = "rsvp_description"
+)
+
+// Action IDs of the event message buttons
+const (
+	actionRSVPGoing  = "rsvp_going"
+	actionRSVPMaybe  = "rsvp_maybe"
+	actionRSVPNo     = "rsvp_no"
+	actionRSVPExport = "rsvp_export"
+)
+
+// RSVP answers
+const (
+	rsvpGoing = "going"
+	rsvpMaybe = "maybe"
+	rsvpNo    = "no"
+)
+
+// Used when rsvp.remind_before isn't set
+const defaultRSVPRemindBefore = time.Hour
+
+// Events are forgotten this long after they start
+const rsvpRetention = 30 * 24 * time.Hour
+
+// Most attendees named on the event message
+const rsvpNamedAttendees = 20
+
+// rsvpEvent is an event posted with RSVP buttons
+type rsvpEvent struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Start       time.Time `json:"start"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Organiser   string    `json:"organiser"`
+	Channel     string    `json:"channel"`
+	TS          string    `json:"ts"`
+	// Responses are the answers given, keyed by user ID
+	Responses map[string]rsvpResponse `json:"responses"`
+	Reminded  bool                    `json:"reminded,omitempty"`
+}
+
+// rsvpResponse is someone's latest answer to an event
+type rsvpResponse struct {
+	Answer string    `json:"answer"`
+	At     time.Time `json:"at"`
+}
+
+// Serializes updates, which read and rewrite the whole event
+var rsvpMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/event",
+		Usage:       "/event create",
+		Description: "Post an event people can say they're going to, with a reminder before it starts",
+		Handler:     handleEventCommand,
+	})
+	registerViewSubmission(rsvpCallbackID, handleRSVPEventSubmission)
+	registerBlockAction(actionRSVPGoing, handleRSVPClick)
+	registerBlockAction(actionRSVPMaybe, handleRSVPClick)
+	registerBlockAction(actionRSVPNo, handleRSVPClick)
+	registerBlockAction(actionRSVPExport, handleRSVPExport)
+	registerUserDataset(userDataset{Name: "rsvps", Title: "Event RSVPs", Describe: describeUserRSVPs, Delete: deleteUserRSVPs})
+}
+
+// startRSVPReminders DMs people who are going shortly before each event
+func startRSVPReminders() {
+	runEvery("event reminders", time.Minute, sendRSVPReminders)
+}
+
+func handleEventCommand(req commandRequest) commandResponse {
+	if len(req.Args) == 0 || strings.ToLower(req.Args[0]) != "create" {
+		return ephemeral("Usage: `%s create`", req.Command)
+	}
+	if err := openRSVPEventModal(req.TriggerID, req.ChannelID); err != nil {
+		log.Printf("Error opening event modal: %v", err)
+		return ephemeral("Sorry, something went wrong opening the form.")
+	}
+	return ephemeral("Opening the event form…")
+}
+
+// openRSVPEventModal asks for the details of an event to post in channel
+func openRSVPEventModal(triggerID, channel string) error {
+	input := func(blockID, label string, multiline, optional bool) slack.Block {
+		element := slack.NewPlainTextInputBlockElement(nil, blockID)
+		element.Multiline = multiline
+		return slack.NewInputBlock(blockID, slack.NewTextBlockObject(slack.PlainTextType, label, false, false), nil, element).WithOptional(optional)
+	}
+	view := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      rsvpCallbackID,
+		PrivateMetadata: channel,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "New event", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Post", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			input(rsvpTitleBlock, "What's happening?", false, false),
+			slack.NewInputBlock(rsvpStartBlock, slack.NewTextBlockObject(slack.PlainTextType, "Starts", false, false), nil, slack.NewDateTimePickerBlockElement(rsvpStartBlock)),
+			input(rsvpLocationBlock, "Where", false, true),
+			input(rsvpDescriptionBlock, "Details", true, true),
+		}},
+	}
+	_, err := slackClient.OpenView(triggerID, view)
+	return err
+}
+
+// handleRSVPEventSubmission posts the event to the channel the modal was
+// opened from
+func handleRSVPEventSubmission(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	values := callback.View.State.Values
+	e := &rsvpEvent{
+		ID:          newInteractionToken(),
+		Title:       strings.TrimSpace(values[rsvpTitleBlock][rsvpTitleBlock].Value),
+		Start:       time.Unix(values[rsvpStartBlock][rsvpStartBlock].SelectedDateTime, 0).UTC(),
+		Location:    strings.TrimSpace(values[rsvpLocationBlock][rsvpLocationBlock].Value),
+		Description: strings.TrimSpace(values[rsvpDescriptionBlock][rsvpDescriptionBlock].Value),
+		Organiser:   callback.User.ID,
+		Channel:     callback.View.PrivateMetadata,
+		Responses:   map[string]rsvpResponse{},
+	}
+	if !e.Start.After(time.Now()) {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{rsvpStartBlock: "Pick a time in the future."})
+	}
+
+	// Saved before posting so early clicks find it
+	rsvpMu.Lock()
+	defer rsvpMu.Unlock()
+	if err := store.Put(rsvpEventsBucket, e.ID, e); err != nil {
+		log.Printf("Error saving event: %v", err)
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{rsvpTitleBlock: "Sorry, the event couldn't be saved. Please try again."})
+	}
+	ts, err := sendMessage(outboundMessage{Channel: e.Channel, Importance: importanceInfo, NeedTS: true, Text: "Event: " + e.Title, Blocks: rsvpEventBlocks(e)})
+	if err != nil {
+		log.Printf("Error posting event: %v", err)
+		if err := store.Delete(rsvpEventsBucket, e.ID); err != nil {
+			log.Printf("Error removing event: %v", err)
+		}
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{rsvpTitleBlock: "I couldn't post in that channel. Is the bot in it?"})
+	}
+	e.TS = ts
+	if err := store.Put(rsvpEventsBucket, e.ID, e); err != nil {
+		log.Printf("Error saving event: %v", err)
+	}
+	return nil
+}
+
+func loadRSVPEvent(id string) (*rsvpEvent, error) {
+	e := &rsvpEvent{}
+	found, err := store.Get(rsvpEventsBucket, id, e)
+	if err != nil || !found {
+		return nil, err
+	}
+	if e.Responses == nil {
+		e.Responses = map[string]rsvpResponse{}
+	}
+	return e, nil
+}
+
+// attendees lists who gave answer, in the order they gave it
+func (e *rsvpEvent) attendees(answer string) []string {
+	var users []string
+	for user, r := range e.Responses {
+		if r.Answer == answer {
+			users = append(users, user)
+		}
+	}
+	slices.SortFunc(users, func(a, b string) int { return e.Responses[a].At.Compare(e.Responses[b].At) })
+	return users
+}
+
+// handleRSVPClick records the clicker's answer and updates the counts
+func handleRSVPClick(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	answer := map[string]string{actionRSVPGoing: rsvpGoing, actionRSVPMaybe: rsvpMaybe, actionRSVPNo: rsvpNo}[action.ActionID]
+
+	rsvpMu.Lock()
+	e, err := loadRSVPEvent(action.Value)
+	started := e != nil && !e.Start.After(time.Now())
+	if err == nil && e != nil && !started && e.Responses[callback.User.ID].Answer != answer {
+		e.Responses[callback.User.ID] = rsvpResponse{Answer: answer, At: time.Now().UTC()}
+		err = store.Put(rsvpEventsBucket, e.ID, e)
+	}
+	rsvpMu.Unlock()
+	if err != nil {
+		log.Printf("Error recording RSVP: %v", err)
+		return
+	}
+	switch {
+	case e == nil:
+		pollReply(callback, "I couldn't find this event any more.")
+		return
+	case started:
+		pollReply(callback, "This event has already started.")
+		return
+	}
+	reply := &slack.WebhookMessage{
+		ReplaceOriginal: true,
+		Text:            "Event: " + e.Title,
+		Blocks:          &slack.Blocks{BlockSet: rsvpEventBlocks(e)},
+	}
+	if err := respond(callback.ResponseURL, reply); err != nil {
+		log.Printf("Error updating event: %v", err)
+	}
+}
+
+// rsvpEventBlocks shows the event's details, who's coming and the RSVP
+// buttons
+func rsvpEventBlocks(e *rsvpEvent) []slack.Block {
+	details := []string{":calendar: " + slackDate(e.Start)}
+	if e.Location != "" {
+		details = append(details, ":round_pushpin: "+e.Location)
+	}
+	if e.Description != "" {
+		details = append(details, e.Description)
+	}
+	text, _ := truncateText(strings.Join(details, "\n"), maxSectionText)
+	title, _ := truncateText(e.Title, maxHeaderText)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, title, false, false)),
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+	}
+
+	going, maybe := e.attendees(rsvpGoing), e.attendees(rsvpMaybe)
+	summary := fmt.Sprintf("*%d going* · %d maybe · %d can't make it", len(going), len(maybe), len(e.attendees(rsvpNo)))
+	if len(going) > 0 {
+		named := going[:min(len(going), rsvpNamedAttendees)]
+		summary += "\n" + mentionList(named)
+		if len(going) > len(named) {
+			summary += fmt.Sprintf(" and %d more", len(going)-len(named))
+		}
+	}
+	blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, summary, false, false), nil, nil))
+
+	button := func(actionID, label string) *slack.ButtonBlockElement {
+		return slack.NewButtonBlockElement(actionID, e.ID, slack.NewTextBlockObject(slack.PlainTextType, label, false, false))
+	}
+	return append(blocks,
+		slack.NewActionBlock("",
+			button(actionRSVPGoing, "Going").WithStyle(slack.StylePrimary),
+			button(actionRSVPMaybe, "Maybe"),
+			button(actionRSVPNo, "Can't make it"),
+			button(actionRSVPExport, "Export attendees"),
+		),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("Organised by <@%s>", e.Organiser), false, false)),
+	)
+}
+
+// handleRSVPExport sends the organiser, or an admin, a CSV of everyone's
+// answers
+func handleRSVPExport(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	e, err := loadRSVPEvent(action.Value)
+	if err != nil || e == nil {
+		if err != nil {
+			log.Printf("Error loading event: %v", err)
+		}
+		pollReply(callback, "I couldn't find this event any more.")
+		return
+	}
+	if callback.User.ID != e.Organiser && !isAdmin(callback.User.ID) {
+		pollReply(callback, "Only the organiser or a bot admin can export the attendees.")
+		return
+	}
+
+	names := map[string]string{}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"name", "user_id", "answer", "answered_at"})
+	for _, answer := range []string{rsvpGoing, rsvpMaybe, rsvpNo} {
+		for _, user := range e.attendees(answer) {
+			w.Write([]string{exportUserName(user, names), user, answer, e.Responses[user].At.Format(time.RFC3339)})
+		}
+	}
+	w.Flush()
+	dm, err := openDM(callback.User.ID)
+	if err == nil {
+		_, err = slackClient.UploadFileV2(slack.UploadFileV2Parameters{
+			Channel:  dm,
+			Content:  buf.String(),
+			FileSize: buf.Len(),
+			Filename: "attendees-" + e.Start.Format(time.DateOnly) + ".csv",
+			Title:    "Attendees of " + e.Title,
+		})
+	}
+	if err != nil {
+		log.Printf("Error uploading attendees: %v", err)
+		pollReply(callback, "Sorry, something went wrong sending you the attendees.")
+		return
+	}
+	pollReply(callback, ":white_check_mark: I've sent you the attendee list.")
+}
+
+// sendRSVPReminders DMs everyone going to an event starting within
+// rsvp.remind_before, once, and forgets old events
+func sendRSVPReminders() {
+	before := appConfig.RSVP.RemindBefore
+	if before <= 0 {
+		before = defaultRSVPRemindBefore
+	}
+	events, err := storeList[rsvpEvent](store, rsvpEventsBucket)
+	if err != nil {
+		log.Printf("Error listing events: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, e := range events {
+		if now.Sub(e.Start) > rsvpRetention {
+			if err := store.Delete(rsvpEventsBucket, e.ID); err != nil {
+				log.Printf("Error removing event: %v", err)
+			}
+			continue
+		}
+		if e.Reminded || e.TS == "" || !now.Before(e.Start) || e.Start.Sub(now) > before {
+			continue
+		}
+
+		rsvpMu.Lock()
+		current, err := loadRSVPEvent(e.ID)
+		if err == nil && current != nil {
+			current.Reminded = true
+			err = store.Put(rsvpEventsBucket, e.ID, current)
+		}
+		rsvpMu.Unlock()
+		if err != nil || current == nil {
+			if err != nil {
+				log.Printf("Error saving event: %v", err)
+			}
+			continue
+		}
+
+		link, err := slackClient.GetPermalink(&slack.PermalinkParameters{Channel: current.Channel, Ts: current.TS})
+		if err != nil {
+			log.Printf("Error linking event: %v", err)
+		}
+		text := fmt.Sprintf(":alarm_clock: *%s* starts %s.", current.Title, slackDate(current.Start))
+		if current.Location != "" {
+			text += " It's at " + current.Location + "."
+		}
+		if link != "" {
+			text += " <" + link + "|Details>"
+		}
+		for _, user := range current.attendees(rsvpGoing) {
+			if _, err := sendDM("rsvp", user, outboundMessage{Importance: importanceInfo, Text: text}); err != nil {
+				log.Printf("Error reminding %s of event: %v", user, err)
+			}
+		}
+	}
+}
+
+func describeUserRSVPs(userID string) (string, error) {
+	events, err := storeList[rsvpEvent](store, rsvpEventsBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, e := range events {
+		if r, ok := e.Responses[userID]; ok {
+			items = append(items, fmt.Sprintf("%s (%s): %s", e.Title, e.Start.Format("2 Jan 2006"), r.Answer))
+		}
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserRSVPs(userID string) error {
+	rsvpMu.Lock()
+	defer rsvpMu.Unlock()
+	for _, id := range store.Keys(rsvpEventsBucket) {
+		e, err := loadRSVPEvent(id)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			continue
+		}
+		if _, ok := e.Responses[userID]; !ok {
+			continue
+		}
+		delete(e.Responses, userID)
+		if err := store.Put(rsvpEventsBucket, id, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
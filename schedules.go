@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for scheduled posts added at runtime, keyed by name. They
+// override config schedules with the same name.
+const schedulesBucket = "schedules"
+
+// ScheduledPost is a message posted on a cron schedule
+type ScheduledPost struct {
+	Name string `yaml:"name" json:"name"`
+	// Cron is a five-field expression like "0 16 * * fri", or @daily etc.
+	Cron    string `yaml:"cron" json:"cron"`
+	Channel string `yaml:"channel" json:"channel"`
+	// Timezone the cron expression is read in; default send_windows.timezone
+	Timezone string `yaml:"timezone" json:"timezone,omitempty"`
+	// Text and Blocks are text/templates rendered with a scheduledPostData.
+	// Blocks must render to a JSON array of Block Kit blocks.
+	Text       string     `yaml:"text" json:"text,omitempty"`
+	Blocks     string     `yaml:"blocks" json:"blocks,omitempty"`
+	Importance importance `yaml:"importance" json:"importance,omitempty"`
+}
+
+// scheduledPostData is the data available to scheduled post templates
+type scheduledPostData struct {
+	Name    string
+	Channel string
+	// Now is the scheduled time in the post's timezone
+	Now time.Time
+}
+
+var (
+	// When the scheduler last looked for due posts; posts falling between
+	// two checks are sent on the second
+	scheduleLastCheck   time.Time
+	scheduleLastCheckMu sync.Mutex
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin schedule add",
+		Usage:       `admin schedule add <name> "<cron>" #channel <text>`,
+		Description: `Post a templated message on a cron schedule, e.g. "0 16 * * fri"`,
+		AdminOnly:   true,
+		Handler:     handleScheduleAdd,
+	})
+	registerBotCommand(&command{
+		Name:        "admin schedule remove",
+		Usage:       "admin schedule remove <name>",
+		Description: "Remove a scheduled post added with schedule add",
+		AdminOnly:   true,
+		Handler:     handleScheduleRemove,
+	})
+	registerBotCommand(&command{
+		Name:        "admin schedule list",
+		Usage:       "admin schedule list",
+		Description: "List scheduled posts and when they next run",
+		AdminOnly:   true,
+		Handler:     handleScheduleList,
+	})
+	registerBotCommand(&command{
+		Name:        "admin schedule run",
+		Usage:       "admin schedule run <name>",
+		Description: "Post a scheduled message now, to try it out",
+		AdminOnly:   true,
+		Handler:     handleScheduleRun,
+	})
+	registerApplyKind(applyKind{Name: "schedules", Bucket: schedulesBucket, Decode: decodeScheduleSpec})
+}
+
+// startSchedules posts scheduled messages as they fall due. Runs missed
+// while the bot was down are skipped.
+func startSchedules() {
+	scheduleLastCheck = time.Now()
+	runEvery("schedules", time.Minute, runDueSchedules)
+}
+
+// loadSchedules returns config schedules merged with the ones added at
+// runtime
+func loadSchedules() ([]ScheduledPost, error) {
+	stored, err := storeList[ScheduledPost](store, schedulesBucket)
+	if err != nil {
+		return nil, err
+	}
+	schedules := slices.Clone(appConfig.Schedules)
+	for _, post := range stored {
+		if i := slices.IndexFunc(schedules, func(p ScheduledPost) bool { return p.Name == post.Name }); i >= 0 {
+			schedules[i] = post
+		} else {
+			schedules = append(schedules, post)
+		}
+	}
+	return schedules, nil
+}
+
+// location returns the timezone the post's cron expression is read in
+func (p ScheduledPost) location() (*time.Location, error) {
+	if p.Timezone == "" {
+		return recipientLocation(p.Channel), nil
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	return loc, nil
+}
+
+// validate checks the post has a schedule and renders
+func (p ScheduledPost) validate() error {
+	if p.Name == "" || p.Cron == "" || p.Channel == "" {
+		return fmt.Errorf("name, cron and channel are required")
+	}
+	if p.Text == "" && p.Blocks == "" {
+		return fmt.Errorf("set text, blocks or both")
+	}
+	if _, err := parseCron(p.Cron); err != nil {
+		return err
+	}
+	if _, err := p.location(); err != nil {
+		return err
+	}
+	_, err := p.render(time.Now())
+	return err
+}
+
+// render builds the message for a run at the given time
+func (p ScheduledPost) render(at time.Time) (outboundMessage, error) {
+	data := scheduledPostData{Name: p.Name, Channel: p.Channel, Now: at}
+	msg := outboundMessage{Channel: p.Channel, Importance: p.Importance}
+	var err error
+	if p.Text != "" {
+		if msg.Text, err = renderTemplate("schedule "+p.Name, p.Text, data); err != nil {
+			return outboundMessage{}, err
+		}
+	}
+	if p.Blocks != "" {
+		raw, err := renderTemplate("schedule "+p.Name+" blocks", p.Blocks, data)
+		if err != nil {
+			return outboundMessage{}, err
+		}
+		var blocks slack.Blocks
+		if err := json.Unmarshal([]byte(raw), &blocks); err != nil {
+			return outboundMessage{}, fmt.Errorf("blocks aren't valid Block Kit JSON: %w", err)
+		}
+		msg.Blocks = blocks.BlockSet
+	}
+	return msg, nil
+}
+
+// decodeScheduleSpec decodes a scheduled post from a POST /apply document
+func decodeScheduleSpec(raw json.RawMessage) (string, any, error) {
+	var post ScheduledPost
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&post); err != nil {
+		return "", nil, err
+	}
+	if err := post.validate(); err != nil {
+		return "", nil, err
+	}
+	return post.Name, post, nil
+}
+
+// runDueSchedules posts every schedule that fell due since the last check
+func runDueSchedules() {
+	scheduleLastCheckMu.Lock()
+	since := scheduleLastCheck
+	now := time.Now()
+	scheduleLastCheck = now
+	scheduleLastCheckMu.Unlock()
+
+	schedules, err := loadSchedules()
+	if err != nil {
+		log.Printf("Error loading schedules: %v", err)
+		return
+	}
+	for _, post := range schedules {
+		cron, err := parseCron(post.Cron)
+		if err != nil {
+			log.Printf("Error in schedule %s: %v", post.Name, err)
+			continue
+		}
+		loc, err := post.location()
+		if err != nil {
+			log.Printf("Error in schedule %s: %v", post.Name, err)
+			continue
+		}
+		at := cron.next(since, loc)
+		if at.IsZero() || at.After(now) {
+			continue
+		}
+		if err := sendScheduledPost(post, at); err != nil {
+			log.Printf("Error posting schedule %s: %v", post.Name, err)
+		}
+	}
+}
+
+func sendScheduledPost(post ScheduledPost, at time.Time) error {
+	msg, err := post.render(at)
+	if err != nil {
+		return err
+	}
+	_, err = sendMessage(msg)
+	return err
+}
+
+func handleScheduleAdd(req commandRequest) commandResponse {
+	args := parseQuotedArgs(strings.Join(req.Args, " "))
+	if len(args) < 4 {
+		return ephemeral("Usage: `%s admin schedule add <name> \"<cron>\" #channel <text>`", botCommand)
+	}
+	match := channelMentionPattern.FindStringSubmatch(args[2])
+	if match == nil {
+		return ephemeral("Say which channel to post in, like #general.")
+	}
+	post := ScheduledPost{Name: args[0], Cron: args[1], Channel: match[1], Text: strings.Join(args[3:], " ")}
+	if err := post.validate(); err != nil {
+		return ephemeral("That schedule doesn't work: %v", err)
+	}
+	if err := store.Put(schedulesBucket, post.Name, post); err != nil {
+		log.Printf("Error saving schedule: %v", err)
+		return ephemeral("Sorry, something went wrong saving the schedule.")
+	}
+	return ephemeral("Saved schedule *%s*; it next posts %s.", post.Name, describeNextRun(post))
+}
+
+// describeNextRun says when post next runs, in its own timezone
+func describeNextRun(post ScheduledPost) string {
+	cron, err := parseCron(post.Cron)
+	if err != nil {
+		return "never (" + err.Error() + ")"
+	}
+	loc, err := post.location()
+	if err != nil {
+		return "never (" + err.Error() + ")"
+	}
+	next := cron.next(time.Now(), loc)
+	if next.IsZero() {
+		return "never"
+	}
+	return next.Format("Mon 2 Jan at 15:04 MST")
+}
+
+func handleScheduleRemove(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s admin schedule remove <name>`", botCommand)
+	}
+	name := req.Args[0]
+	found, err := store.Get(schedulesBucket, name, &ScheduledPost{})
+	if err != nil {
+		log.Printf("Error loading schedule: %v", err)
+		return ephemeral("Sorry, something went wrong loading the schedule.")
+	}
+	if !found {
+		if slices.ContainsFunc(appConfig.Schedules, func(p ScheduledPost) bool { return p.Name == name }) {
+			return ephemeral("*%s* is defined in the config file; remove it there.", name)
+		}
+		return ephemeral("There is no schedule called *%s*.", name)
+	}
+	if err := store.Delete(schedulesBucket, name); err != nil {
+		log.Printf("Error deleting schedule: %v", err)
+		return ephemeral("Sorry, something went wrong removing the schedule.")
+	}
+	return ephemeral("Removed schedule *%s*.", name)
+}
+
+func handleScheduleList(req commandRequest) commandResponse {
+	schedules, err := loadSchedules()
+	if err != nil {
+		log.Printf("Error loading schedules: %v", err)
+		return ephemeral("Sorry, something went wrong loading the schedules.")
+	}
+	if len(schedules) == 0 {
+		return ephemeral("There are no scheduled posts yet.")
+	}
+	type row struct {
+		Name    string `table:"Name"`
+		Cron    string `table:"Cron"`
+		Channel string `table:"Channel"`
+		Next    string `table:"Next run"`
+	}
+	rows := make([]row, len(schedules))
+	for i, post := range schedules {
+		rows[i] = row{Name: post.Name, Cron: post.Cron, Channel: post.Channel, Next: describeNextRun(post)}
+	}
+	table, err := renderTable(rows, tableOptions{SortBy: "Name", MaxWidth: 40})
+	if err != nil {
+		log.Printf("Error rendering schedules: %v", err)
+		return ephemeral("Sorry, something went wrong listing the schedules.")
+	}
+	return ephemeral("%s", table)
+}
+
+func handleScheduleRun(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s admin schedule run <name>`", botCommand)
+	}
+	schedules, err := loadSchedules()
+	if err != nil {
+		log.Printf("Error loading schedules: %v", err)
+		return ephemeral("Sorry, something went wrong loading the schedules.")
+	}
+	i := slices.IndexFunc(schedules, func(p ScheduledPost) bool { return p.Name == req.Args[0] })
+	if i < 0 {
+		return ephemeral("There is no schedule called *%s*.", req.Args[0])
+	}
+	post := schedules[i]
+	loc, err := post.location()
+	if err != nil {
+		return ephemeral("That schedule doesn't work: %v", err)
+	}
+	if err := sendScheduledPost(post, time.Now().In(loc)); err != nil {
+		log.Printf("Error posting schedule %s: %v", post.Name, err)
+		return ephemeral("Sorry, something went wrong posting *%s*: %v", post.Name, err)
+	}
+	return ephemeral("Posted *%s* to <#%s>.", post.Name, post.Channel)
+}
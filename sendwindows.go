@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for messages held until the next send window opens, keyed
+// by the time they were queued so they go out in order
+const outboxBucket = "outbox"
+
+// outboxEntry is a held message. Blocks are kept as slack.Blocks so they
+// can be decoded again.
+type outboxEntry struct {
+	Feature     string             `json:"feature" table:"Feature"`
+	Channel     string             `json:"channel" table:"Channel"`
+	ThreadTS    string             `json:"thread_ts,omitempty"`
+	Text        string             `json:"text"`
+	Blocks      slack.Blocks       `json:"blocks"`
+	Attachments []slack.Attachment `json:"attachments,omitempty"`
+	Importance  importance         `json:"importance,omitempty"`
+	NeedTS      bool               `json:"need_ts,omitempty"`
+	ReleaseAt   time.Time          `json:"release_at" table:"Release at"`
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin outbox",
+		Usage:       "admin outbox",
+		Description: "List messages held until the next send window",
+		AdminOnly:   true,
+		Handler:     handleOutboxList,
+	})
+}
+
+// startOutbox releases held messages once their send window opens
+func startOutbox() {
+	if appConfig.SendWindows.Start == "" {
+		return
+	}
+	runEvery("outbox", time.Minute, releaseOutbox)
+}
+
+// sendInWindow posts msg on behalf of feature, a source of output that can
+// wait such as a digest or report. Outside the recipient's send window the
+// message is held in the outbox until the window next opens, unless feature
+// is listed in send_windows.urgent. The timestamp of the message is
+// returned, or "" if it was held.
+func sendInWindow(feature string, msg outboundMessage) (string, error) {
+	cfg := appConfig.SendWindows
+	if cfg.Start == "" || slices.Contains(cfg.Urgent, feature) {
+		return sendMessage(msg)
+	}
+	release, err := nextSendWindow(recipientLocation(msg.Channel), time.Now())
+	if err != nil {
+		log.Printf("Error working out the send window, sending now: %v", err)
+		return sendMessage(msg)
+	}
+	if release.IsZero() {
+		return sendMessage(msg)
+	}
+
+	entry := outboxEntry{
+		Feature:     feature,
+		Channel:     msg.Channel,
+		ThreadTS:    msg.ThreadTS,
+		Text:        msg.Text,
+		Blocks:      slack.Blocks{BlockSet: msg.Blocks},
+		Attachments: msg.Attachments,
+		Importance:  msg.Importance,
+		NeedTS:      msg.NeedTS,
+		ReleaseAt:   release,
+	}
+	if err := store.Put(outboxBucket, fmt.Sprintf("%020d", time.Now().UnixNano()), entry); err != nil {
+		return "", fmt.Errorf("holding message in the outbox: %w", err)
+	}
+	log.Printf("Held %s message to %s until %s", feature, msg.Channel, release.Format(time.RFC3339))
+	return "", nil
+}
+
+// nextSendWindow returns when the send window next opens in loc, or the
+// zero time if it is open at now
+func nextSendWindow(loc *time.Location, now time.Time) (time.Time, error) {
+	cfg := appConfig.SendWindows
+	start, err := time.Parse("15:04", cfg.Start)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("send_windows.start: %w", err)
+	}
+	end, err := time.Parse("15:04", cfg.End)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("send_windows.end: %w", err)
+	}
+	days, err := parseWeekdays(cfg.Days)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("send_windows.days: %w", err)
+	}
+
+	local := now.In(loc)
+	for i := 0; i < 8; i++ {
+		day := local.AddDate(0, 0, i)
+		if !slices.Contains(days, day.Weekday()) {
+			continue
+		}
+		opens := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		closes := time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+		if i == 0 && !local.Before(opens) && local.Before(closes) {
+			return time.Time{}, nil
+		}
+		if local.Before(opens) {
+			return opens, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("send_windows has no days")
+}
+
+// parseWeekdays parses day names like mon or Monday, defaulting to Monday
+// to Friday when names is empty
+func parseWeekdays(names []string) ([]time.Weekday, error) {
+	if len(names) == 0 {
+		return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+	}
+	var days []time.Weekday
+	for _, name := range names {
+		day, ok := weekdayNames[strings.ToLower(name)[:min(3, len(name))]]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// releaseOutbox sends every held message whose send window has opened
+func releaseOutbox() {
+	now := time.Now()
+	for _, key := range store.Keys(outboxBucket) {
+		var entry outboxEntry
+		found, err := store.Get(outboxBucket, key, &entry)
+		if err != nil {
+			log.Printf("Error loading held message: %v", err)
+			continue
+		}
+		if !found || entry.ReleaseAt.After(now) {
+			continue
+		}
+		_, err = sendMessage(outboundMessage{
+			Channel:     entry.Channel,
+			ThreadTS:    entry.ThreadTS,
+			Text:        entry.Text,
+			Blocks:      entry.Blocks.BlockSet,
+			Attachments: entry.Attachments,
+			Importance:  entry.Importance,
+			NeedTS:      entry.NeedTS,
+		})
+		if err != nil {
+			log.Printf("Error releasing held %s message: %v", entry.Feature, err)
+			continue
+		}
+		if err := store.Delete(outboxBucket, key); err != nil {
+			log.Printf("Error clearing held message: %v", err)
+		}
+	}
+}
+
+func handleOutboxList(req commandRequest) commandResponse {
+	entries, err := storeList[outboxEntry](store, outboxBucket)
+	if err != nil {
+		log.Printf("Error loading the outbox: %v", err)
+		return ephemeral("Sorry, something went wrong loading the outbox.")
+	}
+	if len(entries) == 0 {
+		return ephemeral("The outbox is empty.")
+	}
+	table, err := renderTable(entries, tableOptions{Columns: []string{"Feature", "Channel", "Release at"}})
+	if err != nil {
+		log.Printf("Error rendering the outbox: %v", err)
+		return ephemeral("Sorry, something went wrong listing the outbox.")
+	}
+	return ephemeral("%d held until their send window opens:\n%s", len(entries), table)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for shadow run records, keyed by handler name and time
+const shadowRunsBucket = "shadow_runs"
+
+// Records kept per shadow handler; older ones are dropped
+const maxShadowRuns = 200
+
+// responder answers an event, posting through out so the same logic can
+// run live or in shadow mode. Slow answers can be posted later from a job;
+// either way the responder calls out.done once it has answered.
+type responder func(event any, out *eventReplies)
+
+// shadowResponder is a candidate replacement for a live responder
+type shadowResponder struct {
+	Name    string
+	Respond responder
+}
+
+// shadowRun records what a live responder and a shadow responder produced
+// for the same event
+type shadowRun struct {
+	Handler   string    `json:"handler"`
+	EventType string    `json:"event_type"`
+	Time      time.Time `json:"time"`
+	Live      []string  `json:"live"`
+	Shadow    []string  `json:"shadow"`
+	Match     bool      `json:"match"`
+}
+
+// shadowSummary is one row of the shadow report
+type shadowSummary struct {
+	Handler    string    `table:"Handler"`
+	Runs       int       `table:"Runs"`
+	Mismatches int       `table:"Mismatches"`
+	Last       time.Time `table:"Last run"`
+}
+
+// shadowDifference is one event where a shadow handler answered differently,
+// as listed by admin shadow <handler>
+type shadowDifference struct {
+	Time      time.Time `table:"When"`
+	EventType string    `table:"Event"`
+	Live      string    `table:"Live"`
+	Shadow    string    `table:"Shadow"`
+}
+
+// Longest answer shown for each side of a shadow difference
+const shadowDifferenceWidth = 500
+
+// Shadow responders, keyed by the event type they run for
+var shadowResponders = map[string][]shadowResponder{}
+
+// How long a shadow run waits for the live and shadow answers, which can
+// take a while when they come from the language model
+const shadowRunTimeout = 5 * time.Minute
+
+// registerShadowResponder runs r for every eventType event next to the live
+// responder. Its messages are recorded for comparison, never posted.
+func registerShadowResponder(eventType, name string, r responder) {
+	shadowResponders[eventType] = append(shadowResponders[eventType], shadowResponder{Name: name, Respond: r})
+}
+
+// eventReplies is where a responder posts its answer to an event. Live,
+// messages go to Slack; in shadow mode they're only recorded. Either way
+// what was posted is kept for the shadow report.
+type eventReplies struct {
+	shadow     bool
+	mu         sync.Mutex
+	posted     []postedReply
+	sameAsLive bool
+	finished   chan struct{}
+	finish     sync.Once
+}
+
+// postedReply is a message a responder posted, as last updated
+type postedReply struct {
+	TS string
+	// EphemeralTo is the only user shown an ephemeral message
+	EphemeralTo string
+	Message     outboundMessage
+}
+
+func newEventReplies(shadow bool) *eventReplies {
+	return &eventReplies{shadow: shadow, finished: make(chan struct{})}
+}
+
+// post sends msg and returns its timestamp. In shadow mode it's recorded
+// under a made-up timestamp instead, which update understands.
+func (r *eventReplies) post(msg outboundMessage) (string, error) {
+	r.mu.Lock()
+	ts := fmt.Sprintf("shadow.%d", len(r.posted))
+	r.mu.Unlock()
+	if !r.shadow {
+		var err error
+		if ts, err = sendMessage(msg); err != nil {
+			return "", err
+		}
+	}
+	r.mu.Lock()
+	r.posted = append(r.posted, postedReply{TS: ts, Message: msg})
+	r.mu.Unlock()
+	return ts, nil
+}
+
+// postEphemeral shows msg to userID alone
+func (r *eventReplies) postEphemeral(userID string, msg outboundMessage) error {
+	if !r.shadow {
+		if err := sendEphemeral(userID, msg); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.posted = append(r.posted, postedReply{EphemeralTo: userID, Message: msg})
+	r.mu.Unlock()
+	return nil
+}
+
+// update replaces the text of a message post returned ts for
+func (r *eventReplies) update(channel, ts, text string) error {
+	if !r.shadow {
+		if err := updateMessage(channel, ts, text); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.posted {
+		if p.TS == ts && p.Message.Channel == channel {
+			r.posted[i].Message.Text, r.posted[i].Message.Blocks = text, nil
+		}
+	}
+	return nil
+}
+
+// answerAsLive is for shadow responders that would answer the event just
+// as the live one does, without working it out again
+func (r *eventReplies) answerAsLive() {
+	r.mu.Lock()
+	r.sameAsLive = true
+	r.mu.Unlock()
+	r.done()
+}
+
+// done marks the answer complete
+func (r *eventReplies) done() {
+	r.finish.Do(func() { close(r.finished) })
+}
+
+// wait waits for the answer, or until deadline, and describes what was
+// posted in a comparable form. It reports whether the answer is complete.
+func (r *eventReplies) wait(deadline time.Time) ([]string, bool) {
+	complete := true
+	select {
+	case <-r.finished:
+	case <-time.After(time.Until(deadline)):
+		complete = false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summaries := []string{}
+	for _, p := range r.posted {
+		summary := summarizeMessage(p.Message)
+		if p.EphemeralTo != "" {
+			summary = "(only " + p.EphemeralTo + ") " + summary
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, complete
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin shadow",
+		Usage:       "admin shadow [handler]",
+		Description: "Compare shadow-mode handlers with the live ones they would replace",
+		AdminOnly:   true,
+		Handler:     handleShadowReport,
+	})
+}
+
+// respondToEvent has live answer event, then runs any shadow responders
+// for eventType and records how their answers compare
+func respondToEvent(eventType string, event any, live responder) {
+	liveOut := newEventReplies(false)
+	live(event, liveOut)
+
+	shadows := shadowResponders[eventType]
+	if len(shadows) == 0 {
+		return
+	}
+	go func() {
+		for _, shadow := range shadows {
+			runJob("shadow "+shadow.Name, func() {
+				out := newEventReplies(true)
+				shadow.Respond(event, out)
+				run := shadowRun{Handler: shadow.Name, EventType: eventType, Time: time.Now()}
+				deadline := run.Time.Add(shadowRunTimeout)
+				var liveDone, shadowDone bool
+				run.Live, liveDone = liveOut.wait(deadline)
+				run.Shadow, shadowDone = out.wait(deadline)
+				if !liveDone || !shadowDone {
+					log.Printf("Shadow handler %s gave up waiting for an answer to %s", shadow.Name, eventType)
+					return
+				}
+				if out.sameAsLive {
+					run.Shadow = run.Live
+				}
+				run.Match = strings.Join(run.Live, "\n") == strings.Join(run.Shadow, "\n")
+				if !run.Match {
+					log.Printf("Shadow handler %s differs from live for %s: would post %q", shadow.Name, eventType, run.Shadow)
+				}
+				if err := recordShadowRun(run); err != nil {
+					log.Printf("Error recording shadow run: %v", err)
+				}
+			})
+		}
+	}()
+}
+
+// summarizeMessage describes msg in a comparable form
+func summarizeMessage(msg outboundMessage) string {
+	summary := fmt.Sprintf("%s/%s: %s", msg.Channel, msg.ThreadTS, msg.Text)
+	if len(msg.Blocks) > 0 {
+		if blocks, err := json.Marshal(msg.Blocks); err == nil {
+			summary += " " + string(blocks)
+		}
+	}
+	return summary
+}
+
+func recordShadowRun(run shadowRun) error {
+	prefix := run.Handler + "/"
+	key := fmt.Sprintf("%s%020d", prefix, run.Time.UnixNano())
+	if err := store.Put(shadowRunsBucket, key, run); err != nil {
+		return err
+	}
+	var keys []string
+	for _, k := range store.Keys(shadowRunsBucket) {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	for len(keys) > maxShadowRuns {
+		if err := store.Delete(shadowRunsBucket, keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+func handleShadowReport(req commandRequest) commandResponse {
+	runs, err := storeList[shadowRun](store, shadowRunsBucket)
+	if err != nil {
+		log.Printf("Error loading shadow runs: %v", err)
+		return ephemeral("Sorry, something went wrong loading the shadow runs.")
+	}
+	if len(runs) == 0 {
+		return ephemeral("No shadow handlers have run yet.")
+	}
+
+	if len(req.Args) == 0 {
+		byHandler := map[string]*shadowSummary{}
+		for _, run := range runs {
+			summary := byHandler[run.Handler]
+			if summary == nil {
+				summary = &shadowSummary{Handler: run.Handler}
+				byHandler[run.Handler] = summary
+			}
+			summary.Runs++
+			if !run.Match {
+				summary.Mismatches++
+			}
+			summary.Last = run.Time
+		}
+		var rows []shadowSummary
+		for _, summary := range byHandler {
+			rows = append(rows, *summary)
+		}
+		table, err := renderTable(rows, tableOptions{SortBy: "Handler"})
+		if err != nil {
+			log.Printf("Error rendering shadow report: %v", err)
+			return ephemeral("Sorry, something went wrong building the report.")
+		}
+		return ephemeral("%s\nRun `%s admin shadow <handler>` to see recent differences.", table, botCommand)
+	}
+
+	name := req.Args[0]
+	var differences []shadowDifference
+	total, mismatches := 0, 0
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.Handler != name {
+			continue
+		}
+		total++
+		if run.Match {
+			continue
+		}
+		mismatches++
+		if len(differences) < 5 {
+			differences = append(differences, shadowDifference{
+				Time:      run.Time,
+				EventType: run.EventType,
+				Live:      formatShadowOutput(run.Live),
+				Shadow:    formatShadowOutput(run.Shadow),
+			})
+		}
+	}
+	if total == 0 {
+		return ephemeral("*%s* hasn't run yet.", name)
+	}
+	if mismatches == 0 {
+		return ephemeral("*%s* matched the live handler on all %d recent events.", name, total)
+	}
+	grid, err := renderFieldGrid(differences, tableOptions{MaxWidth: shadowDifferenceWidth})
+	if err != nil {
+		log.Printf("Error rendering shadow differences: %v", err)
+		return ephemeral("Sorry, something went wrong building the report.")
+	}
+	text := fmt.Sprintf("*%s* differed on %d of %d recent events. Latest:", name, mismatches, total)
+	blocks := append([]slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)}, grid...)
+	return commandResponse{Text: text, Blocks: blocks}
+}
+
+func formatShadowOutput(summaries []string) string {
+	if len(summaries) == 0 {
+		return "_nothing_"
+	}
+	return "`" + strings.Join(summaries, " | ") + "`"
+}
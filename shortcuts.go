@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+
+	"github.com/slack-go/slack"
+)
+
+// shortcutHandler handles a shortcut from a message's "More actions" menu or
+// the global shortcuts menu
+type shortcutHandler func(callback *slack.InteractionCallback)
+
+var (
+	// Handlers for message_action payloads, keyed by the shortcut's callback_id
+	messageShortcutHandlers = map[string]shortcutHandler{}
+	// Handlers for shortcut payloads, keyed by the shortcut's callback_id
+	globalShortcutHandlers = map[string]shortcutHandler{}
+)
+
+// registerMessageShortcut routes the message shortcut with callbackID, as
+// configured in the Slack app, to handler
+func registerMessageShortcut(callbackID string, handler shortcutHandler) {
+	messageShortcutHandlers[callbackID] = handler
+}
+
+// registerGlobalShortcut routes the global shortcut with callbackID, as
+// configured in the Slack app, to handler. Global shortcuts have no channel
+// or message; they usually open a modal with callback.TriggerID.
+func registerGlobalShortcut(callbackID string, handler shortcutHandler) {
+	globalShortcutHandlers[callbackID] = handler
+}
+
+func init() {
+	registerMessageShortcut("highlight_message", handleHighlightShortcut)
+}
+
+// shortcutReply answers the user who ran a shortcut, visible only to them
+func shortcutReply(callback *slack.InteractionCallback, text string) {
+	err := respond(callback.ResponseURL, &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeEphemeral})
+	if err != nil {
+		log.Printf("Error replying to shortcut %s: %v", callback.CallbackID, err)
+	}
+}
+
+// handleHighlightShortcut adds a message to the channel's pinned highlights
+// without pinning it
+func handleHighlightShortcut(callback *slack.InteractionCallback) {
+	channel := callback.Channel.ID
+	if !isPinMirroredChannel(channel) {
+		shortcutReply(callback, "Highlights aren't set up for this channel.")
+		return
+	}
+	msg := callback.Message
+	if err := mirrorHighlight(channel, "highlighted", callback.User.ID, msg.User, msg.Text, msg.Timestamp); err != nil {
+		log.Printf("Error highlighting message: %v", err)
+		shortcutReply(callback, "Sorry, something went wrong highlighting that message.")
+		return
+	}
+	shortcutReply(callback, ":pushpin: Added to highlights.")
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+// Slack API calls, counted for usage_report.quotas
+const (
+	quotaSlackCalls = "slack_calls"
+	quotaSlackShed  = "slack_calls_shed"
+)
+
+// Used when slack_rate.reserve and slack_rate.max_wait aren't set
+const (
+	defaultSlackRateReserve = 0.2
+	defaultSlackRateMaxWait = 30 * time.Second
+)
+
+// Calls a minute Slack allows for each method in a rate tier
+var slackTierRates = map[int]float64{1: 1, 2: 20, 3: 50, 4: 100}
+
+// Rate tiers of the methods the bot calls, from Slack's method docs.
+// Methods not listed are treated as tier 3; slack_rate.tiers adds more.
+// chat.postMessage is really "special" (about one a second per channel),
+// which tier 4 approximates for the workspace as a whole.
+var slackMethodTiers = map[string]int{
+	"auth.test":                    4,
+	"chat.delete":                  3,
+	"chat.getPermalink":            4,
+	"chat.postEphemeral":           4,
+	"chat.postMessage":             4,
+	"chat.scheduleMessage":         3,
+	"chat.unfurl":                  3,
+	"chat.update":                  3,
+	"conversations.history":        3,
+	"conversations.info":           3,
+	"conversations.list":           2,
+	"conversations.members":        4,
+	"conversations.open":           3,
+	"conversations.replies":        3,
+	"conversations.unarchive":      2,
+	"dnd.info":                     3,
+	"files.completeUploadExternal": 4,
+	"files.getUploadURLExternal":   4,
+	"files.info":                   4,
+	"functions.completeError":      4,
+	"functions.completeSuccess":    4,
+	"reactions.add":                3,
+	"usergroups.list":              2,
+	"usergroups.users.list":        4,
+	"usergroups.users.update":      2,
+	"users.info":                   4,
+	"users.list":                   2,
+	"views.open":                   4,
+	"views.publish":                4,
+	"views.push":                   4,
+	"views.update":                 4,
+}
+
+// slackBackgroundKey marks a request context as background work
+type slackBackgroundKey struct{}
+
+// backgroundSlackContext is for Slack calls made by background work that
+// can wait a round, like the event watchdog's sampling. Once their method's
+// budget is down to slack_rate.reserve they're dropped rather than queued,
+// so the calls people are waiting on get the rest.
+func backgroundSlackContext() context.Context {
+	return context.WithValue(context.Background(), slackBackgroundKey{}, true)
+}
+
+var (
+	errSlackRateShed   = errors.New("slack call dropped to keep rate budget for more important calls")
+	errSlackRateQueued = errors.New("slack call gave up waiting for rate budget")
+)
+
+// slackMethodBudget is a token bucket holding a minute of one method's
+// calls, and what happened to them since the bot started
+type slackMethodBudget struct {
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+	// Set from Retry-After when Slack rate limits the method anyway
+	pausedUntil time.Time
+	stats       slackMethodStats
+}
+
+// slackMethodStats is how one Slack method has been used since the bot
+// started
+type slackMethodStats struct {
+	Method string `json:"method" table:"Method"`
+	Tier   int    `json:"tier" table:"Tier"`
+	Calls  int    `json:"calls" table:"Calls"`
+	// Queued calls waited for budget; Shed ones were dropped
+	Queued      int `json:"queued" table:"Queued"`
+	Shed        int `json:"shed" table:"Shed"`
+	RateLimited int `json:"rate_limited" table:"429s"`
+	// WaitMS is the total time calls spent queued
+	WaitMS int64 `json:"wait_ms" table:"Waited (ms)"`
+}
+
+var (
+	slackBudgets   = map[string]*slackMethodBudget{}
+	slackBudgetsMu sync.Mutex
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin slack-rate",
+		Usage:       "admin slack-rate",
+		Description: "Show how the bot is using its Slack API rate limits, by method",
+		AdminOnly:   true,
+		Handler:     handleSlackRateCommand,
+	})
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/admin/slack-rate",
+		Scope:       scopeAdmin,
+		OperationID: "ListSlackRateStats",
+		Summary:     "List Slack API calls by method since the bot started, with how many waited, were dropped or were rate limited",
+		Response:    []slackMethodStats{},
+		Handler:     handleAPISlackRate,
+	})
+}
+
+// slackBudgetTransport spends each Slack API call against its method's
+// rate tier, so features share the limits instead of racing into 429s.
+// Calls wait for budget, except low-priority ones (background calls and
+// methods in slack_rate.low_priority), which are dropped once only the
+// reserve is left.
+type slackBudgetTransport struct {
+	next http.RoundTripper
+}
+
+func (t slackBudgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, ok := strings.CutPrefix(req.URL.Path, "/api/")
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	budget := slackBudgetFor(method)
+	if err := budget.acquire(req.Context(), isLowPrioritySlackCall(req, method)); err != nil {
+		return nil, err
+	}
+	recordQuotaUsage(quotaSlackCalls, 1)
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		retry, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		budget.pause(time.Duration(max(retry, 1)) * time.Second)
+		log.Printf("Slack rate limited %s for %ds", method, max(retry, 1))
+	}
+	return resp, err
+}
+
+// slackBudgetFor returns the budget for method, starting it full
+func slackBudgetFor(method string) *slackMethodBudget {
+	slackBudgetsMu.Lock()
+	defer slackBudgetsMu.Unlock()
+	budget, ok := slackBudgets[method]
+	if !ok {
+		tier := slackMethodTier(method)
+		budget = &slackMethodBudget{tokens: slackTierRates[tier], updated: time.Now(), stats: slackMethodStats{Method: method, Tier: tier}}
+		slackBudgets[method] = budget
+	}
+	return budget
+}
+
+func slackMethodTier(method string) int {
+	if tier, ok := appConfig.SlackRate.Tiers[method]; ok && slackTierRates[tier] > 0 {
+		return tier
+	}
+	if tier, ok := slackMethodTiers[method]; ok {
+		return tier
+	}
+	return 3
+}
+
+func isLowPrioritySlackCall(req *http.Request, method string) bool {
+	background, _ := req.Context().Value(slackBackgroundKey{}).(bool)
+	return background || slices.Contains(appConfig.SlackRate.LowPriority, method)
+}
+
+// acquire takes one call from the budget, waiting for it to refill unless
+// the call is low priority
+func (b *slackMethodBudget) acquire(ctx context.Context, low bool) error {
+	reserve := appConfig.SlackRate.Reserve
+	if reserve <= 0 {
+		reserve = defaultSlackRateReserve
+	}
+	maxWait := appConfig.SlackRate.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultSlackRateMaxWait
+	}
+
+	var waited time.Duration
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		rate := slackTierRates[b.stats.Tier]
+		b.tokens = min(rate, b.tokens+now.Sub(b.updated).Minutes()*rate)
+		b.updated = now
+		var wait time.Duration
+		switch {
+		case low && (now.Before(b.pausedUntil) || b.tokens < reserve*rate+1):
+			b.stats.Shed++
+			b.mu.Unlock()
+			recordQuotaUsage(quotaSlackShed, 1)
+			return errSlackRateShed
+		case now.Before(b.pausedUntil):
+			wait = b.pausedUntil.Sub(now)
+		case b.tokens >= 1:
+			b.tokens--
+			b.stats.Calls++
+			if waited > 0 {
+				b.stats.Queued++
+				b.stats.WaitMS += waited.Milliseconds()
+			}
+			b.mu.Unlock()
+			return nil
+		default:
+			wait = time.Duration((1 - b.tokens) / rate * float64(time.Minute))
+		}
+		b.mu.Unlock()
+
+		if waited+wait > maxWait {
+			return errSlackRateQueued
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		waited += wait
+	}
+}
+
+// pause stops calls to the method for d, after Slack rate limited it
+func (b *slackMethodBudget) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pausedUntil = time.Now().Add(d)
+	b.tokens = 0
+	b.stats.RateLimited++
+}
+
+// slackRateStats returns every method called since the bot started,
+// busiest first
+func slackRateStats() []slackMethodStats {
+	slackBudgetsMu.Lock()
+	budgets := make([]*slackMethodBudget, 0, len(slackBudgets))
+	for _, budget := range slackBudgets {
+		budgets = append(budgets, budget)
+	}
+	slackBudgetsMu.Unlock()
+	stats := make([]slackMethodStats, len(budgets))
+	for i, budget := range budgets {
+		budget.mu.Lock()
+		stats[i] = budget.stats
+		budget.mu.Unlock()
+	}
+	slices.SortFunc(stats, func(a, b slackMethodStats) int {
+		if a.Calls != b.Calls {
+			return b.Calls - a.Calls
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return stats
+}
+
+func handleSlackRateCommand(req commandRequest) commandResponse {
+	stats := slackRateStats()
+	if len(stats) == 0 {
+		return ephemeral("I haven't called the Slack API since I started.")
+	}
+	table, err := renderTable(stats, tableOptions{})
+	if err != nil {
+		log.Printf("Error rendering Slack rate stats: %v", err)
+		return ephemeral("Sorry, something went wrong listing Slack API usage.")
+	}
+	text := "*Slack API calls since I started*\n" + table
+	return commandResponse{
+		Text:   text,
+		Blocks: []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)},
+	}
+}
+
+func handleAPISlackRate(c *gin.Context) {
+	c.JSON(http.StatusOK, slackRateStats())
+}
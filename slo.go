@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Latency metrics SLOs can be defined on
+const (
+	// Time to answer a request from Slack (events, interactions, commands)
+	metricSlackAck = "slack_ack"
+	// Time a command handler takes to run
+	metricCommand = "command"
+)
+
+const (
+	// Default SLO compliance window
+	defaultSLOWindow = 28 * 24 * time.Hour
+	// Minimum time between two alerts for the same SLO
+	sloAlertCooldown = time.Hour
+)
+
+// Multiwindow burn rate alerts: the error budget is burning fast enough to
+// run out within the window when both the long and the short window exceed
+// the rate
+var sloBurnAlerts = []struct {
+	Long, Short time.Duration
+	Rate        float64
+}{
+	{time.Hour, 5 * time.Minute, 14.4},
+	{6 * time.Hour, 30 * time.Minute, 6},
+}
+
+// sloMinute counts good and total events in one minute
+type sloMinute struct {
+	Minute int64
+	Good   int
+	Total  int
+}
+
+// sloTracker keeps per-minute counts for one SLO over its window
+type sloTracker struct {
+	SLO
+	mu        sync.Mutex
+	minutes   []sloMinute
+	lastAlert time.Time
+}
+
+// sloStatus is one row of the SLO report
+type sloStatus struct {
+	Name       string `table:"SLO"`
+	Target     string `table:"Target"`
+	Compliance string `table:"Compliance"`
+	Budget     string `table:"Budget left"`
+	Burn1h     string `table:"Burn 1h"`
+	Burn6h     string `table:"Burn 6h"`
+}
+
+// Trackers for the configured SLOs, keyed by metric
+var sloTrackers = map[string][]*sloTracker{}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin slo",
+		Usage:       "admin slo",
+		Description: "Show the bot's own response time SLOs and error budgets",
+		AdminOnly:   true,
+		Handler:     handleSLOReport,
+	})
+}
+
+// startSLOTracking sets up the configured SLOs and checks their burn rates
+// every minute
+func startSLOTracking(cfg SLOConfig) error {
+	for _, slo := range cfg.Objectives {
+		if slo.Metric != metricSlackAck && slo.Metric != metricCommand {
+			return fmt.Errorf("slo %s: unknown metric %q", slo.Name, slo.Metric)
+		}
+		if slo.Threshold <= 0 || slo.Target <= 0 || slo.Target >= 1 {
+			return fmt.Errorf("slo %s needs a threshold and a target between 0 and 1", slo.Name)
+		}
+		if slo.Window <= 0 {
+			slo.Window = defaultSLOWindow
+		}
+		sloTrackers[slo.Metric] = append(sloTrackers[slo.Metric], &sloTracker{SLO: slo})
+	}
+	if len(sloTrackers) > 0 {
+		runEvery("slo burn rate", time.Minute, checkSLOBurnRates)
+	}
+	return nil
+}
+
+// recordLatency counts one event of metric against every SLO defined on it
+func recordLatency(metric string, latency time.Duration) {
+	minute := time.Now().Unix() / 60
+	for _, tracker := range sloTrackers[metric] {
+		tracker.record(minute, latency <= tracker.Threshold)
+	}
+}
+
+// measureSlackAck records how long Slack waited for each request
+func measureSlackAck(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	recordLatency(metricSlackAck, time.Since(start))
+}
+
+func (t *sloTracker) record(minute int64, good bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.minutes); n == 0 || t.minutes[n-1].Minute != minute {
+		t.minutes = append(t.minutes, sloMinute{Minute: minute})
+		// Drop minutes that have left the window
+		oldest := minute - int64(t.Window/time.Minute)
+		drop := 0
+		for drop < len(t.minutes) && t.minutes[drop].Minute <= oldest {
+			drop++
+		}
+		t.minutes = t.minutes[drop:]
+	}
+	last := &t.minutes[len(t.minutes)-1]
+	last.Total++
+	if good {
+		last.Good++
+	}
+}
+
+// errorRate returns the share of bad events over the last window, and whether
+// there were any events
+func (t *sloTracker) errorRate(window time.Duration) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since := time.Now().Add(-window).Unix() / 60
+	good, total := 0, 0
+	for i := len(t.minutes) - 1; i >= 0 && t.minutes[i].Minute > since; i-- {
+		good += t.minutes[i].Good
+		total += t.minutes[i].Total
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(total-good) / float64(total), true
+}
+
+// burnRate is how many times faster than sustainable the error budget is
+// being spent over window
+func (t *sloTracker) burnRate(window time.Duration) float64 {
+	rate, _ := t.errorRate(window)
+	return rate / (1 - t.Target)
+}
+
+// checkSLOBurnRates alerts the ops channel about SLOs burning their error
+// budget too fast
+func checkSLOBurnRates() {
+	channel := appConfig.SLOs.OpsChannel
+	if channel == "" {
+		return
+	}
+	for _, trackers := range sloTrackers {
+		for _, tracker := range trackers {
+			for _, alert := range sloBurnAlerts {
+				long, short := tracker.burnRate(alert.Long), tracker.burnRate(alert.Short)
+				if long < alert.Rate || short < alert.Rate || time.Since(tracker.lastAlert) < sloAlertCooldown {
+					continue
+				}
+				tracker.lastAlert = time.Now()
+				text := fmt.Sprintf(":rotating_light: SLO *%s* is burning its error budget %.1fx too fast over the last %s (%.1fx over %s). "+
+					"At this rate the %s budget runs out in about %s.",
+					tracker.Name, long, alert.Long, short, alert.Short, tracker.Window, budgetExhaustion(tracker, long))
+				if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceCritical, Text: text}); err != nil {
+					log.Printf("Error sending SLO alert: %v", err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// budgetExhaustion estimates how long the error budget lasts at burn rate
+func budgetExhaustion(tracker *sloTracker, burn float64) time.Duration {
+	if burn <= 0 {
+		return tracker.Window
+	}
+	return (time.Duration(float64(tracker.Window) / burn)).Round(time.Minute)
+}
+
+func handleSLOReport(req commandRequest) commandResponse {
+	if len(sloTrackers) == 0 {
+		return ephemeral("No SLOs are configured.")
+	}
+	var rows []sloStatus
+	for _, slo := range appConfig.SLOs.Objectives {
+		for _, tracker := range sloTrackers[slo.Metric] {
+			if tracker.Name != slo.Name {
+				continue
+			}
+			row := sloStatus{
+				Name:       tracker.Name,
+				Target:     fmt.Sprintf("%.2f%% < %s", 100*tracker.Target, tracker.Threshold),
+				Compliance: "no data",
+				Budget:     "100%",
+				Burn1h:     fmt.Sprintf("%.1fx", tracker.burnRate(time.Hour)),
+				Burn6h:     fmt.Sprintf("%.1fx", tracker.burnRate(6*time.Hour)),
+			}
+			if rate, ok := tracker.errorRate(tracker.Window); ok {
+				row.Compliance = fmt.Sprintf("%.2f%%", 100*(1-rate))
+				row.Budget = fmt.Sprintf("%.0f%%", 100*max(0, 1-rate/(1-tracker.Target)))
+			}
+			rows = append(rows, row)
+		}
+	}
+	table, err := renderTable(rows, tableOptions{})
+	if err != nil {
+		log.Printf("Error rendering SLO report: %v", err)
+		return ephemeral("Sorry, something went wrong building the report.")
+	}
+	return ephemeral("%s\nCounts cover the time since the bot last started.", table)
+}
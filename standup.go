@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store buckets for standups. Runs are keyed by team and date; responses by
+// team, date and user.
+const (
+	standupRunsBucket      = "standup_runs"
+	standupResponsesBucket = "standup_responses"
+)
+
+// Callback, action and block IDs of the standup prompt and modal
+const (
+	standupCallbackID     = "standup_submit"
+	actionStandupOpen     = "standup_open"
+	standupYesterdayBlock = "standup_yesterday"
+	standupTodayBlock     = "standup_today"
+	standupBlockersBlock  = "standup_blockers"
+	standupAnswerAction   = "answer"
+)
+
+// standupRun tracks one team's standup on one day
+type standupRun struct {
+	Prompted bool `json:"prompted"`
+	// PromptedUsers lists who has been asked, for per_user teams
+	PromptedUsers []string `json:"prompted_users,omitempty"`
+	Summarized    bool     `json:"summarized"`
+	// SummaryTS is empty if the routing policy didn't post the summary
+	SummaryTS string `json:"summary_ts,omitempty"`
+}
+
+// standupResponse is one member's answers
+type standupResponse struct {
+	User      string    `json:"user"`
+	Yesterday string    `json:"yesterday"`
+	Today     string    `json:"today"`
+	Blockers  string    `json:"blockers"`
+	Submitted time.Time `json:"submitted"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "standup",
+		Usage:       "standup [team]",
+		Description: "Fill in today's standup",
+		Handler:     handleStandupCommand,
+	})
+	registerBlockAction(actionStandupOpen, handleStandupOpen)
+	registerViewSubmission(standupCallbackID, handleStandupSubmission)
+	registerUserDataset(userDataset{Name: "standups", Title: "Standup answers", Describe: describeUserStandups, Delete: deleteUserStandups})
+}
+
+// startStandups prompts and summarises each team's standup on schedule
+func startStandups() {
+	if len(appConfig.Standups) == 0 {
+		return
+	}
+	runEvery("standups", time.Minute, runDueStandups)
+}
+
+// standupToday returns the team's date now in its timezone, and whether
+// standups happen on that day
+func standupToday(team StandupConfig, now time.Time) (time.Time, bool, error) {
+	loc := time.UTC
+	if team.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(team.Timezone); err != nil {
+			return time.Time{}, false, fmt.Errorf("standup %s timezone: %w", team.Name, err)
+		}
+	}
+	days, err := parseWeekdays(team.Days)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("standup %s days: %w", team.Name, err)
+	}
+	local := now.In(loc)
+	return local, slices.Contains(days, local.Weekday()), nil
+}
+
+// reached reports whether the clock time hh:mm has passed on local's day
+func reached(local time.Time, clock string) (bool, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return false, err
+	}
+	at := time.Date(local.Year(), local.Month(), local.Day(), t.Hour(), t.Minute(), 0, 0, local.Location())
+	return !local.Before(at), nil
+}
+
+// standupAt returns the clock time hh:mm on date in loc
+func standupAt(date, clock string, loc *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+}
+
+// runDueStandups sends prompts and summaries whose time has come
+func runDueStandups() {
+	now := time.Now()
+	for _, team := range appConfig.Standups {
+		local, ok, err := standupToday(team, now)
+		if err != nil {
+			log.Printf("Error scheduling standup: %v", err)
+			continue
+		}
+		if team.PerUser {
+			// Members behind the team's timezone can still be answering
+			// yesterday's standup after the team's day has ended
+			yesterday := local.AddDate(0, 0, -1)
+			var run standupRun
+			if _, err := store.Get(standupRunsBucket, team.Name+":"+yesterday.Format("2006-01-02"), &run); err != nil {
+				log.Printf("Error loading standup run: %v", err)
+			} else if len(run.PromptedUsers) > 0 && !run.Summarized {
+				runStandupDay(team, yesterday, now)
+			}
+		}
+		if ok {
+			runStandupDay(team, local, now)
+		}
+	}
+}
+
+// runStandupDay prompts and summarises the team's standup on day, which is
+// in the team's timezone. A per_user team's members are each asked at Time
+// on their own clock, and the summary waits until SummaryTime has passed
+// for all of them.
+func runStandupDay(team StandupConfig, day time.Time, now time.Time) {
+	date := day.Format("2006-01-02")
+	key := team.Name + ":" + date
+	var run standupRun
+	if _, err := store.Get(standupRunsBucket, key, &run); err != nil {
+		log.Printf("Error loading standup run: %v", err)
+		return
+	}
+	due := func(clock string, loc *time.Location) (bool, error) {
+		at, err := standupAt(date, clock, loc)
+		return !now.Before(at), err
+	}
+
+	promptDue, err := due(team.Time, day.Location())
+	if err != nil {
+		log.Printf("Error scheduling standup %s: time: %v", team.Name, err)
+		return
+	}
+	summaryDue, err := due(team.SummaryTime, day.Location())
+	if err != nil {
+		log.Printf("Error scheduling standup %s: summary_time: %v", team.Name, err)
+		return
+	}
+	memberLocations := map[string]*time.Location{}
+	if team.PerUser {
+		summaryDue = true
+		for _, member := range team.Members {
+			memberLocations[member] = userLocationOr(member, day.Location())
+			if ok, _ := due(team.SummaryTime, memberLocations[member]); !ok {
+				summaryDue = false
+			}
+		}
+	}
+	changed := false
+	switch {
+	case summaryDue || run.Prompted:
+	case team.PerUser:
+		for _, member := range team.Members {
+			if slices.Contains(run.PromptedUsers, member) {
+				continue
+			}
+			if ok, _ := due(team.Time, memberLocations[member]); ok {
+				promptStandup(team, date, member)
+				run.PromptedUsers, changed = append(run.PromptedUsers, member), true
+			}
+		}
+	case promptDue:
+		promptStandup(team, date, team.Members...)
+		run.Prompted, changed = true, true
+	}
+	if summaryDue && !run.Summarized {
+		ts, err := postStandupSummary(team, date)
+		if err != nil {
+			log.Printf("Error posting standup summary: %v", err)
+			return
+		}
+		run.Prompted, run.Summarized, run.SummaryTS, changed = true, true, ts, true
+	}
+	if changed {
+		if err := store.Put(standupRunsBucket, key, run); err != nil {
+			log.Printf("Error saving standup run: %v", err)
+		}
+	}
+}
+
+// promptStandup DMs members a button that opens the standup modal
+func promptStandup(team StandupConfig, date string, members ...string) {
+	text := fmt.Sprintf(":sunrise: Time for the *%s* standup. What did you do yesterday, what's next today, and is anything in your way?", team.Name)
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(actionStandupOpen, team.Name+"|"+date,
+				slack.NewTextBlockObject(slack.PlainTextType, "Fill in standup", false, false)).WithStyle(slack.StylePrimary),
+		),
+	}
+	for _, member := range members {
+		if _, err := sendDM("standup", member, outboundMessage{Text: text, Blocks: blocks}); err != nil {
+			log.Printf("Error prompting %s for standup: %v", member, err)
+		}
+	}
+}
+
+// standupTeam finds the configured team called name
+func standupTeam(name string) (StandupConfig, bool) {
+	i := slices.IndexFunc(appConfig.Standups, func(t StandupConfig) bool { return strings.EqualFold(t.Name, name) })
+	if i < 0 {
+		return StandupConfig{}, false
+	}
+	return appConfig.Standups[i], true
+}
+
+func handleStandupCommand(req commandRequest) commandResponse {
+	var teams []StandupConfig
+	for _, team := range appConfig.Standups {
+		if slices.Contains(team.Members, req.UserID) && (len(req.Args) == 0 || strings.EqualFold(team.Name, req.Args[0])) {
+			teams = append(teams, team)
+		}
+	}
+	switch {
+	case len(teams) == 0 && len(req.Args) > 0:
+		return ephemeral("You aren't in a standup called *%s*.", req.Args[0])
+	case len(teams) == 0:
+		return ephemeral("You aren't in any standups.")
+	case len(teams) > 1:
+		var names []string
+		for _, team := range teams {
+			names = append(names, "`"+team.Name+"`")
+		}
+		return ephemeral("You're in several standups; say which: `%s standup <team>` with one of %s.", botCommand, strings.Join(names, ", "))
+	}
+	local, _, err := standupToday(teams[0], time.Now())
+	if err != nil {
+		log.Printf("Error opening standup: %v", err)
+		return ephemeral("Sorry, something went wrong opening the standup.")
+	}
+	if err := openStandupModal(req.TriggerID, teams[0], local.Format("2006-01-02"), req.UserID); err != nil {
+		log.Printf("Error opening standup modal: %v", err)
+		return ephemeral("Sorry, something went wrong opening the standup.")
+	}
+	return ephemeral("Opening the *%s* standup…", teams[0].Name)
+}
+
+// handleStandupOpen opens the standup modal from a prompt's button
+func handleStandupOpen(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	name, date, _ := strings.Cut(action.Value, "|")
+	team, ok := standupTeam(name)
+	if !ok {
+		return
+	}
+	if err := openStandupModal(callback.TriggerID, team, date, callback.User.ID); err != nil {
+		log.Printf("Error opening standup modal: %v", err)
+	}
+}
+
+// openStandupModal asks userID for their standup, prefilled with any
+// answers they already gave
+func openStandupModal(triggerID string, team StandupConfig, date, userID string) error {
+	var previous standupResponse
+	if _, err := store.Get(standupResponsesBucket, team.Name+":"+date+":"+userID, &previous); err != nil {
+		return err
+	}
+	question := func(blockID, label, answer string, optional bool) slack.Block {
+		input := slack.NewPlainTextInputBlockElement(nil, standupAnswerAction)
+		input.Multiline = true
+		input.InitialValue = answer
+		return slack.NewInputBlock(blockID, slack.NewTextBlockObject(slack.PlainTextType, label, false, false), nil, input).WithOptional(optional)
+	}
+	view := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      standupCallbackID,
+		PrivateMetadata: team.Name + "|" + date,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, truncateTitle(team.Name+" standup"), false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Send", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			question(standupYesterdayBlock, "What did you do yesterday?", previous.Yesterday, false),
+			question(standupTodayBlock, "What will you do today?", previous.Today, false),
+			question(standupBlockersBlock, "Anything blocking you?", previous.Blockers, true),
+		}},
+	}
+	_, err := slackClient.OpenView(triggerID, view)
+	return err
+}
+
+// truncateTitle fits a modal title within Slack's 24 character limit
+func truncateTitle(title string) string {
+	if runes := []rune(title); len(runes) > 24 {
+		return string(runes[:23]) + "…"
+	}
+	return title
+}
+
+// handleStandupSubmission records a member's answers. Answers sent after
+// the summary was posted are added to its thread.
+func handleStandupSubmission(callback *slack.InteractionCallback) *slack.ViewSubmissionResponse {
+	name, date, _ := strings.Cut(callback.View.PrivateMetadata, "|")
+	team, ok := standupTeam(name)
+	if !ok {
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{standupYesterdayBlock: "This standup no longer exists."})
+	}
+	values := callback.View.State.Values
+	response := standupResponse{
+		User:      callback.User.ID,
+		Yesterday: strings.TrimSpace(values[standupYesterdayBlock][standupAnswerAction].Value),
+		Today:     strings.TrimSpace(values[standupTodayBlock][standupAnswerAction].Value),
+		Blockers:  strings.TrimSpace(values[standupBlockersBlock][standupAnswerAction].Value),
+		Submitted: time.Now(),
+	}
+	if err := store.Put(standupResponsesBucket, team.Name+":"+date+":"+response.User, response); err != nil {
+		log.Printf("Error saving standup response: %v", err)
+		return slack.NewErrorsViewSubmissionResponse(map[string]string{standupYesterdayBlock: "Sorry, your standup couldn't be saved. Please try again."})
+	}
+
+	var run standupRun
+	if _, err := store.Get(standupRunsBucket, team.Name+":"+date, &run); err != nil {
+		log.Printf("Error loading standup run: %v", err)
+		return nil
+	}
+	if run.SummaryTS != "" {
+		text := formatStandupResponse(response)
+		if _, err := sendMessage(outboundMessage{Channel: team.Channel, ThreadTS: run.SummaryTS, Text: text}); err != nil {
+			log.Printf("Error posting late standup: %v", err)
+		}
+	}
+	return nil
+}
+
+// formatStandupResponse renders one member's answers
+func formatStandupResponse(r standupResponse) string {
+	text := fmt.Sprintf("*<@%s>*\n*Yesterday:* %s\n*Today:* %s", r.User, r.Yesterday, r.Today)
+	if r.Blockers != "" {
+		text += "\n:warning: *Blockers:* " + r.Blockers
+	}
+	return text
+}
+
+// postStandupSummary posts everyone's answers to the team channel, noting
+// who hasn't answered yet
+func postStandupSummary(team StandupConfig, date string) (string, error) {
+	var missing []string
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, fmt.Sprintf("%s standup, %s", team.Name, date), false, false)),
+	}
+	answered := 0
+	for _, member := range team.Members {
+		var response standupResponse
+		found, err := store.Get(standupResponsesBucket, team.Name+":"+date+":"+member, &response)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			missing = append(missing, "<@"+member+">")
+			continue
+		}
+		answered++
+		blocks = append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, formatStandupResponse(response), false, false), nil, nil))
+	}
+	footer := fmt.Sprintf("%d of %d answered.", answered, len(team.Members))
+	if len(missing) > 0 {
+		footer += " Still to come: " + strings.Join(missing, ", ") + ". Late answers go in this thread."
+	}
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, footer, false, false)))
+	text := fmt.Sprintf(":sunrise: %s standup for %s: %s", team.Name, date, footer)
+	return sendMessage(outboundMessage{Channel: team.Channel, Importance: importanceNotice, NeedTS: true, Text: text, Blocks: blocks})
+}
+
+// userStandupKeys returns the keys of userID's standup answers
+func userStandupKeys(userID string) []string {
+	var keys []string
+	for _, key := range store.Keys(standupResponsesBucket) {
+		if strings.HasSuffix(key, ":"+userID) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func describeUserStandups(userID string) (string, error) {
+	keys := userStandupKeys(userID)
+	items := make([]string, len(keys))
+	for i, key := range keys {
+		team, date, _ := strings.Cut(strings.TrimSuffix(key, ":"+userID), ":")
+		items[i] = fmt.Sprintf("%s, %s", team, date)
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserStandups deletes userID's answers; summaries already posted in
+// Slack stay
+func deleteUserStandups(userID string) error {
+	for _, key := range userStandupKeys(userID) {
+		if err := store.Delete(standupResponsesBucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
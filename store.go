@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Global persistent store instance
+var store *Store
+
+// Store is a small persistent key/value store grouped into buckets. The whole
+// store is kept in memory and written to a JSON file on every change, which is
+// plenty for the amount of data a single-workspace bot keeps.
+type Store struct {
+	mu   sync.RWMutex
+	path string
+	data map[string]map[string]json.RawMessage
+	// version changes with every write. It starts from the time the store
+	// was opened, so it doesn't repeat across restarts.
+	version uint64
+}
+
+// openStore loads the store at path, creating it on first write if missing
+func openStore(path string) (*Store, error) {
+	s := &Store{path: path, data: map[string]map[string]json.RawMessage{}, version: uint64(time.Now().UnixNano())}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading store %s: %w", path, err)
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("parsing store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get decodes the value stored under bucket/key into dst, reporting whether
+// it was found
+func (s *Store) Get(bucket, key string, dst any) (bool, error) {
+	s.mu.RLock()
+	raw, ok := s.data[bucket][key]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return true, fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Put stores value under bucket/key and persists the store
+func (s *Store) Put(bucket, key string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding %s/%s: %w", bucket, key, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[bucket] == nil {
+		s.data[bucket] = map[string]json.RawMessage{}
+	}
+	s.data[bucket][key] = raw
+	return s.save()
+}
+
+// Delete removes bucket/key and persists the store
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.data[bucket], key)
+	return s.save()
+}
+
+// DeleteBucket removes bucket and everything in it and persists the store
+func (s *Store) DeleteBucket(bucket string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[bucket]; !ok {
+		return nil
+	}
+	delete(s.data, bucket)
+	return s.save()
+}
+
+// Keys returns the keys in bucket in sorted order
+func (s *Store) Keys(bucket string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data[bucket]))
+	for key := range s.data[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Buckets returns the names of every bucket in sorted order
+func (s *Store) Buckets() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buckets := make([]string, 0, len(s.data))
+	for bucket := range s.data {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	return buckets
+}
+
+// Snapshot returns the whole store as JSON, as it would be written to disk
+func (s *Store) Snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding store: %w", err)
+	}
+	return raw, nil
+}
+
+// Version changes with every write, so a replica can tell when it has
+// fallen behind
+func (s *Store) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// Replace swaps the whole store for one from Snapshot and persists it
+func (s *Store) Replace(snapshot []byte) error {
+	var data map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &data); err != nil {
+		return fmt.Errorf("parsing store snapshot: %w", err)
+	}
+	if data == nil {
+		data = map[string]map[string]json.RawMessage{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data
+	return s.save()
+}
+
+// storeChange sets bucket/key to Value, or deletes it when Value is nil,
+// provided it still holds Base (nil meaning it wasn't there)
+type storeChange struct {
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Base   json.RawMessage `json:"base,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// diffSnapshots lists what changed between two snapshots from Snapshot
+func diffSnapshots(from, to []byte) ([]storeChange, error) {
+	var before, after map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(from, &before); err != nil {
+		return nil, fmt.Errorf("parsing store snapshot: %w", err)
+	}
+	if err := json.Unmarshal(to, &after); err != nil {
+		return nil, fmt.Errorf("parsing store snapshot: %w", err)
+	}
+	var changes []storeChange
+	for bucket, values := range after {
+		for key, value := range values {
+			if base, ok := before[bucket][key]; !ok || !bytes.Equal(base, value) {
+				changes = append(changes, storeChange{Bucket: bucket, Key: key, Base: before[bucket][key], Value: value})
+			}
+		}
+	}
+	for bucket, values := range before {
+		for key, base := range values {
+			if _, ok := after[bucket][key]; !ok {
+				changes = append(changes, storeChange{Bucket: bucket, Key: key, Base: base})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Apply makes each change whose key still holds its Base, and persists
+// the store. It returns the changes it skipped because the key had
+// changed since.
+func (s *Store) Apply(changes []storeChange) ([]storeChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var conflicts []storeChange
+	applied := 0
+	for _, change := range changes {
+		current, ok := s.data[change.Bucket][change.Key]
+		if ok != (change.Base != nil) || ok && !bytes.Equal(current, change.Base) {
+			if !bytes.Equal(current, change.Value) {
+				conflicts = append(conflicts, change)
+			}
+			continue
+		}
+		if change.Value == nil {
+			delete(s.data[change.Bucket], change.Key)
+		} else {
+			if s.data[change.Bucket] == nil {
+				s.data[change.Bucket] = map[string]json.RawMessage{}
+			}
+			s.data[change.Bucket][change.Key] = change.Value
+		}
+		applied++
+	}
+	if applied == 0 {
+		return conflicts, nil
+	}
+	return conflicts, s.save()
+}
+
+// save writes the store to disk atomically. Callers must hold s.mu.
+func (s *Store) save() error {
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return fmt.Errorf("encoding store: %w", err)
+	}
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("creating store directory: %w", err)
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	s.version++
+	return nil
+}
+
+// storeList decodes every value in bucket, in key order
+func storeList[T any](s *Store, bucket string) ([]T, error) {
+	var values []T
+	for _, key := range s.Keys(bucket) {
+		var value T
+		found, err := s.Get(bucket, key, &value)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
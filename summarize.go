@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// How much of a thread is sent to the language model, newest messages
+// first; about 6,000 tokens
+const maxSummaryChars = 24000
+
+const summaryPrompt = "You summarize Slack threads. Reply with a short summary: what was discussed, what was decided " +
+	"and any open questions or action items with their owners, as a few bullet points. " +
+	"Format with Slack mrkdwn, not Markdown. Keep mentions such as <@U123> and <#C123> exactly as written."
+
+// Words that ask for a summary when the bot is mentioned in a thread
+var summaryWords = []string{"tldr", "tl;dr", "summarize", "summarise"}
+
+func init() {
+	registerMessageShortcut("summarize_thread", handleSummarizeShortcut)
+}
+
+// isSummaryRequest reports whether a mention asks for a summary, as in
+// "@bot tldr"
+func isSummaryRequest(text string) bool {
+	text = strings.ToLower(strings.TrimSpace(leadingMentionPattern.ReplaceAllString(text, "")))
+	return slices.Contains(summaryWords, strings.TrimRight(text, "?!. "))
+}
+
+// answerSummaryRequest posts a summary of the thread a mention is in
+func answerSummaryRequest(ev *slackevents.AppMentionEvent, reply outboundMessage, out *eventReplies) {
+	if ev.ThreadTimeStamp == "" {
+		reply.Text = "Mention me with `tldr` in a thread and I'll summarize it."
+	} else if summary, err := summarizeThread(ev.Channel, ev.ThreadTimeStamp, ev.TimeStamp); errors.Is(err, errLLMNotConfigured) {
+		reply.Text = "Summaries need a language model, and none is set up."
+	} else if err != nil {
+		log.Printf("Error summarizing thread: %v", err)
+		reply.Text = "Sorry, something went wrong summarizing this thread."
+	} else {
+		reply.Text = summary
+	}
+	if _, err := out.post(reply); err != nil {
+		log.Printf("Error replying to mention: %v", err)
+	}
+}
+
+// handleSummarizeShortcut sends the user a summary of the thread the
+// message is in, visible only to them
+func handleSummarizeShortcut(callback *slack.InteractionCallback) {
+	if appConfig.LLM.URL == "" || appConfig.LLM.Model == "" {
+		shortcutReply(callback, "Summaries need a language model, and none is set up.")
+		return
+	}
+	if !featureEnabled(featureAIReplies, callback.Channel.ID) {
+		shortcutReply(callback, "Summaries are switched off right now.")
+		return
+	}
+	threadTS := callback.Message.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = callback.Message.Timestamp
+	}
+	// Summaries take longer than Slack waits for the shortcut to be answered
+	go runJob("thread summary", func() {
+		summary, err := summarizeThread(callback.Channel.ID, threadTS, "")
+		if err != nil {
+			log.Printf("Error summarizing thread: %v", err)
+			shortcutReply(callback, "Sorry, something went wrong summarizing that thread.")
+			return
+		}
+		shortcutReply(callback, summary)
+	})
+}
+
+// summarizeThread asks the language model to summarize the thread at
+// threadTS, leaving out the message at skipTS
+func summarizeThread(channel, threadTS, skipTS string) (string, error) {
+	messages, err := fetchThread(channel, threadTS)
+	if err != nil {
+		return "", err
+	}
+	messages = slices.DeleteFunc(messages, func(m slack.Message) bool { return m.Timestamp == skipTS })
+	if len(messages) < 2 {
+		return "There's nothing to summarize yet; the thread has no replies.", nil
+	}
+	transcript := threadTranscript(messages)
+	summary, err := llmChat([]llmMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript},
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("*Summary of %d messages*\n%s", len(messages), strings.TrimSpace(summary)), nil
+}
+
+// fetchThread returns every message in a thread, oldest first
+func fetchThread(channel, threadTS string) ([]slack.Message, error) {
+	params := &slack.GetConversationRepliesParameters{ChannelID: channel, Timestamp: threadTS, Limit: 200}
+	var messages []slack.Message
+	for {
+		page, hasMore, cursor, err := slackClient.GetConversationReplies(params)
+		if err != nil {
+			return nil, fmt.Errorf("fetching thread %s in %s: %w", threadTS, channel, err)
+		}
+		messages = append(messages, page...)
+		if !hasMore || cursor == "" {
+			return messages, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+// threadTranscript writes messages as "<@U123>: text" lines, keeping the
+// newest ones that fit in maxSummaryChars
+func threadTranscript(messages []slack.Message) string {
+	var lines []string
+	size := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		author := "<@" + msg.User + ">"
+		if msg.User == "" {
+			author = msg.Username
+			if author == "" {
+				author = "A bot"
+			}
+		}
+		line := author + ": " + msg.Text
+		if size+len(line) > maxSummaryChars && len(lines) > 0 {
+			lines = append(lines, "(earlier messages left out)")
+			break
+		}
+		lines = append(lines, line)
+		size += len(line) + 1
+	}
+	slices.Reverse(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+// Default per-column limit for rendered cells, in characters
+const defaultTableColumnWidth = 30
+
+// tableOptions controls how rows are rendered by renderTable and renderFieldGrid
+type tableOptions struct {
+	// Columns selects and orders the columns by header or field name;
+	// empty means every exported field
+	Columns []string
+	// SortBy names the column to sort rows by; empty keeps input order
+	SortBy     string
+	Descending bool
+	// MaxWidth caps each cell; defaults to defaultTableColumnWidth
+	MaxWidth int
+}
+
+// tableColumn is one resolved struct field
+type tableColumn struct {
+	Header string
+	Name   string
+	Index  []int
+}
+
+// tableData is rows flattened into cells, after selection and sorting
+type tableData struct {
+	Headers []string
+	Rows    [][]string
+}
+
+// renderTable renders a slice of structs as an aligned monospaced table in a
+// code block. Field headers come from `table:"Header"` tags, falling back
+// to the field name; a tag of "-" hides the field.
+func renderTable(rows any, opts tableOptions) (string, error) {
+	data, err := buildTableData(rows, opts)
+	if err != nil {
+		return "", err
+	}
+
+	widths := make([]int, len(data.Headers))
+	for i, header := range data.Headers {
+		widths[i] = utf8.RuneCountInString(header)
+	}
+	for _, row := range data.Rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("```\n")
+	writeTableLine(&b, data.Headers, widths)
+	separator := make([]string, len(widths))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	writeTableLine(&b, separator, widths)
+	for _, row := range data.Rows {
+		writeTableLine(&b, row, widths)
+	}
+	b.WriteString("```")
+	return b.String(), nil
+}
+
+func writeTableLine(b *strings.Builder, cells []string, widths []int) {
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteString("  ")
+		}
+		b.WriteString(cell)
+		if i < len(cells)-1 {
+			b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+		}
+	}
+	b.WriteString("\n")
+}
+
+// renderFieldGrid renders each row as a section of "*Header*\nvalue" fields,
+// which reads better than a table on mobile for rows with few columns
+func renderFieldGrid(rows any, opts tableOptions) ([]slack.Block, error) {
+	data, err := buildTableData(rows, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []slack.Block
+	for i, row := range data.Rows {
+		if i > 0 {
+			blocks = append(blocks, slack.NewDividerBlock())
+		}
+		var fields []*slack.TextBlockObject
+		for j, cell := range row {
+			if cell == "" {
+				cell = "-"
+			}
+			fields = append(fields, slack.NewTextBlockObject(slack.MarkdownType,
+				fmt.Sprintf("*%s*\n%s", data.Headers[j], cell), false, false))
+		}
+		for start := 0; start < len(fields); start += maxSectionFields {
+			end := min(start+maxSectionFields, len(fields))
+			blocks = append(blocks, slack.NewSectionBlock(nil, fields[start:end], nil))
+		}
+	}
+	return blocks, nil
+}
+
+// buildTableData resolves columns, sorts rows and formats every cell
+func buildTableData(rows any, opts tableOptions) (tableData, error) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return tableData{}, fmt.Errorf("table rows must be a slice, got %T", rows)
+	}
+	elemType := value.Type().Elem()
+	if elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return tableData{}, fmt.Errorf("table rows must be structs, got %s", elemType)
+	}
+
+	columns, err := tableColumns(elemType, opts.Columns)
+	if err != nil {
+		return tableData{}, err
+	}
+
+	// Nil rows are left out before sorting, which can't read their fields
+	items := make([]reflect.Value, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		if item := reflect.Indirect(value.Index(i)); item.IsValid() {
+			items = append(items, item)
+		}
+	}
+	if opts.SortBy != "" {
+		sortColumn, ok := findTableColumn(elemType, opts.SortBy)
+		if !ok {
+			return tableData{}, fmt.Errorf("unknown sort column %q", opts.SortBy)
+		}
+		slices.SortStableFunc(items, func(a, b reflect.Value) int {
+			c := compareTableValues(a.FieldByIndex(sortColumn.Index), b.FieldByIndex(sortColumn.Index))
+			if opts.Descending {
+				return -c
+			}
+			return c
+		})
+	}
+
+	maxWidth := opts.MaxWidth
+	if maxWidth <= 0 {
+		maxWidth = defaultTableColumnWidth
+	}
+	data := tableData{}
+	for _, column := range columns {
+		header, _ := truncateText(column.Header, maxWidth)
+		data.Headers = append(data.Headers, header)
+	}
+	for _, item := range items {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i], _ = truncateText(formatTableValue(item.FieldByIndex(column.Index)), maxWidth)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	return data, nil
+}
+
+// tableColumns returns the columns of t, restricted to and ordered by names
+func tableColumns(t reflect.Type, names []string) ([]tableColumn, error) {
+	if len(names) == 0 {
+		var columns []tableColumn
+		for i := 0; i < t.NumField(); i++ {
+			if column, ok := fieldColumn(t.Field(i)); ok {
+				columns = append(columns, column)
+			}
+		}
+		return columns, nil
+	}
+
+	columns := make([]tableColumn, 0, len(names))
+	for _, name := range names {
+		column, ok := findTableColumn(t, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown table column %q", name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// findTableColumn looks a column up by header or field name, ignoring case
+func findTableColumn(t reflect.Type, name string) (tableColumn, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		column, ok := fieldColumn(t.Field(i))
+		if ok && (strings.EqualFold(column.Header, name) || strings.EqualFold(column.Name, name)) {
+			return column, true
+		}
+	}
+	return tableColumn{}, false
+}
+
+func fieldColumn(field reflect.StructField) (tableColumn, bool) {
+	if !field.IsExported() {
+		return tableColumn{}, false
+	}
+	header := field.Tag.Get("table")
+	if header == "-" {
+		return tableColumn{}, false
+	}
+	if header == "" {
+		header = field.Name
+	}
+	return tableColumn{Header: header, Name: field.Name, Index: field.Index}, true
+}
+
+// formatTableValue renders a single cell
+func formatTableValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format("2006-01-02 15:04")
+	case time.Duration:
+		return value.Round(time.Second).String()
+	case fmt.Stringer:
+		return value.String()
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("%.2f", v.Float())
+	case reflect.Slice:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = formatTableValue(v.Index(i))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// compareTableValues orders numbers numerically, times chronologically and
+// everything else by its rendered text
+func compareTableValues(a, b reflect.Value) int {
+	a, b = reflect.Indirect(a), reflect.Indirect(b)
+	if !a.IsValid() || !b.IsValid() {
+		return cmp.Compare(boolRank(a.IsValid()), boolRank(b.IsValid()))
+	}
+	if ta, ok := a.Interface().(time.Time); ok {
+		return ta.Compare(b.Interface().(time.Time))
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	}
+	return strings.Compare(strings.ToLower(formatTableValue(a)), strings.ToLower(formatTableValue(b)))
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Store bucket for each user's channel defaults, keyed by user ID
+const channelTargetsBucket = "channel_targets"
+
+// Where an inferred channel came from
+const (
+	targetExplicit = "explicit"
+	targetCurrent  = "current"
+	targetDefault  = "default"
+	targetLast     = "last"
+)
+
+// channelTarget is the channel a command acts on
+type channelTarget struct {
+	ChannelID string
+	Source    string
+}
+
+// channelPrefs are what channel inference remembers about a user
+type channelPrefs struct {
+	// Default is used when a command is run from a DM
+	Default string `json:"default,omitempty"`
+	// Last holds the channel each command last acted on, keyed by command
+	Last map[string]string `json:"last,omitempty"`
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "channel default",
+		Usage:       "channel default [#channel|clear]",
+		Description: "Show or set the channel commands use when you run them from a DM",
+		Handler:     handleChannelDefault,
+	})
+	registerUserDataset(userDataset{Name: "channel_targets", Title: "Default and recent channels", Describe: describeUserChannelPrefs, Delete: deleteUserChannelPrefs})
+	registerApplyKind(applyKind{Name: "channel_targets", Bucket: channelTargetsBucket, Decode: decodeChannelTargetSpec})
+}
+
+// decodeChannelTargetSpec decodes a user's default channel from a POST
+// /apply document, like {"user": "U123", "default": "C123"}. The channels
+// commands last acted on aren't part of it, so applying forgets them for
+// the users it changes.
+func decodeChannelTargetSpec(raw json.RawMessage) (string, any, error) {
+	var spec struct {
+		User    string `json:"user"`
+		Default string `json:"default"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return "", nil, err
+	}
+	if !userIDPattern.MatchString(spec.User) {
+		return "", nil, fmt.Errorf("channel target needs a user ID, not %q", spec.User)
+	}
+	if !channelIDPattern.MatchString(spec.Default) || isDMChannel(spec.Default) {
+		return "", nil, fmt.Errorf("default channel for %s must be a channel ID, not %q", spec.User, spec.Default)
+	}
+	return spec.User, channelPrefs{Default: spec.Default}, nil
+}
+
+// inferChannelTarget picks the channel a command acts on: arg when it's
+// a channel mention, otherwise the channel the command was run in. Commands
+// run in a DM fall back to the user's default channel, then to the channel
+// this command last acted on. An empty arg or a non-mention counts as no
+// channel given.
+func inferChannelTarget(req commandRequest, commandName, arg string) (channelTarget, error) {
+	prefs, err := loadChannelPrefs(req.UserID)
+	if err != nil {
+		return channelTarget{}, err
+	}
+	target := channelTarget{ChannelID: req.ChannelID, Source: targetCurrent}
+	switch {
+	case channelMentionPattern.MatchString(arg):
+		target = channelTarget{ChannelID: channelMentionPattern.FindStringSubmatch(arg)[1], Source: targetExplicit}
+	case !isDMChannel(req.ChannelID):
+	case prefs.Default != "":
+		target = channelTarget{ChannelID: prefs.Default, Source: targetDefault}
+	case prefs.Last[commandName] != "":
+		target = channelTarget{ChannelID: prefs.Last[commandName], Source: targetLast}
+	}
+
+	if !isDMChannel(target.ChannelID) && prefs.Last[commandName] != target.ChannelID {
+		if prefs.Last == nil {
+			prefs.Last = map[string]string{}
+		}
+		prefs.Last[commandName] = target.ChannelID
+		if err := store.Put(channelTargetsBucket, req.UserID, prefs); err != nil {
+			log.Printf("Error remembering channel for %s: %v", commandName, err)
+		}
+	}
+	if target.Source == targetDefault || target.Source == targetLast {
+		confirmInferredTarget(req, target)
+	}
+	return target, nil
+}
+
+// confirmInferredTarget tells the user, where they ran the command, which
+// other channel it acted on and why
+func confirmInferredTarget(req commandRequest, target channelTarget) {
+	why := "your default channel"
+	if target.Source == targetLast {
+		why = "where you last used this command"
+	}
+	err := sendEphemeral(req.UserID, outboundMessage{
+		Channel: req.ChannelID,
+		Text:    fmt.Sprintf(":dart: Using <#%s>, %s. Add a #channel to pick another.", target.ChannelID, why),
+	})
+	if err != nil {
+		log.Printf("Error confirming inferred channel: %v", err)
+	}
+}
+
+// isDMChannel reports whether channel is a direct message with the bot
+func isDMChannel(channel string) bool {
+	return strings.HasPrefix(channel, "D")
+}
+
+func loadChannelPrefs(userID string) (*channelPrefs, error) {
+	prefs := &channelPrefs{}
+	if _, err := store.Get(channelTargetsBucket, userID, prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+func handleChannelDefault(req commandRequest) commandResponse {
+	prefs, err := loadChannelPrefs(req.UserID)
+	if err != nil {
+		log.Printf("Error loading channel defaults: %v", err)
+		return ephemeral("Sorry, something went wrong loading your default channel.")
+	}
+	if len(req.Args) == 0 {
+		if prefs.Default == "" {
+			return ephemeral("You don't have a default channel. Set one with `%s channel default #channel`.", botCommand)
+		}
+		return ephemeral("Your default channel is <#%s>.", prefs.Default)
+	}
+
+	arg := req.Args[0]
+	switch {
+	case strings.EqualFold(arg, "clear"):
+		prefs.Default = ""
+	case channelMentionPattern.MatchString(arg):
+		prefs.Default = channelMentionPattern.FindStringSubmatch(arg)[1]
+	default:
+		return ephemeral("Usage: `%s channel default [#channel|clear]`", botCommand)
+	}
+	if err := store.Put(channelTargetsBucket, req.UserID, prefs); err != nil {
+		log.Printf("Error saving default channel: %v", err)
+		return ephemeral("Sorry, something went wrong saving your default channel.")
+	}
+	if prefs.Default == "" {
+		return ephemeral("Cleared your default channel.")
+	}
+	return ephemeral("Commands you run from a DM will use <#%s>.", prefs.Default)
+}
+
+func describeUserChannelPrefs(userID string) (string, error) {
+	var prefs channelPrefs
+	found, err := store.Get(channelTargetsBucket, userID, &prefs)
+	if err != nil || !found {
+		return "", err
+	}
+	var items []string
+	for name, channel := range prefs.Last {
+		items = append(items, fmt.Sprintf("Last used with %s: <#%s>", name, channel))
+	}
+	sort.Strings(items)
+	if prefs.Default != "" {
+		items = append([]string{fmt.Sprintf("Default: <#%s>", prefs.Default)}, items...)
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserChannelPrefs(userID string) error {
+	return store.Delete(channelTargetsBucket, userID)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for the conversation in threads the bot answered with the
+// language model, keyed by channel and thread timestamp
+const threadMemoryBucket = "thread_memory"
+
+// Defaults for llm.mentions.memory
+const (
+	defaultMemoryMaxTokens = 2000
+	defaultMemoryTTL       = 30 * 24 * time.Hour
+)
+
+// Matches the thread a reply's permalink belongs to
+var permalinkThreadPattern = regexp.MustCompile(`thread_ts=(\d+\.\d+)`)
+
+// threadMessage is one message remembered from a thread
+type threadMessage struct {
+	// Role is user or assistant
+	Role string `json:"role"`
+	// User wrote the message; empty for the bot's own replies
+	User string    `json:"user,omitempty"`
+	Text string    `json:"text"`
+	At   time.Time `json:"at"`
+}
+
+// threadMemory is the conversation in one thread
+type threadMemory struct {
+	Channel  string          `json:"channel"`
+	ThreadTS string          `json:"thread_ts"`
+	Messages []threadMessage `json:"messages"`
+	Updated  time.Time       `json:"updated"`
+}
+
+// Held while a thread's memory is read and rewritten
+var threadMemoryMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/forget",
+		Usage:       "/forget <thread link>",
+		Description: "Make me forget what was said in a thread",
+		Handler:     handleForget,
+	})
+	registerUserDataset(userDataset{
+		Name:     "thread_memory",
+		Title:    "Messages I remember from threads",
+		Describe: describeUserThreadMemory,
+		Delete:   deleteUserThreadMemory,
+	})
+}
+
+// startThreadMemory forgets threads nobody has written in for
+// llm.mentions.memory.ttl
+func startThreadMemory() {
+	runEvery("thread memory", time.Hour, expireThreadMemory)
+}
+
+func threadMemoryKey(channel, threadTS string) string {
+	return channel + "/" + threadTS
+}
+
+// rememberInThread adds messages to a thread's memory, starting it if the
+// bot hasn't spoken there yet
+func rememberInThread(channel, threadTS string, messages ...threadMessage) error {
+	threadMemoryMu.Lock()
+	defer threadMemoryMu.Unlock()
+	key := threadMemoryKey(channel, threadTS)
+	memory := threadMemory{Channel: channel, ThreadTS: threadTS}
+	if _, err := store.Get(threadMemoryBucket, key, &memory); err != nil {
+		return err
+	}
+	memory.Messages = append(memory.Messages, messages...)
+	memory.Updated = time.Now().UTC()
+	// Keep a few windows' worth; older messages would never be sent again
+	for len(memory.Messages) > 1 && estimateTokens(memory.Messages) > 4*memoryMaxTokens() {
+		memory.Messages = memory.Messages[1:]
+	}
+	return store.Put(threadMemoryBucket, key, memory)
+}
+
+// threadContext returns the newest messages of a thread that fit in
+// llm.mentions.memory.max_tokens, as turns for the language model. The
+// newest message is always included, cut short if it alone is over.
+func threadContext(channel, threadTS string) ([]llmMessage, error) {
+	var memory threadMemory
+	if _, err := store.Get(threadMemoryBucket, threadMemoryKey(channel, threadTS), &memory); err != nil {
+		return nil, err
+	}
+	budget := memoryMaxTokens()
+	start := len(memory.Messages)
+	for start > 0 && (start == len(memory.Messages) || estimateTokens(memory.Messages[start-1:]) <= budget) {
+		start--
+	}
+	// Models expect the conversation to open with the user, and the roles
+	// to alternate
+	window := memory.Messages[start:]
+	for len(window) > 0 && window[0].Role != "user" {
+		window = window[1:]
+	}
+	if len(window) == 1 && estimateTokens(window) > budget {
+		newest := window[0]
+		newest.Text, _ = truncateText(newest.Text, max((budget-1)*4-len(newest.User), 1))
+		window = []threadMessage{newest}
+	}
+	var turns []llmMessage
+	for _, msg := range window {
+		content := msg.Text
+		if msg.Role == "user" && msg.User != "" {
+			content = fmt.Sprintf("<@%s>: %s", msg.User, msg.Text)
+		}
+		if n := len(turns); n > 0 && turns[n-1].Role == msg.Role {
+			turns[n-1].Content += "\n" + content
+		} else {
+			turns = append(turns, llmMessage{Role: msg.Role, Content: content})
+		}
+	}
+	return turns, nil
+}
+
+// estimateTokens guesses how many tokens messages take, at about four
+// characters a token
+func estimateTokens(messages []threadMessage) int {
+	chars := 0
+	for _, msg := range messages {
+		chars += len([]rune(msg.Text)) + len(msg.User)
+	}
+	return chars/4 + len(messages)
+}
+
+func memoryMaxTokens() int {
+	if n := appConfig.LLM.Mentions.Memory.MaxTokens; n > 0 {
+		return n
+	}
+	return defaultMemoryMaxTokens
+}
+
+// handleThreadMemoryMessage remembers replies in threads the bot is part
+// of, so later answers know what was said. Mentions of the bot are
+// remembered when they're answered instead.
+func handleThreadMemoryMessage(ev *slackevents.MessageEvent) {
+	if !appConfig.LLM.Mentions.Enabled || ev.ThreadTimeStamp == "" || ev.User == "" || ev.User == botUserID || ev.BotID != "" {
+		return
+	}
+	if strings.Contains(ev.Text, "<@"+botUserID+">") {
+		return
+	}
+	key := threadMemoryKey(ev.Channel, ev.ThreadTimeStamp)
+	if found, err := store.Get(threadMemoryBucket, key, &threadMemory{}); err != nil || !found {
+		if err != nil {
+			log.Printf("Error loading thread memory: %v", err)
+		}
+		return
+	}
+	msg := threadMessage{Role: "user", User: ev.User, Text: ev.Text, At: time.Now().UTC()}
+	if err := rememberInThread(ev.Channel, ev.ThreadTimeStamp, msg); err != nil {
+		log.Printf("Error saving thread memory: %v", err)
+	}
+}
+
+// expireThreadMemory forgets threads that have gone quiet
+func expireThreadMemory() {
+	ttl := appConfig.LLM.Mentions.Memory.TTL
+	if ttl <= 0 {
+		ttl = defaultMemoryTTL
+	}
+	memories, err := storeList[threadMemory](store, threadMemoryBucket)
+	if err != nil {
+		log.Printf("Error loading thread memory: %v", err)
+		return
+	}
+	for _, memory := range memories {
+		if time.Since(memory.Updated) > ttl {
+			if err := store.Delete(threadMemoryBucket, threadMemoryKey(memory.Channel, memory.ThreadTS)); err != nil {
+				log.Printf("Error expiring thread memory: %v", err)
+			}
+		}
+	}
+}
+
+func handleForget(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `/forget <thread link>`. Use *Copy link* on any message in the thread.")
+	}
+	match := permalinkPattern.FindStringSubmatch(req.Args[0])
+	if match == nil {
+		return ephemeral("That doesn't look like a message link. Use *Copy link* on a message in the thread.")
+	}
+	channel, threadTS := match[1], match[2]+"."+match[3]
+	if reply := permalinkThreadPattern.FindStringSubmatch(req.Args[0]); reply != nil {
+		threadTS = reply[1]
+	}
+
+	threadMemoryMu.Lock()
+	defer threadMemoryMu.Unlock()
+	key := threadMemoryKey(channel, threadTS)
+	found, err := store.Get(threadMemoryBucket, key, &threadMemory{})
+	if err != nil {
+		log.Printf("Error loading thread memory: %v", err)
+		return ephemeral("Sorry, something went wrong forgetting that thread.")
+	}
+	if !found {
+		return ephemeral("I don't remember anything from that thread.")
+	}
+	if err := store.Delete(threadMemoryBucket, key); err != nil {
+		log.Printf("Error deleting thread memory: %v", err)
+		return ephemeral("Sorry, something went wrong forgetting that thread.")
+	}
+	log.Printf("Thread memory for %s cleared by %s", key, req.UserID)
+	return ephemeral("Done, I've forgotten that thread. If I'm mentioned there again, I'll start fresh.")
+}
+
+func describeUserThreadMemory(userID string) (string, error) {
+	memories, err := storeList[threadMemory](store, threadMemoryBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, memory := range memories {
+		n := 0
+		for _, msg := range memory.Messages {
+			if msg.User == userID {
+				n++
+			}
+		}
+		if n > 0 {
+			items = append(items, fmt.Sprintf("%d messages in a thread in <#%s>", n, memory.Channel))
+		}
+	}
+	return describeItems(items), nil
+}
+
+// deleteUserThreadMemory forgets userID's messages; the bot's replies to
+// them stay
+func deleteUserThreadMemory(userID string) error {
+	threadMemoryMu.Lock()
+	defer threadMemoryMu.Unlock()
+	memories, err := storeList[threadMemory](store, threadMemoryBucket)
+	if err != nil {
+		return err
+	}
+	for _, memory := range memories {
+		kept := memory.Messages[:0]
+		for _, msg := range memory.Messages {
+			if msg.User != userID {
+				kept = append(kept, msg)
+			}
+		}
+		if len(kept) == len(memory.Messages) {
+			continue
+		}
+		memory.Messages = kept
+		if err := store.Put(threadMemoryBucket, threadMemoryKey(memory.Channel, memory.ThreadTS), memory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// How long a user's timezone from users.info is trusted, since people
+// travel, and how long a failed lookup is remembered
+const (
+	userTimezoneTTL       = time.Hour
+	userTimezoneRetryTime = 5 * time.Minute
+)
+
+type cachedLocation struct {
+	loc     *time.Location
+	err     error
+	fetched time.Time
+}
+
+// Timezones looked up with users.info, keyed by user ID
+var userLocations sync.Map
+
+// workspaceLocation returns send_windows.timezone, the timezone used for
+// channels and anything without one of its own, or UTC
+func workspaceLocation() *time.Location {
+	name := appConfig.SendWindows.Timezone
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Error loading send_windows.timezone: %v", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// userLocation returns the timezone set in userID's Slack profile
+func userLocation(userID string) (*time.Location, error) {
+	if value, ok := userLocations.Load(userID); ok {
+		cached := value.(cachedLocation)
+		if age := time.Since(cached.fetched); (cached.err == nil && age < userTimezoneTTL) || age < userTimezoneRetryTime {
+			return cached.loc, cached.err
+		}
+	}
+	loc, err := fetchUserLocation(userID)
+	userLocations.Store(userID, cachedLocation{loc: loc, err: err, fetched: time.Now()})
+	return loc, err
+}
+
+func fetchUserLocation(userID string) (*time.Location, error) {
+	user, err := slackClient.GetUserInfo(userID)
+	if err != nil {
+		return nil, fmt.Errorf("looking up user %s: %w", userID, err)
+	}
+	if user.TZ == "" {
+		return nil, fmt.Errorf("user %s has no timezone", userID)
+	}
+	loc, err := time.LoadLocation(user.TZ)
+	if err != nil {
+		return nil, fmt.Errorf("timezone of user %s: %w", userID, err)
+	}
+	return loc, nil
+}
+
+// userLocationOr returns userID's timezone, or fallback if it can't be
+// looked up
+func userLocationOr(userID string, fallback *time.Location) *time.Location {
+	loc, err := userLocation(userID)
+	if err != nil {
+		log.Printf("Error finding timezone, using %s: %v", fallback, err)
+		return fallback
+	}
+	return loc
+}
+
+// recipientLocation returns the timezone of whoever reads channel: the
+// user's own for a DM or user ID, otherwise the workspace's
+func recipientLocation(channel string) *time.Location {
+	userID := channel
+	if strings.HasPrefix(channel, "D") {
+		info, err := slackClient.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel})
+		if err != nil {
+			log.Printf("Error looking up DM %s: %v", channel, err)
+			return workspaceLocation()
+		}
+		userID = info.User
+	}
+	if !strings.HasPrefix(userID, "U") && !strings.HasPrefix(userID, "W") {
+		return workspaceLocation()
+	}
+	return userLocationOr(userID, workspaceLocation())
+}
+
+// parseTimezoneSuffix splits a trailing IANA timezone, like Europe/Paris or
+// UTC, off words
+func parseTimezoneSuffix(words []string) ([]string, *time.Location, bool) {
+	if len(words) == 0 {
+		return words, nil, false
+	}
+	last := words[len(words)-1]
+	if !strings.Contains(last, "/") && last != "UTC" {
+		return words, nil, false
+	}
+	loc, err := time.LoadLocation(last)
+	if err != nil {
+		return words, nil, false
+	}
+	return words[:len(words)-1], loc, true
+}
@@ -0,0 +1,299 @@
+// Command genclient generates the Go client package for the bot's HTTP API
+// from its OpenAPI document:
+//
+//	go run . openapi > openapi.json
+//	go run ./tools/genclient openapi.json > client/client.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// spec is the subset of OpenAPI 3 the bot's spec uses
+type spec struct {
+	Info       struct{ Version string }
+	Paths      map[string]map[string]operation
+	Components struct {
+		Schemas map[string]*schema
+	}
+}
+
+type operation struct {
+	OperationID string `json:"operationId"`
+	Summary     string
+	Description string
+	Parameters  []parameter
+	RequestBody *struct {
+		Content map[string]struct{ Schema *schema }
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]struct{ Schema *schema }
+	}
+}
+
+type parameter struct {
+	Name        string
+	In          string
+	Required    bool
+	Description string
+}
+
+type schema struct {
+	Ref                  string `json:"$ref"`
+	Type                 string
+	Format               string
+	Items                *schema
+	Properties           map[string]*schema
+	AdditionalProperties *schema `json:"additionalProperties"`
+	Required             []string
+}
+
+// Words kept upper case in Go identifiers
+var initialisms = map[string]bool{"id": true, "url": true, "api": true, "ts": true, "http": true, "json": true, "ip": true}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: genclient <openapi.json>")
+	}
+	raw, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var doc spec
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		log.Fatalf("parsing spec: %v", err)
+	}
+
+	var b bytes.Buffer
+	generate(&b, &doc)
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		os.Stdout.Write(b.Bytes())
+		log.Fatalf("formatting generated code: %v", err)
+	}
+	os.Stdout.Write(src)
+}
+
+func generate(out *bytes.Buffer, doc *spec) {
+	var body bytes.Buffer
+	b := &body
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeStruct(b, name, doc.Components.Schemas[name])
+	}
+
+	type op struct {
+		path, method string
+		operation
+	}
+	var ops []op
+	for path, methods := range doc.Paths {
+		for method, operation := range methods {
+			ops = append(ops, op{path, method, operation})
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].OperationID < ops[j].OperationID })
+	for _, o := range ops {
+		writeOperation(b, o.path, o.method, o.operation)
+	}
+
+	imports := []string{`"context"`}
+	if strings.Contains(body.String(), "url.") {
+		imports = append(imports, `"net/url"`)
+	}
+	if strings.Contains(body.String(), "time.") {
+		imports = append(imports, `"time"`)
+	}
+	fmt.Fprintf(out, `// Code generated by tools/genclient from the bot's OpenAPI spec (API version %s). DO NOT EDIT.
+
+package client
+
+import (
+	%s
+)
+`, doc.Info.Version, strings.Join(imports, "\n\t"))
+	out.Write(body.Bytes())
+}
+
+func writeStruct(b *bytes.Buffer, name string, s *schema) {
+	fmt.Fprintf(b, "\n// %s is a schema of the bot API.\ntype %s struct {\n", name, name)
+	props := make([]string, 0, len(s.Properties))
+	for prop := range s.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	for _, prop := range props {
+		tag := prop
+		if !contains(s.Required, prop) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(b, "\t%s %s `json:%q`\n", goName(prop), goType(s.Properties[prop]), tag)
+	}
+	b.WriteString("}\n")
+}
+
+func writeOperation(b *bytes.Buffer, path, method string, op operation) {
+	var pathParams, queryParams []parameter
+	for _, param := range op.Parameters {
+		if param.In == "path" {
+			pathParams = append(pathParams, param)
+		} else if param.In == "query" {
+			queryParams = append(queryParams, param)
+		}
+	}
+
+	args := []string{"ctx context.Context"}
+	for _, param := range pathParams {
+		args = append(args, lowerFirst(goName(param.Name))+" string")
+	}
+	if len(queryParams) > 0 {
+		paramsType := op.OperationID + "Params"
+		fmt.Fprintf(b, "\n// %s holds the query parameters of %s.\ntype %s struct {\n", paramsType, op.OperationID, paramsType)
+		for _, param := range queryParams {
+			if param.Description != "" {
+				fmt.Fprintf(b, "\t// %s\n", param.Description)
+			}
+			fmt.Fprintf(b, "\t%s string\n", goName(param.Name))
+		}
+		b.WriteString("}\n")
+		args = append(args, "params "+paramsType)
+	}
+	body := "nil"
+	if op.RequestBody != nil {
+		args = append(args, "body "+goType(op.RequestBody.Content["application/json"].Schema))
+		body = "body"
+	}
+
+	var result string
+	if resp, ok := op.Responses["200"]; ok {
+		if content, ok := resp.Content["application/json"]; ok && content.Schema != nil {
+			result = goType(content.Schema)
+		}
+	}
+
+	fmt.Fprintf(b, "\n// %s: %s\n//\n// %s\n", op.OperationID, op.Summary, op.Description)
+	returns := "error"
+	if result != "" {
+		returns = "(" + pointerType(result) + ", error)"
+	}
+	fmt.Fprintf(b, "func (c *Client) %s(%s) %s {\n", op.OperationID, strings.Join(args, ", "), returns)
+
+	pathExpr := fmt.Sprintf("%q", path)
+	for _, param := range pathParams {
+		pathExpr = strings.Replace(pathExpr, "{"+param.Name+"}",
+			`" + url.PathEscape(`+lowerFirst(goName(param.Name))+`) + "`, 1)
+	}
+	pathExpr = strings.TrimSuffix(strings.TrimPrefix(pathExpr, `"" + `), ` + ""`)
+	query := "nil"
+	if len(queryParams) > 0 {
+		query = "query"
+		b.WriteString("\tquery := url.Values{}\n")
+	}
+	for _, param := range queryParams {
+		fmt.Fprintf(b, "\tif params.%s != \"\" {\n\t\tquery.Set(%q, params.%s)\n\t}\n", goName(param.Name), param.Name, goName(param.Name))
+	}
+
+	if result == "" {
+		fmt.Fprintf(b, "\treturn c.do(ctx, %q, %s, %s, %s, nil)\n}\n", strings.ToUpper(method), pathExpr, query, body)
+		return
+	}
+	if strings.HasPrefix(result, "[]") || strings.HasPrefix(result, "map[") {
+		fmt.Fprintf(b, "\tvar out %s\n", result)
+		fmt.Fprintf(b, "\tif err := c.do(ctx, %q, %s, %s, %s, &out); err != nil {\n\t\treturn nil, err\n\t}\n\treturn out, nil\n}\n",
+			strings.ToUpper(method), pathExpr, query, body)
+		return
+	}
+	fmt.Fprintf(b, "\tout := new(%s)\n", result)
+	fmt.Fprintf(b, "\tif err := c.do(ctx, %q, %s, %s, %s, out); err != nil {\n\t\treturn nil, err\n\t}\n\treturn out, nil\n}\n",
+		strings.ToUpper(method), pathExpr, query, body)
+}
+
+// pointerType returns the type a generated method returns for result
+func pointerType(result string) string {
+	if strings.HasPrefix(result, "[]") || strings.HasPrefix(result, "map[") {
+		return result
+	}
+	return "*" + result
+}
+
+func goType(s *schema) string {
+	if s == nil {
+		return "any"
+	}
+	if s.Ref != "" {
+		return s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+	}
+	switch s.Type {
+	case "boolean":
+		return "bool"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "string":
+		switch s.Format {
+		case "date-time":
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "array":
+		return "[]" + goType(s.Items)
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + goType(s.AdditionalProperties)
+		}
+		return "map[string]any"
+	}
+	return "any"
+}
+
+// goName turns a snake_case JSON name into an exported Go identifier
+func goName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+// lowerFirst turns an exported identifier into an unexported one
+func lowerFirst(s string) string {
+	for word := range initialisms {
+		if upper := strings.ToUpper(word); strings.HasPrefix(s, upper) {
+			return word + s[len(upper):]
+		}
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
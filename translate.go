@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Characters sent for translation, counted for usage_report.quotas
+const quotaTranslateChars = "translate_chars"
+
+// translation is a translated message
+type translation struct {
+	Text string
+	// SourceLang is the detected language of the original, like "en"
+	SourceLang string
+}
+
+// translator translates text into another language
+type translator interface {
+	// Translate translates markup from markupForTranslation into lang, a
+	// language code like "fr" or "pt-BR"
+	Translate(markup, lang string) (translation, error)
+}
+
+// translatorFactories creates translators by the name used in config
+var translatorFactories = map[string]func(TranslateConfig) (translator, error){
+	"deepl":  newDeepLTranslator,
+	"google": newGoogleTranslator,
+}
+
+// The configured translator; nil when translate.provider isn't set
+var messageTranslator translator
+
+// Languages for flag reactions, keyed by the flag's country code as in
+// :flag-fr: or :fr:. translate.flags adds more.
+var flagLanguages = map[string]string{
+	"ar": "es", "at": "de", "be": "nl", "bg": "bg", "br": "pt-BR", "ch": "de",
+	"cn": "zh", "cz": "cs", "de": "de", "dk": "da", "ee": "et", "eg": "ar",
+	"es": "es", "fi": "fi", "fr": "fr", "gb": "en-GB", "gr": "el", "hu": "hu",
+	"id": "id", "it": "it", "jp": "ja", "kr": "ko", "lt": "lt", "lv": "lv",
+	"mx": "es", "nl": "nl", "no": "nb", "pl": "pl", "pt": "pt-PT", "ro": "ro",
+	"ru": "ru", "sa": "ar", "se": "sv", "si": "sl", "sk": "sk", "tr": "tr",
+	"tw": "zh-TW", "ua": "uk", "uk": "en-GB", "us": "en-US",
+}
+
+var (
+	// Messages already translated, keyed by channel, timestamp and
+	// language, so a second flag of the same kind doesn't post again
+	translatedMessages   = map[string]time.Time{}
+	translatedMessagesMu sync.Mutex
+)
+
+// setupTranslation builds the translator for translate.provider
+func setupTranslation(cfg TranslateConfig) error {
+	if cfg.Provider == "" {
+		return nil
+	}
+	factory, ok := translatorFactories[cfg.Provider]
+	if !ok {
+		return fmt.Errorf("unknown translate provider %q", cfg.Provider)
+	}
+	provider, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring translate provider: %w", err)
+	}
+	messageTranslator = provider
+	return nil
+}
+
+// flagLanguage returns the language for a flag reaction like flag-fr or jp
+func flagLanguage(reaction string) (string, bool) {
+	country := strings.TrimPrefix(reaction, "flag-")
+	if lang, ok := appConfig.Translate.Flags[country]; ok {
+		return lang, true
+	}
+	lang, ok := flagLanguages[country]
+	return lang, ok
+}
+
+// handleTranslateReaction posts a translation of the message in its thread
+// when someone reacts with a flag
+func handleTranslateReaction(ev *slackevents.ReactionAddedEvent) {
+	if messageTranslator == nil || ev.Item.Type != "message" || ev.User == botUserID || !featureEnabled(featureTranslate, ev.Item.Channel) {
+		return
+	}
+	lang, ok := flagLanguage(ev.Reaction)
+	if !ok {
+		return
+	}
+	key := ev.Item.Channel + "/" + ev.Item.Timestamp + "/" + lang
+	translatedMessagesMu.Lock()
+	for k, at := range translatedMessages {
+		if time.Since(at) > 24*time.Hour {
+			delete(translatedMessages, k)
+		}
+	}
+	_, done := translatedMessages[key]
+	translatedMessages[key] = time.Now()
+	translatedMessagesMu.Unlock()
+	if done {
+		return
+	}
+
+	// Translating takes longer than Slack waits for the event to be acked
+	go runJob("translate", func() {
+		if err := postTranslation(ev, lang); err != nil {
+			log.Printf("Error translating message: %v", err)
+			// Let the next flag try again
+			translatedMessagesMu.Lock()
+			delete(translatedMessages, key)
+			translatedMessagesMu.Unlock()
+		}
+	})
+}
+
+// postTranslation translates the reacted-to message into lang and posts it
+// in the message's thread
+func postTranslation(ev *slackevents.ReactionAddedEvent, lang string) error {
+	msg, err := fetchMessage(ev.Item.Channel, ev.Item.Timestamp)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(msg.Text) == "" {
+		return nil
+	}
+	recordQuotaUsage(quotaTranslateChars, len([]rune(msg.Text)))
+	result, err := messageTranslator.Translate(markupForTranslation(msg.Text), lang)
+	if err != nil {
+		return err
+	}
+	// Nothing to do for a message already in that language
+	if baseLanguage(result.SourceLang) == baseLanguage(lang) {
+		return nil
+	}
+	text := textFromTranslation(result.Text)
+
+	threadTS := msg.ThreadTimestamp
+	if threadTS == "" {
+		threadTS = msg.Timestamp
+	}
+	section, _ := truncateText(text, maxSectionText)
+	note := fmt.Sprintf(":%s: Translated from %s for <@%s>", ev.Reaction, strings.ToUpper(baseLanguage(result.SourceLang)), ev.User)
+	_, err = sendMessage(outboundMessage{
+		Channel:  ev.Item.Channel,
+		ThreadTS: threadTS,
+		Text:     text,
+		Blocks: []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, section, false, false), nil, nil),
+			slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, note, false, false)),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("posting translation: %w", err)
+	}
+	return nil
+}
+
+// baseLanguage returns the language without its region, like "pt" for
+// "pt-BR"
+func baseLanguage(lang string) string {
+	base, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	return base
+}
+
+var (
+	// Spans that keep Slack's <...> entities out of translation
+	keptEntityPattern = regexp.MustCompile(`<span translate="no" class="notranslate">(.*?)</span>`)
+	lineBreakPattern  = regexp.MustCompile(`<br\s*/?>`)
+)
+
+// markupForTranslation turns message text into HTML both providers
+// translate, leaving mentions, channels and links as they are
+func markupForTranslation(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range slackEntityPattern.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString(`<span translate="no" class="notranslate">` + html.EscapeString(text[m[0]:m[1]]) + `</span>`)
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return strings.ReplaceAll(b.String(), "\n", "<br>")
+}
+
+// textFromTranslation turns translated markup back into message text
+func textFromTranslation(markup string) string {
+	markup = keptEntityPattern.ReplaceAllString(markup, "$1")
+	markup = lineBreakPattern.ReplaceAllString(markup, "\n")
+	return html.UnescapeString(markup)
+}
+
+// translateAPIKey reads the provider's API key from cfg.APIKeyEnv or
+// defaultEnv
+func translateAPIKey(cfg TranslateConfig, defaultEnv string) (string, error) {
+	env := cfg.APIKeyEnv
+	if env == "" {
+		env = defaultEnv
+	}
+	key := os.Getenv(env)
+	if key == "" {
+		return "", fmt.Errorf("%s needs an API key in %s", cfg.Provider, env)
+	}
+	return key, nil
+}
+
+// postTranslateJSON sends a translation request and decodes the reply
+func postTranslateJSON(req *http.Request, out any) error {
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling translate API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("calling translate API: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("decoding translation: %w", err)
+	}
+	return nil
+}
+
+// deepLTranslator uses the DeepL API. Free plan keys end in :fx and use
+// DeepL's free endpoint.
+type deepLTranslator struct {
+	url, key string
+}
+
+func newDeepLTranslator(cfg TranslateConfig) (translator, error) {
+	key, err := translateAPIKey(cfg, "DEEPL_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	endpoint := "https://api.deepl.com/v2/translate"
+	if strings.HasSuffix(key, ":fx") {
+		endpoint = "https://api-free.deepl.com/v2/translate"
+	}
+	return &deepLTranslator{url: endpoint, key: key}, nil
+}
+
+func (t *deepLTranslator) Translate(markup, lang string) (translation, error) {
+	payload, err := json.Marshal(map[string]any{
+		"text":         []string{markup},
+		"target_lang":  strings.ToUpper(lang),
+		"tag_handling": "html",
+	})
+	if err != nil {
+		return translation{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(payload))
+	if err != nil {
+		return translation{}, err
+	}
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+t.key)
+	var out struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := postTranslateJSON(req, &out); err != nil {
+		return translation{}, err
+	}
+	if len(out.Translations) == 0 {
+		return translation{}, fmt.Errorf("DeepL returned no translation")
+	}
+	return translation{Text: out.Translations[0].Text, SourceLang: strings.ToLower(out.Translations[0].DetectedSourceLanguage)}, nil
+}
+
+// googleTranslator uses the Google Cloud Translation API (v2)
+type googleTranslator struct {
+	key string
+}
+
+func newGoogleTranslator(cfg TranslateConfig) (translator, error) {
+	key, err := translateAPIKey(cfg, "GOOGLE_TRANSLATE_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	return &googleTranslator{key: key}, nil
+}
+
+func (t *googleTranslator) Translate(markup, lang string) (translation, error) {
+	// Google names languages without a region, except Chinese
+	target := baseLanguage(lang)
+	if target == "zh" {
+		target = "zh-CN"
+		if strings.EqualFold(lang, "zh-TW") {
+			target = "zh-TW"
+		}
+	}
+	payload, err := json.Marshal(map[string]string{"q": markup, "target": target, "format": "html"})
+	if err != nil {
+		return translation{}, err
+	}
+	endpoint := "https://translation.googleapis.com/language/translate/v2?key=" + url.QueryEscape(t.key)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return translation{}, err
+	}
+	var out struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := postTranslateJSON(req, &out); err != nil {
+		return translation{}, err
+	}
+	if len(out.Data.Translations) == 0 {
+		return translation{}, fmt.Errorf("google returned no translation")
+	}
+	first := out.Data.Translations[0]
+	return translation{Text: first.TranslatedText, SourceLang: strings.ToLower(first.DetectedSourceLanguage)}, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Store bucket for triggers added at runtime, keyed by name. They override
+// config triggers with the same name.
+const triggersBucket = "triggers"
+
+// Matches a channel mention such as <#C123|general>
+var channelMentionPattern = regexp.MustCompile(`^<#([A-Z0-9]+)(?:\|[^>]*)?>$`)
+
+// Trigger is a keyword or regex that the bot answers with a templated response
+type Trigger struct {
+	Name string `yaml:"name" json:"name" table:"Name"`
+	// Exactly one of Keyword (a case-insensitive whole word or phrase) and
+	// Regex is set
+	Keyword string `yaml:"keyword" json:"keyword,omitempty" table:"Keyword"`
+	Regex   string `yaml:"regex" json:"regex,omitempty" table:"Regex"`
+	// Response is a text/template rendered with a triggerMatch
+	Response string `yaml:"response" json:"response" table:"Response"`
+	// Channels limits the trigger to these channel IDs; empty means all
+	Channels []string `yaml:"channels" json:"channels,omitempty"`
+	// Cooldown is the minimum time between responses in one channel
+	Cooldown time.Duration `yaml:"cooldown" json:"cooldown,omitempty" table:"Cooldown"`
+}
+
+// triggerMatch is the data available to trigger response templates
+type triggerMatch struct {
+	User    string
+	Channel string
+	Text    string
+	// Groups holds the whole match followed by the capture groups
+	Groups []string
+	// Named holds named capture groups
+	Named map[string]string
+}
+
+var (
+	// Compiled trigger patterns, keyed by pattern
+	triggerPatterns sync.Map
+	// Last response time, keyed by trigger name and channel
+	triggerCooldowns   = map[string]time.Time{}
+	triggerCooldownsMu sync.Mutex
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin trigger add",
+		Usage:       "admin trigger add <name> keyword|regex <pattern> => <response> [--channel=#channel] [--cooldown=10m]",
+		Description: "Answer messages matching a keyword or regex with a templated response",
+		AdminOnly:   true,
+		Handler:     handleTriggerAdd,
+	})
+	registerBotCommand(&command{
+		Name:        "admin trigger remove",
+		Usage:       "admin trigger remove <name>",
+		Description: "Remove a trigger added with trigger add",
+		AdminOnly:   true,
+		Handler:     handleTriggerRemove,
+	})
+	registerApplyKind(applyKind{Name: "triggers", Bucket: triggersBucket, Decode: decodeTriggerSpec})
+	registerBotCommand(&command{
+		Name:        "admin trigger list",
+		Usage:       "admin trigger list",
+		Description: "List message triggers",
+		AdminOnly:   true,
+		Handler:     handleTriggerList,
+	})
+}
+
+// loadTriggers returns config triggers merged with the ones added at runtime
+func loadTriggers() ([]Trigger, error) {
+	stored, err := storeList[Trigger](store, triggersBucket)
+	if err != nil {
+		return nil, err
+	}
+	triggers := slices.Clone(appConfig.Triggers)
+	for _, trigger := range stored {
+		if i := slices.IndexFunc(triggers, func(t Trigger) bool { return t.Name == trigger.Name }); i >= 0 {
+			triggers[i] = trigger
+		} else {
+			triggers = append(triggers, trigger)
+		}
+	}
+	return triggers, nil
+}
+
+// validate checks the trigger can match and render its response
+func (t Trigger) validate() error {
+	if t.Name == "" || t.Response == "" {
+		return fmt.Errorf("name and response are required")
+	}
+	if (t.Keyword == "") == (t.Regex == "") {
+		return fmt.Errorf("set exactly one of keyword and regex")
+	}
+	re, err := t.pattern()
+	if err != nil {
+		return err
+	}
+	// Try the template with as many groups as a match would have
+	sample := triggerMatch{Groups: make([]string, re.NumSubexp()+1), Named: map[string]string{}}
+	if _, err := renderTemplate("trigger "+t.Name, t.Response, sample); err != nil {
+		return err
+	}
+	return nil
+}
+
+// decodeTriggerSpec decodes a trigger from a POST /apply document, where the
+// cooldown is written like "10m"
+func decodeTriggerSpec(raw json.RawMessage) (string, any, error) {
+	var spec struct {
+		Trigger
+		Cooldown string `json:"cooldown"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return "", nil, err
+	}
+	trigger := spec.Trigger
+	if spec.Cooldown != "" {
+		cooldown, err := time.ParseDuration(spec.Cooldown)
+		if err != nil {
+			return "", nil, fmt.Errorf("cooldown: %w", err)
+		}
+		trigger.Cooldown = cooldown
+	}
+	if err := trigger.validate(); err != nil {
+		return "", nil, err
+	}
+	return trigger.Name, trigger, nil
+}
+
+// pattern returns the compiled regex the trigger matches with
+func (t Trigger) pattern() (*regexp.Regexp, error) {
+	source := t.Regex
+	if t.Keyword != "" {
+		source = `(?i)\b` + regexp.QuoteMeta(t.Keyword) + `\b`
+	}
+	if cached, ok := triggerPatterns.Load(source); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		return nil, fmt.Errorf("compiling trigger %s: %w", t.Name, err)
+	}
+	triggerPatterns.Store(source, re)
+	return re, nil
+}
+
+// handleTriggerMessage answers a plain channel message with every trigger it
+// matches
+func handleTriggerMessage(ev *slackevents.MessageEvent) {
+	if ev.User == "" || ev.User == botUserID || ev.BotID != "" || ev.Text == "" || !featureEnabled(featureTriggers, ev.Channel) {
+		return
+	}
+	triggers, err := loadTriggers()
+	if err != nil {
+		log.Printf("Error loading triggers: %v", err)
+		return
+	}
+	for _, trigger := range triggers {
+		if len(trigger.Channels) > 0 && !slices.Contains(trigger.Channels, ev.Channel) {
+			continue
+		}
+		re, err := trigger.pattern()
+		if err != nil {
+			log.Printf("Error matching trigger: %v", err)
+			continue
+		}
+		groups := re.FindStringSubmatch(ev.Text)
+		if groups == nil || !takeTriggerCooldown(trigger, ev.Channel) {
+			continue
+		}
+
+		match := triggerMatch{User: ev.User, Channel: ev.Channel, Text: ev.Text, Groups: groups, Named: map[string]string{}}
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				match.Named[name] = groups[i]
+			}
+		}
+		text, err := renderTemplate("trigger "+trigger.Name, trigger.Response, match)
+		if err != nil {
+			log.Printf("Error rendering trigger response: %v", err)
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if _, err := sendMessage(outboundMessage{Channel: ev.Channel, ThreadTS: ev.ThreadTimeStamp, Text: text}); err != nil {
+			log.Printf("Error posting trigger response: %v", err)
+		}
+	}
+}
+
+// matchesTrigger reports whether a trigger answers text in channel
+func matchesTrigger(channel, text string) bool {
+	triggers, err := loadTriggers()
+	if err != nil {
+		log.Printf("Error loading triggers: %v", err)
+		return false
+	}
+	return slices.ContainsFunc(triggers, func(trigger Trigger) bool {
+		if len(trigger.Channels) > 0 && !slices.Contains(trigger.Channels, channel) {
+			return false
+		}
+		re, err := trigger.pattern()
+		return err == nil && re.MatchString(text)
+	})
+}
+
+// takeTriggerCooldown reports whether trigger may respond in channel now,
+// starting a new cooldown if so
+func takeTriggerCooldown(trigger Trigger, channel string) bool {
+	if trigger.Cooldown <= 0 {
+		return true
+	}
+	key := trigger.Name + "/" + channel
+	triggerCooldownsMu.Lock()
+	defer triggerCooldownsMu.Unlock()
+	if last, ok := triggerCooldowns[key]; ok && time.Since(last) < trigger.Cooldown {
+		return false
+	}
+	triggerCooldowns[key] = time.Now()
+	return true
+}
+
+func handleTriggerAdd(req commandRequest) commandResponse {
+	usage := ephemeral("Usage: `%s admin trigger add <name> keyword|regex <pattern> => <response> [--channel=#channel] [--cooldown=10m]`", botCommand)
+	var words []string
+	trigger := Trigger{}
+	for _, word := range req.Args {
+		switch {
+		case strings.HasPrefix(word, "--channel="):
+			match := channelMentionPattern.FindStringSubmatch(strings.TrimPrefix(word, "--channel="))
+			if match == nil {
+				return ephemeral("`--channel` needs a channel mention like #general.")
+			}
+			trigger.Channels = append(trigger.Channels, match[1])
+		case strings.HasPrefix(word, "--cooldown="):
+			cooldown, err := time.ParseDuration(strings.TrimPrefix(word, "--cooldown="))
+			if err != nil || cooldown < 0 {
+				return ephemeral("`--cooldown` needs a duration like `10m`.")
+			}
+			trigger.Cooldown = cooldown
+		default:
+			words = append(words, word)
+		}
+	}
+	if len(words) < 3 {
+		return usage
+	}
+	trigger.Name = words[0]
+	pattern, response, ok := strings.Cut(strings.Join(words[2:], " "), "=>")
+	pattern, response = strings.TrimSpace(pattern), strings.TrimSpace(response)
+	if !ok || pattern == "" || response == "" {
+		return usage
+	}
+	switch strings.ToLower(words[1]) {
+	case "keyword":
+		trigger.Keyword = pattern
+	case "regex":
+		trigger.Regex = pattern
+	default:
+		return usage
+	}
+	trigger.Response = response
+
+	if err := trigger.validate(); err != nil {
+		return ephemeral("That trigger doesn't work: %v", err)
+	}
+	if err := store.Put(triggersBucket, trigger.Name, trigger); err != nil {
+		log.Printf("Error saving trigger: %v", err)
+		return ephemeral("Sorry, something went wrong saving the trigger.")
+	}
+	return ephemeral("Saved trigger *%s*.", trigger.Name)
+}
+
+func handleTriggerRemove(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s admin trigger remove <name>`", botCommand)
+	}
+	name := req.Args[0]
+	found, err := store.Get(triggersBucket, name, &Trigger{})
+	if err != nil {
+		log.Printf("Error loading trigger: %v", err)
+		return ephemeral("Sorry, something went wrong loading the trigger.")
+	}
+	if !found {
+		if slices.ContainsFunc(appConfig.Triggers, func(t Trigger) bool { return t.Name == name }) {
+			return ephemeral("*%s* is defined in the config file; remove it there.", name)
+		}
+		return ephemeral("There is no trigger called *%s*.", name)
+	}
+	if err := store.Delete(triggersBucket, name); err != nil {
+		log.Printf("Error deleting trigger: %v", err)
+		return ephemeral("Sorry, something went wrong removing the trigger.")
+	}
+	return ephemeral("Removed trigger *%s*.", name)
+}
+
+func handleTriggerList(req commandRequest) commandResponse {
+	triggers, err := loadTriggers()
+	if err != nil {
+		log.Printf("Error loading triggers: %v", err)
+		return ephemeral("Sorry, something went wrong loading the triggers.")
+	}
+	if len(triggers) == 0 {
+		return ephemeral("There are no triggers yet.")
+	}
+	table, err := renderTable(triggers, tableOptions{Columns: []string{"Name", "Keyword", "Regex", "Response", "Cooldown"}, SortBy: "Name", MaxWidth: 40})
+	if err != nil {
+		log.Printf("Error rendering triggers: %v", err)
+		return ephemeral("Sorry, something went wrong listing the triggers.")
+	}
+	return ephemeral("%s", table)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store buckets for trivia rounds in progress, keyed by channel ID, and for
+// all-time scores, keyed by user ID
+const (
+	triviaRoundsBucket = "trivia_rounds"
+	triviaScoresBucket = "trivia_scores"
+)
+
+// Action ID of the answer buttons
+const actionTriviaAnswer = "trivia_answer"
+
+// Used when the trivia settings aren't set
+const (
+	defaultTriviaQuestions = 5
+	defaultTriviaTimeLimit = 20 * time.Second
+)
+
+// How many people the all-time leaderboard shows
+const triviaLeaderboardSize = 10
+
+// Slack shows up to this many buttons in an actions block
+const maxTriviaChoices = 5
+
+// triviaRound is a game of trivia being played in a channel
+type triviaRound struct {
+	Channel   string           `json:"channel"`
+	StartedBy string           `json:"started_by"`
+	Questions []triviaQuestion `json:"questions"`
+	// Current is the index of the question being asked
+	Current int `json:"current"`
+	// Scores are this round's points, keyed by user ID
+	Scores    map[string]int `json:"scores"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// triviaQuestion is a question as asked, its choices shuffled
+type triviaQuestion struct {
+	Question string   `json:"question"`
+	Choices  []string `json:"choices"`
+	Correct  int      `json:"correct"`
+	TS       string   `json:"ts,omitempty"`
+	Closed   bool     `json:"closed,omitempty"`
+	// Answers are the chosen indexes, keyed by user ID
+	Answers map[string]int `json:"answers"`
+}
+
+// triviaScore is a user's all-time trivia record
+type triviaScore struct {
+	User   string `json:"user"`
+	Points int    `json:"points"`
+	Rounds int    `json:"rounds"`
+	Wins   int    `json:"wins"`
+}
+
+// Serializes updates to rounds and scores
+var triviaMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/trivia",
+		Usage:       "/trivia start [questions] | stop | leaderboard",
+		Description: "Play a round of timed multiple-choice trivia in the channel",
+		Handler:     handleTrivia,
+	})
+	registerBlockAction(actionTriviaAnswer, handleTriviaAnswer)
+	registerUserDataset(userDataset{Name: "trivia", Title: "Trivia scores", Describe: describeUserTrivia, Delete: deleteUserTrivia})
+}
+
+func triviaTimeLimit() time.Duration {
+	if appConfig.Trivia.TimeLimit > 0 {
+		return appConfig.Trivia.TimeLimit
+	}
+	return defaultTriviaTimeLimit
+}
+
+func handleTrivia(req commandRequest) commandResponse {
+	sub := ""
+	if len(req.Args) > 0 {
+		sub = strings.ToLower(req.Args[0])
+	}
+	switch sub {
+	case "start":
+		return handleTriviaStart(req)
+	case "stop":
+		return handleTriviaStop(req)
+	case "leaderboard", "top":
+		return triviaLeaderboard()
+	}
+	return ephemeral("Usage: `%s start [questions] | stop | leaderboard`", req.Command)
+}
+
+func handleTriviaStart(req commandRequest) commandResponse {
+	pool := appConfig.Trivia.Questions
+	if len(pool) == 0 {
+		return ephemeral("Trivia has no questions yet. Add some under `trivia.questions` in the config.")
+	}
+	count := appConfig.Trivia.PerRound
+	if count <= 0 {
+		count = defaultTriviaQuestions
+	}
+	if len(req.Args) > 1 {
+		n, err := strconv.Atoi(req.Args[1])
+		if err != nil || n <= 0 {
+			return ephemeral("Usage: `%s start [questions]`", req.Command)
+		}
+		count = n
+	}
+	count = min(count, len(pool))
+
+	round := &triviaRound{Channel: req.ChannelID, StartedBy: req.UserID, Scores: map[string]int{}, UpdatedAt: time.Now().UTC()}
+	for _, i := range rand.Perm(len(pool))[:count] {
+		q := pool[i]
+		choices := append([]string{q.Answer}, q.Wrong...)
+		choices = choices[:min(len(choices), maxTriviaChoices)]
+		order := rand.Perm(len(choices))
+		asked := triviaQuestion{Question: q.Question, Answers: map[string]int{}}
+		for j, k := range order {
+			asked.Choices = append(asked.Choices, choices[k])
+			if k == 0 {
+				asked.Correct = j
+			}
+		}
+		round.Questions = append(round.Questions, asked)
+	}
+
+	triviaMu.Lock()
+	existing, err := loadTriviaRound(req.ChannelID)
+	// A round that hasn't moved in a while was cut short by a restart
+	busy := existing != nil && time.Since(existing.UpdatedAt) < triviaTimeLimit()+time.Minute
+	if err == nil && !busy {
+		err = store.Put(triviaRoundsBucket, req.ChannelID, round)
+	}
+	triviaMu.Unlock()
+	if err != nil {
+		log.Printf("Error saving trivia round: %v", err)
+		return ephemeral("Sorry, something went wrong starting trivia.")
+	}
+	if busy {
+		return ephemeral("There's already a round of trivia going here. `%s stop` ends it.", req.Command)
+	}
+
+	go runJob("trivia", func() { playTriviaRound(req.ChannelID) })
+	return commandResponse{
+		Text: fmt.Sprintf(":brain: <@%s> started a round of trivia: %s, %s each. Get ready!",
+			req.UserID, plural(count, "question"), plural(int(triviaTimeLimit().Seconds()), "second")),
+		InChannel: true,
+	}
+}
+
+// handleTriviaStop ends the round, if the caller started it or is an admin
+func handleTriviaStop(req commandRequest) commandResponse {
+	triviaMu.Lock()
+	defer triviaMu.Unlock()
+	round, err := loadTriviaRound(req.ChannelID)
+	if err != nil {
+		log.Printf("Error loading trivia round: %v", err)
+		return ephemeral("Sorry, something went wrong stopping trivia.")
+	}
+	if round == nil {
+		return ephemeral("There's no trivia going on here.")
+	}
+	if req.UserID != round.StartedBy && !isAdmin(req.UserID) {
+		return ephemeral("Only <@%s>, who started this round, or a bot admin can stop it.", round.StartedBy)
+	}
+	if err := store.Delete(triviaRoundsBucket, req.ChannelID); err != nil {
+		log.Printf("Error removing trivia round: %v", err)
+		return ephemeral("Sorry, something went wrong stopping trivia.")
+	}
+	return commandResponse{Text: fmt.Sprintf(":octagonal_sign: <@%s> stopped the trivia. No scores were recorded.", req.UserID), InChannel: true}
+}
+
+func loadTriviaRound(channel string) (*triviaRound, error) {
+	round := &triviaRound{}
+	found, err := store.Get(triviaRoundsBucket, channel, round)
+	if err != nil || !found {
+		return nil, err
+	}
+	if round.Scores == nil {
+		round.Scores = map[string]int{}
+	}
+	return round, nil
+}
+
+// updateTriviaRound applies fn to the channel's round and saves it. It
+// returns false once the round has been stopped.
+func updateTriviaRound(channel string, fn func(round *triviaRound)) (*triviaRound, bool) {
+	triviaMu.Lock()
+	defer triviaMu.Unlock()
+	round, err := loadTriviaRound(channel)
+	if err != nil {
+		log.Printf("Error loading trivia round: %v", err)
+		return nil, false
+	}
+	if round == nil {
+		return nil, false
+	}
+	fn(round)
+	round.UpdatedAt = time.Now().UTC()
+	if err := store.Put(triviaRoundsBucket, channel, round); err != nil {
+		log.Printf("Error saving trivia round: %v", err)
+		return nil, false
+	}
+	return round, true
+}
+
+// playTriviaRound asks each question in turn, reveals its answer when time
+// is up, then posts the scores
+func playTriviaRound(channel string) {
+	round, ok := updateTriviaRound(channel, func(*triviaRound) {})
+	if !ok {
+		return
+	}
+	for i := range round.Questions {
+		round, ok = updateTriviaRound(channel, func(r *triviaRound) { r.Current = i })
+		if !ok {
+			return
+		}
+		q := round.Questions[i]
+		ts, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceInfo, NeedTS: true, Text: "Trivia: " + q.Question, Blocks: triviaQuestionBlocks(round, i)})
+		if err != nil {
+			log.Printf("Error asking trivia question: %v", err)
+			return
+		}
+		if _, ok = updateTriviaRound(channel, func(r *triviaRound) { r.Questions[i].TS = ts }); !ok {
+			return
+		}
+
+		time.Sleep(triviaTimeLimit())
+
+		round, ok = updateTriviaRound(channel, func(r *triviaRound) {
+			q := &r.Questions[i]
+			q.Closed = true
+			for user, choice := range q.Answers {
+				if choice == q.Correct {
+					r.Scores[user]++
+				}
+			}
+		})
+		if !ok {
+			return
+		}
+		_, _, _, err = slackClient.UpdateMessage(channel, ts,
+			slack.MsgOptionText("Trivia: "+q.Question, false), slack.MsgOptionBlocks(triviaQuestionBlocks(round, i)...))
+		if err != nil {
+			log.Printf("Error revealing trivia answer: %v", err)
+		}
+	}
+
+	triviaMu.Lock()
+	round, err := loadTriviaRound(channel)
+	if err == nil && round != nil {
+		err = store.Delete(triviaRoundsBucket, channel)
+		if err == nil {
+			err = recordTriviaScores(round)
+		}
+	}
+	triviaMu.Unlock()
+	if err != nil {
+		log.Printf("Error finishing trivia round: %v", err)
+		return
+	}
+	if round == nil {
+		return
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceInfo, Text: triviaRoundSummary(round)}); err != nil {
+		log.Printf("Error posting trivia scores: %v", err)
+	}
+}
+
+// triviaPlayers lists everyone who answered in the round, best first
+func triviaPlayers(round *triviaRound) []string {
+	players := map[string]bool{}
+	for _, q := range round.Questions {
+		for user := range q.Answers {
+			players[user] = true
+		}
+	}
+	var users []string
+	for user := range players {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b string) int {
+		return cmp.Or(round.Scores[b]-round.Scores[a], strings.Compare(a, b))
+	})
+	return users
+}
+
+// recordTriviaScores adds the round to everyone's all-time record. The
+// caller holds triviaMu.
+func recordTriviaScores(round *triviaRound) error {
+	players := triviaPlayers(round)
+	best := 0
+	if len(players) > 0 {
+		best = round.Scores[players[0]]
+	}
+	for _, user := range players {
+		score := triviaScore{User: user}
+		if _, err := store.Get(triviaScoresBucket, user, &score); err != nil {
+			return err
+		}
+		score.Points += round.Scores[user]
+		score.Rounds++
+		if best > 0 && round.Scores[user] == best {
+			score.Wins++
+		}
+		if err := store.Put(triviaScoresBucket, user, score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// triviaQuestionBlocks shows question i with answer buttons while it's
+// open, and the right answer and who got it once it's closed
+func triviaQuestionBlocks(round *triviaRound, i int) []slack.Block {
+	q := round.Questions[i]
+	title := fmt.Sprintf(":brain: *Question %d of %d*\n%s", i+1, len(round.Questions), q.Question)
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, title, false, false), nil, nil)}
+	if !q.Closed {
+		var buttons []slack.BlockElement
+		for j, choice := range q.Choices {
+			label, _ := truncateText(choice, maxButtonText)
+			buttons = append(buttons, slack.NewButtonBlockElement(actionTriviaAnswer, fmt.Sprintf("%s:%d:%d", round.Channel, i, j),
+				slack.NewTextBlockObject(slack.PlainTextType, label, false, false)))
+		}
+		return append(blocks,
+			slack.NewActionBlock("", buttons...),
+			slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("You have %d seconds. One answer each!", int(triviaTimeLimit().Seconds())), false, false)),
+		)
+	}
+
+	var right []string
+	for user, choice := range q.Answers {
+		if choice == q.Correct {
+			right = append(right, user)
+		}
+	}
+	slices.Sort(right)
+	result := fmt.Sprintf(":white_check_mark: The answer was *%s*.", q.Choices[q.Correct])
+	switch {
+	case len(q.Answers) == 0:
+		result += " Nobody answered."
+	case len(right) == 0:
+		result += " Nobody got it."
+	default:
+		result += " Well done " + mentionList(right) + "!"
+	}
+	return append(blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, result, false, false), nil, nil))
+}
+
+// triviaRoundSummary is the round's scores followed by the all-time
+// leaderboard
+func triviaRoundSummary(round *triviaRound) string {
+	players := triviaPlayers(round)
+	if len(players) == 0 {
+		return ":brain: Trivia's over. Nobody played this time!"
+	}
+	lines := []string{":checkered_flag: *Trivia scores*"}
+	for i, user := range players {
+		lines = append(lines, fmt.Sprintf("%d. <@%s> %d/%d", i+1, user, round.Scores[user], len(round.Questions)))
+	}
+	if board := triviaLeaderboardLines(); len(board) > 0 {
+		lines = append(lines, "", ":trophy: *All-time leaderboard*")
+		lines = append(lines, board...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func triviaLeaderboard() commandResponse {
+	lines := triviaLeaderboardLines()
+	if len(lines) == 0 {
+		return ephemeral("Nobody has played trivia yet. Start a round with `/trivia start`.")
+	}
+	return commandResponse{Text: ":trophy: *Trivia leaderboard*\n" + strings.Join(lines, "\n"), InChannel: true}
+}
+
+// triviaLeaderboardLines ranks the top players by all-time points
+func triviaLeaderboardLines() []string {
+	scores, err := storeList[triviaScore](store, triviaScoresBucket)
+	if err != nil {
+		log.Printf("Error loading trivia scores: %v", err)
+		return nil
+	}
+	slices.SortStableFunc(scores, func(a, b triviaScore) int {
+		return cmp.Or(b.Points-a.Points, b.Wins-a.Wins, strings.Compare(a.User, b.User))
+	})
+	var lines []string
+	for i, s := range scores[:min(len(scores), triviaLeaderboardSize)] {
+		lines = append(lines, fmt.Sprintf("%d. <@%s> %s, %s in %s", i+1, s.User, plural(s.Points, "point"), plural(s.Wins, "win"), plural(s.Rounds, "round")))
+	}
+	return lines
+}
+
+// handleTriviaAnswer locks in the clicker's answer to the open question
+func handleTriviaAnswer(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	parts := strings.Split(action.Value, ":")
+	if len(parts) != 3 {
+		log.Printf("Invalid trivia answer value %q", action.Value)
+		return
+	}
+	index, err1 := strconv.Atoi(parts[1])
+	choice, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil {
+		log.Printf("Invalid trivia answer value %q", action.Value)
+		return
+	}
+
+	var reply string
+	triviaMu.Lock()
+	round, err := loadTriviaRound(parts[0])
+	switch {
+	case err != nil:
+	case round == nil || index != round.Current || index >= len(round.Questions) || round.Questions[index].Closed:
+		reply = "Too late, that question is closed."
+	case choice < 0 || choice >= len(round.Questions[index].Choices):
+	default:
+		q := &round.Questions[index]
+		if previous, answered := q.Answers[callback.User.ID]; answered {
+			reply = fmt.Sprintf("You already answered *%s*.", q.Choices[previous])
+			break
+		}
+		q.Answers[callback.User.ID] = choice
+		err = store.Put(triviaRoundsBucket, round.Channel, round)
+		reply = fmt.Sprintf("Locked in *%s*. Answers are revealed when time's up.", q.Choices[choice])
+	}
+	triviaMu.Unlock()
+	if err != nil {
+		log.Printf("Error recording trivia answer: %v", err)
+		return
+	}
+	if reply != "" {
+		pollReply(callback, reply)
+	}
+}
+
+func describeUserTrivia(userID string) (string, error) {
+	var score triviaScore
+	found, err := store.Get(triviaScoresBucket, userID, &score)
+	if err != nil || !found {
+		return "", err
+	}
+	return fmt.Sprintf("%s and %s over %s.", plural(score.Points, "point"), plural(score.Wins, "win"), plural(score.Rounds, "round")), nil
+}
+
+func deleteUserTrivia(userID string) error {
+	triviaMu.Lock()
+	defer triviaMu.Unlock()
+	return store.Delete(triviaScoresBucket, userID)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"golang.org/x/net/html"
+)
+
+// Upper bound on how much of a linked resource is read when building a preview
+const maxUnfurlBody = 1 << 20
+
+// unfurlPreview is the content a provider extracts for a shared link
+type unfurlPreview struct {
+	Title    string
+	Text     string
+	ImageURL string
+	Fields   map[string]string
+}
+
+// unfurlProvider builds previews for links on the domains it is configured for
+type unfurlProvider interface {
+	// Unfurl returns the preview for link, or nil if the link should be left
+	// for Slack to unfurl normally
+	Unfurl(link *url.URL) (*unfurlPreview, error)
+}
+
+// unfurlProviderFactories creates providers by the name used in config
+var unfurlProviderFactories = map[string]func(UnfurlDomainConfig) (unfurlProvider, error){
+	"page_meta": func(UnfurlDomainConfig) (unfurlProvider, error) { return pageMetaProvider{}, nil },
+	"json_api":  newJSONAPIProvider,
+}
+
+// Providers for each configured domain, built at startup
+var unfurlProviders = map[string]unfurlProvider{}
+
+// setupUnfurlProviders builds the provider for every configured domain
+func setupUnfurlProviders(cfg UnfurlConfig) error {
+	for domain, domainCfg := range cfg.Domains {
+		factory, ok := unfurlProviderFactories[domainCfg.Provider]
+		if !ok {
+			return fmt.Errorf("unknown unfurl provider %q for %s", domainCfg.Provider, domain)
+		}
+		provider, err := factory(domainCfg)
+		if err != nil {
+			return fmt.Errorf("configuring unfurl provider for %s: %w", domain, err)
+		}
+		unfurlProviders[strings.ToLower(domain)] = provider
+	}
+	return nil
+}
+
+// providerForDomain finds the provider for domain or one of its parent domains
+func providerForDomain(domain string) unfurlProvider {
+	domain = strings.ToLower(domain)
+	for domain != "" {
+		if provider, ok := unfurlProviders[domain]; ok {
+			return provider
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return nil
+}
+
+// handleLinkShared replies to link_shared with previews for configured
+// domains. The previews are fetched in the background, since a slow site
+// would hold the ack past the few seconds Slack waits.
+func handleLinkShared(ev *slackevents.LinkSharedEvent) {
+	if !featureEnabled(featureUnfurl, ev.Channel) {
+		return
+	}
+	links := map[string]unfurlProvider{}
+	for _, shared := range ev.Links {
+		if provider := providerForDomain(shared.Domain); provider != nil {
+			links[shared.URL] = provider
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	go runJob("unfurl", func() { unfurlLinks(ev.Channel, ev.MessageTimeStamp, links) })
+}
+
+// unfurlLinks builds each link's preview with its provider and attaches
+// them to the message
+func unfurlLinks(channel, ts string, links map[string]unfurlProvider) {
+	unfurls := map[string]slack.Attachment{}
+	for shared, provider := range links {
+		link, err := url.Parse(shared)
+		if err != nil {
+			log.Printf("Error parsing shared link %q: %v", shared, err)
+			continue
+		}
+		preview, err := provider.Unfurl(link)
+		if err != nil {
+			log.Printf("Error unfurling %s: %v", shared, err)
+			continue
+		}
+		if preview != nil {
+			unfurls[shared] = unfurlAttachment(shared, preview)
+		}
+	}
+	if len(unfurls) == 0 {
+		return
+	}
+
+	if _, _, _, err := slackClient.UnfurlMessage(channel, ts, unfurls); err != nil {
+		log.Printf("Error unfurling links in %s: %v", channel, err)
+	}
+}
+
+// unfurlAttachment renders preview as Block Kit for chat.unfurl
+func unfurlAttachment(link string, preview *unfurlPreview) slack.Attachment {
+	title := preview.Title
+	if title == "" {
+		title = link
+	}
+	text := fmt.Sprintf("*<%s|%s>*", link, title)
+	if preview.Text != "" {
+		text += "\n" + preview.Text
+	}
+
+	var accessory *slack.Accessory
+	if preview.ImageURL != "" {
+		accessory = slack.NewAccessory(slack.NewImageBlockElement(preview.ImageURL, title))
+	}
+	var fields []*slack.TextBlockObject
+	for _, name := range slices.Sorted(maps.Keys(preview.Fields)) {
+		fields = append(fields, slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*%s*\n%s", name, preview.Fields[name]), false, false))
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, accessory),
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, slack.NewSectionBlock(nil, fields, nil))
+	}
+	for i, block := range blocks {
+		fitted, _ := fitBlock(block)
+		blocks[i] = fitted[0]
+	}
+	return slack.Attachment{Blocks: slack.Blocks{BlockSet: blocks}}
+}
+
+// pageMetaProvider previews a page from its <title> and Open Graph tags,
+// which covers most wikis and documentation sites
+type pageMetaProvider struct{}
+
+func (pageMetaProvider) Unfurl(link *url.URL) (*unfurlPreview, error) {
+	resp, err := httpClient.Get(link.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching page: %s", resp.Status)
+	}
+
+	preview := &unfurlPreview{}
+	tokenizer := html.NewTokenizer(io.LimitReader(resp.Body, maxUnfurlBody))
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return preview, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "meta":
+				applyMetaTag(preview, token.Attr)
+			case "body":
+				return preview, nil
+			}
+		case html.TextToken:
+			if inTitle && preview.Title == "" {
+				preview.Title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			inTitle = false
+		}
+	}
+}
+
+// applyMetaTag copies Open Graph and description meta tags into preview
+func applyMetaTag(preview *unfurlPreview, attrs []html.Attribute) {
+	var name, content string
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "property", "name":
+			name = attr.Val
+		case "content":
+			content = attr.Val
+		}
+	}
+	switch name {
+	case "og:title":
+		preview.Title = content
+	case "og:description":
+		preview.Text = content
+	case "description":
+		if preview.Text == "" {
+			preview.Text = content
+		}
+	case "og:image":
+		preview.ImageURL = content
+	}
+}
+
+// jsonAPIProvider looks the link up in a JSON API, such as an issue tracker,
+// and renders the response through the configured templates
+type jsonAPIProvider struct {
+	cfg UnfurlDomainConfig
+}
+
+// jsonAPILink is the data available to the api_url template
+type jsonAPILink struct {
+	Link     string
+	Path     string
+	Segments []string
+	Query    url.Values
+}
+
+// jsonAPIResult is the data available to the title, text and field templates
+type jsonAPIResult struct {
+	Link string
+	Data any
+}
+
+func newJSONAPIProvider(cfg UnfurlDomainConfig) (unfurlProvider, error) {
+	if cfg.APIURL == "" {
+		return nil, fmt.Errorf("json_api provider needs api_url")
+	}
+	return &jsonAPIProvider{cfg: cfg}, nil
+}
+
+func (p *jsonAPIProvider) Unfurl(link *url.URL) (*unfurlPreview, error) {
+	apiURL, err := renderTemplate("unfurl api_url", p.cfg.APIURL, jsonAPILink{
+		Link:     link.String(),
+		Path:     strings.Trim(link.Path, "/"),
+		Segments: strings.Split(strings.Trim(link.Path, "/"), "/"),
+		Query:    link.Query(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", apiURL, resp.Status)
+	}
+
+	result := jsonAPIResult{Link: link.String()}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxUnfurlBody)).Decode(&result.Data); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", apiURL, err)
+	}
+
+	preview := &unfurlPreview{Fields: map[string]string{}}
+	if preview.Title, err = renderTemplate("unfurl title", p.cfg.Title, result); err != nil {
+		return nil, err
+	}
+	if preview.Text, err = renderTemplate("unfurl text", p.cfg.Text, result); err != nil {
+		return nil, err
+	}
+	for name, tmpl := range p.cfg.Fields {
+		value, err := renderTemplate("unfurl field", tmpl, result)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			preview.Fields[name] = value
+		}
+	}
+	return preview, nil
+}
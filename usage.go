@@ -0,0 +1,648 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Store buckets for usage counts, keyed by UTC date (2006-01-02), and for
+// the months whose report has been sent, keyed by 2006-01
+const (
+	usageBucket        = "usage"
+	usageReportsBucket = "usage_reports"
+)
+
+// Quotas whose consumption is counted, and can be budgeted with
+// usage_report.quotas
+const (
+	quotaAPIRequests = "api_requests"
+	quotaAPILimited  = "api_requests_limited"
+	quotaLLMCalls    = "llm_calls"
+	quotaLLMTokens   = "llm_tokens"
+)
+
+// Prefix of the replies handlers give when something went wrong on our
+// side; commands answering with it count as errors
+const commandErrorPrefix = "Sorry, something went wrong"
+
+// usageDay is the bot's usage on one day
+type usageDay struct {
+	Date string `json:"date"`
+	// Users counts the commands and button clicks of each user
+	Users         map[string]int `json:"users,omitempty"`
+	Commands      map[string]int `json:"commands,omitempty"`
+	CommandErrors map[string]int `json:"command_errors,omitempty"`
+	Features      map[string]int `json:"features,omitempty"`
+	Requests      int            `json:"requests,omitempty"`
+	ServerErrors  int            `json:"server_errors,omitempty"`
+	Quotas        map[string]int `json:"quotas,omitempty"`
+}
+
+// usageReport summarizes a month of usage, as sent to admins and returned
+// by GET /api/v1/admin/usage
+type usageReport struct {
+	Month string `json:"month"`
+	// ActiveUsers ran at least one command or clicked one button
+	ActiveUsers         int              `json:"active_users"`
+	PreviousActiveUsers int              `json:"previous_active_users"`
+	Days                []usageReportDay `json:"days"`
+	Commands            []usageCount     `json:"commands"`
+	// Features compares each feature with the month before
+	Features        []usageCount `json:"features"`
+	CommandErrors   int          `json:"command_errors"`
+	CommandErrorPct float64      `json:"command_error_pct"`
+	Requests        int          `json:"requests"`
+	ServerErrors    int          `json:"server_errors"`
+	ServerErrorPct  float64      `json:"server_error_pct"`
+	Quotas          []usageQuota `json:"quotas"`
+}
+
+// usageReportDay is one day's totals in a usage report
+type usageReportDay struct {
+	Date          string `json:"date"`
+	ActiveUsers   int    `json:"active_users"`
+	Commands      int    `json:"commands"`
+	CommandErrors int    `json:"command_errors"`
+}
+
+// usageCount is how often a command or feature was used
+type usageCount struct {
+	Name     string `json:"name"`
+	Count    int    `json:"count"`
+	Errors   int    `json:"errors,omitempty"`
+	Previous int    `json:"previous,omitempty"`
+}
+
+// usageQuota is how much of a quota was consumed, against its monthly
+// budget when one is configured
+type usageQuota struct {
+	Name   string `json:"name"`
+	Used   int    `json:"used"`
+	Budget int    `json:"budget,omitempty"`
+}
+
+var (
+	// Counts not yet written to the store, keyed by date
+	pendingUsage   = map[string]*usageDay{}
+	pendingUsageMu sync.Mutex
+	// Serializes rewrites of stored days
+	usageStoreMu sync.Mutex
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin usage",
+		Usage:       "admin usage [YYYY-MM]",
+		Description: "Show how the bot is being used this month, or in another month",
+		AdminOnly:   true,
+		Handler:     handleUsageCommand,
+	})
+	registerAPIRoute(apiRoute{
+		Method:      http.MethodGet,
+		Path:        "/admin/usage",
+		Scope:       scopeAdmin,
+		OperationID: "GetUsageReport",
+		Summary:     "Summarize bot usage for a month",
+		Query:       []apiParam{{Name: "month", Description: "Month as YYYY-MM, in UTC; defaults to the current month"}},
+		Response:    usageReport{},
+		Handler:     handleAPIUsageReport,
+	})
+	registerUserDataset(userDataset{Name: "usage", Title: "Usage statistics", Describe: describeUserUsage, Delete: deleteUserUsage})
+}
+
+// startUsageTracking writes usage counts to the store every minute and
+// sends the monthly report once a month is over
+func startUsageTracking() {
+	runEvery("usage", time.Minute, flushUsage)
+	if appConfig.UsageReport.Enabled {
+		runEvery("usage report", time.Hour, sendDueUsageReport)
+	}
+}
+
+// countUsage updates today's pending counts
+func countUsage(update func(*usageDay)) {
+	date := time.Now().UTC().Format(time.DateOnly)
+	pendingUsageMu.Lock()
+	defer pendingUsageMu.Unlock()
+	day, ok := pendingUsage[date]
+	if !ok {
+		day = &usageDay{Date: date}
+		pendingUsage[date] = day
+	}
+	update(day)
+}
+
+// recordCommandUsage counts a run of the command name by userID
+func recordCommandUsage(name, userID string, failed bool) {
+	countUsage(func(day *usageDay) {
+		day.Users = addCount(day.Users, userID, 1)
+		day.Commands = addCount(day.Commands, name, 1)
+		day.Features = addCount(day.Features, commandFeature(name), 1)
+		if failed {
+			day.CommandErrors = addCount(day.CommandErrors, name, 1)
+		}
+	})
+}
+
+// recordActionUsage counts a button click or menu choice; the feature is
+// the action ID up to its first underscore, e.g. standup for standup_open
+func recordActionUsage(actionID, userID string) {
+	feature, _, _ := strings.Cut(actionID, "_")
+	countUsage(func(day *usageDay) {
+		day.Users = addCount(day.Users, userID, 1)
+		day.Features = addCount(day.Features, feature, 1)
+	})
+}
+
+// recordQuotaUsage counts n units of quota
+func recordQuotaUsage(quota string, n int) {
+	countUsage(func(day *usageDay) { day.Quotas = addCount(day.Quotas, quota, n) })
+}
+
+// countRequests counts HTTP requests, server errors, and API calls against
+// the rate limit
+func countRequests(c *gin.Context) {
+	c.Next()
+	status := c.Writer.Status()
+	api := strings.HasPrefix(c.Request.URL.Path, "/api/")
+	countUsage(func(day *usageDay) {
+		day.Requests++
+		if status >= http.StatusInternalServerError {
+			day.ServerErrors++
+		}
+		switch {
+		case api && status == http.StatusTooManyRequests:
+			day.Quotas = addCount(day.Quotas, quotaAPILimited, 1)
+		case api:
+			day.Quotas = addCount(day.Quotas, quotaAPIRequests, 1)
+		}
+	})
+}
+
+// commandFeature names the feature a command belongs to: its first word,
+// or the second for admin commands
+func commandFeature(name string) string {
+	words := strings.Fields(strings.TrimPrefix(name, "/"))
+	if len(words) > 1 && words[0] == "admin" {
+		return words[1]
+	}
+	if len(words) == 0 {
+		return name
+	}
+	return words[0]
+}
+
+func addCount(counts map[string]int, key string, n int) map[string]int {
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	counts[key] += n
+	return counts
+}
+
+// flushUsage adds the pending counts to the stored ones
+func flushUsage() {
+	usageStoreMu.Lock()
+	defer usageStoreMu.Unlock()
+	pendingUsageMu.Lock()
+	pending := pendingUsage
+	pendingUsage = map[string]*usageDay{}
+	pendingUsageMu.Unlock()
+
+	for date, counts := range pending {
+		var day usageDay
+		if _, err := store.Get(usageBucket, date, &day); err != nil {
+			log.Printf("Error loading usage for %s: %v", date, err)
+			continue
+		}
+		day.Date = date
+		day.merge(counts)
+		if err := store.Put(usageBucket, date, day); err != nil {
+			log.Printf("Error saving usage for %s: %v", date, err)
+		}
+	}
+}
+
+func (d *usageDay) merge(other *usageDay) {
+	for user, n := range other.Users {
+		d.Users = addCount(d.Users, user, n)
+	}
+	for name, n := range other.Commands {
+		d.Commands = addCount(d.Commands, name, n)
+	}
+	for name, n := range other.CommandErrors {
+		d.CommandErrors = addCount(d.CommandErrors, name, n)
+	}
+	for name, n := range other.Features {
+		d.Features = addCount(d.Features, name, n)
+	}
+	for name, n := range other.Quotas {
+		d.Quotas = addCount(d.Quotas, name, n)
+	}
+	d.Requests += other.Requests
+	d.ServerErrors += other.ServerErrors
+}
+
+// usageDays returns the stored days of month (2006-01), including counts
+// not yet flushed
+func usageDays(month string) ([]usageDay, error) {
+	var days []usageDay
+	for _, date := range store.Keys(usageBucket) {
+		if !strings.HasPrefix(date, month+"-") {
+			continue
+		}
+		var day usageDay
+		if _, err := store.Get(usageBucket, date, &day); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	pendingUsageMu.Lock()
+	defer pendingUsageMu.Unlock()
+	for date, counts := range pendingUsage {
+		if !strings.HasPrefix(date, month+"-") {
+			continue
+		}
+		i := slices.IndexFunc(days, func(d usageDay) bool { return d.Date == date })
+		if i < 0 {
+			days = append(days, usageDay{Date: date})
+			i = len(days) - 1
+		}
+		days[i].merge(counts)
+	}
+	slices.SortFunc(days, func(a, b usageDay) int { return strings.Compare(a.Date, b.Date) })
+	return days, nil
+}
+
+// buildUsageReport summarizes month (2006-01)
+func buildUsageReport(month string) (usageReport, error) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return usageReport{}, fmt.Errorf("month %q should look like 2006-01", month)
+	}
+	days, err := usageDays(month)
+	if err != nil {
+		return usageReport{}, err
+	}
+	previousDays, err := usageDays(start.AddDate(0, -1, 0).Format("2006-01"))
+	if err != nil {
+		return usageReport{}, err
+	}
+
+	report := usageReport{Month: month}
+	users, commands, failures, features, quotas := map[string]bool{}, map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+	for _, day := range days {
+		row := usageReportDay{Date: day.Date, ActiveUsers: len(day.Users)}
+		for user := range day.Users {
+			users[user] = true
+		}
+		for name, n := range day.Commands {
+			commands[name] += n
+			row.Commands += n
+		}
+		for name, n := range day.CommandErrors {
+			failures[name] += n
+			row.CommandErrors += n
+		}
+		for name, n := range day.Features {
+			features[name] += n
+		}
+		for name, n := range day.Quotas {
+			quotas[name] += n
+		}
+		report.Requests += day.Requests
+		report.ServerErrors += day.ServerErrors
+		report.CommandErrors += row.CommandErrors
+		report.Days = append(report.Days, row)
+	}
+	report.ActiveUsers = len(users)
+
+	previousUsers, previousFeatures := map[string]bool{}, map[string]int{}
+	for _, day := range previousDays {
+		for user := range day.Users {
+			previousUsers[user] = true
+		}
+		for name, n := range day.Features {
+			previousFeatures[name] += n
+		}
+	}
+	report.PreviousActiveUsers = len(previousUsers)
+
+	total := 0
+	for name, n := range commands {
+		total += n
+		report.Commands = append(report.Commands, usageCount{Name: name, Count: n, Errors: failures[name]})
+	}
+	sortUsageCounts(report.Commands)
+	for name := range previousFeatures {
+		if _, ok := features[name]; !ok {
+			features[name] = 0
+		}
+	}
+	for name, n := range features {
+		report.Features = append(report.Features, usageCount{Name: name, Count: n, Previous: previousFeatures[name]})
+	}
+	sortUsageCounts(report.Features)
+	if total > 0 {
+		report.CommandErrorPct = 100 * float64(report.CommandErrors) / float64(total)
+	}
+	if report.Requests > 0 {
+		report.ServerErrorPct = 100 * float64(report.ServerErrors) / float64(report.Requests)
+	}
+
+	budgets := appConfig.UsageReport.Quotas
+	for _, name := range slices.Sorted(maps.Keys(quotas)) {
+		report.Quotas = append(report.Quotas, usageQuota{Name: name, Used: quotas[name], Budget: budgets[name]})
+	}
+	for _, name := range slices.Sorted(maps.Keys(budgets)) {
+		if _, ok := quotas[name]; !ok {
+			report.Quotas = append(report.Quotas, usageQuota{Name: name, Budget: budgets[name]})
+		}
+	}
+	return report, nil
+}
+
+// sortUsageCounts puts the most used first
+func sortUsageCounts(counts []usageCount) {
+	slices.SortFunc(counts, func(a, b usageCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// summary renders the report as message text
+func (r usageReport) summary() string {
+	lines := []string{
+		fmt.Sprintf("*Bot usage in %s*", r.monthName()),
+		fmt.Sprintf("• *Active users:* %d%s", r.ActiveUsers, usageTrend(r.ActiveUsers, r.PreviousActiveUsers)),
+		fmt.Sprintf("• *Commands:* %d, %d failed (%.1f%%)", r.totalCommands(), r.CommandErrors, r.CommandErrorPct),
+		fmt.Sprintf("• *HTTP requests:* %d, %d server errors (%.2f%%)", r.Requests, r.ServerErrors, r.ServerErrorPct),
+	}
+	if len(r.Commands) > 0 {
+		lines = append(lines, "", "*Top commands*")
+		for _, c := range r.Commands[:min(5, len(r.Commands))] {
+			line := fmt.Sprintf("• `%s`: %d", c.Name, c.Count)
+			if c.Errors > 0 {
+				line += fmt.Sprintf(" (%d failed)", c.Errors)
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(r.Features) > 0 {
+		lines = append(lines, "", "*Features*")
+		for _, f := range r.Features[:min(8, len(r.Features))] {
+			lines = append(lines, fmt.Sprintf("• %s: %d%s", f.Name, f.Count, usageTrend(f.Count, f.Previous)))
+		}
+	}
+	if len(r.Quotas) > 0 {
+		lines = append(lines, "", "*Quotas*")
+		for _, q := range r.Quotas {
+			line := fmt.Sprintf("• %s: %d", q.Name, q.Used)
+			if q.Budget > 0 {
+				line += fmt.Sprintf(" of %d (%.0f%%)", q.Budget, 100*float64(q.Used)/float64(q.Budget))
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r usageReport) monthName() string {
+	start, err := time.Parse("2006-01", r.Month)
+	if err != nil {
+		return r.Month
+	}
+	return start.Format("January 2006")
+}
+
+func (r usageReport) totalCommands() int {
+	total := 0
+	for _, c := range r.Commands {
+		total += c.Count
+	}
+	return total
+}
+
+// usageTrend describes the change from previous, e.g. " (up 12%)"
+func usageTrend(current, previous int) string {
+	switch {
+	case previous == 0 && current == 0:
+		return ""
+	case previous == 0:
+		return " (new)"
+	case current == previous:
+		return " (no change)"
+	case current > previous:
+		return fmt.Sprintf(" (up %.0f%%)", 100*float64(current-previous)/float64(previous))
+	default:
+		return fmt.Sprintf(" (down %.0f%%)", 100*float64(previous-current)/float64(previous))
+	}
+}
+
+// charts returns the report's charts: daily activity, top commands, and
+// the most used features week by week
+func (r usageReport) charts() []chartSpec {
+	var specs []chartSpec
+	// Line charts need at least two points
+	if len(r.Days) > 1 {
+		activity := chartSpec{Kind: chartLine, Title: "Daily activity, " + r.monthName(), Series: []chartSeries{{Name: "Active users"}, {Name: "Commands"}}}
+		for _, day := range r.Days {
+			activity.Labels = append(activity.Labels, strings.TrimPrefix(day.Date[8:], "0"))
+			activity.Series[0].Values = append(activity.Series[0].Values, float64(day.ActiveUsers))
+			activity.Series[1].Values = append(activity.Series[1].Values, float64(day.Commands))
+		}
+		specs = append(specs, activity)
+	}
+	if len(r.Commands) > 0 {
+		top := chartSpec{Kind: chartBar, Title: "Top commands, " + r.monthName(), Series: []chartSeries{{}}}
+		for _, c := range r.Commands[:min(8, len(r.Commands))] {
+			top.Labels = append(top.Labels, c.Name)
+			top.Series[0].Values = append(top.Series[0].Values, float64(c.Count))
+		}
+		specs = append(specs, top)
+	}
+	if weekly, ok := r.featureWeeks(); ok {
+		specs = append(specs, weekly)
+	}
+	return specs
+}
+
+// featureWeeks charts the five most used features over the weeks of the
+// month
+func (r usageReport) featureWeeks() (chartSpec, bool) {
+	days, err := usageDays(r.Month)
+	if err != nil || len(days) == 0 || len(r.Features) == 0 || r.Features[0].Count == 0 {
+		return chartSpec{}, false
+	}
+	spec := chartSpec{Kind: chartLine, Title: "Feature use by week, " + r.monthName(), Labels: []string{"1-7", "8-14", "15-21", "22-28", "29+"}}
+	for _, f := range r.Features[:min(5, len(r.Features))] {
+		series := chartSeries{Name: f.Name, Values: make([]float64, len(spec.Labels))}
+		for _, day := range days {
+			var dom int
+			fmt.Sscanf(day.Date[8:], "%d", &dom)
+			series.Values[min((dom-1)/7, len(spec.Labels)-1)] += float64(day.Features[f.Name])
+		}
+		spec.Series = append(spec.Series, series)
+	}
+	return spec, true
+}
+
+// sendDueUsageReport sends last month's report to the admins once the
+// month is over
+func sendDueUsageReport() {
+	month := time.Now().UTC().AddDate(0, 0, -time.Now().UTC().Day()).Format("2006-01")
+	var sent bool
+	if _, err := store.Get(usageReportsBucket, month, &sent); err != nil || sent {
+		return
+	}
+	report, err := buildUsageReport(month)
+	if err != nil {
+		log.Printf("Error building usage report: %v", err)
+		return
+	}
+	if report.Requests > 0 || len(report.Days) > 0 {
+		if err := sendUsageReport(report); err != nil {
+			log.Printf("Error sending usage report: %v", err)
+			return
+		}
+	}
+	if err := store.Put(usageReportsBucket, month, true); err != nil {
+		log.Printf("Error recording usage report: %v", err)
+	}
+}
+
+// sendUsageReport posts report with its charts to usage_report.channel, or
+// to each bot admin directly
+func sendUsageReport(report usageReport) error {
+	msg := outboundMessage{Text: report.summary(), Importance: importanceNotice}
+	var channels []string
+	if channel := appConfig.UsageReport.Channel; channel != "" {
+		msg.Channel = channel
+		if _, err := sendInWindow("usage_report", msg); err != nil {
+			return err
+		}
+		channels = append(channels, channel)
+	} else {
+		if len(appConfig.Admins) == 0 {
+			return fmt.Errorf("no usage_report.channel and no admins to send it to")
+		}
+		for _, admin := range appConfig.Admins {
+			if _, err := sendDM("usage_report", admin, msg); err != nil {
+				log.Printf("Error sending usage report to %s: %v", admin, err)
+				continue
+			}
+			channel, err := openDM(admin)
+			if err != nil {
+				log.Printf("Error opening DM with %s: %v", admin, err)
+				continue
+			}
+			channels = append(channels, channel)
+		}
+	}
+	for _, channel := range channels {
+		for _, spec := range report.charts() {
+			if err := uploadChart(channel, "", spec); err != nil {
+				log.Printf("Error uploading usage chart: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func handleUsageCommand(req commandRequest) commandResponse {
+	month := time.Now().UTC().Format("2006-01")
+	if len(req.Args) > 0 {
+		month = req.Args[0]
+	}
+	if _, err := time.Parse("2006-01", month); err != nil || len(req.Args) > 1 {
+		return ephemeral("Usage: `%s admin usage [YYYY-MM]`", botCommand)
+	}
+	report, err := buildUsageReport(month)
+	if err != nil {
+		log.Printf("Error building usage report: %v", err)
+		return ephemeral("Sorry, something went wrong building the usage report.")
+	}
+	return ephemeral("%s", report.summary())
+}
+
+func handleAPIUsageReport(c *gin.Context) {
+	month := c.DefaultQuery("month", time.Now().UTC().Format("2006-01"))
+	if _, err := time.Parse("2006-01", month); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month should look like 2006-01"})
+		return
+	}
+	report, err := buildUsageReport(month)
+	if err != nil {
+		log.Printf("Error building usage report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if report.Days == nil {
+		report.Days = []usageReportDay{}
+	}
+	if report.Commands == nil {
+		report.Commands = []usageCount{}
+	}
+	if report.Features == nil {
+		report.Features = []usageCount{}
+	}
+	if report.Quotas == nil {
+		report.Quotas = []usageQuota{}
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func describeUserUsage(userID string) (string, error) {
+	days := 0
+	for _, date := range store.Keys(usageBucket) {
+		var day usageDay
+		if _, err := store.Get(usageBucket, date, &day); err != nil {
+			return "", err
+		}
+		if day.Users[userID] > 0 {
+			days++
+		}
+	}
+	if days == 0 {
+		return "", nil
+	}
+	unit := "days"
+	if days == 1 {
+		unit = "day"
+	}
+	return fmt.Sprintf("How many commands you ran and buttons you clicked, on %d %s, for the admins' monthly usage report", days, unit), nil
+}
+
+func deleteUserUsage(userID string) error {
+	usageStoreMu.Lock()
+	defer usageStoreMu.Unlock()
+	pendingUsageMu.Lock()
+	for _, day := range pendingUsage {
+		delete(day.Users, userID)
+	}
+	pendingUsageMu.Unlock()
+	for _, date := range store.Keys(usageBucket) {
+		var day usageDay
+		if _, err := store.Get(usageBucket, date, &day); err != nil {
+			return err
+		}
+		if _, ok := day.Users[userID]; !ok {
+			continue
+		}
+		delete(day.Users, userID)
+		if err := store.Put(usageBucket, date, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// How often usergroups are synced when usergroups.sync.interval is unset
+const defaultUsergroupSyncInterval = time.Hour
+
+// Action IDs of the buttons on join requests
+const (
+	actionUsergroupApprove = "usergroup_join_approve"
+	actionUsergroupDeny    = "usergroup_join_deny"
+)
+
+// Matches a usergroup mention such as <!subteam^S123|@oncall>
+var usergroupMentionPattern = regexp.MustCompile(`^<!subteam\^([A-Z0-9]+)(?:\|[^>]*)?>$`)
+
+// usergroupRow is a usergroup as listed by /bot groups
+type usergroupRow struct {
+	Handle  string `table:"Handle"`
+	Name    string `table:"Name"`
+	Members int    `table:"Members"`
+	Synced  string `table:"Synced from"`
+}
+
+// usergroupSyncResult describes what a sync changed in one usergroup
+type usergroupSyncResult struct {
+	Handle           string
+	Added, Removed   []string
+	UnknownEmails    []string
+	Unchanged, Empty bool
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "groups",
+		Usage:       "groups",
+		Description: "List the workspace's usergroups",
+		Handler:     handleUsergroupList,
+	})
+	registerBotCommand(&command{
+		Name:        "groups join",
+		Usage:       "groups join @group",
+		Description: "Ask to be added to a usergroup",
+		Handler:     handleUsergroupJoin,
+	})
+	registerBotCommand(&command{
+		Name:        "admin groups sync",
+		Usage:       "admin groups sync [@group]",
+		Description: "Sync usergroup membership from the external directory now",
+		AdminOnly:   true,
+		Handler:     handleUsergroupSyncCommand,
+	})
+	registerBlockAction(actionUsergroupApprove, handleUsergroupJoinDecision)
+	registerBlockAction(actionUsergroupDeny, handleUsergroupJoinDecision)
+}
+
+// startUsergroupSync syncs the configured usergroups on an interval
+func startUsergroupSync() {
+	cfg := appConfig.Usergroups.Sync
+	if cfg.URL == "" || len(cfg.Groups) == 0 {
+		return
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultUsergroupSyncInterval
+	}
+	runEvery("usergroup sync", interval, func() {
+		if _, err := syncUsergroups(""); err != nil {
+			log.Printf("Error syncing usergroups: %v", err)
+		}
+	})
+}
+
+// handleSubteamCreated announces a new usergroup
+func handleSubteamCreated(ev *slackevents.SubteamCreatedEvent) {
+	g := ev.Subteam
+	announceUsergroup(fmt.Sprintf(":busts_in_silhouette: <@%s> created the usergroup <!subteam^%s> (%s)", g.CreatedBy, g.ID, g.Name), g)
+}
+
+// handleSubteamUpdated announces changes to a usergroup
+func handleSubteamUpdated(ev *slackevents.SubteamUpdatedEvent) {
+	g := ev.Subteam
+	if g.UpdatedBy == botUserID {
+		return
+	}
+	verb := "updated"
+	if g.DateDelete != 0 {
+		verb = "disabled"
+	}
+	announceUsergroup(fmt.Sprintf(":busts_in_silhouette: <@%s> %s the usergroup <!subteam^%s> (%s)", g.UpdatedBy, verb, g.ID, g.Name), g)
+}
+
+// announceUsergroup posts a usergroup change to usergroups.channel
+func announceUsergroup(text string, g slackevents.SubTeam) {
+	channel := appConfig.Usergroups.Channel
+	if channel == "" {
+		return
+	}
+	if g.Description != "" {
+		text += "\n>" + quoteText(g.Description)
+	}
+	if source, ok := appConfig.Usergroups.Sync.Groups[g.Handle]; ok {
+		text += fmt.Sprintf("\n_Membership is synced from %s; manual changes are undone by the next sync._", source)
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceNotice, Text: text}); err != nil {
+		log.Printf("Error announcing usergroup change: %v", err)
+	}
+}
+
+func handleUsergroupList(req commandRequest) commandResponse {
+	groups, err := slackClient.GetUserGroups(slack.GetUserGroupsOptionIncludeCount(true))
+	if err != nil {
+		log.Printf("Error listing usergroups: %v", err)
+		return ephemeral("Sorry, something went wrong listing the usergroups.")
+	}
+	if len(groups) == 0 {
+		return ephemeral("There are no usergroups yet.")
+	}
+	rows := make([]usergroupRow, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, usergroupRow{
+			Handle:  "@" + g.Handle,
+			Name:    g.Name,
+			Members: g.UserCount,
+			Synced:  appConfig.Usergroups.Sync.Groups[g.Handle],
+		})
+	}
+	table, err := renderTable(rows, tableOptions{SortBy: "Handle", MaxWidth: 40})
+	if err != nil {
+		log.Printf("Error rendering usergroups: %v", err)
+		return ephemeral("Sorry, something went wrong listing the usergroups.")
+	}
+	return ephemeral("%s", table)
+}
+
+func handleUsergroupJoin(req commandRequest) commandResponse {
+	if len(req.Args) != 1 {
+		return ephemeral("Usage: `%s groups join @group`", botCommand)
+	}
+	group, err := findUsergroup(req.Args[0])
+	if err != nil {
+		log.Printf("Error looking up usergroup: %v", err)
+		return ephemeral("Sorry, something went wrong looking up that usergroup.")
+	}
+	if group == nil {
+		return ephemeral("I couldn't find the usergroup %s.", req.Args[0])
+	}
+	if slices.Contains(group.Users, req.UserID) {
+		return ephemeral("You're already in <!subteam^%s>.", group.ID)
+	}
+	if source, ok := appConfig.Usergroups.Sync.Groups[group.Handle]; ok {
+		return ephemeral("Membership of <!subteam^%s> comes from %s, so ask for the change there.", group.ID, source)
+	}
+	channel := appConfig.Usergroups.RequestsChannel
+	if channel == "" {
+		return ephemeral("Join requests aren't set up; ask a bot admin to add you to <!subteam^%s>.", group.ID)
+	}
+
+	text := fmt.Sprintf("<@%s> asked to join <!subteam^%s> (%s)", req.UserID, group.ID, group.Name)
+	value := group.ID + ":" + req.UserID
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, ":raising_hand: "+text, false, false), nil, nil),
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(actionUsergroupApprove, value, slack.NewTextBlockObject(slack.PlainTextType, "Approve", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(actionUsergroupDeny, value, slack.NewTextBlockObject(slack.PlainTextType, "Deny", false, false)),
+		),
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Text: text, Blocks: blocks}); err != nil {
+		log.Printf("Error posting usergroup join request: %v", err)
+		return ephemeral("Sorry, something went wrong sending your request.")
+	}
+	return ephemeral("I've asked for you to be added to <!subteam^%s>.", group.ID)
+}
+
+// handleUsergroupJoinDecision adds the requester when an admin approves
+func handleUsergroupJoinDecision(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	groupID, userID, _ := strings.Cut(action.Value, ":")
+	if !isAdmin(callback.User.ID) {
+		err := sendEphemeral(callback.User.ID, outboundMessage{
+			Channel: callback.Channel.ID,
+			Text:    "Sorry, only bot admins can approve usergroup requests.",
+		})
+		if err != nil {
+			log.Printf("Error replying to usergroup decision: %v", err)
+		}
+		return
+	}
+
+	result := fmt.Sprintf(":no_entry: <@%s> denied <@%s>'s request to join <!subteam^%s>", callback.User.ID, userID, groupID)
+	if action.ActionID == actionUsergroupApprove {
+		if err := addUsergroupMember(groupID, userID); err != nil {
+			log.Printf("Error adding %s to usergroup %s: %v", userID, groupID, err)
+			result = fmt.Sprintf(":warning: Adding <@%s> to <!subteam^%s> failed: %v", userID, groupID, err)
+		} else {
+			result = fmt.Sprintf(":white_check_mark: <@%s> added <@%s> to <!subteam^%s>", callback.User.ID, userID, groupID)
+		}
+	}
+	reply := &slack.WebhookMessage{ReplaceOriginal: true, Text: result}
+	if err := respond(callback.ResponseURL, reply); err != nil {
+		log.Printf("Error updating usergroup request: %v", err)
+	}
+}
+
+func addUsergroupMember(groupID, userID string) error {
+	members, err := slackClient.GetUserGroupMembers(groupID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(members, userID) {
+		return nil
+	}
+	_, err = slackClient.UpdateUserGroupMembers(groupID, strings.Join(append(members, userID), ","))
+	return err
+}
+
+// findUsergroup looks up a usergroup by mention, handle or @handle,
+// returning nil if there's no such group
+func findUsergroup(arg string) (*slack.UserGroup, error) {
+	var id string
+	if m := usergroupMentionPattern.FindStringSubmatch(arg); m != nil {
+		id = m[1]
+	}
+	handle := strings.TrimPrefix(arg, "@")
+	groups, err := slackClient.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+	if err != nil {
+		return nil, err
+	}
+	for i, g := range groups {
+		if g.ID == id || strings.EqualFold(g.Handle, handle) {
+			return &groups[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func handleUsergroupSyncCommand(req commandRequest) commandResponse {
+	if appConfig.Usergroups.Sync.URL == "" || len(appConfig.Usergroups.Sync.Groups) == 0 {
+		return ephemeral("No usergroups are set up to sync; see usergroups.sync in the config.")
+	}
+	handle := ""
+	if len(req.Args) == 1 {
+		group, err := findUsergroup(req.Args[0])
+		if err != nil {
+			log.Printf("Error looking up usergroup: %v", err)
+			return ephemeral("Sorry, something went wrong looking up that usergroup.")
+		}
+		if group == nil {
+			return ephemeral("I couldn't find the usergroup %s.", req.Args[0])
+		}
+		handle = group.Handle
+		if _, ok := appConfig.Usergroups.Sync.Groups[handle]; !ok {
+			return ephemeral("<!subteam^%s> isn't synced from the directory.", group.ID)
+		}
+	}
+
+	results, err := syncUsergroups(handle)
+	if err != nil {
+		log.Printf("Error syncing usergroups: %v", err)
+		return ephemeral("Sorry, syncing failed: %v", err)
+	}
+	var lines []string
+	for _, r := range results {
+		switch {
+		case r.Empty:
+			lines = append(lines, fmt.Sprintf("• @%s: the directory returned nobody, so I left it alone", r.Handle))
+		case r.Unchanged:
+			lines = append(lines, fmt.Sprintf("• @%s: already up to date", r.Handle))
+		default:
+			lines = append(lines, fmt.Sprintf("• @%s: %d added, %d removed", r.Handle, len(r.Added), len(r.Removed)))
+		}
+		if len(r.UnknownEmails) > 0 {
+			lines = append(lines, fmt.Sprintf("    no Slack account for %s", strings.Join(r.UnknownEmails, ", ")))
+		}
+	}
+	return ephemeral("Synced usergroups:\n%s", strings.Join(lines, "\n"))
+}
+
+// syncUsergroups makes the configured usergroups (or just the one with
+// handle) match the external directory
+func syncUsergroups(handle string) ([]usergroupSyncResult, error) {
+	cfg := appConfig.Usergroups.Sync
+	groups, err := slackClient.GetUserGroups(slack.GetUserGroupsOptionIncludeUsers(true))
+	if err != nil {
+		return nil, fmt.Errorf("listing usergroups: %w", err)
+	}
+	users, err := slackClient.GetUsers()
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	byEmail := map[string]string{}
+	for _, u := range users {
+		if u.Profile.Email != "" && !u.Deleted {
+			byEmail[strings.ToLower(u.Profile.Email)] = u.ID
+		}
+	}
+
+	handles := make([]string, 0, len(cfg.Groups))
+	for h := range cfg.Groups {
+		if handle == "" || h == handle {
+			handles = append(handles, h)
+		}
+	}
+	sort.Strings(handles)
+
+	var results []usergroupSyncResult
+	for _, h := range handles {
+		idx := slices.IndexFunc(groups, func(g slack.UserGroup) bool { return g.Handle == h })
+		if idx < 0 {
+			log.Printf("Usergroup @%s in usergroups.sync doesn't exist", h)
+			continue
+		}
+		result, err := syncUsergroup(groups[idx], cfg.Groups[h], byEmail)
+		if err != nil {
+			return results, fmt.Errorf("syncing @%s: %w", h, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func syncUsergroup(group slack.UserGroup, source string, byEmail map[string]string) (usergroupSyncResult, error) {
+	result := usergroupSyncResult{Handle: group.Handle}
+	emails, err := fetchDirectoryGroup(group.Handle, source)
+	if err != nil {
+		return result, err
+	}
+
+	var want []string
+	for _, email := range emails {
+		id, ok := byEmail[strings.ToLower(email)]
+		if !ok {
+			result.UnknownEmails = append(result.UnknownEmails, email)
+			continue
+		}
+		if !slices.Contains(want, id) {
+			want = append(want, id)
+		}
+	}
+	// An empty answer is more likely a directory problem than a real change,
+	// and Slack can't empty a usergroup anyway
+	if len(want) == 0 {
+		result.Empty = true
+		return result, nil
+	}
+	for _, id := range want {
+		if !slices.Contains(group.Users, id) {
+			result.Added = append(result.Added, id)
+		}
+	}
+	for _, id := range group.Users {
+		if !slices.Contains(want, id) {
+			result.Removed = append(result.Removed, id)
+		}
+	}
+	if len(result.Added) == 0 && len(result.Removed) == 0 {
+		result.Unchanged = true
+		return result, nil
+	}
+	if _, err := slackClient.UpdateUserGroupMembers(group.ID, strings.Join(want, ",")); err != nil {
+		return result, fmt.Errorf("updating members: %w", err)
+	}
+	log.Printf("Synced usergroup @%s: %d added, %d removed", group.Handle, len(result.Added), len(result.Removed))
+	return result, nil
+}
+
+// fetchDirectoryGroup asks the external directory for the email addresses
+// of the members of source
+func fetchDirectoryGroup(handle, source string) ([]string, error) {
+	cfg := appConfig.Usergroups.Sync
+	url, err := renderTemplate("usergroups sync url", cfg.URL, map[string]string{"Handle": handle, "Source": source})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching directory group: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching directory group: %s", resp.Status)
+	}
+	var body struct {
+		Emails []string `json:"emails"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding directory group: %w", err)
+	}
+	return body.Emails, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store bucket for the built-in vector store: every passage of a document,
+// keyed by the document's source
+const kbSourcesBucket = "kb_sources"
+
+// kbChunk is one indexed passage of a document
+type kbChunk struct {
+	ID     string    `json:"id"`
+	Source string    `json:"source"`
+	Title  string    `json:"title"`
+	URL    string    `json:"url,omitempty"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+// kbMatch is a passage found by a search, with how alike it is to the
+// query from -1 to 1
+type kbMatch struct {
+	kbChunk
+	Score float64
+}
+
+// vectorStore keeps passages and finds the ones most like a query
+type vectorStore interface {
+	// ReplaceSource swaps every passage of a document for chunks
+	ReplaceSource(source string, chunks []kbChunk) error
+	// Search returns up to limit passages, most alike first
+	Search(vector []float32, limit int) ([]kbMatch, error)
+}
+
+// vectorStoreFactories creates vector stores by the name used in config
+var vectorStoreFactories = map[string]func(KnowledgeConfig) (vectorStore, error){
+	"store":  newStoreVectorStore,
+	"qdrant": newQdrantVectorStore,
+}
+
+// The configured vector store; nil when knowledge.vector_store isn't set
+var knowledgeBase vectorStore
+
+// setupKnowledgeBase builds the vector store for knowledge.vector_store
+func setupKnowledgeBase(cfg KnowledgeConfig) error {
+	if cfg.VectorStore == "" {
+		return nil
+	}
+	factory, ok := vectorStoreFactories[cfg.VectorStore]
+	if !ok {
+		return fmt.Errorf("unknown knowledge vector store %q", cfg.VectorStore)
+	}
+	if cfg.EmbeddingModel == "" {
+		return errors.New("knowledge.embedding_model is required")
+	}
+	vs, err := factory(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring knowledge vector store: %w", err)
+	}
+	knowledgeBase = vs
+	return nil
+}
+
+// embedTexts turns texts into embeddings with an OpenAI-compatible
+// embeddings API
+func embedTexts(texts []string) ([][]float32, error) {
+	cfg := appConfig.Knowledge
+	endpoint := cfg.EmbeddingURL
+	if endpoint == "" {
+		endpoint = appConfig.LLM.URL
+	}
+	if endpoint == "" {
+		return nil, errors.New("no embeddings API is configured (knowledge.embedding_url or llm.url)")
+	}
+	headers := map[string]string{}
+	key := llmAPIKey(appConfig.LLM)
+	if cfg.EmbeddingAPIKeyEnv != "" {
+		key = os.Getenv(cfg.EmbeddingAPIKeyEnv)
+	}
+	if key != "" {
+		headers["Authorization"] = "Bearer " + key
+	}
+	resp, err := llmRequest(strings.TrimSuffix(endpoint, "/")+"/embeddings", map[string]any{"model": cfg.EmbeddingModel, "input": texts}, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding embeddings: %w", err)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings API returned %d embeddings for %d texts", len(out.Data), len(texts))
+	}
+	recordQuotaUsage(quotaLLMTokens, out.Usage.PromptTokens)
+	vectors := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings API returned index %d for %d texts", d.Index, len(texts))
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// storeVectorStore keeps passages in the bot's own store and searches them
+// one by one, which is fine for a few thousand passages
+type storeVectorStore struct{}
+
+func newStoreVectorStore(KnowledgeConfig) (vectorStore, error) {
+	return storeVectorStore{}, nil
+}
+
+func (storeVectorStore) ReplaceSource(source string, chunks []kbChunk) error {
+	if len(chunks) == 0 {
+		return store.Delete(kbSourcesBucket, source)
+	}
+	return store.Put(kbSourcesBucket, source, chunks)
+}
+
+func (storeVectorStore) Search(vector []float32, limit int) ([]kbMatch, error) {
+	sources, err := storeList[[]kbChunk](store, kbSourcesBucket)
+	if err != nil {
+		return nil, err
+	}
+	var matches []kbMatch
+	for _, chunks := range sources {
+		for _, chunk := range chunks {
+			matches = append(matches, kbMatch{kbChunk: chunk, Score: cosineSimilarity(vector, chunk.Vector)})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// qdrantVectorStore keeps passages in a Qdrant collection, created on first
+// use with cosine distance. QDRANT_API_KEY is sent when set.
+type qdrantVectorStore struct {
+	url, collection, key string
+
+	// Whether the collection is known to exist
+	ready   bool
+	readyMu sync.Mutex
+}
+
+func newQdrantVectorStore(cfg KnowledgeConfig) (vectorStore, error) {
+	if cfg.QdrantURL == "" || cfg.Collection == "" {
+		return nil, errors.New("qdrant needs knowledge.qdrant_url and knowledge.collection")
+	}
+	return &qdrantVectorStore{url: strings.TrimSuffix(cfg.QdrantURL, "/"), collection: cfg.Collection, key: os.Getenv("QDRANT_API_KEY")}, nil
+}
+
+// qdrantPayload is what's kept with each point
+type qdrantPayload struct {
+	Source string `json:"source"`
+	Title  string `json:"title"`
+	URL    string `json:"url,omitempty"`
+	Text   string `json:"text"`
+}
+
+func (q *qdrantVectorStore) ReplaceSource(source string, chunks []kbChunk) error {
+	if len(chunks) > 0 {
+		if err := q.ensureCollection(len(chunks[0].Vector)); err != nil {
+			return err
+		}
+	}
+	filter := map[string]any{"filter": map[string]any{
+		"must": []any{map[string]any{"key": "source", "match": map[string]string{"value": source}}},
+	}}
+	if err := q.request(http.MethodPost, "/points/delete?wait=true", filter, nil); err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	points := make([]map[string]any, len(chunks))
+	for i, chunk := range chunks {
+		points[i] = map[string]any{
+			"id":      qdrantPointID(chunk.ID),
+			"vector":  chunk.Vector,
+			"payload": qdrantPayload{Source: chunk.Source, Title: chunk.Title, URL: chunk.URL, Text: chunk.Text},
+		}
+	}
+	return q.request(http.MethodPut, "/points?wait=true", map[string]any{"points": points}, nil)
+}
+
+func (q *qdrantVectorStore) Search(vector []float32, limit int) ([]kbMatch, error) {
+	var out struct {
+		Result []struct {
+			Score   float64       `json:"score"`
+			Payload qdrantPayload `json:"payload"`
+		} `json:"result"`
+	}
+	body := map[string]any{"vector": vector, "limit": limit, "with_payload": true}
+	if err := q.request(http.MethodPost, "/points/search", body, &out); err != nil {
+		return nil, err
+	}
+	matches := make([]kbMatch, len(out.Result))
+	for i, r := range out.Result {
+		p := r.Payload
+		matches[i] = kbMatch{kbChunk: kbChunk{Source: p.Source, Title: p.Title, URL: p.URL, Text: p.Text}, Score: r.Score}
+	}
+	return matches, nil
+}
+
+// ensureCollection creates the collection for vectors of size dims unless
+// it already exists
+func (q *qdrantVectorStore) ensureCollection(dims int) error {
+	q.readyMu.Lock()
+	defer q.readyMu.Unlock()
+	if q.ready {
+		return nil
+	}
+	err := q.request(http.MethodGet, "", nil, nil)
+	if errors.Is(err, errQdrantNotFound) {
+		body := map[string]any{"vectors": map[string]any{"size": dims, "distance": "Cosine"}}
+		err = q.request(http.MethodPut, "", body, nil)
+	}
+	if err != nil {
+		return err
+	}
+	q.ready = true
+	return nil
+}
+
+var errQdrantNotFound = errors.New("qdrant collection not found")
+
+// request calls the collection's API at path
+func (q *qdrantVectorStore) request(method, path string, body, out any) error {
+	var payload io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, q.url+"/collections/"+q.collection+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.key != "" {
+		req.Header.Set("api-key", q.key)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling qdrant: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errQdrantNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("calling qdrant: %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(out); err != nil {
+		return fmt.Errorf("decoding qdrant response: %w", err)
+	}
+	return nil
+}
+
+// qdrantPointID turns a passage ID into the UUID Qdrant wants as a point ID
+func qdrantPointID(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// Used when the watchdog's settings aren't set
+const (
+	defaultWatchdogSampleInterval = 5 * time.Minute
+	defaultWatchdogQuietAfter     = 10 * time.Minute
+	defaultWatchdogMinExpected    = 10
+	defaultWatchdogReplayWindow   = time.Hour
+)
+
+// Messages younger than this may still be on their way, so sampling
+// doesn't count them as missed yet
+const watchdogSettleTime = time.Minute
+
+// How many minutes the baseline event rate averages over
+const watchdogBaselineMinutes = 60
+
+// watchdogGap is a stretch of time in which events went missing
+type watchdogGap struct {
+	From, To time.Time
+	// Missed messages found by sampling, and how many of them were replayed
+	Missed, Replayed int
+	Channels         []string
+}
+
+// eventWatchdog notices when Slack events stop arriving, by the event rate
+// and by comparing key channels' history with the messages received
+type eventWatchdog struct {
+	mu sync.Mutex
+	// Events received this minute, and the average per minute before
+	minuteCount int
+	baseline    float64
+	minutes     int
+	// When events stopped arriving, and whether that's been reported
+	quietSince    time.Time
+	quietReported bool
+	// Sampling has compared history up to here
+	sampledUntil time.Time
+	// Message timestamps received recently, by channel
+	received map[string]map[string]time.Time
+	lastGap  *watchdogGap
+	gaps     int
+}
+
+var watchdog = &eventWatchdog{received: map[string]map[string]time.Time{}}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin watchdog",
+		Usage:       "admin watchdog",
+		Description: "Show the event rate and any gaps in the events Slack sent",
+		AdminOnly:   true,
+		Handler:     handleWatchdogCommand,
+	})
+}
+
+// startEventWatchdog checks the event rate every minute and samples the
+// key channels' history every watchdog.sample_interval
+func startEventWatchdog() {
+	cfg := appConfig.Watchdog
+	if len(cfg.Channels) == 0 && cfg.AlertChannel == "" {
+		return
+	}
+	interval := cfg.SampleInterval
+	if interval <= 0 {
+		interval = defaultWatchdogSampleInterval
+	}
+	watchdog.mu.Lock()
+	watchdog.sampledUntil = time.Now().Add(-watchdogSettleTime)
+	watchdog.mu.Unlock()
+	runEvery("event rate watchdog", time.Minute, watchdog.checkRate)
+	if len(cfg.Channels) > 0 {
+		runEvery("event history watchdog", interval, func() {
+			watchdog.mu.Lock()
+			from := watchdog.sampledUntil
+			watchdog.mu.Unlock()
+			watchdog.sample(from)
+		})
+	}
+}
+
+// countEventReceived counts an event towards the event rate
+func countEventReceived() {
+	watchdog.mu.Lock()
+	watchdog.minuteCount++
+	watchdog.mu.Unlock()
+}
+
+// markMessageReceived remembers a message in a key channel arrived, so
+// sampling doesn't replay it
+func markMessageReceived(channel, ts string) {
+	if !slices.Contains(appConfig.Watchdog.Channels, channel) {
+		return
+	}
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	if watchdog.received[channel] == nil {
+		watchdog.received[channel] = map[string]time.Time{}
+	}
+	watchdog.received[channel][ts] = time.Now()
+}
+
+// checkRate folds the last minute into the baseline, and samples history
+// back to when events stopped once they've been quiet for longer than the
+// baseline makes likely
+func (w *eventWatchdog) checkRate() {
+	cfg := appConfig.Watchdog
+	quietAfter := cfg.QuietAfter
+	if quietAfter <= 0 {
+		quietAfter = defaultWatchdogQuietAfter
+	}
+	minExpected := cfg.MinExpected
+	if minExpected <= 0 {
+		minExpected = defaultWatchdogMinExpected
+	}
+
+	w.mu.Lock()
+	now := time.Now()
+	count := w.minuteCount
+	w.minuteCount = 0
+	if count > 0 {
+		w.quietSince, w.quietReported = time.Time{}, false
+	} else if w.quietSince.IsZero() {
+		w.quietSince = now.Add(-time.Minute)
+	}
+	quiet := now.Sub(w.quietSince)
+	// Long quiet stretches don't lower the baseline, or an outage would
+	// hide itself
+	if count > 0 || quiet < quietAfter {
+		w.minutes = min(w.minutes+1, watchdogBaselineMinutes)
+		w.baseline += (float64(count) - w.baseline) / float64(w.minutes)
+	}
+	expected := w.baseline * quiet.Minutes()
+	alarm := !w.quietSince.IsZero() && !w.quietReported && quiet >= quietAfter && expected >= minExpected
+	if alarm {
+		w.quietReported = true
+	}
+	from := w.quietSince
+	w.mu.Unlock()
+
+	if !alarm {
+		return
+	}
+	log.Printf("Event watchdog: no events for %s, about %.0f expected", quiet.Round(time.Minute), expected)
+	if len(cfg.Channels) == 0 {
+		alertOps(fmt.Sprintf(":rotating_light: I haven't received any Slack events for %s, where I'd expect about %.0f. Is the events endpoint reachable?", quiet.Round(time.Minute), expected))
+		return
+	}
+	// The history of the key channels says whether anything was missed
+	if gap := w.sample(from); gap == nil {
+		alertOps(fmt.Sprintf(":warning: I haven't received any Slack events for %s, where I'd expect about %.0f, but the key channels show nothing missed.", quiet.Round(time.Minute), expected))
+	}
+}
+
+// sample compares the key channels' history since from with the messages
+// received, replaying missed ones and alerting ops, and returns the gap
+// it found, if any
+func (w *eventWatchdog) sample(from time.Time) *watchdogGap {
+	cfg := appConfig.Watchdog
+	replayWindow := cfg.ReplayWindow
+	if replayWindow <= 0 {
+		replayWindow = defaultWatchdogReplayWindow
+	}
+	until := time.Now().Add(-watchdogSettleTime)
+	if !until.After(from) {
+		return nil
+	}
+
+	var gap *watchdogGap
+	complete := true
+	for _, channel := range cfg.Channels {
+		missed, err := w.missedMessages(channel, from, until)
+		if err != nil {
+			log.Printf("Error sampling %s for the event watchdog: %v", channel, err)
+			complete = false
+			continue
+		}
+		if len(missed) == 0 {
+			continue
+		}
+		if gap == nil {
+			gap = &watchdogGap{From: until, To: from}
+		}
+		gap.Channels = append(gap.Channels, channel)
+		for _, msg := range missed {
+			at := slackTSTime(msg.Timestamp)
+			gap.From, gap.To = minTime(gap.From, at), maxTime(gap.To, at)
+			gap.Missed++
+			if time.Since(at) > replayWindow {
+				markMessageReceived(channel, msg.Timestamp)
+				continue
+			}
+			handleNewMessage(replayedMessageEvent(channel, msg))
+			gap.Replayed++
+		}
+	}
+
+	w.mu.Lock()
+	// A failed channel is sampled again next time
+	if complete {
+		w.sampledUntil = until
+	}
+	for channel, seen := range w.received {
+		for ts, at := range seen {
+			if time.Since(at) > 2*replayWindow {
+				delete(seen, ts)
+			}
+		}
+		if len(seen) == 0 {
+			delete(w.received, channel)
+		}
+	}
+	if gap != nil {
+		w.lastGap = gap
+		w.gaps++
+	}
+	w.mu.Unlock()
+
+	if gap != nil {
+		channels := make([]string, len(gap.Channels))
+		for i, channel := range gap.Channels {
+			channels[i] = "<#" + channel + ">"
+		}
+		text := fmt.Sprintf(":rotating_light: Slack events went missing between %s and %s: %d messages in %s never reached me. I've replayed %d of them.",
+			slackDate(gap.From), slackDate(gap.To), gap.Missed, strings.Join(channels, ", "), gap.Replayed)
+		if gap.Replayed < gap.Missed {
+			text += fmt.Sprintf(" The rest are older than %s, so I only report them.", replayWindow)
+		}
+		log.Printf("Event watchdog: %d missed messages in %s, %d replayed", gap.Missed, strings.Join(gap.Channels, ","), gap.Replayed)
+		alertOps(text)
+	}
+	return gap
+}
+
+// missedMessages returns the messages people posted in channel between
+// from and until that never arrived as events, oldest first
+func (w *eventWatchdog) missedMessages(channel string, from, until time.Time) ([]slack.Message, error) {
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channel,
+		Oldest:    fmt.Sprintf("%d.000000", from.Unix()),
+		Latest:    fmt.Sprintf("%d.000000", until.Unix()),
+		Limit:     200,
+	}
+	var missed []slack.Message
+	for {
+		// A sample that fails is taken again next time, so it can give way
+		page, err := slackClient.GetConversationHistoryContext(backgroundSlackContext(), params)
+		if err != nil {
+			return nil, err
+		}
+		w.mu.Lock()
+		for _, msg := range page.Messages {
+			// Only people's new messages are replayed; bots and edits aren't
+			if msg.SubType != "" && msg.SubType != "thread_broadcast" || msg.BotID != "" || msg.User == botUserID {
+				continue
+			}
+			if _, ok := w.received[channel][msg.Timestamp]; !ok {
+				missed = append(missed, msg)
+			}
+		}
+		w.mu.Unlock()
+		if !page.HasMore || page.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.ResponseMetaData.NextCursor
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].Timestamp < missed[j].Timestamp })
+	return missed, nil
+}
+
+// replayedMessageEvent builds the event Slack would have sent for msg
+func replayedMessageEvent(channel string, msg slack.Message) *slackevents.MessageEvent {
+	m := msg.Msg
+	return &slackevents.MessageEvent{
+		Type:            "message",
+		SubType:         msg.SubType,
+		User:            msg.User,
+		Text:            msg.Text,
+		TimeStamp:       msg.Timestamp,
+		ThreadTimeStamp: msg.ThreadTimestamp,
+		EventTimeStamp:  msg.Timestamp,
+		Channel:         channel,
+		ChannelType:     "channel",
+		Message:         &m,
+	}
+}
+
+// alertOps posts to watchdog.alert_channel
+func alertOps(text string) {
+	channel := appConfig.Watchdog.AlertChannel
+	if channel == "" {
+		return
+	}
+	if _, err := sendMessage(outboundMessage{Channel: channel, Importance: importanceWarning, Text: text}); err != nil {
+		log.Printf("Error alerting ops: %v", err)
+	}
+}
+
+func handleWatchdogCommand(req commandRequest) commandResponse {
+	if len(appConfig.Watchdog.Channels) == 0 && appConfig.Watchdog.AlertChannel == "" {
+		return ephemeral("The event watchdog isn't set up. See `watchdog` in the config.")
+	}
+	watchdog.mu.Lock()
+	defer watchdog.mu.Unlock()
+	lines := []string{
+		"*Event watchdog*",
+		fmt.Sprintf("• Baseline: %.1f events a minute over the last %d minutes", watchdog.baseline, watchdog.minutes),
+	}
+	if !watchdog.quietSince.IsZero() {
+		lines = append(lines, fmt.Sprintf("• No events since %s", slackDate(watchdog.quietSince)))
+	}
+	if len(appConfig.Watchdog.Channels) > 0 {
+		lines = append(lines, fmt.Sprintf("• Key channels' history checked up to %s", slackDate(watchdog.sampledUntil)))
+	}
+	if gap := watchdog.lastGap; gap != nil {
+		lines = append(lines, fmt.Sprintf("• %d gaps found; the last, from %s to %s, missed %d messages and replayed %d",
+			watchdog.gaps, slackDate(gap.From), slackDate(gap.To), gap.Missed, gap.Replayed))
+	} else {
+		lines = append(lines, "• No gaps found")
+	}
+	return ephemeral("%s", strings.Join(lines, "\n"))
+}
+
+// slackTSTime turns a message timestamp like 1700000000.000100 into a time
+func slackTSTime(ts string) time.Time {
+	seconds, _ := strconv.ParseFloat(ts, 64)
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
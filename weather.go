@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket of the location each user's /weather defaults to, keyed by
+// user ID
+const weatherDefaultsBucket = "weather_defaults"
+
+// Used when the weather settings aren't set
+const (
+	defaultWeatherProvider  = "openweather"
+	defaultWeatherKeyEnv    = "OPENWEATHER_API_KEY"
+	defaultWeatherUnits     = "metric"
+	weatherForecastDays     = 5
+	maxWeatherLocationChars = 100
+)
+
+// errLocationNotFound is returned by providers that can't place a location
+var errLocationNotFound = errors.New("location not found")
+
+// weatherForecast is the weather now and over the next few days somewhere
+type weatherForecast struct {
+	// Place is the location as the provider names it, like "London, GB"
+	Place   string
+	Current weatherReading
+	Days    []weatherDay
+	Source  string
+}
+
+// weatherReading is the weather at one time
+type weatherReading struct {
+	Temp, FeelsLike float64
+	Humidity        int
+	Wind            float64
+	Description     string
+	Emoji           string
+}
+
+// weatherDay is the outlook for one local day
+type weatherDay struct {
+	Date     time.Time
+	Low      float64
+	High     float64
+	Emoji    string
+	RainProb float64
+}
+
+// weatherProvider looks up forecasts in a weather service
+type weatherProvider interface {
+	Forecast(location string) (*weatherForecast, error)
+}
+
+// weatherProviderFactories creates weather providers by the name used in
+// config
+var weatherProviderFactories = map[string]func(WeatherConfig) (weatherProvider, error){
+	"openweather": newOpenWeatherProvider,
+}
+
+// The configured provider; nil when no API key is set
+var weatherSource weatherProvider
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/weather",
+		Usage:       "/weather [city] | default <city> | default clear",
+		Description: "See the weather and forecast for a city, or for your default one",
+		Handler:     handleWeather,
+	})
+	registerUserDataset(userDataset{Name: "weather", Title: "Default weather location", Describe: describeUserWeather, Delete: deleteUserWeather})
+}
+
+// setupWeather builds the provider for weather.provider
+func setupWeather(cfg WeatherConfig) error {
+	weatherSource = nil
+	name := cfg.Provider
+	if name == "" {
+		name = defaultWeatherProvider
+	}
+	factory, ok := weatherProviderFactories[name]
+	if !ok {
+		return fmt.Errorf("unknown weather provider %q", name)
+	}
+	provider, err := factory(cfg)
+	if err != nil {
+		// Weather is optional; without a key /weather says it isn't set up
+		log.Printf("Weather lookups are off: %v", err)
+		return nil
+	}
+	weatherSource = provider
+	return nil
+}
+
+// weatherUnits returns weather.units and the symbols for its temperatures
+// and wind speeds
+func weatherUnits() (units, temp, wind string) {
+	if strings.EqualFold(appConfig.Weather.Units, "imperial") {
+		return "imperial", "°F", "mph"
+	}
+	return defaultWeatherUnits, "°C", "m/s"
+}
+
+func handleWeather(req commandRequest) commandResponse {
+	if weatherSource == nil {
+		return ephemeral("Weather lookups aren't set up. See `weather` in the config.")
+	}
+	args := req.Args
+	if len(args) > 0 && strings.EqualFold(args[0], "default") {
+		return setWeatherDefault(req.UserID, strings.Join(args[1:], " "))
+	}
+	location := strings.Join(args, " ")
+	if location == "" {
+		if _, err := store.Get(weatherDefaultsBucket, req.UserID, &location); err != nil {
+			log.Printf("Error loading weather default: %v", err)
+			return ephemeral("Sorry, something went wrong looking up your default location.")
+		}
+		if location == "" {
+			return ephemeral("Usage: `/weather <city>`, or set a default with `/weather default <city>`")
+		}
+	}
+	if len(location) > maxWeatherLocationChars {
+		return ephemeral("That's a long name for a place. Try just the city, like `/weather Lagos`.")
+	}
+	// Forecasts take a few calls, which can be longer than Slack waits
+	go runJob("weather", func() {
+		reply := &slack.WebhookMessage{ResponseType: slack.ResponseTypeEphemeral, ReplaceOriginal: true}
+		forecast, err := weatherSource.Forecast(location)
+		switch {
+		case errors.Is(err, errLocationNotFound):
+			reply.Text = fmt.Sprintf("I couldn't find %q. Try adding the country, like `Paris, FR`.", location)
+		case err != nil:
+			log.Printf("Error looking up weather for %q: %v", location, err)
+			reply.Text = "Sorry, something went wrong looking up the weather."
+		default:
+			reply.Text, reply.Blocks = weatherText(forecast), &slack.Blocks{BlockSet: fitResponseBlocks(weatherBlocks(forecast))}
+		}
+		if err := respond(req.ResponseURL, reply); err != nil {
+			log.Printf("Error replying to /weather: %v", err)
+		}
+	})
+	return ephemeral(":mag: Checking the weather in %s…", location)
+}
+
+// setWeatherDefault saves or clears the location /weather uses on its own
+func setWeatherDefault(userID, location string) commandResponse {
+	location = strings.TrimSpace(location)
+	switch {
+	case location == "":
+		return ephemeral("Usage: `/weather default <city>` or `/weather default clear`")
+	case strings.EqualFold(location, "clear"):
+		if err := deleteUserWeather(userID); err != nil {
+			log.Printf("Error clearing weather default: %v", err)
+			return ephemeral("Sorry, something went wrong clearing your default location.")
+		}
+		return ephemeral("Your default weather location is cleared.")
+	case len(location) > maxWeatherLocationChars:
+		return ephemeral("That's a long name for a place. Try just the city, like `/weather default Lagos`.")
+	}
+	if err := store.Put(weatherDefaultsBucket, userID, location); err != nil {
+		log.Printf("Error saving weather default: %v", err)
+		return ephemeral("Sorry, something went wrong saving your default location.")
+	}
+	return ephemeral(":round_pushpin: `/weather` on its own now shows %s.", location)
+}
+
+func weatherText(f *weatherForecast) string {
+	_, temp, _ := weatherUnits()
+	return fmt.Sprintf("%s %s: %.0f%s, %s", f.Current.Emoji, f.Place, f.Current.Temp, temp, f.Current.Description)
+}
+
+// weatherBlocks lays out the weather now and a day per field after it
+func weatherBlocks(f *weatherForecast) []slack.Block {
+	_, temp, wind := weatherUnits()
+	md := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.MarkdownType, s, false, false)
+	}
+	header, _ := truncateText("Weather in "+f.Place, maxHeaderText)
+	now := fmt.Sprintf("%s *%.0f%s* %s\nFeels like %.0f%s", f.Current.Emoji, f.Current.Temp, temp, f.Current.Description, f.Current.FeelsLike, temp)
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, header, false, false)),
+		slack.NewSectionBlock(md(now), []*slack.TextBlockObject{
+			md(fmt.Sprintf(":droplet: *Humidity*\n%d%%", f.Current.Humidity)),
+			md(fmt.Sprintf(":dash: *Wind*\n%.0f %s", f.Current.Wind, wind)),
+		}, nil),
+	}
+	if len(f.Days) > 0 {
+		var fields []*slack.TextBlockObject
+		for _, d := range f.Days[:min(len(f.Days), maxSectionFields)] {
+			text := fmt.Sprintf("*%s*\n%s %.0f%s / %.0f%s", d.Date.Format("Mon 2 Jan"), d.Emoji, d.High, temp, d.Low, temp)
+			if d.RainProb >= 0.2 {
+				text += fmt.Sprintf(" · :umbrella: %.0f%%", d.RainProb*100)
+			}
+			fields = append(fields, md(text))
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), slack.NewSectionBlock(nil, fields, nil))
+	}
+	return append(blocks, slack.NewContextBlock("", md("Forecast from "+f.Source)))
+}
+
+// weatherEmoji picks an emoji for an OpenWeather condition code
+// (https://openweathermap.org/weather-conditions)
+func weatherEmoji(code int, night bool) string {
+	switch {
+	case code >= 200 && code < 300:
+		return ":thunder_cloud_and_rain:"
+	case code >= 300 && code < 400:
+		return ":partly_sunny_rain:"
+	case code >= 500 && code < 600:
+		return ":rain_cloud:"
+	case code >= 600 && code < 700:
+		return ":snowflake:"
+	case code >= 700 && code < 800:
+		return ":fog:"
+	case code == 800 && night:
+		return ":crescent_moon:"
+	case code == 800:
+		return ":sunny:"
+	case code == 801:
+		return ":mostly_sunny:"
+	case code == 802:
+		return ":partly_sunny:"
+	default:
+		return ":cloud:"
+	}
+}
+
+// openWeatherProvider reads OpenWeather's free geocoding, current weather
+// and 5 day / 3 hour forecast APIs
+type openWeatherProvider struct {
+	url, key string
+}
+
+func newOpenWeatherProvider(cfg WeatherConfig) (weatherProvider, error) {
+	env := cfg.APIKeyEnv
+	if env == "" {
+		env = defaultWeatherKeyEnv
+	}
+	key := os.Getenv(env)
+	if key == "" {
+		return nil, fmt.Errorf("%s is not set", env)
+	}
+	return openWeatherProvider{url: "https://api.openweathermap.org", key: key}, nil
+}
+
+// openWeatherConditions is the weather list OpenWeather puts in readings
+type openWeatherConditions []struct {
+	ID          int    `json:"id"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+}
+
+func (c openWeatherConditions) emoji() string {
+	if len(c) == 0 {
+		return ":thermometer:"
+	}
+	return weatherEmoji(c[0].ID, strings.HasSuffix(c[0].Icon, "n"))
+}
+
+func (p openWeatherProvider) Forecast(location string) (*weatherForecast, error) {
+	units, _, _ := weatherUnits()
+	var places []struct {
+		Name    string  `json:"name"`
+		Country string  `json:"country"`
+		Lat     float64 `json:"lat"`
+		Lon     float64 `json:"lon"`
+	}
+	query := url.Values{"q": {location}, "limit": {"1"}, "appid": {p.key}}
+	if err := weatherGet(p.url+"/geo/1.0/direct?"+query.Encode(), &places); err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, errLocationNotFound
+	}
+	place := places[0]
+	coords := url.Values{"lat": {fmt.Sprint(place.Lat)}, "lon": {fmt.Sprint(place.Lon)}, "units": {units}, "appid": {p.key}}
+
+	var current struct {
+		Weather openWeatherConditions `json:"weather"`
+		Main    struct {
+			Temp      float64 `json:"temp"`
+			FeelsLike float64 `json:"feels_like"`
+			Humidity  int     `json:"humidity"`
+		} `json:"main"`
+		Wind struct {
+			Speed float64 `json:"speed"`
+		} `json:"wind"`
+	}
+	if err := weatherGet(p.url+"/data/2.5/weather?"+coords.Encode(), &current); err != nil {
+		return nil, err
+	}
+	f := &weatherForecast{
+		Place: strings.TrimSuffix(place.Name+", "+place.Country, ", "),
+		Current: weatherReading{
+			Temp:      current.Main.Temp,
+			FeelsLike: current.Main.FeelsLike,
+			Humidity:  current.Main.Humidity,
+			Wind:      current.Wind.Speed,
+			Emoji:     current.Weather.emoji(),
+		},
+		Source: "OpenWeather",
+	}
+	if len(current.Weather) > 0 {
+		f.Current.Description = current.Weather[0].Description
+	}
+
+	var forecast struct {
+		List []struct {
+			DT   int64 `json:"dt"`
+			Main struct {
+				TempMin float64 `json:"temp_min"`
+				TempMax float64 `json:"temp_max"`
+			} `json:"main"`
+			Weather openWeatherConditions `json:"weather"`
+			Pop     float64               `json:"pop"`
+		} `json:"list"`
+		City struct {
+			// Timezone is the place's offset from UTC, in seconds
+			Timezone int `json:"timezone"`
+		} `json:"city"`
+	}
+	if err := weatherGet(p.url+"/data/2.5/forecast?"+coords.Encode(), &forecast); err != nil {
+		return nil, err
+	}
+	loc := time.FixedZone(place.Name, forecast.City.Timezone)
+	today := time.Now().In(loc).Format(time.DateOnly)
+	// The day's emoji is the one for around midday, or the first reading
+	// that day
+	middayGap := map[string]int{}
+	for _, item := range forecast.List {
+		at := time.Unix(item.DT, 0).In(loc)
+		date := at.Format(time.DateOnly)
+		if date == today {
+			continue
+		}
+		n := len(f.Days)
+		if n == 0 || f.Days[n-1].Date.Format(time.DateOnly) != date {
+			if n == weatherForecastDays {
+				break
+			}
+			f.Days = append(f.Days, weatherDay{Date: at, Low: item.Main.TempMin, High: item.Main.TempMax, Emoji: item.Weather.emoji(), RainProb: item.Pop})
+			middayGap[date] = max(at.Hour()-12, 12-at.Hour())
+			continue
+		}
+		d := &f.Days[n-1]
+		d.Low, d.High, d.RainProb = min(d.Low, item.Main.TempMin), max(d.High, item.Main.TempMax), max(d.RainProb, item.Pop)
+		if gap := max(at.Hour()-12, 12-at.Hour()); gap < middayGap[date] {
+			d.Emoji, middayGap[date] = item.Weather.emoji(), gap
+		}
+	}
+	return f, nil
+}
+
+// weatherGet calls the provider's API and decodes the JSON answer into out
+func weatherGet(endpoint string, out any) error {
+	resp, err := httpClient.Get(endpoint)
+	if err != nil {
+		// The URL carries the API key, which url.Error would log
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errLocationNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(out)
+}
+
+func describeUserWeather(userID string) (string, error) {
+	var location string
+	if _, err := store.Get(weatherDefaultsBucket, userID, &location); err != nil {
+		return "", err
+	}
+	if location == "" {
+		return describeItems(nil), nil
+	}
+	return describeItems([]string{location}), nil
+}
+
+func deleteUserWeather(userID string) error {
+	return store.Delete(weatherDefaultsBucket, userID)
+}
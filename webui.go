@@ -0,0 +1,958 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/slack-go/slack"
+)
+
+const (
+	webSessionCookie = "bot_admin_session"
+	// How long a sign-in link from /bot admin web works, once
+	webLoginTTL = 10 * time.Minute
+	// How long a browser stays signed in
+	webSessionTTL = 8 * time.Hour
+)
+
+// webSession is a signed-in browser, or a sign-in link not used yet
+type webSession struct {
+	UserID  string
+	Expires time.Time
+}
+
+var (
+	// Sign-in links and sessions, keyed by token. Both live in memory, so a
+	// restart signs everyone out.
+	webLogins     = map[string]webSession{}
+	webSessions   = map[string]webSession{}
+	webSessionsMu sync.Mutex
+)
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin web",
+		Usage:       "admin web",
+		Description: "Get a sign-in link for the admin web UI (read-only for auditors)",
+		Handler:     handleAdminWeb,
+	})
+}
+
+func handleAdminWeb(req commandRequest) commandResponse {
+	if !isAdmin(req.UserID) && !isAuditor(req.UserID) {
+		return ephemeral("Sorry, only bot admins and auditors can use the web UI.")
+	}
+	base := strings.TrimSuffix(appConfig.Web.BaseURL, "/")
+	if base == "" {
+		return ephemeral("The web UI isn't set up: add `web.base_url` to the config.")
+	}
+	token := newInteractionToken()
+	webSessionsMu.Lock()
+	expireWebSessions(webLogins)
+	webLogins[token] = webSession{UserID: req.UserID, Expires: time.Now().Add(webLoginTTL)}
+	webSessionsMu.Unlock()
+	return ephemeral("<%s/admin/login?token=%s|Open the admin web UI>. The link works once, for the next %d minutes.",
+		base, token, int(webLoginTTL.Minutes()))
+}
+
+// expireWebSessions drops expired entries; the caller holds webSessionsMu
+func expireWebSessions(sessions map[string]webSession) {
+	now := time.Now()
+	for token, session := range sessions {
+		if now.After(session.Expires) {
+			delete(sessions, token)
+		}
+	}
+}
+
+// mountWebUI serves the admin web UI on group, which is /admin
+func mountWebUI(group *gin.RouterGroup) {
+	group.GET("/login", handleWebLogin)
+
+	// Admins and auditors can look at everything and try previews
+	view := group.Group("", requireWebSession)
+	view.GET("", func(c *gin.Context) { c.Redirect(http.StatusFound, "/admin/triggers") })
+	view.POST("/logout", handleWebLogout)
+	view.GET("/triggers", handleWebTriggers)
+	view.POST("/preview/trigger", handleWebTriggerPreview)
+	view.GET("/schedules", handleWebSchedules)
+	view.POST("/preview/schedule", handleWebSchedulePreview)
+	view.GET("/routing", handleWebRouting)
+	view.GET("/templates", handleWebGreetings)
+	view.POST("/preview/greeting", handleWebGreetingPreview)
+	view.GET("/audit", handleWebAuditLog)
+	view.GET("/deliveries", handleWebDeliveries)
+
+	// Only admins can change anything
+	admin := view.Group("", requireWebAdmin)
+	admin.POST("/triggers", handleWebTriggerSave)
+	admin.POST("/triggers/delete", handleWebTriggerDelete)
+	admin.POST("/schedules", handleWebScheduleSave)
+	admin.POST("/schedules/delete", handleWebScheduleDelete)
+	admin.POST("/routing", handleWebRoutingSave)
+	admin.POST("/routing/delete", handleWebRoutingDelete)
+	admin.POST("/templates", handleWebGreetingSave)
+	admin.POST("/templates/delete", handleWebGreetingDelete)
+}
+
+// handleWebLogin swaps a sign-in link's token for a session cookie
+func handleWebLogin(c *gin.Context) {
+	token := c.Query("token")
+	webSessionsMu.Lock()
+	login, ok := webLogins[token]
+	delete(webLogins, token)
+	if ok && time.Now().Before(login.Expires) {
+		expireWebSessions(webSessions)
+		token = newInteractionToken()
+		webSessions[token] = webSession{UserID: login.UserID, Expires: time.Now().Add(webSessionTTL)}
+	}
+	webSessionsMu.Unlock()
+	if !ok || time.Now().After(login.Expires) {
+		renderWebPage(c, http.StatusUnauthorized, "signin", webPage{Error: "That sign-in link has expired or was already used."})
+		return
+	}
+
+	// Lax rather than Strict, so the cookie comes along on the redirect
+	// after following the link from Slack; POSTs from other sites still
+	// don't get it
+	secure := c.Request.TLS != nil || strings.HasPrefix(appConfig.Web.BaseURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(webSessionCookie, token, int(webSessionTTL.Seconds()), "/admin", "", secure, true)
+	log.Printf("Admin web UI sign-in by %s", login.UserID)
+	c.Redirect(http.StatusSeeOther, "/admin/triggers")
+}
+
+func handleWebLogout(c *gin.Context) {
+	if token, err := c.Cookie(webSessionCookie); err == nil {
+		webSessionsMu.Lock()
+		delete(webSessions, token)
+		webSessionsMu.Unlock()
+	}
+	c.SetCookie(webSessionCookie, "", -1, "/admin", "", false, true)
+	renderWebPage(c, http.StatusOK, "signin", webPage{Notice: "You're signed out."})
+}
+
+// requireWebSession lets through signed-in browsers whose user is still a
+// bot admin or auditor, and sets "webUser" and "webRole". Everything an
+// auditor looks at goes in the admin audit log.
+func requireWebSession(c *gin.Context) {
+	token, err := c.Cookie(webSessionCookie)
+	webSessionsMu.Lock()
+	session, ok := webSessions[token]
+	webSessionsMu.Unlock()
+	if err != nil || !ok || time.Now().After(session.Expires) {
+		renderWebPage(c, http.StatusUnauthorized, "signin", webPage{})
+		c.Abort()
+		return
+	}
+	role := roleAdmin
+	if !isAdmin(session.UserID) {
+		if !isAuditor(session.UserID) {
+			renderWebPage(c, http.StatusForbidden, "signin", webPage{Error: "Only bot admins and auditors can use the web UI."})
+			c.Abort()
+			return
+		}
+		role = roleAuditor
+		recordAdminAudit(session.UserID, roleAuditor, "web", c.Request.Method+" "+c.Request.URL.RequestURI())
+	}
+	c.Set("webUser", session.UserID)
+	c.Set("webRole", role)
+	c.Next()
+}
+
+// requireWebAdmin turns auditors away from anything that changes settings
+func requireWebAdmin(c *gin.Context) {
+	if c.GetString("webRole") != roleAdmin {
+		renderWebPage(c, http.StatusForbidden, "signin", webPage{Error: "Auditors can look at settings but not change them."})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// webPage is the data every admin web UI page gets
+type webPage struct {
+	Section string
+	UserID  string
+	// ReadOnly hides the controls auditors can't use
+	ReadOnly bool
+	Error    string
+	Notice   string
+	Data     any
+}
+
+const webLayout = `{{define "layout"}}<!DOCTYPE html>
+<html>
+<head>
+  <title>Slack bot admin</title>
+  <script>
+    // Live previews, without loading a library from a CDN into a page with
+    // admin rights: a form with data-preview posts its fields there as it's
+    // edited and shows the answer in #preview
+    document.addEventListener("DOMContentLoaded", () => {
+      for (const form of document.querySelectorAll("form[data-preview]")) {
+        const target = document.getElementById("preview");
+        let timer;
+        const refresh = async () => {
+          const resp = await fetch(form.dataset.preview, { method: "POST", body: new URLSearchParams(new FormData(form)) });
+          target.innerHTML = await resp.text();
+        };
+        form.addEventListener("input", () => {
+          clearTimeout(timer);
+          timer = setTimeout(refresh, 400);
+        });
+        refresh();
+      }
+    });
+  </script>
+  <style>
+    body { font-family: -apple-system, sans-serif; margin: 0; color: #1d1c1d; }
+    nav { background: #3f0e40; padding: 12px 24px; }
+    nav a, nav button { color: #fff; margin-right: 16px; text-decoration: none; }
+    nav a.active { font-weight: bold; }
+    nav form { display: inline; float: right; }
+    nav button { background: none; border: none; cursor: pointer; font-size: inherit; }
+    main { padding: 24px; display: flex; gap: 32px; flex-wrap: wrap; }
+    main > section { flex: 1; min-width: 360px; }
+    table { border-collapse: collapse; width: 100%; }
+    td, th { border-bottom: 1px solid #ddd; padding: 6px; text-align: left; vertical-align: top; }
+    label { display: block; margin-top: 12px; font-weight: bold; }
+    input, select, textarea { width: 100%; box-sizing: border-box; font: inherit; padding: 4px; }
+    textarea { height: 8em; font-family: monospace; }
+    .error { background: #fde8e8; padding: 8px; }
+    .notice { background: #e8f5e9; padding: 8px; }
+    .message { border: 1px solid #ddd; border-radius: 8px; padding: 12px; }
+    .section { display: flex; justify-content: space-between; gap: 8px; margin: 8px 0; }
+    .fields { display: grid; grid-template-columns: 1fr 1fr; gap: 8px; margin-top: 8px; }
+    .context { color: #616061; font-size: 0.85em; margin: 8px 0; }
+    .mention { background: #e8f5fa; color: #1264a3; }
+    .actions button { margin-right: 8px; }
+    .primary { background: #007a5a; color: #fff; }
+    .danger { color: #e01e5a; }
+    img.icon { height: 1em; } img.thumb { max-height: 72px; } img.image { max-width: 100%; }
+    blockquote { border-left: 4px solid #ddd; margin: 0; padding-left: 8px; }
+  </style>
+</head>
+<body>
+  {{if .UserID}}<nav>
+    <a href="/admin/triggers" {{if eq .Section "triggers"}}class="active"{{end}}>Triggers</a>
+    <a href="/admin/schedules" {{if eq .Section "schedules"}}class="active"{{end}}>Schedules</a>
+    <a href="/admin/routing" {{if eq .Section "routing"}}class="active"{{end}}>Routing</a>
+    <a href="/admin/templates" {{if eq .Section "templates"}}class="active"{{end}}>Templates</a>
+    <a href="/admin/audit" {{if eq .Section "audit"}}class="active"{{end}}>Audit log</a>
+    <a href="/admin/deliveries" {{if eq .Section "deliveries"}}class="active"{{end}}>Deliveries</a>
+    <form method="post" action="/admin/logout"><button>Sign out</button></form>
+  </nav>{{end}}
+  <main>
+    {{if .ReadOnly}}<p class="notice" style="flex-basis: 100%">You have read-only access as an auditor. What you look at is recorded in the audit log.</p>{{end}}
+    {{if .Error}}<p class="error" style="flex-basis: 100%">{{.Error}}</p>{{end}}
+    {{if .Notice}}<p class="notice" style="flex-basis: 100%">{{.Notice}}</p>{{end}}
+    {{template "content" .}}
+  </main>
+</body>
+</html>{{end}}`
+
+// Page bodies, each defining "content" for the layout
+var webPageSources = map[string]string{
+	"signin": `{{define "content"}}<section>
+  <h2>Sign in</h2>
+  <p>Run <code>/bot admin web</code> in Slack to get a sign-in link.</p>
+</section>{{end}}`,
+
+	"triggers": `{{define "content"}}<section>
+  <h2>Triggers</h2>
+  <table>
+    <tr><th>Name</th><th>Pattern</th><th>Response</th><th></th></tr>
+    {{range .Data.Items}}<tr>
+      <td>{{.Trigger.Name}}<br><small>{{.Source}}</small></td>
+      <td>{{if .Trigger.Keyword}}keyword: {{.Trigger.Keyword}}{{else}}regex: <code>{{.Trigger.Regex}}</code>{{end}}</td>
+      <td>{{.Trigger.Response}}</td>
+      <td><a href="?edit={{.Trigger.Name}}">{{if $.ReadOnly}}View{{else}}Edit{{end}}</a>
+        {{if and .Stored (not $.ReadOnly)}}<form method="post" action="/admin/triggers/delete"><input type="hidden" name="name" value="{{.Trigger.Name}}"><button class="danger">Delete</button></form>{{end}}</td>
+    </tr>{{else}}<tr><td colspan="4">No triggers yet.</td></tr>{{end}}
+  </table>
+</section>
+<section>
+  {{with .Data.Form}}<h2>{{if .Name}}Edit {{.Name}}{{else}}New trigger{{end}}</h2>
+  <form method="post" action="/admin/triggers" data-preview="/admin/preview/trigger">
+    <label>Name <input name="name" value="{{.Name}}" required></label>
+    <label>Match <select name="kind">
+      <option value="keyword" {{if ne .Kind "regex"}}selected{{end}}>Keyword or phrase</option>
+      <option value="regex" {{if eq .Kind "regex"}}selected{{end}}>Regex</option>
+    </select></label>
+    <label>Pattern <input name="pattern" value="{{.Pattern}}" required></label>
+    <label>Response <textarea name="response" required>{{.Response}}</textarea></label>
+    <label>Channels <input name="channels" value="{{.Channels}}" placeholder="Channel IDs, comma separated; empty for all"></label>
+    <label>Cooldown <input name="cooldown" value="{{.Cooldown}}" placeholder="like 10m"></label>
+    <label>Sample message <input name="sample" value="{{.Sample}}" placeholder="A message to try the trigger on"></label>
+    {{if not $.ReadOnly}}<p><button class="primary">Save</button></p>{{end}}
+  </form>{{end}}
+  <h3>Preview</h3>
+  <div id="preview"></div>
+</section>{{end}}`,
+
+	"schedules": `{{define "content"}}<section>
+  <h2>Schedules</h2>
+  <table>
+    <tr><th>Name</th><th>When</th><th>Channel</th><th>Next run</th><th></th></tr>
+    {{range .Data.Items}}<tr>
+      <td>{{.Post.Name}}<br><small>{{.Source}}</small></td>
+      <td><code>{{.Post.Cron}}</code>{{if .Post.Timezone}}<br><small>{{.Post.Timezone}}</small>{{end}}</td>
+      <td>{{.Post.Channel}}</td>
+      <td>{{.NextRun}}</td>
+      <td><a href="?edit={{.Post.Name}}">{{if $.ReadOnly}}View{{else}}Edit{{end}}</a>
+        {{if and .Stored (not $.ReadOnly)}}<form method="post" action="/admin/schedules/delete"><input type="hidden" name="name" value="{{.Post.Name}}"><button class="danger">Delete</button></form>{{end}}</td>
+    </tr>{{else}}<tr><td colspan="5">No schedules yet.</td></tr>{{end}}
+  </table>
+</section>
+<section>
+  {{with .Data.Form}}<h2>{{if .Name}}Edit {{.Name}}{{else}}New schedule{{end}}</h2>
+  <form method="post" action="/admin/schedules" data-preview="/admin/preview/schedule">
+    <label>Name <input name="name" value="{{.Name}}" required></label>
+    <label>Cron <input name="cron" value="{{.Cron}}" placeholder="0 16 * * fri" required></label>
+    <label>Channel <input name="channel" value="{{.Channel}}" placeholder="Channel ID" required></label>
+    <label>Timezone <input name="timezone" value="{{.Timezone}}" placeholder="like Europe/London; empty for the channel's"></label>
+    <label>Importance <select name="importance">
+      {{$level := .Importance}}{{range $.Data.Levels}}<option {{if eq . $level}}selected{{end}}>{{.}}</option>{{end}}
+    </select></label>
+    <label>Text <textarea name="text">{{.Text}}</textarea></label>
+    <label>Blocks <textarea name="blocks" placeholder="A JSON array of Block Kit blocks">{{.Blocks}}</textarea></label>
+    {{if not $.ReadOnly}}<p><button class="primary">Save</button></p>{{end}}
+  </form>{{end}}
+  <h3>Preview</h3>
+  <div id="preview"></div>
+</section>{{end}}`,
+
+	"routing": `{{define "content"}}<section>
+  <h2>Routing</h2>
+  <p>What happens to bot messages of each importance in a channel. Unset levels fall back to <code>routing.default</code> in the config.</p>
+  <table>
+    <tr><th>Channel</th>{{range $.Data.Levels}}<th>{{.}}</th>{{end}}<th></th></tr>
+    {{range .Data.Items}}{{$item := .}}<tr>
+      <td>{{.Channel}}<br><small>{{.Source}}</small></td>
+      {{range $.Data.Levels}}<td>{{index $item.Policy .}}</td>{{end}}
+      <td><a href="?edit={{.Channel}}">{{if $.ReadOnly}}View{{else}}Edit{{end}}</a>
+        {{if and .Stored (not $.ReadOnly)}}<form method="post" action="/admin/routing/delete"><input type="hidden" name="channel" value="{{.Channel}}"><button class="danger">Delete</button></form>{{end}}</td>
+    </tr>{{else}}<tr><td colspan="6">No channel policies yet.</td></tr>{{end}}
+  </table>
+</section>
+<section>
+  {{with .Data.Form}}{{$policy := .Policy}}<h2>{{if .Channel}}Edit {{.Channel}}{{else}}New policy{{end}}</h2>
+  <form method="post" action="/admin/routing">
+    <label>Channel <input name="channel" value="{{.Channel}}" placeholder="Channel ID" required></label>
+    {{range $.Data.Levels}}{{$level := .}}<label>{{.}} <select name="{{.}}">
+      <option value="">(default)</option>
+      {{range $.Data.Routes}}<option {{if eq . (index $policy $level)}}selected{{end}}>{{.}}</option>{{end}}
+    </select></label>{{end}}
+    {{if not $.ReadOnly}}<p><button class="primary">Save</button></p>{{end}}
+  </form>{{end}}
+</section>{{end}}`,
+
+	"templates": `{{define "content"}}<section>
+  <h2>Channel greetings</h2>
+  <table>
+    <tr><th>Channel</th><th>Mode</th><th>Message</th><th></th></tr>
+    {{range .Data.Items}}<tr>
+      <td>{{.Channel}}<br><small>{{.Source}}</small></td>
+      <td>{{or .Greeting.Mode "ephemeral"}}</td>
+      <td>{{.Greeting.Message}}</td>
+      <td><a href="?edit={{.Channel}}">{{if $.ReadOnly}}View{{else}}Edit{{end}}</a>
+        {{if and .Stored (not $.ReadOnly)}}<form method="post" action="/admin/templates/delete"><input type="hidden" name="channel" value="{{.Channel}}"><button class="danger">Delete</button></form>{{end}}</td>
+    </tr>{{else}}<tr><td colspan="4">No greetings yet.</td></tr>{{end}}
+  </table>
+</section>
+<section>
+  {{with .Data.Form}}<h2>{{if .Channel}}Edit {{.Channel}}{{else}}New greeting{{end}}</h2>
+  <form method="post" action="/admin/templates" data-preview="/admin/preview/greeting">
+    <label>Channel <input name="channel" value="{{.Channel}}" placeholder="Channel ID" required></label>
+    <label>Mode <select name="mode">
+      {{$mode := or .Greeting.Mode "ephemeral"}}{{range $.Data.Modes}}<option {{if eq . $mode}}selected{{end}}>{{.}}</option>{{end}}
+    </select></label>
+    <label>Thread <input name="thread_ts" value="{{.Greeting.ThreadTS}}" placeholder="Message timestamp to reply under, for thread mode"></label>
+    <label>Message <textarea name="message" required>{{.Greeting.Message}}</textarea></label>
+    <p><small>The message is a template with <code>{{"{{.UserID}}"}}</code>, <code>{{"{{.ChannelID}}"}}</code> and <code>{{"{{.InviterID}}"}}</code>.</small></p>
+    {{if not $.ReadOnly}}<p><button class="primary">Save</button></p>{{end}}
+  </form>{{end}}
+  <h3>Preview</h3>
+  <div id="preview"></div>
+</section>{{end}}`,
+
+	"audit": `{{define "content"}}<section>
+  <h2>Audit log</h2>
+  <form method="get"><label>User <input name="user" value="{{.Data.User}}" placeholder="User ID; empty for everyone"></label></form>
+  <table>
+    <tr><th>When</th><th>User</th><th>Role</th><th>Action</th><th>Detail</th></tr>
+    {{range .Data.Entries}}<tr>
+      <td>{{.At.Format "2 Jan 2006 15:04:05 MST"}}</td><td>{{.UserID}}</td><td>{{.Role}}</td><td>{{.Action}}</td><td>{{.Detail}}</td>
+    </tr>{{else}}<tr><td colspan="5">Nothing recorded yet.</td></tr>{{end}}
+  </table>
+</section>{{end}}`,
+
+	"deliveries": `{{define "content"}}<section>
+  <h2>Deliveries</h2>
+  <p>The bot's last {{.Data.Max}} messages and what the routing policy did with them.</p>
+  <form method="get"><label>Channel <input name="channel" value="{{.Data.Channel}}" placeholder="Channel ID; empty for all"></label></form>
+  <table>
+    <tr><th>When</th><th>Channel</th><th>Route</th><th>Importance</th><th>Message</th></tr>
+    {{range .Data.Records}}<tr>
+      <td>{{.At.Format "2 Jan 2006 15:04:05 MST"}}</td>
+      <td>{{.Channel}}{{if .ThreadTS}}<br><small>in thread {{.ThreadTS}}</small>{{end}}</td>
+      <td>{{.Route}}</td><td>{{or .Importance "info"}}</td>
+      <td>{{.Text}}{{if .Error}}<p class="error">{{.Error}}</p>{{end}}</td>
+    </tr>{{else}}<tr><td colspan="5">No messages sent yet.</td></tr>{{end}}
+  </table>
+</section>{{end}}`,
+}
+
+// Parsed pages, keyed by name
+var webPages = func() map[string]*template.Template {
+	layout := template.Must(template.New("layout").Parse(webLayout))
+	pages := map[string]*template.Template{}
+	for name, source := range webPageSources {
+		pages[name] = template.Must(template.Must(layout.Clone()).Parse(source))
+	}
+	return pages
+}()
+
+const webPreviewFragment = `{{if .Error}}<p class="error">{{.Error}}</p>{{else}}
+{{if .Note}}<p class="context">{{.Note}}</p>{{end}}
+{{.Message}}
+{{if .BuilderURL}}<p><a href="{{.BuilderURL}}" target="_blank" rel="noopener">Open in Block Kit Builder</a></p>{{end}}{{end}}`
+
+var webPreviewTemplate = template.Must(template.New("preview").Parse(webPreviewFragment))
+
+// webPreview is what the preview pane shows
+type webPreview struct {
+	Error      string
+	Note       string
+	Message    template.HTML
+	BuilderURL string
+}
+
+func renderWebPage(c *gin.Context, status int, name string, page webPage) {
+	page.Section = name
+	page.UserID = c.GetString("webUser")
+	page.ReadOnly = c.GetString("webRole") == roleAuditor
+	var buf bytes.Buffer
+	if err := webPages[name].ExecuteTemplate(&buf, "layout", page); err != nil {
+		log.Printf("Error rendering web page %s: %v", name, err)
+		c.String(http.StatusInternalServerError, "Sorry, something went wrong showing this page.")
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// renderWebPreview answers a live preview request with the message as
+// Slack would roughly show it
+func renderWebPreview(c *gin.Context, preview webPreview) {
+	var buf bytes.Buffer
+	if err := webPreviewTemplate.Execute(&buf, preview); err != nil {
+		log.Printf("Error rendering web preview: %v", err)
+		c.String(http.StatusInternalServerError, "Sorry, something went wrong showing the preview.")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// previewOf builds the preview for a rendered message
+func previewOf(text string, blocks []slack.Block, note string) webPreview {
+	preview := webPreview{Note: note, Message: previewMessageHTML(text, blocks)}
+	if len(blocks) == 0 {
+		blocks = []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)}
+	}
+	preview.BuilderURL = blockKitBuilderURL(blocks)
+	return preview
+}
+
+// webItemSource says where a listed item comes from
+func webItemSource(stored, inConfig bool) string {
+	switch {
+	case stored && inConfig:
+		return "edited here, replaces the config"
+	case stored:
+		return "added here"
+	}
+	return "from the config"
+}
+
+// webRedirect logs a change and sends the browser back to the list
+func webRedirect(c *gin.Context, section, change string) {
+	log.Printf("Admin web UI change by %s: %s", c.GetString("webUser"), change)
+	recordAdminAudit(c.GetString("webUser"), roleAdmin, "web", change)
+	c.Redirect(http.StatusSeeOther, "/admin/"+section)
+}
+
+// splitIDs reads a comma or space separated list of IDs
+func splitIDs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// Triggers
+
+type webTriggerForm struct {
+	Name, Kind, Pattern, Response, Channels, Cooldown, Sample string
+}
+
+func (f webTriggerForm) trigger() (Trigger, error) {
+	trigger := Trigger{Name: f.Name, Response: f.Response, Channels: splitIDs(f.Channels)}
+	if f.Kind == "regex" {
+		trigger.Regex = f.Pattern
+	} else {
+		trigger.Keyword = f.Pattern
+	}
+	if f.Cooldown != "" {
+		cooldown, err := time.ParseDuration(f.Cooldown)
+		if err != nil {
+			return Trigger{}, fmt.Errorf("cooldown: %w", err)
+		}
+		trigger.Cooldown = cooldown
+	}
+	return trigger, trigger.validate()
+}
+
+func webTriggerFormFrom(c *gin.Context) webTriggerForm {
+	return webTriggerForm{
+		Name:     strings.TrimSpace(c.PostForm("name")),
+		Kind:     c.PostForm("kind"),
+		Pattern:  c.PostForm("pattern"),
+		Response: c.PostForm("response"),
+		Channels: c.PostForm("channels"),
+		Cooldown: strings.TrimSpace(c.PostForm("cooldown")),
+		Sample:   c.PostForm("sample"),
+	}
+}
+
+func handleWebTriggers(c *gin.Context) {
+	form := webTriggerForm{}
+	if name := c.Query("edit"); name != "" {
+		form.Name = name
+	}
+	renderWebTriggers(c, http.StatusOK, form, "")
+}
+
+func renderWebTriggers(c *gin.Context, status int, form webTriggerForm, errText string) {
+	triggers, err := loadTriggers()
+	if err != nil {
+		log.Printf("Error loading triggers: %v", err)
+		errText = "Sorry, something went wrong loading the triggers."
+	}
+	stored := store.Keys(triggersBucket)
+	type item struct {
+		Trigger Trigger
+		Source  string
+		Stored  bool
+	}
+	var items []item
+	for _, trigger := range triggers {
+		isStored := slices.Contains(stored, trigger.Name)
+		inConfig := slices.ContainsFunc(appConfig.Triggers, func(t Trigger) bool { return t.Name == trigger.Name })
+		items = append(items, item{Trigger: trigger, Source: webItemSource(isStored, inConfig), Stored: isStored})
+		// Fill the form when editing an existing trigger
+		if status == http.StatusOK && trigger.Name == form.Name {
+			form = webTriggerForm{Name: trigger.Name, Kind: "keyword", Pattern: trigger.Keyword, Response: trigger.Response,
+				Channels: strings.Join(trigger.Channels, ", ")}
+			if trigger.Regex != "" {
+				form.Kind, form.Pattern = "regex", trigger.Regex
+			}
+			if trigger.Cooldown > 0 {
+				form.Cooldown = trigger.Cooldown.String()
+			}
+		}
+	}
+	slices.SortFunc(items, func(a, b item) int { return strings.Compare(a.Trigger.Name, b.Trigger.Name) })
+	renderWebPage(c, status, "triggers", webPage{Error: errText, Data: map[string]any{"Items": items, "Form": form}})
+}
+
+func handleWebTriggerSave(c *gin.Context) {
+	form := webTriggerFormFrom(c)
+	trigger, err := form.trigger()
+	if err != nil {
+		renderWebTriggers(c, http.StatusBadRequest, form, "That trigger doesn't work: "+err.Error())
+		return
+	}
+	if err := store.Put(triggersBucket, trigger.Name, trigger); err != nil {
+		log.Printf("Error saving trigger: %v", err)
+		renderWebTriggers(c, http.StatusInternalServerError, form, "Sorry, something went wrong saving the trigger.")
+		return
+	}
+	webRedirect(c, "triggers", "saved trigger "+trigger.Name)
+}
+
+func handleWebTriggerDelete(c *gin.Context) {
+	name := c.PostForm("name")
+	if err := store.Delete(triggersBucket, name); err != nil {
+		log.Printf("Error removing trigger: %v", err)
+		renderWebTriggers(c, http.StatusInternalServerError, webTriggerForm{}, "Sorry, something went wrong removing the trigger.")
+		return
+	}
+	webRedirect(c, "triggers", "removed trigger "+name)
+}
+
+// handleWebTriggerPreview renders the response the trigger would give to
+// the sample message
+func handleWebTriggerPreview(c *gin.Context) {
+	form := webTriggerFormFrom(c)
+	trigger, err := form.trigger()
+	if err != nil {
+		renderWebPreview(c, webPreview{Error: err.Error()})
+		return
+	}
+	re, _ := trigger.pattern()
+	data := triggerMatch{User: c.GetString("webUser"), Text: form.Sample, Groups: make([]string, re.NumSubexp()+1), Named: map[string]string{}}
+	note := "Add a sample message to see what the trigger does with it."
+	if form.Sample != "" {
+		groups := re.FindStringSubmatch(form.Sample)
+		if groups == nil {
+			renderWebPreview(c, webPreview{Note: "The sample message doesn't match, so there's no response."})
+			return
+		}
+		data.Groups = groups
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				data.Named[name] = groups[i]
+			}
+		}
+		note = "The response to the sample message:"
+	}
+	text, err := renderTemplate("trigger "+trigger.Name, trigger.Response, data)
+	if err != nil {
+		renderWebPreview(c, webPreview{Error: err.Error()})
+		return
+	}
+	renderWebPreview(c, previewOf(text, nil, note))
+}
+
+// Schedules
+
+func webScheduleFrom(c *gin.Context) ScheduledPost {
+	return ScheduledPost{
+		Name:       strings.TrimSpace(c.PostForm("name")),
+		Cron:       strings.TrimSpace(c.PostForm("cron")),
+		Channel:    strings.TrimSpace(c.PostForm("channel")),
+		Timezone:   strings.TrimSpace(c.PostForm("timezone")),
+		Text:       c.PostForm("text"),
+		Blocks:     c.PostForm("blocks"),
+		Importance: importance(c.PostForm("importance")),
+	}
+}
+
+func handleWebSchedules(c *gin.Context) {
+	renderWebSchedules(c, http.StatusOK, ScheduledPost{Name: c.Query("edit")}, "")
+}
+
+func renderWebSchedules(c *gin.Context, status int, form ScheduledPost, errText string) {
+	schedules, err := loadSchedules()
+	if err != nil {
+		log.Printf("Error loading schedules: %v", err)
+		errText = "Sorry, something went wrong loading the schedules."
+	}
+	stored := store.Keys(schedulesBucket)
+	type item struct {
+		Post    ScheduledPost
+		NextRun string
+		Source  string
+		Stored  bool
+	}
+	var items []item
+	for _, post := range schedules {
+		isStored := slices.Contains(stored, post.Name)
+		inConfig := slices.ContainsFunc(appConfig.Schedules, func(p ScheduledPost) bool { return p.Name == post.Name })
+		items = append(items, item{Post: post, NextRun: describeNextRun(post), Source: webItemSource(isStored, inConfig), Stored: isStored})
+		if status == http.StatusOK && post.Name == form.Name {
+			form = post
+		}
+	}
+	slices.SortFunc(items, func(a, b item) int { return strings.Compare(a.Post.Name, b.Post.Name) })
+	renderWebPage(c, status, "schedules", webPage{Error: errText, Data: map[string]any{
+		"Items":  items,
+		"Form":   form,
+		"Levels": []importance{importanceInfo, importanceNotice, importanceWarning, importanceCritical},
+	}})
+}
+
+func handleWebScheduleSave(c *gin.Context) {
+	post := webScheduleFrom(c)
+	if post.Importance == importanceInfo {
+		post.Importance = ""
+	}
+	if err := post.validate(); err != nil {
+		renderWebSchedules(c, http.StatusBadRequest, post, "That schedule doesn't work: "+err.Error())
+		return
+	}
+	if err := store.Put(schedulesBucket, post.Name, post); err != nil {
+		log.Printf("Error saving schedule: %v", err)
+		renderWebSchedules(c, http.StatusInternalServerError, post, "Sorry, something went wrong saving the schedule.")
+		return
+	}
+	webRedirect(c, "schedules", "saved schedule "+post.Name)
+}
+
+func handleWebScheduleDelete(c *gin.Context) {
+	name := c.PostForm("name")
+	if err := store.Delete(schedulesBucket, name); err != nil {
+		log.Printf("Error removing schedule: %v", err)
+		renderWebSchedules(c, http.StatusInternalServerError, ScheduledPost{}, "Sorry, something went wrong removing the schedule.")
+		return
+	}
+	webRedirect(c, "schedules", "removed schedule "+name)
+}
+
+// handleWebSchedulePreview renders the post as it would go out next
+func handleWebSchedulePreview(c *gin.Context) {
+	post := webScheduleFrom(c)
+	if post.Text == "" && post.Blocks == "" {
+		renderWebPreview(c, webPreview{Note: "Add text or blocks to see the post."})
+		return
+	}
+	at := time.Now()
+	note := "How the post looks now."
+	if cron, err := parseCron(post.Cron); err == nil {
+		if loc, err := post.location(); err == nil {
+			if next := cron.next(at, loc); !next.IsZero() {
+				at = next
+				note = "How the next post, " + next.Format("Mon 2 Jan at 15:04 MST") + ", looks."
+			}
+		}
+	}
+	msg, err := post.render(at)
+	if err != nil {
+		renderWebPreview(c, webPreview{Error: err.Error()})
+		return
+	}
+	renderWebPreview(c, previewOf(msg.Text, msg.Blocks, note))
+}
+
+// Routing
+
+var (
+	routeLevels = []string{string(importanceInfo), string(importanceNotice), string(importanceWarning), string(importanceCritical)}
+	routeNames  = []string{routePost, routeThread, routeDigest, routeSuppress, routeDMOwner}
+)
+
+type webRoutingForm struct {
+	Channel string
+	Policy  map[string]string
+}
+
+func handleWebRouting(c *gin.Context) {
+	renderWebRouting(c, http.StatusOK, webRoutingForm{Channel: c.Query("edit")}, "")
+}
+
+func renderWebRouting(c *gin.Context, status int, form webRoutingForm, errText string) {
+	storedChannels := store.Keys(routingPoliciesBucket)
+	policies := map[string]map[string]string{}
+	for channel, policy := range appConfig.Routing.Channels {
+		policies[channel] = policy
+	}
+	for _, channel := range storedChannels {
+		var policy map[string]string
+		if _, err := store.Get(routingPoliciesBucket, channel, &policy); err != nil {
+			log.Printf("Error loading routing policy for %s: %v", channel, err)
+			continue
+		}
+		policies[channel] = policy
+	}
+
+	type item struct {
+		Channel string
+		Policy  map[string]string
+		Source  string
+		Stored  bool
+	}
+	var items []item
+	for channel, policy := range policies {
+		_, inConfig := appConfig.Routing.Channels[channel]
+		isStored := slices.Contains(storedChannels, channel)
+		items = append(items, item{Channel: channel, Policy: policy, Source: webItemSource(isStored, inConfig), Stored: isStored})
+	}
+	slices.SortFunc(items, func(a, b item) int { return strings.Compare(a.Channel, b.Channel) })
+	if status == http.StatusOK {
+		form.Policy = policies[form.Channel]
+	}
+	if form.Policy == nil {
+		form.Policy = map[string]string{}
+	}
+	renderWebPage(c, status, "routing", webPage{Error: errText, Data: map[string]any{
+		"Items":  items,
+		"Form":   form,
+		"Levels": routeLevels,
+		"Routes": routeNames,
+	}})
+}
+
+func handleWebRoutingSave(c *gin.Context) {
+	form := webRoutingForm{Channel: strings.TrimSpace(c.PostForm("channel")), Policy: map[string]string{}}
+	for _, level := range routeLevels {
+		route := c.PostForm(level)
+		if route == "" {
+			continue
+		}
+		if !slices.Contains(routeNames, route) {
+			renderWebRouting(c, http.StatusBadRequest, form, fmt.Sprintf("%q isn't a route.", route))
+			return
+		}
+		form.Policy[level] = route
+	}
+	if form.Channel == "" {
+		renderWebRouting(c, http.StatusBadRequest, form, "Say which channel the policy is for.")
+		return
+	}
+	if err := store.Put(routingPoliciesBucket, form.Channel, form.Policy); err != nil {
+		log.Printf("Error saving routing policy: %v", err)
+		renderWebRouting(c, http.StatusInternalServerError, form, "Sorry, something went wrong saving the routing policy.")
+		return
+	}
+	webRedirect(c, "routing", "saved routing policy for "+form.Channel)
+}
+
+func handleWebRoutingDelete(c *gin.Context) {
+	channel := c.PostForm("channel")
+	if err := store.Delete(routingPoliciesBucket, channel); err != nil {
+		log.Printf("Error removing routing policy: %v", err)
+		renderWebRouting(c, http.StatusInternalServerError, webRoutingForm{}, "Sorry, something went wrong removing the routing policy.")
+		return
+	}
+	webRedirect(c, "routing", "removed routing policy for "+channel)
+}
+
+// Greeting templates
+
+type webGreetingForm struct {
+	Channel  string
+	Greeting ChannelGreeting
+}
+
+func webGreetingFormFrom(c *gin.Context) webGreetingForm {
+	greeting := ChannelGreeting{Mode: c.PostForm("mode"), ThreadTS: strings.TrimSpace(c.PostForm("thread_ts")), Message: c.PostForm("message")}
+	if greeting.Mode == greetingEphemeral {
+		greeting.Mode = ""
+	}
+	return webGreetingForm{Channel: strings.TrimSpace(c.PostForm("channel")), Greeting: greeting}
+}
+
+func (f webGreetingForm) render() (string, error) {
+	return renderTemplate("greeting", f.Greeting.Message, greetingData{UserID: "USAMPLE", ChannelID: f.Channel, InviterID: "UINVITER"})
+}
+
+func handleWebGreetings(c *gin.Context) {
+	renderWebGreetings(c, http.StatusOK, webGreetingForm{Channel: c.Query("edit")}, "")
+}
+
+func renderWebGreetings(c *gin.Context, status int, form webGreetingForm, errText string) {
+	stored := store.Keys(greetingsBucket)
+	channels := slices.Clone(stored)
+	for channel := range appConfig.Greetings.Channels {
+		if !slices.Contains(channels, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	slices.Sort(channels)
+
+	type item struct {
+		Channel  string
+		Greeting ChannelGreeting
+		Source   string
+		Stored   bool
+	}
+	var items []item
+	for _, channel := range channels {
+		greeting, _ := channelGreeting(channel)
+		_, inConfig := appConfig.Greetings.Channels[channel]
+		isStored := slices.Contains(stored, channel)
+		items = append(items, item{Channel: channel, Greeting: greeting, Source: webItemSource(isStored, inConfig), Stored: isStored})
+		if status == http.StatusOK && channel == form.Channel {
+			form.Greeting = greeting
+		}
+	}
+	renderWebPage(c, status, "templates", webPage{Error: errText, Data: map[string]any{
+		"Items": items,
+		"Form":  form,
+		"Modes": []string{greetingEphemeral, greetingThread, greetingChannel},
+	}})
+}
+
+func handleWebGreetingSave(c *gin.Context) {
+	form := webGreetingFormFrom(c)
+	if form.Channel == "" || form.Greeting.Message == "" {
+		renderWebGreetings(c, http.StatusBadRequest, form, "Channel and message are required.")
+		return
+	}
+	if _, err := form.render(); err != nil {
+		renderWebGreetings(c, http.StatusBadRequest, form, "That greeting doesn't work: "+err.Error())
+		return
+	}
+	if err := store.Put(greetingsBucket, form.Channel, form.Greeting); err != nil {
+		log.Printf("Error saving greeting: %v", err)
+		renderWebGreetings(c, http.StatusInternalServerError, form, "Sorry, something went wrong saving the greeting.")
+		return
+	}
+	webRedirect(c, "templates", "saved greeting for "+form.Channel)
+}
+
+func handleWebGreetingDelete(c *gin.Context) {
+	channel := c.PostForm("channel")
+	if err := store.Delete(greetingsBucket, channel); err != nil {
+		log.Printf("Error removing greeting: %v", err)
+		renderWebGreetings(c, http.StatusInternalServerError, webGreetingForm{}, "Sorry, something went wrong removing the greeting.")
+		return
+	}
+	webRedirect(c, "templates", "removed greeting for "+channel)
+}
+
+// handleWebGreetingPreview renders the greeting for a sample newcomer
+func handleWebGreetingPreview(c *gin.Context) {
+	form := webGreetingFormFrom(c)
+	if form.Greeting.Message == "" {
+		renderWebPreview(c, webPreview{Note: "Write a message to see the greeting."})
+		return
+	}
+	text, err := form.render()
+	if err != nil {
+		renderWebPreview(c, webPreview{Error: err.Error()})
+		return
+	}
+	note := "Only the newcomer sees this greeting."
+	switch form.Greeting.Mode {
+	case greetingThread:
+		note = "Posted in the thread for everyone to see."
+	case greetingChannel:
+		note = "Posted in the channel for everyone to see."
+	}
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)}
+	renderWebPreview(c, previewOf(text, blocks, note))
+}
+
+// Audit log and deliveries
+
+// How many audit log entries the web UI shows
+const webAuditLogLimit = 200
+
+func handleWebAuditLog(c *gin.Context) {
+	user := strings.TrimSpace(c.Query("user"))
+	entries, err := adminAuditLog(user, webAuditLogLimit)
+	errText := ""
+	if err != nil {
+		log.Printf("Error loading admin audit log: %v", err)
+		errText = "Sorry, something went wrong loading the audit log."
+	}
+	renderWebPage(c, http.StatusOK, "audit", webPage{Error: errText, Data: map[string]any{"Entries": entries, "User": user}})
+}
+
+func handleWebDeliveries(c *gin.Context) {
+	channel := strings.TrimSpace(c.Query("channel"))
+	records, err := recentDeliveries(channel)
+	errText := ""
+	if err != nil {
+		log.Printf("Error loading delivery history: %v", err)
+		errText = "Sorry, something went wrong loading the delivery history."
+	}
+	renderWebPage(c, http.StatusOK, "deliveries", webPage{Error: errText, Data: map[string]any{"Records": records, "Channel": channel, "Max": maxDeliveries}})
+}
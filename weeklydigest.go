@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for when each channel's weekly digest last went out, keyed
+// by channel ID
+const weeklyDigestsBucket = "weekly_digests"
+
+// Used when the weekly_digest settings aren't set
+const (
+	defaultWeeklyDigestSchedule = "0 9 * * mon"
+	defaultWeeklyDigestThreads  = 5
+)
+
+// Most links listed in a digest
+const weeklyDigestLinks = 10
+
+// Most messages read for one digest
+const weeklyDigestMaxMessages = 5000
+
+// weeklyDigestState is when a channel's digest last went out
+type weeklyDigestState struct {
+	Channel string    `json:"channel"`
+	LastRun time.Time `json:"last_run"`
+	// Members is how many members the channel had then
+	Members int `json:"members"`
+}
+
+// weeklyDigest summarizes a channel's week
+type weeklyDigest struct {
+	Channel   string
+	From      time.Time
+	Messages  int
+	Posters   int
+	Threads   []slack.Message
+	Links     []string
+	Joined    int
+	Left      int
+	Members   int
+	Truncated bool
+}
+
+func init() {
+	registerBotCommand(&command{
+		Name:        "admin weekly-digest",
+		Usage:       "admin weekly-digest [#channel]",
+		Description: "Post the weekly channel digest now",
+		AdminOnly:   true,
+		Handler:     handleWeeklyDigestNow,
+	})
+}
+
+// startWeeklyDigests posts each weekly_digest channel's digest when
+// weekly_digest.schedule comes round
+func startWeeklyDigests() {
+	if len(appConfig.WeeklyDigest.Channels) == 0 {
+		return
+	}
+	runEvery("weekly digests", time.Minute, runDueWeeklyDigests)
+}
+
+// weeklyDigestSchedule parses weekly_digest.schedule and
+// weekly_digest.timezone
+func weeklyDigestSchedule() (cronSchedule, *time.Location, error) {
+	cfg := appConfig.WeeklyDigest
+	expr := cfg.Schedule
+	if expr == "" {
+		expr = defaultWeeklyDigestSchedule
+	}
+	cron, err := parseCron(expr)
+	if err != nil {
+		return cronSchedule{}, nil, fmt.Errorf("weekly_digest.schedule: %w", err)
+	}
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return cronSchedule{}, nil, fmt.Errorf("weekly_digest.timezone: %w", err)
+		}
+	}
+	return cron, loc, nil
+}
+
+func runDueWeeklyDigests() {
+	cron, loc, err := weeklyDigestSchedule()
+	if err != nil {
+		log.Printf("Error scheduling weekly digests: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, channel := range appConfig.WeeklyDigest.Channels {
+		state := weeklyDigestState{Channel: channel}
+		if _, err := store.Get(weeklyDigestsBucket, channel, &state); err != nil {
+			log.Printf("Error loading weekly digest for %s: %v", channel, err)
+			continue
+		}
+		if state.LastRun.IsZero() {
+			// The first digest waits for the schedule rather than going
+			// out the moment it's set up
+			state.LastRun = now.UTC()
+			if err := store.Put(weeklyDigestsBucket, channel, state); err != nil {
+				log.Printf("Error saving weekly digest for %s: %v", channel, err)
+			}
+			continue
+		}
+		if next := cron.next(state.LastRun, loc); next.IsZero() || now.Before(next) {
+			continue
+		}
+		if err := postWeeklyDigest(channel); err != nil {
+			log.Printf("Error posting weekly digest for %s: %v", channel, err)
+		}
+	}
+}
+
+func handleWeeklyDigestNow(req commandRequest) commandResponse {
+	channels := appConfig.WeeklyDigest.Channels
+	if len(req.Args) > 0 {
+		m := channelMentionPattern.FindStringSubmatch(req.Args[0])
+		if m == nil {
+			return ephemeral("Usage: `%s admin weekly-digest [#channel]`", botCommand)
+		}
+		channels = []string{m[1]}
+	}
+	if len(channels) == 0 {
+		return ephemeral("Weekly digests aren't set up. See `weekly_digest` in the config.")
+	}
+	recordAdminAudit(req.UserID, roleAdmin, "weekly digest", "posted the weekly digest in "+strings.Join(channels, ", "))
+	go runJob("weekly digests", func() {
+		for _, channel := range channels {
+			if err := postWeeklyDigest(channel); err != nil {
+				log.Printf("Error posting weekly digest for %s: %v", channel, err)
+			}
+		}
+	})
+	return ephemeral(":newspaper: Putting the digest together now.")
+}
+
+// postWeeklyDigest summarizes the channel's last seven days into it and
+// remembers it did
+func postWeeklyDigest(channel string) error {
+	state := weeklyDigestState{Channel: channel}
+	if _, err := store.Get(weeklyDigestsBucket, channel, &state); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	digest, err := buildWeeklyDigest(channel, now.AddDate(0, 0, -7), now)
+	if err != nil {
+		return err
+	}
+	if _, err := sendInWindow("weekly_digest", outboundMessage{Channel: channel, Importance: importanceInfo, Text: "Weekly digest", Blocks: weeklyDigestBlocks(digest, state.Members)}); err != nil {
+		return err
+	}
+	state.LastRun, state.Members = now, digest.Members
+	return store.Put(weeklyDigestsBucket, channel, state)
+}
+
+// buildWeeklyDigest reads the channel's history between from and until
+func buildWeeklyDigest(channel string, from, until time.Time) (weeklyDigest, error) {
+	digest := weeklyDigest{Channel: channel, From: from}
+	info, err := slackClient.GetConversationInfo(&slack.GetConversationInfoInput{ChannelID: channel, IncludeNumMembers: true})
+	if err != nil {
+		return digest, fmt.Errorf("looking up channel %s: %w", channel, err)
+	}
+	digest.Members = info.NumMembers
+
+	params := &slack.GetConversationHistoryParameters{
+		ChannelID: channel,
+		Oldest:    fmt.Sprintf("%d.000000", from.Unix()),
+		Latest:    fmt.Sprintf("%d.000000", until.Unix()),
+		Limit:     200,
+	}
+	posters := map[string]bool{}
+	seenLinks := map[string]bool{}
+	for {
+		page, err := slackClient.GetConversationHistory(params)
+		if err != nil {
+			return digest, fmt.Errorf("fetching history of %s: %w", channel, err)
+		}
+		for _, msg := range page.Messages {
+			switch msg.SubType {
+			case "channel_join":
+				digest.Joined++
+				continue
+			case "channel_leave":
+				digest.Left++
+				continue
+			case "", "thread_broadcast":
+			default:
+				continue
+			}
+			if msg.BotID != "" || msg.User == botUserID {
+				continue
+			}
+			digest.Messages++
+			posters[msg.User] = true
+			if msg.ReplyCount > 0 || len(msg.Reactions) > 0 {
+				digest.Threads = append(digest.Threads, msg)
+			}
+			for _, m := range slackMarkupPattern.FindAllStringSubmatch(msg.Text, -1) {
+				link := m[2]
+				if m[1] != "" || !strings.HasPrefix(link, "http") || seenLinks[link] {
+					continue
+				}
+				seenLinks[link] = true
+				digest.Links = append(digest.Links, link)
+			}
+		}
+		if digest.Messages >= weeklyDigestMaxMessages {
+			digest.Truncated = true
+			break
+		}
+		if !page.HasMore || page.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.ResponseMetaData.NextCursor
+	}
+	digest.Posters = len(posters)
+
+	slices.SortStableFunc(digest.Threads, func(a, b slack.Message) int { return weeklyDigestScore(b) - weeklyDigestScore(a) })
+	threads := appConfig.WeeklyDigest.TopThreads
+	if threads <= 0 {
+		threads = defaultWeeklyDigestThreads
+	}
+	digest.Threads = digest.Threads[:min(len(digest.Threads), threads)]
+	// History is newest first; the oldest links read better first
+	slices.Reverse(digest.Links)
+	digest.Links = digest.Links[:min(len(digest.Links), weeklyDigestLinks)]
+	return digest, nil
+}
+
+// weeklyDigestScore ranks a message by its replies and reactions
+func weeklyDigestScore(msg slack.Message) int {
+	score := msg.ReplyCount
+	for _, r := range msg.Reactions {
+		score += r.Count
+	}
+	return score
+}
+
+// weeklyDigestBlocks renders the digest. previousMembers is the member
+// count at the last digest, or 0 before the first.
+func weeklyDigestBlocks(d weeklyDigest, previousMembers int) []slack.Block {
+	text := func(s string) *slack.TextBlockObject {
+		s, _ = truncateText(s, maxSectionText)
+		return slack.NewTextBlockObject(slack.MarkdownType, s, false, false)
+	}
+	stats := fmt.Sprintf("%s from %s · %s joined, %d left · %s now",
+		plural(d.Messages, "message"), plural(d.Posters, "person", "people"), plural(d.Joined, "person", "people"), d.Left, plural(d.Members, "member"))
+	if previousMembers > 0 && d.Members != previousMembers {
+		stats += fmt.Sprintf(" (%+d since the last digest)", d.Members-previousMembers)
+	}
+	if d.Truncated {
+		stats += fmt.Sprintf(" · only the latest %d messages were read", weeklyDigestMaxMessages)
+	}
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "Your week in review", false, false)),
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("<#%s> since %s", d.Channel, slackDate(d.From)), false, false)),
+		slack.NewSectionBlock(text(":bar_chart: "+stats), nil, nil),
+	}
+	if d.Messages == 0 {
+		return append(blocks, slack.NewSectionBlock(text("A quiet week. Nobody posted here."), nil, nil))
+	}
+
+	if len(d.Threads) > 0 {
+		lines := []string{"*:fire: Top threads*"}
+		for i, msg := range d.Threads {
+			snippet, _ := truncateText(strings.Join(strings.Fields(msg.Text), " "), 80)
+			if link, err := slackClient.GetPermalink(&slack.PermalinkParameters{Channel: d.Channel, Ts: msg.Timestamp}); err != nil {
+				log.Printf("Error linking digest thread: %v", err)
+			} else {
+				snippet = "<" + link + "|" + strings.NewReplacer("<", "", ">", "", "|", "").Replace(snippet) + ">"
+			}
+			reactions := weeklyDigestScore(msg) - msg.ReplyCount
+			lines = append(lines, fmt.Sprintf("%d. %s by <@%s>: %s, %s", i+1, snippet, msg.User, plural(msg.ReplyCount, "reply", "replies"), plural(reactions, "reaction")))
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), slack.NewSectionBlock(text(strings.Join(lines, "\n")), nil, nil))
+	}
+	if len(d.Links) > 0 {
+		lines := []string{"*:link: Shared links*"}
+		for _, link := range d.Links {
+			lines = append(lines, "• "+link)
+		}
+		blocks = append(blocks, slack.NewDividerBlock(), slack.NewSectionBlock(text(strings.Join(lines, "\n")), nil, nil))
+	}
+	return blocks
+}
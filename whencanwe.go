@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Store bucket for availability polls, keyed by poll ID
+const availabilityBucket = "availability_polls"
+
+// Action IDs of the availability poll buttons
+const (
+	actionAvailabilityToggle = "availability_toggle"
+	actionAvailabilityClose  = "availability_close"
+)
+
+// Limits that keep the grid within a message's blocks and readable
+const (
+	maxAvailabilityDates = 10
+	maxAvailabilityTimes = 8
+)
+
+// Used when --for isn't given
+const defaultAvailabilityLength = time.Hour
+
+// availabilityPoll asks when people can make a meeting
+type availabilityPoll struct {
+	ID       string             `json:"id"`
+	Title    string             `json:"title"`
+	Creator  string             `json:"creator"`
+	Channel  string             `json:"channel"`
+	Timezone string             `json:"timezone"`
+	Length   time.Duration      `json:"length"`
+	Slots    []availabilitySlot `json:"slots"`
+	// Available holds the indexes of the slots each user can make, keyed
+	// by user ID
+	Available map[string][]int `json:"available"`
+	// Winner is the chosen slot's index once the poll is closed
+	Winner    *int      `json:"winner,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// availabilitySlot is a date, or a date and time, people can pick
+type availabilitySlot struct {
+	Start  time.Time `json:"start"`
+	AllDay bool      `json:"all_day,omitempty"`
+}
+
+// Serializes updates, which read and rewrite the whole poll
+var availabilityMu sync.Mutex
+
+func init() {
+	registerSlashCommand(&command{
+		Name:        "/whencanwe",
+		Usage:       `/whencanwe ["Title"] <dates...> [times...] [--for 30m] [Area/City]`,
+		Description: "Find a time that suits everyone with a grid of date and time buttons",
+		Handler:     handleWhenCanWe,
+	})
+	registerBlockAction(actionAvailabilityToggle, handleAvailabilityToggle)
+	registerBlockAction(actionAvailabilityClose, handleAvailabilityClose)
+	registerUserDataset(userDataset{Name: "availability", Title: "Meeting availability", Describe: describeUserAvailability, Delete: deleteUserAvailability})
+}
+
+func handleWhenCanWe(req commandRequest) commandResponse {
+	usage := fmt.Sprintf(`Usage: %s ["Title"] <dates...> [times...] [--for 30m] [Area/City], like %s "Planning" mon tue 10:00 14:00`, req.Command, req.Command)
+	args, loc, ok := parseTimezoneSuffix(parseQuotedArgs(req.Text))
+	if !ok {
+		loc = userLocationOr(req.UserID, workspaceLocation())
+	}
+	p := &availabilityPoll{
+		ID: newInteractionToken(), Creator: req.UserID, Channel: req.ChannelID, Timezone: loc.String(),
+		Length: defaultAvailabilityLength, Available: map[string][]int{}, CreatedAt: time.Now().UTC(),
+	}
+	now := time.Now().In(loc)
+	var dates []time.Time
+	var clocks, title []string
+	for i := 0; i < len(args); i++ {
+		arg := strings.TrimSpace(args[i])
+		if arg == "" {
+			continue
+		}
+		if arg == "--for" && i+1 < len(args) {
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return ephemeral("%q isn't a meeting length I understand; try `--for 30m`.", args[i+1])
+			}
+			p.Length = d
+			i++
+			continue
+		}
+		if date, ok := parseAvailabilityDate(arg, now); ok {
+			dates = append(dates, date)
+			continue
+		}
+		if clock, err := parseClock(arg); err == nil {
+			clocks = append(clocks, clock)
+			continue
+		}
+		title = append(title, arg)
+	}
+	if len(dates) == 0 {
+		return ephemeral("%s", usage)
+	}
+	if len(dates) > maxAvailabilityDates || len(clocks) > maxAvailabilityTimes {
+		return ephemeral("Polls can have at most %d dates and %d times.", maxAvailabilityDates, maxAvailabilityTimes)
+	}
+	slices.SortFunc(dates, func(a, b time.Time) int { return a.Compare(b) })
+	dates = slices.Compact(dates)
+	slices.Sort(clocks)
+	clocks = slices.Compact(clocks)
+	p.Title = strings.Join(title, " ")
+	if p.Title == "" {
+		p.Title = "Meeting"
+	}
+	for _, date := range dates {
+		if len(clocks) == 0 {
+			p.Slots = append(p.Slots, availabilitySlot{Start: date.UTC(), AllDay: true})
+		}
+		for _, clock := range clocks {
+			p.Slots = append(p.Slots, availabilitySlot{Start: atClock(date, clock).UTC()})
+		}
+	}
+
+	if err := store.Put(availabilityBucket, p.ID, p); err != nil {
+		log.Printf("Error saving availability poll: %v", err)
+		return ephemeral("Sorry, something went wrong creating the poll.")
+	}
+	return commandResponse{Text: "When can we: " + p.Title, Blocks: availabilityBlocks(p), InChannel: true, Result: p.ID}
+}
+
+// parseAvailabilityDate understands 2024-01-31, today, tomorrow and day
+// names, which mean the next such day from today
+func parseAvailabilityDate(s string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch strings.ToLower(s) {
+	case "today":
+		return today, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), true
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+		return t, true
+	}
+	day, ok := parseWeekday(s)
+	if !ok {
+		return time.Time{}, false
+	}
+	ahead := (int(weekdayNames[day]) - int(today.Weekday()) + 7) % 7
+	return today.AddDate(0, 0, ahead), true
+}
+
+func loadAvailabilityPoll(id string) (*availabilityPoll, error) {
+	p := &availabilityPoll{}
+	found, err := store.Get(availabilityBucket, id, p)
+	if err != nil || !found {
+		return nil, err
+	}
+	if p.Available == nil {
+		p.Available = map[string][]int{}
+	}
+	return p, nil
+}
+
+func (p *availabilityPoll) location() *time.Location {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// counts returns how many people can make each slot
+func (p *availabilityPoll) counts() []int {
+	counts := make([]int, len(p.Slots))
+	for _, slots := range p.Available {
+		for _, i := range slots {
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// best returns the slot most people can make, the earliest on a tie, or
+// -1 if nobody has answered
+func (p *availabilityPoll) best() int {
+	counts := p.counts()
+	best := -1
+	for i, n := range counts {
+		if n > 0 && (best < 0 || n > counts[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// people lists who can make slot i
+func (p *availabilityPoll) people(i int) []string {
+	var users []string
+	for user, slots := range p.Available {
+		if slices.Contains(slots, i) {
+			users = append(users, user)
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+// slotLabel shows slot i in the poll's timezone
+func (p *availabilityPoll) slotLabel(i int) string {
+	start := p.Slots[i].Start.In(p.location())
+	if p.Slots[i].AllDay {
+		return start.Format("Mon 2 Jan")
+	}
+	return start.Format("Mon 2 Jan 15:04")
+}
+
+// handleAvailabilityToggle marks the clicker as able, or no longer able,
+// to make a slot
+func handleAvailabilityToggle(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	id, indexStr, _ := strings.Cut(action.Value, ":")
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		log.Printf("Invalid availability value %q", action.Value)
+		return
+	}
+
+	availabilityMu.Lock()
+	p, err := loadAvailabilityPoll(id)
+	open := p != nil && p.Winner == nil
+	if err == nil && open && index >= 0 && index < len(p.Slots) {
+		slots := p.Available[callback.User.ID]
+		if slices.Contains(slots, index) {
+			slots = slices.DeleteFunc(slots, func(i int) bool { return i == index })
+		} else {
+			slots = append(slots, index)
+		}
+		if len(slots) == 0 {
+			delete(p.Available, callback.User.ID)
+		} else {
+			p.Available[callback.User.ID] = slots
+		}
+		err = store.Put(availabilityBucket, p.ID, p)
+	}
+	availabilityMu.Unlock()
+	if err != nil {
+		log.Printf("Error recording availability: %v", err)
+		return
+	}
+	if !open {
+		pollReply(callback, "This poll is closed.")
+		return
+	}
+	updateAvailabilityMessage(callback, p)
+}
+
+// handleAvailabilityClose picks the slot most people can make and offers
+// calendar links for it, if the clicker created the poll or is an admin
+func handleAvailabilityClose(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	availabilityMu.Lock()
+	p, err := loadAvailabilityPoll(action.Value)
+	allowed := p != nil && (callback.User.ID == p.Creator || isAdmin(callback.User.ID))
+	best := -1
+	if err == nil && allowed && p.Winner == nil {
+		if best = p.best(); best >= 0 {
+			p.Winner = &best
+			err = store.Put(availabilityBucket, p.ID, p)
+		}
+	}
+	availabilityMu.Unlock()
+	if err != nil {
+		log.Printf("Error closing availability poll: %v", err)
+		return
+	}
+	switch {
+	case p == nil:
+		pollReply(callback, "I couldn't find this poll any more.")
+		return
+	case !allowed:
+		pollReply(callback, "Only the person who started the poll or a bot admin can pick the slot.")
+		return
+	case p.Winner == nil:
+		pollReply(callback, "Nobody has said when they're free yet.")
+		return
+	}
+	updateAvailabilityMessage(callback, p)
+	if best < 0 {
+		return
+	}
+	text := fmt.Sprintf(":calendar: *%s* is on %s. See you there %s!", p.Title, availabilitySlotDate(p, best), mentionList(p.people(best)))
+	if _, err := sendMessage(outboundMessage{Channel: p.Channel, ThreadTS: callback.Container.MessageTs, Broadcast: true, Importance: importanceInfo, Text: text}); err != nil {
+		log.Printf("Error announcing availability winner: %v", err)
+	}
+}
+
+// availabilitySlotDate shows slot i in each reader's timezone
+func availabilitySlotDate(p *availabilityPoll, i int) string {
+	slot := p.Slots[i]
+	if slot.AllDay {
+		return p.slotLabel(i)
+	}
+	return slackDate(slot.Start)
+}
+
+func updateAvailabilityMessage(callback *slack.InteractionCallback, p *availabilityPoll) {
+	reply := &slack.WebhookMessage{
+		ReplaceOriginal: true,
+		Text:            "When can we: " + p.Title,
+		Blocks:          &slack.Blocks{BlockSet: availabilityBlocks(p)},
+	}
+	if err := respond(callback.ResponseURL, reply); err != nil {
+		log.Printf("Error updating availability poll: %v", err)
+	}
+}
+
+// availabilityBlocks shows a row of buttons per date while the poll is
+// open, and the chosen slot with calendar links once it's closed
+func availabilityBlocks(p *availabilityPoll) []slack.Block {
+	responded := fmt.Sprintf("%d people answered", len(p.Available))
+	if len(p.Available) == 1 {
+		responded = "1 person answered"
+	}
+	details := slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType,
+		fmt.Sprintf("Asked by <@%s> · %s · times in %s", p.Creator, responded, p.Timezone), false, false))
+
+	if p.Winner != nil {
+		i := *p.Winner
+		people := p.people(i)
+		text := fmt.Sprintf(":calendar: *%s*\nPicked *%s*, which suits %d of %d:\n%s", p.Title, p.slotLabel(i), len(people), len(p.Available), mentionList(people))
+		google := slack.NewButtonBlockElement("availability_google", p.ID, slack.NewTextBlockObject(slack.PlainTextType, "Add to Google Calendar", false, false))
+		google.URL = googleCalendarLink(p, i)
+		outlook := slack.NewButtonBlockElement("availability_outlook", p.ID, slack.NewTextBlockObject(slack.PlainTextType, "Add to Outlook", false, false))
+		outlook.URL = outlookCalendarLink(p, i)
+		return []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+			slack.NewActionBlock("availability_calendar", google, outlook),
+			details,
+		}
+	}
+
+	blocks := []slack.Block{slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType,
+		fmt.Sprintf(":calendar: *When can we: %s?*\nClick every slot you can make. Click again to take it back.", p.Title), false, false), nil, nil)}
+	counts := p.counts()
+	best := p.best()
+	var row []slack.BlockElement
+	day := ""
+	flush := func() {
+		if len(row) > 0 {
+			blocks = append(blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "*"+day+"*", false, false)), slack.NewActionBlock("", row...))
+		}
+		row = nil
+	}
+	for i, slot := range p.Slots {
+		start := slot.Start.In(p.location())
+		label := start.Format("15:04")
+		if slot.AllDay {
+			label = start.Format("Mon 2 Jan")
+		} else if d := start.Format("Mon 2 Jan"); d != day {
+			flush()
+			day = d
+		}
+		if counts[i] > 0 {
+			label += fmt.Sprintf(" · %d", counts[i])
+		}
+		button := slack.NewButtonBlockElement(actionAvailabilityToggle, fmt.Sprintf("%s:%d", p.ID, i), slack.NewTextBlockObject(slack.PlainTextType, label, false, false))
+		if best >= 0 && counts[i] == counts[best] {
+			button = button.WithStyle(slack.StylePrimary)
+		}
+		row = append(row, button)
+	}
+	if p.Slots[0].AllDay {
+		day = "Dates"
+	}
+	flush()
+	return append(blocks,
+		details,
+		slack.NewActionBlock("",
+			slack.NewButtonBlockElement(actionAvailabilityClose, p.ID, slack.NewTextBlockObject(slack.PlainTextType, "Pick the best slot", false, false)),
+		),
+	)
+}
+
+// availabilitySlotTimes returns when slot i starts and ends, with all-day
+// slots lasting the whole day
+func availabilitySlotTimes(p *availabilityPoll, i int) (time.Time, time.Time) {
+	slot := p.Slots[i]
+	if slot.AllDay {
+		start := slot.Start.In(p.location())
+		return start, start.AddDate(0, 0, 1)
+	}
+	return slot.Start, slot.Start.Add(p.Length)
+}
+
+// googleCalendarLink opens a new Google Calendar event for slot i
+func googleCalendarLink(p *availabilityPoll, i int) string {
+	start, end := availabilitySlotTimes(p, i)
+	dates := start.UTC().Format("20060102T150405Z") + "/" + end.UTC().Format("20060102T150405Z")
+	if p.Slots[i].AllDay {
+		dates = start.Format("20060102") + "/" + end.Format("20060102")
+	}
+	q := url.Values{"action": {"TEMPLATE"}, "text": {p.Title}, "dates": {dates}}
+	return "https://calendar.google.com/calendar/render?" + q.Encode()
+}
+
+// outlookCalendarLink opens a new Outlook event for slot i
+func outlookCalendarLink(p *availabilityPoll, i int) string {
+	start, end := availabilitySlotTimes(p, i)
+	q := url.Values{"path": {"/calendar/action/compose"}, "rru": {"addevent"}, "subject": {p.Title}}
+	if p.Slots[i].AllDay {
+		q.Set("startdt", start.Format(time.DateOnly))
+		q.Set("enddt", end.Format(time.DateOnly))
+		q.Set("allday", "true")
+	} else {
+		q.Set("startdt", start.UTC().Format(time.RFC3339))
+		q.Set("enddt", end.UTC().Format(time.RFC3339))
+	}
+	return "https://outlook.office.com/calendar/0/deeplink/compose?" + q.Encode()
+}
+
+func describeUserAvailability(userID string) (string, error) {
+	polls, err := storeList[availabilityPoll](store, availabilityBucket)
+	if err != nil {
+		return "", err
+	}
+	var items []string
+	for _, p := range polls {
+		if slots := p.Available[userID]; len(slots) > 0 {
+			items = append(items, fmt.Sprintf("%s: %s", p.Title, plural(len(slots), "slot")))
+		}
+	}
+	return describeItems(items), nil
+}
+
+func deleteUserAvailability(userID string) error {
+	availabilityMu.Lock()
+	defer availabilityMu.Unlock()
+	for _, id := range store.Keys(availabilityBucket) {
+		p, err := loadAvailabilityPoll(id)
+		if err != nil {
+			return err
+		}
+		if p == nil || p.Available[userID] == nil {
+			continue
+		}
+		delete(p.Available, userID)
+		if err := store.Put(availabilityBucket, id, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// workflowStepHandler runs one of the bot's Workflow Builder steps with the
+// inputs configured in the workflow and returns the step's outputs
+type workflowStepHandler func(inputs map[string]any) (outputs map[string]string, err error)
+
+// Workflow steps, keyed by the callback_id of the function in the app manifest
+var workflowSteps = map[string]workflowStepHandler{}
+
+// registerWorkflowStep makes handler run when a workflow reaches the step
+// (custom function) with callbackID
+func registerWorkflowStep(callbackID string, handler workflowStepHandler) {
+	workflowSteps[callbackID] = handler
+}
+
+func init() {
+	registerWorkflowStep("post_templated_message", runPostTemplatedMessageStep)
+}
+
+// handleFunctionExecuted runs a workflow step and reports the result back to
+// the workflow. Steps call out to other services, so they run after the
+// event is acked.
+func handleFunctionExecuted(ev *slackevents.FunctionExecutedEvent) {
+	handler, ok := workflowSteps[ev.Function.CallbackID]
+	if !ok {
+		log.Printf("Unknown workflow step: %s", ev.Function.CallbackID)
+		return
+	}
+	go runJob("workflow step", func() { runWorkflowStep(ev, handler) })
+}
+
+func runWorkflowStep(ev *slackevents.FunctionExecutedEvent, handler workflowStepHandler) {
+	outputs, err := handler(ev.Inputs)
+	if err != nil {
+		log.Printf("Workflow step %s failed: %v", ev.Function.CallbackID, err)
+		if err := slackClient.FunctionCompleteError(ev.FunctionExecutionID, err.Error()); err != nil {
+			log.Printf("Error reporting workflow step failure: %v", err)
+		}
+		return
+	}
+	err = slackClient.FunctionCompleteSuccess(ev.FunctionExecutionID, slack.FunctionCompleteSuccessRequestOptionOutput(outputs))
+	if err != nil {
+		log.Printf("Error completing workflow step %s: %v", ev.Function.CallbackID, err)
+	}
+}
+
+// runPostTemplatedMessageStep posts a templated message. Inputs: channel_id,
+// template (rendered with every input as .inputname) and optional
+// thread_ts. Output: message_ts.
+func runPostTemplatedMessageStep(inputs map[string]any) (map[string]string, error) {
+	channel, _ := inputs["channel_id"].(string)
+	tmpl, _ := inputs["template"].(string)
+	if channel == "" || tmpl == "" {
+		return nil, fmt.Errorf("channel_id and template are required")
+	}
+	text, err := renderTemplate("workflow message", tmpl, inputs)
+	if err != nil {
+		return nil, err
+	}
+	threadTS, _ := inputs["thread_ts"].(string)
+	ts, err := sendMessage(outboundMessage{Channel: channel, ThreadTS: threadTS, Text: text, NeedTS: true})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"message_ts": ts}, nil
+}